@@ -0,0 +1,120 @@
+// Package apitest runs the secret server in-process for the integration tests of the services using it.
+// The server has the full handler stack of the public and the admin API against the in-memory storage:
+//
+//	srv := apitest.NewServer(t)
+//	secret := srv.CreateSecret(t, "password", 1, 0)
+//	text, status := srv.GetSecret(t, secret.Hash)
+//
+// The server is closed by the cleanup of the test. The helpers use the root path, so they don't work
+// with httpapi.WithPathPrefix and httpapi.WithBaseURL; use URL with the prefix instead.
+package apitest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	sst "github.com/evsan/secret-server-task"
+	"github.com/evsan/secret-server-task/httpapi"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Server is the running secret server
+type Server struct {
+	// URL is the base URL of the public API
+	URL string
+	// AdminURL is the base URL of the admin API, it requires no authentication
+	AdminURL string
+	// Client is the client of the servers
+	Client *http.Client
+	// Storage is the in-memory storage of the server
+	Storage sst.Storage
+	// Clock is the clock of the storage, moving it forward expires the secrets
+	Clock *sst.ManualClock
+}
+
+// NewServer starts the server with the options of the public and the admin API.
+// The metrics are registered in the registry of the server, so the servers of the parallel tests don't clash
+func NewServer(t testing.TB, opts ...httpapi.Option) *Server {
+	t.Helper()
+	clock := sst.NewManualClock(time.Now())
+	storage := sst.NewMemStorage(sst.WithMemClock(clock))
+	opts = append([]httpapi.Option{httpapi.WithMetrics(prometheus.NewRegistry())}, opts...)
+
+	api := httptest.NewServer(httpapi.New(storage, opts...))
+	t.Cleanup(api.Close)
+	admin := httptest.NewServer(httpapi.NewAdmin(storage, opts...))
+	t.Cleanup(admin.Close)
+	return &Server{
+		URL:      api.URL,
+		AdminURL: admin.URL,
+		Client:   api.Client(),
+		Storage:  storage,
+		Clock:    clock,
+	}
+}
+
+// CreateSecret stores the secret with POST /secret and fails the test unless it is created.
+// expireAfter is in minutes, 0 means the secret never expires
+func (s *Server) CreateSecret(t testing.TB, text string, expireAfterViews, expireAfter int) sst.Secret {
+	t.Helper()
+	return s.CreateSecretWith(t, url.Values{
+		"secret":           {text},
+		"expireAfterViews": {strconv.Itoa(expireAfterViews)},
+		"expireAfter":      {strconv.Itoa(expireAfter)},
+	})
+}
+
+// CreateSecretWith stores the secret with the form fields, e.g. claim, label or recipientToken.
+// It fails the test unless the secret is created
+func (s *Server) CreateSecretWith(t testing.TB, form url.Values) sst.Secret {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodPost, s.URL+"/secret", strings.NewReader(form.Encode()))
+	if err != nil {
+		t.Fatal("creating the secret failed: ", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	var secret sst.Secret
+	if status := s.Do(t, req, &secret); status != http.StatusOK {
+		t.Fatalf("creating the secret failed with %d", status)
+	}
+	return secret
+}
+
+// GetSecret retrieves the secret with GET /secret/{hash}, consuming a view.
+// It returns the text and the status, the text is empty unless the status is 200
+func (s *Server) GetSecret(t testing.TB, hash string) (string, int) {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodGet, s.URL+"/secret/"+url.PathEscape(hash), nil)
+	if err != nil {
+		t.Fatal("retrieving the secret failed: ", err)
+	}
+	var secret sst.Secret
+	status := s.Do(t, req, &secret)
+	return secret.SecretText, status
+}
+
+// Do sends the request with Accept: application/json and decodes the successful response into v if it is not nil.
+// It returns the status and fails the test if the request can't be sent or the response can't be decoded
+func (s *Server) Do(t testing.TB, req *http.Request, v interface{}) int {
+	t.Helper()
+	if req.Header.Get("Accept") == "" {
+		req.Header.Set("Accept", "application/json")
+	}
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		t.Fatal("request failed: ", err)
+	}
+	defer resp.Body.Close()
+	if v != nil && resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		if err = json.NewDecoder(resp.Body).Decode(v); err != nil {
+			t.Fatal("decoding the response failed: ", err)
+		}
+	}
+	return resp.StatusCode
+}
@@ -0,0 +1,52 @@
+package apitest_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/evsan/secret-server-task/apitest"
+)
+
+func TestServer(t *testing.T) {
+	srv := apitest.NewServer(t)
+	consumed := srv.CreateSecret(t, "password", 1, 0)
+	expired := srv.CreateSecret(t, "password", 10, 1)
+
+	text, status := srv.GetSecret(t, consumed.Hash)
+	if status != http.StatusOK || text != "password" {
+		t.Fatalf("expected: %d %s, result: %d %s", http.StatusOK, "password", status, text)
+	}
+	srv.Clock.Add(2 * time.Minute)
+
+	testCases := map[string]string{
+		"consumed": consumed.Hash,
+		"expired":  expired.Hash,
+		"unknown":  "0123456789abcdef0123456789abcdef",
+	}
+	for name, hash := range testCases {
+		t.Run(name, func(t *testing.T) {
+			if _, status := srv.GetSecret(t, hash); status != http.StatusNotFound {
+				t.Fatalf("expected: %d, result: %d", http.StatusNotFound, status)
+			}
+		})
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, srv.AdminURL+"/admin/stats", nil)
+	if status := srv.Do(t, req, nil); status != http.StatusOK {
+		t.Fatalf("expected: %d, result: %d", http.StatusOK, status)
+	}
+}
+
+func TestNewServer_Parallel(t *testing.T) {
+	for _, name := range []string{"a", "b"} {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			srv := apitest.NewServer(t)
+			secret := srv.CreateSecret(t, name, 1, 0)
+			if text, _ := srv.GetSecret(t, secret.Hash); text != name {
+				t.Fatalf("expected: %s, result: %s", name, text)
+			}
+		})
+	}
+}
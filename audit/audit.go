@@ -0,0 +1,368 @@
+// Package audit ships the audit events to the configured sinks.
+//
+// Every sink has its own buffer and worker, so a slow or failing sink doesn't delay the others.
+// The worker writes the events in batches and retries the failed batches with backoff.
+// When the buffer is full the events are dropped, or with the block overflow the caller waits
+// for the space up to BlockTimeout, which slows down the API instead of losing the events.
+// The events never contain the secret text.
+package audit
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Event is the audited action
+type Event struct {
+	At time.Time `json:"at"`
+	// Action is e.g. "store", "get" or "admin.revoke"
+	Action string `json:"action"`
+	Hash   string `json:"hash,omitempty"`
+	Tenant string `json:"tenant,omitempty"`
+	Owner  string `json:"owner,omitempty"`
+	// Target is the owner, the tenant or the template changed by the admin action
+	Target string `json:"target,omitempty"`
+	// Outcome is the result of the retrieval
+	Outcome string `json:"outcome,omitempty"`
+	Detail  string `json:"detail,omitempty"`
+	// RequestID and TraceID identify the request of the action, see httpapi.Trace
+	RequestID string `json:"requestId,omitempty"`
+	TraceID   string `json:"traceId,omitempty"`
+}
+
+// Sink writes the batches of the events. Write is called by the single worker of the sink
+// and must not retain the slice
+type Sink interface {
+	Write(events []Event) error
+	Close() error
+}
+
+// Overflow policies of the sink buffer
+const (
+	OverflowDrop  = "drop"
+	OverflowBlock = "block"
+)
+
+// Sink defaults
+const (
+	defaultBuffer        = 1024
+	defaultBatchSize     = 100
+	defaultFlushInterval = 5 * time.Second
+	defaultAttempts      = 3
+	retryBackoff         = time.Second
+)
+
+var (
+	// ErrUnknownSink is returned for the sink type which is not supported
+	ErrUnknownSink = errors.New("unknown audit sink type, it should be file, syslog, http or s3")
+	// ErrInvalidOverflow is returned for the overflow policy other than drop and block
+	ErrInvalidOverflow = errors.New("invalid audit sink overflow, it should be drop or block")
+)
+
+// Duration is the duration written as a string in the config, e.g. "5s"
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	*d = Duration(v)
+	return err
+}
+
+// SinkConfig configures the sink and its buffering. Only the fields of the Type are used
+type SinkConfig struct {
+	// Type is file, syslog, http or s3
+	Type string `json:"type"`
+	// Name identifies the sink in the stats, the type is used if it is empty
+	Name string `json:"name"`
+	// Buffer is the amount of the events waiting for the sink, 1024 by default
+	Buffer int `json:"buffer"`
+	// Overflow is drop (default) or block
+	Overflow string `json:"overflow"`
+	// BlockTimeout limits the wait of the block overflow, 0 means waiting until the space is available
+	BlockTimeout Duration `json:"blockTimeout"`
+	// BatchSize and FlushInterval control how many events are written at once and how long they may wait
+	BatchSize     int      `json:"batchSize"`
+	FlushInterval Duration `json:"flushInterval"`
+	// Attempts is how many times the batch is written before it is dropped, 3 by default
+	Attempts int `json:"attempts"`
+
+	File   FileConfig   `json:"file"`
+	Syslog SyslogConfig `json:"syslog"`
+	HTTP   HTTPConfig   `json:"http"`
+	S3     S3Config     `json:"s3"`
+}
+
+// LoadConfig reads the JSON list of the sink configs
+func LoadConfig(path string) ([]SinkConfig, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var configs []SinkConfig
+	if err = json.Unmarshal(b, &configs); err != nil {
+		return nil, err
+	}
+	return configs, nil
+}
+
+// NewSink creates the sink of the config type
+func NewSink(c SinkConfig) (Sink, error) {
+	switch c.Type {
+	case "file":
+		return NewFileSink(c.File)
+	case "syslog":
+		return NewSyslogSink(c.Syslog)
+	case "http":
+		return NewHTTPSink(c.HTTP)
+	case "s3":
+		return NewS3Sink(c.S3)
+	}
+	return nil, ErrUnknownSink
+}
+
+// SinkStats are the counters of the sink
+type SinkStats struct {
+	Name string
+	// Written is the amount of the events written by the sink
+	Written uint64
+	// Dropped is the amount of the events dropped because the buffer was full
+	Dropped uint64
+	// Failed is the amount of the events dropped because the sink failed to write them
+	Failed uint64
+}
+
+// Log fans out the events to the sinks
+type Log struct {
+	mu     sync.RWMutex
+	closed bool
+	sinks  []*worker
+}
+
+// Open creates the log writing to the configured sinks
+func Open(configs []SinkConfig) (*Log, error) {
+	l := &Log{}
+	for _, c := range configs {
+		sink, err := NewSink(c)
+		if err == nil {
+			if err = l.Add(sink, c); err != nil {
+				sink.Close()
+			}
+		}
+		if err != nil {
+			l.Close()
+			return nil, fmt.Errorf("audit sink %q: %w", c.Type, err)
+		}
+	}
+	return l, nil
+}
+
+// Add starts shipping the events to the sink with the buffering of the config, its sink fields are ignored
+func (l *Log) Add(sink Sink, c SinkConfig) error {
+	w, err := newWorker(sink, c)
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sinks = append(l.sinks, w)
+	go w.run()
+	return nil
+}
+
+// Record queues the event for all sinks, the time is set if it is zero
+func (l *Log) Record(e Event) {
+	if e.At.IsZero() {
+		e.At = time.Now()
+	}
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if l.closed {
+		return
+	}
+	for _, w := range l.sinks {
+		w.push(e)
+	}
+}
+
+// Stats returns the counters of the sinks in the order they were added
+func (l *Log) Stats() []SinkStats {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	stats := make([]SinkStats, len(l.sinks))
+	for i, w := range l.sinks {
+		stats[i] = w.stats()
+	}
+	return stats
+}
+
+// Close writes the queued events and closes the sinks
+func (l *Log) Close() {
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return
+	}
+	l.closed = true
+	l.mu.Unlock()
+	for _, w := range l.sinks {
+		close(w.events)
+	}
+	for _, w := range l.sinks {
+		<-w.done
+		if err := w.sink.Close(); err != nil {
+			log.Printf("audit sink %s: %v", w.name, err)
+		}
+	}
+}
+
+// worker buffers the events of the sink and writes them in batches
+type worker struct {
+	name          string
+	sink          Sink
+	events        chan Event
+	done          chan struct{}
+	block         bool
+	blockTimeout  time.Duration
+	batchSize     int
+	flushInterval time.Duration
+	attempts      int
+
+	written, dropped, failed uint64
+}
+
+func newWorker(sink Sink, c SinkConfig) (*worker, error) {
+	w := &worker{
+		name:          c.Name,
+		sink:          sink,
+		done:          make(chan struct{}),
+		blockTimeout:  time.Duration(c.BlockTimeout),
+		batchSize:     c.BatchSize,
+		flushInterval: time.Duration(c.FlushInterval),
+		attempts:      c.Attempts,
+	}
+	switch c.Overflow {
+	case "", OverflowDrop:
+	case OverflowBlock:
+		w.block = true
+	default:
+		return nil, ErrInvalidOverflow
+	}
+	if w.name == "" {
+		w.name = c.Type
+	}
+	buffer := c.Buffer
+	if buffer <= 0 {
+		buffer = defaultBuffer
+	}
+	w.events = make(chan Event, buffer)
+	if w.batchSize <= 0 {
+		w.batchSize = defaultBatchSize
+	}
+	if w.flushInterval <= 0 {
+		w.flushInterval = defaultFlushInterval
+	}
+	if w.attempts <= 0 {
+		w.attempts = defaultAttempts
+	}
+	return w, nil
+}
+
+// push queues the event according to the overflow policy
+func (w *worker) push(e Event) {
+	select {
+	case w.events <- e:
+		return
+	default:
+	}
+	if w.block {
+		if w.blockTimeout <= 0 {
+			w.events <- e
+			return
+		}
+		timer := time.NewTimer(w.blockTimeout)
+		defer timer.Stop()
+		select {
+		case w.events <- e:
+			return
+		case <-timer.C:
+		}
+	}
+	atomic.AddUint64(&w.dropped, 1)
+}
+
+func (w *worker) run() {
+	defer close(w.done)
+	ticker := time.NewTicker(w.flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]Event, 0, w.batchSize)
+	flush := func() {
+		if len(batch) > 0 {
+			w.write(batch)
+			batch = batch[:0]
+		}
+	}
+	for {
+		select {
+		case e, ok := <-w.events:
+			if !ok {
+				flush()
+				return
+			}
+			if batch = append(batch, e); len(batch) >= w.batchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+// write retries the batch with the exponential backoff. The buffer fills up meanwhile,
+// so the overflow policy applies to the failing sink
+func (w *worker) write(batch []Event) {
+	var err error
+	for attempt := 0; attempt < w.attempts; attempt++ {
+		if attempt > 0 {
+			time.Sleep(retryBackoff << (attempt - 1))
+		}
+		if err = w.sink.Write(batch); err == nil {
+			atomic.AddUint64(&w.written, uint64(len(batch)))
+			return
+		}
+	}
+	atomic.AddUint64(&w.failed, uint64(len(batch)))
+	log.Printf("audit sink %s: %d events are lost: %v", w.name, len(batch), err)
+}
+
+func (w *worker) stats() SinkStats {
+	return SinkStats{
+		Name:    w.name,
+		Written: atomic.LoadUint64(&w.written),
+		Dropped: atomic.LoadUint64(&w.dropped),
+		Failed:  atomic.LoadUint64(&w.failed),
+	}
+}
+
+// jsonLines encodes the events as the JSON lines
+func jsonLines(events []Event) ([]byte, error) {
+	var b []byte
+	for _, e := range events {
+		line, err := json.Marshal(e)
+		if err != nil {
+			return nil, err
+		}
+		b = append(append(b, line...), '\n')
+	}
+	return b, nil
+}
@@ -0,0 +1,256 @@
+package audit_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/evsan/secret-server-task/audit"
+)
+
+// memSink keeps the written events, it blocks while the gate is not nil and open
+type memSink struct {
+	mu     sync.Mutex
+	events []audit.Event
+	gate   chan struct{}
+	fail   error
+}
+
+func (s *memSink) Write(events []audit.Event) error {
+	if s.gate != nil {
+		<-s.gate
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.fail != nil {
+		return s.fail
+	}
+	s.events = append(s.events, events...)
+	return nil
+}
+
+func (s *memSink) Close() error {
+	return nil
+}
+
+func (s *memSink) written() []audit.Event {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]audit.Event(nil), s.events...)
+}
+
+func TestLog_Fanout(t *testing.T) {
+	first, second := &memSink{}, &memSink{}
+	l := &audit.Log{}
+	if err := l.Add(first, audit.SinkConfig{Name: "first"}); err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+	if err := l.Add(second, audit.SinkConfig{Name: "second", BatchSize: 1}); err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+	for _, action := range []string{"store", "get", "admin.revoke"} {
+		l.Record(audit.Event{Action: action, Hash: "abc"})
+	}
+	l.Close()
+	l.Record(audit.Event{Action: "get"})
+
+	for name, sink := range map[string]*memSink{"first": first, "second": second} {
+		events := sink.written()
+		if len(events) != 3 {
+			t.Fatalf("%s: expected: %d, result: %d", name, 3, len(events))
+		}
+		if events[2].Action != "admin.revoke" || events[2].At.IsZero() {
+			t.Fatalf("%s: unexpected event: %+v", name, events[2])
+		}
+	}
+	for _, stats := range l.Stats() {
+		if stats.Written != 3 || stats.Dropped != 0 {
+			t.Fatalf("%s: unexpected stats: %+v", stats.Name, stats)
+		}
+	}
+}
+
+func TestLog_Overflow(t *testing.T) {
+	cases := map[string]struct {
+		config  audit.SinkConfig
+		dropped bool
+	}{
+		"drop":  {audit.SinkConfig{Buffer: 1, BatchSize: 1}, true},
+		"block": {audit.SinkConfig{Buffer: 1, BatchSize: 1, Overflow: audit.OverflowBlock}, false},
+		"block timeout": {audit.SinkConfig{Buffer: 1, BatchSize: 1, Overflow: audit.OverflowBlock,
+			BlockTimeout: audit.Duration(time.Millisecond)}, true},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			stuck, other := &memSink{gate: make(chan struct{})}, &memSink{}
+			l := &audit.Log{}
+			if err := l.Add(stuck, c.config); err != nil {
+				t.Fatal("error is not expected: ", err)
+			}
+			if err := l.Add(other, audit.SinkConfig{Buffer: 10}); err != nil {
+				t.Fatal("error is not expected: ", err)
+			}
+
+			recorded := make(chan struct{})
+			go func() {
+				defer close(recorded)
+				for i := 0; i < 5; i++ {
+					l.Record(audit.Event{Action: "get"})
+				}
+			}()
+			select {
+			case <-recorded:
+				if !c.dropped {
+					t.Fatal("expected the caller to wait for the blocked sink")
+				}
+			case <-time.After(100 * time.Millisecond):
+				if c.dropped {
+					t.Fatal("the caller is not expected to wait for the blocked sink")
+				}
+			}
+			close(stuck.gate)
+			<-recorded
+			l.Close()
+
+			stats := l.Stats()
+			if dropped := stats[0].Dropped > 0; dropped != c.dropped {
+				t.Fatalf("expected: %v, result: %+v", c.dropped, stats[0])
+			}
+			if stats[0].Written+stats[0].Dropped != 5 {
+				t.Fatalf("expected all events to be accounted, result: %+v", stats[0])
+			}
+			if len(other.written()) != 5 {
+				t.Fatalf("expected: %d, result: %d", 5, len(other.written()))
+			}
+		})
+	}
+}
+
+func TestLog_InvalidConfig(t *testing.T) {
+	cases := map[string]struct {
+		config audit.SinkConfig
+		err    error
+	}{
+		"unknown type": {audit.SinkConfig{Type: "kafka"}, audit.ErrUnknownSink},
+		"overflow":     {audit.SinkConfig{Type: "file", Overflow: "wait", File: audit.FileConfig{Path: filepath.Join(t.TempDir(), "audit.log")}}, audit.ErrInvalidOverflow},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			if _, err := audit.Open([]audit.SinkConfig{c.config}); !errors.Is(err, c.err) {
+				t.Fatalf("expected: %v, result: %v", c.err, err)
+			}
+		})
+	}
+}
+
+func TestFileSink_Rotate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	sink, err := audit.NewFileSink(audit.FileConfig{Path: path, MaxSize: 200, MaxBackups: 2})
+	if err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+	at := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 10; i++ {
+		if err = sink.Write([]audit.Event{{At: at, Action: "store", Hash: strings.Repeat("a", 32)}}); err != nil {
+			t.Fatal("error is not expected: ", err)
+		}
+	}
+	if err = sink.Close(); err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+
+	for _, name := range []string{path, path + ".1", path + ".2"} {
+		f, err := os.Open(name)
+		if err != nil {
+			t.Fatal("error is not expected: ", err)
+		}
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			var e audit.Event
+			if err = json.Unmarshal(scanner.Bytes(), &e); err != nil || e.Action != "store" {
+				t.Fatalf("unexpected line %q: %v", scanner.Text(), err)
+			}
+		}
+		f.Close()
+		if info, _ := os.Stat(name); info.Size() > 200 {
+			t.Fatalf("expected %s to be rotated at the limit, result: %d bytes", name, info.Size())
+		}
+	}
+	if _, err = os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Fatal("expected only 2 backups to be kept")
+	}
+}
+
+func TestHTTPSink(t *testing.T) {
+	var status = http.StatusOK
+	var received []audit.Event
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			t.Errorf("expected the configured header, result: %q", r.Header.Get("Authorization"))
+		}
+		var batch []audit.Event
+		if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+			t.Error("error is not expected: ", err)
+		}
+		received = append(received, batch...)
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	sink, err := audit.NewHTTPSink(audit.HTTPConfig{URL: srv.URL, Headers: map[string]string{"Authorization": "Bearer token"}})
+	if err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+	if err = sink.Write([]audit.Event{{Action: "store"}, {Action: "get"}}); err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+	if len(received) != 2 || received[1].Action != "get" {
+		t.Fatalf("unexpected batch: %+v", received)
+	}
+
+	status = http.StatusServiceUnavailable
+	if err = sink.Write([]audit.Event{{Action: "store"}}); err == nil {
+		t.Fatal("expected the error of the failed collector")
+	}
+}
+
+func TestS3Sink(t *testing.T) {
+	var path, auth, body string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		if r.Method != http.MethodPut {
+			t.Errorf("expected: %s, result: %s", http.MethodPut, r.Method)
+		}
+		path, auth, body = r.URL.Path, r.Header.Get("Authorization"), string(b)
+	}))
+	defer srv.Close()
+
+	sink, err := audit.NewS3Sink(audit.S3Config{Bucket: "logs", Region: "eu-west-1", Prefix: "audit/", Endpoint: srv.URL,
+		AccessKeyID: "AKID", SecretAccessKey: "secret"})
+	if err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+	if err = sink.Write([]audit.Event{{Action: "store"}, {Action: "get"}}); err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+
+	if !strings.HasPrefix(path, "/logs/audit/") || !strings.HasSuffix(path, ".jsonl") {
+		t.Fatalf("unexpected object path: %s", path)
+	}
+	if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=AKID/") ||
+		!strings.Contains(auth, "/eu-west-1/s3/aws4_request, SignedHeaders=host;x-amz-content-sha256;x-amz-date, Signature=") {
+		t.Fatalf("unexpected authorization: %s", auth)
+	}
+	if strings.Count(body, "\n") != 2 {
+		t.Fatalf("expected 2 JSON lines, result: %q", body)
+	}
+}
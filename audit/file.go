@@ -0,0 +1,97 @@
+package audit
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// File sink defaults
+const (
+	defaultMaxFileSize = 100 << 20
+	defaultMaxBackups  = 5
+)
+
+// FileConfig configures the rotating local file
+type FileConfig struct {
+	Path string `json:"path"`
+	// MaxSize is the size in bytes the file is rotated at, 100MiB by default
+	MaxSize int64 `json:"maxSize"`
+	// MaxBackups is how many rotated files are kept as path.1 ... path.N, 5 by default
+	MaxBackups int `json:"maxBackups"`
+}
+
+// FileSink appends the events to the file as the JSON lines and rotates it at the size limit
+type FileSink struct {
+	config FileConfig
+	f      *os.File
+	size   int64
+}
+
+// NewFileSink opens the file for appending
+func NewFileSink(c FileConfig) (*FileSink, error) {
+	if c.Path == "" {
+		return nil, errors.New("file path is required")
+	}
+	if c.MaxSize <= 0 {
+		c.MaxSize = defaultMaxFileSize
+	}
+	if c.MaxBackups <= 0 {
+		c.MaxBackups = defaultMaxBackups
+	}
+	s := &FileSink{config: c}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *FileSink) open() error {
+	f, err := os.OpenFile(s.config.Path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	s.f, s.size = f, info.Size()
+	return nil
+}
+
+func (s *FileSink) Write(events []Event) error {
+	b, err := jsonLines(events)
+	if err != nil {
+		return err
+	}
+	if s.size > 0 && s.size+int64(len(b)) > s.config.MaxSize {
+		if err = s.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := s.f.Write(b)
+	s.size += int64(n)
+	return err
+}
+
+// rotate shifts the backups, the oldest one is removed
+func (s *FileSink) rotate() error {
+	if err := s.f.Close(); err != nil {
+		return err
+	}
+	path := s.config.Path
+	for i := s.config.MaxBackups - 1; i > 0; i-- {
+		if err := os.Rename(fmt.Sprintf("%s.%d", path, i), fmt.Sprintf("%s.%d", path, i+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if err := os.Rename(path, path+".1"); err != nil {
+		return err
+	}
+	return s.open()
+}
+
+func (s *FileSink) Close() error {
+	return s.f.Close()
+}
@@ -0,0 +1,68 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// HTTPConfig configures the collector the batches are posted to
+type HTTPConfig struct {
+	URL string `json:"url"`
+	// Headers are added to the requests, e.g. Authorization
+	Headers map[string]string `json:"headers"`
+	// Timeout of the request, 10s by default
+	Timeout Duration `json:"timeout"`
+}
+
+// HTTPSink posts every batch as the JSON array. The batch is retried unless the collector responds with 2xx
+type HTTPSink struct {
+	config HTTPConfig
+	client *http.Client
+}
+
+// NewHTTPSink creates the sink posting to the URL
+func NewHTTPSink(c HTTPConfig) (*HTTPSink, error) {
+	if c.URL == "" {
+		return nil, errors.New("collector url is required")
+	}
+	timeout := time.Duration(c.Timeout)
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	return &HTTPSink{config: c, client: &http.Client{Timeout: timeout}}, nil
+}
+
+func (s *HTTPSink) Write(events []Event) error {
+	b, err := json.Marshal(events)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, s.config.URL, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range s.config.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("collector responded with %s", resp.Status)
+	}
+	return nil
+}
+
+func (s *HTTPSink) Close() error {
+	s.client.CloseIdleConnections()
+	return nil
+}
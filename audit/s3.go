@@ -0,0 +1,144 @@
+package audit
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// S3Config configures the bucket the batches are uploaded to. The credentials are taken from
+// AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN if they are empty
+type S3Config struct {
+	Bucket string `json:"bucket"`
+	Region string `json:"region"`
+	// Prefix is prepended to the object keys, e.g. audit/
+	Prefix string `json:"prefix"`
+	// Endpoint is the S3 compatible service, https://s3.{region}.amazonaws.com by default.
+	// The bucket is addressed in the path
+	Endpoint        string `json:"endpoint"`
+	AccessKeyID     string `json:"accessKeyId"`
+	SecretAccessKey string `json:"secretAccessKey"`
+	SessionToken    string `json:"sessionToken"`
+}
+
+// S3Sink uploads every batch as the JSON lines object {prefix}YYYY/MM/DD/{time}-{seq}.jsonl
+type S3Sink struct {
+	config   S3Config
+	endpoint *url.URL
+	client   *http.Client
+	seq      uint64
+	now      func() time.Time
+}
+
+// NewS3Sink creates the sink uploading to the bucket
+func NewS3Sink(c S3Config) (*S3Sink, error) {
+	if c.Bucket == "" || c.Region == "" {
+		return nil, errors.New("s3 bucket and region are required")
+	}
+	if c.AccessKeyID == "" {
+		c.AccessKeyID, c.SecretAccessKey, c.SessionToken = os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY"), os.Getenv("AWS_SESSION_TOKEN")
+	}
+	if c.AccessKeyID == "" || c.SecretAccessKey == "" {
+		return nil, errors.New("s3 credentials are required")
+	}
+	if c.Endpoint == "" {
+		c.Endpoint = "https://s3." + c.Region + ".amazonaws.com"
+	}
+	endpoint, err := url.Parse(c.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	return &S3Sink{config: c, endpoint: endpoint, client: &http.Client{Timeout: 30 * time.Second}, now: time.Now}, nil
+}
+
+func (s *S3Sink) Write(events []Event) error {
+	body, err := jsonLines(events)
+	if err != nil {
+		return err
+	}
+	now := s.now().UTC()
+	key := fmt.Sprintf("%s%s-%d.jsonl", s.config.Prefix, now.Format("2006/01/02/150405.000000000"), atomic.AddUint64(&s.seq, 1))
+	u := *s.endpoint
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + s.config.Bucket + "/" + key
+
+	req, err := http.NewRequest(http.MethodPut, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	s.sign(req, body, now)
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("s3 responded with %s", resp.Status)
+	}
+	return nil
+}
+
+// sign adds the AWS Signature Version 4 of the request
+func (s *S3Sink) sign(req *http.Request, body []byte, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	payloadHash := sha256Hex(body)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := []string{"host:" + req.URL.Host, "x-amz-content-sha256:" + payloadHash, "x-amz-date:" + amzDate}
+	if s.config.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.config.SessionToken)
+		headers = append(headers, "x-amz-security-token:"+s.config.SessionToken)
+	}
+	names := make([]string, len(headers))
+	for i, h := range headers {
+		names[i], _, _ = strings.Cut(h, ":")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonical := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		strings.Join(headers, "\n") + "\n",
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := amzDate[:8] + "/" + s.config.Region + "/s3/aws4_request"
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonical))
+
+	key := []byte("AWS4" + s.config.SecretAccessKey)
+	for _, part := range []string{amzDate[:8], s.config.Region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, toSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.config.AccessKeyID, scope, signedHeaders, signature))
+}
+
+func (s *S3Sink) Close() error {
+	s.client.CloseIdleConnections()
+	return nil
+}
+
+func sha256Hex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
@@ -0,0 +1,51 @@
+//go:build !windows && !plan9
+
+package audit
+
+import (
+	"encoding/json"
+	"log/syslog"
+)
+
+// SyslogConfig configures the syslog connection. The local syslog is used if Address is empty
+type SyslogConfig struct {
+	// Network is e.g. udp or tcp
+	Network string `json:"network"`
+	Address string `json:"address"`
+	// Tag is the name of the program in the messages, secret-server by default
+	Tag string `json:"tag"`
+}
+
+// SyslogSink sends every event as the JSON message with the auth facility
+type SyslogSink struct {
+	w *syslog.Writer
+}
+
+// NewSyslogSink connects to the syslog
+func NewSyslogSink(c SyslogConfig) (*SyslogSink, error) {
+	if c.Tag == "" {
+		c.Tag = "secret-server"
+	}
+	w, err := syslog.Dial(c.Network, c.Address, syslog.LOG_INFO|syslog.LOG_AUTH, c.Tag)
+	if err != nil {
+		return nil, err
+	}
+	return &SyslogSink{w: w}, nil
+}
+
+func (s *SyslogSink) Write(events []Event) error {
+	for _, e := range events {
+		b, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		if err = s.w.Info(string(b)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *SyslogSink) Close() error {
+	return s.w.Close()
+}
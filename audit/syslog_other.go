@@ -0,0 +1,17 @@
+//go:build windows || plan9
+
+package audit
+
+import "errors"
+
+// SyslogConfig configures the syslog connection, syslog is not available on this platform
+type SyslogConfig struct {
+	Network string `json:"network"`
+	Address string `json:"address"`
+	Tag     string `json:"tag"`
+}
+
+// NewSyslogSink always fails, log/syslog is not implemented on this platform
+func NewSyslogSink(SyslogConfig) (Sink, error) {
+	return nil, errors.New("syslog is not supported on this platform")
+}
@@ -0,0 +1,169 @@
+// Package backup implements the encrypted backup format of the secret storages.
+//
+// The backup is an age (https://age-encryption.org) encrypted stream of JSON lines:
+// the header followed by one line per secret. It is written and read record by record,
+// so the storage is never loaded into memory as a whole.
+package backup
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"filippo.io/age"
+	sst "github.com/evsan/secret-server-task"
+)
+
+const format = "secret-server-backup"
+
+// header is the first line of the backup
+type header struct {
+	Format    string    `json:"format"`
+	Version   int       `json:"version"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// record keeps the attributes which are hidden in the API responses
+type record struct {
+	sst.Secret
+	Owner  string `json:"owner,omitempty"`
+	Tenant string `json:"tenant,omitempty"`
+	// WebhookURL is empty in the backups of the older versions
+	WebhookURL    string     `json:"webhookUrl,omitempty"`
+	Labels        sst.Labels `json:"labels,omitempty"`
+	ClaimRequired bool       `json:"claimRequired,omitempty"`
+	HomeRegion    string     `json:"homeRegion,omitempty"`
+	Recipient     string     `json:"recipient,omitempty"`
+	// CheckInInterval, ReleaseURL and Released keep the dead man's switch
+	CheckInInterval time.Duration `json:"checkInInterval,omitempty"`
+	ReleaseURL      string        `json:"releaseUrl,omitempty"`
+	Released        bool          `json:"released,omitempty"`
+	// Views keeps the viewed secrets out of the unviewed expiry notifications after the restore
+	Views int `json:"views,omitempty"`
+}
+
+// ImportResult is the report of the backup restoring
+type ImportResult struct {
+	Imported  int      `json:"imported" xml:"imported"`
+	Expired   int      `json:"expired" xml:"expired"`
+	Conflicts []string `json:"conflicts" xml:"conflicts>hash"`
+}
+
+// Write streams the encrypted backup of the available secrets to w.
+// Returns the amount of exported secrets
+func Write(w io.Writer, exporter sst.Exporter, recipients ...age.Recipient) (int, error) {
+	encrypted, err := age.Encrypt(w, recipients...)
+	if err != nil {
+		return 0, err
+	}
+
+	enc := json.NewEncoder(encrypted)
+	err = enc.Encode(header{Format: format, Version: 1, CreatedAt: time.Now()})
+	if err != nil {
+		return 0, err
+	}
+
+	var count int
+	err = exporter.Export(func(secret sst.Secret) error {
+		count++
+		return enc.Encode(record{Secret: secret, Owner: secret.Owner, Tenant: secret.Tenant, WebhookURL: secret.WebhookURL, Labels: secret.Labels, ClaimRequired: secret.ClaimRequired, HomeRegion: secret.HomeRegion, Recipient: secret.Recipient,
+			CheckInInterval: secret.CheckInInterval, ReleaseURL: secret.ReleaseURL, Released: secret.Released, Views: secret.Views})
+	})
+	if err != nil {
+		return count, err
+	}
+	return count, encrypted.Close()
+}
+
+// Read decrypts the backup and imports the secrets one by one.
+// Already expired secrets are skipped, existing hashes are reported as conflicts and never overwritten.
+func Read(r io.Reader, importer sst.Importer, identities ...age.Identity) (ImportResult, error) {
+	result := ImportResult{Conflicts: []string{}}
+
+	decrypted, err := age.Decrypt(r, identities...)
+	if err != nil {
+		return result, err
+	}
+
+	dec := json.NewDecoder(decrypted)
+	var h header
+	if err = dec.Decode(&h); err != nil {
+		return result, err
+	}
+	if h.Format != format || h.Version != 1 {
+		return result, fmt.Errorf("unsupported backup format %q version %d", h.Format, h.Version)
+	}
+
+	for {
+		var rec record
+		err = dec.Decode(&rec)
+		if err == io.EOF {
+			return result, nil
+		}
+		if err != nil {
+			return result, err
+		}
+		secret := rec.Secret
+		secret.Owner = rec.Owner
+		secret.Tenant = rec.Tenant
+		secret.WebhookURL = rec.WebhookURL
+		secret.Labels = rec.Labels
+		secret.ClaimRequired = rec.ClaimRequired
+		secret.HomeRegion = rec.HomeRegion
+		secret.Recipient = rec.Recipient
+		secret.CheckInInterval = rec.CheckInInterval
+		secret.ReleaseURL = rec.ReleaseURL
+		secret.Released = rec.Released
+		secret.Views = rec.Views
+
+		if secret.IsExpiredAt(time.Now()) {
+			result.Expired++
+			continue
+		}
+		switch err = importer.Import(secret); err {
+		case nil:
+			result.Imported++
+		case sst.ErrSecretExists:
+			result.Conflicts = append(result.Conflicts, secret.Hash)
+		default:
+			return result, err
+		}
+	}
+}
+
+// ParseRecipients parses the comma separated list of age public keys (age1...)
+func ParseRecipients(value string) ([]age.Recipient, error) {
+	var result []age.Recipient
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		r, err := age.ParseX25519Recipient(item)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, r)
+	}
+	if len(result) == 0 {
+		return nil, errors.New("at least one age recipient is required")
+	}
+	return result, nil
+}
+
+// ReadIdentities reads the age identity file (AGE-SECRET-KEY-...)
+func ReadIdentities(path string) ([]age.Identity, error) {
+	if path == "" {
+		return nil, errors.New("at least one age identity is required")
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return age.ParseIdentities(f)
+}
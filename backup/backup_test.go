@@ -0,0 +1,61 @@
+package backup_test
+
+import (
+	"bytes"
+	"testing"
+
+	"filippo.io/age"
+	sst "github.com/evsan/secret-server-task"
+	"github.com/evsan/secret-server-task/backup"
+)
+
+func TestWriteRead(t *testing.T) {
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+	source := sst.NewMemStorage()
+	viewed, err := source.Store("viewed secret", 3, 10, sst.WithOwner("alice"), sst.WithLabels(sst.Labels{"team": "ops"}))
+	if err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+	if _, err = source.Get(viewed.Hash); err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+	unviewed, _ := source.Store("unviewed secret", 1, 10)
+
+	var buf bytes.Buffer
+	if n, err := backup.Write(&buf, source.(sst.Exporter), identity.Recipient()); err != nil || n != 2 {
+		t.Fatalf("expected: 2, result: %d %v", n, err)
+	}
+	target := sst.NewMemStorage()
+	result, err := backup.Read(&buf, target.(sst.Importer), identity)
+	if err != nil || result.Imported != 2 {
+		t.Fatalf("expected: 2, result: %v %v", result, err)
+	}
+
+	peeker, _ := sst.PeekerOf(target)
+	testCases := map[string]struct {
+		hash      string
+		text      string
+		views     int
+		remaining int
+	}{
+		"viewed":   {viewed.Hash, "viewed secret", 1, 2},
+		"unviewed": {unviewed.Hash, "unviewed secret", 0, 1},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			s, err := peeker.Peek(tc.hash)
+			if err != nil {
+				t.Fatal("error is not expected: ", err)
+			}
+			if s.SecretText != tc.text || s.Views != tc.views || s.RemainingViews != tc.remaining {
+				t.Fatalf("expected: %s %d %d, result: %s %d %d", tc.text, tc.views, tc.remaining, s.SecretText, s.Views, s.RemainingViews)
+			}
+		})
+	}
+	if s, _ := peeker.Peek(viewed.Hash); s.Owner != "alice" || s.Labels["team"] != "ops" {
+		t.Fatalf("hidden attributes should be restored: %v %v", s.Owner, s.Labels)
+	}
+}
@@ -0,0 +1,119 @@
+// Package chaos injects latency and failures into the HTTP API and the storage.
+// It is meant for the staging instances only, to verify the retries of the clients and the alerting.
+// The zero Config injects nothing.
+package chaos
+
+import (
+	"errors"
+	"math/rand"
+	"net/http"
+	"time"
+
+	sst "github.com/evsan/secret-server-task"
+)
+
+// ErrInjected is returned by the storage instead of the real result
+var ErrInjected = errors.New("chaos: injected failure")
+
+// Config describes the injected faults. Rates are probabilities from 0 to 1
+type Config struct {
+	// Latency is the maximum random delay added to every call
+	Latency time.Duration
+	// ErrorRate is the probability of the failure before the call is made
+	ErrorRate float64
+	// PartialRate is the probability of the failure after the call is made, e.g. the secret is stored
+	// but the client gets the error
+	PartialRate float64
+}
+
+// Enabled returns true if any fault is configured
+func (c Config) Enabled() bool {
+	return c.Latency > 0 || c.ErrorRate > 0 || c.PartialRate > 0
+}
+
+func (c Config) delay() {
+	if c.Latency > 0 {
+		time.Sleep(time.Duration(rand.Int63n(int64(c.Latency))))
+	}
+}
+
+func happens(rate float64) bool {
+	return rate > 0 && rand.Float64() < rate
+}
+
+// Middleware injects the faults into the handler.
+// Failed requests get 503 Service Unavailable, so the clients are expected to retry them
+func Middleware(c Config) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if !c.Enabled() {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			c.delay()
+			if happens(c.ErrorRate) {
+				http.Error(w, "Injected failure", http.StatusServiceUnavailable)
+				return
+			}
+			if happens(c.PartialRate) {
+				next.ServeHTTP(discardWriter{header: http.Header{}}, r)
+				http.Error(w, "Injected failure", http.StatusServiceUnavailable)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// discardWriter drops the response of the handler which is replaced by the injected failure
+type discardWriter struct {
+	header http.Header
+}
+
+func (w discardWriter) Header() http.Header         { return w.header }
+func (w discardWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (w discardWriter) WriteHeader(int)             {}
+
+// storage injects the faults into the calls of the wrapped storage
+type storage struct {
+	sst.Storage
+	config Config
+}
+
+// NewStorage wraps the storage with the fault injection, the storage is returned as is if the config is empty
+func NewStorage(st sst.Storage, c Config) sst.Storage {
+	if !c.Enabled() {
+		return st
+	}
+	return &storage{Storage: st, config: c}
+}
+
+// Unwrap returns the wrapped storage
+func (s *storage) Unwrap() sst.Storage {
+	return s.Storage
+}
+
+// Store
+func (s *storage) Store(secret string, expireAfterViews, expireAfter int, opts ...sst.SecretOption) (sst.Secret, error) {
+	s.config.delay()
+	if happens(s.config.ErrorRate) {
+		return sst.Secret{}, ErrInjected
+	}
+	result, err := s.Storage.Store(secret, expireAfterViews, expireAfter, opts...)
+	if err == nil && happens(s.config.PartialRate) {
+		return sst.Secret{}, ErrInjected
+	}
+	return result, err
+}
+
+// Get. The partial failure consumes the view
+func (s *storage) Get(key string) (sst.Secret, error) {
+	s.config.delay()
+	if happens(s.config.ErrorRate) {
+		return sst.Secret{}, ErrInjected
+	}
+	result, err := s.Storage.Get(key)
+	if err == nil && happens(s.config.PartialRate) {
+		return sst.Secret{}, ErrInjected
+	}
+	return result, err
+}
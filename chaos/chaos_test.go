@@ -0,0 +1,60 @@
+package chaos_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	sst "github.com/evsan/secret-server-task"
+	"github.com/evsan/secret-server-task/chaos"
+)
+
+const (
+	secretText     = "secret"
+	remainingViews = 100
+)
+
+func TestNewStorage(t *testing.T) {
+	backend := sst.NewMemStorage()
+	if chaos.NewStorage(backend, chaos.Config{}) != backend {
+		t.Fatal("empty config should not wrap the storage")
+	}
+
+	testCases := map[string]struct {
+		Config chaos.Config
+		Stored bool
+	}{
+		"error":   {Config: chaos.Config{ErrorRate: 1}, Stored: false},
+		"partial": {Config: chaos.Config{PartialRate: 1}, Stored: true},
+	}
+	for name, tst := range testCases {
+		t.Run(name, func(t *testing.T) {
+			backend := sst.NewMemStorage()
+			st := chaos.NewStorage(backend, tst.Config)
+			if _, err := st.Store(secretText, remainingViews, 0); err != chaos.ErrInjected {
+				t.Fatalf("expected: %s, result: %v", chaos.ErrInjected, err)
+			}
+			stats, err := backend.(sst.StatsStorage).Stats()
+			if err != nil {
+				t.Fatal("error is not expected: ", err)
+			}
+			if (stats.Total == 1) != tst.Stored {
+				t.Fatalf("expected stored: %v, result: %d records", tst.Stored, stats.Total)
+			}
+		})
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	var calls int
+	h := chaos.Middleware(chaos.Config{PartialRate: 1})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		_, _ = w.Write([]byte("ok"))
+	}))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusServiceUnavailable || calls != 1 {
+		t.Fatalf("expected: %d, result: %d, calls: %d", http.StatusServiceUnavailable, w.Code, calls)
+	}
+}
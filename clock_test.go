@@ -0,0 +1,134 @@
+package secret_server_task_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	sst "github.com/evsan/secret-server-task"
+)
+
+func TestMemStorage_Clock(t *testing.T) {
+	start := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+	clock := sst.NewManualClock(start)
+	storage := sst.NewMemStorage(sst.WithMemClock(clock))
+
+	secret, err := storage.Store(secretText, remainingViews, expiresDelta)
+	if err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+	if !secret.CreatedAt.Equal(start) {
+		t.Fatalf("expected: %s, result: %s", start, secret.CreatedAt)
+	}
+
+	clock.Add(expiresDelta*time.Minute - time.Second)
+	if _, err = storage.Get(secret.Hash); err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+
+	clock.Add(time.Second)
+	if _, err = storage.Get(secret.Hash); !errors.Is(err, sst.ErrSecretNotAvailable) {
+		t.Fatalf("expected: %s, result: %v", sst.ErrSecretNotAvailable, err)
+	}
+}
+
+func TestSecret_IsAvailableAt(t *testing.T) {
+	clock := sst.NewManualClock(time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC))
+	s, err := sst.NewSecretAt(clock, secretText, remainingViews, expiresDelta)
+	if err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+
+	testCases := map[string]struct {
+		At       time.Time
+		Expected bool
+	}{
+		"created":    {At: s.CreatedAt, Expected: true},
+		"before TTL": {At: s.ExpiresAt.Add(-time.Nanosecond), Expected: true},
+		"at TTL":     {At: s.ExpiresAt, Expected: false},
+	}
+	for name, tst := range testCases {
+		t.Run(name, func(t *testing.T) {
+			if s.IsAvailableAt(tst.At) != tst.Expected {
+				t.Fail()
+			}
+		})
+	}
+}
+
+func TestMemStorage_NotBefore(t *testing.T) {
+	start := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+	clock := sst.NewManualClock(start)
+	storage := sst.NewMemStorage(sst.WithMemClock(clock))
+
+	if _, err := storage.Store(secretText, remainingViews, 1, sst.WithNotBefore(start.Add(time.Hour))); err != sst.ErrInvalidNotBefore {
+		t.Fatalf("expected: %s, result: %v", sst.ErrInvalidNotBefore, err)
+	}
+
+	secret, err := storage.Store(secretText, remainingViews, expiresDelta, sst.WithNotBefore(start.Add(time.Minute)))
+	if err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+	if _, err = storage.Get(secret.Hash); err != sst.ErrSecretNotYetAvailable {
+		t.Fatalf("expected: %s, result: %v", sst.ErrSecretNotYetAvailable, err)
+	}
+	if removed, _ := storage.(sst.Purger).PurgeExpired(); removed != 0 {
+		t.Fatalf("expected: %d, result: %d", 0, removed)
+	}
+
+	clock.Add(time.Minute)
+	result, err := storage.Get(secret.Hash)
+	if err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+	if result.RemainingViews != remainingViews-1 {
+		t.Fatalf("expected: %d, result: %d", remainingViews-1, result.RemainingViews)
+	}
+}
+
+func TestMemStorage_ExpiryHook(t *testing.T) {
+	clock := sst.NewManualClock(time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC))
+	expired := map[string]sst.Secret{}
+	storage := sst.NewMemStorage(sst.WithMemClock(clock), sst.WithMemExpiryHook(func(s sst.Secret) {
+		expired[s.Hash] = s
+	}))
+
+	unviewed, err := storage.Store(secretText, remainingViews, expiresDelta)
+	if err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+	viewed, err := storage.Store(secretText, remainingViews, expiresDelta)
+	if err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+	if _, err = storage.Get(viewed.Hash); err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+
+	clock.Add(expiresDelta * time.Minute)
+	if _, err = storage.Get(viewed.Hash); !errors.Is(err, sst.ErrSecretNotAvailable) {
+		t.Fatalf("expected: %s, result: %v", sst.ErrSecretNotAvailable, err)
+	}
+	if _, err = storage.(sst.Purger).PurgeExpired(); err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+
+	testCases := map[string]struct {
+		Hash  string
+		Views int
+	}{
+		"unviewed": {Hash: unviewed.Hash, Views: 0},
+		"viewed":   {Hash: viewed.Hash, Views: 1},
+	}
+	for name, tst := range testCases {
+		t.Run(name, func(t *testing.T) {
+			s, ok := expired[tst.Hash]
+			if !ok {
+				t.Fatal("expiry hook is not called")
+			}
+			if s.Views != tst.Views || s.SecretText != "" {
+				t.Fatalf("expected: %d views and no text, result: %d views, %q", tst.Views, s.Views, s.SecretText)
+			}
+		})
+	}
+}
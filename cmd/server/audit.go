@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+
+	sst "github.com/evsan/secret-server-task"
+	"github.com/evsan/secret-server-task/audit"
+	"github.com/evsan/secret-server-task/httpapi"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// AuditConfig holds the sinks the audit events are shipped to
+type AuditConfig struct {
+	SinksFile string
+}
+
+// RegisterFlags registers the audit flags in the flag set
+func (c *AuditConfig) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.SinksFile, "auditSinks", "", "JSON file with the audit sinks: [{\"type\": \"file|syslog|http|s3\", \"buffer\": 1024, \"overflow\": \"drop|block\", \"file\": {...}, ...}]. If empty no audit events are shipped")
+}
+
+// open starts the sinks and registers their metrics, it returns nil if no sinks are configured
+func (c AuditConfig) open() *audit.Log {
+	if c.SinksFile == "" {
+		return nil
+	}
+	configs, err := audit.LoadConfig(c.SinksFile)
+	if err != nil {
+		log.Fatal("can't load the audit sinks: ", err)
+	}
+	l, err := audit.Open(configs)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for i, stats := range l.Stats() {
+		i := i
+		counter := func(name, help string, value func(audit.SinkStats) uint64) {
+			opts := prometheus.CounterOpts{Name: name, Help: help, ConstLabels: prometheus.Labels{"sink": stats.Name}}
+			prometheus.MustRegister(prometheus.NewCounterFunc(opts, func() float64 {
+				return float64(value(l.Stats()[i]))
+			}))
+		}
+		counter("audit_events_written_total", "The total number of the audit events written by the sink",
+			func(s audit.SinkStats) uint64 { return s.Written })
+		counter("audit_events_dropped_total", "The total number of the audit events dropped because the buffer of the sink was full",
+			func(s audit.SinkStats) uint64 { return s.Dropped })
+		counter("audit_events_failed_total", "The total number of the audit events the sink failed to write",
+			func(s audit.SinkStats) uint64 { return s.Failed })
+	}
+	return l
+}
+
+// auditAPI records the creations and the retrievals of the public API
+func auditAPI(l *audit.Log, app *httpapi.App) {
+	app.OnStore(func(ctx context.Context, s sst.Secret) {
+		l.Record(traced(ctx, audit.Event{Action: "store", Hash: s.Hash, Tenant: s.Tenant, Owner: s.Owner}))
+	})
+	app.OnGet(func(ctx context.Context, hash string, outcome httpapi.GetOutcome) {
+		l.Record(traced(ctx, audit.Event{Action: "get", Hash: hash, Outcome: string(outcome)}))
+	})
+}
+
+// auditAdmin records the admin actions
+func auditAdmin(l *audit.Log) httpapi.Option {
+	return httpapi.WithAdminHook(func(ctx context.Context, e httpapi.AdminEvent) {
+		event := audit.Event{Action: "admin." + e.Action, Target: e.Target, Detail: e.Detail}
+		if e.Action == httpapi.AdminRevoke {
+			event.Hash, event.Target = e.Target, ""
+		}
+		l.Record(traced(ctx, event))
+	})
+}
+
+// traced records the request of the action in the event
+func traced(ctx context.Context, e audit.Event) audit.Event {
+	t := httpapi.TraceOf(ctx)
+	e.RequestID, e.TraceID = t.RequestID, t.TraceID()
+	return e
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"flag"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// AuthConfig describes the access control of a listener.
+// If AllowedNets is not empty the client address must belong to one of the networks.
+// If basic auth credentials or a bearer token are configured the request must provide any of them.
+// Empty config allows everything.
+type AuthConfig struct {
+	BasicAuth   basicCredentials
+	BearerToken string
+	AllowedNets cidrList
+}
+
+// RegisterFlags registers the auth flags using the given prefix, e.g. "metrics" gives -metricsBasicAuth
+func (c *AuthConfig) RegisterFlags(fs *flag.FlagSet, prefix, description string) {
+	fs.Var(&c.BasicAuth, prefix+"BasicAuth", "user:password required for "+description)
+	fs.StringVar(&c.BearerToken, prefix+"Token", "", "bearer token required for "+description)
+	fs.Var(&c.AllowedNets, prefix+"AllowIPs", "comma separated list of IPs or CIDRs allowed to access "+description)
+}
+
+// Enabled returns true if any of the access checks is configured
+func (c AuthConfig) Enabled() bool {
+	return c.BasicAuth.User != "" || c.BearerToken != "" || len(c.AllowedNets) > 0
+}
+
+// Middleware wraps the handler with the configured access checks
+func (c AuthConfig) Middleware(next http.Handler) http.Handler {
+	if !c.Enabled() {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(c.AllowedNets) > 0 && !c.AllowedNets.Contains(remoteIP(r)) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		if !c.authenticated(r) {
+			if c.BasicAuth.User != "" {
+				w.Header().Set("WWW-Authenticate", `Basic realm="secret-server"`)
+			}
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (c AuthConfig) authenticated(r *http.Request) bool {
+	if c.BasicAuth.User == "" && c.BearerToken == "" {
+		return true
+	}
+	if c.BasicAuth.User != "" {
+		if user, pass, ok := r.BasicAuth(); ok &&
+			secureCompare(user, c.BasicAuth.User) && secureCompare(pass, c.BasicAuth.Password) {
+			return true
+		}
+	}
+	if c.BearerToken != "" {
+		const prefix = "Bearer "
+		h := r.Header.Get("Authorization")
+		if len(h) > len(prefix) && strings.EqualFold(h[:len(prefix)], prefix) &&
+			secureCompare(h[len(prefix):], c.BearerToken) {
+			return true
+		}
+	}
+	return false
+}
+
+func secureCompare(given, expected string) bool {
+	return subtle.ConstantTimeCompare([]byte(given), []byte(expected)) == 1
+}
+
+// remoteIP returns the IP of the direct peer. Proxy headers are ignored on purpose,
+// they can be forged by the client.
+func remoteIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return net.ParseIP(host)
+}
+
+// basicCredentials implements flag.Value for "user:password" values
+type basicCredentials struct {
+	User     string
+	Password string
+}
+
+func (b *basicCredentials) String() string {
+	if b == nil || b.User == "" {
+		return ""
+	}
+	return b.User + ":***"
+}
+
+func (b *basicCredentials) Set(value string) error {
+	i := strings.Index(value, ":")
+	if i < 1 {
+		return errors.New("basic auth should be in user:password format")
+	}
+	b.User, b.Password = value[:i], value[i+1:]
+	return nil
+}
+
+// cidrList implements flag.Value for comma separated lists of IPs and CIDRs
+type cidrList []*net.IPNet
+
+func (l *cidrList) String() string {
+	if l == nil {
+		return ""
+	}
+	s := make([]string, 0, len(*l))
+	for _, n := range *l {
+		s = append(s, n.String())
+	}
+	return strings.Join(s, ",")
+}
+
+func (l *cidrList) Set(value string) error {
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if !strings.Contains(item, "/") {
+			ip := net.ParseIP(item)
+			if ip == nil {
+				return errors.New("invalid IP address: " + item)
+			}
+			bits := 32
+			if ip.To4() == nil {
+				bits = 128
+			}
+			*l = append(*l, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(item)
+		if err != nil {
+			return err
+		}
+		*l = append(*l, n)
+	}
+	return nil
+}
+
+// Contains checks if the ip belongs to any of the networks
+func (l cidrList) Contains(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, n := range l {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+
+	"filippo.io/age"
+	sst "github.com/evsan/secret-server-task"
+	"github.com/evsan/secret-server-task/backup"
+)
+
+// BackupConfig holds the encryption settings of the backup
+type BackupConfig struct {
+	Recipients     string
+	IdentityFile   string
+	PassphraseFile string
+}
+
+// RegisterExportFlags registers the encryption flags in the flag set
+func (c *BackupConfig) RegisterExportFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.Recipients, "recipient", "", "comma separated list of age public keys (age1...) the backup is encrypted to")
+	fs.StringVar(&c.PassphraseFile, "passphraseFile", "", "file with the passphrase the backup is encrypted with, alternative to -recipient")
+}
+
+// RegisterImportFlags registers the decryption flags in the flag set
+func (c *BackupConfig) RegisterImportFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.IdentityFile, "identity", "", "age identity file (AGE-SECRET-KEY-...) the backup is decrypted with")
+	fs.StringVar(&c.PassphraseFile, "passphraseFile", "", "file with the passphrase the backup is encrypted with, alternative to -identity")
+}
+
+// recipients returns age recipients of the encrypted backup
+func (c *BackupConfig) recipients() ([]age.Recipient, error) {
+	if c.PassphraseFile != "" {
+		passphrase, err := readPassphrase(c.PassphraseFile)
+		if err != nil {
+			return nil, err
+		}
+		r, err := age.NewScryptRecipient(passphrase)
+		if err != nil {
+			return nil, err
+		}
+		return []age.Recipient{r}, nil
+	}
+	return backup.ParseRecipients(c.Recipients)
+}
+
+// identities returns age identities for the backup decryption
+func (c *BackupConfig) identities() ([]age.Identity, error) {
+	if c.PassphraseFile != "" {
+		passphrase, err := readPassphrase(c.PassphraseFile)
+		if err != nil {
+			return nil, err
+		}
+		i, err := age.NewScryptIdentity(passphrase)
+		if err != nil {
+			return nil, err
+		}
+		return []age.Identity{i}, nil
+	}
+	return backup.ReadIdentities(c.IdentityFile)
+}
+
+func readPassphrase(path string) (string, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	passphrase := strings.TrimRight(string(b), "\r\n")
+	if passphrase == "" {
+		return "", errors.New("passphrase file is empty")
+	}
+	return passphrase, nil
+}
+
+// exportCommand writes the encrypted backup of the persistent storage
+func exportCommand(args []string) {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+
+	var storageConfig StorageConfig
+	storageConfig.RegisterFlags(fs)
+
+	var backupConfig BackupConfig
+	backupConfig.RegisterExportFlags(fs)
+
+	var fipsConfig FIPSConfig
+	fipsConfig.RegisterFlags(fs)
+
+	var pushConfig PushConfig
+	pushConfig.RegisterFlags(fs, "export")
+
+	out := fs.String("out", "-", "output file, - means stdout")
+
+	_ = fs.Parse(args)
+	fipsConfig.check()
+	fipsConfig.refuse("age backup", true)
+
+	if !storageConfig.Persistent() {
+		log.Fatal("export requires -dbUrl or -dbShards, use GET /admin/export for the in-memory storage")
+	}
+	recipients, err := backupConfig.recipients()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	storage, db := storageConfig.Open()
+	defer db.Close()
+
+	var w io.Writer = os.Stdout
+	if *out != "-" {
+		f, err := os.OpenFile(*out, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		w = f
+	}
+
+	run := pushConfig.start()
+	count, err := backup.Write(w, storage.(sst.Exporter), recipients...)
+	run.finish(count, err)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Fprintf(os.Stderr, "exported %d secrets\n", count)
+}
+
+// importCommand restores the backup into the persistent storage: server import [flags] backup.age
+func importCommand(args []string) {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+
+	var storageConfig StorageConfig
+	storageConfig.RegisterFlags(fs)
+
+	var backupConfig BackupConfig
+	backupConfig.RegisterImportFlags(fs)
+
+	var fipsConfig FIPSConfig
+	fipsConfig.RegisterFlags(fs)
+
+	var pushConfig PushConfig
+	pushConfig.RegisterFlags(fs, "import")
+
+	_ = fs.Parse(args)
+	fipsConfig.check()
+	fipsConfig.refuse("age backup", true)
+
+	if fs.NArg() != 1 {
+		log.Fatal("usage: server import [flags] backup.age, - means stdin")
+	}
+	if !storageConfig.Persistent() {
+		log.Fatal("import requires -dbUrl or -dbShards, use POST /admin/import for the in-memory storage")
+	}
+	identities, err := backupConfig.identities()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var r io.Reader = os.Stdin
+	if fs.Arg(0) != "-" {
+		f, err := os.Open(fs.Arg(0))
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		r = f
+	}
+
+	storage, db := storageConfig.Open()
+	defer db.Close()
+
+	run := pushConfig.start()
+	result, err := backup.Read(r, storage.(sst.Importer), identities...)
+	run.finish(result.Imported, err)
+	fmt.Fprintf(os.Stderr, "imported %d secrets, skipped %d expired, %d conflicts\n", result.Imported, result.Expired, len(result.Conflicts))
+	for _, hash := range result.Conflicts {
+		fmt.Fprintln(os.Stderr, "conflict:", hash)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
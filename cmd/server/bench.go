@@ -0,0 +1,262 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// benchConfig describes the generated traffic
+type benchConfig struct {
+	Target      string
+	APIKey      string
+	Rate        int
+	Duration    time.Duration
+	Concurrency int
+	CreateRatio float64
+	Views       int
+	SecretSize  int
+}
+
+// benchCommand drives the create/view traffic to the running server and reports the latencies:
+// server bench -target=http://localhost:8001 -rate=100 -duration=30s
+func benchCommand(args []string) {
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+
+	var c benchConfig
+	fs.StringVar(&c.Target, "target", "http://localhost:8001", "base URL of the API including the path prefix")
+	fs.StringVar(&c.APIKey, "apiKey", "", "api key sent with the create requests")
+	fs.IntVar(&c.Rate, "rate", 50, "requests per second")
+	fs.DurationVar(&c.Duration, "duration", 10*time.Second, "how long the traffic is generated")
+	fs.IntVar(&c.Concurrency, "concurrency", 50, "maximum number of requests in flight")
+	fs.Float64Var(&c.CreateRatio, "createRatio", 0.3, "share of the create requests, the rest are views of the created secrets")
+	fs.IntVar(&c.Views, "views", 3, "expireAfterViews of the created secrets")
+	fs.IntVar(&c.SecretSize, "secretSize", 256, "size of the created secrets in bytes")
+
+	_ = fs.Parse(args)
+
+	if c.Rate < 1 || c.Concurrency < 1 || c.Views < 1 || c.SecretSize < 1 || c.CreateRatio <= 0 || c.CreateRatio > 1 {
+		log.Fatal("-rate, -concurrency, -views and -secretSize should be positive, -createRatio should be in (0, 1]")
+	}
+
+	b := newBench(c)
+	b.run()
+	b.report(os.Stdout)
+}
+
+// bench generates the open loop traffic: the requests are sent at the fixed rate
+// regardless of the latency, the requests exceeding the concurrency are dropped
+type bench struct {
+	config benchConfig
+	client *http.Client
+	secret string
+
+	mu        sync.Mutex
+	hashes    []string
+	views     map[string]int
+	latencies map[string][]time.Duration
+	errors    map[string]int
+	dropped   int
+}
+
+func newBench(c benchConfig) *bench {
+	return &bench{
+		config: c,
+		client: &http.Client{
+			Timeout:   10 * time.Second,
+			Transport: &http.Transport{MaxIdleConnsPerHost: c.Concurrency},
+		},
+		secret:    strings.Repeat("x", c.SecretSize),
+		views:     map[string]int{},
+		latencies: map[string][]time.Duration{},
+		errors:    map[string]int{},
+	}
+}
+
+func (b *bench) run() {
+	slots := make(chan struct{}, b.config.Concurrency)
+	var wg sync.WaitGroup
+
+	ticker := time.NewTicker(time.Second / time.Duration(b.config.Rate))
+	defer ticker.Stop()
+	deadline := time.After(b.config.Duration)
+
+	for {
+		select {
+		case <-deadline:
+			wg.Wait()
+			return
+		case <-ticker.C:
+			select {
+			case slots <- struct{}{}:
+			default:
+				b.mu.Lock()
+				b.dropped++
+				b.mu.Unlock()
+				continue
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer func() { <-slots }()
+				b.request()
+			}()
+		}
+	}
+}
+
+// request makes the view of the previously created secret or creates the new one
+func (b *bench) request() {
+	hash, ok := "", false
+	if rand.Float64() >= b.config.CreateRatio {
+		hash, ok = b.nextHash()
+	}
+	start := time.Now()
+	if ok {
+		err := b.view(hash)
+		b.record("view", time.Since(start), err)
+		return
+	}
+	err := b.create()
+	b.record("create", time.Since(start), err)
+}
+
+func (b *bench) create() error {
+	form := url.Values{
+		"secret":           {b.secret},
+		"expireAfterViews": {strconv.Itoa(b.config.Views)},
+		"expireAfter":      {"10"},
+	}
+	req, err := http.NewRequest(http.MethodPost, b.config.Target+"/secret", strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if b.config.APIKey != "" {
+		req.Header.Set("X-API-Key", b.config.APIKey)
+	}
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		_, _ = io.Copy(ioutil.Discard, resp.Body)
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	var secret struct {
+		Hash string `json:"hash"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&secret); err != nil {
+		return err
+	}
+	b.mu.Lock()
+	b.hashes = append(b.hashes, secret.Hash)
+	b.views[secret.Hash] = b.config.Views
+	b.mu.Unlock()
+	return nil
+}
+
+func (b *bench) view(hash string) error {
+	req, err := http.NewRequest(http.MethodGet, b.config.Target+"/secret/"+hash, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// nextHash returns the random created secret which still has views left
+func (b *bench) nextHash() (string, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.hashes) == 0 {
+		return "", false
+	}
+	i := rand.Intn(len(b.hashes))
+	hash := b.hashes[i]
+	b.views[hash]--
+	if b.views[hash] == 0 {
+		delete(b.views, hash)
+		b.hashes[i] = b.hashes[len(b.hashes)-1]
+		b.hashes = b.hashes[:len(b.hashes)-1]
+	}
+	return hash, true
+}
+
+func (b *bench) record(op string, latency time.Duration, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.latencies[op] = append(b.latencies[op], latency)
+	if err != nil {
+		b.errors[op]++
+	}
+}
+
+func (b *bench) report(w io.Writer) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "op\trequests\terrors\terror %\tp50\tp90\tp99\tmax\t")
+
+	var all []time.Duration
+	var allErrors int
+	for _, op := range []string{"create", "view"} {
+		latencies := b.latencies[op]
+		all = append(all, latencies...)
+		allErrors += b.errors[op]
+		writeBenchRow(tw, op, latencies, b.errors[op])
+	}
+	writeBenchRow(tw, "total", all, allErrors)
+	_ = tw.Flush()
+
+	fmt.Fprintf(w, "\ntarget rate %d/s for %s, achieved %.1f/s, dropped %d requests over the concurrency limit\n",
+		b.config.Rate, b.config.Duration, float64(len(all))/b.config.Duration.Seconds(), b.dropped)
+}
+
+func writeBenchRow(w io.Writer, op string, latencies []time.Duration, errors int) {
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	var errorRate float64
+	if len(latencies) > 0 {
+		errorRate = float64(errors) * 100 / float64(len(latencies))
+	}
+	fmt.Fprintf(w, "%s\t%d\t%d\t%.2f\t%s\t%s\t%s\t%s\t\n", op, len(latencies), errors, errorRate,
+		percentile(latencies, 0.5), percentile(latencies, 0.9), percentile(latencies, 0.99), percentile(latencies, 1))
+}
+
+// percentile returns the nearest rank percentile of the sorted latencies
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(p*float64(len(sorted))+0.5) - 1
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(sorted) {
+		i = len(sorted) - 1
+	}
+	return sorted[i].Round(time.Microsecond)
+}
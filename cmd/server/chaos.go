@@ -0,0 +1,34 @@
+package main
+
+import (
+	"flag"
+	"log"
+
+	"github.com/evsan/secret-server-task/chaos"
+)
+
+// ChaosConfig holds the faults injected into the API and into the storage. Never enable it in production
+type ChaosConfig struct {
+	HTTP    chaos.Config
+	Storage chaos.Config
+}
+
+// RegisterFlags registers the fault injection flags in the flag set
+func (c *ChaosConfig) RegisterFlags(fs *flag.FlagSet) {
+	fs.DurationVar(&c.HTTP.Latency, "chaosLatency", 0, "staging only: maximum random latency added to the API requests")
+	fs.Float64Var(&c.HTTP.ErrorRate, "chaosErrorRate", 0, "staging only: share of the API requests failed with 503 before handling")
+	fs.Float64Var(&c.HTTP.PartialRate, "chaosPartialRate", 0, "staging only: share of the API requests failed with 503 after handling")
+	fs.DurationVar(&c.Storage.Latency, "chaosStorageLatency", 0, "staging only: maximum random latency added to the storage calls")
+	fs.Float64Var(&c.Storage.ErrorRate, "chaosStorageErrorRate", 0, "staging only: share of the storage calls failed before the call")
+	fs.Float64Var(&c.Storage.PartialRate, "chaosStoragePartialRate", 0, "staging only: share of the storage calls failed after the call")
+}
+
+// warn logs the enabled fault injection, so it is never enabled unnoticed
+func (c ChaosConfig) warn() {
+	if c.HTTP.Enabled() {
+		log.Printf("WARNING: chaos mode injects faults into the API: %+v", c.HTTP)
+	}
+	if c.Storage.Enabled() {
+		log.Printf("WARNING: chaos mode injects faults into the storage: %+v", c.Storage)
+	}
+}
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"filippo.io/age"
+	sst "github.com/evsan/secret-server-task"
+	"github.com/evsan/secret-server-task/audit"
+	"github.com/evsan/secret-server-task/backup"
+	"github.com/evsan/secret-server-task/contentpolicy"
+	"github.com/evsan/secret-server-task/httpapi"
+	"github.com/evsan/secret-server-task/opa"
+	"github.com/jmoiron/sqlx"
+)
+
+// errSkipped marks the check which doesn't apply to the configuration
+var errSkipped = errors.New("skipped")
+
+// checkReport prints the result of every check, the deployment gate reads the exit status
+type checkReport struct {
+	out    io.Writer
+	failed int
+	passed int
+}
+
+// run performs the check and reports its result
+func (r *checkReport) run(name string, check func() error) {
+	err := check()
+	switch {
+	case err == nil:
+		r.passed++
+		fmt.Fprintf(r.out, "ok    %s\n", name)
+	case errors.Is(err, errSkipped):
+		fmt.Fprintf(r.out, "skip  %s\n", name)
+	default:
+		r.failed++
+		fmt.Fprintf(r.out, "FAIL  %s: %v\n", name, err)
+	}
+}
+
+// checkCommand validates the configuration of serve without serving: it accepts the same flags,
+// loads every configured file, connects to the databases and verifies their schema.
+// It exits with 1 if any check failed, so it is usable as the pre-deploy gate
+func checkCommand(args []string) {
+	fs := flag.NewFlagSet("check", flag.ExitOnError)
+	f := newServeFlags(fs)
+	timeout := fs.Duration("checkTimeout", 10*time.Second, "timeout of the connection to every database")
+	_ = fs.Parse(args)
+
+	r := &checkReport{out: os.Stdout}
+	f.check(r, *timeout)
+	fmt.Fprintf(r.out, "%d passed, %d failed\n", r.passed, r.failed)
+	if r.failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// check runs the checks of the serve configuration
+func (f *serveFlags) check(r *checkReport, timeout time.Duration) {
+	r.run("fips", func() error {
+		if err := f.fipsConfig.validate(); err != nil {
+			return err
+		}
+		if f.fipsConfig.Enabled && *f.backupIdentity != "" {
+			return errors.New("-backupIdentity (age backups) is not allowed in the FIPS mode")
+		}
+		return nil
+	})
+	r.run("log output", func() error {
+		if _, err := httpapi.NewLogControl(sst.SystemClock, httpapi.LogLevel(f.logConfig.Level), f.logConfig.Sample); err != nil {
+			return err
+		}
+		if _, err := f.logConfig.accessConfig(); err != nil {
+			return err
+		}
+		out, err := f.logConfig.open()
+		if err != nil || out == nil {
+			return err
+		}
+		return out.Close()
+	})
+	r.run("locale", func() error {
+		if *f.locale == "" {
+			return errSkipped
+		}
+		return validateLocale(*f.locale)
+	})
+	r.run("timestamps", func() error {
+		return validateTimestamps(*f.timestamps)
+	})
+	r.run("static dir", func() error {
+		if *f.staticDir == "" {
+			return errSkipped
+		}
+		info, err := os.Stat(*f.staticDir)
+		if err == nil && !info.IsDir() {
+			err = fmt.Errorf("%s is not a directory", *f.staticDir)
+		}
+		return err
+	})
+	r.run("hash format", func() error {
+		return sst.HashFormat{Length: *f.hashLength, Alphabet: *f.hashAlphabet}.Validate()
+	})
+	r.run("admin auth", func() error {
+		if *f.adminAddr == "" {
+			return errSkipped
+		}
+		if f.adminAuth.BasicAuth.User == "" && f.adminAuth.BearerToken == "" {
+			return errors.New("admin API requires -adminBasicAuth or -adminToken")
+		}
+		return nil
+	})
+	r.run("api keys", func() error {
+		var apiKeys httpapi.APIKeys
+		if *f.apiKeysFile != "" {
+			var err error
+			if apiKeys, err = httpapi.LoadAPIKeys(*f.apiKeysFile); err != nil {
+				return err
+			}
+		}
+		if *f.requireAPIKey && len(apiKeys) == 0 {
+			return errors.New("-requireApiKey requires -apiKeysFile")
+		}
+		if *f.apiKeysFile == "" {
+			return errSkipped
+		}
+		return nil
+	})
+	r.run("tenants", func() error {
+		for _, t := range strings.Split(*f.tenantList, ",") {
+			if t = strings.TrimSpace(t); t == "" {
+				continue
+			}
+			if err := sst.ValidateTenant(t); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	r.run("policy", func() error {
+		if *f.policyFile == "" {
+			return errSkipped
+		}
+		_, err := httpapi.LoadPolicyConfig(*f.policyFile)
+		return err
+	})
+	r.run("route middleware", func() error {
+		if *f.routeMiddleware == "" {
+			return errSkipped
+		}
+		_, err := httpapi.LoadRouteMiddleware(*f.routeMiddleware)
+		return err
+	})
+	r.run("content policy", func() error {
+		if *f.contentPolicy == "" {
+			return errSkipped
+		}
+		rules := contentpolicy.DefaultRules()
+		if *f.contentPolicy != "default" {
+			var err error
+			if rules, err = contentpolicy.LoadRules(*f.contentPolicy); err != nil {
+				return err
+			}
+		}
+		_, err := contentpolicy.New(rules...)
+		return err
+	})
+	r.run("label index key", func() error {
+		if *f.labelIndexKeyFile == "" {
+			return errSkipped
+		}
+		_, err := loadLabelIndex(*f.labelIndexKeyFile)
+		return err
+	})
+	r.run("policy endpoint", func() error {
+		if *f.policyURL == "" {
+			return errSkipped
+		}
+		// Any decision is fine, the endpoint should answer
+		_, err := opa.New(*f.policyURL, *f.policyTimeout).Evaluate(context.Background(), httpapi.PolicyInput{Action: httpapi.PolicyRetrieve, Time: time.Now().UTC()})
+		return err
+	})
+	r.run("rate limit exemption", func() error {
+		if *f.rateLimitExempt == "" {
+			return errSkipped
+		}
+		_, err := f.rateLimitExemption()
+		return err
+	})
+	r.run("csrf key", func() error {
+		if !*f.csrf || *f.csrfKeyFile == "" {
+			return errSkipped
+		}
+		_, err := loadKeyFile(*f.csrfKeyFile, "-csrfKeyFile")
+		return err
+	})
+	r.run("preview token key", func() error {
+		if *f.previewTokenTTL <= 0 || *f.previewTokenKeyFile == "" {
+			return errSkipped
+		}
+		_, err := loadKeyFile(*f.previewTokenKeyFile, "-previewTokenKeyFile")
+		return err
+	})
+	r.run("cache headers", httpapi.AuditCacheHeaders)
+	r.run("id seed", f.idConfig.validate)
+	r.run("compaction window", f.compactionConfig.validate)
+	r.run("oidc provider", func() error {
+		if *f.oidcIssuer == "" {
+			return errSkipped
+		}
+		_, err := f.webLogin()
+		return err
+	})
+	r.run("audit sinks", func() error {
+		if f.auditConfig.SinksFile == "" {
+			return errSkipped
+		}
+		configs, err := audit.LoadConfig(f.auditConfig.SinksFile)
+		if err != nil {
+			return err
+		}
+		for _, c := range configs {
+			switch c.Type {
+			case "file", "syslog", "http", "s3":
+			default:
+				return fmt.Errorf("%w: %q", audit.ErrUnknownSink, c.Type)
+			}
+		}
+		return nil
+	})
+	r.run("backup identity", func() error {
+		if *f.backupIdentity == "" {
+			return errSkipped
+		}
+		identities, err := backup.ReadIdentities(*f.backupIdentity)
+		if err != nil {
+			return err
+		}
+		return probeIdentities(identities)
+	})
+	r.run("jws key", func() error {
+		if *f.jwsKeyFile == "" {
+			return errSkipped
+		}
+		signer, err := httpapi.LoadSigner(*f.jwsKeyFile)
+		if err != nil {
+			return err
+		}
+		_, err = signer.Compact([]byte("{}"))
+		return err
+	})
+
+	dbs, err := f.storageConfig.databases()
+	r.run("tenant keys", func() error {
+		keys, err := f.storageConfig.tenantKeys()
+		if err == nil && keys == nil {
+			return errSkipped
+		}
+		return err
+	})
+	r.run("storage", func() error {
+		if err != nil {
+			return err
+		}
+		if f.storageConfig.Outbox && (len(dbs) == 0 || f.storageConfig.Shards != "") {
+			return errors.New("-webhookOutbox requires -dbUrl and is not supported with -dbShards")
+		}
+		if len(dbs) == 0 {
+			return errSkipped
+		}
+		return nil
+	})
+	for _, d := range dbs {
+		d := d
+		r.run("database "+d.name, func() error {
+			return checkDatabase(d.url, timeout)
+		})
+	}
+}
+
+// probeIdentities encrypts the probe to every X25519 identity and decrypts it back,
+// the other identities (e.g. the passphrases) can't be probed without the backup
+func probeIdentities(identities []age.Identity) error {
+	for _, identity := range identities {
+		x, ok := identity.(*age.X25519Identity)
+		if !ok {
+			continue
+		}
+		var encrypted bytes.Buffer
+		w, err := age.Encrypt(&encrypted, x.Recipient())
+		if err != nil {
+			return err
+		}
+		if _, err = io.WriteString(w, "probe"); err != nil {
+			return err
+		}
+		if err = w.Close(); err != nil {
+			return err
+		}
+		rd, err := age.Decrypt(&encrypted, x)
+		if err != nil {
+			return err
+		}
+		if b, err := io.ReadAll(rd); err != nil || string(b) != "probe" {
+			return fmt.Errorf("identity %s doesn't decrypt its backups: %v", x.Recipient(), err)
+		}
+	}
+	return nil
+}
+
+// checkDatabase connects to the database and verifies schema.sql is applied
+func checkDatabase(url string, timeout time.Duration) error {
+	db, err := sqlx.Open("postgres", url)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err = db.PingContext(ctx); err != nil {
+		return err
+	}
+	return sst.VerifyPgSchema(db)
+}
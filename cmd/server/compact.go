@@ -0,0 +1,138 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"time"
+
+	sst "github.com/evsan/secret-server-task"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var errCompactWindow = errors.New("-compactWindow should be HH:MM-HH:MM, e.g. 02:00-05:00")
+
+// CompactionConfig holds the schedule of the compaction of the embedded storages, see sst.Compactor
+type CompactionConfig struct {
+	Interval time.Duration
+	// Window is the local time of the day the compaction may start in, HH:MM-HH:MM. Empty allows any time
+	Window string
+}
+
+// RegisterFlags registers the compaction flags in the flag set
+func (c *CompactionConfig) RegisterFlags(fs *flag.FlagSet) {
+	fs.DurationVar(&c.Interval, "compactInterval", 24*time.Hour, "how often the embedded on-disk storage is compacted to return the space of the purged secrets to the filesystem. 0 disables the compaction, the other storages are never compacted")
+	fs.StringVar(&c.Window, "compactWindow", "", "local time window the compaction starts in, HH:MM-HH:MM, e.g. 02:00-05:00 or 23:00-01:00. The due compaction waits for the window, empty starts it at any time")
+}
+
+// compactWindow is the window of the day in minutes since midnight, the end before the start wraps midnight
+type compactWindow struct {
+	start, end int
+}
+
+// window parses the configured window, nil if it is empty
+func (c CompactionConfig) window() (*compactWindow, error) {
+	if c.Window == "" {
+		return nil, nil
+	}
+	var startH, startM, endH, endM int
+	if _, err := fmt.Sscanf(c.Window, "%d:%d-%d:%d", &startH, &startM, &endH, &endM); err != nil {
+		return nil, errCompactWindow
+	}
+	for _, v := range []struct{ value, max int }{{startH, 23}, {startM, 59}, {endH, 23}, {endM, 59}} {
+		if v.value < 0 || v.value > v.max {
+			return nil, errCompactWindow
+		}
+	}
+	w := &compactWindow{start: startH*60 + startM, end: endH*60 + endM}
+	if w.start == w.end {
+		return nil, errCompactWindow
+	}
+	return w, nil
+}
+
+// validate checks the window, the check command runs it
+func (c CompactionConfig) validate() error {
+	_, err := c.window()
+	return err
+}
+
+// contains reports whether the minute of the day t is inside the window
+func (w *compactWindow) contains(t time.Time) bool {
+	minute := t.Hour()*60 + t.Minute()
+	if w.start < w.end {
+		return minute >= w.start && minute < w.end
+	}
+	return minute >= w.start || minute < w.end
+}
+
+// next returns t if it is inside the window, otherwise the next start of the window
+func (w *compactWindow) next(t time.Time) time.Time {
+	if w == nil || w.contains(t) {
+		return t
+	}
+	start := time.Date(t.Year(), t.Month(), t.Day(), w.start/60, w.start%60, 0, 0, t.Location())
+	if !start.After(t) {
+		start = start.AddDate(0, 0, 1)
+	}
+	return start
+}
+
+// compactionMetrics count the compactions and the space they reclaim
+type compactionMetrics struct {
+	compactions *prometheus.CounterVec
+	reclaimed   prometheus.Counter
+}
+
+func newCompactionMetrics() compactionMetrics {
+	m := compactionMetrics{
+		compactions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "storage_compactions_total",
+			Help: "The total number of the compactions of the embedded storage by the result: ok or error",
+		}, []string{"result"}),
+		reclaimed: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "storage_compaction_reclaimed_bytes_total",
+			Help: "The total number of the bytes returned to the filesystem by the compactions of the embedded storage",
+		}),
+	}
+	prometheus.MustRegister(m.compactions, m.reclaimed)
+	return m
+}
+
+// runCompaction compacts the storage every interval inside the window until the process exits.
+// Every replica compacts its own files, so the leadership is not checked
+func (c CompactionConfig) runCompaction(storage sst.Storage, jobs *jobMetrics) {
+	window, err := c.window()
+	if err != nil {
+		log.Fatal(err)
+	}
+	compactor, ok := sst.CompactorOf(storage)
+	if !ok || c.Interval <= 0 {
+		return
+	}
+	metrics := newCompactionMetrics()
+	compaction := jobs.job("compaction")
+	last := time.Now()
+	for {
+		time.Sleep(time.Until(window.next(last.Add(c.Interval))))
+		started := time.Now()
+		var reclaimed int64
+		_, err := compaction.observe(func() (int, error) {
+			var err error
+			reclaimed, err = compactor.Compact()
+			return 0, err
+		})
+		if reclaimed > 0 {
+			metrics.reclaimed.Add(float64(reclaimed))
+		}
+		last = time.Now()
+		if err != nil {
+			metrics.compactions.WithLabelValues("error").Inc()
+			log.Println("compaction: ", err)
+			continue
+		}
+		metrics.compactions.WithLabelValues("ok").Inc()
+		log.Printf("compaction: reclaimed %d bytes in %s", reclaimed, last.Sub(started).Round(time.Millisecond))
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"log"
+)
+
+// FIPSConfig restricts the crypto to the FIPS 140 validated BoringCrypto module
+type FIPSConfig struct {
+	Enabled bool
+}
+
+// RegisterFlags registers the FIPS flag in the flag set
+func (c *FIPSConfig) RegisterFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&c.Enabled, "fips", fipsBuild, "restrict the crypto to the FIPS 140 validated module and refuse the non-compliant settings. Requires the binary built with GOEXPERIMENT=boringcrypto, where it is always enabled")
+}
+
+// check refuses to start if the FIPS mode is requested but can't be provided.
+// The FIPS build is always in the FIPS mode
+func (c *FIPSConfig) check() {
+	if err := c.validate(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// validate reports whether the requested FIPS mode can be provided and enables it in the FIPS build
+func (c *FIPSConfig) validate() error {
+	if !c.Enabled && !fipsBuild {
+		return nil
+	}
+	if !fipsBuild {
+		return errors.New("-fips requires the binary built with GOEXPERIMENT=boringcrypto")
+	}
+	if !fipsModule() {
+		return errors.New("BoringCrypto is not available on this platform, the FIPS mode can't be provided")
+	}
+	c.Enabled = true
+	return nil
+}
+
+// refuse stops the process if the non-compliant setting is used in the FIPS mode
+func (c *FIPSConfig) refuse(setting string, used bool) {
+	if c.Enabled && used {
+		log.Fatalf("%s is not FIPS 140 compliant", setting)
+	}
+}
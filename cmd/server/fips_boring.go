@@ -0,0 +1,17 @@
+//go:build boringcrypto
+
+package main
+
+import (
+	"crypto/boring"
+	// fipsonly restricts all TLS configurations, including the postgres, syslog and webhook clients
+	_ "crypto/tls/fipsonly"
+)
+
+// fipsBuild is set in the binaries built with GOEXPERIMENT=boringcrypto
+const fipsBuild = true
+
+// fipsModule reports whether BoringCrypto handles the crypto operations
+func fipsModule() bool {
+	return boring.Enabled()
+}
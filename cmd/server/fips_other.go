@@ -0,0 +1,9 @@
+//go:build !boringcrypto
+
+package main
+
+const fipsBuild = false
+
+func fipsModule() bool {
+	return false
+}
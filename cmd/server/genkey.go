@@ -0,0 +1,136 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"filippo.io/age"
+	sst "github.com/evsan/secret-server-task"
+	"github.com/evsan/secret-server-task/httpapi"
+)
+
+// tokenBytes is the entropy of the generated bearer tokens and api keys
+const tokenBytes = 32
+
+// genKeyCommand generates the keys in the encodings the server reads them in:
+//
+//	token   the bearer token of -adminToken and -metricsToken
+//	apikey  the entry of -apiKeysFile, {"key": ..., "owner": ..., "tenant": ...}
+//	jws     the SEC 1 PEM P-256 key of -jwsKeyFile
+//	age     the X25519 identity of -backupIdentity, its recipient is printed to stderr
+//	tenantkey the entry of the tenant in -tenantKeysFile, {"id": ..., "key": ...}
+func genKeyCommand(args []string) {
+	fs := flag.NewFlagSet("gen-key", flag.ExitOnError)
+	typ := fs.String("type", "token", "type of the key: token, apikey, jws, age or tenantkey")
+	out := fs.String("out", "", "file the key is written to, created with the mode 0600 and never overwritten. If empty the key is printed")
+	owner := fs.String("owner", "", "owner of the api key")
+	tenant := fs.String("tenant", "", "tenant of the api key or the tenant key, empty for the default one")
+	_ = fs.Parse(args)
+
+	var key []byte
+	var err error
+	switch *typ {
+	case "token":
+		key = append(randomToken(), '\n')
+	case "apikey":
+		key, err = genAPIKey(*owner, *tenant)
+	case "jws":
+		key, err = genJWSKey()
+	case "age":
+		key, err = genAgeIdentity()
+	case "tenantkey":
+		key, err = genTenantKey(*tenant)
+	default:
+		log.Fatalf("unknown -type %q, it should be token, apikey, jws, age or tenantkey", *typ)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *out == "" {
+		_, _ = os.Stdout.Write(key)
+		return
+	}
+	f, err := os.OpenFile(*out, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if _, err = f.Write(key); err == nil {
+		err = f.Close()
+	}
+	if err != nil {
+		_ = os.Remove(*out)
+		log.Fatal(err)
+	}
+}
+
+// randomToken returns 256 random bits encoded as unpadded base64url, usable in the headers as is
+func randomToken() []byte {
+	b := make([]byte, tokenBytes)
+	if _, err := rand.Read(b); err != nil {
+		log.Fatal(err)
+	}
+	token := make([]byte, base64.RawURLEncoding.EncodedLen(len(b)))
+	base64.RawURLEncoding.Encode(token, b)
+	return token
+}
+
+func genAPIKey(owner, tenant string) ([]byte, error) {
+	if owner == "" {
+		return nil, errors.New("-owner is required for the api key")
+	}
+	if err := sst.ValidateTenant(tenant); err != nil {
+		return nil, err
+	}
+	b, err := json.Marshal(httpapi.APIKey{Key: string(randomToken()), Owner: owner, Tenant: tenant})
+	return append(b, '\n'), err
+}
+
+func genJWSKey() ([]byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), nil
+}
+
+func genAgeIdentity() ([]byte, error) {
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintln(os.Stderr, "Public key:", identity.Recipient())
+	return []byte("# public key: " + identity.Recipient().String() + "\n" + identity.String() + "\n"), nil
+}
+
+// genTenantKey returns the AES-256 key of the tenant, its id is the tenant and the date, so the rotated keys differ
+func genTenantKey(tenant string) ([]byte, error) {
+	if err := sst.ValidateTenant(tenant); err != nil {
+		return nil, err
+	}
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	name := tenant
+	if name == "" {
+		name = "default"
+	}
+	b, err := json.Marshal(sst.TenantKey{ID: name + "-" + time.Now().UTC().Format("20060102"), Key: key})
+	return append(b, '\n'), err
+}
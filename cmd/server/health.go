@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// readyHandler responds with 200 if the server can serve the secrets: the database is reachable.
+// It is served by the API listener without authentication, so the probes need no credentials
+func readyHandler(db *sqlx.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if db != nil {
+			ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+			defer cancel()
+			if err := db.PingContext(ctx); err != nil {
+				http.Error(w, "storage is not available", http.StatusServiceUnavailable)
+				return
+			}
+		}
+		_, _ = fmt.Fprintln(w, "ok")
+	}
+}
+
+// healthcheckCommand requests /readyz of the local server and exits with 1 if it isn't ready.
+// It is used by the container HEALTHCHECK and the exec probes, so the image needs no curl
+func healthcheckCommand(args []string) {
+	fs := flag.NewFlagSet("healthcheck", flag.ExitOnError)
+	apiAddr := fs.String("apiAddr", ":8001", "http port for API of the checked server")
+	timeout := fs.Duration("timeout", 3*time.Second, "how long the server may take to respond")
+	_ = fs.Parse(args)
+
+	host, port, err := net.SplitHostPort(*apiAddr)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "unhealthy:", err)
+		os.Exit(1)
+	}
+	if host == "" {
+		host = "127.0.0.1"
+	}
+
+	client := &http.Client{Timeout: *timeout}
+	resp, err := client.Get("http://" + net.JoinHostPort(host, port) + "/readyz")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "unhealthy:", err)
+		os.Exit(1)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		fmt.Fprintln(os.Stderr, "unhealthy:", resp.Status)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,16 @@
+//go:build !testids
+
+package main
+
+import "flag"
+
+// IDConfig is empty in the release binaries, their ids are always random
+type IDConfig struct{}
+
+func (c *IDConfig) RegisterFlags(*flag.FlagSet) {}
+
+func (c *IDConfig) apply() {}
+
+func (c *IDConfig) validate() error {
+	return errSkipped
+}
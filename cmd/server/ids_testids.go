@@ -0,0 +1,38 @@
+//go:build testids
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"log"
+
+	sst "github.com/evsan/secret-server-task"
+)
+
+// IDConfig seeds the generated ids of the binaries built with -tags testids for the reproducible fixtures
+type IDConfig struct {
+	Seed int64
+}
+
+// RegisterFlags registers the seed flag in the flag set
+func (c *IDConfig) RegisterFlags(fs *flag.FlagSet) {
+	fs.Int64Var(&c.Seed, "idSeed", 0, "seed of the generated hashes, share link and job ids, the same seed generates the same ids. 0 keeps them random. Never store the real secrets with it")
+}
+
+// apply seeds the ids, it must run before the storage is opened
+func (c *IDConfig) apply() {
+	if c.Seed == 0 {
+		return
+	}
+	log.Printf("ids are seeded with -idSeed=%d, they are predictable: the real secrets must not be stored", c.Seed)
+	sst.SeedIDs(c.Seed)
+}
+
+// validate refuses the seeded ids in the pre-deploy check, the binary is for the tests only
+func (c *IDConfig) validate() error {
+	if c.Seed == 0 {
+		return errSkipped
+	}
+	return errors.New("-idSeed makes the ids predictable, it is for the tests only")
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	sst "github.com/evsan/secret-server-task"
+	"github.com/evsan/secret-server-task/httpapi"
+	"github.com/evsan/secret-server-task/webhook"
+)
+
+// runJanitor periodically removes the expired secrets until the process exits.
+// Storages which don't implement sst.Purger or delegate the expiry to the backend are ignored.
+// Only the leader replica purges.
+func runJanitor(storage sst.Storage, interval time.Duration, isLeader func() bool, jobs *jobMetrics) {
+	purger, ok := sst.Base(storage).(sst.Purger)
+	if !ok || interval <= 0 {
+		return
+	}
+	if sst.ExpiresNatively(storage) {
+		log.Println("janitor: the storage expires the secrets natively, the sweeps are disabled")
+		return
+	}
+	janitor := jobs.job("janitor")
+	for range time.Tick(interval) {
+		if !isLeader() {
+			continue
+		}
+		removed, err := janitor.observe(purger.PurgeExpired)
+		if err != nil {
+			log.Println("janitor: purge failed: ", err)
+			continue
+		}
+		if removed > 0 {
+			log.Printf("janitor: purged %d expired secrets", removed)
+		}
+	}
+}
+
+// runReleases periodically sends the releases of the dead man's switch secrets whose deadline passed.
+// Only the leader replica releases, so the release is not sent by every replica
+func runReleases(api *httpapi.App, interval time.Duration, isLeader func() bool, jobs *jobMetrics) {
+	if interval <= 0 {
+		return
+	}
+	releases := jobs.job("releases")
+	for range time.Tick(interval) {
+		if !isLeader() {
+			continue
+		}
+		released, err := releases.observe(api.ReleaseSwitches)
+		if err != nil {
+			log.Println("dead man's switch: release failed: ", err)
+		}
+		if released > 0 {
+			log.Printf("dead man's switch: released %d secrets", released)
+		}
+	}
+}
+
+// runDispatcher delivers the due events of the outbox every interval, the way webhook.Dispatcher.Run does,
+// and measures the runs. Every replica dispatches, the delivery errors are logged by the dispatcher
+func runDispatcher(d *webhook.Dispatcher, interval time.Duration, jobs *jobMetrics) {
+	dispatcher := jobs.job("webhook_dispatcher")
+	for {
+		dispatcher.observe(d.Drain)
+		time.Sleep(interval)
+	}
+}
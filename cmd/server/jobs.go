@@ -0,0 +1,77 @@
+package main
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// jobMetrics export the health of the background jobs labeled by the job, so the alerts catch the job
+// which silently stopped running, e.g. `time() - background_job_last_success_timestamp_seconds{job="janitor"} > 3600`
+type jobMetrics struct {
+	lastRun     *prometheus.GaugeVec
+	lastSuccess *prometheus.GaugeVec
+	duration    *prometheus.GaugeVec
+	items       *prometheus.CounterVec
+	errors      *prometheus.CounterVec
+}
+
+func newJobMetrics() *jobMetrics {
+	labels := []string{"job"}
+	m := &jobMetrics{
+		lastRun: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "background_job_last_run_timestamp_seconds",
+			Help: "Time of the end of the last run of the background job",
+		}, labels),
+		lastSuccess: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "background_job_last_success_timestamp_seconds",
+			Help: "Time of the end of the last successful run of the background job",
+		}, labels),
+		duration: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "background_job_duration_seconds",
+			Help: "Duration of the last run of the background job",
+		}, labels),
+		items: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "background_job_items_processed_total",
+			Help: "The total number of the items processed by the background job, e.g. the purged secrets",
+		}, labels),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "background_job_errors_total",
+			Help: "The total number of the failed runs of the background job",
+		}, labels),
+	}
+	prometheus.MustRegister(m.lastRun, m.lastSuccess, m.duration, m.items, m.errors)
+	return m
+}
+
+// job is the background job whose runs are measured
+type job struct {
+	name    string
+	metrics *jobMetrics
+}
+
+// job returns the measured job. The counters of the job are exported as 0 before its first run,
+// the timestamps appear after it, so the disabled jobs don't fire the staleness alerts
+func (m *jobMetrics) job(name string) job {
+	m.items.WithLabelValues(name)
+	m.errors.WithLabelValues(name)
+	return job{name: name, metrics: m}
+}
+
+// observe runs the job once and records its duration, the processed items and the result
+func (j job) observe(run func() (int, error)) (int, error) {
+	started := time.Now()
+	n, err := run()
+	m := j.metrics
+	m.duration.WithLabelValues(j.name).Set(time.Since(started).Seconds())
+	m.lastRun.WithLabelValues(j.name).SetToCurrentTime()
+	if n > 0 {
+		m.items.WithLabelValues(j.name).Add(float64(n))
+	}
+	if err != nil {
+		m.errors.WithLabelValues(j.name).Inc()
+		return n, err
+	}
+	m.lastSuccess.WithLabelValues(j.name).SetToCurrentTime()
+	return n, nil
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+
+	sst "github.com/evsan/secret-server-task"
+	"github.com/evsan/secret-server-task/httpapi"
+	"github.com/evsan/secret-server-task/logoutput"
+)
+
+// LogConfig selects where the logs are written
+type LogConfig struct {
+	// Output is stdout, syslog or journald
+	Output     string
+	SyslogAddr string
+	Tag        string
+	// Level and Sample are the defaults of the access log, the level is changed by PUT /admin/log
+	Level  string
+	Sample int
+	// AccessFormat is text, json or clf
+	AccessFormat string
+	// TrustedProxies is the comma separated list of the proxies whose X-Forwarded-For is logged as the client
+	TrustedProxies string
+
+	out logoutput.Output
+}
+
+// RegisterFlags registers the log output flags in the flag set
+func (c *LogConfig) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.Output, "logOutput", "stdout", "where the logs are written: stdout, syslog (RFC 5424 to -syslogAddr) or journald")
+	fs.StringVar(&c.SyslogAddr, "syslogAddr", "udp://localhost:514", "syslog server of -logOutput=syslog: udp://, tcp:// or tls://host:port")
+	fs.StringVar(&c.Tag, "logTag", "secret-server", "application name of the syslog messages and the journald entries")
+	fs.StringVar(&c.Level, "logLevel", "info", "level of the access log: debug, info, warn or error. It is changed at runtime by PUT /admin/log")
+	fs.IntVar(&c.Sample, "accessLogSample", 1, "log every n-th successful request on the info level, the failed ones are always logged")
+	fs.StringVar(&c.AccessFormat, "accessLogFormat", "text", "format of the access log: text, json (JSON lines) or clf (Combined Log Format)")
+	fs.StringVar(&c.TrustedProxies, "trustedProxies", "", "comma separated CIDRs of the proxies whose X-Forwarded-For is logged as the client address")
+}
+
+// apply redirects the standard logger. The timestamps are left to syslog and journald
+func (c *LogConfig) apply() {
+	out, err := c.open()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if out == nil {
+		return
+	}
+	c.out = out
+	log.SetOutput(logoutput.Writer(c.out))
+	log.SetFlags(0)
+}
+
+// open connects to the configured output, it is nil for stdout
+func (c *LogConfig) open() (logoutput.Output, error) {
+	var out logoutput.Output
+	var err error
+	switch c.Output {
+	case "", "stdout":
+		return nil, nil
+	case "syslog":
+		out, err = logoutput.DialSyslog(c.SyslogAddr, c.Tag, nil)
+	case "journald":
+		out, err = logoutput.DialJournald("", c.Tag)
+	default:
+		return nil, fmt.Errorf("unknown -logOutput %q, it should be stdout, syslog or journald", c.Output)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("-logOutput=%s is not available: %v", c.Output, err)
+	}
+	return out, nil
+}
+
+// logger creates the logger with the prefix writing to the configured output
+func (c *LogConfig) logger(prefix string) *log.Logger {
+	if c.out == nil {
+		return log.New(os.Stdout, prefix, log.LstdFlags)
+	}
+	return log.New(logoutput.Writer(c.out), prefix, 0)
+}
+
+// control creates the runtime control of the access log level
+func (c *LogConfig) control() *httpapi.LogControl {
+	control, err := httpapi.NewLogControl(sst.SystemClock, httpapi.LogLevel(c.Level), c.Sample)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return control
+}
+
+// accessConfig parses the format and the trusted proxies of the access log
+func (c *LogConfig) accessConfig() (httpapi.AccessLogConfig, error) {
+	format, err := httpapi.ParseAccessLogFormat(c.AccessFormat)
+	if err != nil {
+		return httpapi.AccessLogConfig{}, err
+	}
+	proxies, err := httpapi.ParseTrustedProxies(c.TrustedProxies)
+	if err != nil {
+		return httpapi.AccessLogConfig{}, fmt.Errorf("-trustedProxies: %v", err)
+	}
+	return httpapi.AccessLogConfig{Format: format, TrustedProxies: proxies}, nil
+}
+
+// accessLogger creates the access log middleware of the API. The json and clf lines have no prefix
+// and no timestamp of the logger, so the pipelines parse them as is
+func (c *LogConfig) accessLogger(prefix string, control *httpapi.LogControl) func(http.Handler) http.Handler {
+	config, err := c.accessConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+	logger := c.logger(prefix)
+	if config.Format != httpapi.AccessLogText {
+		logger.SetPrefix("")
+		logger.SetFlags(0)
+	}
+	return httpapi.StructuredAccessLogger(logger, control, config)
+}
+
+// close closes the connection of the output, the later lines are written to stderr
+func (c *LogConfig) close() {
+	if c.out != nil {
+		log.SetOutput(os.Stderr)
+		log.SetFlags(log.LstdFlags)
+		_ = c.out.Close()
+	}
+}
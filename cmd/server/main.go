@@ -1,36 +1,231 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
 	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
 
 	sst "github.com/evsan/secret-server-task"
 	"github.com/jmoiron/sqlx"
 	_ "github.com/lib/pq"
 )
 
+// commands holds the available subcommands. The first argument selects the command,
+// "serve" is used if it is omitted, so `server -apiAddr=:8001` works as before.
+var commands = map[string]func(args []string){
+	"serve":  serveCommand,
+	"check":  checkCommand,
+	"purge":  purgeCommand,
+	"export": exportCommand,
+	"import": importCommand,
+	"bench":  benchCommand,
+	// smoke verifies the deployed server end to end
+	"smoke": smokeCommand,
+	// gen-key generates the tokens and the keys the flags expect
+	"gen-key": genKeyCommand,
+	// healthcheck probes the server started by serve
+	"healthcheck": healthcheckCommand,
+	// service installs serve as the service of the platform
+	"service": serviceCommand,
+}
+
 func main() {
-	dbUrl := flag.String("dbUrl", "", "postgres db url. If empty in-memory storage will be used")
-	apiAddr := flag.String("apiAddr", ":8001", "http port for API")
-	metricsAddr := flag.String("metricsAddr", ":9001", "http port for /metrics endpoint")
-	debug := flag.Bool("debug", false, "enable debug mode")
+	name, args := "serve", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+
+	command, ok := commands[name]
+	if !ok {
+		names := make([]string, 0, len(commands))
+		for n := range commands {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		fmt.Fprintf(os.Stderr, "unknown command %q, available commands: %s\n", name, strings.Join(names, ", "))
+		os.Exit(2)
+	}
+	if name == "serve" && asService != nil {
+		command = asService(command)
+	}
+	command(args)
+}
+
+// StorageConfig holds the flags which are needed to open the storage
+type StorageConfig struct {
+	DbUrl string
+	// Shards is the comma separated list of name=url of the sharded postgres databases
+	Shards    string
+	Pool      PoolConfig
+	Retention time.Duration
+	// RetryAttempts and RetryBackoff control the retries of the transient db errors
+	RetryAttempts int
+	RetryBackoff  time.Duration
+	// NotifyChannel is the NOTIFY channel of the storage events, empty disables them
+	NotifyChannel string
+	// Outbox records the webhook view notifications in the outbox table
+	Outbox bool
+	// LockedMemory keeps the texts of the in-memory storage in the locked memory
+	LockedMemory bool
+	// TenantKeysFile is the JSON file with the keys encrypting the texts of the tenants, empty keeps them plain
+	TenantKeysFile string
+	// ExpiryHook is called for the expired secrets, it is set by the commands which need it
+	ExpiryHook sst.ExpiryHook
+
+	// keys are the tenant keys of the opened storage, POST /admin/reload replaces them
+	keys *sst.TenantKeys
+	// dbs are the pools of the opened postgres databases, all shards included
+	dbs []*sqlx.DB
+}
+
+// RegisterFlags registers the storage flags in the flag set
+func (c *StorageConfig) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.DbUrl, "dbUrl", "", "postgres db url. If empty in-memory storage will be used")
+	fs.StringVar(&c.Shards, "dbShards", "", "comma separated list of name=url of the postgres databases the secrets are sharded across, -dbUrl is the shard named \"default\" if set. The names must never change")
+	fs.IntVar(&c.Pool.MaxOpenConns, "dbMaxOpenConns", 20, "maximum number of open db connections. 0 means unlimited")
+	fs.IntVar(&c.Pool.MaxIdleConns, "dbMaxIdleConns", 10, "maximum number of idle db connections. 0 means no idle connections are retained")
+	fs.DurationVar(&c.Pool.ConnMaxLifetime, "dbConnMaxLifetime", 30*time.Minute, "maximum amount of time a db connection may be reused. 0 means forever")
+	fs.DurationVar(&c.Pool.ConnMaxIdleTime, "dbConnMaxIdleTime", 5*time.Minute, "maximum amount of time a db connection may be idle. 0 means forever")
+	fs.DurationVar(&c.Retention, "retention", 0, "how long the scrubbed tombstones of consumed and expired secrets are kept, they are read by GET /admin/secret/{hash}/tombstone. 0 means immediate deletion")
+	fs.IntVar(&c.RetryAttempts, "dbRetryAttempts", 3, "how many times the db operation is attempted if it fails with the transient error. 1 disables the retries")
+	fs.DurationVar(&c.RetryBackoff, "dbRetryBackoff", 20*time.Millisecond, "base of the jittered exponential backoff between the db retries")
+	fs.BoolVar(&c.Outbox, "webhookOutbox", false, "record the webhook view notifications in the postgres outbox table in the same transaction as the view and deliver them with retries")
+	fs.StringVar(&c.NotifyChannel, "dbNotifyChannel", "", "postgres channel the secret lifecycle events are published to with NOTIFY. If empty no events are published")
+	fs.BoolVar(&c.LockedMemory, "lockedMemory", false, "keep the texts of the in-memory storage in the locked memory which is never swapped or dumped. Every secret takes at least three pages of RLIMIT_MEMLOCK")
+	fs.StringVar(&c.TenantKeysFile, "tenantKeysFile", "", "JSON file with the AES-256 keys encrypting the secret texts of every tenant: {\"<tenant>\": [{\"id\": \"...\", \"key\": \"<base64>\"}]}, \"\" is the default tenant. The first key seals, removing all keys of the tenant crypto-shreds its secrets")
+}
+
+// tenantKeys loads the keys of -tenantKeysFile, nil if it is not set
+func (c *StorageConfig) tenantKeys() (*sst.TenantKeys, error) {
+	if c.TenantKeysFile == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(c.TenantKeysFile)
+	if err != nil {
+		return nil, err
+	}
+	var keys map[string][]sst.TenantKey
+	if err = json.Unmarshal(data, &keys); err != nil {
+		return nil, fmt.Errorf("%s: %w", c.TenantKeysFile, err)
+	}
+	return sst.NewTenantKeys(keys)
+}
+
+// Persistent reports whether the postgres storage is configured
+func (c *StorageConfig) Persistent() bool {
+	return c.DbUrl != "" || c.Shards != ""
+}
+
+// Open creates the configured storage. db is nil for the in-memory storage,
+// it is the first shard for the sharded storage
+func (c *StorageConfig) Open() (storage sst.Storage, db *sqlx.DB) {
+	if c.Shards != "" {
+		if c.Outbox {
+			log.Fatal("-webhookOutbox is not supported with -dbShards")
+		}
+		return c.openShards()
+	}
+	if c.DbUrl == "" {
+		opts := []sst.MemOption{sst.WithMemExpiryHook(c.ExpiryHook), sst.WithMemRetention(c.Retention)}
+		if c.LockedMemory {
+			opts = append(opts, sst.WithLockedMemory())
+		}
+		if keys := c.mustTenantKeys(); keys != nil {
+			opts = append(opts, sst.WithMemTenantKeys(keys))
+		}
+		return sst.NewMemStorage(opts...), nil
+	}
+	return c.openPg(c.DbUrl)
+}
+
+func (c *StorageConfig) openPg(url string) (sst.Storage, *sqlx.DB) {
+	db := sqlx.MustConnect("postgres", url)
+	c.Pool.Apply(db)
+	c.dbs = append(c.dbs, db)
+	opts := []sst.PgOption{sst.WithRetention(c.Retention), sst.WithPgExpiryHook(c.ExpiryHook), sst.WithPgRetry(c.RetryAttempts, c.RetryBackoff)}
+	if c.NotifyChannel != "" {
+		opts = append(opts, sst.WithPgNotify(c.NotifyChannel))
+	}
+	if c.Outbox {
+		opts = append(opts, sst.WithPgOutbox())
+	}
+	if keys := c.mustTenantKeys(); keys != nil {
+		opts = append(opts, sst.WithPgTenantKeys(keys))
+	}
+	return sst.NewPgStorage(db, opts...), db
+}
+
+// Close closes the pools of all databases opened by Open, also of the shards not returned by it
+func (c *StorageConfig) Close() error {
+	var errs []error
+	for _, db := range c.dbs {
+		errs = append(errs, db.Close())
+	}
+	c.dbs = nil
+	return errors.Join(errs...)
+}
 
-	flag.Parse()
+// mustTenantKeys loads the tenant keys, the storage is never opened without the configured keys.
+// The shards share the keys
+func (c *StorageConfig) mustTenantKeys() *sst.TenantKeys {
+	if c.keys != nil {
+		return c.keys
+	}
+	keys, err := c.tenantKeys()
+	if err != nil {
+		log.Fatal("can't load the tenant keys: ", err)
+	}
+	c.keys = keys
+	return keys
+}
 
-	var storage sst.Storage
-	var db *sqlx.DB
+// database is the configured postgres database, the shard name is "default" for -dbUrl
+type database struct {
+	name, url string
+}
 
-	if *dbUrl != "" {
-		db = sqlx.MustConnect("postgres", *dbUrl)
-		storage = sst.NewPgStorage(db)
-	} else {
-		storage = sst.NewMemStorage()
+// databases returns the configured postgres databases, none for the in-memory storage
+func (c *StorageConfig) databases() ([]database, error) {
+	var dbs []database
+	if c.DbUrl != "" {
+		dbs = append(dbs, database{"default", c.DbUrl})
 	}
+	if c.Shards == "" {
+		return dbs, nil
+	}
+	for _, item := range strings.Split(c.Shards, ",") {
+		name, url, ok := strings.Cut(strings.TrimSpace(item), "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid shard %q, name=url is expected", item)
+		}
+		dbs = append(dbs, database{name, url})
+	}
+	return dbs, nil
+}
 
-	app := App{
-		Storage:     storage,
-		ApiAddr:     *apiAddr,
-		MetricsAddr: *metricsAddr,
-		Debug:       *debug,
+func (c *StorageConfig) openShards() (sst.Storage, *sqlx.DB) {
+	dbs, err := c.databases()
+	if err != nil {
+		log.Fatal(err)
+	}
+	var shards []sst.Shard
+	var first *sqlx.DB
+	for _, d := range dbs {
+		st, db := c.openPg(d.url)
+		if first == nil {
+			first = db
+		}
+		shards = append(shards, sst.Shard{Name: d.name, Storage: st})
+	}
+	storage, err := sst.NewShardedStorage(shards...)
+	if err != nil {
+		log.Fatal(err)
 	}
-	app.Run()
+	return storage, first
 }
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	sst "github.com/evsan/secret-server-task"
+	"github.com/evsan/secret-server-task/webhook"
+)
+
+// unviewedExpiryHook notifies the creator if the secret expired without being viewed:
+// the per-secret webhook if its host is still allowed, and the server configured URL with the owner and the tenant.
+// The in-process events are lost for the secrets purged by the separate purge command.
+func unviewedExpiryHook(n *webhook.Notifier, serverURL string) sst.ExpiryHook {
+	return func(s sst.Secret) {
+		if s.Views > 0 || s.RemainingViews <= 0 {
+			return
+		}
+		e := webhook.Event{
+			Event:          webhook.ExpiredUnviewed,
+			Hash:           strings.TrimPrefix(s.Hash, s.Tenant+"/"),
+			RemainingViews: s.RemainingViews,
+			At:             time.Now(),
+		}
+		if s.WebhookURL != "" && n.Validate(s.WebhookURL) == nil {
+			n.Notify(s.WebhookURL, e)
+		}
+		e.Owner, e.Tenant, e.Labels = s.Owner, s.Tenant, s.Labels
+		n.Notify(serverURL, e)
+	}
+}
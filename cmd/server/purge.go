@@ -0,0 +1,41 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+
+	sst "github.com/evsan/secret-server-task"
+)
+
+// purgeCommand removes all expired and consumed secrets from the database.
+// It makes sense only for the persistent storages, the in-memory one lives within the server process.
+func purgeCommand(args []string) {
+	fs := flag.NewFlagSet("purge", flag.ExitOnError)
+
+	var storageConfig StorageConfig
+	storageConfig.RegisterFlags(fs)
+
+	var pushConfig PushConfig
+	pushConfig.RegisterFlags(fs, "purge")
+
+	_ = fs.Parse(args)
+
+	if !storageConfig.Persistent() {
+		log.Fatal("purge requires -dbUrl or -dbShards, use POST /admin/purge-expired for the in-memory storage")
+	}
+
+	storage, _ := storageConfig.Open()
+
+	run := pushConfig.start()
+	removed, err := storage.(sst.Purger).PurgeExpired()
+	run.finish(removed, err)
+	// log.Fatal skips the deferred calls, the pools of all shards are closed before it
+	if closeErr := storageConfig.Close(); closeErr != nil {
+		log.Println("closing the database: ", closeErr)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("removed %d expired secrets\n", removed)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+)
+
+// PushConfig holds the Pushgateway the batch commands report their runs to
+type PushConfig struct {
+	URL string
+	Job string
+}
+
+// RegisterFlags registers the Pushgateway flags in the flag set, job is the default job name
+func (c *PushConfig) RegisterFlags(fs *flag.FlagSet, job string) {
+	fs.StringVar(&c.URL, "pushgateway", "", "Pushgateway URL the metrics of the run are pushed to, e.g. http://pushgateway:9091. If empty nothing is pushed")
+	fs.StringVar(&c.Job, "pushJob", "secret_server_"+job, "job name of the pushed metrics")
+}
+
+// batchRun measures the run of the batch command
+type batchRun struct {
+	config  *PushConfig
+	startAt time.Time
+}
+
+// start begins the measured run
+func (c *PushConfig) start() *batchRun {
+	return &batchRun{config: c, startAt: time.Now()}
+}
+
+// finish pushes the metrics of the run: its duration, the processed items and whether it succeeded.
+// The successful run replaces the metrics of the job, the failed one keeps the time of the last success,
+// so the alerts on the stale batches work. The push error is logged only, it doesn't fail the run
+func (b *batchRun) finish(items int, runErr error) {
+	if b.config.URL == "" {
+		return
+	}
+	duration := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "secret_server_batch_duration_seconds",
+		Help: "Duration of the last run of the batch command",
+	})
+	duration.Set(time.Since(b.startAt).Seconds())
+	processed := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "secret_server_batch_items",
+		Help: "Number of the secrets processed by the last run of the batch command",
+	})
+	processed.Set(float64(items))
+	success := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "secret_server_batch_success",
+		Help: "Whether the last run of the batch command succeeded",
+	})
+
+	pusher := push.New(b.config.URL, b.config.Job).Collector(duration).Collector(processed).Collector(success)
+	if host, err := os.Hostname(); err == nil {
+		pusher = pusher.Grouping("instance", host)
+	}
+
+	var err error
+	if runErr == nil {
+		lastSuccess := prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "secret_server_batch_last_success_timestamp_seconds",
+			Help: "Time of the last successful run of the batch command",
+		})
+		lastSuccess.SetToCurrentTime()
+		success.Set(1)
+		err = pusher.Collector(lastSuccess).Push()
+	} else {
+		err = pusher.Add()
+	}
+	if err != nil {
+		log.Println("pushing the metrics failed: ", err)
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+
+	sst "github.com/evsan/secret-server-task"
+	"github.com/evsan/secret-server-task/replication"
+)
+
+// ReplicationConfig holds the settings of the cross-region replication
+type ReplicationConfig struct {
+	replication.Config
+}
+
+// RegisterFlags registers the replication flags in the flag set
+func (c *ReplicationConfig) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.Region, "region", "", "name of the local region, required by the replication")
+	fs.StringVar(&c.PeerAdminURL, "replicationPeerAdminUrl", "", "admin API of the peer region the secrets are replicated to. If empty the replication is disabled")
+	fs.StringVar(&c.PeerToken, "replicationPeerToken", "", "bearer token of the peer admin API")
+	fs.StringVar(&c.PeerAPIURL, "replicationPeerApiUrl", "", "public API of the peer region including the path prefix, the views of its replicas are served there")
+}
+
+// Enabled reports whether the secrets are replicated
+func (c ReplicationConfig) Enabled() bool {
+	return c.PeerAdminURL != ""
+}
+
+// wrap returns the replicating storage if the replication is enabled
+func (c ReplicationConfig) wrap(st sst.Storage) sst.Storage {
+	if !c.Enabled() {
+		return st
+	}
+	if c.Region == "" || c.PeerAPIURL == "" {
+		log.Fatal("replication requires -region and -replicationPeerApiUrl")
+	}
+	return replication.New(st, c.Config)
+}
+
+// adminHandler adds the endpoint receiving the events of the peer to the admin API
+func (c ReplicationConfig) adminHandler(st sst.Storage, admin http.Handler) http.Handler {
+	if !c.Enabled() {
+		return admin
+	}
+	router := http.NewServeMux()
+	router.Handle("POST /admin/replication", replication.Handler(st))
+	router.Handle("/", admin)
+	return router
+}
@@ -0,0 +1,623 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"filippo.io/age"
+	sst "github.com/evsan/secret-server-task"
+	"github.com/evsan/secret-server-task/backup"
+	"github.com/evsan/secret-server-task/chaos"
+	"github.com/evsan/secret-server-task/contentpolicy"
+	"github.com/evsan/secret-server-task/httpapi"
+	"github.com/evsan/secret-server-task/leader"
+	"github.com/evsan/secret-server-task/oidc"
+	"github.com/evsan/secret-server-task/opa"
+	"github.com/evsan/secret-server-task/openmetrics"
+	"github.com/evsan/secret-server-task/webhook"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// serveFlags holds the configuration of serve, it is shared with check which validates it
+type serveFlags struct {
+	storageConfig       StorageConfig
+	idConfig            IDConfig
+	apiAddr             *string
+	metricsAddr         *string
+	adminAddr           *string
+	pathPrefix          *string
+	baseURL             *string
+	debug               *bool
+	leaderInterval      *time.Duration
+	purgeInterval       *time.Duration
+	releaseInterval     *time.Duration
+	apiKeysFile         *string
+	tenantList          *string
+	readOnce            *bool
+	maxViews            *int
+	policyFile          *string
+	routeMiddleware     *string
+	usageExportFile     *string
+	usageExportInterval *time.Duration
+	requireAPIKey       *bool
+	idempotencyWindow   *time.Duration
+	viewRequester       *bool
+	viewHeaders         *bool
+	hashLength          *int
+	hashAlphabet        *string
+	maxBodySize         *int64
+	maxDecompressedSize *int64
+	claimWindow         *time.Duration
+	resumeWindow        *time.Duration
+	resumeMaxBytes      *int64
+	expiryWebhookURL    *string
+	webhookHosts        *string
+	contentPolicy       *string
+	labelIndexKeyFile   *string
+	policyURL           *string
+	rateLimitExempt     *string
+	policyTimeout       *time.Duration
+	oidcIssuer          *string
+	oidcClientID        *string
+	oidcSecretFile      *string
+	oidcSessionKeyFile  *string
+	oidcSessionTTL      *time.Duration
+	csrf                *bool
+	csrfKeyFile         *string
+	cacheAudit          *bool
+	previewTokenTTL     *time.Duration
+	previewTokenKeyFile *string
+	drainTimeout        *time.Duration
+	backupIdentity      *string
+	jwsKeyFile          *string
+	clientCertHeader    *string
+	locale              *string
+	hypermedia          *bool
+	webUI               *bool
+	staticDir           *string
+	timestamps          *string
+	omitZeroTimestamps  *bool
+	writeWorkers        *int
+	writeQueue          *int
+	maxRequestTimeout   *time.Duration
+	metricsAuth         AuthConfig
+	adminAuth           AuthConfig
+	chaosConfig         ChaosConfig
+	replicationConfig   ReplicationConfig
+	shadowConfig        ShadowConfig
+	auditConfig         AuditConfig
+	logConfig           LogConfig
+	fipsConfig          FIPSConfig
+	statsdConfig        StatsdConfig
+	compactionConfig    CompactionConfig
+}
+
+// newServeFlags registers the flags of serve in the flag set
+func newServeFlags(fs *flag.FlagSet) *serveFlags {
+	f := &serveFlags{
+		apiAddr:             fs.String("apiAddr", ":8001", "http port for API"),
+		metricsAddr:         fs.String("metricsAddr", ":9001", "http port for /metrics endpoint. If empty the metrics are not served, e.g. when they are exported with -statsdAddr only"),
+		adminAddr:           fs.String("adminAddr", "", "http port for the admin API. If empty the admin API is disabled"),
+		pathPrefix:          fs.String("pathPrefix", "", "path prefix for all API routes, e.g. /tools/secrets"),
+		baseURL:             fs.String("baseUrl", "", "absolute URL the API is reachable at, e.g. https://example.com/tools/secrets. Overrides -pathPrefix"),
+		debug:               fs.Bool("debug", false, "enable debug mode"),
+		leaderInterval:      fs.Duration("leaderInterval", 10*time.Second, "how often the replicas sharing -dbUrl compete for running the background jobs and the leader checks its lock"),
+		purgeInterval:       fs.Duration("purgeInterval", time.Hour, "how often expired secrets are purged in the background. 0 disables the janitor"),
+		releaseInterval:     fs.Duration("releaseInterval", time.Minute, "how often the dead man's switch secrets past their check-in deadline are released to their webhooks. 0 disables the releases"),
+		apiKeysFile:         fs.String("apiKeysFile", "", "JSON file with the api keys: [{\"key\": \"...\", \"owner\": \"...\", \"tenant\": \"...\"}]"),
+		tenantList:          fs.String("tenants", "", "comma separated list of the tenants served under /t/{tenant}/ in addition to the tenants of the api keys"),
+		readOnce:            fs.Bool("readOnce", false, "force expireAfterViews=1 for all secrets regardless of the request and the policies"),
+		maxViews:            fs.Int("maxViews", 0, "cap expireAfterViews of all secrets regardless of the request and the policies. 0 means no cap"),
+		routeMiddleware:     fs.String("routeMiddleware", "", "JSON file mapping the middlewares (apiKey, rateLimit, bodyLimit, timeout) to the route groups of the API, e.g. [{\"routes\": [\"GET\"], \"middleware\": [{\"name\": \"rateLimit\", \"perMinute\": 60}]}]"),
+		policyFile:          fs.String("policyFile", "", "JSON file with the default policy, the tenant overrides and the templates: {\"default\": {...}, \"tenants\": {\"name\": {...}}, \"templates\": {\"name\": {...}}}"),
+		usageExportFile:     fs.String("usageExportFile", "", "file the usage report is periodically written to, CSV if it ends with .csv, JSON otherwise"),
+		usageExportInterval: fs.Duration("usageExportInterval", time.Hour, "how often the usage report is written to -usageExportFile"),
+		requireAPIKey:       fs.Bool("requireApiKey", false, "forbid creating secrets without an api key"),
+		idempotencyWindow:   fs.Duration("idempotencyWindow", time.Hour, "how long POST /secret responses are replayed for the same Idempotency-Key and api key, without the api key only to the same client address. 0 disables the replay"),
+		viewRequester:       fs.Bool("viewRequester", false, "record the address and the User-Agent of the recipients in the view history returned to the owners by GET /secret/{hash}/views"),
+		viewHeaders:         fs.Bool("viewHeaders", true, "send X-Secret-Remaining-Views and X-Secret-Expires-At with all served secrets. false leaves them out of all responses, e.g. where the proxies log the headers"),
+		hashLength:          fs.Int("hashLength", sst.DefaultHashFormat.Length, "length of the generated hashes"),
+		hashAlphabet:        fs.String("hashAlphabet", sst.DefaultHashFormat.Alphabet, "characters of the generated hashes, with -hashLength they should give at least 64 bits of entropy. The hashes of the default format stay valid"),
+		maxBodySize:         fs.Int64("maxBodySize", 1<<20, "maximum size of the request bodies in bytes, the larger requests are refused with 413. 0 means no limit"),
+		maxDecompressedSize: fs.Int64("maxDecompressedSize", 0, "maximum size of the decompressed gzip request bodies (Content-Encoding: gzip) in bytes, the compressed size is limited by -maxBodySize. 0 disables the decompression"),
+		claimWindow:         fs.Duration("claimWindow", time.Minute, "how long the claim token of the secrets created with claim=true can be revealed"),
+		resumeWindow:        fs.Duration("downloadResumeWindow", 0, "how long the interrupted download of the file secret is resumed with Range and its X-Download-Token without consuming another view. The file is kept in the locked memory meanwhile, 0 disables the resume"),
+		resumeMaxBytes:      fs.Int64("downloadResumeMaxBytes", 64<<20, "maximum size of all files kept for the resumed downloads in bytes, the downloads over it are not resumable"),
+		expiryWebhookURL:    fs.String("expiryWebhookUrl", "", "URL notified with the owner and the tenant when a secret expires without being viewed"),
+		webhookHosts:        fs.String("webhookHosts", "", "comma separated list of the hosts the per-secret webhook URLs may point to, *.example.com allows the subdomains"),
+		contentPolicy:       fs.String("contentPolicy", "", "content policy of the new secrets: \"default\" rejects card numbers, AWS access key IDs and private keys, otherwise JSON file with the rules: [{\"name\": \"...\", \"pattern\": \"...\", \"action\": \"reject|flag\"}]"),
+		labelIndexKeyFile:   fs.String("labelIndexKeyFile", "", "file with the key (at least 32 bytes) of the blind index the label values are stored as, so GET /admin/secrets?label= searches them without the plaintext"),
+		policyURL:           fs.String("policyUrl", "", "URL of the policy document of Open Policy Agent evaluated on the creation and the retrieval, e.g. http://localhost:8181/v1/data/secrets/allow. The requests are refused while it can't be evaluated"),
+		policyTimeout:       fs.Duration("policyTimeout", time.Second, "how long the evaluation of -policyUrl may take"),
+		oidcIssuer:          fs.String("oidcIssuer", "", "OpenID Connect provider the users of the web UI log in with, e.g. https://accounts.example.com. The creation without the api key needs the login, the retrieval stays public. Requires -baseUrl"),
+		oidcClientID:        fs.String("oidcClientId", "", "client id of the web UI registered at -oidcIssuer with the redirect URI {baseUrl}/auth/callback"),
+		oidcSecretFile:      fs.String("oidcClientSecretFile", "", "file with the client secret of -oidcClientId, empty for the public clients"),
+		oidcSessionKeyFile:  fs.String("oidcSessionKeyFile", "", "file with the key (at least 32 bytes) the session cookies are signed with, shared by the instances. Empty generates it at start"),
+		oidcSessionTTL:      fs.Duration("oidcSessionTtl", 8*time.Hour, "how long the web UI session lasts after the login"),
+		csrf:                fs.Bool("csrf", false, "require the token of GET /csrf in the browser requests changing the data, the requests with the api key are exempt"),
+		csrfKeyFile:         fs.String("csrfKeyFile", "", "file with the key (at least 32 bytes) the -csrf tokens are signed with, shared by the instances. Empty generates it at start"),
+		cacheAudit:          fs.Bool("cacheAudit", false, "check at start and on every response that the secret responses forbid the caching, the regressions are logged and counted by secret_cacheable_responses_total"),
+		rateLimitExempt:     fs.String("rateLimitExemptNetworks", "", "comma separated CIDRs of the clients exempt from the rate limit of the tenants, e.g. the internal CI systems. X-Forwarded-For of -trustedProxies is the client address. The api keys are exempted with rateLimitExempt in -apiKeysFile"),
+		previewTokenTTL:     fs.Duration("previewTokenTtl", 0, "how long the single-use support preview tokens of POST /admin/secret/{hash}/preview-token are valid, e.g. 15m. 0 disables them"),
+		previewTokenKeyFile: fs.String("previewTokenKeyFile", "", "file with the key (at least 32 bytes) the -previewTokenTtl tokens are signed with, shared by the instances. Empty generates it at start"),
+		drainTimeout:        fs.Duration("drainTimeout", 30*time.Second, "how long the in-flight requests are served after SIGTERM or after the upgrade started by SIGHUP"),
+		backupIdentity:      fs.String("backupIdentity", "", "age identity file used by POST /admin/import"),
+		jwsKeyFile:          fs.String("jwsKeyFile", "", "PEM file with the P-256 private key the secret responses are signed with (ES256), the public key is served at /.well-known/jwks.json"),
+		writeWorkers:        fs.Int("writeWorkers", 0, "number of the workers storing the created secrets, bounds the concurrent inserts under the burst load. 0 means every request writes itself"),
+		writeQueue:          fs.Int("writeQueue", 1000, "number of the creations waiting for the -writeWorkers, the creations arriving to the full queue get 503 with Retry-After"),
+		maxRequestTimeout:   fs.Duration("maxRequestTimeout", 30*time.Second, "maximum deadline the clients set with the X-Request-Timeout header. 0 ignores the header"),
+		timestamps:          fs.String("timestamps", string(httpapi.TimestampRFC3339), "encoding of the timestamps of the secret responses: rfc3339 or unix (the seconds since the epoch)"),
+		omitZeroTimestamps:  fs.Bool("omitZeroTimestamps", false, "omit expiresAt and notBefore of the secret responses if they are zero"),
+		webUI:               fs.Bool("webUI", false, "serve the embedded web UI at the root of the API"),
+		staticDir:           fs.String("staticDir", "", "directory served at the root of the API instead of the embedded web UI, e.g. the customized UI"),
+		hypermedia:          fs.Bool("hypermedia", false, "serve the secrets as HAL (application/hal+json) and JSON:API (application/vnd.api+json) with the links of the operations"),
+		locale:              fs.String("locale", "", "fallback locale of the error messages translated by Accept-Language: "+strings.Join(httpapi.Locales(), ", ")+". Empty sends them in English"),
+		clientCertHeader:    fs.String("clientCertHeader", "", "header with the subject of the client certificate verified by the TLS terminating proxy, e.g. X-SSL-Client-S-DN, the secrets bound with recipientCert are served to it. The proxy must overwrite it"),
+	}
+	f.storageConfig.RegisterFlags(fs)
+	f.metricsAuth.RegisterFlags(fs, "metrics", "the metrics listener")
+	f.adminAuth.RegisterFlags(fs, "admin", "the admin API")
+	f.chaosConfig.RegisterFlags(fs)
+	f.replicationConfig.RegisterFlags(fs)
+	f.shadowConfig.RegisterFlags(fs)
+	f.auditConfig.RegisterFlags(fs)
+	f.logConfig.RegisterFlags(fs)
+	f.fipsConfig.RegisterFlags(fs)
+	f.statsdConfig.RegisterFlags(fs)
+	f.compactionConfig.RegisterFlags(fs)
+	f.idConfig.RegisterFlags(fs)
+	return f
+}
+
+func serveCommand(args []string) {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	f := newServeFlags(fs)
+
+	_ = fs.Parse(args)
+	f.logConfig.apply()
+	defer f.logConfig.close()
+	f.idConfig.apply()
+
+	f.fipsConfig.check()
+	f.fipsConfig.refuse("-backupIdentity (age backups)", *f.backupIdentity != "")
+
+	hashFormat := sst.HashFormat{Length: *f.hashLength, Alphabet: *f.hashAlphabet}
+	if err := hashFormat.Validate(); err != nil {
+		log.Fatal(err)
+	}
+
+	if *f.adminAddr != "" && f.adminAuth.BasicAuth.User == "" && f.adminAuth.BearerToken == "" {
+		log.Fatal("admin API requires -adminBasicAuth or -adminToken")
+	}
+
+	var identities []age.Identity
+	if *f.backupIdentity != "" {
+		var err error
+		if identities, err = backup.ReadIdentities(*f.backupIdentity); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	var apiKeys httpapi.APIKeys
+	if *f.apiKeysFile != "" {
+		var err error
+		if apiKeys, err = httpapi.LoadAPIKeys(*f.apiKeysFile); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *f.requireAPIKey && len(apiKeys) == 0 {
+		log.Fatal("-requireApiKey requires -apiKeysFile")
+	}
+
+	var tenants []string
+	for _, t := range strings.Split(*f.tenantList, ",") {
+		if t = strings.TrimSpace(t); t == "" {
+			continue
+		}
+		if err := sst.ValidateTenant(t); err != nil {
+			log.Fatal(err)
+		}
+		tenants = append(tenants, t)
+	}
+
+	var policyConfig httpapi.PolicyConfig
+	if *f.policyFile != "" {
+		var err error
+		if policyConfig, err = httpapi.LoadPolicyConfig(*f.policyFile); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	var inspector httpapi.ContentInspector
+	if *f.contentPolicy != "" {
+		rules := contentpolicy.DefaultRules()
+		if *f.contentPolicy != "default" {
+			var err error
+			if rules, err = contentpolicy.LoadRules(*f.contentPolicy); err != nil {
+				log.Fatal(err)
+			}
+		}
+		scanner, err := contentpolicy.New(rules...)
+		if err != nil {
+			log.Fatal(err)
+		}
+		inspector = scanner
+	}
+
+	var hosts []string
+	if *f.webhookHosts != "" {
+		hosts = strings.Split(*f.webhookHosts, ",")
+	}
+	f.storageConfig.ExpiryHook = unviewedExpiryHook(webhook.New(hosts...), *f.expiryWebhookURL)
+
+	storage, db := f.storageConfig.Open()
+	if db != nil {
+		prometheus.MustRegister(newDBStatsCollector(db))
+	}
+	if retries, ok := sst.Base(storage).(sst.RetryStats); ok {
+		prometheus.MustRegister(prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "storage_retries_total",
+			Help: "The total number of the storage operations retried after the transient error",
+		}, func() float64 {
+			return float64(retries.Retries())
+		}))
+	}
+
+	isLeader := func() bool { return true }
+	if db != nil {
+		elector := leader.New(db.DB, leader.DefaultLockID, *f.leaderInterval)
+		go elector.Run(context.Background())
+		isLeader = elector.IsLeader
+		prometheus.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "background_jobs_leader",
+			Help: "1 if the replica holds the leadership and runs the background jobs, 0 otherwise",
+		}, func() float64 {
+			if elector.IsLeader() {
+				return 1
+			}
+			return 0
+		}))
+	}
+	jobs := newJobMetrics()
+	go runJanitor(storage, *f.purgeInterval, isLeader, jobs)
+	go f.compactionConfig.runCompaction(storage, jobs)
+
+	// The dispatchers of the replicas claim the different events, so every replica runs one
+	outbox, webhookOutbox := sst.Base(storage).(sst.Outbox)
+	webhookOutbox = webhookOutbox && f.storageConfig.Outbox
+	if webhookOutbox {
+		go runDispatcher(webhook.NewDispatcher(outbox, webhook.New(hosts...)), 5*time.Second, jobs)
+	} else if f.storageConfig.Outbox {
+		log.Fatal("-webhookOutbox requires -dbUrl")
+	}
+
+	f.chaosConfig.warn()
+	primary := chaos.NewStorage(f.replicationConfig.wrap(f.shadowConfig.wrap(storage)), f.chaosConfig.Storage)
+	if *f.labelIndexKeyFile != "" {
+		// The labels are blinded before the shadow and the replicas get them
+		index, err := loadLabelIndex(*f.labelIndexKeyFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		primary = sst.NewBlindLabelStorage(primary, index)
+	}
+	usage := sst.NewUsageStorage(primary)
+	go runUsageExport(usage, *f.usageExportFile, *f.usageExportInterval, jobs)
+
+	if *f.maxViews > 0 {
+		policyConfig.EnforceMaxExpireAfterViews = *f.maxViews
+	}
+	if *f.readOnce {
+		policyConfig.EnforceMaxExpireAfterViews = 1
+	}
+
+	// The anonymous creators replaying Idempotency-Key are told apart by the client address of the access log
+	accessConfig, err := f.logConfig.accessConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+	opts := []httpapi.Option{
+		httpapi.WithPathPrefix(*f.pathPrefix),
+		httpapi.WithAPIKeys(apiKeys, *f.requireAPIKey),
+		httpapi.WithTenants(tenants...),
+		httpapi.WithPolicies(httpapi.NewPolicies(policyConfig)),
+		httpapi.WithBackupIdentities(identities...),
+		httpapi.WithIdempotencyWindow(*f.idempotencyWindow, accessConfig.TrustedProxies...),
+		httpapi.WithClaimWindow(*f.claimWindow),
+		httpapi.WithMaxBodySize(*f.maxBodySize),
+		httpapi.WithRequestDecompression(*f.maxDecompressedSize),
+		httpapi.WithHashFormat(hashFormat),
+	}
+	if *f.viewRequester {
+		opts = append(opts, httpapi.WithViewRequester())
+	}
+	if *f.resumeWindow > 0 {
+		opts = append(opts, httpapi.WithDownloadResume(*f.resumeWindow, *f.resumeMaxBytes))
+	}
+	if *f.routeMiddleware != "" {
+		groups, err := httpapi.LoadRouteMiddleware(*f.routeMiddleware)
+		if err != nil {
+			log.Fatal(err)
+		}
+		opts = append(opts, httpapi.WithRouteMiddleware(groups...))
+	}
+	if !*f.viewHeaders {
+		opts = append(opts, httpapi.WithoutViewHeaders())
+	}
+	if inspector != nil {
+		opts = append(opts, httpapi.WithContentInspector(inspector))
+	}
+	if *f.policyURL != "" {
+		// The client address of the policy is the one of the access log
+		config, err := f.logConfig.accessConfig()
+		if err != nil {
+			log.Fatal(err)
+		}
+		opts = append(opts, httpapi.WithPolicyEvaluator(opa.New(*f.policyURL, *f.policyTimeout), config.TrustedProxies...))
+	}
+	if *f.rateLimitExempt != "" {
+		opt, err := f.rateLimitExemption()
+		if err != nil {
+			log.Fatal(err)
+		}
+		opts = append(opts, opt)
+	}
+	if webhookOutbox {
+		opts = append(opts, httpapi.WithWebhookOutbox())
+	}
+	if len(hosts) > 0 {
+		opts = append(opts, httpapi.WithWebhookHosts(hosts...))
+	}
+	if *f.baseURL != "" {
+		opts = append(opts, httpapi.WithBaseURL(*f.baseURL))
+	}
+	if *f.csrf {
+		key, err := loadKeyFile(*f.csrfKeyFile, "-csrfKeyFile")
+		if err != nil {
+			log.Fatal(err)
+		}
+		opts = append(opts, httpapi.WithCSRF(key))
+	}
+	if *f.previewTokenTTL > 0 {
+		key, err := loadKeyFile(*f.previewTokenKeyFile, "-previewTokenKeyFile")
+		if err != nil {
+			log.Fatal(err)
+		}
+		opts = append(opts, httpapi.WithPreviewTokens(httpapi.NewPreviewTokens(key, *f.previewTokenTTL)))
+	}
+	if *f.cacheAudit {
+		if err := httpapi.AuditCacheHeaders(); err != nil {
+			log.Println(err)
+		}
+		opts = append(opts, httpapi.WithCacheAudit())
+	}
+	if *f.oidcIssuer != "" {
+		login, err := f.webLogin()
+		if err != nil {
+			log.Fatal(err)
+		}
+		opts = append(opts, login)
+	}
+	if *f.writeWorkers > 0 {
+		opts = append(opts, httpapi.WithWriteQueue(*f.writeWorkers, *f.writeQueue))
+	}
+	if *f.clientCertHeader != "" {
+		opts = append(opts, httpapi.WithClientCertHeader(*f.clientCertHeader))
+	}
+	if *f.timestamps != string(httpapi.TimestampRFC3339) || *f.omitZeroTimestamps {
+		if err := validateTimestamps(*f.timestamps); err != nil {
+			log.Fatal(err)
+		}
+		opts = append(opts, httpapi.WithResponseShape(httpapi.ResponseShape{
+			Timestamps: httpapi.TimestampFormat(*f.timestamps),
+			OmitZero:   *f.omitZeroTimestamps,
+		}))
+	}
+	if *f.staticDir != "" {
+		opts = append(opts, httpapi.WithStatic(os.DirFS(*f.staticDir)))
+	} else if *f.webUI {
+		opts = append(opts, httpapi.WithStatic(httpapi.WebUI()))
+	}
+	if *f.hypermedia {
+		opts = append(opts, httpapi.WithHypermedia())
+	}
+	if *f.locale != "" {
+		if err := validateLocale(*f.locale); err != nil {
+			log.Fatal(err)
+		}
+		opts = append(opts, httpapi.WithLocales(*f.locale))
+	}
+	var signer *httpapi.Signer
+	if *f.jwsKeyFile != "" {
+		var err error
+		if signer, err = httpapi.LoadSigner(*f.jwsKeyFile); err != nil {
+			log.Fatal(err)
+		}
+		opts = append(opts, httpapi.WithSigner(signer))
+	}
+
+	// Standard middleware
+	logger := f.logConfig.logger("[api] ")
+	logControl := f.logConfig.control()
+	opts = append(opts, httpapi.WithMiddleware(httpapi.Recovery(logger, *f.debug), f.logConfig.accessLogger("[api] ", logControl)))
+	if *f.maxRequestTimeout > 0 {
+		opts = append(opts, httpapi.WithMiddleware(httpapi.RequestTimeout(*f.maxRequestTimeout)))
+	}
+
+	// The scrapers accepting OpenMetrics get the trace ids of the requests as the exemplars
+	exemplars := openmetrics.NewExemplars()
+	opts = append(opts, httpapi.WithExemplars(exemplars))
+
+	metricsRouter := http.NewServeMux()
+	metricsRouter.Handle("GET /metrics", openmetrics.Handler(prometheus.DefaultGatherer, exemplars, promhttp.Handler()))
+
+	auditLog := f.auditConfig.open()
+	adminOpts := append(opts[:len(opts):len(opts)], httpapi.WithLogControl(logControl))
+	if f.fipsConfig.Enabled {
+		adminOpts = append(adminOpts, httpapi.WithFIPS())
+	}
+	if auditLog != nil {
+		adminOpts = append(adminOpts, auditAdmin(auditLog))
+	}
+	if signer != nil || f.storageConfig.keys != nil {
+		adminOpts = append(adminOpts, httpapi.WithReload(f.reloadKeys(signer)))
+	}
+
+	// The listeners are handed over to the new binary on SIGHUP
+	up := newUpgrader()
+	if *f.metricsAddr != "" {
+		if err := up.serve("metrics", *f.metricsAddr, f.metricsAuth.Middleware(metricsRouter)); err != nil {
+			log.Println("metrics are not available")
+		}
+	}
+	statsd := f.statsdConfig.start()
+
+	if *f.adminAddr != "" {
+		admin := f.replicationConfig.adminHandler(storage, httpapi.NewAdmin(usage, adminOpts...))
+		if err := up.serve("admin", *f.adminAddr, f.adminAuth.Middleware(admin)); err != nil {
+			log.Println("admin API is not available")
+		}
+	}
+
+	// The faults are injected into the public API only, the admin API stays usable
+	apiOpts := append(opts[:len(opts):len(opts)], httpapi.WithMiddleware(chaos.Middleware(f.chaosConfig.HTTP)))
+	apiRouter := http.NewServeMux()
+	apiRouter.Handle("GET /readyz", readyHandler(db))
+	api := httpapi.NewApp(usage, apiOpts...)
+	go runReleases(api, *f.releaseInterval, isLeader, jobs)
+	if auditLog != nil {
+		auditAPI(auditLog, api)
+	}
+	apiRouter.Handle("/", api.Handler())
+	if err := up.serve("api", *f.apiAddr, apiRouter); err != nil {
+		log.Fatal(err)
+	}
+	up.ready()
+	up.wait(f.storageConfig.Persistent(), *f.drainTimeout)
+	if err := statsd.Close(); err != nil {
+		log.Println("dogstatsd: ", err)
+	}
+	if auditLog != nil {
+		auditLog.Close()
+	}
+}
+
+// validateLocale checks the locale has the message catalog
+func validateLocale(locale string) error {
+	for _, l := range httpapi.Locales() {
+		if l == locale {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown -locale %q, it should be one of %s", locale, strings.Join(httpapi.Locales(), ", "))
+}
+
+// validateTimestamps checks the encoding of the timestamps is known
+func validateTimestamps(format string) error {
+	switch httpapi.TimestampFormat(format) {
+	case httpapi.TimestampRFC3339, httpapi.TimestampUnix:
+		return nil
+	}
+	return fmt.Errorf("unknown -timestamps %q, it should be rfc3339 or unix", format)
+}
+
+// reloadKeys reads -tenantKeysFile and -jwsKeyFile again and swaps the keys of the running server,
+// so they are rotated without the restart. Both files are read first, the invalid one keeps all keys
+func (f *serveFlags) reloadKeys(signer *httpapi.Signer) func() error {
+	return func() error {
+		var next *httpapi.Signer
+		if signer != nil {
+			var err error
+			if next, err = httpapi.LoadSigner(*f.jwsKeyFile); err != nil {
+				return fmt.Errorf("%s: %w", *f.jwsKeyFile, err)
+			}
+		}
+		keys := f.storageConfig.keys
+		var nextKeys *sst.TenantKeys
+		if keys != nil {
+			var err error
+			if nextKeys, err = f.storageConfig.tenantKeys(); err != nil {
+				return err
+			}
+		}
+		if next != nil {
+			signer.Rotate(next)
+		}
+		if nextKeys != nil {
+			keys.Replace(nextKeys)
+		}
+		return nil
+	}
+}
+
+// rateLimitExemption parses -rateLimitExemptNetworks, the client address is the one of the access log
+func (f *serveFlags) rateLimitExemption() (httpapi.Option, error) {
+	networks, err := httpapi.ParseTrustedProxies(*f.rateLimitExempt)
+	if err != nil {
+		return nil, fmt.Errorf("-rateLimitExemptNetworks: %v", err)
+	}
+	config, err := f.logConfig.accessConfig()
+	if err != nil {
+		return nil, err
+	}
+	return httpapi.WithRateLimitExemption(networks, config.TrustedProxies...), nil
+}
+
+// webLogin discovers the -oidcIssuer and returns the web login option
+func (f *serveFlags) webLogin() (httpapi.Option, error) {
+	if *f.baseURL == "" || *f.oidcClientID == "" {
+		return nil, errors.New("-oidcIssuer requires -baseUrl and -oidcClientId")
+	}
+	cfg := oidc.Config{Issuer: *f.oidcIssuer, ClientID: *f.oidcClientID, Timeout: 10 * time.Second}
+	if *f.oidcSecretFile != "" {
+		secret, err := os.ReadFile(*f.oidcSecretFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.ClientSecret = string(bytes.TrimSpace(secret))
+	}
+	key, err := loadKeyFile(*f.oidcSessionKeyFile, "-oidcSessionKeyFile")
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Timeout)
+	defer cancel()
+	provider, err := oidc.New(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return httpapi.WithWebLogin(provider, key, *f.oidcSessionTTL), nil
+}
+
+// loadKeyFile reads the signing key of the cookies, at least 32 bytes. Empty path returns no key
+func loadKeyFile(path, flag string) ([]byte, error) {
+	if path == "" {
+		return nil, nil
+	}
+	key, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if key = bytes.TrimSpace(key); len(key) < 32 {
+		return nil, errors.New(flag + " should have at least 32 bytes")
+	}
+	return key, nil
+}
+
+// loadLabelIndex reads the key of the blind index of the labels, the surrounding whitespace is ignored
+func loadLabelIndex(path string) (*sst.LabelIndex, error) {
+	key, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return sst.NewLabelIndex(bytes.TrimSpace(key))
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/evsan/secret-server-task/httpapi"
+)
+
+func TestReloadKeys(t *testing.T) {
+	dir := t.TempDir()
+	jwsFile, tenantFile := filepath.Join(dir, "jws.pem"), filepath.Join(dir, "tenants.json")
+	writeJWS := func() {
+		key, err := genJWSKey()
+		if err != nil {
+			t.Fatal("error is not expected: ", err)
+		}
+		if err = os.WriteFile(jwsFile, key, 0o600); err != nil {
+			t.Fatal("error is not expected: ", err)
+		}
+	}
+	writeTenants := func(ids ...string) {
+		var entries []string
+		for i, id := range ids {
+			key := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{byte(i + 1)}, 32))
+			entries = append(entries, `{"id": "`+id+`", "key": "`+key+`"}`)
+		}
+		if err := os.WriteFile(tenantFile, []byte(`{"": [`+strings.Join(entries, ",")+`]}`), 0o600); err != nil {
+			t.Fatal("error is not expected: ", err)
+		}
+	}
+	writeJWS()
+	writeTenants("key-1")
+
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	f := newServeFlags(fs)
+	if err := fs.Parse([]string{"-jwsKeyFile", jwsFile, "-tenantKeysFile", tenantFile}); err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+	keys := f.storageConfig.mustTenantKeys()
+	signer, err := httpapi.LoadSigner(jwsFile)
+	if err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+	reload := f.reloadKeys(signer)
+	current := func() (string, string) {
+		sealed, err := keys.Seal("", "hash", "test secret")
+		if err != nil {
+			t.Fatal("error is not expected: ", err)
+		}
+		return signer.JWKS().Keys[0].Kid, strings.Split(sealed, ":")[1]
+	}
+
+	// Both files are rotated
+	kid, _ := current()
+	writeJWS()
+	writeTenants("key-2", "key-1")
+	if err = reload(); err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+	newKid, keyID := current()
+	if newKid == kid || keyID != "key-2" {
+		t.Fatalf("keys should be rotated: %s %s", newKid, keyID)
+	}
+
+	// The invalid file keeps all keys, also the ones of the valid file
+	invalid := map[string]func(){
+		"jws":     func() { writeTenants("key-3"); _ = os.WriteFile(jwsFile, []byte("invalid"), 0o600) },
+		"tenants": func() { writeJWS(); _ = os.WriteFile(tenantFile, []byte(`{"": [{"id": "key-3"}]}`), 0o600) },
+		"missing": func() { writeTenants("key-3"); _ = os.Remove(jwsFile) },
+	}
+	for name, write := range invalid {
+		t.Run(name, func(t *testing.T) {
+			write()
+			if err := reload(); err == nil {
+				t.Fatal("error is expected")
+			}
+			if kid, keyID := current(); kid != newKid || keyID != "key-2" {
+				t.Fatalf("keys should be kept: %s %s", kid, keyID)
+			}
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+)
+
+// serviceManager installs and controls the server as the service of the platform:
+// the systemd unit on Linux, the launchd daemon on macOS and the Windows service
+type serviceManager interface {
+	// install registers the service running serve with the args, it is started on the boot
+	install(args []string) error
+	uninstall() error
+	start() error
+	stop() error
+}
+
+// errServiceUnsupported is returned on the platforms without the service manager
+var errServiceUnsupported = errors.New("service management is not supported on this platform")
+
+// asService wraps serve when the process is started by the service manager, it is set on Windows
+var asService func(serve func(args []string)) func(args []string)
+
+// serviceCommand runs `service install|uninstall|start|stop`. The flags following install are passed to serve
+func serviceCommand(args []string) {
+	fs := flag.NewFlagSet("service", flag.ExitOnError)
+	name := fs.String("name", "secret-server", "name of the service")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: server service [-name=secret-server] install [serve flags] | uninstall | start | stop")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	manager, err := newServiceManager(*name)
+	if err != nil {
+		log.Fatal(err)
+	}
+	switch action := fs.Arg(0); action {
+	case "install":
+		err = manager.install(fs.Args()[1:])
+	case "uninstall":
+		err = manager.uninstall()
+	case "start":
+		err = manager.start()
+	case "stop":
+		err = manager.stop()
+	default:
+		fmt.Fprintf(fs.Output(), "unknown action %q\n", action)
+		fs.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// serviceInstalled returns the error if the service definition file exists
+func serviceInstalled(name, path string) error {
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("service %s is already installed: %s", name, path)
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
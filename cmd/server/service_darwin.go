@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// launchdPlist is the daemon definition. The daemon is restarted only if it fails, so the old process
+// exiting after the SIGHUP upgrade doesn't start another one, and its process group is left to the new process
+const launchdPlist = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>Label</key>
+	<string>%s</string>
+	<key>ProgramArguments</key>
+	<array>
+%s	</array>
+	<key>RunAtLoad</key>
+	<true/>
+	<key>KeepAlive</key>
+	<dict>
+		<key>SuccessfulExit</key>
+		<false/>
+	</dict>
+	<key>AbandonProcessGroup</key>
+	<true/>
+	<key>StandardOutPath</key>
+	<string>%s</string>
+	<key>StandardErrorPath</key>
+	<string>%s</string>
+</dict>
+</plist>
+`
+
+// launchdService is the launchd daemon of the server
+type launchdService struct {
+	name string
+}
+
+func newServiceManager(name string) (serviceManager, error) {
+	return launchdService{name: name}, nil
+}
+
+func (s launchdService) path() string {
+	return filepath.Join("/Library/LaunchDaemons", s.name+".plist")
+}
+
+func (s launchdService) install(args []string) error {
+	if err := serviceInstalled(s.name, s.path()); err != nil {
+		return err
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	var arguments bytes.Buffer
+	for _, arg := range append([]string{executable, "serve"}, args...) {
+		arguments.WriteString("\t\t<string>")
+		if err = xml.EscapeText(&arguments, []byte(arg)); err != nil {
+			return err
+		}
+		arguments.WriteString("</string>\n")
+	}
+	logFile := plistEscape(filepath.Join("/Library/Logs", s.name+".log"))
+	plist := fmt.Sprintf(launchdPlist, plistEscape(s.name), arguments.String(), logFile, logFile)
+	return os.WriteFile(s.path(), []byte(plist), 0644)
+}
+
+func (s launchdService) uninstall() error {
+	return os.Remove(s.path())
+}
+
+func (s launchdService) start() error {
+	return launchctl("load", s.path())
+}
+
+func (s launchdService) stop() error {
+	return launchctl("unload", s.path())
+}
+
+func launchctl(args ...string) error {
+	cmd := exec.Command("launchctl", args...)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	return cmd.Run()
+}
+
+func plistEscape(s string) string {
+	var b bytes.Buffer
+	_ = xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
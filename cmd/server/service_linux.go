@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// systemdUnit is the unit of the service. Type=notify lets the process started by the SIGHUP upgrade
+// report itself as the main process, so systemd doesn't stop the service when the old one exits
+const systemdUnit = `[Unit]
+Description=Secret server
+After=network-online.target
+Wants=network-online.target
+
+[Service]
+Type=notify
+NotifyAccess=all
+ExecStart=%s
+ExecReload=/bin/kill -HUP $MAINPID
+Restart=on-failure
+LimitMEMLOCK=infinity
+
+[Install]
+WantedBy=multi-user.target
+`
+
+// systemdService is the systemd unit of the server
+type systemdService struct {
+	name string
+}
+
+func newServiceManager(name string) (serviceManager, error) {
+	return systemdService{name: name}, nil
+}
+
+func (s systemdService) path() string {
+	return filepath.Join("/etc/systemd/system", s.name+".service")
+}
+
+func (s systemdService) install(args []string) error {
+	if err := serviceInstalled(s.name, s.path()); err != nil {
+		return err
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	command := []string{systemdQuote(executable), "serve"}
+	for _, arg := range args {
+		command = append(command, systemdQuote(arg))
+	}
+	if err = os.WriteFile(s.path(), []byte(fmt.Sprintf(systemdUnit, strings.Join(command, " "))), 0644); err != nil {
+		return err
+	}
+	if err = systemctl("daemon-reload"); err != nil {
+		return err
+	}
+	return systemctl("enable", s.name)
+}
+
+func (s systemdService) uninstall() error {
+	if err := systemctl("disable", s.name); err != nil {
+		return err
+	}
+	if err := os.Remove(s.path()); err != nil {
+		return err
+	}
+	return systemctl("daemon-reload")
+}
+
+func (s systemdService) start() error {
+	return systemctl("start", s.name)
+}
+
+func (s systemdService) stop() error {
+	return systemctl("stop", s.name)
+}
+
+func systemctl(args ...string) error {
+	cmd := exec.Command("systemctl", args...)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	return cmd.Run()
+}
+
+// systemdQuote quotes the argument of ExecStart, the specifiers and the variables are escaped
+func systemdQuote(arg string) string {
+	return strings.NewReplacer("%", "%%", "$", "$$").Replace(strconv.Quote(arg))
+}
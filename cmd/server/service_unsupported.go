@@ -0,0 +1,7 @@
+//go:build !linux && !darwin && !windows
+
+package main
+
+func newServiceManager(string) (serviceManager, error) {
+	return nil, errServiceUnsupported
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+// serviceStopTimeout is how long stop waits for the service to drain and exit
+const serviceStopTimeout = time.Minute
+
+// windowsService is the Windows service of the server
+type windowsService struct {
+	name string
+}
+
+func newServiceManager(name string) (serviceManager, error) {
+	return windowsService{name: name}, nil
+}
+
+func (s windowsService) install(args []string) error {
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+	if service, err := m.OpenService(s.name); err == nil {
+		service.Close()
+		return fmt.Errorf("service %s is already installed", s.name)
+	}
+	config := mgr.Config{DisplayName: s.name, Description: "Secret server", StartType: mgr.StartAutomatic}
+	service, err := m.CreateService(s.name, executable, config, append([]string{"serve"}, args...)...)
+	if err != nil {
+		return err
+	}
+	return service.Close()
+}
+
+// open calls f with the installed service
+func (s windowsService) open(f func(service *mgr.Service) error) error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+	service, err := m.OpenService(s.name)
+	if err != nil {
+		return fmt.Errorf("service %s is not installed: %v", s.name, err)
+	}
+	defer service.Close()
+	return f(service)
+}
+
+func (s windowsService) uninstall() error {
+	return s.open(func(service *mgr.Service) error {
+		return service.Delete()
+	})
+}
+
+func (s windowsService) start() error {
+	return s.open(func(service *mgr.Service) error {
+		return service.Start()
+	})
+}
+
+// stop waits until the service drains the requests and exits
+func (s windowsService) stop() error {
+	return s.open(func(service *mgr.Service) error {
+		status, err := service.Control(svc.Stop)
+		for deadline := time.Now().Add(serviceStopTimeout); err == nil && status.State != svc.Stopped; {
+			if time.Now().After(deadline) {
+				return fmt.Errorf("service %s is not stopped in %s", s.name, serviceStopTimeout)
+			}
+			time.Sleep(300 * time.Millisecond)
+			status, err = service.Query()
+		}
+		return err
+	})
+}
+
+func init() {
+	asService = func(serve func(args []string)) func(args []string) {
+		if isService, err := svc.IsWindowsService(); err != nil || !isService {
+			return serve
+		}
+		return func(args []string) {
+			redirectServiceLog()
+			if err := svc.Run("", windowsHandler{serve: serve, args: args}); err != nil {
+				log.Fatal(err)
+			}
+		}
+	}
+}
+
+// redirectServiceLog writes the output of the service next to the executable, the service has no console
+func redirectServiceLog() {
+	executable, err := os.Executable()
+	if err != nil {
+		return
+	}
+	f, err := os.OpenFile(strings.TrimSuffix(executable, ".exe")+".log", os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return
+	}
+	os.Stdout, os.Stderr = f, f
+	log.SetOutput(f)
+}
+
+// windowsHandler runs serve until the service control manager stops it
+type windowsHandler struct {
+	serve func(args []string)
+	args  []string
+}
+
+func (h windowsHandler) Execute(_ []string, requests <-chan svc.ChangeRequest, changes chan<- svc.Status) (bool, uint32) {
+	changes <- svc.Status{State: svc.StartPending}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		h.serve(h.args)
+	}()
+	changes <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+	for {
+		select {
+		case <-done:
+			return false, 0
+		case r := <-requests:
+			switch r.Cmd {
+			case svc.Interrogate:
+				changes <- r.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				changes <- svc.Status{State: svc.StopPending, WaitHint: uint32(serviceStopTimeout / time.Millisecond)}
+				select {
+				case shutdown <- syscall.SIGTERM:
+				default:
+				}
+				<-done
+				return false, 0
+			}
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"flag"
+	"log"
+
+	sst "github.com/evsan/secret-server-task"
+	"github.com/evsan/secret-server-task/shadow"
+	"github.com/jmoiron/sqlx"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ShadowConfig holds the candidate storage the reads are compared with
+type ShadowConfig struct {
+	DbUrl string
+}
+
+// RegisterFlags registers the shadow read flags in the flag set
+func (c *ShadowConfig) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.DbUrl, "shadowDbUrl", "", "postgres db url of the candidate storage the reads are compared with before the migration, its views are never consumed. If empty the reads are not compared")
+}
+
+// wrap returns the storage comparing the reads with the candidate if it is configured
+func (c ShadowConfig) wrap(st sst.Storage) sst.Storage {
+	if c.DbUrl == "" {
+		return st
+	}
+	db := sqlx.MustConnect("postgres", c.DbUrl)
+	shadowed, err := shadow.New(st, sst.NewPgStorage(db))
+	if err != nil {
+		log.Fatal(err)
+	}
+	counter := func(name, help string, value func(shadow.Stats) uint64) {
+		prometheus.MustRegister(prometheus.NewCounterFunc(prometheus.CounterOpts{Name: name, Help: help}, func() float64 {
+			return float64(value(shadowed.Stats()))
+		}))
+	}
+	counter("shadow_reads_total", "The total number of the reads compared with the candidate storage",
+		func(s shadow.Stats) uint64 { return s.Compared })
+	counter("shadow_read_mismatches_total", "The total number of the reads the candidate storage answered differently",
+		func(s shadow.Stats) uint64 { return s.Mismatches })
+	counter("shadow_reads_skipped_total", "The total number of the reads not compared because too many comparisons were running",
+		func(s shadow.Stats) uint64 { return s.Skipped })
+	return shadowed
+}
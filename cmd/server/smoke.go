@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// smokeClient makes the requests of the smoke test to the deployed server
+type smokeClient struct {
+	target string
+	apiKey string
+	client *http.Client
+}
+
+// smokeSecret is the part of the secret response the smoke test verifies
+type smokeSecret struct {
+	Hash       string    `json:"hash"`
+	SecretText string    `json:"secretText"`
+	CreatedAt  time.Time `json:"createdAt"`
+	ExpiresAt  time.Time `json:"expiresAt"`
+}
+
+// smokeCommand verifies the deployed server end to end: it creates the throwaway secrets,
+// retrieves them and checks they are burnt after the last view and not served after the expiry.
+// It exits with 1 on any deviation, so it is usable as the post-deploy verification:
+// server smoke -target=https://secrets.example.com
+func smokeCommand(args []string) {
+	fs := flag.NewFlagSet("smoke", flag.ExitOnError)
+	target := fs.String("target", "http://localhost:8001", "base URL of the API including the path prefix")
+	apiKey := fs.String("apiKey", "", "api key sent with the create requests")
+	timeout := fs.Duration("timeout", 10*time.Second, "timeout of every request")
+	expiry := fs.Bool("expiry", true, "verify the secret isn't served after its expiry, it waits the minute of the shortest expiry")
+	grace := fs.Duration("expiryGrace", 5*time.Second, "how long after the expiry the secret may still be served, the clock skew of the servers")
+	_ = fs.Parse(args)
+
+	c := &smokeClient{
+		target: strings.TrimRight(*target, "/"),
+		apiKey: *apiKey,
+		client: &http.Client{Timeout: *timeout},
+	}
+	r := &checkReport{out: os.Stdout}
+	c.run(r, *expiry, *grace)
+	fmt.Fprintf(r.out, "%d passed, %d failed\n", r.passed, r.failed)
+	if r.failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// run performs the smoke checks, the checks depending on the failed one are skipped
+func (c *smokeClient) run(r *checkReport, expiry bool, grace time.Duration) {
+	text := "smoke " + string(randomToken())
+
+	var burnt smokeSecret
+	var created error
+	r.run("create", func() error {
+		burnt, created = c.create(text, 1, 0)
+		return created
+	})
+	r.run("retrieve", func() error {
+		if created != nil {
+			return errSkipped
+		}
+		return c.expect(burnt.Hash, http.StatusOK, text)
+	})
+	r.run("burn after read", func() error {
+		if created != nil {
+			return errSkipped
+		}
+		return c.expect(burnt.Hash, http.StatusNotFound, "")
+	})
+	r.run("unknown secret", func() error {
+		return c.expect(string(randomToken()), http.StatusNotFound, "")
+	})
+	r.run("expiry", func() error {
+		if !expiry || created != nil {
+			return errSkipped
+		}
+		expiring, err := c.create(text, 2, 1)
+		if err != nil {
+			return err
+		}
+		if err = c.expect(expiring.Hash, http.StatusOK, text); err != nil {
+			return err
+		}
+		time.Sleep(expiring.ExpiresAt.Sub(expiring.CreatedAt) + grace)
+		return c.expect(expiring.Hash, http.StatusNotFound, "")
+	})
+}
+
+// create stores the secret, expireAfter is in minutes
+func (c *smokeClient) create(text string, views, expireAfter int) (smokeSecret, error) {
+	form := url.Values{
+		"secret":           {text},
+		"expireAfterViews": {strconv.Itoa(views)},
+		"expireAfter":      {strconv.Itoa(expireAfter)},
+	}
+	req, err := http.NewRequest(http.MethodPost, c.target+"/secret", strings.NewReader(form.Encode()))
+	if err != nil {
+		return smokeSecret{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if c.apiKey != "" {
+		req.Header.Set("X-API-Key", c.apiKey)
+	}
+	var secret smokeSecret
+	status, err := c.do(req, &secret)
+	if err != nil {
+		return smokeSecret{}, err
+	}
+	if status != http.StatusOK {
+		return smokeSecret{}, fmt.Errorf("expected status %d, got %d", http.StatusOK, status)
+	}
+	if secret.Hash == "" {
+		return smokeSecret{}, errors.New("the response has no hash")
+	}
+	return secret, nil
+}
+
+// expect retrieves the secret and compares the status and the text with the expected ones
+func (c *smokeClient) expect(hash string, status int, text string) error {
+	req, err := http.NewRequest(http.MethodGet, c.target+"/secret/"+url.PathEscape(hash), nil)
+	if err != nil {
+		return err
+	}
+	var secret smokeSecret
+	got, err := c.do(req, &secret)
+	if err != nil {
+		return err
+	}
+	if got != status {
+		return fmt.Errorf("expected status %d, got %d", status, got)
+	}
+	if secret.SecretText != text {
+		return errors.New("the retrieved text differs from the stored one")
+	}
+	return nil
+}
+
+// do sends the request as JSON and decodes the 200 response into v
+func (c *smokeClient) do(req *http.Request, v interface{}) (int, error) {
+	req.Header.Set("Accept", "application/json")
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		_, _ = io.Copy(ioutil.Discard, resp.Body)
+		return resp.StatusCode, nil
+	}
+	return resp.StatusCode, json.NewDecoder(resp.Body).Decode(v)
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/evsan/secret-server-task/dogstatsd"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// StatsdConfig holds the Datadog agent the metrics are exported to over DogStatsD
+type StatsdConfig struct {
+	Addr     string
+	Prefix   string
+	Tags     string
+	Interval time.Duration
+}
+
+// RegisterFlags registers the DogStatsD flags in the flag set
+func (c *StatsdConfig) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.Addr, "statsdAddr", "", "DogStatsD address of the Datadog agent the metrics are exported to: host:port (e.g. localhost:8125) or unix:///var/run/datadog/dsd.socket. If empty nothing is exported")
+	fs.StringVar(&c.Prefix, "statsdPrefix", "secret_server.", "prefix of the exported metric names")
+	fs.StringVar(&c.Tags, "statsdTags", "", "comma separated list of the tags added to the exported metrics, e.g. env:prod,service:secret-server")
+	fs.DurationVar(&c.Interval, "statsdInterval", 10*time.Second, "how often the metrics are exported")
+}
+
+// start exports the registered metrics, it returns nil if the export is disabled
+func (c StatsdConfig) start() *dogstatsd.Exporter {
+	if c.Addr == "" {
+		return nil
+	}
+	var tags []string
+	for _, t := range strings.Split(c.Tags, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			tags = append(tags, t)
+		}
+	}
+	e, err := dogstatsd.Start(prometheus.DefaultGatherer, dogstatsd.Config{Address: c.Addr, Prefix: c.Prefix, Tags: tags, Interval: c.Interval})
+	if err != nil {
+		log.Fatal("can't export the metrics to DogStatsD: ", err)
+	}
+	return e
+}
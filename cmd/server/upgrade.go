@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Environment of the process started by the upgrade
+const (
+	// listenersEnv is the comma separated names of the inherited listeners, the first one is fd 3
+	listenersEnv = "SECRET_SERVER_LISTENERS"
+	// readyEnv is the fd the new process closes when its listeners are served
+	readyEnv = "SECRET_SERVER_READY_FD"
+)
+
+// readyTimeout is how long the old process waits for the new one to start serving
+const readyTimeout = time.Minute
+
+// shutdown receives the signals handled by wait and the stop requests of the Windows service manager
+var shutdown = make(chan os.Signal, 1)
+
+// upgrader serves the listeners which survive the binary upgrade. On SIGHUP the new binary is started
+// with the listeners, and the old process drains its connections when the new one is ready.
+// SIGINT and SIGTERM drain the connections and exit without the upgrade
+type upgrader struct {
+	inherited map[string]*os.File
+
+	mu        sync.Mutex
+	names     []string
+	listeners []net.Listener
+	servers   []*http.Server
+}
+
+func newUpgrader() *upgrader {
+	u := &upgrader{inherited: map[string]*os.File{}}
+	if names := os.Getenv(listenersEnv); names != "" {
+		for i, name := range strings.Split(names, ",") {
+			u.inherited[name] = os.NewFile(uintptr(3+i), name)
+		}
+	}
+	os.Unsetenv(listenersEnv)
+	return u
+}
+
+// serve serves the handler on the inherited listener of the name or on the new one
+func (u *upgrader) serve(name, addr string, handler http.Handler) error {
+	ln, err := u.listen(name, addr)
+	if err != nil {
+		return err
+	}
+	srv := &http.Server{Handler: handler}
+	u.mu.Lock()
+	u.names = append(u.names, name)
+	u.listeners = append(u.listeners, ln)
+	u.servers = append(u.servers, srv)
+	u.mu.Unlock()
+
+	go func() {
+		if err := srv.Serve(ln); err != nil && err != http.ErrServerClosed {
+			log.Printf("%s listener: %v", name, err)
+		}
+	}()
+	return nil
+}
+
+func (u *upgrader) listen(name, addr string) (net.Listener, error) {
+	if f, ok := u.inherited[name]; ok {
+		defer f.Close()
+		return net.FileListener(f)
+	}
+	return net.Listen("tcp", addr)
+}
+
+// ready tells the old process that the listeners are served, so it can drain.
+// systemd is notified as well, the new process becomes the main process of the unit
+func (u *upgrader) ready() {
+	notifySystemd(fmt.Sprintf("MAINPID=%d\nREADY=1", os.Getpid()))
+	fd, err := strconv.Atoi(os.Getenv(readyEnv))
+	os.Unsetenv(readyEnv)
+	if err != nil {
+		return
+	}
+	if err = os.NewFile(uintptr(fd), "ready").Close(); err != nil {
+		log.Println("upgrade: ", err)
+	}
+}
+
+// notifySystemd sends the state to the notification socket of the systemd unit of Type=notify
+func notifySystemd(state string) {
+	addr := os.Getenv("NOTIFY_SOCKET")
+	if addr == "" {
+		return
+	}
+	conn, err := net.Dial("unixgram", addr)
+	if err != nil {
+		log.Println("systemd notification: ", err)
+		return
+	}
+	defer conn.Close()
+	if _, err = conn.Write([]byte(state)); err != nil {
+		log.Println("systemd notification: ", err)
+	}
+}
+
+// wait handles the signals until the process should exit. The upgrade is refused if allowed is false:
+// the in-memory secrets can't be handed over to the new process
+func (u *upgrader) wait(allowed bool, drainTimeout time.Duration) {
+	signal.Notify(shutdown, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM)
+	for sig := range shutdown {
+		if sig == syscall.SIGHUP {
+			if !allowed {
+				log.Println("upgrade: refused, the secrets of the in-memory storage would be lost")
+				continue
+			}
+			if err := u.upgrade(); err != nil {
+				log.Println("upgrade: failed, the old process keeps serving: ", err)
+				continue
+			}
+			log.Println("upgrade: the new process is serving, draining the connections")
+		}
+		u.drain(drainTimeout)
+		return
+	}
+}
+
+// upgrade starts the new binary with the listeners and waits until it is ready
+func (u *upgrader) upgrade() error {
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	u.mu.Lock()
+	names := append([]string(nil), u.names...)
+	var files []*os.File
+	for _, ln := range u.listeners {
+		tcp, ok := ln.(*net.TCPListener)
+		if !ok {
+			u.mu.Unlock()
+			return errors.New("listener can't be handed over")
+		}
+		f, err := tcp.File()
+		if err != nil {
+			u.mu.Unlock()
+			return err
+		}
+		defer f.Close()
+		files = append(files, f)
+	}
+	u.mu.Unlock()
+
+	readyR, readyW, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer readyR.Close()
+	files = append(files, readyW)
+
+	cmd := exec.Command(executable, os.Args[1:]...)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	cmd.ExtraFiles = files
+	cmd.Env = append(os.Environ(), listenersEnv+"="+strings.Join(names, ","), fmt.Sprintf("%s=%d", readyEnv, 3+len(names)))
+	err = cmd.Start()
+	readyW.Close()
+	if err != nil {
+		return err
+	}
+
+	// The pipe is closed by the new process when it is ready or when it exits
+	ready := make(chan error, 1)
+	go func() {
+		_, err := readyR.Read(make([]byte, 1))
+		ready <- err
+	}()
+	exited := make(chan error, 1)
+	go func() {
+		exited <- cmd.Wait()
+	}()
+	select {
+	case <-ready:
+		// Wait returns immediately if the new process failed on the startup
+		select {
+		case err = <-exited:
+			return fmt.Errorf("new process exited: %v", err)
+		case <-time.After(100 * time.Millisecond):
+			return nil
+		}
+	case err = <-exited:
+		return fmt.Errorf("new process exited: %v", err)
+	case <-time.After(readyTimeout):
+		_ = cmd.Process.Kill()
+		return errors.New("new process is not ready in time")
+	}
+}
+
+// drain stops accepting the connections and waits for the in-flight requests
+func (u *upgrader) drain(timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	u.mu.Lock()
+	servers := u.servers
+	u.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, srv := range servers {
+		wg.Add(1)
+		go func(srv *http.Server) {
+			defer wg.Done()
+			if err := srv.Shutdown(ctx); err != nil {
+				log.Println("drain: ", err)
+			}
+		}(srv)
+	}
+	wg.Wait()
+}
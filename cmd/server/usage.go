@@ -0,0 +1,51 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	sst "github.com/evsan/secret-server-task"
+	"github.com/evsan/secret-server-task/httpapi"
+)
+
+// runUsageExport periodically writes the usage report to the file, CSV if the file has .csv extension
+// and JSON otherwise. The file is replaced atomically, so the consumers never read a partial report.
+func runUsageExport(usage *sst.UsageStorage, path string, interval time.Duration, jobs *jobMetrics) {
+	if path == "" || interval <= 0 {
+		return
+	}
+	export := jobs.job("usage_export")
+	for range time.Tick(interval) {
+		if _, err := export.observe(func() (int, error) { return exportUsage(usage, path) }); err != nil {
+			log.Println("usage export failed: ", err)
+		}
+	}
+}
+
+// exportUsage writes the report and returns the amount of its rows
+func exportUsage(usage *sst.UsageStorage, path string) (int, error) {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".usage-*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+
+	report := httpapi.NewUsageReport(usage)
+	if strings.HasSuffix(path, ".csv") {
+		err = httpapi.WriteUsageCSV(tmp, report.Usage)
+	} else {
+		err = json.NewEncoder(tmp).Encode(report)
+	}
+	if e := tmp.Close(); err == nil {
+		err = e
+	}
+	if err != nil {
+		return 0, err
+	}
+	return len(report.Usage), os.Rename(tmp.Name(), path)
+}
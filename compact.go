@@ -0,0 +1,37 @@
+package secret_server_task
+
+// Compactor is implemented by the storages keeping the secrets in the embedded on-disk backend,
+// e.g. SQLite VACUUM, the rewrite of the Bolt file or the value log GC of Badger. The purged secrets
+// and tombstones leave the free pages the backend doesn't return to the filesystem by itself, so the file
+// of the long-running install grows with every secret ever stored. The in-memory and the PostgreSQL storages
+// don't implement it, autovacuum reclaims the space of the purged rows
+type Compactor interface {
+	// Compact reclaims the space of the removed records and returns the amount of the reclaimed bytes.
+	// It may block the writes for its duration
+	Compact() (int64, error)
+}
+
+// CompactorOf returns the Compactor of the storage or of its base storage
+func CompactorOf(st Storage) (Compactor, bool) {
+	if c, ok := st.(Compactor); ok {
+		return c, true
+	}
+	c, ok := Base(st).(Compactor)
+	return c, ok
+}
+
+// Compact compacts the shards one by one and returns the bytes reclaimed by all of them,
+// the shards which are not compacted are skipped
+func (st *shardedStorage) Compact() (int64, error) {
+	var reclaimed int64
+	for _, s := range st.shards {
+		if c, ok := CompactorOf(s.Storage); ok {
+			n, err := c.Compact()
+			reclaimed += n
+			if err != nil {
+				return reclaimed, err
+			}
+		}
+	}
+	return reclaimed, nil
+}
@@ -0,0 +1,168 @@
+// Package contentpolicy inspects the secrets before they are stored.
+// The rules match the kinds of data which must not be shared through the service,
+// e.g. card numbers, and either reject the secret or flag it for the review.
+package contentpolicy
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Actions of the rules
+const (
+	// Reject refuses to store the secret
+	Reject = "reject"
+	// Flag stores the secret with the names of the matched rules, see httpapi.ContentFlagsLabel
+	Flag = "flag"
+)
+
+// Checks applied to the matches of the pattern
+const (
+	// Luhn keeps only the matches with the valid Luhn check digit, e.g. card numbers
+	Luhn = "luhn"
+)
+
+// Builtin patterns which can be used by the name without the pattern
+var builtin = map[string]Rule{
+	"pan":               {Pattern: `\b\d(?:[ -]?\d){12,18}\b`, Check: Luhn},
+	"aws-access-key-id": {Pattern: `\b(?:AKIA|ASIA|AROA|AIDA)[0-9A-Z]{16}\b`},
+	"private-key":       {Pattern: `-----BEGIN (?:[A-Z0-9]+ )*PRIVATE KEY-----`},
+}
+
+// Rule is the pattern with the action taken if the secret matches it
+type Rule struct {
+	Name string `json:"name"`
+	// Pattern is the regular expression, the builtin pattern of the name is used if empty
+	Pattern string `json:"pattern,omitempty"`
+	// Action is Reject or Flag
+	Action string `json:"action"`
+	// Check is the optional validation of the matches, Luhn
+	Check string `json:"check,omitempty"`
+}
+
+// DefaultRules rejects the card numbers, the AWS access key IDs and the private keys
+func DefaultRules() []Rule {
+	return []Rule{
+		{Name: "pan", Action: Reject},
+		{Name: "aws-access-key-id", Action: Reject},
+		{Name: "private-key", Action: Reject},
+	}
+}
+
+// LoadRules reads the JSON list of the rules
+func LoadRules(path string) ([]Rule, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var rules []Rule
+	if err = json.NewDecoder(f).Decode(&rules); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// RejectedError is returned by Inspect for the secrets matching the Reject rules
+type RejectedError struct {
+	Rules []string
+}
+
+func (e *RejectedError) Error() string {
+	return "secret is refused by the content policy: " + strings.Join(e.Rules, ", ")
+}
+
+type compiledRule struct {
+	Rule
+	re *regexp.Regexp
+}
+
+// Scanner applies the rules to the secret text
+type Scanner struct {
+	rules []compiledRule
+}
+
+// New compiles the rules
+func New(rules ...Rule) (*Scanner, error) {
+	s := &Scanner{}
+	for _, rule := range rules {
+		if rule.Pattern == "" {
+			b, ok := builtin[rule.Name]
+			if !ok {
+				return nil, fmt.Errorf("content policy rule %q has no pattern", rule.Name)
+			}
+			rule.Pattern = b.Pattern
+			if rule.Check == "" {
+				rule.Check = b.Check
+			}
+		}
+		if rule.Action != Reject && rule.Action != Flag {
+			return nil, fmt.Errorf("content policy rule %q: action should be %s or %s", rule.Name, Reject, Flag)
+		}
+		if rule.Check != "" && rule.Check != Luhn {
+			return nil, fmt.Errorf("content policy rule %q: unknown check %q", rule.Name, rule.Check)
+		}
+		re, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("content policy rule %q: %v", rule.Name, err)
+		}
+		s.rules = append(s.rules, compiledRule{Rule: rule, re: re})
+	}
+	return s, nil
+}
+
+// Inspect returns the names of the matched Flag rules or RejectedError if any Reject rule matches
+func (s *Scanner) Inspect(text string) ([]string, error) {
+	var flagged, rejected []string
+	for _, rule := range s.rules {
+		if !rule.matches(text) {
+			continue
+		}
+		if rule.Action == Reject {
+			rejected = append(rejected, rule.Name)
+		} else {
+			flagged = append(flagged, rule.Name)
+		}
+	}
+	if len(rejected) > 0 {
+		return nil, &RejectedError{Rules: rejected}
+	}
+	return flagged, nil
+}
+
+func (r compiledRule) matches(text string) bool {
+	if r.Check != Luhn {
+		return r.re.MatchString(text)
+	}
+	for _, match := range r.re.FindAllString(text, -1) {
+		if luhnValid(match) {
+			return true
+		}
+	}
+	return false
+}
+
+// luhnValid checks the digits of the number ignoring the separators
+func luhnValid(number string) bool {
+	var sum, n int
+	for i := len(number) - 1; i >= 0; i-- {
+		c := number[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		d := int(c - '0')
+		if n%2 == 1 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		n++
+	}
+	return n > 0 && sum%10 == 0
+}
@@ -0,0 +1,268 @@
+package secret_server_task
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Errors of the dead man's switch secrets
+var (
+	ErrInvalidCheckInInterval = errors.New("invalid checkInInterval, the value should be positive")
+	// ErrSwitchReleased is returned by CheckIn after the deadline passed, the secret is already available
+	ErrSwitchReleased = errors.New("dead man's switch is already released")
+)
+
+// WithDeadMansSwitch makes the secret the dead man's switch: it becomes available and releaseURL is notified
+// with its link only if the creator doesn't check in for the interval. NotBefore is the deadline of the next check-in
+func WithDeadMansSwitch(interval time.Duration, releaseURL string) SecretOption {
+	return func(s *Secret) {
+		s.CheckInInterval, s.ReleaseURL = interval, releaseURL
+		s.NotBefore = s.CreatedAt.Add(interval)
+	}
+}
+
+// IsSwitch reports whether the secret is the dead man's switch
+func (s *Secret) IsSwitch() bool {
+	return s.CheckInInterval > 0
+}
+
+// CheckInStorage is implemented by the storages which keep the dead man's switch secrets
+type CheckInStorage interface {
+	// CheckIn moves the deadline of the switch secret of the owner CheckInInterval from now and returns its metadata.
+	// Returns ErrNotFound if the owner has no such switch and ErrSwitchReleased if the deadline passed
+	CheckIn(key, owner string) (Secret, error)
+}
+
+// CheckInStorageOf returns the CheckInStorage of the storage or of its base storage
+func CheckInStorageOf(st Storage) (CheckInStorage, bool) {
+	if c, ok := st.(CheckInStorage); ok {
+		return c, true
+	}
+	c, ok := Base(st).(CheckInStorage)
+	return c, ok
+}
+
+// ReleaseStorage is implemented by the storages which report the dead man's switch secrets to release
+type ReleaseStorage interface {
+	// DueReleases returns the metadata of at most limit switch secrets whose deadline passed
+	// and whose release is not recorded yet, the soonest deadlines first
+	DueReleases(limit int) ([]Secret, error)
+	// MarkReleased records that the release of the secret was sent, so it is not returned by DueReleases again
+	MarkReleased(key string) error
+}
+
+// checkIn moves the deadline of the switch secret of the owner
+func (s *Secret) checkIn(owner string, now time.Time) error {
+	if !s.IsSwitch() || owner == "" || s.Owner != owner {
+		return ErrNotFound
+	}
+	if s.IsExpiredAt(now) {
+		_, err := s.unavailable()
+		return err
+	}
+	if s.Released || !now.Before(s.NotBefore) {
+		return ErrSwitchReleased
+	}
+	deadline := now.Add(s.CheckInInterval)
+	if !s.ExpiresAt.IsZero() && !deadline.Before(s.ExpiresAt) {
+		return ErrInvalidNotBefore
+	}
+	s.NotBefore = deadline
+	return nil
+}
+
+// isDueRelease reports whether the deadline of the switch secret passed and it is not released yet
+func (s *Secret) isDueRelease(now time.Time) bool {
+	return s.IsSwitch() && !s.Released && s.IsAvailableAt(now)
+}
+
+// CheckIn
+func (st *memStorage) CheckIn(key, owner string) (Secret, error) {
+	value, ok := st.values.Load(key)
+	if !ok {
+		return Secret{}, ErrNotFound
+	}
+	mSecret := value.(*memSecret)
+	mSecret.mu.Lock()
+	defer mSecret.mu.Unlock()
+	if !mSecret.deletedAt.IsZero() {
+		return Secret{}, ErrNotFound
+	}
+	if err := mSecret.checkIn(owner, st.clock.Now()); err != nil {
+		return Secret{}, err
+	}
+	return mSecret.metadata(), nil
+}
+
+// DueReleases
+func (st *memStorage) DueReleases(limit int) ([]Secret, error) {
+	due := []Secret{}
+	st.values.Range(func(key, value interface{}) bool {
+		mSecret := value.(*memSecret)
+		mSecret.mu.Lock()
+		defer mSecret.mu.Unlock()
+		if mSecret.deletedAt.IsZero() && mSecret.isDueRelease(st.clock.Now()) {
+			due = append(due, mSecret.metadata())
+		}
+		return true
+	})
+	sort.Slice(due, func(i, j int) bool { return due[i].NotBefore.Before(due[j].NotBefore) })
+	if len(due) > limit {
+		due = due[:limit]
+	}
+	return due, nil
+}
+
+// MarkReleased
+func (st *memStorage) MarkReleased(key string) error {
+	value, ok := st.values.Load(key)
+	if !ok {
+		return ErrNotFound
+	}
+	mSecret := value.(*memSecret)
+	mSecret.mu.Lock()
+	mSecret.Released = true
+	mSecret.mu.Unlock()
+	return nil
+}
+
+// CheckIn
+func (st *pgStorage) CheckIn(key, owner string) (Secret, error) {
+	var secret Secret
+	err := st.retry(true, func() (err error) {
+		secret, err = st.checkInTx(key, owner)
+		return err
+	})
+	if err != nil && !errors.Is(err, ErrSecretNotAvailable) && err != ErrSwitchReleased && err != ErrInvalidNotBefore {
+		return Secret{}, &BackendError{Op: "check-in", Err: err}
+	}
+	return secret, err
+}
+
+func (st *pgStorage) checkInTx(key, owner string) (Secret, error) {
+	tx, err := st.db.Beginx()
+	if err != nil {
+		return Secret{}, err
+	}
+
+	var pSecret pgSecret
+	err = tx.Get(&pSecret, "SELECT "+pgSecretColumns+" FROM secret WHERE id=$1 AND deleted_at IS NULL FOR UPDATE", key)
+	if err == sql.ErrNoRows {
+		err = ErrNotFound
+	}
+	secret := pSecret.ToSecret()
+	if err == nil {
+		err = secret.checkIn(owner, st.clock.Now())
+	}
+	if err == nil {
+		_, err = tx.Exec("UPDATE secret SET not_before = $2 WHERE id=$1", key, secret.NotBefore)
+	}
+	if err != nil {
+		if e := tx.Rollback(); e != nil {
+			log.Println(e)
+		}
+		return Secret{}, err
+	}
+	if err = tx.Commit(); err != nil {
+		return Secret{}, commitError{err}
+	}
+	return secret.metadata(), nil
+}
+
+// DueReleases
+func (st *pgStorage) DueReleases(limit int) ([]Secret, error) {
+	q := "SELECT " + pgSecretColumns + " FROM secret WHERE check_in_interval > 0 AND NOT released AND deleted_at IS NULL" +
+		" AND not_before <= $1 AND remaining_views > 0 AND (expires_at IS NULL OR expires_at > $1) ORDER BY not_before LIMIT $2"
+	var rows []pgSecret
+	err := st.retry(true, func() error {
+		rows = nil
+		return st.db.Select(&rows, q, st.clock.Now(), limit)
+	})
+	if err != nil {
+		return nil, &BackendError{Op: "due releases", Err: err}
+	}
+	due := make([]Secret, 0, len(rows))
+	for _, p := range rows {
+		due = append(due, p.ToSecret().metadata())
+	}
+	return due, nil
+}
+
+// MarkReleased
+func (st *pgStorage) MarkReleased(key string) error {
+	var updated int64
+	err := st.retry(true, func() error {
+		res, err := st.db.Exec("UPDATE secret SET released = TRUE WHERE id=$1", key)
+		if err == nil {
+			updated, err = res.RowsAffected()
+		}
+		return err
+	})
+	if err != nil {
+		return &BackendError{Op: "mark released", Err: err}
+	}
+	if updated == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// CheckIn
+func (st *tenantStorage) CheckIn(key, owner string) (Secret, error) {
+	c, ok := CheckInStorageOf(st.Storage)
+	if !ok || strings.Contains(key, "/") {
+		return Secret{}, ErrNotFound
+	}
+	s, err := c.CheckIn(tenantKeyPrefix(st.tenant)+key, owner)
+	if err != nil {
+		return Secret{}, err
+	}
+	s.Hash = key
+	return s, nil
+}
+
+// CheckIn checks in at the shard keeping the secret
+func (st *shardedStorage) CheckIn(key, owner string) (Secret, error) {
+	return st.lookup(key, func(s Storage) (Secret, error) {
+		c, ok := Base(s).(CheckInStorage)
+		if !ok {
+			return Secret{}, ErrSecretNotAvailable
+		}
+		return c.CheckIn(key, owner)
+	})
+}
+
+// DueReleases collects the due releases of the shards, the soonest deadlines first
+func (st *shardedStorage) DueReleases(limit int) ([]Secret, error) {
+	due := []Secret{}
+	for _, s := range st.shards {
+		if r, ok := Base(s.Storage).(ReleaseStorage); ok {
+			shardDue, err := r.DueReleases(limit)
+			if err != nil {
+				return nil, err
+			}
+			due = append(due, shardDue...)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool { return due[i].NotBefore.Before(due[j].NotBefore) })
+	if len(due) > limit {
+		due = due[:limit]
+	}
+	return due, nil
+}
+
+// MarkReleased
+func (st *shardedStorage) MarkReleased(key string) error {
+	_, err := st.lookup(key, func(s Storage) (Secret, error) {
+		r, ok := Base(s).(ReleaseStorage)
+		if !ok {
+			return Secret{}, ErrSecretNotAvailable
+		}
+		return Secret{}, r.MarkReleased(key)
+	})
+	return err
+}
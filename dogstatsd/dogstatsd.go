@@ -0,0 +1,206 @@
+// Package dogstatsd exports the metrics of the prometheus gatherer to the Datadog agent over DogStatsD,
+// for the environments without Prometheus. The same collectors are reported: the counters as the deltas
+// since the previous flush, the gauges as they are, the histograms and the summaries as the count, sum
+// and bucket deltas (tagged upper_bound) and the quantile gauges (tagged quantile), the way the Datadog
+// OpenMetrics integration maps them. The labels become the tags.
+package dogstatsd
+
+import (
+	"errors"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// ErrInvalidAddress is returned if the address is neither host:port nor unix:///path
+var ErrInvalidAddress = errors.New("dogstatsd address should be host:port or unix:///path/to/socket")
+
+const (
+	// maxUDPPacket fits the datagram into the common MTU, the agent also accepts the larger ones on UDS
+	maxUDPPacket  = 1432
+	maxUnixPacket = 8192
+)
+
+// Config describes the agent and the reported metrics
+type Config struct {
+	// Address is host:port of the UDP listener of the agent or unix:///path of its socket
+	Address string
+	// Prefix is prepended to the metric names, e.g. "secret_server."
+	Prefix string
+	// Tags are added to every metric, e.g. env:prod
+	Tags []string
+	// Interval is the period of the flushes, 10s if zero
+	Interval time.Duration
+}
+
+// Exporter flushes the gathered metrics periodically
+type Exporter struct {
+	gatherer  prometheus.Gatherer
+	config    Config
+	conn      net.Conn
+	maxPacket int
+
+	mu sync.Mutex
+	// previous holds the cumulative values of the last flush, the deltas are computed from them
+	previous map[string]float64
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// Start connects to the agent and flushes the metrics of the gatherer every interval until Close
+func Start(g prometheus.Gatherer, c Config) (*Exporter, error) {
+	network, address, maxPacket := "udp", c.Address, maxUDPPacket
+	if strings.HasPrefix(address, "unix://") {
+		network, address, maxPacket = "unixgram", strings.TrimPrefix(address, "unix://"), maxUnixPacket
+	} else if _, _, err := net.SplitHostPort(address); err != nil {
+		return nil, ErrInvalidAddress
+	}
+	if address == "" {
+		return nil, ErrInvalidAddress
+	}
+	conn, err := net.Dial(network, address)
+	if err != nil {
+		return nil, err
+	}
+	if c.Interval <= 0 {
+		c.Interval = 10 * time.Second
+	}
+	e := &Exporter{
+		gatherer:  g,
+		config:    c,
+		conn:      conn,
+		maxPacket: maxPacket,
+		previous:  map[string]float64{},
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	go e.run()
+	return e, nil
+}
+
+func (e *Exporter) run() {
+	defer close(e.done)
+	ticker := time.NewTicker(e.config.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := e.Flush(); err != nil {
+				log.Println("dogstatsd: flush failed: ", err)
+			}
+		case <-e.stop:
+			return
+		}
+	}
+}
+
+// Close stops the periodic flushes and sends the last one. It is a no-op on nil
+func (e *Exporter) Close() error {
+	if e == nil {
+		return nil
+	}
+	close(e.stop)
+	<-e.done
+	err := e.Flush()
+	_ = e.conn.Close()
+	return err
+}
+
+// Flush sends the current metrics. The agent is not acknowledging them,
+// so the error means only the packet couldn't be sent, e.g. the socket is missing
+func (e *Exporter) Flush() error {
+	families, err := e.gatherer.Gather()
+	if err != nil && len(families) == 0 {
+		return err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var packet []byte
+	var sendErr error
+	emit := func(name string, value float64, typ string, tags []string) {
+		line := e.config.Prefix + name + ":" + strconv.FormatFloat(value, 'f', -1, 64) + "|" + typ
+		if all := append(tags[:len(tags):len(tags)], e.config.Tags...); len(all) > 0 {
+			line += "|#" + strings.Join(all, ",")
+		}
+		if len(packet) > 0 && len(packet)+1+len(line) > e.maxPacket {
+			if _, err := e.conn.Write(packet); err != nil && sendErr == nil {
+				sendErr = err
+			}
+			packet = packet[:0]
+		}
+		if len(packet) > 0 {
+			packet = append(packet, '\n')
+		}
+		packet = append(packet, line...)
+	}
+	// count emits the delta of the cumulative value since the previous flush, the reset counter restarts from 0
+	count := func(name string, value float64, tags []string) {
+		key := name + "|" + strings.Join(tags, ",")
+		delta := value - e.previous[key]
+		if delta < 0 {
+			delta = value
+		}
+		e.previous[key] = value
+		if delta != 0 {
+			emit(name, delta, "c", tags)
+		}
+	}
+
+	for _, mf := range families {
+		name := mf.GetName()
+		for _, m := range mf.Metric {
+			tags := labelTags(m.Label)
+			switch mf.GetType() {
+			case dto.MetricType_COUNTER:
+				count(name, m.Counter.GetValue(), tags)
+			case dto.MetricType_GAUGE:
+				emit(name, m.Gauge.GetValue(), "g", tags)
+			case dto.MetricType_SUMMARY:
+				for _, q := range m.Summary.Quantile {
+					emit(name+".quantile", q.GetValue(), "g", withTag(tags, "quantile:"+strconv.FormatFloat(q.GetQuantile(), 'f', -1, 64)))
+				}
+				count(name+".count", float64(m.Summary.GetSampleCount()), tags)
+				count(name+".sum", m.Summary.GetSampleSum(), tags)
+			case dto.MetricType_HISTOGRAM:
+				for _, b := range m.Histogram.Bucket {
+					count(name+".bucket", float64(b.GetCumulativeCount()), withTag(tags, "upper_bound:"+strconv.FormatFloat(b.GetUpperBound(), 'f', -1, 64)))
+				}
+				count(name+".bucket", float64(m.Histogram.GetSampleCount()), withTag(tags, "upper_bound:none"))
+				count(name+".count", float64(m.Histogram.GetSampleCount()), tags)
+				count(name+".sum", m.Histogram.GetSampleSum(), tags)
+			default:
+				emit(name, m.Untyped.GetValue(), "g", tags)
+			}
+		}
+	}
+	if len(packet) > 0 {
+		if _, err := e.conn.Write(packet); err != nil && sendErr == nil {
+			sendErr = err
+		}
+	}
+	return sendErr
+}
+
+// tagReplacer removes the separators of the DogStatsD format from the label values
+var tagReplacer = strings.NewReplacer(",", "_", "|", "_", "#", "_", "\n", "_")
+
+func labelTags(labels []*dto.LabelPair) []string {
+	tags := make([]string, 0, len(labels))
+	for _, lp := range labels {
+		tags = append(tags, lp.GetName()+":"+tagReplacer.Replace(lp.GetValue()))
+	}
+	return tags
+}
+
+// withTag returns the copy of the tags with the extra one, the tags of the series are shared by its samples
+func withTag(tags []string, tag string) []string {
+	return append(tags[:len(tags):len(tags)], tag)
+}
@@ -0,0 +1,79 @@
+package dogstatsd_test
+
+import (
+	"net"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/evsan/secret-server-task/dogstatsd"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestExporter(t *testing.T) {
+	agent, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+	defer agent.Close()
+	read := func() []string {
+		buf := make([]byte, 65536)
+		_ = agent.SetReadDeadline(time.Now().Add(time.Second))
+		n, _, err := agent.ReadFrom(buf)
+		if err != nil {
+			t.Fatal("error is not expected: ", err)
+		}
+		lines := strings.Split(string(buf[:n]), "\n")
+		sort.Strings(lines)
+		return lines
+	}
+
+	reg := prometheus.NewRegistry()
+	requests := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "requests_total", Help: "Requests"}, []string{"path"})
+	latency := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "latency_seconds", Help: "Latency", Buckets: []float64{0.5}})
+	queue := prometheus.NewGauge(prometheus.GaugeOpts{Name: "queue", Help: "Queue"})
+	reg.MustRegister(requests, latency, queue)
+
+	exporter, err := dogstatsd.Start(reg, dogstatsd.Config{
+		Address:  agent.LocalAddr().String(),
+		Prefix:   "sst.",
+		Tags:     []string{"env:test"},
+		Interval: time.Hour,
+	})
+	if err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+
+	requests.WithLabelValues("/a,b").Add(3)
+	latency.Observe(0.25)
+	queue.Set(7)
+	if err = exporter.Flush(); err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+	expected := []string{
+		"sst.latency_seconds.bucket:1|c|#upper_bound:0.5,env:test",
+		"sst.latency_seconds.bucket:1|c|#upper_bound:none,env:test",
+		"sst.latency_seconds.count:1|c|#env:test",
+		"sst.latency_seconds.sum:0.25|c|#env:test",
+		"sst.queue:7|g|#env:test",
+		"sst.requests_total:3|c|#path:/a_b,env:test",
+	}
+	if lines := read(); strings.Join(lines, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("expected: %v, result: %v", expected, lines)
+	}
+
+	// The counters report the deltas, the unchanged ones are not sent
+	requests.WithLabelValues("/a,b").Inc()
+	if err = exporter.Close(); err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+	expected = []string{"sst.queue:7|g|#env:test", "sst.requests_total:1|c|#path:/a_b,env:test"}
+	if lines := read(); strings.Join(lines, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("expected: %v, result: %v", expected, lines)
+	}
+
+	if _, err = dogstatsd.Start(reg, dogstatsd.Config{Address: "agent"}); err != dogstatsd.ErrInvalidAddress {
+		t.Fatalf("expected: %v, result: %v", dogstatsd.ErrInvalidAddress, err)
+	}
+}
@@ -0,0 +1,72 @@
+package secret_server_task
+
+import (
+	"errors"
+)
+
+// The errors of the unavailable secrets by the reason. They all match ErrSecretNotAvailable with errors.Is,
+// so the callers which don't need the reason keep comparing to it. errors.As with *UnavailableError returns the reason
+var (
+	// ErrNotFound is returned for the secret which never existed, or was purged, revoked or erased
+	ErrNotFound error = &UnavailableError{Reason: ReasonNotFound}
+	// ErrExpired is returned for the secret whose TTL passed
+	ErrExpired error = &UnavailableError{Reason: ReasonExpired}
+	// ErrViewsExhausted is returned for the secret whose last view is consumed
+	ErrViewsExhausted error = &UnavailableError{Reason: ReasonConsumed}
+)
+
+// ErrBackendUnavailable is matched by the errors of the storage backend, e.g. the lost database connection.
+// The secret may be available, the operation should be run again later
+var ErrBackendUnavailable = errors.New("storage backend is unavailable")
+
+// UnavailableError is the error of the secret which can't be retrieved for the reason
+type UnavailableError struct {
+	Reason UnavailableReason
+}
+
+func (e *UnavailableError) Error() string {
+	return ErrSecretNotAvailable.Error() + ": " + string(e.Reason)
+}
+
+// Is matches ErrSecretNotAvailable and the errors with the same reason
+func (e *UnavailableError) Is(target error) bool {
+	if target == ErrSecretNotAvailable {
+		return true
+	}
+	t, ok := target.(*UnavailableError)
+	return ok && t.Reason == e.Reason
+}
+
+// Err returns the error of the reason, ErrSecretNotAvailable if the reason isn't known
+func (r UnavailableReason) Err() error {
+	switch r {
+	case ReasonNotFound:
+		return ErrNotFound
+	case ReasonExpired:
+		return ErrExpired
+	case ReasonConsumed:
+		return ErrViewsExhausted
+	}
+	return ErrSecretNotAvailable
+}
+
+// BackendError is the failure of the storage backend during the operation. It matches ErrBackendUnavailable
+// and unwraps to the cause, e.g. *pq.Error
+type BackendError struct {
+	// Op is the storage operation, e.g. "get"
+	Op  string
+	Err error
+}
+
+func (e *BackendError) Error() string {
+	return e.Op + ": " + ErrBackendUnavailable.Error() + ": " + e.Err.Error()
+}
+
+func (e *BackendError) Unwrap() error {
+	return e.Err
+}
+
+// Is matches ErrBackendUnavailable
+func (e *BackendError) Is(target error) bool {
+	return target == ErrBackendUnavailable
+}
@@ -0,0 +1,86 @@
+package secret_server_task_test
+
+import (
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	sst "github.com/evsan/secret-server-task"
+)
+
+func TestMemStorage_TypedErrors(t *testing.T) {
+	clock := sst.NewManualClock(time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC))
+	storage := sst.NewMemStorage(sst.WithMemClock(clock))
+
+	consumed, err := storage.Store(secretText, 1, 0)
+	if err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+	if _, err = storage.Get(consumed.Hash); err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+	expired, err := storage.Store(secretText, remainingViews, expiresDelta)
+	if err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+	clock.Add(expiresDelta * time.Minute)
+
+	// The steps are ordered, the unavailable secret is removed by the first attempt
+	steps := []struct {
+		name   string
+		hash   string
+		err    error
+		reason sst.UnavailableReason
+	}{
+		{"consumed", consumed.Hash, sst.ErrViewsExhausted, sst.ReasonConsumed},
+		{"consumed again", consumed.Hash, sst.ErrNotFound, sst.ReasonNotFound},
+		{"expired", expired.Hash, sst.ErrExpired, sst.ReasonExpired},
+		{"expired again", expired.Hash, sst.ErrNotFound, sst.ReasonNotFound},
+		{"unknown", sst.GenHashKey(), sst.ErrNotFound, sst.ReasonNotFound},
+	}
+	for _, step := range steps {
+		_, err := storage.Get(step.hash)
+		if !errors.Is(err, step.err) || !errors.Is(err, sst.ErrSecretNotAvailable) {
+			t.Fatalf("%s: expected: %s, result: %v", step.name, step.err, err)
+		}
+		var unavailable *sst.UnavailableError
+		if !errors.As(err, &unavailable) || unavailable.Reason != step.reason {
+			t.Fatalf("%s: expected: %s, result: %v", step.name, step.reason, unavailable)
+		}
+	}
+}
+
+func TestMemStorage_TypedErrorsRetention(t *testing.T) {
+	storage := sst.NewMemStorage(sst.WithMemRetention(time.Hour))
+	secret, err := storage.Store(secretText, 1, 0)
+	if err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+	if _, err = storage.Get(secret.Hash); err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+	s, err := storage.Get(secret.Hash)
+	if !errors.Is(err, sst.ErrViewsExhausted) || errors.Is(err, sst.ErrExpired) {
+		t.Fatalf("expected: %s, result: %v", sst.ErrViewsExhausted, err)
+	}
+	if s.Unavailable.Err() != sst.ErrViewsExhausted {
+		t.Fatalf("expected: %s, result: %v", sst.ErrViewsExhausted, s.Unavailable.Err())
+	}
+}
+
+func TestBackendError(t *testing.T) {
+	var err error = &sst.BackendError{Op: "get", Err: io.ErrUnexpectedEOF}
+	if !errors.Is(err, sst.ErrBackendUnavailable) {
+		t.Fatalf("expected: %s, result: %v", sst.ErrBackendUnavailable, err)
+	}
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("expected: %s, result: %v", io.ErrUnexpectedEOF, err)
+	}
+	if errors.Is(err, sst.ErrSecretNotAvailable) {
+		t.Fatal("backend error is not the unavailable secret")
+	}
+	if err.Error() != "get: storage backend is unavailable: unexpected EOF" {
+		t.Fatalf("unexpected message: %s", err)
+	}
+}
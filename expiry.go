@@ -0,0 +1,30 @@
+package secret_server_task
+
+// NativeExpiry is implemented by the storages whose backend removes the expired records itself,
+// e.g. the Redis key expiry or the DynamoDB and Mongo TTL indexes. The janitor doesn't sweep them,
+// but Get still checks IsAvailable, because the backends remove the records with a delay
+// (DynamoDB within days) and the secret must never be served after its expiry.
+// The backend TTL doesn't know about the views, so the storage removes the consumed secret on its last view
+type NativeExpiry interface {
+	// ExpiresNatively reports whether the backend enforces ExpiresAt
+	ExpiresNatively() bool
+}
+
+// ExpiresNatively reports whether the storage or its base storage delegates the expiry to the backend
+func ExpiresNatively(st Storage) bool {
+	if n, ok := st.(NativeExpiry); ok {
+		return n.ExpiresNatively()
+	}
+	n, ok := Base(st).(NativeExpiry)
+	return ok && n.ExpiresNatively()
+}
+
+// ExpiresNatively is true if all the shards delegate the expiry, PurgeExpired sweeps only the other ones
+func (st *shardedStorage) ExpiresNatively() bool {
+	for _, s := range st.shards {
+		if !ExpiresNatively(s.Storage) {
+			return false
+		}
+	}
+	return true
+}
@@ -1,13 +1,23 @@
 module github.com/evsan/secret-server-task
 
-go 1.12
+go 1.22
 
 require (
+	filippo.io/age v1.1.1
 	github.com/google/uuid v1.1.1
-	github.com/gorilla/mux v1.7.2
 	github.com/jmoiron/sqlx v1.2.0
 	github.com/lib/pq v1.0.0
 	github.com/prometheus/client_golang v1.0.0
-	github.com/urfave/negroni v1.0.0
+	github.com/prometheus/client_model v0.0.0-20190129233127-fd36f4220a90
+	golang.org/x/sys v0.3.0
+)
+
+require (
+	github.com/beorn7/perks v1.0.0 // indirect
+	github.com/golang/protobuf v1.3.1 // indirect
+	github.com/matttproud/golang_protobuf_extensions v1.0.1 // indirect
+	github.com/prometheus/common v0.4.1 // indirect
+	github.com/prometheus/procfs v0.0.2 // indirect
+	golang.org/x/crypto v0.4.0 // indirect
 	google.golang.org/appengine v1.6.1 // indirect
 )
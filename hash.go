@@ -0,0 +1,119 @@
+package secret_server_task
+
+import (
+	"errors"
+	"io"
+	"math"
+	"strings"
+)
+
+// ErrInvalidHashFormat is returned for the hash formats which are too weak or not safe in the URL path
+var ErrInvalidHashFormat = errors.New("invalid hash format, it should have at least 64 bits of entropy and consist of unique unreserved URL characters")
+
+// maxHashAttempts is how many hashes are generated for the secret while they collide with the stored ones
+const maxHashAttempts = 3
+
+// Hash format limits
+const (
+	minHashEntropy = 64
+	maxHashLength  = 128
+	urlUnreserved  = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789-._~"
+)
+
+// HashFormat describes the generated hashes: Length characters of Alphabet
+type HashFormat struct {
+	Length   int    `json:"length"`
+	Alphabet string `json:"alphabet"`
+}
+
+// DefaultHashFormat is the format of GenHashKey: 128 bits of the UUID as hex
+var DefaultHashFormat = HashFormat{Length: 32, Alphabet: "0123456789abcdef"}
+
+// Validate checks that the format is strong enough and safe in the URL path
+func (f HashFormat) Validate() error {
+	if f.Length <= 0 || f.Length > maxHashLength || len(f.Alphabet) < 2 {
+		return ErrInvalidHashFormat
+	}
+	for i := 0; i < len(f.Alphabet); i++ {
+		c := f.Alphabet[i]
+		if strings.IndexByte(urlUnreserved, c) < 0 || strings.IndexByte(f.Alphabet[i+1:], c) >= 0 {
+			return ErrInvalidHashFormat
+		}
+	}
+	if float64(f.Length)*math.Log2(float64(len(f.Alphabet))) < minHashEntropy {
+		return ErrInvalidHashFormat
+	}
+	return nil
+}
+
+// Generate returns the random hash of the format. The bytes which would make the distribution
+// of the characters uneven are skipped
+func (f HashFormat) Generate() string {
+	n := len(f.Alphabet)
+	limit := 256 - 256%n
+	result := make([]byte, 0, f.Length)
+	buf := make([]byte, f.Length)
+	for len(result) < f.Length {
+		if _, err := io.ReadFull(idSource, buf); err != nil {
+			panic(err)
+		}
+		for _, b := range buf {
+			if int(b) < limit && len(result) < f.Length {
+				result = append(result, f.Alphabet[int(b)%n])
+			}
+		}
+	}
+	return string(result)
+}
+
+// Match reports whether the hash can be generated by the format. It doesn't allocate,
+// so the malformed keys are rejected cheaply before the storage is queried
+func (f HashFormat) Match(hash string) bool {
+	if len(hash) != f.Length {
+		return false
+	}
+	for i := 0; i < len(hash); i++ {
+		if strings.IndexByte(f.Alphabet, hash[i]) < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// WithHashFormat generates the hash of the secret in the format. It must precede the options prefixing the hash
+func WithHashFormat(f HashFormat) SecretOption {
+	return func(s *Secret) {
+		s.Hash = f.Generate()
+	}
+}
+
+// WithHash stores the secret at the hash chosen by the client instead of the generated one.
+// Store returns ErrSecretExists if it is taken. It must precede the options prefixing the hash
+func WithHash(hash string) SecretOption {
+	return func(s *Secret) {
+		s.Hash = hash
+	}
+}
+
+// storeUnique stores the secret created by newSecret, which is called again for the new hash while insert
+// returns ErrSecretExists. The hash fixed by the options doesn't change, ErrSecretExists is returned for it
+func storeUnique(newSecret func() (Secret, error), insert func(Secret) error) (Secret, error) {
+	var previous string
+	for attempt := 0; attempt < maxHashAttempts; attempt++ {
+		s, err := newSecret()
+		if err != nil {
+			return Secret{}, err
+		}
+		if s.Hash == previous {
+			break
+		}
+		previous = s.Hash
+		if err = insert(s); err != ErrSecretExists {
+			if err != nil {
+				return Secret{}, err
+			}
+			return s, nil
+		}
+	}
+	return Secret{}, ErrSecretExists
+}
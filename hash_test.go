@@ -0,0 +1,50 @@
+package secret_server_task_test
+
+import (
+	"testing"
+
+	sst "github.com/evsan/secret-server-task"
+)
+
+func TestHashFormat_Validate(t *testing.T) {
+	testCases := map[string]struct {
+		Format   sst.HashFormat
+		ExpError error
+	}{
+		"default":        {Format: sst.DefaultHashFormat},
+		"base62":         {Format: sst.HashFormat{Length: 22, Alphabet: "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789"}},
+		"weak":           {Format: sst.HashFormat{Length: 10, Alphabet: "0123456789abcdef"}, ExpError: sst.ErrInvalidHashFormat},
+		"slash":          {Format: sst.HashFormat{Length: 32, Alphabet: "0123456789abcde/"}, ExpError: sst.ErrInvalidHashFormat},
+		"duplicated":     {Format: sst.HashFormat{Length: 64, Alphabet: "0120"}, ExpError: sst.ErrInvalidHashFormat},
+		"empty alphabet": {Format: sst.HashFormat{Length: 32}, ExpError: sst.ErrInvalidHashFormat},
+		"too long":       {Format: sst.HashFormat{Length: 129, Alphabet: "01"}, ExpError: sst.ErrInvalidHashFormat},
+	}
+	for name, tst := range testCases {
+		t.Run(name, func(t *testing.T) {
+			if err := tst.Format.Validate(); err != tst.ExpError {
+				t.Fatalf("expected: %v, result: %v", tst.ExpError, err)
+			}
+		})
+	}
+}
+
+func TestHashFormat_Generate(t *testing.T) {
+	f := sst.HashFormat{Length: 22, Alphabet: "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789"}
+	seen := map[string]bool{}
+	for i := 0; i < 1000; i++ {
+		hash := f.Generate()
+		if !f.Match(hash) {
+			t.Fatalf("generated hash %q doesn't match the format", hash)
+		}
+		if seen[hash] {
+			t.Fatalf("hash %q is generated twice", hash)
+		}
+		seen[hash] = true
+	}
+	if !sst.DefaultHashFormat.Match(sst.GenHashKey()) {
+		t.Fatal("GenHashKey doesn't match the default format")
+	}
+	if f.Match("short") || f.Match("!!!!!!!!!!!!!!!!!!!!!!") {
+		t.Fatal("malformed hash matches the format")
+	}
+}
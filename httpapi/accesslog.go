@@ -0,0 +1,190 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AccessLogFormat is the format of the access log lines
+type AccessLogFormat string
+
+const (
+	// AccessLogText is the human readable "status | latency | host | method path" line
+	AccessLogText AccessLogFormat = "text"
+	// AccessLogJSON is the JSON object per line for the log pipelines
+	AccessLogJSON AccessLogFormat = "json"
+	// AccessLogCLF is the Combined Log Format of Apache and nginx followed by the latency in microseconds
+	// as %D of Apache. The referer is never logged
+	AccessLogCLF AccessLogFormat = "clf"
+)
+
+// ErrInvalidAccessLogFormat is returned for the format other than text, json and clf
+var ErrInvalidAccessLogFormat = errors.New("invalid access log format, it should be text, json or clf")
+
+// ParseAccessLogFormat returns the format of the name, empty is text
+func ParseAccessLogFormat(name string) (AccessLogFormat, error) {
+	switch f := AccessLogFormat(name); f {
+	case "", AccessLogText:
+		return AccessLogText, nil
+	case AccessLogJSON, AccessLogCLF:
+		return f, nil
+	}
+	return "", ErrInvalidAccessLogFormat
+}
+
+// AccessLogConfig configures StructuredAccessLogger
+type AccessLogConfig struct {
+	// Format is text if empty. The json and clf lines are complete, the logger should have no prefix and flags
+	Format AccessLogFormat
+	// TrustedProxies are the networks of the proxies whose X-Forwarded-For is trusted for the client address
+	TrustedProxies []*net.IPNet
+}
+
+// ParseTrustedProxies parses the comma separated list of the CIDRs and the addresses of the proxies
+func ParseTrustedProxies(list string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		if !strings.Contains(item, "/") {
+			ip := net.ParseIP(item)
+			if ip == nil {
+				return nil, errors.New("invalid proxy address " + item)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				bits = 8 * net.IPv4len
+			}
+			item += "/" + strconv.Itoa(bits)
+		}
+		_, n, err := net.ParseCIDR(item)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// accessLogEntry is the line of the json format
+type accessLogEntry struct {
+	Time      time.Time `json:"time"`
+	Method    string    `json:"method"`
+	Host      string    `json:"host"`
+	Path      string    `json:"path"`
+	Route     string    `json:"route,omitempty"`
+	Status    int       `json:"status"`
+	Bytes     int64     `json:"bytes"`
+	LatencyMs float64   `json:"latencyMs"`
+	ClientIP  string    `json:"clientIp"`
+	UserAgent string    `json:"userAgent"`
+}
+
+// routeKey is the context key of the route template of the request
+type routeKey struct{}
+
+// recordRoute passes the pattern the router matches to the access logger
+func recordRoute(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if route, ok := r.Context().Value(routeKey{}).(*string); ok {
+			_, *route = mux.Handler(r)
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// StructuredAccessLogger logs the requests according to the level and the sampling of the control
+// with the route template, the status, the bytes written, the latency, the client address and the User-Agent
+func StructuredAccessLogger(logger *log.Logger, c *LogControl, config AccessLogConfig) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			route := new(string)
+			sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(sw, r.WithContext(context.WithValue(r.Context(), routeKey{}, route)))
+			logged, details := c.logs(sw.status)
+			if !logged {
+				return
+			}
+			latency := time.Since(start)
+
+			switch config.Format {
+			case AccessLogJSON:
+				b, err := json.Marshal(accessLogEntry{
+					Time:      start.UTC(),
+					Method:    r.Method,
+					Host:      r.Host,
+					Path:      r.URL.Path,
+					Route:     *route,
+					Status:    sw.status,
+					Bytes:     sw.bytes,
+					LatencyMs: float64(latency.Microseconds()) / 1000,
+					ClientIP:  clientIP(r, config.TrustedProxies),
+					UserAgent: r.UserAgent(),
+				})
+				if err == nil {
+					logger.Print(string(b))
+				}
+			case AccessLogCLF:
+				// The shared secret links would leak through the referer, so it is always "-"
+				logger.Printf("%s - - [%s] %q %d %d \"-\" %q %d", clientIP(r, config.TrustedProxies), start.Format("02/Jan/2006:15:04:05 -0700"),
+					r.Method+" "+r.URL.RequestURI()+" "+r.Proto, sw.status, sw.bytes, r.UserAgent(), latency.Microseconds())
+			default:
+				if details {
+					logger.Printf("%d | %v | %s | %s %s | %s | %q", sw.status, latency, r.Host, r.Method, r.URL.Path, r.RemoteAddr, r.UserAgent())
+					return
+				}
+				logger.Printf("%d | %v | %s | %s %s", sw.status, latency, r.Host, r.Method, r.URL.Path)
+			}
+		})
+	}
+}
+
+// clientIP returns the address of the client. X-Forwarded-For is read only if the request comes
+// from the trusted proxy, the rightmost untrusted address of the chain is the client
+func clientIP(r *http.Request, trusted []*net.IPNet) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if !isTrustedProxy(host, trusted) {
+		return host
+	}
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if hop == "" {
+			continue
+		}
+		if net.ParseIP(hop) == nil {
+			// The chain is broken, the address of the last trusted hop is the best known
+			return host
+		}
+		if !isTrustedProxy(hop, trusted) {
+			return hop
+		}
+		host = hop
+	}
+	return host
+}
+
+func isTrustedProxy(addr string, trusted []*net.IPNet) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, n := range trusted {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,451 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	sst "github.com/evsan/secret-server-task"
+	"github.com/evsan/secret-server-task/backup"
+)
+
+// AdminStats is the response of GET /admin/stats
+type AdminStats struct {
+	StartedAt time.Time  `json:"startedAt" xml:"startedAt"`
+	Uptime    string     `json:"uptime" xml:"uptime"`
+	Storage   *sst.Stats `json:"storage,omitempty" xml:"storage,omitempty"`
+	// Shards is the health of the shards of the sharded storage
+	Shards []sst.ShardStatus `json:"shards,omitempty" xml:"shards>shard,omitempty"`
+}
+
+// RevokeResult is the response of POST /admin/secret/{hash}/revoke
+type RevokeResult struct {
+	Hash      string    `json:"hash" xml:"hash"`
+	Reason    string    `json:"reason" xml:"reason"`
+	RevokedAt time.Time `json:"revokedAt" xml:"revokedAt"`
+}
+
+// PurgeResult is the response of POST /admin/purge-expired
+type PurgeResult struct {
+	Removed int `json:"removed" xml:"removed"`
+}
+
+// UsageReport is the response of GET /admin/usage
+type UsageReport struct {
+	GeneratedAt time.Time   `json:"generatedAt" xml:"generatedAt"`
+	Since       time.Time   `json:"since" xml:"since"`
+	Usage       []sst.Usage `json:"usage" xml:"usage"`
+}
+
+// SecretInfo is the metadata of the secret listed by GET /admin/secrets, the secret text is never listed
+type SecretInfo struct {
+	Hash           string     `json:"hash" xml:"hash"`
+	Tenant         string     `json:"tenant,omitempty" xml:"tenant,omitempty"`
+	Owner          string     `json:"owner,omitempty" xml:"owner,omitempty"`
+	CreatedAt      time.Time  `json:"createdAt" xml:"createdAt"`
+	ExpiresAt      time.Time  `json:"expiresAt" xml:"expiresAt"`
+	NotBefore      time.Time  `json:"notBefore" xml:"notBefore"`
+	RemainingViews int        `json:"remainingViews" xml:"remainingViews"`
+	Views          int        `json:"views" xml:"views"`
+	Labels         sst.Labels `json:"labels,omitempty" xml:"labels,omitempty"`
+	// Status is set by GET /admin/secrets/search, the listed secrets are live
+	Status sst.SecretStatus `json:"status,omitempty" xml:"status,omitempty"`
+}
+
+// newSecretInfo returns the metadata of the secret
+func newSecretInfo(s sst.Secret) SecretInfo {
+	return SecretInfo{
+		Hash:           strings.TrimPrefix(s.Hash, s.Tenant+"/"),
+		Tenant:         s.Tenant,
+		Owner:          s.Owner,
+		CreatedAt:      s.CreatedAt,
+		ExpiresAt:      s.ExpiresAt,
+		NotBefore:      s.NotBefore,
+		RemainingViews: s.RemainingViews,
+		Views:          s.Views,
+		Labels:         s.Labels,
+	}
+}
+
+// SecretList is the response of GET /admin/secrets
+type SecretList struct {
+	Secrets []SecretInfo `json:"secrets" xml:"secrets>secret"`
+	// Truncated is true if there are more matching secrets than the limit
+	Truncated bool `json:"truncated" xml:"truncated"`
+}
+
+// Listing limits of GET /admin/secrets
+const (
+	defaultListLimit = 100
+	maxListLimit     = 1000
+)
+
+var errListLimit = errors.New("list limit is reached")
+
+// Admin actions passed to the admin hooks
+const (
+	AdminPurge  = "purge"
+	AdminRevoke = "revoke"
+	// AdminBulkRevoke is passed when the bulk revocation finishes, Target is the job
+	AdminBulkRevoke = "bulk-revoke"
+	AdminErase      = "erase"
+	AdminExport     = "export"
+	AdminImport     = "import"
+	AdminPolicy     = "policy"
+	AdminTemplate   = "template"
+	AdminLogLevel   = "log-level"
+	// AdminPreviewToken is passed when the support preview token is issued, Detail is the reason
+	AdminPreviewToken = "preview-token"
+	AdminReload       = "reload"
+)
+
+// AdminEvent is the successful admin action passed to the admin hooks
+type AdminEvent struct {
+	Action string
+	// Target is the hash, the owner, the tenant or the template the action changed, empty for the whole storage
+	Target string
+	// Detail describes the result, e.g. the revocation reason or the amount of the secrets
+	Detail string
+}
+
+// WithAdminHook registers the hook called after every successful admin action which changes
+// or exports the data. The hook runs in the request goroutine, the bulk revocations call it from their own one
+func WithAdminHook(hook func(ctx context.Context, event AdminEvent)) Option {
+	return func(a *App) {
+		a.adminHooks = append(a.adminHooks, hook)
+	}
+}
+
+func (a *App) adminHook(ctx context.Context, event AdminEvent) {
+	for _, hook := range a.adminHooks {
+		hook(ctx, event)
+	}
+}
+
+// NewAdmin creates the handler of the privileged endpoints.
+// It has no authentication on its own: it must be served by the separate protected listener
+// and must never be mounted to the public API.
+func NewAdmin(storage sst.Storage, opts ...Option) http.Handler {
+	a := newApp(storage, opts...)
+
+	router := http.NewServeMux()
+
+	router.HandleFunc("GET /admin/stats", a.adminStatsHandler)
+	router.HandleFunc("POST /admin/purge-expired", a.adminPurgeHandler)
+	router.HandleFunc("GET /admin/secrets", a.adminListHandler)
+	router.HandleFunc("GET /admin/secrets/search", a.adminSearchHandler)
+	router.HandleFunc("GET /admin/export", a.adminExportHandler)
+	router.HandleFunc("POST /admin/secret/{hash}/revoke", a.adminRevokeHandler)
+	router.HandleFunc("POST /admin/revocations", a.adminBulkRevokeHandler)
+	router.HandleFunc("GET /admin/revocations/{id}", a.adminRevocationHandler)
+	router.HandleFunc("GET /admin/secret/{hash}/tombstone", a.adminTombstoneHandler)
+	if a.previewTokens != nil {
+		router.HandleFunc("POST /admin/secret/{hash}/preview-token", a.adminPreviewTokenHandler)
+	}
+	router.HandleFunc("POST /admin/owners/{owner}/erase", a.adminEraseHandler)
+	if a.reload != nil {
+		router.HandleFunc("POST /admin/reload", a.adminReloadHandler)
+	}
+	router.HandleFunc("GET /admin/usage", a.adminUsageHandler)
+	router.HandleFunc("GET /admin/policies", a.adminGetPoliciesHandler)
+	router.HandleFunc("PUT /admin/policies/{tenant}", a.adminPutTenantPolicyHandler)
+	router.HandleFunc("DELETE /admin/policies/{tenant}", a.adminDeleteTenantPolicyHandler)
+	router.HandleFunc("PUT /admin/templates/{name}", a.adminPutTemplateHandler)
+	router.HandleFunc("DELETE /admin/templates/{name}", a.adminDeleteTemplateHandler)
+	router.HandleFunc("GET /admin/log", a.adminGetLogHandler)
+	router.HandleFunc("PUT /admin/log", a.adminPutLogHandler)
+
+	// The backups are streamed, so the import is the only route without the body limit
+	root := http.NewServeMux()
+	root.HandleFunc("POST /admin/import", a.adminImportHandler)
+	root.Handle("/", a.limitBody(router))
+
+	return traceRequest(a.withMiddleware(root))
+}
+
+func (a *App) adminStatsHandler(w http.ResponseWriter, r *http.Request) {
+	stats := AdminStats{
+		StartedAt: a.startedAt,
+		Uptime:    time.Since(a.startedAt).Round(time.Second).String(),
+	}
+	if st, ok := sst.Base(a.storage).(sst.StatsStorage); ok {
+		s, err := st.Stats()
+		if err != nil {
+			http.Error(w, "Storage stats are not available", http.StatusInternalServerError)
+			return
+		}
+		stats.Storage = &s
+	}
+	stats.Shards, _ = sst.ShardHealth(a.storage)
+	a.dataResponse(stats, w, r)
+}
+
+func (a *App) adminPurgeHandler(w http.ResponseWriter, r *http.Request) {
+	purger, ok := sst.Base(a.storage).(sst.Purger)
+	if !ok {
+		http.Error(w, "Storage doesn't support purging", http.StatusNotImplemented)
+		return
+	}
+	removed, err := purger.PurgeExpired()
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Purge failed", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("admin: purged %d expired secrets", removed)
+	a.adminHook(r.Context(), AdminEvent{Action: AdminPurge, Detail: fmt.Sprintf("purged %d secrets", removed)})
+	a.dataResponse(PurgeResult{Removed: removed}, w, r)
+}
+
+func (a *App) adminRevokeHandler(w http.ResponseWriter, r *http.Request) {
+	revoker, ok := sst.Base(a.storage).(sst.Revoker)
+	if !ok {
+		http.Error(w, "Storage doesn't support revocation", http.StatusNotImplemented)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		a.bodyError(w, r, err, "Invalid input", http.StatusBadRequest)
+		return
+	}
+	key := r.PathValue("hash")
+	reason := r.FormValue("reason")
+
+	err := revoker.Revoke(key, reason)
+	if err != sst.ErrEmptyReason && a.dropDownloads(key, "") > 0 && errors.Is(err, sst.ErrSecretNotAvailable) {
+		// The last view was downloaded, the download is revoked
+		err = nil
+	}
+	switch {
+	case err == nil:
+	case err == sst.ErrEmptyReason:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, sst.ErrSecretNotAvailable):
+		http.Error(w, "Secret not found", http.StatusNotFound)
+		return
+	case backendUnavailable(err, w):
+		return
+	default:
+		log.Println(err)
+		http.Error(w, "Revocation failed", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("admin: revoked secret %s, reason: %q", key, reason)
+	a.adminHook(r.Context(), AdminEvent{Action: AdminRevoke, Target: key, Detail: reason})
+	a.dataResponse(RevokeResult{Hash: key, Reason: reason, RevokedAt: time.Now()}, w, r)
+}
+
+// adminEraseHandler removes all the data of the creator (right to erasure)
+func (a *App) adminEraseHandler(w http.ResponseWriter, r *http.Request) {
+	eraser, ok := sst.Base(a.storage).(sst.Eraser)
+	if !ok {
+		http.Error(w, "Storage doesn't support erasure", http.StatusNotImplemented)
+		return
+	}
+	owner := r.PathValue("owner")
+	report, err := eraser.EraseOwner(owner)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Erasure failed", http.StatusInternalServerError)
+		return
+	}
+	if a.downloads != nil {
+		a.downloads.drop(func(s *downloadSession) bool { return s.owner == owner })
+	}
+	log.Printf("admin: erased %d secrets and %d audit records of %q, digest %s",
+		report.ErasedSecrets, report.ErasedAuditRecords, owner, report.Digest)
+	a.adminHook(r.Context(), AdminEvent{Action: AdminErase, Target: owner, Detail: "digest " + report.Digest})
+	a.dataResponse(report, w, r)
+}
+
+// adminTombstoneHandler returns the tombstone of the consumed or expired secret, kept for -retention
+func (a *App) adminTombstoneHandler(w http.ResponseWriter, r *http.Request) {
+	reader, ok := sst.Base(a.storage).(sst.TombstoneReader)
+	if !ok {
+		http.Error(w, "Storage doesn't keep the tombstones", http.StatusNotImplemented)
+		return
+	}
+	tombstone, err := reader.Tombstone(r.PathValue("hash"))
+	switch {
+	case err == nil:
+	case errors.Is(err, sst.ErrSecretNotAvailable):
+		http.Error(w, "Tombstone not found", http.StatusNotFound)
+		return
+	case backendUnavailable(err, w):
+		return
+	default:
+		log.Println(err)
+		http.Error(w, "Loading the tombstone failed", http.StatusInternalServerError)
+		return
+	}
+	a.dataResponse(tombstone, w, r)
+}
+
+// adminListHandler lists the metadata of the available secrets.
+// The query filters them: ?label=key:value (repeated, all must match), ?owner=, ?tenant= and ?limit=
+func (a *App) adminListHandler(w http.ResponseWriter, r *http.Request) {
+	exporter, ok := sst.Base(a.storage).(sst.Exporter)
+	if !ok {
+		http.Error(w, "Storage doesn't support listing", http.StatusNotImplemented)
+		return
+	}
+	query := r.URL.Query()
+	selector, err := parseLabels(query["label"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// The blinded labels are matched by the blinded selector
+	selector = sst.StoredLabels(a.storage, selector)
+	limit := defaultListLimit
+	if value := query.Get("limit"); value != "" {
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit <= 0 || limit > maxListLimit {
+			http.Error(w, "limit should be between 1 and "+strconv.Itoa(maxListLimit), http.StatusBadRequest)
+			return
+		}
+	}
+	_, tenantSet := query["tenant"]
+	tenant, owner := query.Get("tenant"), query.Get("owner")
+
+	list := SecretList{Secrets: []SecretInfo{}}
+	err = exporter.Export(func(s sst.Secret) error {
+		if (tenantSet && s.Tenant != tenant) || (owner != "" && s.Owner != owner) || !s.Labels.Match(selector) {
+			return nil
+		}
+		if len(list.Secrets) == limit {
+			list.Truncated = true
+			return errListLimit
+		}
+		list.Secrets = append(list.Secrets, newSecretInfo(s))
+		return nil
+	})
+	if err != nil && err != errListLimit {
+		log.Println(err)
+		http.Error(w, "Listing failed", http.StatusInternalServerError)
+		return
+	}
+	// The views and the revocations don't change any timestamp of the list, so only the ETag validates it
+	a.metadataResponse(list, time.Time{}, w, r)
+}
+
+// errFIPSBackup is the response of the backup endpoints in the FIPS mode
+const errFIPSBackup = "Backups are encrypted with age which is not FIPS 140 approved"
+
+// adminExportHandler streams the backup encrypted to the recipients given in the query
+func (a *App) adminExportHandler(w http.ResponseWriter, r *http.Request) {
+	exporter, ok := sst.Base(a.storage).(sst.Exporter)
+	if !ok {
+		http.Error(w, "Storage doesn't support export", http.StatusNotImplemented)
+		return
+	}
+	if a.fips {
+		http.Error(w, errFIPSBackup, http.StatusNotImplemented)
+		return
+	}
+	recipients, err := backup.ParseRecipients(r.URL.Query().Get("recipient"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", `attachment; filename="backup.age"`)
+	count, err := backup.Write(w, exporter, recipients...)
+	if err != nil {
+		// The headers are already sent, the client gets the truncated stream which fails to decrypt
+		log.Println("admin: export failed: ", err)
+		return
+	}
+	log.Printf("admin: exported %d secrets", count)
+	a.adminHook(r.Context(), AdminEvent{Action: AdminExport, Detail: fmt.Sprintf("exported %d secrets", count)})
+}
+
+// adminImportHandler restores the backup posted in the request body.
+// The backup is decrypted with the identities configured with WithBackupIdentities.
+func (a *App) adminImportHandler(w http.ResponseWriter, r *http.Request) {
+	importer, ok := sst.Base(a.storage).(sst.Importer)
+	if !ok {
+		http.Error(w, "Storage doesn't support import", http.StatusNotImplemented)
+		return
+	}
+	if a.fips {
+		http.Error(w, errFIPSBackup, http.StatusNotImplemented)
+		return
+	}
+	if len(a.backupIdentities) == 0 {
+		http.Error(w, "Backup identity is not configured", http.StatusNotImplemented)
+		return
+	}
+
+	result, err := backup.Read(r.Body, importer, a.backupIdentities...)
+	if err != nil {
+		log.Println("admin: import failed: ", err)
+		http.Error(w, "Import failed: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	log.Printf("admin: imported %d secrets, skipped %d expired, %d conflicts", result.Imported, result.Expired, len(result.Conflicts))
+	a.adminHook(r.Context(), AdminEvent{Action: AdminImport, Detail: fmt.Sprintf("imported %d secrets", result.Imported)})
+	a.dataResponse(result, w, r)
+}
+
+// usageStorage finds the usage accounting wrapper of the storage
+func usageStorage(st sst.Storage) *sst.UsageStorage {
+	for {
+		if u, ok := st.(*sst.UsageStorage); ok {
+			return u
+		}
+		w, ok := st.(sst.Unwrapper)
+		if !ok {
+			return nil
+		}
+		st = w.Unwrap()
+	}
+}
+
+// NewUsageReport creates the report of the usage counters
+func NewUsageReport(usage *sst.UsageStorage) UsageReport {
+	return UsageReport{GeneratedAt: time.Now(), Since: usage.Since(), Usage: usage.Usage()}
+}
+
+// WriteUsageCSV writes the usage counters as CSV for the chargeback tools
+func WriteUsageCSV(w io.Writer, usage []sst.Usage) error {
+	cw := csv.NewWriter(w)
+	_ = cw.Write([]string{"tenant", "owner", "secrets_created", "bytes_stored", "views_served"})
+	for _, u := range usage {
+		_ = cw.Write([]string{
+			u.Tenant,
+			u.Owner,
+			strconv.FormatInt(u.SecretsCreated, 10),
+			strconv.FormatInt(u.BytesStored, 10),
+			strconv.FormatInt(u.ViewsServed, 10),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// adminUsageHandler returns the usage counters, ?format=csv gives the CSV
+func (a *App) adminUsageHandler(w http.ResponseWriter, r *http.Request) {
+	usage := usageStorage(a.storage)
+	if usage == nil {
+		http.Error(w, "Usage accounting is disabled", http.StatusNotImplemented)
+		return
+	}
+	report := NewUsageReport(usage)
+	if r.URL.Query().Get("format") == "csv" {
+		w.Header().Set("Content-Type", "text/csv")
+		if err := WriteUsageCSV(w, report.Usage); err != nil {
+			log.Println(err)
+		}
+		return
+	}
+	a.dataResponse(report, w, r)
+}
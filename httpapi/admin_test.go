@@ -0,0 +1,377 @@
+package httpapi_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	sst "github.com/evsan/secret-server-task"
+	"github.com/evsan/secret-server-task/httpapi"
+)
+
+func TestAdminListSecrets(t *testing.T) {
+	storage := sst.NewMemStorage()
+	api := httpapi.New(storage, httpapi.WithMetrics(nil))
+	admin := httpapi.NewAdmin(storage, httpapi.WithMetrics(nil))
+
+	post := func(labels ...string) int {
+		form := url.Values{"secret": {"test secret"}, "expireAfterViews": {"2"}, "expireAfter": {"0"}, "label": labels}
+		req := httptest.NewRequest(http.MethodPost, "/secret", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Accept", "application/json")
+		w := httptest.NewRecorder()
+		api.ServeHTTP(w, req)
+		if strings.Contains(w.Body.String(), "OPS-") {
+			t.Fatal("labels should not be exposed to the recipients")
+		}
+		return w.Code
+	}
+	for _, labels := range [][]string{{"ticket:OPS-1", "env:prod"}, {"ticket:OPS-2", "env:prod"}, {"ticket:OPS-3", "env:dev"}, nil} {
+		if code := post(labels...); code != http.StatusOK {
+			t.Fatalf("expected: %d, result: %d", http.StatusOK, code)
+		}
+	}
+	for _, labels := range [][]string{{"ticket"}, {"ticket id:OPS-4"}, {"env:prod", "env:dev"}} {
+		if code := post(labels...); code != http.StatusMethodNotAllowed {
+			t.Fatalf("expected: %d, result: %d", http.StatusMethodNotAllowed, code)
+		}
+	}
+
+	testCases := map[string]struct {
+		query     string
+		expected  int
+		truncated bool
+	}{
+		"all":        {"", 4, false},
+		"env":        {"?label=env:prod", 2, false},
+		"env ticket": {"?label=env:prod&label=ticket:OPS-2", 1, false},
+		"no match":   {"?label=env:test", 0, false},
+		"limit":      {"?label=env:prod&limit=1", 1, true},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/admin/secrets"+tc.query, nil)
+			req.Header.Set("Accept", "application/json")
+			w := httptest.NewRecorder()
+			admin.ServeHTTP(w, req)
+			if w.Code != http.StatusOK {
+				t.Fatalf("expected: %d, result: %d", http.StatusOK, w.Code)
+			}
+			if strings.Contains(w.Body.String(), "test secret") {
+				t.Fatal("secret text should not be listed")
+			}
+			var list httpapi.SecretList
+			if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil {
+				t.Fatal("error is not expected: ", err)
+			}
+			if len(list.Secrets) != tc.expected || list.Truncated != tc.truncated {
+				t.Fatalf("expected: %d %t, result: %d %t", tc.expected, tc.truncated, len(list.Secrets), list.Truncated)
+			}
+		})
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/secrets?limit=0", nil)
+	w := httptest.NewRecorder()
+	admin.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected: %d, result: %d", http.StatusBadRequest, w.Code)
+	}
+}
+
+func TestAdminListSecrets_BlindLabels(t *testing.T) {
+	index, err := sst.NewLabelIndex([]byte(strings.Repeat("k", sst.MinLabelIndexKey)))
+	if err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+	storage := sst.NewBlindLabelStorage(sst.NewMemStorage(), index)
+	api := httpapi.New(storage, httpapi.WithMetrics(nil))
+	admin := httpapi.NewAdmin(storage, httpapi.WithMetrics(nil))
+
+	for _, ticket := range []string{"ticket:OPS-1", "ticket:OPS-2"} {
+		form := url.Values{"secret": {"test secret"}, "expireAfterViews": {"2"}, "expireAfter": {"0"}, "label": {ticket}}
+		req := httptest.NewRequest(http.MethodPost, "/secret", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		api.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/secrets?label=ticket:OPS-2", nil)
+	req.Header.Set("Accept", "application/json")
+	w := httptest.NewRecorder()
+	admin.ServeHTTP(w, req)
+	var list httpapi.SecretList
+	if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+	if len(list.Secrets) != 1 {
+		t.Fatalf("expected: %d, result: %d", 1, len(list.Secrets))
+	}
+	if strings.Contains(w.Body.String(), "OPS-") {
+		t.Fatal("plaintext labels should not be stored")
+	}
+}
+
+func TestAdminSearch(t *testing.T) {
+	storage := sst.NewMemStorage(sst.WithMemRetention(time.Hour))
+	admin := httpapi.NewAdmin(storage, httpapi.WithMetrics(nil))
+	for i := 0; i < 3; i++ {
+		if _, err := storage.Store("test secret", 1, 0, sst.WithOwner("alice")); err != nil {
+			t.Fatal("error is not expected: ", err)
+		}
+	}
+	consumed, err := storage.Store("test secret", 1, 0, sst.WithOwner("bob"))
+	if err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+	if _, err = storage.Get(consumed.Hash); err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+	search := func(query string) (int, httpapi.SearchResponse) {
+		req := httptest.NewRequest(http.MethodGet, "/admin/secrets/search"+query, nil)
+		req.Header.Set("Accept", "application/json")
+		w := httptest.NewRecorder()
+		admin.ServeHTTP(w, req)
+		var resp httpapi.SearchResponse
+		if w.Code == http.StatusOK {
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatal("error is not expected: ", err)
+			}
+		}
+		return w.Code, resp
+	}
+
+	// The pages follow each other without the overlap
+	seen := map[string]bool{}
+	query := "?owner=alice&limit=2"
+	for page := 0; ; page++ {
+		code, resp := search(query)
+		if code != http.StatusOK {
+			t.Fatalf("expected: %d, result: %d", http.StatusOK, code)
+		}
+		for _, s := range resp.Secrets {
+			if seen[s.Hash] || s.Status != sst.StatusLive {
+				t.Fatalf("unexpected secret: %+v", s)
+			}
+			seen[s.Hash] = true
+		}
+		if resp.Next == "" {
+			break
+		}
+		query = "?owner=alice&limit=2&cursor=" + resp.Next
+	}
+	if len(seen) != 3 {
+		t.Fatalf("expected: %d, result: %d", 3, len(seen))
+	}
+
+	code, resp := search("?status=consumed")
+	if code != http.StatusOK || len(resp.Secrets) != 1 || resp.Secrets[0].Hash != consumed.Hash || resp.Secrets[0].Status != sst.StatusConsumed {
+		t.Fatalf("unexpected search: %d %+v", code, resp)
+	}
+	for _, query := range []string{"?status=gone", "?createdAfter=yesterday", "?cursor=x", "?limit=0"} {
+		if code, _ := search(query); code != http.StatusBadRequest {
+			t.Fatalf("%s: expected: %d, result: %d", query, http.StatusBadRequest, code)
+		}
+	}
+}
+
+func TestAdminBulkRevoke(t *testing.T) {
+	storage := sst.NewMemStorage()
+	keys := httpapi.APIKeys{"alice-key": {Key: "alice-key", Owner: "alice"}}
+	events := make(chan httpapi.AdminEvent, 1)
+	admin := httpapi.NewAdmin(storage, httpapi.WithMetrics(nil), httpapi.WithAPIKeys(keys, false),
+		httpapi.WithAdminHook(func(_ context.Context, event httpapi.AdminEvent) { events <- event }))
+
+	// More secrets than one batch of the search
+	var compromised []sst.Secret
+	for i := 0; i < 150; i++ {
+		s, err := storage.Store("test secret", 1, 0, sst.WithOwner("alice"))
+		if err != nil {
+			t.Fatal("error is not expected: ", err)
+		}
+		compromised = append(compromised, s)
+	}
+	kept, err := storage.Store("test secret", 1, 0, sst.WithOwner("bob"))
+	if err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+
+	do := func(method, target string, form url.Values) (*httptest.ResponseRecorder, httpapi.RevocationJob) {
+		req := httptest.NewRequest(method, target, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Accept", "application/json")
+		w := httptest.NewRecorder()
+		admin.ServeHTTP(w, req)
+		var job httpapi.RevocationJob
+		if w.Code == http.StatusOK {
+			if err := json.Unmarshal(w.Body.Bytes(), &job); err != nil {
+				t.Fatal("error is not expected: ", err)
+			}
+		}
+		return w, job
+	}
+
+	for name, form := range map[string]url.Values{
+		"no filter":       {"reason": {"key leaked"}},
+		"no reason":       {"apiKey": {"alice-key"}},
+		"unknown key":     {"apiKey": {"mallory-key"}, "reason": {"key leaked"}},
+		"invalid within":  {"apiKey": {"alice-key"}, "within": {"yesterday"}, "reason": {"key leaked"}},
+		"invalid created": {"owner": {"alice"}, "createdAfter": {"yesterday"}, "reason": {"key leaked"}},
+	} {
+		if w, _ := do(http.MethodPost, "/admin/revocations", form); w.Code != http.StatusBadRequest {
+			t.Fatalf("%s: expected: %d, result: %d", name, http.StatusBadRequest, w.Code)
+		}
+	}
+
+	w, job := do(http.MethodPost, "/admin/revocations", url.Values{"apiKey": {"alice-key"}, "within": {"24h"}, "reason": {"key leaked"}})
+	if w.Code != http.StatusOK || job.ID == "" {
+		t.Fatalf("expected: %d, result: %d %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	location := w.Header().Get("Location")
+	if location != "/admin/revocations/"+job.ID {
+		t.Fatalf("expected: %s, result: %s", "/admin/revocations/"+job.ID, location)
+	}
+	select {
+	case event := <-events:
+		if event.Action != httpapi.AdminBulkRevoke || event.Target != job.ID {
+			t.Fatalf("unexpected event: %+v", event)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("bulk revocation didn't finish")
+	}
+
+	if w, job = do(http.MethodGet, location, nil); w.Code != http.StatusOK {
+		t.Fatalf("expected: %d, result: %d", http.StatusOK, w.Code)
+	}
+	if job.State != httpapi.RevocationDone || job.Matched != len(compromised) || job.Revoked != len(compromised) ||
+		job.Failed != 0 || job.FinishedAt == nil || job.Reason != "key leaked" {
+		t.Fatalf("unexpected job: %+v", job)
+	}
+	for _, s := range compromised {
+		if _, err := storage.Get(s.Hash); err == nil {
+			t.Fatalf("secret %s should be revoked", s.Hash)
+		}
+	}
+	if _, err := storage.Get(kept.Hash); err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+	if w, _ = do(http.MethodGet, "/admin/revocations/unknown", nil); w.Code != http.StatusNotFound {
+		t.Fatalf("expected: %d, result: %d", http.StatusNotFound, w.Code)
+	}
+}
+
+func TestWithAdminHook(t *testing.T) {
+	storage := sst.NewMemStorage()
+	secret, err := storage.Store("test secret", 2, 0)
+	if err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+	var events []httpapi.AdminEvent
+	admin := httpapi.NewAdmin(storage, httpapi.WithMetrics(nil), httpapi.WithAdminHook(func(_ context.Context, e httpapi.AdminEvent) {
+		events = append(events, e)
+	}))
+
+	testCases := []struct {
+		path     string
+		form     url.Values
+		expected int
+	}{
+		{"/admin/secret/" + secret.Hash + "/revoke", url.Values{"reason": {"leaked"}}, http.StatusOK},
+		{"/admin/secret/" + secret.Hash + "/revoke", url.Values{"reason": {"again"}}, http.StatusNotFound},
+		{"/admin/owners/alice/erase", nil, http.StatusOK},
+	}
+	for _, tc := range testCases {
+		req := httptest.NewRequest(http.MethodPost, tc.path, strings.NewReader(tc.form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Accept", "application/json")
+		w := httptest.NewRecorder()
+		admin.ServeHTTP(w, req)
+		if w.Code != tc.expected {
+			t.Fatalf("%s: expected: %d, result: %d", tc.path, tc.expected, w.Code)
+		}
+	}
+
+	// The failed revocation is not reported
+	if len(events) != 2 {
+		t.Fatalf("expected: %d, result: %d", 2, len(events))
+	}
+	if events[0] != (httpapi.AdminEvent{Action: httpapi.AdminRevoke, Target: secret.Hash, Detail: "leaked"}) {
+		t.Fatalf("unexpected event: %+v", events[0])
+	}
+	if events[1].Action != httpapi.AdminErase || events[1].Target != "alice" {
+		t.Fatalf("unexpected event: %+v", events[1])
+	}
+}
+
+func TestAdminTombstone(t *testing.T) {
+	storage := sst.NewMemStorage(sst.WithMemRetention(time.Hour))
+	consumed, err := storage.Store("test secret", 1, 0)
+	if err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+	if _, err = storage.Get(consumed.Hash); err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+	live, err := storage.Store("test secret", 1, 0)
+	if err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+	admin := httpapi.NewAdmin(storage, httpapi.WithMetrics(nil))
+
+	testCases := map[string]struct {
+		hash     string
+		expected int
+	}{
+		"consumed": {consumed.Hash, http.StatusOK},
+		"live":     {live.Hash, http.StatusNotFound},
+		"unknown":  {"unknown", http.StatusNotFound},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/admin/secret/"+tc.hash+"/tombstone", nil)
+			req.Header.Set("Accept", "application/json")
+			w := httptest.NewRecorder()
+			admin.ServeHTTP(w, req)
+			if w.Code != tc.expected {
+				t.Fatalf("expected: %d, result: %d", tc.expected, w.Code)
+			}
+			if tc.expected != http.StatusOK {
+				return
+			}
+			var tombstone sst.Tombstone
+			if err := json.Unmarshal(w.Body.Bytes(), &tombstone); err != nil {
+				t.Fatal("error is not expected: ", err)
+			}
+			if tombstone.Hash != consumed.Hash || tombstone.Reason != sst.ReasonConsumed || tombstone.DeletedAt.IsZero() {
+				t.Fatalf("unexpected tombstone: %+v", tombstone)
+			}
+			if strings.Contains(w.Body.String(), "test secret") {
+				t.Fatal("secret text should not be returned")
+			}
+		})
+	}
+}
+
+func TestWithFIPS(t *testing.T) {
+	admin := httpapi.NewAdmin(sst.NewMemStorage(), httpapi.WithMetrics(nil), httpapi.WithFIPS())
+
+	testCases := map[string]struct {
+		method, path string
+	}{
+		"export": {http.MethodGet, "/admin/export?recipient=age1ql3z7hjy54pw3hyww5ayyfg7zqgvc7w3j2elw8zmrj2kg5sfn9aqmcac8p"},
+		"import": {http.MethodPost, "/admin/import"},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, tc.path, nil)
+			w := httptest.NewRecorder()
+			admin.ServeHTTP(w, req)
+			if w.Code != http.StatusNotImplemented {
+				t.Fatalf("expected: %d, result: %d", http.StatusNotImplemented, w.Code)
+			}
+		})
+	}
+}
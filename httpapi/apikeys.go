@@ -0,0 +1,117 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+
+	sst "github.com/evsan/secret-server-task"
+)
+
+// APIKey is an entry of the -apiKeysFile
+type APIKey struct {
+	Key   string `json:"key"`
+	Owner string `json:"owner"`
+	// Tenant is the namespace of the secrets created with the key, empty for the default one
+	Tenant string `json:"tenant"`
+	// RateLimitExempt exempts the creations with the key from the rate limit of the tenant, e.g. of the CI systems
+	RateLimitExempt bool `json:"rateLimitExempt,omitempty"`
+}
+
+// APIKeys maps the key to its definition
+type APIKeys map[string]APIKey
+
+// LoadAPIKeys reads the JSON array of APIKey from the file
+func LoadAPIKeys(path string) (APIKeys, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var list []APIKey
+	if err = json.NewDecoder(f).Decode(&list); err != nil {
+		return nil, err
+	}
+	keys := make(APIKeys, len(list))
+	for _, k := range list {
+		if k.Key == "" || k.Owner == "" {
+			return nil, errors.New("api key and owner can't be empty")
+		}
+		if err = sst.ValidateTenant(k.Tenant); err != nil {
+			return nil, err
+		}
+		if _, ok := keys[k.Key]; ok {
+			return nil, errors.New("duplicated api key of " + k.Owner)
+		}
+		keys[k.Key] = k
+	}
+	return keys, nil
+}
+
+type ctxKey int
+
+const apiKeyCtxKey ctxKey = iota
+
+// Tenants returns the set of the tenants used by the keys
+func (keys APIKeys) Tenants() map[string]bool {
+	tenants := make(map[string]bool)
+	for _, k := range keys {
+		if k.Tenant != "" {
+			tenants[k.Tenant] = true
+		}
+	}
+	return tenants
+}
+
+// requestAPIKey returns the api key of the request or false for anonymous requests
+func requestAPIKey(r *http.Request) (APIKey, bool) {
+	k, ok := r.Context().Value(apiKeyCtxKey).(APIKey)
+	return k, ok
+}
+
+// requestOwner returns the identity of the creator, empty for anonymous requests
+func requestOwner(r *http.Request) string {
+	k, _ := requestAPIKey(r)
+	return k.Owner
+}
+
+// apiKeyFromRequest reads the key from X-API-Key or Authorization: Bearer headers
+func apiKeyFromRequest(r *http.Request) string {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	const prefix = "Bearer "
+	h := r.Header.Get("Authorization")
+	if len(h) > len(prefix) && strings.EqualFold(h[:len(prefix)], prefix) {
+		return h[len(prefix):]
+	}
+	return ""
+}
+
+// Middleware identifies the api key holder. Requests with unknown keys are rejected,
+// requests without a key are anonymous unless required is set.
+func (keys APIKeys) Middleware(required bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := apiKeyFromRequest(r)
+			if key == "" {
+				if required {
+					http.Error(w, "API key is required", http.StatusUnauthorized)
+					return
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+			k, ok := keys[key]
+			if !ok {
+				http.Error(w, "Invalid API key", http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), apiKeyCtxKey, k)))
+		})
+	}
+}
@@ -0,0 +1,1061 @@
+// Package httpapi implements the HTTP API of the secret server.
+//
+// The API can be mounted into an existing server:
+//
+//	storage := sst.NewMemStorage()
+//	mux.Handle("/secrets/", httpapi.New(storage, httpapi.WithPathPrefix("/secrets")))
+package httpapi
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"io"
+	"io/fs"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"filippo.io/age"
+	sst "github.com/evsan/secret-server-task"
+	"github.com/evsan/secret-server-task/openmetrics"
+	"github.com/evsan/secret-server-task/webhook"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// App holds the configuration and the dependencies of the handlers
+type App struct {
+	storage    sst.Storage
+	pathPrefix string
+	// apiKeys identify the creators of the secrets. requireAPIKey forbids anonymous creation
+	apiKeys       APIKeys
+	requireAPIKey bool
+	// tenants are the namespaces served under /t/{tenant}/
+	tenants map[string]bool
+	// policies limit the secrets created by the tenants
+	policies *Policies
+	// backupIdentities decrypt the backups restored via POST /admin/import
+	backupIdentities []age.Identity
+	// fips refuses the age backups, their crypto is not FIPS 140 approved
+	fips       bool
+	marshalers map[string]Marshaler
+	// signer signs the responses carrying the secrets, nil if disabled
+	signer *Signer
+	// exemplars link the request counters to the traces, nil if disabled
+	exemplars *openmetrics.Exemplars
+	// contentTypes holds the ready header values of the marshalers, so the responses don't allocate them
+	contentTypes map[string][]string
+	metrics      Metrics
+	registerer   prometheus.Registerer
+	middleware   []func(http.Handler) http.Handler
+	baseURL      string
+	// storeHooks and getHooks are called after the store and get operations
+	storeHooks []func(ctx context.Context, secret sst.Secret)
+	getHooks   []func(ctx context.Context, hash string, outcome GetOutcome)
+	// adminHooks are called after the successful admin actions
+	adminHooks []func(ctx context.Context, event AdminEvent)
+	// webhooks notifies the per-secret webhook URLs, nil if they are not allowed
+	webhooks *webhook.Notifier
+	// idempotency replays the creation responses, nil if disabled
+	idempotency *idempotencyCache
+	// claims signs the tokens of the two-step reveal
+	claims claimSigner
+	// downloads keeps the files of the resumable downloads, nil if the downloads are not resumable
+	downloads *downloadSessions
+	// reload reloads the keys of the running server, nil if POST /admin/reload is disabled
+	reload func() error
+	// routeMiddleware are the middlewares of the route groups of the public API
+	routeMiddleware []RouteMiddleware
+	// writeQueue bounds the concurrent writes of the creations, nil if every request writes itself
+	writeQueue *writeQueue
+	// clientCertHeader carries the client certificate identity verified by the proxy, empty if not trusted
+	clientCertHeader string
+	// fallbackLocale is the locale of the error messages for the requests accepting no known locale, empty disables the translation
+	fallbackLocale string
+	// shape is the encoding of the secrets in the JSON and XML responses
+	shape ResponseShape
+	// static holds the files served at the root of the API, nil serves none
+	static fs.FS
+	// inspector checks the secret text before it is stored, nil if not configured
+	inspector ContentInspector
+	// policyEvaluator allows the creations and the retrievals, nil if not configured
+	policyEvaluator PolicyEvaluator
+	// policyProxies are the proxies whose X-Forwarded-For is the client address of the policy input
+	policyProxies []*net.IPNet
+	// exemptNetworks are the client networks exempt from the rate limit, exemptProxies are the proxies
+	// whose X-Forwarded-For is the client address
+	exemptNetworks []*net.IPNet
+	exemptProxies  []*net.IPNet
+	// webhookOutbox means the view notifications are recorded by the storage, the handlers don't send them
+	webhookOutbox bool
+	// maxBodySize is the limit of the request bodies, 0 means no limit
+	maxBodySize int64
+	// maxDecompressedSize is the limit of the decompressed gzip request bodies, 0 refuses the compressed requests
+	maxDecompressedSize int64
+	// viewRequester records the address and the User-Agent of the recipients in the view history
+	viewRequester bool
+	// noViewHeaders hides X-Secret-Remaining-Views and X-Secret-Expires-At of the served secrets
+	noViewHeaders bool
+	// hashFormat is the format of the generated hashes
+	hashFormat sst.HashFormat
+	// logControl changes the level of the access log at runtime, nil if it is not served
+	logControl *LogControl
+	// webLogin restricts the creation without the api key to the logged in users of the web UI, nil if disabled
+	webLogin *webSessions
+	// csrf requires the token in the browser requests changing the data, nil if disabled
+	csrf *csrfTokens
+	// revocations are the bulk revocations started via the admin API
+	revocations revocationJobs
+	// previewTokens issues the single-use tokens of the support preview, nil if disabled
+	previewTokens *PreviewTokens
+	// cacheAudit reports the secret responses without the headers forbidding the caching, nil if not audited
+	cacheAudit func(route string)
+
+	startedAt time.Time
+}
+
+// Option configures the App
+type Option func(*App)
+
+// WithPathPrefix mounts all routes under the prefix, e.g. /tools/secrets
+func WithPathPrefix(prefix string) Option {
+	return func(a *App) {
+		a.pathPrefix = normalizePathPrefix(prefix)
+	}
+}
+
+// WithAPIKeys enables the identification of the creators. If required is set
+// the secrets can't be created without a valid key
+func WithAPIKeys(keys APIKeys, required bool) Option {
+	return func(a *App) {
+		a.apiKeys = keys
+		a.requireAPIKey = required
+		for t := range keys.Tenants() {
+			a.tenants[t] = true
+		}
+	}
+}
+
+// WithTenants adds the tenants served under /t/{tenant}/. The tenants of the api keys are added automatically
+func WithTenants(tenants ...string) Option {
+	return func(a *App) {
+		for _, t := range tenants {
+			a.tenants[t] = true
+		}
+	}
+}
+
+// WithPolicies sets the creation policies. The same Policies should be passed to NewAdmin,
+// so the changes made via the admin API are applied to the public one
+func WithPolicies(p *Policies) Option {
+	return func(a *App) {
+		a.policies = p
+	}
+}
+
+// WithBackupIdentities sets the age identities used by POST /admin/import
+func WithBackupIdentities(identities ...age.Identity) Option {
+	return func(a *App) {
+		a.backupIdentities = identities
+	}
+}
+
+// WithFIPS refuses the features using the crypto outside of the FIPS 140 module:
+// GET /admin/export and POST /admin/import answer 501
+func WithFIPS() Option {
+	return func(a *App) {
+		a.fips = true
+	}
+}
+
+// WithMetrics registers the API metrics with the registerer instead of prometheus.DefaultRegisterer.
+// nil disables the registration, the metrics are still counted but never exported
+func WithMetrics(reg prometheus.Registerer) Option {
+	return func(a *App) {
+		a.registerer = reg
+	}
+}
+
+// WithMarshaler adds or replaces the marshaler used for the media range of the Accept header, e.g. "application/json"
+func WithMarshaler(mediaRange string, m Marshaler) Option {
+	return func(a *App) {
+		a.marshalers[mediaRange] = m
+	}
+}
+
+// WithMiddleware wraps the API handler with the middlewares. The first one is the outermost
+func WithMiddleware(mw ...func(http.Handler) http.Handler) Option {
+	return func(a *App) {
+		a.middleware = append(a.middleware, mw...)
+	}
+}
+
+// WithBaseURL sets the absolute URL the API is reachable at, e.g. https://example.com/tools/secrets.
+// The path of the URL is used as the path prefix and the URL as the base of the generated links.
+func WithBaseURL(baseURL string) Option {
+	return func(a *App) {
+		u, err := url.Parse(baseURL)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			panic("httpapi: invalid base URL " + baseURL)
+		}
+		a.pathPrefix = normalizePathPrefix(u.Path)
+		u.Path, u.RawQuery, u.Fragment = "", "", ""
+		a.baseURL = u.String()
+	}
+}
+
+// WithWebhookHosts allows the creators to attach the webhook URLs pointing to the hosts.
+// "*.example.com" allows the subdomains of example.com. Without hosts the per-secret webhooks are refused
+func WithWebhookHosts(hosts ...string) Option {
+	return func(a *App) {
+		a.webhooks = webhook.New(hosts...)
+	}
+}
+
+// ContentInspector checks the secret text against the content policy of the organization.
+// It returns the names of the matched rules to flag the secret, or the error to refuse it
+type ContentInspector interface {
+	Inspect(secret string) (flags []string, err error)
+}
+
+// ContentFlagsLabel is the label of the flagged secrets, the value is the comma separated list of the rules
+const ContentFlagsLabel = "content.flags"
+
+// WithContentInspector sets the inspector of the new secrets, e.g. contentpolicy.Scanner
+func WithContentInspector(i ContentInspector) Option {
+	return func(a *App) {
+		a.inspector = i
+	}
+}
+
+// WithWebhookOutbox disables the in-process view notifications, because the storage records them
+// in the transactional outbox (see sst.WithPgOutbox) and they are delivered by webhook.Dispatcher
+func WithWebhookOutbox() Option {
+	return func(a *App) {
+		a.webhookOutbox = true
+	}
+}
+
+// WithHashFormat sets the format of the generated hashes, it must be validated with HashFormat.Validate.
+// The hashes of both the format and sst.DefaultHashFormat are accepted, so the links created before the change keep working
+func WithHashFormat(f sst.HashFormat) Option {
+	return func(a *App) {
+		a.hashFormat = f
+	}
+}
+
+// WithLimits sets the default creation policy. It replaces the policies set by WithPolicies
+func WithLimits(p sst.Policy) Option {
+	return func(a *App) {
+		a.policies = NewPolicies(PolicyConfig{Default: p})
+	}
+}
+
+// Accept Header
+// I didn't find the library to parse Accept headers correctly.
+// So I've decided to create my own parser as a quick fix for this task.
+// My method ignores ;q=(q-factor weighting), the first supported type wins
+type Marshaler struct {
+	MarshalFunc func(interface{}) ([]byte, error)
+	// EncodeFunc writes the encoded value to the response. MarshalFunc is used if it is nil
+	EncodeFunc  func(io.Writer, interface{}) error
+	ContentType string
+}
+
+func newApp(storage sst.Storage, opts ...Option) *App {
+	a := &App{
+		storage:     storage,
+		tenants:     map[string]bool{},
+		registerer:  prometheus.DefaultRegisterer,
+		idempotency: newIdempotencyCache(time.Hour),
+		claims:      newClaimSigner(),
+		hashFormat:  sst.DefaultHashFormat,
+		startedAt:   time.Now(),
+	}
+	a.initMarchalers()
+	for _, opt := range opts {
+		opt(a)
+	}
+	if a.policies == nil {
+		a.policies = NewPolicies(PolicyConfig{})
+	}
+	a.contentTypes = map[string][]string{}
+	for _, m := range a.marshalers {
+		a.contentTypes[m.ContentType] = []string{m.ContentType}
+	}
+	return a
+}
+
+// GetOutcome is the result of the retrieval passed to the OnGet hooks
+type GetOutcome string
+
+// Retrieval outcomes
+const (
+	// GetServed means the secret was found and returned to the client
+	GetServed GetOutcome = "served"
+	// GetNotFound means the secret doesn't exist or is no longer available
+	GetNotFound GetOutcome = "not_found"
+	// GetScheduled means the secret exists but its notBefore time hasn't come yet
+	GetScheduled GetOutcome = "scheduled"
+	// GetRejected means the request was refused before the storage lookup, e.g. unknown tenant or invalid claim token
+	GetRejected GetOutcome = "rejected"
+	// GetClaimed means the claim token was issued for the secret, no view was consumed
+	GetClaimed GetOutcome = "claimed"
+	// GetFailed means the storage backend failed, the secret may still be available
+	GetFailed GetOutcome = "failed"
+	// GetResumed means the download was resumed with its token, no view was consumed
+	GetResumed GetOutcome = "resumed"
+)
+
+// New creates the handler of the public API
+func New(storage sst.Storage, opts ...Option) http.Handler {
+	return NewApp(storage, opts...).Handler()
+}
+
+// NewApp creates the public API which accepts hooks. Hooks must be registered before the handler is served.
+func NewApp(storage sst.Storage, opts ...Option) *App {
+	a := newApp(storage, opts...)
+	a.initMetrics()
+	return a
+}
+
+// OnStore registers the hook called after the secret is stored and before the response is written.
+// The hook runs in the request goroutine, so slow side effects should be done asynchronously
+func (a *App) OnStore(hook func(ctx context.Context, secret sst.Secret)) {
+	a.storeHooks = append(a.storeHooks, hook)
+}
+
+// OnGet registers the hook called after every retrieval attempt with its outcome
+func (a *App) OnGet(hook func(ctx context.Context, hash string, outcome GetOutcome)) {
+	a.getHooks = append(a.getHooks, hook)
+}
+
+// Handler returns the handler of the public API routes
+func (a *App) Handler() http.Handler {
+
+	apiRouter := a.newRouteMux()
+
+	viewsHandler := a.apiKeys.Middleware(true)(http.HandlerFunc(a.viewsHandler))
+	previewHandler := a.apiKeys.Middleware(true)(http.HandlerFunc(a.previewHandler))
+	deleteHandler := a.apiKeys.Middleware(true)(http.HandlerFunc(a.deleteSecretHandler))
+	createLinkHandler := a.apiKeys.Middleware(true)(http.HandlerFunc(a.createLinkHandler))
+	listLinksHandler := a.apiKeys.Middleware(true)(http.HandlerFunc(a.listLinksHandler))
+	deleteLinkHandler := a.apiKeys.Middleware(true)(http.HandlerFunc(a.deleteLinkHandler))
+	checkInHandler := a.apiKeys.Middleware(true)(http.HandlerFunc(a.checkInHandler))
+	storeHandler := a.creatorMiddleware(a.idempotency.middleware(http.HandlerFunc(a.storeSecretHandler), a.bodyError))
+
+	apiRouter.HandleFunc("GET "+a.Path("/secret/{hash}"), a.getSecretHandler)
+	apiRouter.Handle("POST "+a.Path("/secret"), storeHandler)
+	apiRouter.HandleFunc("POST "+a.Path("/secret/{hash}/reveal"), a.revealSecretHandler)
+	apiRouter.HandleFunc("GET "+a.Path("/t/{tenant}/secret/{hash}"), a.getSecretHandler)
+	apiRouter.HandleFunc("GET "+a.Path("/secret/{hash}/download"), a.downloadSecretHandler)
+	apiRouter.HandleFunc("GET "+a.Path("/t/{tenant}/secret/{hash}/download"), a.downloadSecretHandler)
+	apiRouter.HandleFunc("POST "+a.Path("/t/{tenant}/secret/{hash}/reveal"), a.revealSecretHandler)
+	apiRouter.Handle("GET "+a.Path("/secret/{hash}/views"), viewsHandler)
+	apiRouter.Handle("GET "+a.Path("/t/{tenant}/secret/{hash}/views"), viewsHandler)
+	apiRouter.Handle("GET "+a.Path("/secret/{hash}/preview"), previewHandler)
+	apiRouter.Handle("GET "+a.Path("/t/{tenant}/secret/{hash}/preview"), previewHandler)
+	apiRouter.Handle("DELETE "+a.Path("/secret/{hash}"), deleteHandler)
+	apiRouter.Handle("DELETE "+a.Path("/t/{tenant}/secret/{hash}"), deleteHandler)
+	apiRouter.Handle("POST "+a.Path("/secret/{hash}/links"), createLinkHandler)
+	apiRouter.Handle("POST "+a.Path("/t/{tenant}/secret/{hash}/links"), createLinkHandler)
+	apiRouter.Handle("GET "+a.Path("/secret/{hash}/links"), listLinksHandler)
+	apiRouter.Handle("GET "+a.Path("/t/{tenant}/secret/{hash}/links"), listLinksHandler)
+	apiRouter.Handle("DELETE "+a.Path("/secret/{hash}/links/{id}"), deleteLinkHandler)
+	apiRouter.Handle("DELETE "+a.Path("/t/{tenant}/secret/{hash}/links/{id}"), deleteLinkHandler)
+	apiRouter.Handle("POST "+a.Path("/secret/{hash}/checkin"), checkInHandler)
+	apiRouter.Handle("POST "+a.Path("/t/{tenant}/secret/{hash}/checkin"), checkInHandler)
+	apiRouter.HandleFunc("GET "+a.Path("/link/{id}"), a.getLinkHandler)
+	apiRouter.HandleFunc("GET "+a.Path("/t/{tenant}/link/{id}"), a.getLinkHandler)
+	apiRouter.Handle("POST "+a.Path("/t/{tenant}/secret"), storeHandler)
+	apiRouter.Handle("PUT "+a.Path("/secret/{hash}"), storeHandler)
+	apiRouter.Handle("PUT "+a.Path("/t/{tenant}/secret/{hash}"), storeHandler)
+	apiRouter.Handle("GET "+a.Path("/v1/usage"), a.apiKeys.Middleware(true)(http.HandlerFunc(a.usageHandler)))
+	if a.signer != nil {
+		apiRouter.HandleFunc("GET "+a.Path("/.well-known/jwks.json"), a.jwksHandler)
+	}
+	if a.previewTokens != nil {
+		apiRouter.HandleFunc("GET "+a.Path("/support/{token}"), a.supportPreviewHandler)
+	}
+	if a.csrf != nil {
+		apiRouter.HandleFunc("GET "+a.Path("/csrf"), a.csrfHandler)
+	}
+	if a.webLogin != nil {
+		apiRouter.HandleFunc("GET "+a.Path("/auth/login"), a.loginHandler)
+		apiRouter.HandleFunc("GET "+a.Path("/auth/callback"), a.callbackHandler)
+		apiRouter.HandleFunc("POST "+a.Path("/auth/logout"), a.logoutHandler)
+		apiRouter.HandleFunc("GET "+a.Path("/auth/session"), a.sessionHandler)
+	}
+	if a.static != nil {
+		apiRouter.Handle("GET "+a.Path("/"), http.StripPrefix(a.pathPrefix, StaticHandler(a.static)))
+	}
+
+	apiRouter.reportUnmatched()
+
+	return traceRequest(a.localize(a.withMiddleware(corsMiddleware(a.limitBody(a.decompressBody(a.checkCSRF(a.auditCache(apiRouter.ServeMux, recordRoute(apiRouter.ServeMux)))))))))
+}
+
+// withMiddleware wraps the handler with the middlewares configured by WithMiddleware
+func (a *App) withMiddleware(handler http.Handler) http.Handler {
+	for i := len(a.middleware) - 1; i >= 0; i-- {
+		handler = a.middleware[i](handler)
+	}
+	return handler
+}
+
+// Path returns the given route path mounted under the configured path prefix.
+// It should be used for all routes and for URLs generated in responses.
+func (a *App) Path(path string) string {
+	return a.pathPrefix + path
+}
+
+// URL returns the link to the given route path, absolute if the base URL is configured
+func (a *App) URL(path string) string {
+	return a.baseURL + a.Path(path)
+}
+
+// normalizePathPrefix returns the prefix with a leading slash and without a trailing one.
+// Empty prefix and "/" mean that the API is mounted at the root.
+func normalizePathPrefix(prefix string) string {
+	prefix = strings.Trim(prefix, "/")
+	if prefix == "" {
+		return ""
+	}
+	return "/" + prefix
+}
+
+// The header values are shared by all responses, net/http never modifies them
+var (
+	corsAllowOrigin  = []string{"*"}
+	corsAllowHeaders = []string{"Content-Type, Content-Encoding, Authorization, Accept, Idempotency-Key, If-None-Match, If-Modified-Since, X-Recipient-Token, X-Request-Timeout, Request-Timeout"}
+	// ETag and the signature are not the CORS-safelisted response headers
+	corsExposeHeaders = []string{"ETag, X-JWS-Signature, X-Creation-Receipt, X-Secret-Remaining-Views, X-Secret-Expires-At"}
+)
+
+func corsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header()["Access-Control-Allow-Origin"] = corsAllowOrigin
+		w.Header()["Access-Control-Allow-Headers"] = corsAllowHeaders
+		w.Header()["Access-Control-Expose-Headers"] = corsExposeHeaders
+		if r.Method != http.MethodOptions {
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+func (a *App) getSecretHandler(w http.ResponseWriter, r *http.Request) {
+	a.metrics.secretGetCounter.Inc()
+	a.exemplar("secret_get_requests_total", r)
+	defer a.observeDuration(a.metrics.secretGetDuration, a.metrics.secretGetSeconds, "secret_get_request_duration_seconds", r)()
+
+	key := r.PathValue("hash")
+	if !a.validHash(key) {
+		a.rejectHash(key, w, r)
+		return
+	}
+	tenant, ok := a.requestTenant(w, r)
+	if !ok {
+		a.getHook(r.Context(), key, GetRejected)
+		return
+	}
+	if !a.allowedByPolicy(PolicyInput{Action: PolicyRetrieve, Tenant: tenant, Hash: key}, w, r) {
+		a.getHook(r.Context(), key, GetRejected)
+		return
+	}
+
+	st := sst.NewTenantStorage(a.storage, tenant)
+	s, err := st.Get(key)
+	if err == sst.ErrRecipientRequired {
+		a.serveRecipient(st, tenant, key, s, w, r)
+		return
+	}
+	if err == sst.ErrClaimRequired {
+		a.getHook(r.Context(), key, GetClaimed)
+		a.claimResponse(tenant, s, w, r)
+		return
+	}
+	a.serveSecret(st, key, s, err, w, r)
+}
+
+// serveSecret writes the result of the retrieval from the tenant storage st
+func (a *App) serveSecret(st sst.Storage, key string, s sst.Secret, err error, w http.ResponseWriter, r *http.Request) {
+	if a.served(st, key, s, err, w, r) {
+		a.dataResponse(s, w, r)
+	}
+}
+
+// served responds to the failed retrieval, or runs the hooks and records the view of the served secret.
+// It returns true if the secret should be written
+func (a *App) served(st sst.Storage, key string, s sst.Secret, err error, w http.ResponseWriter, r *http.Request) bool {
+	if err == sst.ErrSecretNotYetAvailable {
+		a.getHook(r.Context(), key, GetScheduled)
+		http.Error(w, "Secret is not available yet", http.StatusNotFound)
+		return false
+	}
+	if err != nil {
+		a.metrics.secretUnavailable.WithLabelValues(unavailableReason(s, err)).Inc()
+		if backendUnavailable(err, w) {
+			a.getHook(r.Context(), key, GetFailed)
+			return false
+		}
+		a.getHook(r.Context(), key, GetNotFound)
+		// The reason is counted only, the response is the same for all of them
+		http.Error(w, "Secret not found", http.StatusNotFound)
+		return false
+	}
+	a.getHook(r.Context(), key, GetServed)
+	a.recordView(st, s, r)
+	if !a.webhookOutbox {
+		event := webhook.Viewed
+		if s.RemainingViews == 0 {
+			event = webhook.Consumed
+		}
+		e := webhook.Event{Event: event, Hash: key, RemainingViews: s.RemainingViews, At: time.Now()}
+		a.webhooks.Notify(s.WebhookURL, TraceOf(r.Context()).event(e))
+	}
+	return true
+}
+
+// validHash reports whether the hash has the format of the generated hashes
+func (a *App) validHash(hash string) bool {
+	return a.hashFormat.Match(hash) || sst.DefaultHashFormat.Match(hash)
+}
+
+// rejectHash responds to the malformed hash the same way as to the unknown one, the storage is not queried
+func (a *App) rejectHash(key string, w http.ResponseWriter, r *http.Request) {
+	a.getHook(r.Context(), key, GetRejected)
+	a.metrics.secretUnavailable.WithLabelValues("malformed").Inc()
+	http.Error(w, "Secret not found", http.StatusNotFound)
+}
+
+// backendRetryAfter is the Retry-After of the requests failed by the storage backend, in seconds
+const backendRetryAfter = "5"
+
+// backendUnavailable responds with 503 to the failure of the storage backend. The secret may still be available,
+// so the client should retry rather than take it for gone
+func backendUnavailable(err error, w http.ResponseWriter) bool {
+	if !errors.Is(err, sst.ErrBackendUnavailable) {
+		return false
+	}
+	w.Header().Set("Retry-After", backendRetryAfter)
+	http.Error(w, "Storage is not available, try again later", http.StatusServiceUnavailable)
+	return true
+}
+
+// unavailableReason returns the metric label of the failed retrieval
+func unavailableReason(s sst.Secret, err error) string {
+	switch {
+	case !errors.Is(err, sst.ErrSecretNotAvailable):
+		return "error"
+	case s.Unavailable == "":
+		return "unknown"
+	}
+	return string(s.Unavailable)
+}
+
+func (a *App) getHook(ctx context.Context, hash string, outcome GetOutcome) {
+	for _, hook := range a.getHooks {
+		hook(ctx, hash, outcome)
+	}
+}
+
+func (a *App) storeSecretHandler(w http.ResponseWriter, r *http.Request) {
+	a.metrics.secretPostCounter.Inc()
+	a.exemplar("secret_post_requests_total", r)
+	defer a.observeDuration(a.metrics.secretPostDuration, a.metrics.secretPostSeconds, "secret_post_request_duration_seconds", r)()
+
+	err := r.ParseForm()
+	if err != nil {
+		a.bodyError(w, r, err, "Invalid input", http.StatusMethodNotAllowed)
+		return
+	}
+
+	template, err := a.policies.Template(r.FormValue("template"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusMethodNotAllowed)
+		return
+	}
+
+	secretText := r.FormValue("secret")
+	expAfter, err := formInt(r, "expireAfter", template.ExpireAfter)
+	if err != nil {
+		http.Error(w, "Invalid input", http.StatusMethodNotAllowed)
+		return
+	}
+
+	expAfterViews, err := formInt(r, "expireAfterViews", template.ExpireAfterViews)
+	if err != nil {
+		http.Error(w, "Invalid input", http.StatusMethodNotAllowed)
+		return
+	}
+
+	tenant, ok := a.requestTenant(w, r)
+	if !ok {
+		return
+	}
+
+	policy := a.policies.For(tenant)
+	if err = policy.Check(secretText, expAfterViews, expAfter); err != nil {
+		http.Error(w, err.Error(), http.StatusMethodNotAllowed)
+		return
+	}
+	expAfterViews, capped := policy.ApplyViews(expAfterViews)
+	if capped {
+		// The body has the applied value anyway, the header tells the client that it was changed
+		w.Header().Set("X-Applied-Policy", "expireAfterViews="+strconv.Itoa(expAfterViews))
+	}
+	input := PolicyInput{
+		Action:           PolicyCreate,
+		Tenant:           tenant,
+		ExpireAfter:      expAfter,
+		ExpireAfterViews: expAfterViews,
+		Size:             len(secretText),
+		Template:         r.FormValue("template"),
+	}
+	if !a.allowedByPolicy(input, w, r) {
+		return
+	}
+	if exemption := a.rateLimitExemption(r); exemption != "" {
+		a.metrics.rateLimitExemptions.WithLabelValues(exemption).Inc()
+	} else {
+		limit, allowed := a.policies.Take(tenant)
+		limit.setHeaders(w.Header())
+		if !allowed {
+			a.limitResponse(w, r, http.StatusTooManyRequests, rateLimitError(limit, time.Now()))
+			return
+		}
+	}
+	if quota, allowed := a.policies.TakeQuota(tenant, requestOwner(r)); !allowed {
+		quota.setHeaders(w.Header(), time.Now())
+		a.limitResponse(w, r, http.StatusPaymentRequired, quotaError(quota))
+		return
+	}
+
+	opts := []sst.SecretOption{sst.WithOwner(requestOwner(r))}
+	// PUT /secret/{hash} stores the secret at the hash chosen by the client
+	key := r.PathValue("hash")
+	switch {
+	case key != "" && !a.validHash(key):
+		http.Error(w, "Invalid hash, it should match the hash format of the server", http.StatusMethodNotAllowed)
+		return
+	case key != "":
+		opts = append(opts, sst.WithHash(key))
+	case a.hashFormat != sst.DefaultHashFormat:
+		opts = append(opts, sst.WithHashFormat(a.hashFormat))
+	}
+	if notBefore := r.FormValue("notBefore"); notBefore != "" {
+		t, err := time.Parse(time.RFC3339, notBefore)
+		if err != nil {
+			http.Error(w, "Invalid input", http.StatusMethodNotAllowed)
+			return
+		}
+		opts = append(opts, sst.WithNotBefore(t))
+	}
+	if webhookURL := r.FormValue("webhookUrl"); webhookURL != "" {
+		if err = a.webhooks.Validate(webhookURL); err != nil {
+			http.Error(w, err.Error(), http.StatusMethodNotAllowed)
+			return
+		}
+		opts = append(opts, sst.WithWebhookURL(webhookURL))
+	}
+	labels, err := parseLabels(r.Form["label"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusMethodNotAllowed)
+		return
+	}
+	labels = template.ApplyLabels(labels)
+	if a.inspector != nil {
+		flags, err := a.inspector.Inspect(secretText)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusMethodNotAllowed)
+			return
+		}
+		if len(flags) > 0 {
+			labels[ContentFlagsLabel] = strings.Join(flags, ",")
+			log.Printf("content policy: secret of %q flagged by %s", requestOwner(r), labels[ContentFlagsLabel])
+		}
+	}
+	if len(labels) > 0 {
+		opts = append(opts, sst.WithLabels(labels))
+	}
+	if claim := r.FormValue("claim"); claim != "" {
+		required, err := strconv.ParseBool(claim)
+		if err != nil {
+			http.Error(w, "Invalid input", http.StatusMethodNotAllowed)
+			return
+		}
+		if required {
+			opts = append(opts, sst.WithClaimRequired())
+		}
+	}
+	recipient, err := recipientOption(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusMethodNotAllowed)
+		return
+	}
+	if recipient != nil {
+		opts = append(opts, recipient)
+	}
+	file, err := fileOption(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusMethodNotAllowed)
+		return
+	}
+	if file != nil {
+		opts = append(opts, file)
+	}
+	deadMansSwitch, err := a.switchOption(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusMethodNotAllowed)
+		return
+	}
+	if deadMansSwitch != nil {
+		opts = append(opts, deadMansSwitch)
+	}
+
+	storage := sst.NewTenantStorage(a.storage, tenant)
+	var secret sst.Secret
+	queueErr := a.writeQueue.do(r.Context(), func() {
+		secret, err = storage.Store(secretText, expAfterViews, expAfter, opts...)
+	})
+	if queueErr == errWriteQueueFull {
+		w.Header().Set("Retry-After", writeRetryAfter)
+		http.Error(w, "Too many secrets are being stored, try again later", http.StatusServiceUnavailable)
+		return
+	}
+	if queueErr == context.DeadlineExceeded {
+		http.Error(w, "Request deadline exceeded, nothing is stored", http.StatusServiceUnavailable)
+		return
+	}
+	if queueErr != nil {
+		// The client is gone, nothing is stored
+		return
+	}
+	if errors.Is(err, sst.ErrLockedMemory) {
+		http.Error(w, "Secret can't be stored at the moment", http.StatusServiceUnavailable)
+		return
+	}
+	if err == sst.ErrTenantKey {
+		http.Error(w, "Secrets of the tenant can't be stored", http.StatusForbidden)
+		return
+	}
+	if err == sst.ErrSecretExists && key != "" {
+		if secret, ok := a.replayPut(storage, key, secretText, r); ok {
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.Header().Set("Content-Location", a.secretURL(tenant, secret.Hash))
+			a.setReceipt(w, r, tenant, policy, secret)
+			a.dataResponse(secret, w, r)
+			return
+		}
+	}
+	if err == sst.ErrSecretExists {
+		http.Error(w, "Secret with the same hash already exists", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Invalid input", http.StatusMethodNotAllowed)
+		return
+	}
+	for _, hook := range a.storeHooks {
+		hook(r.Context(), secret)
+	}
+	w.Header().Set("Content-Location", a.secretURL(tenant, secret.Hash))
+	a.setReceipt(w, r, tenant, policy, secret)
+	a.dataResponse(secret, w, r)
+}
+
+// replayPut returns the secret stored by the earlier PUT of the same owner and text, so the retried
+// PUT succeeds. The secret which is consumed, expired or different is a conflict
+func (a *App) replayPut(storage sst.Storage, key, text string, r *http.Request) (sst.Secret, bool) {
+	p, ok := sst.PeekerOf(storage)
+	if !ok {
+		return sst.Secret{}, false
+	}
+	secret, err := p.Peek(key)
+	if err != nil || secret.Owner != requestOwner(r) || subtle.ConstantTimeCompare([]byte(secret.SecretText), []byte(text)) != 1 {
+		return sst.Secret{}, false
+	}
+	return secret, true
+}
+
+// secretURL returns the retrieval link of the secret
+func (a *App) secretURL(tenant, hash string) string {
+	if tenant == "" {
+		return a.URL("/secret/" + hash)
+	}
+	return a.URL("/t/" + tenant + "/secret/" + hash)
+}
+
+// requestTenant returns the tenant of the request. The tenant from the path must be known
+// and must match the tenant of the api key if any. The error response is written if ok is false
+func (a *App) requestTenant(w http.ResponseWriter, r *http.Request) (tenant string, ok bool) {
+	key, hasKey := requestAPIKey(r)
+	pathTenant := r.PathValue("tenant")
+	if pathTenant == "" {
+		return key.Tenant, true
+	}
+	if !a.tenants[pathTenant] {
+		http.Error(w, "Tenant not found", http.StatusNotFound)
+		return "", false
+	}
+	if hasKey && key.Tenant != pathTenant {
+		http.Error(w, "API key doesn't belong to the tenant", http.StatusForbidden)
+		return "", false
+	}
+	return pathTenant, true
+}
+
+func (a *App) dataResponse(data interface{}, w http.ResponseWriter, r *http.Request) {
+	m := a.getMarshaler(r.Header.Get("Accept"))
+	if m.ContentType == "" {
+		http.Error(w, "Accept header is invalid", http.StatusMethodNotAllowed)
+		return
+	}
+	body := data
+	if s, ok := data.(sst.Secret); ok {
+		// The secret text must never be kept by the caches, unlike the metadata
+		noStore(w.Header())
+		if shapedContentTypes[m.ContentType] {
+			var err error
+			if body, err = a.shapeSecret(s, r.URL.Query().Get("fields")); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if m.ContentType == halContentType || m.ContentType == jsonAPIContentType {
+				if body, err = a.hypermedia(m.ContentType, s, body, r); err != nil {
+					http.Error(w, err.Error(), http.StatusMethodNotAllowed)
+					return
+				}
+			}
+		}
+	}
+	// The signed response needs the whole body, so it is never streamed
+	if s, ok := data.(sst.Secret); ok && len(s.SecretText) > maxPooledBuffer && m.EncodeFunc != nil && a.signer == nil {
+		a.streamResponse(m, s, body, w)
+		return
+	}
+
+	// The response is encoded into the pooled buffer first, so the encoding error
+	// can still be reported and Content-Length is known
+	buf := bufferPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	defer putBuffer(buf)
+
+	if err := encodeResponse(m, buf, body); err != nil {
+		http.Error(w, err.Error(), http.StatusMethodNotAllowed)
+		return
+	}
+	if _, ok := data.(sst.Secret); ok && a.signer != nil && m.ContentType != joseContentType {
+		sig, err := a.signer.Detached(buf.Bytes())
+		if err != nil {
+			http.Error(w, "Signing failed", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set(signatureHeader, sig)
+	}
+	// The body is written, the headers of the secret are never sent with the error
+	if s, ok := data.(sst.Secret); ok {
+		a.secretHeaders(w.Header(), s, m.ContentType == rawContentType)
+	}
+	if ct, ok := a.contentTypes[m.ContentType]; ok {
+		w.Header()["Content-Type"] = ct
+	} else {
+		w.Header().Set("Content-Type", m.ContentType)
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	_, _ = w.Write(buf.Bytes())
+}
+
+// metadataResponse writes the metadata polled by the dashboards with the ETag of the representation
+// and Last-Modified if modified is set, so the unchanged response is answered with 304
+func (a *App) metadataResponse(data interface{}, modified time.Time, w http.ResponseWriter, r *http.Request) {
+	m := a.getMarshaler(r.Header.Get("Accept"))
+	if m.ContentType == "" {
+		http.Error(w, "Accept header is invalid", http.StatusMethodNotAllowed)
+		return
+	}
+	buf := bufferPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	defer putBuffer(buf)
+
+	if err := encodeResponse(m, buf, data); err != nil {
+		http.Error(w, err.Error(), http.StatusMethodNotAllowed)
+		return
+	}
+	// The content type is a part of the representation, text/xml and application/xml must not share the tag
+	h := sha256.New()
+	h.Write([]byte(m.ContentType + "\n"))
+	h.Write(buf.Bytes())
+	w.Header().Set("ETag", `"`+base64.RawURLEncoding.EncodeToString(h.Sum(nil)[:16])+`"`)
+	w.Header().Set("Cache-Control", "private, no-cache")
+	w.Header().Add("Vary", "Accept")
+	w.Header().Set("Content-Type", m.ContentType)
+	http.ServeContent(w, r, "", modified, bytes.NewReader(buf.Bytes()))
+}
+
+// encodeResponse encodes the value with the marshaler
+func encodeResponse(m Marshaler, buf *bytes.Buffer, data interface{}) error {
+	if m.EncodeFunc != nil {
+		return m.EncodeFunc(buf, data)
+	}
+	b, err := m.MarshalFunc(data)
+	if err == nil {
+		_, err = buf.Write(b)
+		clear(b)
+	}
+	return err
+}
+
+// streamResponse encodes the large secret directly to the client without the intermediate copy.
+// The raw payload is sent with Content-Length, the encoded one is sent chunked. body is the shaped secret
+func (a *App) streamResponse(m Marshaler, s sst.Secret, body interface{}, w http.ResponseWriter) {
+	w.Header().Set("Content-Type", m.ContentType)
+	a.secretHeaders(w.Header(), s, m.ContentType == rawContentType)
+	if m.ContentType == rawContentType {
+		w.Header().Set("Content-Length", strconv.Itoa(len(s.SecretText)))
+	}
+	if err := m.EncodeFunc(w, body); err != nil {
+		// The status is already sent, the client sees the truncated response
+		log.Println("streaming response failed:", err)
+	}
+}
+
+// maxPooledBuffer limits the size of the buffers returned to the pool,
+// so a single large secret doesn't keep the memory forever
+const maxPooledBuffer = 64 << 10
+
+var bufferPool = sync.Pool{
+	New: func() interface{} { return new(bytes.Buffer) },
+}
+
+// putBuffer zeroes the response, so the secret texts don't stay in the pool or in the garbage heap
+func putBuffer(buf *bytes.Buffer) {
+	clear(buf.Bytes())
+	buf.Reset()
+	if buf.Cap() <= maxPooledBuffer {
+		bufferPool.Put(buf)
+	}
+}
+
+func encodeJSON(w io.Writer, v interface{}) error {
+	return json.NewEncoder(w).Encode(v)
+}
+
+func encodeXML(w io.Writer, v interface{}) error {
+	return xml.NewEncoder(w).Encode(v)
+}
+
+const rawContentType = "application/octet-stream"
+
+// errNotRaw is returned for the responses which have no raw representation, e.g. the admin reports
+var errNotRaw = errors.New("response is not available as " + rawContentType)
+
+// encodeRaw writes the secret text as is, the attributes of the secret are sent in the headers
+func encodeRaw(w io.Writer, v interface{}) error {
+	s, ok := v.(sst.Secret)
+	if !ok {
+		return errNotRaw
+	}
+	_, err := io.WriteString(w, s.SecretText)
+	return err
+}
+
+func (a *App) initMarchalers() {
+	jsonMarshaler := Marshaler{
+		MarshalFunc: json.Marshal,
+		EncodeFunc:  encodeJSON,
+		ContentType: "application/json",
+	}
+	xmlTextMarshaler := Marshaler{
+		MarshalFunc: xml.Marshal,
+		EncodeFunc:  encodeXML,
+		ContentType: "text/xml",
+	}
+	xmlAppMarshaler := Marshaler{
+		MarshalFunc: xml.Marshal,
+		EncodeFunc:  encodeXML,
+		ContentType: "application/xml",
+	}
+	rawMarshaler := Marshaler{
+		MarshalFunc: func(v interface{}) ([]byte, error) {
+			var buf bytes.Buffer
+			err := encodeRaw(&buf, v)
+			return buf.Bytes(), err
+		},
+		EncodeFunc:  encodeRaw,
+		ContentType: rawContentType,
+	}
+	a.marshalers = map[string]Marshaler{
+		rawContentType:     rawMarshaler,
+		"*/*":              jsonMarshaler,
+		"application/json": jsonMarshaler,
+		"application/*":    jsonMarshaler,
+		"application/xml":  xmlAppMarshaler,
+		"text/xml":         xmlTextMarshaler,
+		"text/*":           xmlTextMarshaler,
+	}
+}
+
+// getMarshaler returns the marshaler of the first media range of the Accept header which is supported.
+// The header is scanned in place, so the lookup doesn't allocate
+func (a *App) getMarshaler(acceptHeader string) Marshaler {
+	for acceptHeader != "" {
+		item := acceptHeader
+		if i := strings.IndexByte(acceptHeader, ','); i >= 0 {
+			item, acceptHeader = acceptHeader[:i], acceptHeader[i+1:]
+		} else {
+			acceptHeader = ""
+		}
+		if i := strings.IndexByte(item, ';'); i >= 0 {
+			item = item[:i]
+		}
+		if m, ok := a.marshalers[strings.TrimSpace(item)]; ok {
+			return m
+		}
+	}
+	return Marshaler{}
+}
+
+// secretHeaders describes the written secret with its view headers,
+// the raw payload and the file have no body describing it, so they also get its hash and creation time
+func (a *App) secretHeaders(h http.Header, s sst.Secret, raw bool) {
+	if raw {
+		h.Set("X-Secret-Hash", s.Hash)
+		h.Set("X-Secret-Created-At", s.CreatedAt.Format(time.RFC3339))
+	}
+	a.viewHeaders(h, s)
+}
+
+// parseLabels parses the repeated key:value label fields
+func parseLabels(values []string) (sst.Labels, error) {
+	labels := make(sst.Labels, len(values))
+	for _, value := range values {
+		k, v, ok := strings.Cut(value, ":")
+		if !ok {
+			return nil, sst.ErrInvalidLabels
+		}
+		if _, exists := labels[k]; exists {
+			return nil, sst.ErrInvalidLabels
+		}
+		labels[k] = v
+	}
+	return labels, labels.Validate()
+}
+
+// formInt parses the integer form field. The preset value of the template replaces the field
+func formInt(r *http.Request, name string, preset *int) (int, error) {
+	if preset != nil {
+		return *preset, nil
+	}
+	return strconv.Atoi(r.FormValue(name))
+}
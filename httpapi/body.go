@@ -0,0 +1,116 @@
+package httpapi
+
+import (
+	"compress/gzip"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// ErrorResponse is the negotiated body of the error responses
+type ErrorResponse struct {
+	XMLName xml.Name `json:"-" xml:"error"`
+	Status  int      `json:"status" xml:"status"`
+	Message string   `json:"message" xml:"message"`
+}
+
+// WithMaxBodySize limits the size of the request bodies in bytes, the larger requests are refused
+// with 413 Request Entity Too Large before the form is parsed. 0 means no limit.
+// The limit is independent of the secret size policy, it protects the parsing of the form
+func WithMaxBodySize(n int64) Option {
+	return func(a *App) {
+		a.maxBodySize = n
+	}
+}
+
+// WithRequestDecompression accepts the request bodies compressed with gzip (Content-Encoding: gzip)
+// and stops reading them at maxSize decompressed bytes, so the small compressed body can't expand
+// into the huge form. The compressed body is still limited by WithMaxBodySize. 0 disables the decompression.
+// The other encodings are refused with 415
+func WithRequestDecompression(maxSize int64) Option {
+	return func(a *App) {
+		a.maxDecompressedSize = maxSize
+	}
+}
+
+// decompressBody replaces the gzip body of the request with its decompressed content
+func (a *App) decompressBody(next http.Handler) http.Handler {
+	if a.maxDecompressedSize <= 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch encoding := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding"))); encoding {
+		case "", "identity":
+			next.ServeHTTP(w, r)
+			return
+		case "gzip", "x-gzip":
+		default:
+			w.Header().Set("Accept-Encoding", "gzip")
+			a.errorResponse(w, r, http.StatusUnsupportedMediaType, "Unsupported Content-Encoding, only gzip is accepted")
+			return
+		}
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			a.bodyError(w, r, err, "Invalid gzip body", http.StatusBadRequest)
+			return
+		}
+		defer gz.Close()
+		r.Body = http.MaxBytesReader(w, gz, a.maxDecompressedSize)
+		r.Header.Del("Content-Encoding")
+		r.Header.Del("Content-Length")
+		r.ContentLength = -1
+		next.ServeHTTP(w, r)
+	})
+}
+
+// limitBody refuses the requests declaring the body larger than the limit and stops reading
+// the others at the limit, the handlers report it with bodyError
+func (a *App) limitBody(next http.Handler) http.Handler {
+	if a.maxBodySize <= 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ContentLength > a.maxBodySize {
+			a.tooLarge(w, r, a.maxBodySize)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, a.maxBodySize)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// bodyError reports the error of reading the request body. The body over the limit is refused with 413,
+// other errors with the status of the handler
+func (a *App) bodyError(w http.ResponseWriter, r *http.Request, err error, message string, status int) {
+	var maxErr *http.MaxBytesError
+	if errors.As(err, &maxErr) {
+		a.tooLarge(w, r, maxErr.Limit)
+		return
+	}
+	http.Error(w, message, status)
+}
+
+// tooLarge refuses the body over the limit, the decompressed body has its own limit
+func (a *App) tooLarge(w http.ResponseWriter, r *http.Request, limit int64) {
+	a.errorResponse(w, r, http.StatusRequestEntityTooLarge, fmt.Sprintf("Request body exceeds %d bytes", limit))
+}
+
+// errorResponse writes the error in the format of the Accept header, as plain text if it has no structured format
+func (a *App) errorResponse(w http.ResponseWriter, r *http.Request, status int, message string) {
+	m := a.getMarshaler(r.Header.Get("Accept"))
+	if m.ContentType == "" || m.ContentType == rawContentType {
+		http.Error(w, message, status)
+		return
+	}
+	b, err := m.MarshalFunc(ErrorResponse{Status: status, Message: message})
+	if err != nil {
+		http.Error(w, message, status)
+		return
+	}
+	w.Header().Set("Content-Type", m.ContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	_, _ = w.Write(b)
+}
@@ -0,0 +1,118 @@
+package httpapi_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	sst "github.com/evsan/secret-server-task"
+	"github.com/evsan/secret-server-task/httpapi"
+)
+
+func TestWithMaxBodySize(t *testing.T) {
+	testCases := map[string]struct {
+		secret string
+		// chunked hides the length of the body, so it is refused while the form is parsed
+		chunked  bool
+		headers  map[string]string
+		expected int
+	}{
+		"small":          {secret: "test secret", expected: http.StatusOK},
+		"large":          {secret: strings.Repeat("x", 200), expected: http.StatusRequestEntityTooLarge},
+		"large chunked":  {secret: strings.Repeat("x", 200), chunked: true, expected: http.StatusRequestEntityTooLarge},
+		"idempotent":     {secret: strings.Repeat("x", 200), chunked: true, headers: map[string]string{"Idempotency-Key": "1"}, expected: http.StatusRequestEntityTooLarge},
+		"small chunked":  {secret: "test secret", chunked: true, expected: http.StatusOK},
+		"large with key": {secret: strings.Repeat("x", 200), headers: map[string]string{"Idempotency-Key": "2"}, expected: http.StatusRequestEntityTooLarge},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			h := httpapi.New(sst.NewMemStorage(), httpapi.WithMetrics(nil), httpapi.WithMaxBodySize(100))
+
+			form := url.Values{"secret": {tc.secret}, "expireAfterViews": {"1"}, "expireAfter": {"0"}}
+			var body io.Reader = strings.NewReader(form.Encode())
+			if tc.chunked {
+				body = io.MultiReader(body)
+			}
+			req := httptest.NewRequest(http.MethodPost, "/secret", body)
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			req.Header.Set("Accept", "application/json")
+			for k, v := range tc.headers {
+				req.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+			if w.Code != tc.expected {
+				t.Fatalf("expected: %d, result: %d", tc.expected, w.Code)
+			}
+			if tc.expected != http.StatusRequestEntityTooLarge {
+				return
+			}
+			var result httpapi.ErrorResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+				t.Fatal("error is not expected: ", err)
+			}
+			if result.Status != http.StatusRequestEntityTooLarge {
+				t.Fatalf("expected: %d, result: %d", http.StatusRequestEntityTooLarge, result.Status)
+			}
+		})
+	}
+}
+
+func TestWithRequestDecompression(t *testing.T) {
+	compress := func(s string) []byte {
+		var b bytes.Buffer
+		gz := gzip.NewWriter(&b)
+		_, _ = gz.Write([]byte(s))
+		_ = gz.Close()
+		return b.Bytes()
+	}
+	form := func(secret string) string {
+		return url.Values{"secret": {secret}, "expireAfterViews": {"1"}, "expireAfter": {"0"}}.Encode()
+	}
+	testCases := map[string]struct {
+		body     []byte
+		encoding string
+		expected int
+	}{
+		"gzip":     {compress(form("test secret")), "gzip", http.StatusOK},
+		"identity": {[]byte(form("test secret")), "", http.StatusOK},
+		// The body is far below the limit on the wire
+		"bomb":    {compress(form(strings.Repeat("x", 10000))), "gzip", http.StatusRequestEntityTooLarge},
+		"invalid": {[]byte(form("test secret")), "gzip", http.StatusBadRequest},
+		"zstd":    {[]byte(form("test secret")), "zstd", http.StatusUnsupportedMediaType},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			h := httpapi.New(sst.NewMemStorage(), httpapi.WithMetrics(nil), httpapi.WithMaxBodySize(1000),
+				httpapi.WithRequestDecompression(500))
+
+			req := httptest.NewRequest(http.MethodPost, "/secret", bytes.NewReader(tc.body))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			req.Header.Set("Accept", "application/json")
+			if tc.encoding != "" {
+				req.Header.Set("Content-Encoding", tc.encoding)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+			if w.Code != tc.expected {
+				t.Fatalf("expected: %d, result: %d %s", tc.expected, w.Code, w.Body.String())
+			}
+			if tc.expected == http.StatusUnsupportedMediaType && w.Header().Get("Accept-Encoding") != "gzip" {
+				t.Fatalf("expected: %s, result: %s", "gzip", w.Header().Get("Accept-Encoding"))
+			}
+			if tc.expected != http.StatusOK {
+				return
+			}
+			var secret sst.Secret
+			if err := json.Unmarshal(w.Body.Bytes(), &secret); err != nil || secret.SecretText != "test secret" {
+				t.Fatalf("expected: %s, result: %s %v", "test secret", secret.SecretText, err)
+			}
+		})
+	}
+}
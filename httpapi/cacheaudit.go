@@ -0,0 +1,220 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+
+	sst "github.com/evsan/secret-server-task"
+)
+
+// secretRoutes are the routes returning the secret text or the token revealing it,
+// without the path prefix and the /t/{tenant} segment
+var secretRoutes = map[string]bool{
+	"POST /secret":                true,
+	"PUT /secret/{hash}":          true,
+	"GET /secret/{hash}":          true,
+	"POST /secret/{hash}/reveal":  true,
+	"GET /secret/{hash}/download": true,
+	"GET /link/{id}":              true,
+}
+
+// noStore forbids the browsers and the proxies to keep the response, Pragma covers the HTTP/1.0 caches
+func noStore(h http.Header) {
+	h.Set("Cache-Control", "no-store, no-cache")
+	h.Set("Pragma", "no-cache")
+}
+
+// notCacheable reports whether the headers carry the directives of noStore
+func notCacheable(h http.Header) bool {
+	directives := map[string]bool{}
+	for _, value := range h.Values("Cache-Control") {
+		for _, d := range strings.Split(value, ",") {
+			directives[strings.ToLower(strings.TrimSpace(d))] = true
+		}
+	}
+	return directives["no-store"] && directives["no-cache"] && strings.Contains(strings.ToLower(h.Get("Pragma")), "no-cache")
+}
+
+// WithCacheAudit checks the successful responses of the routes returning the secrets: the ones without
+// Cache-Control: no-store, no-cache and Pragma: no-cache are logged and counted by secret_cacheable_responses_total,
+// so the handler forgetting the headers is noticed before the secrets are kept by the caches
+func WithCacheAudit() Option {
+	return func(a *App) {
+		a.cacheAudit = a.reportCacheable
+	}
+}
+
+// reportCacheable records the cacheable response of the secret route
+func (a *App) reportCacheable(route string) {
+	log.Printf("cache audit: %s responded without Cache-Control: no-store, no-cache and Pragma: no-cache", route)
+	a.metrics.cacheableResponses.WithLabelValues(route).Inc()
+}
+
+// secretRoute returns the route of the secret response without the path prefix and the tenant, empty if the route is other
+func (a *App) secretRoute(pattern string) string {
+	method, path, _ := strings.Cut(pattern, " ")
+	path = strings.TrimPrefix(strings.TrimPrefix(path, a.pathPrefix), "/t/{tenant}")
+	if route := method + " " + path; secretRoutes[route] {
+		return route
+	}
+	return ""
+}
+
+// auditCache wraps the responses of the secret routes of the mux with the check of their headers
+func (a *App) auditCache(mux *http.ServeMux, next http.Handler) http.Handler {
+	if a.cacheAudit == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, pattern := mux.Handler(r)
+		route := a.secretRoute(pattern)
+		if route == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(&cacheAuditWriter{ResponseWriter: w, route: route, report: a.cacheAudit}, r)
+	})
+}
+
+// cacheAuditWriter checks the headers of the successful response when they are sent
+type cacheAuditWriter struct {
+	http.ResponseWriter
+	route   string
+	report  func(route string)
+	checked bool
+}
+
+func (w *cacheAuditWriter) check(status int) {
+	if w.checked {
+		return
+	}
+	w.checked = true
+	if status >= 200 && status < 300 && !notCacheable(w.Header()) {
+		w.report(w.route)
+	}
+}
+
+func (w *cacheAuditWriter) WriteHeader(status int) {
+	w.check(status)
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *cacheAuditWriter) Write(b []byte) (int, error) {
+	w.check(http.StatusOK)
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush keeps streaming responses working through the wrapper
+func (w *cacheAuditWriter) Flush() {
+	w.check(http.StatusOK)
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap allows http.ResponseController to reach the underlying writer
+func (w *cacheAuditWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// AuditCacheHeaders requests every secret route of the API over the memory storage in every representation
+// and returns the error naming the responses which could be kept by the caches. It is run at start and by the pre-deploy check
+func AuditCacheHeaders() error {
+	var cacheable []string
+	keys := APIKeys{
+		"audit-key":        {Key: "audit-key", Owner: "audit"},
+		"audit-tenant-key": {Key: "audit-tenant-key", Owner: "audit", Tenant: "audit"},
+	}
+	a := NewApp(sst.NewMemStorage(), WithMetrics(nil), WithAPIKeys(keys, false), WithHypermedia())
+	a.cacheAudit = func(route string) {
+		if !slices.Contains(cacheable, route) {
+			cacheable = append(cacheable, route)
+		}
+	}
+	handler := a.Handler()
+	key := ""
+	do := func(method, path, accept string, form url.Values) (*httptest.ResponseRecorder, error) {
+		req := httptest.NewRequest(method, path, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Accept", accept)
+		req.Header.Set("X-API-Key", key)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		if w.Code < 200 || w.Code >= 300 {
+			return nil, fmt.Errorf("cache audit: %s %s responded with %d", method, path, w.Code)
+		}
+		return w, nil
+	}
+	create := func(prefix string, form url.Values) (string, error) {
+		form.Set("secret", "cache audit")
+		form.Set("expireAfterViews", "100")
+		form.Set("expireAfter", "0")
+		w, err := do(http.MethodPost, prefix+"/secret", "application/json", form)
+		if err != nil {
+			return "", err
+		}
+		var secret sst.Secret
+		err = json.Unmarshal(w.Body.Bytes(), &secret)
+		return secret.Hash, err
+	}
+
+	accepts := []string{"application/json", "application/xml", "text/xml", rawContentType, halContentType, jsonAPIContentType}
+	for prefix, k := range map[string]string{"": "audit-key", "/t/audit": "audit-tenant-key"} {
+		key = k
+		hash, err := create(prefix, url.Values{"filename": {"audit.txt"}})
+		if err != nil {
+			return err
+		}
+		for _, accept := range accepts {
+			if _, err = do(http.MethodGet, prefix+"/secret/"+hash, accept, nil); err != nil {
+				return err
+			}
+		}
+		if _, err = do(http.MethodGet, prefix+"/secret/"+hash+"/download", "", nil); err != nil {
+			return err
+		}
+		if _, err = do(http.MethodPut, prefix+"/secret/"+a.hashFormat.Generate(), "application/json", url.Values{
+			"secret": {"cache audit"}, "expireAfterViews": {"1"}, "expireAfter": {"0"},
+		}); err != nil {
+			return err
+		}
+
+		w, err := do(http.MethodPost, prefix+"/secret/"+hash+"/links", "application/json", url.Values{"validFor": {"1"}})
+		if err != nil {
+			return err
+		}
+		var link ShareLinkResponse
+		if err = json.Unmarshal(w.Body.Bytes(), &link); err != nil {
+			return err
+		}
+		if _, err = do(http.MethodGet, prefix+"/link/"+link.ID, "application/json", nil); err != nil {
+			return err
+		}
+
+		claimed, err := create(prefix, url.Values{"claim": {"true"}})
+		if err != nil {
+			return err
+		}
+		w, err = do(http.MethodGet, prefix+"/secret/"+claimed, "application/json", nil)
+		if err != nil {
+			return err
+		}
+		var claim ClaimResponse
+		if err = json.Unmarshal(w.Body.Bytes(), &claim); err != nil {
+			return err
+		}
+		if _, err = do(http.MethodPost, prefix+"/secret/"+claimed+"/reveal", "application/json", url.Values{"claimToken": {claim.ClaimToken}}); err != nil {
+			return err
+		}
+	}
+	if len(cacheable) > 0 {
+		return fmt.Errorf("cache audit: the responses of %s could be cached", strings.Join(cacheable, ", "))
+	}
+	return nil
+}
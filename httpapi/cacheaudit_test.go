@@ -0,0 +1,44 @@
+package httpapi_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	sst "github.com/evsan/secret-server-task"
+	"github.com/evsan/secret-server-task/httpapi"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestAuditCacheHeaders(t *testing.T) {
+	if err := httpapi.AuditCacheHeaders(); err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+}
+
+func TestWithCacheAudit(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	h := httpapi.New(sst.NewMemStorage(), httpapi.WithMetrics(reg), httpapi.WithCacheAudit())
+
+	w := postSecret(h, "/secret")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected: %d, result: %d", http.StatusOK, w.Code)
+	}
+	req := httptest.NewRequest(http.MethodGet, w.Header().Get("Content-Location"), nil)
+	req.Header.Set("Accept", "application/json")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Header().Get("Cache-Control") != "no-store, no-cache" || w.Header().Get("Pragma") != "no-cache" {
+		t.Fatalf("unexpected headers: %v", w.Header())
+	}
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+	for _, f := range families {
+		if f.GetName() == "secret_cacheable_responses_total" {
+			t.Fatalf("expected: no cacheable responses, result: %v", f.Metric)
+		}
+	}
+}
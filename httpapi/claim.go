@@ -0,0 +1,123 @@
+package httpapi
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	sst "github.com/evsan/secret-server-task"
+)
+
+// ClaimResponse is returned by GET of the secret created with claim=true instead of the secret text.
+// No view is consumed until the token is posted to the reveal URL
+type ClaimResponse struct {
+	Hash           string    `json:"hash" xml:"hash"`
+	ClaimToken     string    `json:"claimToken" xml:"claimToken"`
+	ClaimExpiresAt time.Time `json:"claimExpiresAt" xml:"claimExpiresAt"`
+	RevealURL      string    `json:"revealUrl" xml:"revealUrl"`
+	CreatedAt      time.Time `json:"createdAt" xml:"createdAt"`
+	ExpiresAt      time.Time `json:"expiresAt" xml:"expiresAt"`
+	RemainingViews int       `json:"remainingViews" xml:"remainingViews"`
+}
+
+// WithClaimWindow sets how long the claim token of the two-step reveal is valid, one minute by default.
+// The tokens are signed with the key generated at start, so they are accepted only by the instance which issued them
+func WithClaimWindow(window time.Duration) Option {
+	return func(a *App) {
+		a.claims.window = window
+	}
+}
+
+// claimSigner issues the stateless claim tokens: the expiry time with HMAC of the storage key and the expiry
+type claimSigner struct {
+	window time.Duration
+	key    []byte
+}
+
+func newClaimSigner() claimSigner {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic(err)
+	}
+	return claimSigner{window: time.Minute, key: key}
+}
+
+func (c claimSigner) sign(key string, expiresAt int64) string {
+	mac := hmac.New(sha256.New, c.key)
+	mac.Write([]byte(key + "\n" + strconv.FormatInt(expiresAt, 10)))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// issue returns the token for the storage key and its expiry time
+func (c claimSigner) issue(key string, now time.Time) (string, time.Time) {
+	expiresAt := now.Add(c.window).Unix()
+	return strconv.FormatInt(expiresAt, 10) + "." + c.sign(key, expiresAt), time.Unix(expiresAt, 0)
+}
+
+// verify checks the token of the storage key
+func (c claimSigner) verify(key, token string, now time.Time) bool {
+	exp, sig, ok := strings.Cut(token, ".")
+	if !ok {
+		return false
+	}
+	expiresAt, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil || now.Unix() > expiresAt {
+		return false
+	}
+	return hmac.Equal([]byte(sig), []byte(c.sign(key, expiresAt)))
+}
+
+// claimResponse issues the claim token of the secret returned by Get with ErrClaimRequired
+func (a *App) claimResponse(tenant string, s sst.Secret, w http.ResponseWriter, r *http.Request) {
+	token, expiresAt := a.claims.issue(tenant+"/"+s.Hash, time.Now())
+	noStore(w.Header())
+	a.dataResponse(ClaimResponse{
+		Hash:           s.Hash,
+		ClaimToken:     token,
+		ClaimExpiresAt: expiresAt,
+		RevealURL:      a.secretURL(tenant, s.Hash) + "/reveal",
+		CreatedAt:      s.CreatedAt,
+		ExpiresAt:      s.ExpiresAt,
+		RemainingViews: s.RemainingViews,
+	}, w, r)
+}
+
+// revealSecretHandler serves the view of the claimed secret, the claimToken form field is required
+func (a *App) revealSecretHandler(w http.ResponseWriter, r *http.Request) {
+	a.metrics.secretGetCounter.Inc()
+	a.exemplar("secret_get_requests_total", r)
+	defer a.observeDuration(a.metrics.secretGetDuration, a.metrics.secretGetSeconds, "secret_get_request_duration_seconds", r)()
+
+	key := r.PathValue("hash")
+	if !a.validHash(key) {
+		a.rejectHash(key, w, r)
+		return
+	}
+	tenant, ok := a.requestTenant(w, r)
+	if !ok {
+		a.getHook(r.Context(), key, GetRejected)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		a.bodyError(w, r, err, "Invalid input", http.StatusMethodNotAllowed)
+		return
+	}
+	if !a.claims.verify(tenant+"/"+key, r.FormValue("claimToken"), time.Now()) {
+		a.getHook(r.Context(), key, GetRejected)
+		http.Error(w, "Invalid or expired claim token", http.StatusForbidden)
+		return
+	}
+	if !a.allowedByPolicy(PolicyInput{Action: PolicyRetrieve, Tenant: tenant, Hash: key}, w, r) {
+		a.getHook(r.Context(), key, GetRejected)
+		return
+	}
+
+	st := sst.NewTenantStorage(a.storage, tenant)
+	s, err := sst.RevealSecret(st, key)
+	a.serveSecret(st, key, s, err, w, r)
+}
@@ -0,0 +1,129 @@
+package httpapi_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	sst "github.com/evsan/secret-server-task"
+	"github.com/evsan/secret-server-task/httpapi"
+)
+
+func TestTwoStepReveal(t *testing.T) {
+	testCases := map[string]struct {
+		window   time.Duration
+		token    func(token string) string
+		expected int
+	}{
+		"valid":    {time.Minute, func(token string) string { return token }, http.StatusOK},
+		"expired":  {-time.Minute, func(token string) string { return token }, http.StatusForbidden},
+		"forged":   {time.Minute, func(token string) string { return token + "x" }, http.StatusForbidden},
+		"no token": {time.Minute, func(string) string { return "" }, http.StatusForbidden},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			h := httpapi.New(sst.NewMemStorage(), httpapi.WithMetrics(nil), httpapi.WithClaimWindow(tc.window))
+
+			form := url.Values{"secret": {"test secret"}, "expireAfterViews": {"1"}, "expireAfter": {"0"}, "claim": {"true"}}
+			req := httptest.NewRequest(http.MethodPost, "/secret", strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			req.Header.Set("Accept", "application/json")
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+			var secret sst.Secret
+			if err := json.Unmarshal(w.Body.Bytes(), &secret); err != nil {
+				t.Fatal("error is not expected: ", err)
+			}
+
+			// Link previews get the claim only, the view is not consumed
+			var claim httpapi.ClaimResponse
+			for i := 0; i < 2; i++ {
+				req = httptest.NewRequest(http.MethodGet, "/secret/"+secret.Hash, nil)
+				req.Header.Set("Accept", "application/json")
+				w = httptest.NewRecorder()
+				h.ServeHTTP(w, req)
+				if strings.Contains(w.Body.String(), "test secret") {
+					t.Fatal("secret text should not be returned before the reveal")
+				}
+				if err := json.Unmarshal(w.Body.Bytes(), &claim); err != nil {
+					t.Fatal("error is not expected: ", err)
+				}
+				if claim.RemainingViews != 1 {
+					t.Fatalf("expected: %d, result: %d", 1, claim.RemainingViews)
+				}
+			}
+
+			reveal := url.Values{"claimToken": {tc.token(claim.ClaimToken)}}
+			req = httptest.NewRequest(http.MethodPost, claim.RevealURL, strings.NewReader(reveal.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			req.Header.Set("Accept", "application/json")
+			w = httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+			if w.Code != tc.expected {
+				t.Fatalf("expected: %d, result: %d", tc.expected, w.Code)
+			}
+			if tc.expected == http.StatusOK && !strings.Contains(w.Body.String(), "test secret") {
+				t.Fatalf("expected: %s, result: %s", "test secret", w.Body.String())
+			}
+		})
+	}
+}
+
+func TestRecipientBinding(t *testing.T) {
+	const token = "pre-shared token 1234"
+	testCases := map[string]struct {
+		binding  url.Values
+		header   http.Header
+		expected int
+	}{
+		"token":           {url.Values{"recipientToken": {token}}, http.Header{"X-Recipient-Token": {token}}, http.StatusOK},
+		"wrong token":     {url.Values{"recipientToken": {token}}, http.Header{"X-Recipient-Token": {token + "x"}}, http.StatusForbidden},
+		"no credential":   {url.Values{"recipientToken": {token}}, http.Header{}, http.StatusForbidden},
+		"cert":            {url.Values{"recipientCert": {"CN=alice"}}, http.Header{"X-Client-Subject": {"CN=alice"}}, http.StatusOK},
+		"other cert":      {url.Values{"recipientCert": {"CN=alice"}}, http.Header{"X-Client-Subject": {"CN=mallory"}}, http.StatusForbidden},
+		"token for cert":  {url.Values{"recipientCert": {"CN=alice"}}, http.Header{"X-Recipient-Token": {"CN=alice"}}, http.StatusForbidden},
+		"weak token":      {url.Values{"recipientToken": {"short"}}, nil, http.StatusMethodNotAllowed},
+		"both credential": {url.Values{"recipientToken": {token}, "recipientCert": {"CN=alice"}}, nil, http.StatusMethodNotAllowed},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			h := httpapi.New(sst.NewMemStorage(), httpapi.WithMetrics(nil), httpapi.WithClientCertHeader("X-Client-Subject"))
+
+			form := url.Values{"secret": {"test secret"}, "expireAfterViews": {"1"}, "expireAfter": {"0"}}
+			for k, v := range tc.binding {
+				form[k] = v
+			}
+			req := httptest.NewRequest(http.MethodPost, "/secret", strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			req.Header.Set("Accept", "application/json")
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+			if tc.header == nil {
+				if w.Code != tc.expected {
+					t.Fatalf("expected: %d, result: %d", tc.expected, w.Code)
+				}
+				return
+			}
+			var secret sst.Secret
+			if err := json.Unmarshal(w.Body.Bytes(), &secret); err != nil {
+				t.Fatal("error is not expected: ", err)
+			}
+
+			req = httptest.NewRequest(http.MethodGet, "/secret/"+secret.Hash, nil)
+			req.Header = tc.header
+			req.Header.Set("Accept", "application/json")
+			w = httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+			if w.Code != tc.expected {
+				t.Fatalf("expected: %d, result: %d", tc.expected, w.Code)
+			}
+			if served := strings.Contains(w.Body.String(), "test secret"); served != (tc.expected == http.StatusOK) {
+				t.Fatalf("unexpected response: %s", w.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,120 @@
+package httpapi
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// CSRF token of the browser requests changing the data
+const (
+	csrfCookie = "sst_csrf"
+	csrfHeader = "X-CSRF-Token"
+	// csrfField is the form field of the plain HTML forms, which can't set the header
+	csrfField = "csrfToken"
+)
+
+// CSRFToken is the response of GET /csrf
+type CSRFToken struct {
+	Token string `json:"token" xml:"token"`
+}
+
+// csrfTokens issues the double-submit tokens: the random value with its HMAC, so the tokens
+// planted by the other sites sharing the cookies of the domain are refused
+type csrfTokens struct {
+	key []byte
+}
+
+// WithCSRF requires the token of GET /csrf in the browser requests changing the data: the requests with
+// the Origin, Sec-Fetch-Site or Cookie header have to send the cookie and the same token in X-CSRF-Token
+// or the csrfToken form field. The requests with the api key are exempt, the cross-site forms can't set it.
+// The tokens are signed with the key, empty generates it at start
+func WithCSRF(key []byte) Option {
+	return func(a *App) {
+		if len(key) == 0 {
+			key = make([]byte, 32)
+			if _, err := rand.Read(key); err != nil {
+				panic(err)
+			}
+		}
+		a.csrf = &csrfTokens{key: key}
+	}
+}
+
+func (c *csrfTokens) sign(value string) string {
+	mac := hmac.New(sha256.New, c.key)
+	mac.Write([]byte(value))
+	return b64(mac.Sum(nil))
+}
+
+func (c *csrfTokens) issue() string {
+	value := randomToken()
+	return value + "." + c.sign(value)
+}
+
+func (c *csrfTokens) valid(token string) bool {
+	value, sig, ok := strings.Cut(token, ".")
+	return ok && hmac.Equal([]byte(sig), []byte(c.sign(value)))
+}
+
+// browserRequest reports whether the request may come from the browser of the victim. The browsers send
+// Origin with the cross-site POST, Sec-Fetch-Site with every request and the cookies of the site
+func browserRequest(r *http.Request) bool {
+	return r.Header.Get("Origin") != "" || r.Header.Get("Sec-Fetch-Site") != "" || r.Header.Get("Cookie") != ""
+}
+
+// checkCSRF refuses the browser requests changing the data without the token
+func (a *App) checkCSRF(next http.Handler) http.Handler {
+	if a.csrf == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			next.ServeHTTP(w, r)
+			return
+		}
+		if apiKeyFromRequest(r) != "" || !browserRequest(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		token := r.Header.Get(csrfHeader)
+		if token == "" && strings.HasPrefix(r.Header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+			// The handlers read the parsed form, it is parsed once
+			if err := r.ParseForm(); err != nil {
+				a.bodyError(w, r, err, "Invalid input", http.StatusBadRequest)
+				return
+			}
+			token = r.PostForm.Get(csrfField)
+		}
+		c, err := r.Cookie(csrfCookie)
+		if err != nil || !a.csrf.valid(c.Value) || subtle.ConstantTimeCompare([]byte(token), []byte(c.Value)) != 1 {
+			http.Error(w, "CSRF token is missing or invalid", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// csrfHandler returns the token of the cookie, the new one is issued if the cookie is missing or invalid
+func (a *App) csrfHandler(w http.ResponseWriter, r *http.Request) {
+	token := ""
+	if c, err := r.Cookie(csrfCookie); err == nil && a.csrf.valid(c.Value) {
+		token = c.Value
+	} else {
+		token = a.csrf.issue()
+		http.SetCookie(w, &http.Cookie{
+			Name:     csrfCookie,
+			Value:    token,
+			Path:     a.Path("/"),
+			HttpOnly: true,
+			Secure:   strings.HasPrefix(a.baseURL, "https://"),
+			SameSite: http.SameSiteStrictMode,
+		})
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	a.dataResponse(CSRFToken{Token: token}, w, r)
+}
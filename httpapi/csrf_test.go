@@ -0,0 +1,82 @@
+package httpapi_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	sst "github.com/evsan/secret-server-task"
+	"github.com/evsan/secret-server-task/httpapi"
+)
+
+func TestWithCSRF(t *testing.T) {
+	keys := httpapi.APIKeys{"alice-key": {Key: "alice-key", Owner: "alice"}}
+	handler := httpapi.New(sst.NewMemStorage(), httpapi.WithMetrics(nil), httpapi.WithAPIKeys(keys, false), httpapi.WithCSRF(nil))
+
+	req := httptest.NewRequest(http.MethodGet, "/csrf", nil)
+	req.Header.Set("Accept", "application/json")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	var token httpapi.CSRFToken
+	if err := json.Unmarshal(w.Body.Bytes(), &token); err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+	cookies := w.Result().Cookies()
+	if w.Code != http.StatusOK || len(cookies) != 1 || cookies[0].Value != token.Token || cookies[0].SameSite != http.SameSiteStrictMode {
+		t.Fatalf("unexpected response: %d %v", w.Code, w.Header())
+	}
+	cookie := cookies[0]
+	forged := &http.Cookie{Name: cookie.Name, Value: "planted.token"}
+
+	testCases := map[string]struct {
+		header   http.Header
+		field    string
+		cookie   *http.Cookie
+		expected int
+	}{
+		"not browser":     {nil, "", nil, http.StatusOK},
+		"api key":         {http.Header{"Origin": {"https://evil.example.com"}, "X-Api-Key": {"alice-key"}}, "", nil, http.StatusOK},
+		"cross-site form": {http.Header{"Origin": {"https://evil.example.com"}}, "", nil, http.StatusForbidden},
+		"no cookie":       {http.Header{"Sec-Fetch-Site": {"same-origin"}, "X-Csrf-Token": {token.Token}}, "", nil, http.StatusForbidden},
+		"no token":        {http.Header{"Sec-Fetch-Site": {"same-origin"}}, "", cookie, http.StatusForbidden},
+		"other token":     {http.Header{"Sec-Fetch-Site": {"same-origin"}, "X-Csrf-Token": {"other"}}, "", cookie, http.StatusForbidden},
+		"planted cookie":  {http.Header{"Sec-Fetch-Site": {"same-origin"}, "X-Csrf-Token": {forged.Value}}, "", forged, http.StatusForbidden},
+		"header":          {http.Header{"Sec-Fetch-Site": {"same-origin"}, "X-Csrf-Token": {token.Token}}, "", cookie, http.StatusOK},
+		"form field":      {http.Header{"Origin": {"https://secrets.example.com"}}, token.Token, cookie, http.StatusOK},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			form := url.Values{"secret": {"test secret"}, "expireAfterViews": {"2"}, "expireAfter": {"0"}}
+			if tc.field != "" {
+				form.Set("csrfToken", tc.field)
+			}
+			req := httptest.NewRequest(http.MethodPost, "/secret", strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			req.Header.Set("Accept", "application/json")
+			for k, v := range tc.header {
+				req.Header[k] = v
+			}
+			if tc.cookie != nil {
+				req.AddCookie(tc.cookie)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			if w.Code != tc.expected {
+				t.Fatalf("expected: %d, result: %d %s", tc.expected, w.Code, w.Body.String())
+			}
+		})
+	}
+
+	// The valid cookie keeps its token
+	req = httptest.NewRequest(http.MethodGet, "/csrf", nil)
+	req.Header.Set("Accept", "application/json")
+	req.AddCookie(cookie)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if len(w.Result().Cookies()) != 0 || !strings.Contains(w.Body.String(), token.Token) {
+		t.Fatalf("unexpected response: %s %v", w.Body.String(), w.Header())
+	}
+}
@@ -0,0 +1,125 @@
+package httpapi
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	sst "github.com/evsan/secret-server-task"
+	"github.com/evsan/secret-server-task/webhook"
+)
+
+// releaseBatch is the maximum amount of the releases sent by one ReleaseSwitches call
+const releaseBatch = 100
+
+var (
+	errSwitchURL       = errors.New("releaseWebhookUrl is required with checkInInterval")
+	errSwitchNotBefore = errors.New("notBefore can't be set for the dead man's switch, the deadline is checkInInterval from now")
+	errSwitchOwner     = errors.New("dead man's switch requires the api key, the check-ins are authenticated by it")
+)
+
+// CheckInResponse is the body of POST /secret/{hash}/checkin
+type CheckInResponse struct {
+	Hash string `json:"hash" xml:"hash"`
+	// Deadline is the time the secret is released at without the next check-in
+	Deadline time.Time `json:"deadline" xml:"deadline"`
+}
+
+// switchOption reads the checkInInterval= and releaseWebhookUrl= fields making the secret the dead man's switch,
+// nil if there are none. The release URL is checked against the allowed webhook hosts
+func (a *App) switchOption(r *http.Request) (sst.SecretOption, error) {
+	interval, releaseURL := r.FormValue("checkInInterval"), r.FormValue("releaseWebhookUrl")
+	if interval == "" && releaseURL == "" {
+		return nil, nil
+	}
+	d, err := time.ParseDuration(interval)
+	if err != nil || d <= 0 {
+		return nil, sst.ErrInvalidCheckInInterval
+	}
+	switch {
+	case releaseURL == "":
+		return nil, errSwitchURL
+	case r.FormValue("notBefore") != "":
+		return nil, errSwitchNotBefore
+	case requestOwner(r) == "":
+		return nil, errSwitchOwner
+	}
+	if err = a.webhooks.Validate(releaseURL); err != nil {
+		return nil, err
+	}
+	return sst.WithDeadMansSwitch(d, releaseURL), nil
+}
+
+// checkInHandler moves the deadline of the dead man's switch of the owner identified by the api key.
+// The secrets of the other owners are reported as not found, so their existence isn't revealed
+func (a *App) checkInHandler(w http.ResponseWriter, r *http.Request) {
+	tenant, ok := a.requestTenant(w, r)
+	if !ok {
+		return
+	}
+	c, ok := sst.CheckInStorageOf(sst.NewTenantStorage(a.storage, tenant))
+	if !ok {
+		http.Error(w, "Storage doesn't support the dead man's switch", http.StatusNotImplemented)
+		return
+	}
+	key := r.PathValue("hash")
+	if !a.validHash(key) {
+		http.Error(w, "Secret not found", http.StatusNotFound)
+		return
+	}
+	secret, err := c.CheckIn(key, requestOwner(r))
+	switch {
+	case err == nil:
+		a.dataResponse(CheckInResponse{Hash: key, Deadline: secret.NotBefore}, w, r)
+	case err == sst.ErrSwitchReleased:
+		http.Error(w, "Deadline passed, the secret is released", http.StatusConflict)
+	case err == sst.ErrInvalidNotBefore:
+		http.Error(w, "Secret expires before the next deadline", http.StatusConflict)
+	case errors.Is(err, sst.ErrSecretNotAvailable):
+		http.Error(w, "Secret not found", http.StatusNotFound)
+	case backendUnavailable(err, w):
+	default:
+		log.Println("check-in: ", err)
+		http.Error(w, "Check-in failed", http.StatusInternalServerError)
+	}
+}
+
+// ReleaseSwitches posts the links of the dead man's switch secrets whose deadline passed to their release URLs
+// and returns the amount of the sent releases. The release is recorded only after it is accepted, so the failed one
+// is sent again by the next call; the release URLs of the hosts which are no longer allowed are abandoned
+func (a *App) ReleaseSwitches() (int, error) {
+	st, ok := sst.Base(a.storage).(sst.ReleaseStorage)
+	if !ok {
+		return 0, nil
+	}
+	due, err := st.DueReleases(releaseBatch)
+	if err != nil {
+		return 0, err
+	}
+	var released int
+	for _, s := range due {
+		hash := s.Hash
+		if s.Tenant != "" {
+			hash = strings.TrimPrefix(hash, s.Tenant+"/")
+		}
+		err = a.webhooks.Validate(s.ReleaseURL)
+		if err == nil {
+			err = a.webhooks.Send(s.ReleaseURL, webhook.Event{Event: webhook.Released, Hash: hash, URL: a.secretURL(s.Tenant, hash), At: s.NotBefore})
+		}
+		if err != nil && err != webhook.ErrHost && err != webhook.ErrURL {
+			log.Printf("dead man's switch: release of %s failed: %v", s.Hash, err)
+			continue
+		}
+		if err != nil {
+			log.Printf("dead man's switch: release of %s is abandoned: %v", s.Hash, err)
+		} else {
+			released++
+		}
+		if err = st.MarkReleased(s.Hash); err != nil {
+			return released, err
+		}
+	}
+	return released, nil
+}
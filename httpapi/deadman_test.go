@@ -0,0 +1,132 @@
+package httpapi_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	sst "github.com/evsan/secret-server-task"
+	"github.com/evsan/secret-server-task/httpapi"
+	"github.com/evsan/secret-server-task/webhook"
+)
+
+func TestDeadMansSwitch(t *testing.T) {
+	var events []webhook.Event
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var e webhook.Event
+		if err := json.NewDecoder(r.Body).Decode(&e); err != nil {
+			t.Error("error is not expected: ", err)
+		}
+		events = append(events, e)
+	}))
+	defer target.Close()
+
+	start := time.Now()
+	clock := sst.NewManualClock(start)
+	storage := sst.NewMemStorage(sst.WithMemClock(clock))
+	keys := httpapi.APIKeys{
+		"alice-key": {Key: "alice-key", Owner: "alice"},
+		"bob-key":   {Key: "bob-key", Owner: "bob"},
+	}
+	app := httpapi.NewApp(storage, httpapi.WithMetrics(nil), httpapi.WithAPIKeys(keys, false),
+		httpapi.WithWebhookHosts("127.0.0.1"), httpapi.WithBaseURL("https://secrets.example.com"))
+	h := app.Handler()
+	do := func(method, path, key string, form url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Accept", "application/json")
+		if key != "" {
+			req.Header.Set("X-API-Key", key)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+	create := func(key string, fields url.Values) *httptest.ResponseRecorder {
+		form := url.Values{"secret": {"test secret"}, "expireAfterViews": {"1"}, "expireAfter": {"0"}}
+		for name, values := range fields {
+			form[name] = values
+		}
+		return do(http.MethodPost, "/secret", key, form)
+	}
+
+	invalid := map[string]struct {
+		key    string
+		fields url.Values
+	}{
+		"no url":      {"alice-key", url.Values{"checkInInterval": {"1h"}}},
+		"no interval": {"alice-key", url.Values{"releaseWebhookUrl": {target.URL}}},
+		"interval":    {"alice-key", url.Values{"checkInInterval": {"-1h"}, "releaseWebhookUrl": {target.URL}}},
+		"host":        {"alice-key", url.Values{"checkInInterval": {"1h"}, "releaseWebhookUrl": {"https://example.com/hook"}}},
+		"notBefore":   {"alice-key", url.Values{"checkInInterval": {"1h"}, "releaseWebhookUrl": {target.URL}, "notBefore": {start.Format(time.RFC3339)}}},
+		"no owner":    {"", url.Values{"checkInInterval": {"1h"}, "releaseWebhookUrl": {target.URL}}},
+		"expiry":      {"alice-key", url.Values{"checkInInterval": {"1h"}, "releaseWebhookUrl": {target.URL}, "expireAfter": {"30"}}},
+	}
+	for name, tc := range invalid {
+		t.Run(name, func(t *testing.T) {
+			if w := create(tc.key, tc.fields); w.Code != http.StatusMethodNotAllowed {
+				t.Fatalf("expected: %d, result: %d", http.StatusMethodNotAllowed, w.Code)
+			}
+		})
+	}
+
+	w := create("alice-key", url.Values{"checkInInterval": {"1h"}, "releaseWebhookUrl": {target.URL + "/release"}, "expireAfter": {"1440"}})
+	if w.Code != http.StatusOK || strings.Contains(w.Body.String(), target.URL) {
+		t.Fatalf("unexpected response: %d %s", w.Code, w.Body.String())
+	}
+	var secret sst.Secret
+	if err := json.Unmarshal(w.Body.Bytes(), &secret); err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+	if w = do(http.MethodGet, "/secret/"+secret.Hash, "", nil); w.Code == http.StatusOK {
+		t.Fatalf("secret should not be available before the deadline: %s", w.Body.String())
+	}
+
+	// Only the owner checks in, the switch of the other owner is not found
+	clock.Add(30 * time.Minute)
+	if w = do(http.MethodPost, "/secret/"+secret.Hash+"/checkin", "bob-key", nil); w.Code != http.StatusNotFound {
+		t.Fatalf("expected: %d, result: %d", http.StatusNotFound, w.Code)
+	}
+	if w = do(http.MethodPost, "/secret/"+secret.Hash+"/checkin", "", nil); w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected: %d, result: %d", http.StatusUnauthorized, w.Code)
+	}
+	if w = do(http.MethodPost, "/secret/"+secret.Hash+"/checkin", "alice-key", nil); w.Code != http.StatusOK {
+		t.Fatalf("expected: %d, result: %d", http.StatusOK, w.Code)
+	}
+	var checkIn httpapi.CheckInResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &checkIn); err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+	if deadline := start.Add(90 * time.Minute); !checkIn.Deadline.Equal(deadline) {
+		t.Fatalf("expected: %s, result: %s", deadline, checkIn.Deadline)
+	}
+
+	// The first deadline is extended by the check-in
+	clock.Add(45 * time.Minute)
+	if released, err := app.ReleaseSwitches(); err != nil || released != 0 {
+		t.Fatalf("unexpected release: %d %v", released, err)
+	}
+
+	clock.Add(time.Hour)
+	if released, err := app.ReleaseSwitches(); err != nil || released != 1 {
+		t.Fatalf("unexpected release: %d %v", released, err)
+	}
+	if released, err := app.ReleaseSwitches(); err != nil || released != 0 {
+		t.Fatalf("release should be sent once: %d %v", released, err)
+	}
+	if len(events) != 1 || events[0].Event != webhook.Released || events[0].Hash != secret.Hash ||
+		events[0].URL != "https://secrets.example.com/secret/"+secret.Hash {
+		t.Fatalf("unexpected events: %+v", events)
+	}
+
+	if w = do(http.MethodPost, "/secret/"+secret.Hash+"/checkin", "alice-key", nil); w.Code != http.StatusConflict {
+		t.Fatalf("expected: %d, result: %d", http.StatusConflict, w.Code)
+	}
+	if w = do(http.MethodGet, "/secret/"+secret.Hash, "", nil); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "test secret") {
+		t.Fatalf("unexpected response: %d %s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,56 @@
+package httpapi
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	sst "github.com/evsan/secret-server-task"
+)
+
+// ownerDeleteReason is the revocation reason of the secrets deleted by their owner
+const ownerDeleteReason = "deleted by the owner"
+
+// deleteSecretHandler burns the secret of the owner identified by the api key before it is viewed or expires.
+// The secrets of the other owners are reported as not found, so their existence isn't revealed
+func (a *App) deleteSecretHandler(w http.ResponseWriter, r *http.Request) {
+	tenant, ok := a.requestTenant(w, r)
+	if !ok {
+		return
+	}
+	st := sst.NewTenantStorage(a.storage, tenant)
+	p, canPeek := sst.PeekerOf(st)
+	revoker, canRevoke := sst.RevokerOf(st)
+	if !canPeek || !canRevoke {
+		http.Error(w, "Storage doesn't support revocation", http.StatusNotImplemented)
+		return
+	}
+	key := r.PathValue("hash")
+	if !a.validHash(key) {
+		http.Error(w, "Secret not found", http.StatusNotFound)
+		return
+	}
+	secret, err := p.Peek(key)
+	if err == nil && secret.Owner != requestOwner(r) {
+		err = sst.ErrSecretNotAvailable
+	}
+	if err == nil {
+		err = revoker.Revoke(key, ownerDeleteReason)
+	}
+	// The secret whose last view was downloaded is gone, its resumable download is still burnt
+	if owner := requestOwner(r); owner != "" && a.dropDownloads(downloadKey(tenant, key), owner) > 0 &&
+		errors.Is(err, sst.ErrSecretNotAvailable) {
+		err = nil
+	}
+	switch {
+	case err == nil:
+		w.WriteHeader(http.StatusNoContent)
+	// The owner of the scheduled secret can't be verified before its notBefore
+	case errors.Is(err, sst.ErrSecretNotAvailable), err == sst.ErrSecretNotYetAvailable:
+		http.Error(w, "Secret not found", http.StatusNotFound)
+	case backendUnavailable(err, w):
+	default:
+		log.Println("delete: ", err)
+		http.Error(w, "Revocation failed", http.StatusInternalServerError)
+	}
+}
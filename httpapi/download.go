@@ -0,0 +1,169 @@
+package httpapi
+
+import (
+	"errors"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	sst "github.com/evsan/secret-server-task"
+)
+
+// maxFilename is the longest name of the file secret in bytes, the usual limit of the file systems
+const maxFilename = 255
+
+// Errors of the file secrets
+var (
+	errInvalidFilename    = errors.New("filename should be the name of the file without the path, at most 255 bytes")
+	errInvalidContentType = errors.New("contentType should be the media type, e.g. application/x-pem-file")
+)
+
+// downloadExtensions are the extensions of the files saved without the name by the detected type
+var downloadExtensions = map[string]string{
+	"application/x-pem-file": ".pem",
+	"application/json":       ".json",
+}
+
+// fileOption reads the filename= and contentType= fields marking the secret as the file, nil if there are none
+func fileOption(r *http.Request) (sst.SecretOption, error) {
+	filename, contentType := r.FormValue("filename"), r.FormValue("contentType")
+	if filename == "" && contentType == "" {
+		return nil, nil
+	}
+	if filename != "" && !validFilename(filename) {
+		return nil, errInvalidFilename
+	}
+	if contentType != "" {
+		mediaType, params, err := mime.ParseMediaType(contentType)
+		if err != nil {
+			return nil, errInvalidContentType
+		}
+		contentType = mime.FormatMediaType(mediaType, params)
+	}
+	return sst.WithFile(filename, contentType), nil
+}
+
+// validFilename reports whether the name has no path and no control characters
+func validFilename(name string) bool {
+	if len(name) > maxFilename || !utf8.ValidString(name) || name == "." || name == ".." {
+		return false
+	}
+	return strings.IndexFunc(name, func(r rune) bool {
+		return r == '/' || r == '\\' || unicode.IsControl(r)
+	}) < 0
+}
+
+// downloadSecretHandler serves the secret as the file the browsers save instead of rendering it.
+// The view is consumed as by GET /secret/{hash}; the secrets revealed in two steps can't be downloaded.
+// The request with the token of the resumable download serves the kept file without consuming a view
+func (a *App) downloadSecretHandler(w http.ResponseWriter, r *http.Request) {
+	a.metrics.secretGetCounter.Inc()
+	a.exemplar("secret_get_requests_total", r)
+	defer a.observeDuration(a.metrics.secretGetDuration, a.metrics.secretGetSeconds, "secret_get_request_duration_seconds", r)()
+
+	key := r.PathValue("hash")
+	if !a.validHash(key) {
+		a.rejectHash(key, w, r)
+		return
+	}
+	tenant, ok := a.requestTenant(w, r)
+	if !ok {
+		a.getHook(r.Context(), key, GetRejected)
+		return
+	}
+	if !a.allowedByPolicy(PolicyInput{Action: PolicyRetrieve, Tenant: tenant, Hash: key}, w, r) {
+		a.getHook(r.Context(), key, GetRejected)
+		return
+	}
+
+	if token := r.Header.Get(DownloadTokenHeader); token != "" && a.downloads != nil {
+		now := time.Now()
+		session, ok := a.downloads.claim(downloadKey(tenant, key), token, now)
+		if !ok {
+			a.getHook(r.Context(), key, GetRejected)
+			http.Error(w, "Invalid or expired download token", http.StatusForbidden)
+			return
+		}
+		a.getHook(r.Context(), key, GetResumed)
+		a.downloads.serve(session, w, r)
+		return
+	}
+	if _, _, ok := resumeRange(r.Header.Get("Range"), 0); !ok && a.downloads != nil {
+		// The view isn't consumed by the download whose start can't be served
+		a.getHook(r.Context(), key, GetRejected)
+		http.Error(w, resumeRangeMessage, http.StatusRequestedRangeNotSatisfiable)
+		return
+	}
+
+	st := sst.NewTenantStorage(a.storage, tenant)
+	s, err := st.Get(key)
+	if err == sst.ErrClaimRequired || err == sst.ErrRecipientRequired {
+		// Get consumes no view of them
+		a.getHook(r.Context(), key, GetRejected)
+		http.Error(w, "Secret is revealed in two steps, it can't be downloaded", http.StatusConflict)
+		return
+	}
+	if !a.served(st, key, s, err, w, r) {
+		return
+	}
+	if a.downloads != nil {
+		header := http.Header{}
+		if !a.fileHeader(s, header, w) {
+			return
+		}
+		now := time.Now()
+		if token, session, ok := a.downloads.start(downloadKey(tenant, key), s.Owner, s.SecretText, header, now); ok {
+			w.Header().Set(DownloadTokenHeader, token)
+			w.Header().Set("X-Download-Token-Expires-At", session.expiresAt.Format(time.RFC3339))
+			a.downloads.serve(session, w, r)
+			return
+		}
+	}
+	a.fileResponse(s, w)
+}
+
+// fileResponse writes the text of the secret as the attachment with its name and type
+func (a *App) fileResponse(s sst.Secret, w http.ResponseWriter) {
+	h := w.Header()
+	if !a.fileHeader(s, h, w) {
+		return
+	}
+	h.Set("Content-Length", strconv.Itoa(len(s.SecretText)))
+	_, _ = w.Write([]byte(s.SecretText))
+}
+
+// fileHeader sets the headers of the file secret, false if the error was written
+func (a *App) fileHeader(s sst.Secret, h http.Header, w http.ResponseWriter) bool {
+	contentType := s.ContentType
+	if contentType == "" {
+		contentType = detectContentType(s.SecretText)
+	}
+	filename := s.Filename
+	if filename == "" {
+		mediaType, _, _ := mime.ParseMediaType(contentType)
+		ext, ok := downloadExtensions[mediaType]
+		if !ok {
+			ext = ".txt"
+		}
+		filename = s.Hash + ext
+	}
+	h.Set("Content-Type", contentType)
+	h.Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+	h.Set("X-Content-Type-Options", "nosniff")
+	noStore(h)
+	if a.signer != nil {
+		// The signature is of the whole file, also in the partial responses
+		sig, err := a.signer.Detached([]byte(s.SecretText))
+		if err != nil {
+			http.Error(w, "Signing failed", http.StatusInternalServerError)
+			return false
+		}
+		h.Set(signatureHeader, sig)
+	}
+	a.secretHeaders(h, s, true)
+	return true
+}
@@ -0,0 +1,93 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"net/http"
+
+	sst "github.com/evsan/secret-server-task"
+)
+
+const (
+	// halContentType is the media type of HAL, the secret has _links
+	halContentType = "application/hal+json"
+	// jsonAPIContentType is the media type of JSON:API, the secret is the resource object of the data member
+	jsonAPIContentType = "application/vnd.api+json"
+)
+
+// WithHypermedia serves the secrets as HAL and JSON:API if the client accepts application/hal+json
+// or application/vnd.api+json. The links are self, delete (DELETE of self by the owner), views and preview,
+// absolute if WithBaseURL is set. The other responses are plain JSON in these media types
+func WithHypermedia() Option {
+	return func(a *App) {
+		for _, ct := range []string{halContentType, jsonAPIContentType} {
+			a.marshalers[ct] = Marshaler{
+				MarshalFunc: json.Marshal,
+				EncodeFunc:  encodeJSON,
+				ContentType: ct,
+			}
+		}
+	}
+}
+
+// halLink is the link object of HAL
+type halLink struct {
+	Href string `json:"href"`
+}
+
+// jsonAPIDocument is the top-level document of JSON:API with the single resource
+type jsonAPIDocument struct {
+	Data jsonAPIResource `json:"data"`
+}
+
+// jsonAPIResource is the resource object of JSON:API
+type jsonAPIResource struct {
+	Type       string                     `json:"type"`
+	ID         string                     `json:"id"`
+	Attributes map[string]json.RawMessage `json:"attributes"`
+	Links      map[string]string          `json:"links"`
+}
+
+// secretLinks returns the links of the secret by the relation
+func (a *App) secretLinks(r *http.Request, s sst.Secret) map[string]string {
+	// The secrets served via the share links have no hash, their URL isn't revealed
+	if s.Hash == "" {
+		return map[string]string{}
+	}
+	self := a.secretURL(r.PathValue("tenant"), s.Hash)
+	links := map[string]string{
+		"self":    self,
+		"delete":  self,
+		"views":   self + "/views",
+		"preview": self + "/preview",
+	}
+	if s.Filename != "" {
+		links["download"] = self + "/download"
+	}
+	return links
+}
+
+// hypermedia wraps the shaped secret into the document of the media type
+func (a *App) hypermedia(contentType string, s sst.Secret, body interface{}, r *http.Request) (interface{}, error) {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]json.RawMessage
+	if err = json.Unmarshal(b, &fields); err != nil {
+		return nil, err
+	}
+	links := a.secretLinks(r, s)
+
+	if contentType == jsonAPIContentType {
+		delete(fields, "hash")
+		return jsonAPIDocument{Data: jsonAPIResource{Type: "secret", ID: s.Hash, Attributes: fields, Links: links}}, nil
+	}
+	halLinks := make(map[string]halLink, len(links))
+	for rel, href := range links {
+		halLinks[rel] = halLink{Href: href}
+	}
+	if fields["_links"], err = json.Marshal(halLinks); err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
@@ -0,0 +1,117 @@
+package httpapi_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	sst "github.com/evsan/secret-server-task"
+	"github.com/evsan/secret-server-task/httpapi"
+)
+
+func TestWithHypermedia(t *testing.T) {
+	storage := sst.NewMemStorage()
+	h := httpapi.New(storage, httpapi.WithMetrics(nil), httpapi.WithHypermedia(), httpapi.WithBaseURL("https://secrets.example.com"))
+
+	t.Run("hal", func(t *testing.T) {
+		secret, _ := storage.Store("text", 1, 0)
+		req := httptest.NewRequest(http.MethodGet, "/secret/"+secret.Hash, nil)
+		req.Header.Set("Accept", "application/hal+json")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if ct := w.Header().Get("Content-Type"); ct != "application/hal+json" {
+			t.Fatalf("expected: %s, result: %s", "application/hal+json", ct)
+		}
+		var doc struct {
+			SecretText string `json:"secretText"`
+			Links      map[string]struct {
+				Href string `json:"href"`
+			} `json:"_links"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &doc); err != nil {
+			t.Fatal("error is not expected: ", err)
+		}
+		self := "https://secrets.example.com/secret/" + secret.Hash
+		if doc.SecretText != "text" || doc.Links["self"].Href != self || doc.Links["delete"].Href != self || doc.Links["views"].Href != self+"/views" {
+			t.Fatalf("expected: text and the links of %s, result: %s", self, w.Body.String())
+		}
+	})
+
+	t.Run("json:api", func(t *testing.T) {
+		secret, _ := storage.Store("text", 1, 0)
+		req := httptest.NewRequest(http.MethodGet, "/secret/"+secret.Hash, nil)
+		req.Header.Set("Accept", "application/vnd.api+json")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		var doc struct {
+			Data struct {
+				Type       string                 `json:"type"`
+				ID         string                 `json:"id"`
+				Attributes map[string]interface{} `json:"attributes"`
+				Links      map[string]string      `json:"links"`
+			} `json:"data"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &doc); err != nil {
+			t.Fatal("error is not expected: ", err)
+		}
+		if doc.Data.Type != "secret" || doc.Data.ID != secret.Hash || doc.Data.Attributes["secretText"] != "text" || doc.Data.Attributes["hash"] != nil {
+			t.Fatalf("expected: the secret resource, result: %s", w.Body.String())
+		}
+		if doc.Data.Links["preview"] != "https://secrets.example.com/secret/"+secret.Hash+"/preview" {
+			t.Fatalf("expected: the preview link, result: %s", doc.Data.Links["preview"])
+		}
+	})
+}
+
+func TestDeleteSecret(t *testing.T) {
+	keys := httpapi.APIKeys{
+		"alice-key": {Key: "alice-key", Owner: "alice"},
+		"bob-key":   {Key: "bob-key", Owner: "bob"},
+	}
+	h := httpapi.New(sst.NewMemStorage(), httpapi.WithMetrics(nil), httpapi.WithAPIKeys(keys, false))
+
+	form := url.Values{"secret": {"test secret"}, "expireAfterViews": {"1"}, "expireAfter": {"0"}}
+	req := httptest.NewRequest(http.MethodPost, "/secret", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("X-API-Key", "alice-key")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	var secret sst.Secret
+	if err := json.Unmarshal(w.Body.Bytes(), &secret); err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+
+	// The order matters: the other owner can't delete the secret, the owner deletes it once
+	steps := []struct {
+		key      string
+		expected int
+	}{
+		{"", http.StatusUnauthorized},
+		{"bob-key", http.StatusNotFound},
+		{"alice-key", http.StatusNoContent},
+		{"alice-key", http.StatusNotFound},
+	}
+	for _, step := range steps {
+		req := httptest.NewRequest(http.MethodDelete, "/secret/"+secret.Hash, nil)
+		if step.key != "" {
+			req.Header.Set("X-API-Key", step.key)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != step.expected {
+			t.Fatalf("expected: %d, result: %d", step.expected, w.Code)
+		}
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/secret/"+secret.Hash, nil)
+	req.Header.Set("Accept", "application/json")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected: %d, result: %d", http.StatusNotFound, w.Code)
+	}
+}
@@ -0,0 +1,179 @@
+package httpapi
+
+import (
+	"embed"
+	"encoding/json"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// defaultLocale is the locale of the messages in the code, it needs no catalog
+const defaultLocale = "en"
+
+// catalogFS holds the message catalogs, locales/<locale>.json maps the English message
+// as passed to http.Error to its translation
+//
+//go:embed locales/*.json
+var catalogFS embed.FS
+
+// catalogs holds the translations of the error messages of the public API by locale
+var catalogs = loadCatalogs()
+
+func loadCatalogs() map[string]map[string]string {
+	files, err := catalogFS.ReadDir("locales")
+	if err != nil {
+		panic(err)
+	}
+	c := make(map[string]map[string]string, len(files))
+	for _, f := range files {
+		b, err := catalogFS.ReadFile("locales/" + f.Name())
+		if err != nil {
+			panic(err)
+		}
+		var messages map[string]string
+		if err = json.Unmarshal(b, &messages); err != nil {
+			panic("locales/" + f.Name() + ": " + err.Error())
+		}
+		c[strings.TrimSuffix(f.Name(), path.Ext(f.Name()))] = messages
+	}
+	return c
+}
+
+// Locales returns the locales the error messages are translated to, including the default English one
+func Locales() []string {
+	locales := []string{defaultLocale}
+	for l := range catalogs {
+		locales = append(locales, l)
+	}
+	sort.Strings(locales[1:])
+	return locales
+}
+
+// WithLocales translates the error messages of the public API to the language of Accept-Language.
+// The fallback locale is used if the request accepts none of Locales, the unknown fallback means English.
+// The messages without the translation, e.g. the policy violations, are sent in English
+func WithLocales(fallback string) Option {
+	return func(a *App) {
+		if _, ok := catalogs[fallback]; !ok {
+			fallback = defaultLocale
+		}
+		a.fallbackLocale = fallback
+	}
+}
+
+// negotiateLocale returns the locale of Accept-Language with the highest quality,
+// the region is ignored if the locale of the region isn't known, e.g. de-AT is de
+func negotiateLocale(acceptLanguage, fallback string) string {
+	type tag struct {
+		locale string
+		q      float64
+	}
+	var tags []tag
+	for _, item := range strings.Split(acceptLanguage, ",") {
+		locale, params, _ := strings.Cut(strings.TrimSpace(item), ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			var err error
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		if locale != "" && q > 0 {
+			tags = append(tags, tag{strings.ToLower(locale), q})
+		}
+	}
+	sort.SliceStable(tags, func(i, j int) bool { return tags[i].q > tags[j].q })
+
+	for _, t := range tags {
+		if t.locale == "*" {
+			return fallback
+		}
+		for locale := t.locale; locale != ""; {
+			if _, ok := catalogs[locale]; ok || locale == defaultLocale {
+				return locale
+			}
+			i := strings.LastIndexByte(locale, '-')
+			if i < 0 {
+				break
+			}
+			locale = locale[:i]
+		}
+	}
+	return fallback
+}
+
+// localize translates the errors written by http.Error if WithLocales is set
+func (a *App) localize(next http.Handler) http.Handler {
+	if a.fallbackLocale == "" {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lw := &localizingWriter{ResponseWriter: w, locale: negotiateLocale(r.Header.Get("Accept-Language"), a.fallbackLocale)}
+		next.ServeHTTP(lw, r)
+		lw.flushHeader()
+	})
+}
+
+// localizingWriter replaces the body of the plain text error responses with its translation.
+// http.Error writes the message with a single Write after setting X-Content-Type-Options, so the secrets
+// served as text/plain and the other responses are passed as is. The status of the error is held
+// until its message is written, so Content-Language is set only for the translated ones
+type localizingWriter struct {
+	http.ResponseWriter
+	locale      string
+	wroteHeader bool
+	// pending is the held status of the error response
+	pending int
+}
+
+func (w *localizingWriter) WriteHeader(status int) {
+	if w.wroteHeader || w.pending != 0 {
+		return
+	}
+	h := w.Header()
+	if status >= http.StatusBadRequest && h.Get("X-Content-Type-Options") == "nosniff" &&
+		strings.HasPrefix(h.Get("Content-Type"), "text/plain") {
+		w.pending = status
+		return
+	}
+	w.wroteHeader = true
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *localizingWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader && w.pending == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.pending == 0 {
+		return w.ResponseWriter.Write(b)
+	}
+	w.Header().Add("Vary", "Accept-Language")
+	translated, ok := catalogs[w.locale][strings.TrimSuffix(string(b), "\n")]
+	if !ok {
+		w.flushHeader()
+		return w.ResponseWriter.Write(b)
+	}
+	w.Header().Set("Content-Language", w.locale)
+	w.flushHeader()
+	if _, err := w.ResponseWriter.Write([]byte(translated + "\n")); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// flushHeader writes the held status
+func (w *localizingWriter) flushHeader() {
+	if w.pending != 0 {
+		w.wroteHeader = true
+		w.ResponseWriter.WriteHeader(w.pending)
+		w.pending = 0
+	}
+}
+
+// Unwrap allows http.ResponseController to reach the underlying writer
+func (w *localizingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,73 @@
+package httpapi_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	sst "github.com/evsan/secret-server-task"
+	"github.com/evsan/secret-server-task/httpapi"
+)
+
+func TestWithLocales(t *testing.T) {
+	storage := sst.NewMemStorage()
+	secret, err := storage.Store("Geheimnis", 1, 0)
+	if err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+
+	testCases := map[string]struct {
+		fallback       string
+		acceptLanguage string
+		hash           string
+		expected       string
+		language       string
+	}{
+		"translated":       {"en", "de", "0123456789abcdef0123456789abcdef", "Geheimnis nicht gefunden\n", "de"},
+		"region":           {"en", "fr-CA, en;q=0.5", "0123456789abcdef0123456789abcdef", "Secret introuvable\n", "fr"},
+		"quality":          {"en", "de;q=0.5, hu", "0123456789abcdef0123456789abcdef", "A titok nem található\n", "hu"},
+		"english":          {"de", "en-US", "0123456789abcdef0123456789abcdef", "Secret not found\n", ""},
+		"fallback":         {"es", "ja", "0123456789abcdef0123456789abcdef", "Secreto no encontrado\n", "es"},
+		"no header":        {"es", "", "0123456789abcdef0123456789abcdef", "Secreto no encontrado\n", "es"},
+		"unknown fallback": {"xx", "ja", "0123456789abcdef0123456789abcdef", "Secret not found\n", ""},
+		"secret":           {"en", "de", secret.Hash, `"secretText":"Geheimnis"`, ""},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			h := httpapi.New(storage, httpapi.WithMetrics(nil), httpapi.WithLocales(tc.fallback))
+			req := httptest.NewRequest(http.MethodGet, "/secret/"+tc.hash, nil)
+			req.Header.Set("Accept", "application/json")
+			if tc.acceptLanguage != "" {
+				req.Header.Set("Accept-Language", tc.acceptLanguage)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+			if !strings.Contains(w.Body.String(), tc.expected) {
+				t.Fatalf("expected: %q, result: %q", tc.expected, w.Body.String())
+			}
+			if language := w.Header().Get("Content-Language"); language != tc.language {
+				t.Fatalf("expected: %s, result: %s", tc.language, language)
+			}
+		})
+	}
+}
+
+func TestWithLocales_Untranslated(t *testing.T) {
+	h := httpapi.New(sst.NewMemStorage(), httpapi.WithMetrics(nil), httpapi.WithLocales("de"))
+	form := url.Values{"secret": {"test"}, "expireAfterViews": {"1"}, "expireAfter": {"0"}}
+	req := httptest.NewRequest(http.MethodPost, "/secret", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Accept-Language", "de")
+	req.Header.Set("Idempotency-Key", strings.Repeat("k", 1000))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest || w.Body.String() != "Idempotency-Key is too long\n" {
+		t.Fatalf("expected: %d, result: %d %q", http.StatusBadRequest, w.Code, w.Body.String())
+	}
+	if language := w.Header().Get("Content-Language"); language != "" {
+		t.Fatalf("expected: no Content-Language, result: %s", language)
+	}
+}
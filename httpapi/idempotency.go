@@ -0,0 +1,199 @@
+package httpapi
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	idempotencyHeader = "Idempotency-Key"
+	// maxIdempotencyKey limits the memory used by the keys
+	maxIdempotencyKey = 255
+)
+
+// WithIdempotencyWindow sets how long the responses of POST /secret are replayed for the same Idempotency-Key.
+// The cached response contains the secret, so the window should be short. Zero disables the replay.
+// The requests without the api key are replayed only to the same client address,
+// X-Forwarded-For of the trusted proxies is the client address
+func WithIdempotencyWindow(window time.Duration, trustedProxies ...*net.IPNet) Option {
+	return func(a *App) {
+		a.idempotency = newIdempotencyCache(window)
+		if a.idempotency != nil {
+			a.idempotency.trustedProxies = trustedProxies
+		}
+	}
+}
+
+// idempotencyCache keeps the successful creation responses in memory.
+// The replay works only if the retry reaches the same instance
+type idempotencyCache struct {
+	window time.Duration
+	// trustedProxies are the proxies whose X-Forwarded-For is the address of the anonymous client
+	trustedProxies []*net.IPNet
+
+	mu        sync.Mutex
+	entries   map[string]*idempotentResponse
+	lastSweep time.Time
+}
+
+type idempotentResponse struct {
+	fingerprint string
+	// done is closed when the original request is finished
+	done      chan struct{}
+	status    int
+	header    http.Header
+	body      []byte
+	expiresAt time.Time
+}
+
+func newIdempotencyCache(window time.Duration) *idempotencyCache {
+	if window <= 0 {
+		return nil
+	}
+	return &idempotencyCache{window: window, entries: map[string]*idempotentResponse{}}
+}
+
+// middleware replays the stored response if the request with the same key and the same form was already
+// handled successfully. The key is scoped by the path and the api key or the address of the anonymous client,
+// so clients can't replay each other's responses.
+// bodyError reports the form which can't be parsed
+func (c *idempotencyCache) middleware(next http.Handler, bodyError func(http.ResponseWriter, *http.Request, error, string, int)) http.Handler {
+	if c == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(idempotencyHeader)
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKey {
+			http.Error(w, "Idempotency-Key is too long", http.StatusBadRequest)
+			return
+		}
+		if err := r.ParseForm(); err != nil {
+			bodyError(w, r, err, "Invalid input", http.StatusMethodNotAllowed)
+			return
+		}
+		apiKey, _ := requestAPIKey(r)
+		// The owner separates the users of the web login, their sessions have no key.
+		// The anonymous clients share neither, the one reusing the key of the other must not get its secret
+		client := ""
+		if apiKey.Key == "" && apiKey.Owner == "" {
+			client = clientIP(r, c.trustedProxies)
+		}
+		scope := strings.Join([]string{r.URL.Path, apiKey.Key, apiKey.Owner, client, key}, "\x00")
+		sum := sha256.Sum256([]byte(r.PostForm.Encode()))
+		fingerprint := hex.EncodeToString(sum[:])
+
+		entry, found := c.acquire(scope, fingerprint)
+		if found {
+			c.replay(w, entry, fingerprint)
+			return
+		}
+
+		rw := &recordingWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rw, r)
+		c.finish(scope, entry, rw)
+	})
+}
+
+// acquire returns the existing entry of the key or registers the new in-flight one
+func (c *idempotencyCache) acquire(scope, fingerprint string) (*idempotentResponse, bool) {
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if now.Sub(c.lastSweep) > c.window {
+		for k, e := range c.entries {
+			if !e.expiresAt.IsZero() && now.After(e.expiresAt) {
+				delete(c.entries, k)
+			}
+		}
+		c.lastSweep = now
+	}
+
+	if e, ok := c.entries[scope]; ok && (e.expiresAt.IsZero() || now.Before(e.expiresAt)) {
+		return e, true
+	}
+	e := &idempotentResponse{fingerprint: fingerprint, done: make(chan struct{})}
+	c.entries[scope] = e
+	return e, false
+}
+
+// finish stores the successful response. Failed requests are forgotten, so the client can retry them
+func (c *idempotencyCache) finish(scope string, e *idempotentResponse, rw *recordingWriter) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if rw.status >= 200 && rw.status < 300 {
+		e.status, e.header, e.body = rw.status, rw.header, rw.body.Bytes()
+		e.expiresAt = time.Now().Add(c.window)
+	} else {
+		delete(c.entries, scope)
+	}
+	close(e.done)
+}
+
+func (c *idempotencyCache) replay(w http.ResponseWriter, e *idempotentResponse, fingerprint string) {
+	if e.fingerprint != fingerprint {
+		http.Error(w, "Idempotency-Key is already used for a different request", http.StatusUnprocessableEntity)
+		return
+	}
+	select {
+	case <-e.done:
+	default:
+		http.Error(w, "Request with the same Idempotency-Key is in progress", http.StatusConflict)
+		return
+	}
+	c.mu.Lock()
+	status, header, body := e.status, e.header, e.body
+	c.mu.Unlock()
+	if status == 0 {
+		// The original request failed meanwhile
+		http.Error(w, "Request with the same Idempotency-Key failed, retry it", http.StatusConflict)
+		return
+	}
+	for k, v := range header {
+		w.Header()[k] = v
+	}
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(status)
+	_, _ = w.Write(body)
+}
+
+// recordingWriter passes the response to the client and keeps a copy
+type recordingWriter struct {
+	http.ResponseWriter
+	status      int
+	header      http.Header
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (w *recordingWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		w.status = status
+		w.header = w.ResponseWriter.Header().Clone()
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *recordingWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap allows http.ResponseController to reach the underlying writer
+func (w *recordingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
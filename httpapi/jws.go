@@ -0,0 +1,189 @@
+package httpapi
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"sync/atomic"
+)
+
+// joseContentType is the compact JWS response requested with the Accept header
+const joseContentType = "application/jose"
+
+// signatureHeader carries the detached JWS of the response body
+const signatureHeader = "X-JWS-Signature"
+
+// ErrInvalidSigningKey is returned if the signing key is not the P-256 ECDSA private key
+var ErrInvalidSigningKey = errors.New("signing key should be the P-256 ECDSA private key")
+
+// Signer signs the responses with ES256, its public key is served as the JWKS
+type Signer struct {
+	key atomic.Pointer[signingKey]
+}
+
+// signingKey is the key replaced by Rotate with its public key and headers at once
+type signingKey struct {
+	key *ecdsa.PrivateKey
+	jwk JWK
+	// header is the encoded protected header of the detached and the compact signatures
+	header, compactHeader string
+}
+
+// JWK is the public key of the signer, RFC 7517
+type JWK struct {
+	Kty string `json:"kty"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+}
+
+// JWKS is the response of GET /.well-known/jwks.json
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// NewSigner creates the signer of the P-256 key. The key id is its RFC 7638 thumbprint
+func NewSigner(key *ecdsa.PrivateKey) (*Signer, error) {
+	if key == nil || key.Curve != elliptic.P256() {
+		return nil, ErrInvalidSigningKey
+	}
+	jwk := JWK{
+		Kty: "EC",
+		Crv: "P-256",
+		X:   b64(key.X.FillBytes(make([]byte, 32))),
+		Y:   b64(key.Y.FillBytes(make([]byte, 32))),
+		Use: "sig",
+		Alg: "ES256",
+	}
+	// The members of the thumbprint are required in the lexicographic order
+	thumbprint := sha256.Sum256([]byte(`{"crv":"` + jwk.Crv + `","kty":"` + jwk.Kty + `","x":"` + jwk.X + `","y":"` + jwk.Y + `"}`))
+	jwk.Kid = b64(thumbprint[:])
+	s := &Signer{}
+	s.key.Store(&signingKey{
+		key:           key,
+		jwk:           jwk,
+		header:        b64([]byte(`{"alg":"ES256","kid":"` + jwk.Kid + `"}`)),
+		compactHeader: b64([]byte(`{"alg":"ES256","kid":"` + jwk.Kid + `","cty":"json"}`)),
+	})
+	return s, nil
+}
+
+// Rotate replaces the key by the key of next, the responses and the JWKS switch to it at once.
+// The signatures have the new kid, so the verifiers refetch the JWKS
+func (s *Signer) Rotate(next *Signer) {
+	s.key.Store(next.key.Load())
+}
+
+// LoadSigner reads the PEM encoded P-256 key, SEC 1 (EC PRIVATE KEY) or PKCS #8 (PRIVATE KEY)
+func LoadSigner(path string) (*Signer, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, ErrInvalidSigningKey
+	}
+	var key interface{}
+	switch block.Type {
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return nil, ErrInvalidSigningKey
+	}
+	if err != nil {
+		return nil, err
+	}
+	ecKey, ok := key.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, ErrInvalidSigningKey
+	}
+	return NewSigner(ecKey)
+}
+
+// JWKS returns the set of the public keys the signatures are verified with
+func (s *Signer) JWKS() JWKS {
+	return JWKS{Keys: []JWK{s.key.Load().jwk}}
+}
+
+// Detached returns the JWS of the payload without the payload, RFC 7515 appendix F: header..signature
+func (s *Signer) Detached(payload []byte) (string, error) {
+	k := s.key.Load()
+	sig, err := k.sign(k.header, payload)
+	if err != nil {
+		return "", err
+	}
+	return k.header + ".." + sig, nil
+}
+
+// Compact returns the JWS compact serialization of the payload
+func (s *Signer) Compact(payload []byte) (string, error) {
+	k := s.key.Load()
+	sig, err := k.sign(k.compactHeader, payload)
+	if err != nil {
+		return "", err
+	}
+	return k.compactHeader + "." + b64(payload) + "." + sig, nil
+}
+
+// sign returns the ES256 signature of the signing input: the fixed size R || S
+func (k *signingKey) sign(header string, payload []byte) (string, error) {
+	digest := sha256.Sum256([]byte(header + "." + b64(payload)))
+	r, ss, err := ecdsa.Sign(rand.Reader, k.key, digest[:])
+	if err != nil {
+		return "", err
+	}
+	sig := make([]byte, 64)
+	r.FillBytes(sig[:32])
+	ss.FillBytes(sig[32:])
+	return b64(sig), nil
+}
+
+// marshaler encodes the value as JSON signed in the compact serialization
+func (s *Signer) marshaler() Marshaler {
+	return Marshaler{
+		MarshalFunc: func(v interface{}) ([]byte, error) {
+			payload, err := json.Marshal(v)
+			if err != nil {
+				return nil, err
+			}
+			jws, err := s.Compact(payload)
+			return []byte(jws), err
+		},
+		ContentType: joseContentType,
+	}
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// WithSigner signs the responses carrying the secrets. The signature of the regular response is detached
+// in the X-JWS-Signature header, Accept: application/jose returns the compact JWS of the JSON response instead.
+// The public key is served at GET /.well-known/jwks.json
+func WithSigner(s *Signer) Option {
+	return func(a *App) {
+		a.signer = s
+		a.marshalers[joseContentType] = s.marshaler()
+	}
+}
+
+// jwksHandler serves the public key of the signer
+func (a *App) jwksHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	w.Header().Set("Content-Type", "application/jwk-set+json")
+	_ = json.NewEncoder(w).Encode(a.signer.JWKS())
+}
@@ -0,0 +1,155 @@
+package httpapi_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	sst "github.com/evsan/secret-server-task"
+	"github.com/evsan/secret-server-task/httpapi"
+)
+
+// verifyJWS checks the compact serialization, header.payload.signature, with the key of the JWKS
+func verifyJWS(t *testing.T, jwks httpapi.JWKS, jws string) {
+	t.Helper()
+	parts := strings.Split(jws, ".")
+	if len(parts) != 3 || len(jwks.Keys) != 1 {
+		t.Fatalf("unexpected jws: %s", jws)
+	}
+	coord := func(s string) *big.Int {
+		b, err := base64.RawURLEncoding.DecodeString(s)
+		if err != nil {
+			t.Fatal("error is not expected: ", err)
+		}
+		return new(big.Int).SetBytes(b)
+	}
+	key := ecdsa.PublicKey{Curve: elliptic.P256(), X: coord(jwks.Keys[0].X), Y: coord(jwks.Keys[0].Y)}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || len(sig) != 64 {
+		t.Fatalf("unexpected signature: %s", parts[2])
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if !ecdsa.Verify(&key, digest[:], new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])) {
+		t.Fatalf("signature is invalid: %s", jws)
+	}
+}
+
+func TestWithSigner(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+	signer, err := httpapi.NewSigner(key)
+	if err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+	storage := sst.NewMemStorage()
+	handler := httpapi.New(storage, httpapi.WithMetrics(nil), httpapi.WithSigner(signer))
+	get := func(path, accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Accept", accept)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	w := get("/.well-known/jwks.json", "application/json")
+	var jwks httpapi.JWKS
+	if err = json.Unmarshal(w.Body.Bytes(), &jwks); err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+	if len(jwks.Keys) != 1 || jwks.Keys[0].Kid != signer.JWKS().Keys[0].Kid || jwks.Keys[0].Alg != "ES256" {
+		t.Fatalf("unexpected jwks: %s", w.Body.String())
+	}
+
+	secret, err := storage.Store("signed secret", 2, 0)
+	if err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+
+	w = get("/secret/"+secret.Hash, "application/json")
+	detached := strings.Split(w.Header().Get("X-JWS-Signature"), "..")
+	if w.Code != http.StatusOK || len(detached) != 2 {
+		t.Fatalf("unexpected response: %d %v", w.Code, w.Header())
+	}
+	verifyJWS(t, jwks, detached[0]+"."+base64.RawURLEncoding.EncodeToString(w.Body.Bytes())+"."+detached[1])
+
+	w = get("/secret/"+secret.Hash, "application/jose")
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/jose" {
+		t.Fatalf("unexpected response: %d %v", w.Code, w.Header())
+	}
+	verifyJWS(t, jwks, w.Body.String())
+	payload, err := base64.RawURLEncoding.DecodeString(strings.Split(w.Body.String(), ".")[1])
+	if err != nil || !strings.Contains(string(payload), `"secretText":"signed secret"`) {
+		t.Fatalf("unexpected payload: %s", payload)
+	}
+
+	if _, err = httpapi.NewSigner(nil); err != httpapi.ErrInvalidSigningKey {
+		t.Fatalf("expected: %v, result: %v", httpapi.ErrInvalidSigningKey, err)
+	}
+}
+
+func TestCreationReceipt(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+	signer, err := httpapi.NewSigner(key)
+	if err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+	keys := httpapi.APIKeys{"alice-key": {Key: "alice-key", Owner: "alice"}}
+	policies := httpapi.NewPolicies(httpapi.PolicyConfig{Default: sst.Policy{MaxExpireAfterViews: 3, CapExpireAfterViews: true}})
+	post := func(opts ...httpapi.Option) *httptest.ResponseRecorder {
+		handler := httpapi.New(sst.NewMemStorage(), append(opts, httpapi.WithMetrics(nil), httpapi.WithAPIKeys(keys, false),
+			httpapi.WithPolicies(policies), httpapi.WithBaseURL("https://secrets.example.com"))...)
+		form := url.Values{"secret": {"test secret"}, "expireAfterViews": {"5"}, "expireAfter": {"10"}}
+		req := httptest.NewRequest(http.MethodPost, "/secret", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Accept", "application/json")
+		req.Header.Set("X-API-Key", "alice-key")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected: %d, result: %d", http.StatusOK, w.Code)
+		}
+		return w
+	}
+
+	if receipt := post().Header().Get("X-Creation-Receipt"); receipt != "" {
+		t.Fatalf("unexpected receipt without the signer: %s", receipt)
+	}
+
+	w := post(httpapi.WithSigner(signer))
+	var secret sst.Secret
+	if err = json.Unmarshal(w.Body.Bytes(), &secret); err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+	jws := w.Header().Get("X-Creation-Receipt")
+	verifyJWS(t, signer.JWKS(), jws)
+	payload, err := base64.RawURLEncoding.DecodeString(strings.Split(jws, ".")[1])
+	if err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+	if strings.Contains(string(payload), "test secret") {
+		t.Fatalf("receipt should not carry the secret text: %s", payload)
+	}
+	var receipt httpapi.CreationReceipt
+	if err = json.Unmarshal(payload, &receipt); err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+	if receipt.Type != "secret-creation-receipt" || receipt.Hash != secret.Hash || receipt.Owner != "alice" ||
+		receipt.URL != "https://secrets.example.com/secret/"+secret.Hash || !receipt.ExpiresAt.Equal(secret.ExpiresAt) ||
+		receipt.ExpireAfterViews != 3 || receipt.Policy.MaxExpireAfterViews != 3 || receipt.PolicyEvaluated {
+		t.Fatalf("unexpected receipt: %s", payload)
+	}
+}
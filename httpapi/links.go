@@ -0,0 +1,205 @@
+package httpapi
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	sst "github.com/evsan/secret-server-task"
+)
+
+// ShareLinkResponse is the share link with its URL
+type ShareLinkResponse struct {
+	sst.ShareLink
+	URL string `json:"url" xml:"url"`
+}
+
+// ShareLinksResponse is the body of GET /secret/{hash}/links
+type ShareLinksResponse struct {
+	Hash  string              `json:"hash" xml:"hash"`
+	Links []ShareLinkResponse `json:"links" xml:"links>link"`
+}
+
+// linkURL returns the URL of the share link
+func (a *App) linkURL(tenant, id string) string {
+	if tenant == "" {
+		return a.URL("/link/" + id)
+	}
+	return a.URL("/t/" + tenant + "/link/" + id)
+}
+
+// ownedSecret returns the share links of the storage and the secret of the owner identified by the api key.
+// The secrets of the other owners are reported as not found, so their existence isn't revealed
+func (a *App) ownedSecret(w http.ResponseWriter, r *http.Request) (string, sst.ShareLinks, sst.Secret, bool) {
+	tenant, ok := a.requestTenant(w, r)
+	if !ok {
+		return "", nil, sst.Secret{}, false
+	}
+	st := sst.NewTenantStorage(a.storage, tenant)
+	p, canPeek := sst.PeekerOf(st)
+	links, hasLinks := sst.ShareLinksOf(st)
+	if !canPeek || !hasLinks {
+		http.Error(w, "Storage doesn't keep the share links", http.StatusNotImplemented)
+		return "", nil, sst.Secret{}, false
+	}
+	key := r.PathValue("hash")
+	if !a.validHash(key) {
+		http.Error(w, "Secret not found", http.StatusNotFound)
+		return "", nil, sst.Secret{}, false
+	}
+	secret, err := p.Peek(key)
+	if errors.Is(err, sst.ErrSecretNotAvailable) || err == sst.ErrSecretNotYetAvailable || (err == nil && secret.Owner != requestOwner(r)) {
+		http.Error(w, "Secret not found", http.StatusNotFound)
+		return "", nil, sst.Secret{}, false
+	}
+	if backendUnavailable(err, w) {
+		return "", nil, sst.Secret{}, false
+	}
+	if err != nil {
+		log.Println("share links: ", err)
+		http.Error(w, "Share links are not available", http.StatusInternalServerError)
+		return "", nil, sst.Secret{}, false
+	}
+	return tenant, links, secret, true
+}
+
+// createLinkHandler adds the share link valid for validFor minutes to the secret of the owner.
+// The link never outlives the secret, its expiry is capped by the expiry of the secret
+func (a *App) createLinkHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		a.bodyError(w, r, err, "Invalid input", http.StatusBadRequest)
+		return
+	}
+	validFor, err := strconv.Atoi(r.FormValue("validFor"))
+	if err != nil || validFor < 1 {
+		http.Error(w, "Invalid validFor, the value should be positive", http.StatusBadRequest)
+		return
+	}
+	var singleUse bool
+	if v := r.FormValue("singleUse"); v != "" {
+		if singleUse, err = strconv.ParseBool(v); err != nil {
+			http.Error(w, "Invalid singleUse, the value should be true or false", http.StatusBadRequest)
+			return
+		}
+	}
+	tenant, links, secret, ok := a.ownedSecret(w, r)
+	if !ok {
+		return
+	}
+	// The claim and the recipient credential are bound to the URL of the secret, the link can't carry them
+	if secret.ClaimRequired || secret.Recipient != "" {
+		http.Error(w, "Secret requiring the claim or the recipient can't be shared by the links", http.StatusConflict)
+		return
+	}
+	now := time.Now()
+	link := sst.ShareLink{
+		ID:        sst.GenHashKey(),
+		Hash:      secret.Hash,
+		Owner:     secret.Owner,
+		CreatedAt: now,
+		ExpiresAt: now.Add(time.Duration(validFor) * time.Minute),
+		SingleUse: singleUse,
+	}
+	if !secret.ExpiresAt.IsZero() && secret.ExpiresAt.Before(link.ExpiresAt) {
+		link.ExpiresAt = secret.ExpiresAt
+	}
+	err = links.CreateLink(link)
+	if backendUnavailable(err, w) {
+		return
+	}
+	if err != nil {
+		log.Println("share links: ", err)
+		http.Error(w, "Share link can't be created", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	a.dataResponse(ShareLinkResponse{ShareLink: link, URL: a.linkURL(tenant, link.ID)}, w, r)
+}
+
+// listLinksHandler returns the share links of the secret to its owner, the used and the expired ones included
+// until they are purged
+func (a *App) listLinksHandler(w http.ResponseWriter, r *http.Request) {
+	tenant, links, secret, ok := a.ownedSecret(w, r)
+	if !ok {
+		return
+	}
+	list, err := links.Links(secret.Hash)
+	if backendUnavailable(err, w) {
+		return
+	}
+	if err != nil {
+		log.Println("share links: ", err)
+		http.Error(w, "Share links are not available", http.StatusInternalServerError)
+		return
+	}
+	resp := ShareLinksResponse{Hash: secret.Hash, Links: make([]ShareLinkResponse, len(list))}
+	for i, link := range list {
+		resp.Links[i] = ShareLinkResponse{ShareLink: link, URL: a.linkURL(tenant, link.ID)}
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	a.dataResponse(resp, w, r)
+}
+
+// deleteLinkHandler revokes the share link of the secret, the secret and its other links are kept
+func (a *App) deleteLinkHandler(w http.ResponseWriter, r *http.Request) {
+	_, links, secret, ok := a.ownedSecret(w, r)
+	if !ok {
+		return
+	}
+	err := links.DeleteLink(secret.Hash, r.PathValue("id"))
+	switch {
+	case err == nil:
+		w.WriteHeader(http.StatusNoContent)
+	case errors.Is(err, sst.ErrSecretNotAvailable):
+		http.Error(w, "Share link not found", http.StatusNotFound)
+	case backendUnavailable(err, w):
+	default:
+		log.Println("share links: ", err)
+		http.Error(w, "Share link can't be deleted", http.StatusInternalServerError)
+	}
+}
+
+// getLinkHandler serves the secret of the share link. It consumes a view of the secret like GET /secret/{hash},
+// the hash is left out of the response, so the holder of the link can't outlive its validity
+func (a *App) getLinkHandler(w http.ResponseWriter, r *http.Request) {
+	a.metrics.secretGetCounter.Inc()
+	a.exemplar("secret_get_requests_total", r)
+	defer a.observeDuration(a.metrics.secretGetDuration, a.metrics.secretGetSeconds, "secret_get_request_duration_seconds", r)()
+
+	id := r.PathValue("id")
+	if !sst.DefaultHashFormat.Match(id) {
+		a.rejectHash(id, w, r)
+		return
+	}
+	tenant, ok := a.requestTenant(w, r)
+	if !ok {
+		a.getHook(r.Context(), id, GetRejected)
+		return
+	}
+	if !a.allowedByPolicy(PolicyInput{Action: PolicyRetrieve, Tenant: tenant, Link: id}, w, r) {
+		a.getHook(r.Context(), id, GetRejected)
+		return
+	}
+	st := sst.NewTenantStorage(a.storage, tenant)
+	links, ok := sst.ShareLinksOf(st)
+	if !ok {
+		a.rejectHash(id, w, r)
+		return
+	}
+	link, err := links.UseLink(id)
+	if err != nil {
+		a.served(st, id, sst.Secret{Unavailable: sst.ReasonNotFound}, err, w, r)
+		return
+	}
+	s, err := st.Get(link.Hash)
+	if err == sst.ErrClaimRequired || err == sst.ErrRecipientRequired {
+		// The links of the gated secrets are refused by createLinkHandler, the gate is never bypassed
+		s, err = sst.Secret{}, sst.ErrSecretNotAvailable
+	}
+	if a.served(st, link.Hash, s, err, w, r) {
+		s.Hash = ""
+		a.dataResponse(s, w, r)
+	}
+}
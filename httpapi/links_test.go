@@ -0,0 +1,162 @@
+package httpapi_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	sst "github.com/evsan/secret-server-task"
+	"github.com/evsan/secret-server-task/httpapi"
+)
+
+func TestShareLinks(t *testing.T) {
+	keys := httpapi.APIKeys{
+		"alice-key": {Key: "alice-key", Owner: "alice"},
+		"bob-key":   {Key: "bob-key", Owner: "bob"},
+	}
+	h := httpapi.New(sst.NewMemStorage(), httpapi.WithMetrics(nil), httpapi.WithAPIKeys(keys, false))
+	do := func(method, path, key string, form url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Accept", "application/json")
+		if key != "" {
+			req.Header.Set("X-API-Key", key)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	w := do(http.MethodPost, "/secret", "alice-key", url.Values{"secret": {"test secret"}, "expireAfterViews": {"3"}, "expireAfter": {"60"}})
+	var secret sst.Secret
+	if err := json.Unmarshal(w.Body.Bytes(), &secret); err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+	links := "/secret/" + secret.Hash + "/links"
+
+	testCases := map[string]struct {
+		key      string
+		form     url.Values
+		expected int
+	}{
+		"other owner":      {"bob-key", url.Values{"validFor": {"10"}}, http.StatusNotFound},
+		"anonymous":        {"", url.Values{"validFor": {"10"}}, http.StatusUnauthorized},
+		"no validity":      {"alice-key", url.Values{}, http.StatusBadRequest},
+		"invalid validity": {"alice-key", url.Values{"validFor": {"0"}}, http.StatusBadRequest},
+		"invalid flag":     {"alice-key", url.Values{"validFor": {"10"}, "singleUse": {"once"}}, http.StatusBadRequest},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			if w := do(http.MethodPost, links, tc.key, tc.form); w.Code != tc.expected {
+				t.Fatalf("expected: %d, result: %d", tc.expected, w.Code)
+			}
+		})
+	}
+
+	create := func(form url.Values) httpapi.ShareLinkResponse {
+		w := do(http.MethodPost, links, "alice-key", form)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected: %d, result: %d", http.StatusOK, w.Code)
+		}
+		var link httpapi.ShareLinkResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &link); err != nil {
+			t.Fatal("error is not expected: ", err)
+		}
+		return link
+	}
+	once := create(url.Values{"validFor": {"10"}, "singleUse": {"true"}})
+	// The link never outlives the secret
+	capped := create(url.Values{"validFor": {"120"}})
+	if !capped.ExpiresAt.Equal(secret.ExpiresAt) || !once.ExpiresAt.Before(secret.ExpiresAt) {
+		t.Fatalf("unexpected expiry: %s, %s", once.ExpiresAt, capped.ExpiresAt)
+	}
+	if once.URL != "/link/"+once.ID {
+		t.Fatalf("expected: %s, result: %s", "/link/"+once.ID, once.URL)
+	}
+
+	// The steps are ordered, every served link consumes a view of the secret
+	steps := []struct {
+		name     string
+		method   string
+		path     string
+		expected int
+	}{
+		{"single-use", http.MethodGet, once.URL, http.StatusOK},
+		{"single-use again", http.MethodGet, once.URL, http.StatusNotFound},
+		{"reusable", http.MethodGet, capped.URL, http.StatusOK},
+		{"reusable again", http.MethodGet, capped.URL, http.StatusOK},
+		{"consumed secret", http.MethodGet, capped.URL, http.StatusNotFound},
+		{"unknown", http.MethodGet, "/link/" + sst.GenHashKey(), http.StatusNotFound},
+		{"malformed", http.MethodGet, "/link/x", http.StatusNotFound},
+	}
+	for _, step := range steps {
+		w := do(step.method, step.path, "", nil)
+		if w.Code != step.expected {
+			t.Fatalf("%s: expected: %d, result: %d", step.name, step.expected, w.Code)
+		}
+		if w.Code != http.StatusOK {
+			continue
+		}
+		var s sst.Secret
+		if err := json.Unmarshal(w.Body.Bytes(), &s); err != nil {
+			t.Fatal("error is not expected: ", err)
+		}
+		if s.SecretText != "test secret" || s.Hash != "" {
+			t.Fatalf("%s: unexpected secret: %+v", step.name, s)
+		}
+	}
+}
+
+func TestShareLinks_Manage(t *testing.T) {
+	keys := httpapi.APIKeys{"alice-key": {Key: "alice-key", Owner: "alice"}}
+	h := httpapi.New(sst.NewMemStorage(), httpapi.WithMetrics(nil), httpapi.WithAPIKeys(keys, false))
+	do := func(method, path string, form url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Accept", "application/json")
+		req.Header.Set("X-API-Key", "alice-key")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	w := do(http.MethodPost, "/secret", url.Values{"secret": {"test secret"}, "expireAfterViews": {"5"}, "expireAfter": {"0"}})
+	var secret sst.Secret
+	if err := json.Unmarshal(w.Body.Bytes(), &secret); err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+	links := "/secret/" + secret.Hash + "/links"
+	var created []httpapi.ShareLinkResponse
+	for i := 0; i < 2; i++ {
+		var link httpapi.ShareLinkResponse
+		if err := json.Unmarshal(do(http.MethodPost, links, url.Values{"validFor": {"10"}}).Body.Bytes(), &link); err != nil {
+			t.Fatal("error is not expected: ", err)
+		}
+		created = append(created, link)
+	}
+
+	if w = do(http.MethodDelete, links+"/"+created[0].ID, nil); w.Code != http.StatusNoContent {
+		t.Fatalf("expected: %d, result: %d", http.StatusNoContent, w.Code)
+	}
+	if w = do(http.MethodDelete, links+"/"+created[0].ID, nil); w.Code != http.StatusNotFound {
+		t.Fatalf("expected: %d, result: %d", http.StatusNotFound, w.Code)
+	}
+	// The revoked link is gone, the secret is shared by the other one
+	if w = do(http.MethodGet, created[0].URL, nil); w.Code != http.StatusNotFound {
+		t.Fatalf("expected: %d, result: %d", http.StatusNotFound, w.Code)
+	}
+	if w = do(http.MethodGet, created[1].URL, nil); w.Code != http.StatusOK {
+		t.Fatalf("expected: %d, result: %d", http.StatusOK, w.Code)
+	}
+
+	var list httpapi.ShareLinksResponse
+	if err := json.Unmarshal(do(http.MethodGet, links, nil).Body.Bytes(), &list); err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+	if list.Hash != secret.Hash || len(list.Links) != 1 || list.Links[0].ID != created[1].ID {
+		t.Fatalf("unexpected links: %+v", list)
+	}
+}
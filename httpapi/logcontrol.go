@@ -0,0 +1,182 @@
+package httpapi
+
+import (
+	"encoding/xml"
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	sst "github.com/evsan/secret-server-task"
+)
+
+// LogLevel is the verbosity of the access log
+type LogLevel string
+
+// Access log levels
+const (
+	// LevelDebug logs every request with the remote address and the User-Agent, the sampling is off
+	LevelDebug LogLevel = "debug"
+	// LevelInfo logs the sampled requests and every failed one
+	LevelInfo LogLevel = "info"
+	// LevelWarn logs the requests failed with 4xx and 5xx
+	LevelWarn LogLevel = "warn"
+	// LevelError logs the requests failed with 5xx
+	LevelError LogLevel = "error"
+)
+
+// ErrInvalidLogLevel is returned for the level other than debug, info, warn and error
+var ErrInvalidLogLevel = errors.New("invalid log level, it should be debug, info, warn or error")
+
+// LogControl holds the level and the sampling of the access log. The level can be overridden
+// at runtime for the limited time, e.g. debug for 10 minutes during the incident
+type LogControl struct {
+	clock  sst.Clock
+	sample uint64
+	count  uint64
+
+	mu       sync.Mutex
+	level    LogLevel
+	override LogLevel
+	until    time.Time
+}
+
+// NewLogControl creates the control of the default level. With the info level only every
+// sample-th successful request is logged, 0 or 1 logs all of them
+func NewLogControl(clock sst.Clock, level LogLevel, sample int) (*LogControl, error) {
+	if !level.valid() {
+		return nil, ErrInvalidLogLevel
+	}
+	if sample < 1 {
+		sample = 1
+	}
+	return &LogControl{clock: clock, level: level, sample: uint64(sample)}, nil
+}
+
+func (l LogLevel) valid() bool {
+	switch l {
+	case LevelDebug, LevelInfo, LevelWarn, LevelError:
+		return true
+	}
+	return false
+}
+
+// Level returns the effective level and the time the override ends, zero if it is not overridden
+func (c *LogControl) Level() (LogLevel, time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.override != "" && c.clock.Now().Before(c.until) {
+		return c.override, c.until
+	}
+	return c.level, time.Time{}
+}
+
+// SetLevel overrides the level for the duration. The zero duration changes the default level
+func (c *LogControl) SetLevel(level LogLevel, d time.Duration) error {
+	if !level.valid() || d < 0 {
+		return ErrInvalidLogLevel
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if d == 0 {
+		c.level, c.override, c.until = level, "", time.Time{}
+		return nil
+	}
+	c.override, c.until = level, c.clock.Now().Add(d)
+	return nil
+}
+
+// Sample returns the sampling of the successful requests
+func (c *LogControl) Sample() int {
+	return int(c.sample)
+}
+
+// logs reports whether the request with the status is logged and with the debug details
+func (c *LogControl) logs(status int) (logged, details bool) {
+	level, _ := c.Level()
+	switch level {
+	case LevelDebug:
+		return true, true
+	case LevelWarn:
+		return status >= 400, false
+	case LevelError:
+		return status >= 500, false
+	}
+	if status >= 400 {
+		return true, false
+	}
+	return atomic.AddUint64(&c.count, 1)%c.sample == 0, false
+}
+
+// AccessLogger logs the requests according to the level and the sampling of the control in the text format
+func AccessLogger(logger *log.Logger, c *LogControl) func(http.Handler) http.Handler {
+	return StructuredAccessLogger(logger, c, AccessLogConfig{})
+}
+
+// WithLogControl serves the level of the access log on GET and PUT /admin/log
+func WithLogControl(c *LogControl) Option {
+	return func(a *App) {
+		a.logControl = c
+	}
+}
+
+// LogLevelResponse is the body of GET and PUT /admin/log
+type LogLevelResponse struct {
+	XMLName xml.Name `json:"-" xml:"log"`
+	Level   LogLevel `json:"level" xml:"level"`
+	// Until is the end of the override, the default level is restored afterwards
+	Until  *time.Time `json:"until,omitempty" xml:"until,omitempty"`
+	Sample int        `json:"sample" xml:"sample"`
+}
+
+func (a *App) logLevelResponse(w http.ResponseWriter, r *http.Request) {
+	level, until := a.logControl.Level()
+	resp := LogLevelResponse{Level: level, Sample: a.logControl.Sample()}
+	if !until.IsZero() {
+		resp.Until = &until
+	}
+	a.dataResponse(resp, w, r)
+}
+
+func (a *App) adminGetLogHandler(w http.ResponseWriter, r *http.Request) {
+	if a.logControl == nil {
+		http.Error(w, "Log control is not configured", http.StatusNotImplemented)
+		return
+	}
+	a.logLevelResponse(w, r)
+}
+
+// adminPutLogHandler changes the level with the form values level and duration, e.g. level=debug&duration=10m.
+// Without the duration the default level is changed
+func (a *App) adminPutLogHandler(w http.ResponseWriter, r *http.Request) {
+	if a.logControl == nil {
+		http.Error(w, "Log control is not configured", http.StatusNotImplemented)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		a.bodyError(w, r, err, "Invalid input", http.StatusBadRequest)
+		return
+	}
+	var d time.Duration
+	if duration := r.FormValue("duration"); duration != "" {
+		var err error
+		if d, err = time.ParseDuration(duration); err != nil || d <= 0 {
+			http.Error(w, "Invalid duration", http.StatusBadRequest)
+			return
+		}
+	}
+	level := LogLevel(r.FormValue("level"))
+	if err := a.logControl.SetLevel(level, d); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if d == 0 {
+		log.Printf("admin: default log level is %s", level)
+	} else {
+		log.Printf("admin: log level is %s for %s", level, d)
+	}
+	a.adminHook(r.Context(), AdminEvent{Action: AdminLogLevel, Target: string(level), Detail: r.FormValue("duration")})
+	a.logLevelResponse(w, r)
+}
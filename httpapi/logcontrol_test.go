@@ -0,0 +1,203 @@
+package httpapi_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	sst "github.com/evsan/secret-server-task"
+	"github.com/evsan/secret-server-task/httpapi"
+)
+
+func TestAccessLogger(t *testing.T) {
+	clock := sst.NewManualClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	control, err := httpapi.NewLogControl(clock, httpapi.LevelInfo, 3)
+	if err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+	var buf bytes.Buffer
+	handler := httpapi.AccessLogger(log.New(&buf, "", 0), control)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+		}
+	}))
+	count := func(path string, requests int) int {
+		buf.Reset()
+		for i := 0; i < requests; i++ {
+			req := httptest.NewRequest(http.MethodGet, path, nil)
+			req.Header.Set("User-Agent", "incident-probe")
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+		}
+		return strings.Count(buf.String(), "\n")
+	}
+
+	if n := count("/ok", 6); n != 2 {
+		t.Fatalf("expected every 3rd request to be logged: %d, result: %d", 2, n)
+	}
+	if n := count("/missing", 2); n != 2 {
+		t.Fatalf("expected the failed requests not to be sampled: %d, result: %d", 2, n)
+	}
+
+	if err = control.SetLevel(httpapi.LevelWarn, 0); err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+	if n := count("/ok", 3); n != 0 {
+		t.Fatalf("expected: %d, result: %d", 0, n)
+	}
+
+	if err = control.SetLevel(httpapi.LevelDebug, 10*time.Minute); err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+	if n := count("/ok", 3); n != 3 || !strings.Contains(buf.String(), "incident-probe") {
+		t.Fatalf("expected all requests with the details: %d, result: %q", 3, buf.String())
+	}
+
+	// The override ends, the default level is restored
+	clock.Add(11 * time.Minute)
+	if level, until := control.Level(); level != httpapi.LevelWarn || !until.IsZero() {
+		t.Fatalf("expected: %s, result: %s until %s", httpapi.LevelWarn, level, until)
+	}
+	if n := count("/ok", 3); n != 0 {
+		t.Fatalf("expected: %d, result: %d", 0, n)
+	}
+
+	if err = control.SetLevel("verbose", time.Minute); err != httpapi.ErrInvalidLogLevel {
+		t.Fatalf("expected: %v, result: %v", httpapi.ErrInvalidLogLevel, err)
+	}
+}
+
+func TestAdminLog(t *testing.T) {
+	control, err := httpapi.NewLogControl(sst.SystemClock, httpapi.LevelInfo, 10)
+	if err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+	admin := httpapi.NewAdmin(sst.NewMemStorage(), httpapi.WithMetrics(nil), httpapi.WithLogControl(control))
+
+	put := func(handler http.Handler, form url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPut, "/admin/log", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Accept", "application/json")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	testCases := map[string]struct {
+		form     url.Values
+		expected int
+	}{
+		"invalid level":    {url.Values{"level": {"verbose"}, "duration": {"10m"}}, http.StatusBadRequest},
+		"invalid duration": {url.Values{"level": {"debug"}, "duration": {"soon"}}, http.StatusBadRequest},
+		"negative":         {url.Values{"level": {"debug"}, "duration": {"-1m"}}, http.StatusBadRequest},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			if w := put(admin, tc.form); w.Code != tc.expected {
+				t.Fatalf("expected: %d, result: %d", tc.expected, w.Code)
+			}
+		})
+	}
+
+	w := put(admin, url.Values{"level": {"debug"}, "duration": {"10m"}})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected: %d, result: %d", http.StatusOK, w.Code)
+	}
+	var resp httpapi.LogLevelResponse
+	if err = json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+	if resp.Level != httpapi.LevelDebug || resp.Until == nil || resp.Sample != 10 {
+		t.Fatalf("unexpected response: %s", w.Body.String())
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/log", nil)
+	req.Header.Set("Accept", "application/json")
+	w = httptest.NewRecorder()
+	admin.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"level":"debug"`) {
+		t.Fatalf("unexpected response: %d %s", w.Code, w.Body.String())
+	}
+
+	disabled := httpapi.NewAdmin(sst.NewMemStorage(), httpapi.WithMetrics(nil))
+	if w = put(disabled, url.Values{"level": {"debug"}}); w.Code != http.StatusNotImplemented {
+		t.Fatalf("expected: %d, result: %d", http.StatusNotImplemented, w.Code)
+	}
+}
+
+func TestStructuredAccessLogger(t *testing.T) {
+	control, err := httpapi.NewLogControl(sst.SystemClock, httpapi.LevelInfo, 1)
+	if err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+	proxies, err := httpapi.ParseTrustedProxies("10.0.0.0/8, 192.0.2.1")
+	if err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+	storage := sst.NewMemStorage()
+	secret, _ := storage.Store("text", 1, 0)
+
+	testCases := map[string]struct {
+		remoteAddr string
+		forwarded  string
+		clientIP   string
+	}{
+		"direct":          {"203.0.113.5:1234", "198.51.100.1", "203.0.113.5"},
+		"trusted proxy":   {"10.1.2.3:1234", "198.51.100.1, 192.0.2.1", "198.51.100.1"},
+		"spoofed chain":   {"10.1.2.3:1234", "198.51.100.7, 198.51.100.1", "198.51.100.1"},
+		"invalid hop":     {"10.1.2.3:1234", "unknown", "10.1.2.3"},
+		"only trusted":    {"192.0.2.1:1234", "10.0.0.1", "10.0.0.1"},
+		"missing forward": {"10.1.2.3:1234", "", "10.1.2.3"},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			var buf bytes.Buffer
+			logger := httpapi.StructuredAccessLogger(log.New(&buf, "", 0), control, httpapi.AccessLogConfig{Format: httpapi.AccessLogJSON, TrustedProxies: proxies})
+			h := httpapi.New(storage, httpapi.WithMetrics(nil), httpapi.WithMiddleware(logger))
+			req := httptest.NewRequest(http.MethodGet, "/secret/"+secret.Hash, nil)
+			req.RemoteAddr = tc.remoteAddr
+			req.Header.Set("Accept", "application/json")
+			req.Header.Set("User-Agent", "probe")
+			if tc.forwarded != "" {
+				req.Header.Set("X-Forwarded-For", tc.forwarded)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+
+			var entry struct {
+				Route     string `json:"route"`
+				Status    int    `json:"status"`
+				Bytes     int    `json:"bytes"`
+				ClientIP  string `json:"clientIp"`
+				UserAgent string `json:"userAgent"`
+			}
+			if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+				t.Fatal("error is not expected: ", err)
+			}
+			if entry.Route != "GET /secret/{hash}" || entry.Status != w.Code || entry.Bytes != w.Body.Len() || entry.UserAgent != "probe" {
+				t.Fatalf("expected: the route, the status and the size of the response, result: %s", buf.String())
+			}
+			if entry.ClientIP != tc.clientIP {
+				t.Fatalf("expected: %s, result: %s", tc.clientIP, entry.ClientIP)
+			}
+		})
+	}
+
+	var buf bytes.Buffer
+	logger := httpapi.StructuredAccessLogger(log.New(&buf, "", 0), control, httpapi.AccessLogConfig{Format: httpapi.AccessLogCLF})
+	handler := logger(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("hello"))
+	}))
+	req := httptest.NewRequest(http.MethodGet, "/ping?x=1", nil)
+	req.Header.Set("Referer", "https://chat.example.com/secret-link")
+	req.Header.Set("User-Agent", "probe")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	if line := buf.String(); !strings.HasPrefix(line, "192.0.2.1 - - [") || !strings.Contains(line, `] "GET /ping?x=1 HTTP/1.1" 200 5 "-" "probe" `) {
+		t.Fatalf("expected: the combined log format line, result: %s", line)
+	}
+}
@@ -0,0 +1,136 @@
+package httpapi
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/evsan/secret-server-task/openmetrics"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type Metrics struct {
+	secretGetCounter   prometheus.Counter
+	secretPostCounter  prometheus.Counter
+	secretGetDuration  prometheus.Summary
+	secretPostDuration prometheus.Summary
+	// The histograms of the durations carry the exemplars, the summaries are kept for the existing dashboards
+	secretGetSeconds  prometheus.Histogram
+	secretPostSeconds prometheus.Histogram
+	// secretUnavailable counts the retrievals of the not available secrets by the reason
+	secretUnavailable *prometheus.CounterVec
+	// rateLimitExemptions counts the creations exempt from the rate limit by the reason
+	rateLimitExemptions *prometheus.CounterVec
+	// cacheableResponses counts the secret responses found cacheable by the audit, nil if it is disabled
+	cacheableResponses *prometheus.CounterVec
+}
+
+func (a *App) initMetrics() {
+	a.metrics.secretGetCounter = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "secret_get_requests_total",
+		Help: "The total number of GET /secret/{hash} requests",
+	})
+
+	a.metrics.secretPostCounter = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "secret_post_requests_total",
+		Help: "The total number of POST /secret requests",
+	})
+
+	a.metrics.secretPostDuration = prometheus.NewSummary(prometheus.SummaryOpts{
+		Name:       "secret_post_request_duration",
+		Help:       "Histogram for the POST /secret response time",
+		Objectives: map[float64]float64{0.5: 0.1, 0.9: 0.01, 0.99: 0.001},
+	})
+
+	a.metrics.secretGetDuration = prometheus.NewSummary(prometheus.SummaryOpts{
+		Name:       "secret_get_request_duration",
+		Help:       "Histogram for the GET /secret/{hash} response time",
+		Objectives: map[float64]float64{0.5: 0.1, 0.9: 0.01, 0.99: 0.001},
+	})
+
+	a.metrics.secretPostSeconds = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "secret_post_request_duration_seconds",
+		Help:    "Histogram for the POST /secret response time in seconds",
+		Buckets: prometheus.DefBuckets,
+	})
+
+	a.metrics.secretGetSeconds = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "secret_get_request_duration_seconds",
+		Help:    "Histogram for the GET /secret/{hash} response time in seconds",
+		Buckets: prometheus.DefBuckets,
+	})
+
+	a.metrics.secretUnavailable = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "secret_get_unavailable_total",
+		Help: "The total number of retrievals of not available secrets by the reason: not_found, expired, consumed, malformed, unknown or error",
+	}, []string{"reason"})
+
+	a.metrics.rateLimitExemptions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "secret_rate_limit_exemptions_total",
+		Help: "The total number of the creations exempt from the rate limit by the reason: api_key or network",
+	}, []string{"reason"})
+
+	a.metrics.secretGetCounter = a.register(a.metrics.secretGetCounter).(prometheus.Counter)
+	a.metrics.secretPostCounter = a.register(a.metrics.secretPostCounter).(prometheus.Counter)
+	a.metrics.secretPostDuration = a.register(a.metrics.secretPostDuration).(prometheus.Summary)
+	a.metrics.secretGetDuration = a.register(a.metrics.secretGetDuration).(prometheus.Summary)
+	a.metrics.secretPostSeconds = a.register(a.metrics.secretPostSeconds).(prometheus.Histogram)
+	a.metrics.secretGetSeconds = a.register(a.metrics.secretGetSeconds).(prometheus.Histogram)
+	a.metrics.secretUnavailable = a.register(a.metrics.secretUnavailable).(*prometheus.CounterVec)
+	a.metrics.rateLimitExemptions = a.register(a.metrics.rateLimitExemptions).(*prometheus.CounterVec)
+	if a.cacheAudit != nil {
+		a.metrics.cacheableResponses = a.register(prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "secret_cacheable_responses_total",
+			Help: "The total number of the secret responses without the headers forbidding the caching by the route",
+		}, []string{"route"})).(*prometheus.CounterVec)
+	}
+	if a.writeQueue != nil {
+		for _, c := range a.writeQueue.collectors() {
+			a.register(c)
+		}
+	}
+}
+
+// WithExemplars records the trace id of the traced requests (W3C traceparent) as the exemplars
+// of the request counters and the duration histograms, they are served by openmetrics.Handler
+func WithExemplars(e *openmetrics.Exemplars) Option {
+	return func(a *App) {
+		a.exemplars = e
+	}
+}
+
+// exemplar records the request counted by the counter if it is traced
+func (a *App) exemplar(counter string, r *http.Request) {
+	if a.exemplars != nil {
+		a.exemplars.Record(counter, nil, 1, openmetrics.TraceID(r))
+	}
+}
+
+// observeDuration returns the function observing the duration of the request since the call
+// by the summary and the histogram, the histogram gets the exemplar of the traced request
+func (a *App) observeDuration(summary prometheus.Summary, histogram prometheus.Histogram, name string, r *http.Request) func() {
+	start := time.Now()
+	return func() {
+		seconds := time.Since(start).Seconds()
+		summary.Observe(seconds)
+		histogram.Observe(seconds)
+		if a.exemplars != nil {
+			a.exemplars.Record(name, nil, seconds, openmetrics.TraceID(r))
+		}
+	}
+}
+
+// register registers the collector with the configured registerer.
+// If the same metric is already registered (New is called several times) the existing collector
+// is returned, so the handlers share the counters instead of panicking.
+func (a *App) register(c prometheus.Collector) prometheus.Collector {
+	if a.registerer == nil {
+		return c
+	}
+	if err := a.registerer.Register(c); err != nil {
+		if are, ok := err.(prometheus.AlreadyRegisteredError); ok {
+			return are.ExistingCollector
+		}
+		panic(err)
+	}
+	return c
+}
@@ -0,0 +1,115 @@
+package httpapi
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"runtime/debug"
+	"strconv"
+	"time"
+)
+
+// Recovery responds with 500 Internal Server Error if the handler panics and logs the stack trace.
+// The stack trace is written to the response as well if printStack is set, it must be used for debugging only
+func Recovery(logger *log.Logger, printStack bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer func() {
+				if err := recover(); err != nil {
+					if err == http.ErrAbortHandler {
+						panic(err)
+					}
+					stack := debug.Stack()
+					logger.Printf("PANIC: %s\n%s", err, stack)
+
+					w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+					w.WriteHeader(http.StatusInternalServerError)
+					if printStack {
+						_, _ = w.Write(stack)
+					}
+				}
+			}()
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequestTimeout sets the deadline of the request context from the X-Request-Timeout or Request-Timeout header:
+// the seconds (e.g. 2.5) or the duration (e.g. 1500ms), limited by max. The work waiting for the storage,
+// e.g. the creation in the write queue, gives up at the deadline. The requests without the header have no deadline
+func RequestTimeout(max time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			value := r.Header.Get("X-Request-Timeout")
+			if value == "" {
+				value = r.Header.Get("Request-Timeout")
+			}
+			if value == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			timeout, ok := parseTimeout(value)
+			if !ok {
+				http.Error(w, "Invalid request timeout, it should be the seconds or the duration, e.g. 1500ms", http.StatusBadRequest)
+				return
+			}
+			if timeout > max {
+				timeout = max
+			}
+			ctx, cancel := context.WithTimeout(r.Context(), timeout)
+			defer cancel()
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// parseTimeout parses the positive seconds or duration
+func parseTimeout(value string) (time.Duration, bool) {
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		return time.Duration(seconds * float64(time.Second)), seconds > 0 && seconds < 1e9
+	}
+	d, err := time.ParseDuration(value)
+	return d, err == nil && d > 0
+}
+
+// Logger logs the method, the path, the status and the duration of every request
+func Logger(logger *log.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(sw, r)
+			logger.Printf("%d | %v | %s | %s %s", sw.status, time.Since(start), r.Host, r.Method, r.URL.Path)
+		})
+	}
+}
+
+// statusWriter remembers the status code and the amount of the bytes written by the handler
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// Flush keeps streaming responses working through the wrapper
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap allows http.ResponseController to reach the underlying writer
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,344 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	sst "github.com/evsan/secret-server-task"
+)
+
+// PolicyConfig is the format of the -policyFile
+type PolicyConfig struct {
+	Default sst.Policy                    `json:"default"`
+	Tenants map[string]sst.PolicyOverride `json:"tenants"`
+	// EnforceMaxExpireAfterViews caps expireAfterViews of all tenants, the overrides can't relax it.
+	// 1 guarantees burn-after-reading
+	EnforceMaxExpireAfterViews int `json:"enforceMaxExpireAfterViews,omitempty"`
+	// Templates are the presets selected with the template field at creation
+	Templates map[string]sst.Template `json:"templates,omitempty"`
+}
+
+// LoadPolicyConfig reads the policy file
+func LoadPolicyConfig(path string) (PolicyConfig, error) {
+	var cfg PolicyConfig
+	f, err := os.Open(path)
+	if err != nil {
+		return cfg, err
+	}
+	defer f.Close()
+
+	if err = json.NewDecoder(f).Decode(&cfg); err != nil {
+		return cfg, err
+	}
+	for tenant := range cfg.Tenants {
+		if err = sst.ValidateTenant(tenant); err != nil {
+			return cfg, err
+		}
+	}
+	for name, t := range cfg.Templates {
+		if err = sst.ValidateTemplate(name, t); err != nil {
+			return cfg, err
+		}
+	}
+	return cfg, nil
+}
+
+// Policies holds the default policy with the tenant overrides, the templates and the rate limiters.
+// The overrides and the templates can be changed at runtime via the admin API.
+type Policies struct {
+	mu       sync.RWMutex
+	config   PolicyConfig
+	limiters map[string]*rateLimiter
+	// quotas counts the creations of the day by the tenant and the owner
+	quotaMu sync.Mutex
+	quotas  map[quotaKey]*quotaCounter
+}
+
+// NewPolicies creates the policies from the config
+func NewPolicies(cfg PolicyConfig) *Policies {
+	if cfg.Tenants == nil {
+		cfg.Tenants = map[string]sst.PolicyOverride{}
+	}
+	if cfg.Templates == nil {
+		cfg.Templates = map[string]sst.Template{}
+	}
+	return &Policies{config: cfg, limiters: map[string]*rateLimiter{}, quotas: map[quotaKey]*quotaCounter{}}
+}
+
+// For returns the effective policy of the tenant
+func (p *Policies) For(tenant string) sst.Policy {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	policy := p.config.Default
+	if o, ok := p.config.Tenants[tenant]; ok {
+		policy = o.Apply(policy)
+	}
+	return policy.Enforce(p.config.EnforceMaxExpireAfterViews)
+}
+
+// SetTenant replaces the override of the tenant
+func (p *Policies) SetTenant(tenant string, o sst.PolicyOverride) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.config.Tenants[tenant] = o
+	delete(p.limiters, tenant)
+}
+
+// DeleteTenant removes the override of the tenant, the default policy applies afterwards
+func (p *Policies) DeleteTenant(tenant string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.config.Tenants, tenant)
+	delete(p.limiters, tenant)
+}
+
+// Template returns the template by name. Empty name is the empty template
+func (p *Policies) Template(name string) (sst.Template, error) {
+	if name == "" {
+		return sst.Template{}, nil
+	}
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	t, ok := p.config.Templates[name]
+	if !ok {
+		return t, sst.ErrUnknownTemplate
+	}
+	return t, nil
+}
+
+// SetTemplate adds or replaces the template
+func (p *Policies) SetTemplate(name string, t sst.Template) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.config.Templates[name] = t
+}
+
+// DeleteTemplate removes the template
+func (p *Policies) DeleteTemplate(name string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.config.Templates, name)
+}
+
+// Config returns the copy of the current policy config
+func (p *Policies) Config() PolicyConfig {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	cfg := PolicyConfig{
+		Default:                    p.config.Default,
+		Tenants:                    make(map[string]sst.PolicyOverride, len(p.config.Tenants)),
+		EnforceMaxExpireAfterViews: p.config.EnforceMaxExpireAfterViews,
+		Templates:                  make(map[string]sst.Template, len(p.config.Templates)),
+	}
+	for k, v := range p.config.Tenants {
+		cfg.Tenants[k] = v
+	}
+	for k, v := range p.config.Templates {
+		cfg.Templates[k] = v
+	}
+	return cfg
+}
+
+// Allow takes a token from the rate limiter of the tenant
+func (p *Policies) Allow(tenant string) bool {
+	_, ok := p.Take(tenant)
+	return ok
+}
+
+// RateLimitStatus is the state of the rate limiter reported to the clients
+type RateLimitStatus struct {
+	// Limit is the size of the bucket, the amount of requests which can be made at once
+	Limit int
+	// Remaining is the amount of requests which can be made now
+	Remaining int
+	// Reset is the time until the bucket is full again
+	Reset time.Duration
+	// RetryAfter is the time until the next request is allowed, zero if it is allowed now
+	RetryAfter time.Duration
+}
+
+// Take takes a token from the rate limiter of the tenant and returns the status of the limiter.
+// The status is nil if the tenant is not rate limited
+func (p *Policies) Take(tenant string) (*RateLimitStatus, bool) {
+	l := p.limiter(tenant)
+	if l == nil {
+		return nil, true
+	}
+	return l.Take(time.Now())
+}
+
+// RateLimit returns the status of the rate limiter of the tenant without taking a token,
+// nil if the tenant is not rate limited
+func (p *Policies) RateLimit(tenant string) *RateLimitStatus {
+	l := p.limiter(tenant)
+	if l == nil {
+		return nil
+	}
+	return l.Status(time.Now())
+}
+
+// limiter returns the rate limiter of the tenant, nil if it is not rate limited
+func (p *Policies) limiter(tenant string) *rateLimiter {
+	policy := p.For(tenant)
+	if policy.RatePerMinute <= 0 {
+		return nil
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	l, ok := p.limiters[tenant]
+	if !ok {
+		l = newRateLimiter(policy.RatePerMinute, policy.RateBurst)
+		p.limiters[tenant] = l
+	}
+	return l
+}
+
+// rateLimiter is the token bucket refilled with perMinute tokens per minute
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64 // tokens per second
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(perMinute, burst int) *rateLimiter {
+	if burst <= 0 {
+		burst = perMinute
+	}
+	return &rateLimiter{rate: float64(perMinute) / 60, burst: float64(burst), tokens: float64(burst)}
+}
+
+func (l *rateLimiter) Allow(now time.Time) bool {
+	_, ok := l.Take(now)
+	return ok
+}
+
+// Take takes a token if there is any and returns the state after that
+func (l *rateLimiter) Take(now time.Time) (*RateLimitStatus, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.refill(now)
+	ok := l.tokens >= 1
+	if ok {
+		l.tokens--
+	}
+	status := &RateLimitStatus{
+		Limit:     int(l.burst),
+		Remaining: int(l.tokens),
+		Reset:     l.wait(l.burst),
+	}
+	if !ok {
+		status.RetryAfter = l.wait(1)
+	}
+	return status, ok
+}
+
+// Status returns the state of the bucket at now without taking a token
+func (l *rateLimiter) Status(now time.Time) *RateLimitStatus {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.refill(now)
+	status := &RateLimitStatus{
+		Limit:     int(l.burst),
+		Remaining: int(l.tokens),
+		Reset:     l.wait(l.burst),
+	}
+	if l.tokens < 1 {
+		status.RetryAfter = l.wait(1)
+	}
+	return status
+}
+
+// refill adds the tokens accumulated since the last call
+func (l *rateLimiter) refill(now time.Time) {
+	if !l.last.IsZero() {
+		l.tokens += now.Sub(l.last).Seconds() * l.rate
+		if l.tokens > l.burst {
+			l.tokens = l.burst
+		}
+	}
+	l.last = now
+}
+
+// wait returns the time until the bucket has the tokens
+func (l *rateLimiter) wait(tokens float64) time.Duration {
+	if l.tokens >= tokens {
+		return 0
+	}
+	return time.Duration((tokens - l.tokens) / l.rate * float64(time.Second))
+}
+
+func (a *App) adminGetPoliciesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(a.policies.Config())
+}
+
+func (a *App) adminPutTenantPolicyHandler(w http.ResponseWriter, r *http.Request) {
+	tenant := r.PathValue("tenant")
+	if err := sst.ValidateTenant(tenant); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var o sst.PolicyOverride
+	if err := json.NewDecoder(r.Body).Decode(&o); err != nil {
+		a.bodyError(w, r, err, "Invalid policy: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	a.policies.SetTenant(tenant, o)
+	a.adminHook(r.Context(), AdminEvent{Action: AdminPolicy, Target: tenant, Detail: "set"})
+	a.adminGetPoliciesHandler(w, r)
+}
+
+func (a *App) adminDeleteTenantPolicyHandler(w http.ResponseWriter, r *http.Request) {
+	a.policies.DeleteTenant(r.PathValue("tenant"))
+	a.adminHook(r.Context(), AdminEvent{Action: AdminPolicy, Target: r.PathValue("tenant"), Detail: "deleted"})
+	a.adminGetPoliciesHandler(w, r)
+}
+
+func (a *App) adminPutTemplateHandler(w http.ResponseWriter, r *http.Request) {
+	var t sst.Template
+	if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
+		a.bodyError(w, r, err, "Invalid template: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	name := r.PathValue("name")
+	if err := sst.ValidateTemplate(name, t); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	a.policies.SetTemplate(name, t)
+	a.adminHook(r.Context(), AdminEvent{Action: AdminTemplate, Target: name, Detail: "set"})
+	a.adminGetPoliciesHandler(w, r)
+}
+
+func (a *App) adminDeleteTemplateHandler(w http.ResponseWriter, r *http.Request) {
+	a.policies.DeleteTemplate(r.PathValue("name"))
+	a.adminHook(r.Context(), AdminEvent{Action: AdminTemplate, Target: r.PathValue("name"), Detail: "deleted"})
+	a.adminGetPoliciesHandler(w, r)
+}
+
+// setHeaders writes the RateLimit-* headers and Retry-After if the request is throttled.
+// The values are in seconds rounded up, so the client never retries too early
+func (s *RateLimitStatus) setHeaders(h http.Header) {
+	if s == nil {
+		return
+	}
+	h.Set("RateLimit-Limit", strconv.Itoa(s.Limit))
+	h.Set("RateLimit-Remaining", strconv.Itoa(s.Remaining))
+	h.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(s.Reset)))
+	if s.RetryAfter > 0 {
+		h.Set("Retry-After", strconv.Itoa(ceilSeconds(s.RetryAfter)))
+	}
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int((d + time.Second - 1) / time.Second)
+}
@@ -0,0 +1,86 @@
+package httpapi
+
+import (
+	"context"
+	"log"
+	"net"
+	"net/http"
+	"time"
+)
+
+// The actions of PolicyInput
+const (
+	// PolicyCreate is evaluated before the secret is stored
+	PolicyCreate = "create"
+	// PolicyRetrieve is evaluated before the view of the secret is consumed
+	PolicyRetrieve = "retrieve"
+)
+
+// PolicyInput is the metadata of the request evaluated by the policy of the organization, the secret text is never passed
+type PolicyInput struct {
+	Action string `json:"action"`
+	Tenant string `json:"tenant"`
+	// Owner is the owner of the api key of the request, empty for the anonymous requests
+	Owner string `json:"owner"`
+	// ClientIP is the address of the client, X-Forwarded-For is read from the trusted proxies only
+	ClientIP  string    `json:"clientIp"`
+	UserAgent string    `json:"userAgent"`
+	Time      time.Time `json:"time"`
+	// Hash is the secret retrieved by the hash, Link the one retrieved by the share link
+	Hash string `json:"hash,omitempty"`
+	Link string `json:"link,omitempty"`
+	// ExpireAfter (minutes, 0 never expires), ExpireAfterViews and Size (bytes of the text) describe the created secret
+	ExpireAfter      int    `json:"expireAfter"`
+	ExpireAfterViews int    `json:"expireAfterViews"`
+	Size             int    `json:"size"`
+	Template         string `json:"template,omitempty"`
+}
+
+// PolicyDecision is the result of the evaluation
+type PolicyDecision struct {
+	Allow bool `json:"allow"`
+	// Reason is sent to the client of the refused request
+	Reason string `json:"reason,omitempty"`
+}
+
+// PolicyEvaluator evaluates the policy of the organization, e.g. opa.Client.
+// The error refuses the request with 503, so the policy is never bypassed when it can't be evaluated
+type PolicyEvaluator interface {
+	Evaluate(ctx context.Context, input PolicyInput) (PolicyDecision, error)
+}
+
+// WithPolicyEvaluator evaluates the policy on the creation and the retrieval of the secrets.
+// X-Forwarded-For of the trusted proxies is the client address of the input
+func WithPolicyEvaluator(e PolicyEvaluator, trustedProxies ...*net.IPNet) Option {
+	return func(a *App) {
+		a.policyEvaluator = e
+		a.policyProxies = trustedProxies
+	}
+}
+
+// allowedByPolicy evaluates the policy with the metadata of the request. The refused request is answered with 403
+func (a *App) allowedByPolicy(input PolicyInput, w http.ResponseWriter, r *http.Request) bool {
+	if a.policyEvaluator == nil {
+		return true
+	}
+	input.Owner = requestOwner(r)
+	input.ClientIP = clientIP(r, a.policyProxies)
+	input.UserAgent = r.UserAgent()
+	input.Time = time.Now().UTC()
+	decision, err := a.policyEvaluator.Evaluate(r.Context(), input)
+	if err != nil {
+		log.Println("policy: ", err)
+		w.Header().Set("Retry-After", backendRetryAfter)
+		http.Error(w, "Policy can't be evaluated, try again later", http.StatusServiceUnavailable)
+		return false
+	}
+	if !decision.Allow {
+		message := "Refused by the policy"
+		if decision.Reason != "" {
+			message += ": " + decision.Reason
+		}
+		http.Error(w, message, http.StatusForbidden)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,102 @@
+package httpapi_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	sst "github.com/evsan/secret-server-task"
+	"github.com/evsan/secret-server-task/httpapi"
+)
+
+// corporatePolicy allows the secrets expiring within 30 days and the retrievals from 10.0.0.0/8
+type corporatePolicy struct {
+	inputs []httpapi.PolicyInput
+	err    error
+}
+
+func (p *corporatePolicy) Evaluate(_ context.Context, input httpapi.PolicyInput) (httpapi.PolicyDecision, error) {
+	p.inputs = append(p.inputs, input)
+	if p.err != nil {
+		return httpapi.PolicyDecision{}, p.err
+	}
+	switch {
+	case input.Action == httpapi.PolicyCreate && (input.ExpireAfter == 0 || input.ExpireAfter > 30*24*60):
+		return httpapi.PolicyDecision{Reason: "no secrets over 30 days"}, nil
+	case input.Action == httpapi.PolicyRetrieve && !strings.HasPrefix(input.ClientIP, "10."):
+		return httpapi.PolicyDecision{}, nil
+	}
+	return httpapi.PolicyDecision{Allow: true}, nil
+}
+
+func TestWithPolicyEvaluator(t *testing.T) {
+	policy := &corporatePolicy{}
+	_, proxy, _ := net.ParseCIDR("192.0.2.1/32")
+	h := httpapi.New(sst.NewMemStorage(), httpapi.WithMetrics(nil), httpapi.WithPolicyEvaluator(policy, proxy))
+	post := func(expireAfter string) *httptest.ResponseRecorder {
+		form := url.Values{"secret": {"test secret"}, "expireAfterViews": {"5"}, "expireAfter": {expireAfter}}
+		req := httptest.NewRequest(http.MethodPost, "/secret", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Accept", "application/json")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	for expireAfter, expected := range map[string]int{"0": http.StatusForbidden, "50000": http.StatusForbidden, "60": http.StatusOK} {
+		if w := post(expireAfter); w.Code != expected {
+			t.Fatalf("expireAfter %s: expected: %d, result: %d", expireAfter, expected, w.Code)
+		}
+	}
+	if w := post("0"); !strings.Contains(w.Body.String(), "no secrets over 30 days") {
+		t.Fatalf("reason is expected, result: %s", w.Body.String())
+	}
+	created := policy.inputs[len(policy.inputs)-1]
+	if created.Action != httpapi.PolicyCreate || created.Size != len("test secret") || created.ExpireAfterViews != 5 {
+		t.Fatalf("unexpected input: %+v", created)
+	}
+
+	w := post("60")
+	hash := strings.Split(w.Header().Get("Content-Location"), "/secret/")[1]
+	testCases := map[string]struct {
+		remoteAddr string
+		forwarded  string
+		expected   int
+	}{
+		"corporate":           {"10.1.2.3:1234", "", http.StatusOK},
+		"outside":             {"203.0.113.5:1234", "", http.StatusForbidden},
+		"via trusted proxy":   {"192.0.2.1:1234", "10.4.5.6", http.StatusOK},
+		"via untrusted proxy": {"203.0.113.5:1234", "10.4.5.6", http.StatusForbidden},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/secret/"+hash, nil)
+			req.Header.Set("Accept", "application/json")
+			req.RemoteAddr = tc.remoteAddr
+			if tc.forwarded != "" {
+				req.Header.Set("X-Forwarded-For", tc.forwarded)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+			if w.Code != tc.expected {
+				t.Fatalf("expected: %d, result: %d", tc.expected, w.Code)
+			}
+		})
+	}
+	retrieved := policy.inputs[len(policy.inputs)-1]
+	if retrieved.Action != httpapi.PolicyRetrieve || retrieved.Hash != hash {
+		t.Fatalf("unexpected input: %+v", retrieved)
+	}
+
+	// The policy which can't be evaluated refuses everything
+	policy.err = errors.New("connection refused")
+	w = post("60")
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") == "" {
+		t.Fatalf("expected: %d, result: %d", http.StatusServiceUnavailable, w.Code)
+	}
+}
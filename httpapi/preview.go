@@ -0,0 +1,98 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	sst "github.com/evsan/secret-server-task"
+)
+
+// previewShown is the amount of the characters shown at each end of the preview
+const previewShown = 2
+
+// previewMinLength is the shortest text which is previewed with its ends, the shorter ones are masked entirely,
+// so the preview never shows more than the half of the text
+const previewMinLength = 4 * previewShown
+
+// PreviewResponse is the body of GET /secret/{hash}/preview
+type PreviewResponse struct {
+	Hash string `json:"hash" xml:"hash"`
+	// Preview is the text with all but the first and the last 2 characters masked, e.g. "ab****yz"
+	Preview string `json:"preview" xml:"preview"`
+	// Length is the amount of the characters of the text
+	Length int `json:"length" xml:"length"`
+	// ContentType is the detected media type of the text, e.g. application/json or application/x-pem-file
+	ContentType    string    `json:"contentType" xml:"contentType"`
+	ExpiresAt      time.Time `json:"expiresAt" xml:"expiresAt"`
+	RemainingViews int       `json:"remainingViews" xml:"remainingViews"`
+}
+
+// newPreview masks the text of the secret
+func newPreview(secret sst.Secret) PreviewResponse {
+	text := secret.SecretText
+	length := utf8.RuneCountInString(text)
+	preview := strings.Repeat("*", length)
+	if length >= previewMinLength {
+		runes := []rune(text)
+		preview = string(runes[:previewShown]) + strings.Repeat("*", length-2*previewShown) + string(runes[length-previewShown:])
+	}
+	return PreviewResponse{
+		Hash:           secret.Hash,
+		Preview:        preview,
+		Length:         length,
+		ContentType:    detectContentType(text),
+		ExpiresAt:      secret.ExpiresAt,
+		RemainingViews: secret.RemainingViews,
+	}
+}
+
+// detectContentType recognizes the usual kinds of the credentials on top of http.DetectContentType
+func detectContentType(text string) string {
+	trimmed := strings.TrimSpace(text)
+	switch {
+	case strings.HasPrefix(trimmed, "-----BEGIN "):
+		return "application/x-pem-file"
+	case strings.HasPrefix(trimmed, "{") && json.Valid([]byte(trimmed)):
+		return "application/json"
+	}
+	return http.DetectContentType([]byte(text))
+}
+
+// previewHandler returns the masked preview of the secret to its owner identified by the api key,
+// so the sender can check the pasted credential without consuming a view.
+// The secrets of the other owners are reported as not found, so their existence isn't revealed
+func (a *App) previewHandler(w http.ResponseWriter, r *http.Request) {
+	tenant, ok := a.requestTenant(w, r)
+	if !ok {
+		return
+	}
+	p, ok := sst.PeekerOf(sst.NewTenantStorage(a.storage, tenant))
+	if !ok {
+		http.Error(w, "Storage doesn't support the preview", http.StatusNotImplemented)
+		return
+	}
+	key := r.PathValue("hash")
+	if !a.validHash(key) {
+		http.Error(w, "Secret not found", http.StatusNotFound)
+		return
+	}
+	secret, err := p.Peek(key)
+	if errors.Is(err, sst.ErrSecretNotAvailable) || err == sst.ErrSecretNotYetAvailable || (err == nil && secret.Owner != requestOwner(r)) {
+		http.Error(w, "Secret not found", http.StatusNotFound)
+		return
+	}
+	if backendUnavailable(err, w) {
+		return
+	}
+	if err != nil {
+		log.Println("preview: ", err)
+		http.Error(w, "Preview is not available", http.StatusInternalServerError)
+		return
+	}
+	a.metadataResponse(newPreview(secret), secret.CreatedAt, w, r)
+}
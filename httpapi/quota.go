@@ -0,0 +1,195 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Codes of LimitError
+const (
+	// LimitRate means the rate limit of the tenant is exceeded, the response is 429
+	LimitRate = "rate_limited"
+	// LimitQuota means the daily quota of the owner is used up, the response is 402
+	LimitQuota = "quota_exceeded"
+)
+
+// LimitError is the negotiated body of the 402 and 429 responses, so the automated clients back off until Reset
+type LimitError struct {
+	XMLName xml.Name `json:"-" xml:"error"`
+	Code    string   `json:"code" xml:"code"`
+	Message string   `json:"message" xml:"message"`
+	// Current is the amount of the creations counted against the limit
+	Current int `json:"current" xml:"current"`
+	Limit   int `json:"limit" xml:"limit"`
+	// Reset is the time the next creation is allowed
+	Reset time.Time `json:"reset" xml:"reset"`
+}
+
+// QuotaStatus is the consumption of the daily quota of the owner
+type QuotaStatus struct {
+	Limit     int `json:"limit" xml:"limit"`
+	Used      int `json:"used" xml:"used"`
+	Remaining int `json:"remaining" xml:"remaining"`
+	// Reset is the start of the next UTC day, the quota is renewed then
+	Reset time.Time `json:"reset" xml:"reset"`
+}
+
+type quotaKey struct {
+	tenant string
+	owner  string
+}
+
+// quotaCounter is the amount of the creations of the day
+type quotaCounter struct {
+	day  time.Time
+	used int
+}
+
+// TakeQuota counts the creation against the daily quota of the owner in the tenant and returns the status
+// of the quota. The anonymous creations share the quota of the empty owner. The status is nil if there is no quota
+func (p *Policies) TakeQuota(tenant, owner string) (*QuotaStatus, bool) {
+	return p.quota(tenant, owner, time.Now(), true)
+}
+
+// Quota returns the status of the daily quota of the owner in the tenant, nil if there is no quota
+func (p *Policies) Quota(tenant, owner string) *QuotaStatus {
+	status, _ := p.quota(tenant, owner, time.Now(), false)
+	return status
+}
+
+func (p *Policies) quota(tenant, owner string, now time.Time, take bool) (*QuotaStatus, bool) {
+	limit := p.For(tenant).SecretsPerDay
+	if limit <= 0 {
+		return nil, true
+	}
+	day := now.UTC().Truncate(24 * time.Hour)
+
+	p.quotaMu.Lock()
+	defer p.quotaMu.Unlock()
+	key := quotaKey{tenant: tenant, owner: owner}
+	c, ok := p.quotas[key]
+	if !ok || !c.day.Equal(day) {
+		// The counter of the past day is replaced by the first request of the owner in the new day
+		c = &quotaCounter{day: day}
+		p.quotas[key] = c
+	}
+	allowed := c.used < limit
+	if take && allowed {
+		c.used++
+	}
+	return &QuotaStatus{Limit: limit, Used: c.used, Remaining: limit - c.used, Reset: day.Add(24 * time.Hour)}, allowed
+}
+
+// setHeaders writes the Retry-After of the used up quota
+func (s *QuotaStatus) setHeaders(h http.Header, now time.Time) {
+	h.Set("Retry-After", strconv.Itoa(ceilSeconds(s.Reset.Sub(now))))
+}
+
+// limitResponse writes the LimitError in the format of the Accept header, as JSON if it has no structured format
+func (a *App) limitResponse(w http.ResponseWriter, r *http.Request, status int, e LimitError) {
+	m := a.getMarshaler(r.Header.Get("Accept"))
+	if m.ContentType == "" || m.ContentType == rawContentType {
+		m = Marshaler{MarshalFunc: json.Marshal, ContentType: "application/json"}
+	}
+	b, err := m.MarshalFunc(e)
+	if err != nil {
+		http.Error(w, e.Message, status)
+		return
+	}
+	w.Header().Set("Content-Type", m.ContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	_, _ = w.Write(b)
+}
+
+// UsageResponse is the response of GET /v1/usage: the consumption of the API key holder and its limits
+type UsageResponse struct {
+	Tenant string `json:"tenant" xml:"tenant"`
+	Owner  string `json:"owner" xml:"owner"`
+	// Since is the start of the counters, they are kept in memory. Nil if the usage isn't counted
+	Since          *time.Time `json:"since,omitempty" xml:"since,omitempty"`
+	SecretsCreated int64      `json:"secretsCreated" xml:"secretsCreated"`
+	BytesStored    int64      `json:"bytesStored" xml:"bytesStored"`
+	ViewsServed    int64      `json:"viewsServed" xml:"viewsServed"`
+	// Quota is the daily quota of the owner, nil if there is none
+	Quota *QuotaStatus `json:"quota,omitempty" xml:"quota,omitempty"`
+	// RateLimit is the rate limit shared by the tenant, nil if there is none
+	RateLimit *RateLimitUsage `json:"rateLimit,omitempty" xml:"rateLimit,omitempty"`
+}
+
+// RateLimitUsage is the state of the rate limiter of the tenant
+type RateLimitUsage struct {
+	Limit     int `json:"limit" xml:"limit"`
+	Remaining int `json:"remaining" xml:"remaining"`
+	// Reset is the time the bucket is full again
+	Reset time.Time `json:"reset" xml:"reset"`
+}
+
+// usageHandler serves GET /v1/usage, the api key is required
+func (a *App) usageHandler(w http.ResponseWriter, r *http.Request) {
+	key, _ := requestAPIKey(r)
+	resp := UsageResponse{Tenant: key.Tenant, Owner: key.Owner, Quota: a.policies.Quota(key.Tenant, key.Owner)}
+	if usage := usageStorage(a.storage); usage != nil {
+		since := usage.Since()
+		u := usage.UsageOf(key.Tenant, key.Owner)
+		resp.Since, resp.SecretsCreated, resp.BytesStored, resp.ViewsServed = &since, u.SecretsCreated, u.BytesStored, u.ViewsServed
+	}
+	if status := a.policies.RateLimit(key.Tenant); status != nil {
+		resp.RateLimit = &RateLimitUsage{Limit: status.Limit, Remaining: status.Remaining, Reset: time.Now().Add(status.Reset)}
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	a.dataResponse(resp, w, r)
+}
+
+// rateLimitError returns the LimitError of the exceeded rate limit
+func rateLimitError(s *RateLimitStatus, now time.Time) LimitError {
+	return LimitError{
+		Code:    LimitRate,
+		Message: "Too many requests",
+		Current: s.Limit - s.Remaining,
+		Limit:   s.Limit,
+		Reset:   now.Add(s.RetryAfter),
+	}
+}
+
+// quotaError returns the LimitError of the used up quota
+func quotaError(s *QuotaStatus) LimitError {
+	return LimitError{
+		Code:    LimitQuota,
+		Message: "Daily quota of the secrets is used up",
+		Current: s.Used,
+		Limit:   s.Limit,
+		Reset:   s.Reset,
+	}
+}
+
+// Reasons of the rate limit exemptions
+const (
+	exemptAPIKey  = "api_key"
+	exemptNetwork = "network"
+)
+
+// WithRateLimitExemption exempts the creations from the client networks, e.g. of the internal CI systems,
+// from the rate limit of the tenants. X-Forwarded-For of the trusted proxies is the client address.
+// The api keys with rateLimitExempt are exempt without it. The daily quotas still apply
+func WithRateLimitExemption(networks []*net.IPNet, trustedProxies ...*net.IPNet) Option {
+	return func(a *App) {
+		a.exemptNetworks = networks
+		a.exemptProxies = trustedProxies
+	}
+}
+
+// rateLimitExemption returns the reason the creation is exempt from the rate limit, empty if it isn't
+func (a *App) rateLimitExemption(r *http.Request) string {
+	if key, ok := requestAPIKey(r); ok && key.RateLimitExempt {
+		return exemptAPIKey
+	}
+	if len(a.exemptNetworks) > 0 && isTrustedProxy(clientIP(r, a.exemptProxies), a.exemptNetworks) {
+		return exemptNetwork
+	}
+	return ""
+}
@@ -0,0 +1,183 @@
+package httpapi_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	sst "github.com/evsan/secret-server-task"
+	"github.com/evsan/secret-server-task/httpapi"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestQuota(t *testing.T) {
+	keys := httpapi.APIKeys{
+		"alice-key": {Key: "alice-key", Owner: "alice"},
+		"bob-key":   {Key: "bob-key", Owner: "bob"},
+	}
+	storage := sst.NewUsageStorage(sst.NewMemStorage())
+	h := httpapi.New(storage, httpapi.WithMetrics(nil), httpapi.WithAPIKeys(keys, false),
+		httpapi.WithLimits(sst.Policy{SecretsPerDay: 2}))
+	post := func(key string) *httptest.ResponseRecorder {
+		form := url.Values{"secret": {"test secret"}, "expireAfterViews": {"1"}, "expireAfter": {"0"}}
+		req := httptest.NewRequest(http.MethodPost, "/secret", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Accept", "application/json")
+		req.Header.Set("X-API-Key", key)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	// The steps are ordered, every owner has its own quota
+	steps := []struct {
+		key      string
+		expected int
+	}{
+		{"alice-key", http.StatusOK},
+		{"alice-key", http.StatusOK},
+		{"alice-key", http.StatusPaymentRequired},
+		{"bob-key", http.StatusOK},
+	}
+	var refused *httptest.ResponseRecorder
+	for i, step := range steps {
+		w := post(step.key)
+		if w.Code != step.expected {
+			t.Fatalf("step %d: expected: %d, result: %d", i, step.expected, w.Code)
+		}
+		if w.Code == http.StatusPaymentRequired {
+			refused = w
+		}
+	}
+
+	var e httpapi.LimitError
+	if err := json.Unmarshal(refused.Body.Bytes(), &e); err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+	tomorrow := time.Now().UTC().Truncate(24 * time.Hour).Add(24 * time.Hour)
+	if e.Code != httpapi.LimitQuota || e.Current != 2 || e.Limit != 2 || !e.Reset.Equal(tomorrow) {
+		t.Fatalf("unexpected limit error: %+v", e)
+	}
+	if refused.Header().Get("Retry-After") == "" {
+		t.Fatal("Retry-After is expected")
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/usage", nil)
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("X-API-Key", "alice-key")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected: %d, result: %d", http.StatusOK, w.Code)
+	}
+	var usage httpapi.UsageResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &usage); err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+	if usage.Owner != "alice" || usage.SecretsCreated != 2 || usage.Since == nil || usage.RateLimit != nil {
+		t.Fatalf("unexpected usage: %+v", usage)
+	}
+	if usage.Quota == nil || usage.Quota.Used != 2 || usage.Quota.Remaining != 0 {
+		t.Fatalf("unexpected quota: %+v", usage.Quota)
+	}
+
+	// The usage is shown to the api key holders only
+	req = httptest.NewRequest(http.MethodGet, "/v1/usage", nil)
+	req.Header.Set("Accept", "application/json")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected: %d, result: %d", http.StatusUnauthorized, w.Code)
+	}
+}
+
+func TestRateLimitError(t *testing.T) {
+	h := httpapi.New(sst.NewMemStorage(), httpapi.WithMetrics(nil),
+		httpapi.WithLimits(sst.Policy{RatePerMinute: 60, RateBurst: 1}))
+
+	if w := postSecret(h, "/secret"); w.Code != http.StatusOK {
+		t.Fatalf("expected: %d, result: %d", http.StatusOK, w.Code)
+	}
+	w := postSecret(h, "/secret")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected: %d, result: %d", http.StatusTooManyRequests, w.Code)
+	}
+	var e httpapi.LimitError
+	if err := json.Unmarshal(w.Body.Bytes(), &e); err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+	if e.Code != httpapi.LimitRate || e.Current != 1 || e.Limit != 1 || !e.Reset.After(time.Now()) {
+		t.Fatalf("unexpected limit error: %+v", e)
+	}
+}
+
+func TestRateLimitExemption(t *testing.T) {
+	keys := httpapi.APIKeys{
+		"ci-key":    {Key: "ci-key", Owner: "ci", RateLimitExempt: true},
+		"alice-key": {Key: "alice-key", Owner: "alice"},
+	}
+	networks, err := httpapi.ParseTrustedProxies("198.51.100.0/24")
+	if err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+	proxies, err := httpapi.ParseTrustedProxies("10.0.0.1")
+	if err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+
+	testCases := map[string]struct {
+		remoteAddr string
+		header     http.Header
+		exempt     bool
+	}{
+		"anonymous":         {"192.0.2.1:1234", nil, false},
+		"exempt key":        {"192.0.2.1:1234", http.Header{"X-Api-Key": {"ci-key"}}, true},
+		"other key":         {"192.0.2.1:1234", http.Header{"X-Api-Key": {"alice-key"}}, false},
+		"network":           {"198.51.100.7:1234", nil, true},
+		"trusted proxy":     {"10.0.0.1:1234", http.Header{"X-Forwarded-For": {"198.51.100.7"}}, true},
+		"untrusted forward": {"192.0.2.1:1234", http.Header{"X-Forwarded-For": {"198.51.100.7"}}, false},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			reg := prometheus.NewRegistry()
+			h := httpapi.New(sst.NewMemStorage(), httpapi.WithMetrics(reg), httpapi.WithAPIKeys(keys, false),
+				httpapi.WithLimits(sst.Policy{RatePerMinute: 1, RateBurst: 1}),
+				httpapi.WithRateLimitExemption(networks, proxies...))
+			var codes []int
+			for i := 0; i < 3; i++ {
+				form := url.Values{"secret": {"test secret"}, "expireAfterViews": {"1"}, "expireAfter": {"0"}}
+				req := httptest.NewRequest(http.MethodPost, "/secret", strings.NewReader(form.Encode()))
+				req.RemoteAddr = tc.remoteAddr
+				req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+				req.Header.Set("Accept", "application/json")
+				for k, v := range tc.header {
+					req.Header[k] = v
+				}
+				w := httptest.NewRecorder()
+				h.ServeHTTP(w, req)
+				codes = append(codes, w.Code)
+			}
+			if exempt := codes[2] == http.StatusOK; exempt != tc.exempt || codes[0] != http.StatusOK {
+				t.Fatalf("expected exempt: %v, result: %v", tc.exempt, codes)
+			}
+
+			families, err := reg.Gather()
+			if err != nil {
+				t.Fatal("error is not expected: ", err)
+			}
+			exemptions := 0
+			for _, f := range families {
+				if f.GetName() == "secret_rate_limit_exemptions_total" {
+					exemptions = int(f.Metric[0].Counter.GetValue())
+				}
+			}
+			if tc.exempt && exemptions != 3 || !tc.exempt && exemptions != 0 {
+				t.Fatalf("unexpected exemptions: %d", exemptions)
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	sst "github.com/evsan/secret-server-task"
+)
+
+// receiptHeader carries the compact JWS of the CreationReceipt
+const receiptHeader = "X-Creation-Receipt"
+
+// receiptType is the type of the receipt payload, so it isn't taken for the other signed responses
+const receiptType = "secret-creation-receipt"
+
+// CreationReceipt is the signed evidence that the secret was created by the server: the creator archives it
+// for the audits, it carries the metadata and the applied policy, never the secret text
+type CreationReceipt struct {
+	Type     string    `json:"typ"`
+	Issuer   string    `json:"iss,omitempty"`
+	IssuedAt time.Time `json:"issuedAt"`
+	Hash     string    `json:"hash"`
+	// URL is the retrieval link the secret was transferred with
+	URL              string    `json:"url"`
+	Tenant           string    `json:"tenant,omitempty"`
+	Owner            string    `json:"owner,omitempty"`
+	CreatedAt        time.Time `json:"createdAt"`
+	ExpiresAt        time.Time `json:"expiresAt"`
+	NotBefore        time.Time `json:"notBefore"`
+	ExpireAfterViews int       `json:"expireAfterViews"`
+	// Policy is the creation policy of the tenant the secret passed
+	Policy   sst.Policy `json:"policy"`
+	Template string     `json:"template,omitempty"`
+	// PolicyEvaluated means the organization policy allowed the creation too
+	PolicyEvaluated bool `json:"policyEvaluated"`
+}
+
+// setReceipt adds the signed receipt of the created secret to the response, nothing if the server doesn't sign
+func (a *App) setReceipt(w http.ResponseWriter, r *http.Request, tenant string, policy sst.Policy, secret sst.Secret) {
+	if a.signer == nil {
+		return
+	}
+	receipt := CreationReceipt{
+		Type:             receiptType,
+		Issuer:           a.baseURL,
+		IssuedAt:         time.Now().UTC(),
+		Hash:             secret.Hash,
+		URL:              a.secretURL(tenant, secret.Hash),
+		Tenant:           tenant,
+		Owner:            secret.Owner,
+		CreatedAt:        secret.CreatedAt,
+		ExpiresAt:        secret.ExpiresAt,
+		NotBefore:        secret.NotBefore,
+		ExpireAfterViews: secret.RemainingViews,
+		Policy:           policy,
+		Template:         r.FormValue("template"),
+		PolicyEvaluated:  a.policyEvaluator != nil,
+	}
+	payload, err := json.Marshal(receipt)
+	if err == nil {
+		var jws string
+		if jws, err = a.signer.Compact(payload); err == nil {
+			w.Header().Set(receiptHeader, jws)
+			return
+		}
+	}
+	// The secret is stored anyway, the creator gets it without the receipt
+	log.Println("creation receipt: ", err)
+}
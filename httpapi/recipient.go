@@ -0,0 +1,91 @@
+package httpapi
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+
+	sst "github.com/evsan/secret-server-task"
+)
+
+// errRecipientBinding is returned if the secret is bound to more than one credential
+var errRecipientBinding = errors.New("secret can be bound to recipientToken or recipientCert, not both")
+
+// recipientTokenHeader carries the pre-shared token of the secret bound with recipientToken
+const recipientTokenHeader = "X-Recipient-Token"
+
+// WithClientCertHeader reads the identity of the verified client certificate from the header set
+// by the TLS terminating proxy, e.g. X-SSL-Client-S-DN. The header must be overwritten by the proxy
+// on every request, otherwise the clients forge it. Without it the subject of the verified
+// certificate of the TLS connection is used
+func WithClientCertHeader(name string) Option {
+	return func(a *App) {
+		a.clientCertHeader = name
+	}
+}
+
+// recipientOption returns the binding of the recipientToken or recipientCert form field, nil if none is set
+func recipientOption(r *http.Request) (sst.SecretOption, error) {
+	token, cert := r.FormValue("recipientToken"), r.FormValue("recipientCert")
+	switch {
+	case token != "" && cert != "":
+		return nil, errRecipientBinding
+	case token != "":
+		binding, err := sst.RecipientToken(token)
+		if err != nil {
+			return nil, err
+		}
+		return sst.WithRecipient(binding), nil
+	case cert != "":
+		return sst.WithRecipient(sst.RecipientCert(cert)), nil
+	}
+	return nil, nil
+}
+
+// recipientVerified reports whether the request presents the credential the secret is bound to
+func (a *App) recipientVerified(s sst.Secret, r *http.Request) bool {
+	var bindings []string
+	if token := r.Header.Get(recipientTokenHeader); token != "" {
+		if binding, err := sst.RecipientToken(token); err == nil {
+			bindings = append(bindings, binding)
+		}
+	}
+	if identity := a.clientIdentity(r); identity != "" {
+		bindings = append(bindings, sst.RecipientCert(identity))
+	}
+	for _, binding := range bindings {
+		if subtle.ConstantTimeCompare([]byte(binding), []byte(s.Recipient)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIdentity returns the identity of the verified client certificate, empty without it
+func (a *App) clientIdentity(r *http.Request) string {
+	if a.clientCertHeader != "" {
+		return r.Header.Get(a.clientCertHeader)
+	}
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 && len(r.TLS.VerifiedChains[0]) > 0 {
+		return r.TLS.VerifiedChains[0][0].Subject.String()
+	}
+	return ""
+}
+
+// serveRecipient serves the secret bound to the recipient if the request presents the credential.
+// The claimed secret gets the claim token, which is issued only to the verified recipient
+func (a *App) serveRecipient(st sst.Storage, tenant, key string, s sst.Secret, w http.ResponseWriter, r *http.Request) {
+	if !a.recipientVerified(s, r) {
+		a.getHook(r.Context(), key, GetRejected)
+		a.metrics.secretUnavailable.WithLabelValues("recipient").Inc()
+		http.Error(w, "Recipient credential required", http.StatusForbidden)
+		return
+	}
+	if s.ClaimRequired {
+		a.getHook(r.Context(), key, GetClaimed)
+		a.claimResponse(tenant, s, w, r)
+		return
+	}
+	s, err := sst.RevealSecret(st, key)
+	a.serveSecret(st, key, s, err, w, r)
+}
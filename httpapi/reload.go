@@ -0,0 +1,31 @@
+package httpapi
+
+import (
+	"log"
+	"net/http"
+	"time"
+)
+
+// ReloadResult is the response of POST /admin/reload
+type ReloadResult struct {
+	ReloadedAt time.Time `json:"reloadedAt" xml:"reloadedAt"`
+}
+
+// WithReload serves POST /admin/reload calling the reload, e.g. reading the rotated key files again
+// and swapping the keys of the running server. The failed reload keeps the previous keys
+func WithReload(reload func() error) Option {
+	return func(a *App) {
+		a.reload = reload
+	}
+}
+
+func (a *App) adminReloadHandler(w http.ResponseWriter, r *http.Request) {
+	if err := a.reload(); err != nil {
+		log.Println("admin: reload failed: ", err)
+		http.Error(w, "Reload failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Println("admin: reloaded the keys")
+	a.adminHook(r.Context(), AdminEvent{Action: AdminReload})
+	a.dataResponse(ReloadResult{ReloadedAt: time.Now()}, w, r)
+}
@@ -0,0 +1,119 @@
+package httpapi_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	sst "github.com/evsan/secret-server-task"
+	"github.com/evsan/secret-server-task/httpapi"
+)
+
+func TestWithReload(t *testing.T) {
+	newSigner := func() *httpapi.Signer {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal("error is not expected: ", err)
+		}
+		signer, err := httpapi.NewSigner(key)
+		if err != nil {
+			t.Fatal("error is not expected: ", err)
+		}
+		return signer
+	}
+	newKeys := func(keys ...sst.TenantKey) *sst.TenantKeys {
+		k, err := sst.NewTenantKeys(map[string][]sst.TenantKey{"": keys})
+		if err != nil {
+			t.Fatal("error is not expected: ", err)
+		}
+		return k
+	}
+	oldKey := sst.TenantKey{ID: "key-1", Key: bytes.Repeat([]byte{1}, 32)}
+	newKey := sst.TenantKey{ID: "key-2", Key: bytes.Repeat([]byte{2}, 32)}
+
+	signer, keys := newSigner(), newKeys(oldKey)
+	next := struct {
+		signer *httpapi.Signer
+		keys   *sst.TenantKeys
+		err    error
+	}{newSigner(), newKeys(newKey, oldKey), nil}
+	reload := func() error {
+		if next.err != nil {
+			return next.err
+		}
+		signer.Rotate(next.signer)
+		keys.Replace(next.keys)
+		return nil
+	}
+	var events []httpapi.AdminEvent
+	storage := sst.NewMemStorage(sst.WithMemTenantKeys(keys))
+	handler := httpapi.New(storage, httpapi.WithMetrics(nil), httpapi.WithSigner(signer))
+	admin := httpapi.NewAdmin(storage, httpapi.WithMetrics(nil), httpapi.WithReload(reload),
+		httpapi.WithAdminHook(func(_ context.Context, event httpapi.AdminEvent) { events = append(events, event) }))
+	before, err := storage.Store("test secret", 1, 0)
+	if err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+
+	serve := func(h http.Handler, method, path, accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("Accept", accept)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+	jwks := func() httpapi.JWKS {
+		var jwks httpapi.JWKS
+		if err := json.NewDecoder(serve(handler, http.MethodGet, "/.well-known/jwks.json", "").Body).Decode(&jwks); err != nil {
+			t.Fatal("error is not expected: ", err)
+		}
+		return jwks
+	}
+	oldJWKS := jwks()
+
+	// The failed reload keeps the keys
+	next.err = errors.New("key file is invalid")
+	if w := serve(admin, http.MethodPost, "/admin/reload", "application/json"); w.Code != http.StatusInternalServerError {
+		t.Fatalf("expected: %d, result: %d", http.StatusInternalServerError, w.Code)
+	}
+	if jwks().Keys[0].Kid != oldJWKS.Keys[0].Kid || len(events) != 0 {
+		t.Fatalf("keys should be kept: %v", events)
+	}
+
+	next.err = nil
+	if w := serve(admin, http.MethodPost, "/admin/reload", "application/json"); w.Code != http.StatusOK {
+		t.Fatalf("expected: %d, result: %d", http.StatusOK, w.Code)
+	}
+	if len(events) != 1 || events[0].Action != httpapi.AdminReload {
+		t.Fatalf("unexpected events: %v", events)
+	}
+	newJWKS := jwks()
+	if newJWKS.Keys[0].Kid == oldJWKS.Keys[0].Kid {
+		t.Fatal("signing key should be rotated")
+	}
+	// The secret sealed by the old key is opened and signed by the new one
+	w := serve(handler, http.MethodGet, "/secret/"+before.Hash, "application/jose")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected: %d, result: %d", http.StatusOK, w.Code)
+	}
+	verifyJWS(t, newJWKS, w.Body.String())
+	after, err := storage.Store("test secret", 1, 0)
+	if err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+	peeker, _ := sst.PeekerOf(storage)
+	if s, err := peeker.Peek(after.Hash); err != nil || s.SecretText != "test secret" {
+		t.Fatalf("expected: %s, result: %s %v", "test secret", s.SecretText, err)
+	}
+	if sealed, _ := keys.Seal("", after.Hash, "test secret"); !strings.Contains(sealed, newKey.ID) {
+		t.Fatalf("text should be sealed by the new key: %s", sealed)
+	}
+}
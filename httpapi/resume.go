@@ -0,0 +1,207 @@
+package httpapi
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	sst "github.com/evsan/secret-server-task"
+)
+
+// DownloadTokenHeader carries the token of the download, the resumed requests send it back with Range
+const DownloadTokenHeader = "X-Download-Token"
+
+// WithDownloadResume keeps the downloaded file secrets for the window, so the interrupted download is resumed
+// with the token of the download and Range without consuming another view. The written bytes may never have
+// reached the client, so Range may start anywhere up to the last written byte; the bytes which were never
+// written are not served. The session is dropped when the secret is deleted, revoked or erased,
+// and when the window ends. The kept files are in the locked memory, maxBytes limits their size; the downloads over it or without
+// the locked memory are not resumable. The tokens are accepted only by the instance which issued them.
+// The handlers built with the same option share the downloads, so the admin handler revokes them.
+// Without it the downloads ignore Range and are always sent whole
+func WithDownloadResume(window time.Duration, maxBytes int64) Option {
+	d := &downloadSessions{window: window, maxBytes: maxBytes, sessions: map[string]*downloadSession{}}
+	return func(a *App) {
+		a.downloads = d
+	}
+}
+
+// downloadSession is the served file kept for the resumed requests
+type downloadSession struct {
+	// key is the storage key of the secret, the token is valid for its download URL only
+	key   string
+	owner string
+	text  *sst.LockedText
+	// header has the headers of the file sent again with every part
+	header http.Header
+	// written is the end of the written bytes, the resumed Range starts at most there
+	written   int
+	expiresAt time.Time
+}
+
+// downloadSessions keeps the files of the resumable downloads by the token
+type downloadSessions struct {
+	mu       sync.Mutex
+	window   time.Duration
+	maxBytes int64
+	size     int64
+	sessions map[string]*downloadSession
+}
+
+// downloadKey returns the storage key of the secret of the tenant, the key used by the admin endpoints
+func downloadKey(tenant, hash string) string {
+	if tenant == "" {
+		return hash
+	}
+	return tenant + "/" + hash
+}
+
+// destroy frees the kept file, the caller holds the lock
+func (d *downloadSessions) destroy(s *downloadSession) {
+	d.size -= int64(s.text.Len())
+	s.text.Destroy()
+}
+
+// sweep drops the expired sessions, the caller holds the lock
+func (d *downloadSessions) sweep(now time.Time) {
+	for token, s := range d.sessions {
+		if now.After(s.expiresAt) {
+			d.destroy(s)
+			delete(d.sessions, token)
+		}
+	}
+}
+
+// start keeps the file of the served view in the locked memory and returns the token resuming its download.
+// False if the kept files would exceed the limit or the file can't be locked
+func (d *downloadSessions) start(key, owner, text string, header http.Header, now time.Time) (string, *downloadSession, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.sweep(now)
+	size := int64(len(text))
+	if d.size+size > d.maxBytes {
+		return "", nil, false
+	}
+	locked, err := sst.NewLockedText(text)
+	if err != nil {
+		return "", nil, false
+	}
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	d.size += size
+	token := base64.RawURLEncoding.EncodeToString(b)
+	s := &downloadSession{key: key, owner: owner, text: locked, header: header, expiresAt: now.Add(d.window)}
+	d.sessions[token] = s
+	return token, s, true
+}
+
+// claim returns the session of the download of the storage key
+func (d *downloadSessions) claim(key, token string, now time.Time) (*downloadSession, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.sweep(now)
+	s, ok := d.sessions[token]
+	if !ok || s.key != key {
+		return nil, false
+	}
+	return s, true
+}
+
+// dropDownloads removes the resumable downloads of the storage key, also of the secret whose last view
+// was consumed by the download. The empty owner matches any, it returns the number of the dropped downloads
+func (a *App) dropDownloads(key, owner string) int {
+	if a.downloads == nil {
+		return 0
+	}
+	return a.downloads.drop(func(s *downloadSession) bool {
+		return s.key == key && (owner == "" || s.owner == owner)
+	})
+}
+
+// drop removes the matching sessions and returns their number
+func (d *downloadSessions) drop(match func(*downloadSession) bool) int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	dropped := 0
+	for token, s := range d.sessions {
+		if !match(s) {
+			continue
+		}
+		dropped++
+		delete(d.sessions, token)
+		// The part being written stops at the next chunk
+		d.destroy(s)
+	}
+	return dropped
+}
+
+// resumeRangeMessage rejects Range of the resumable download which starts after the written bytes
+const resumeRangeMessage = "Range should start at most at the end of the received bytes"
+
+// resumeRange parses `bytes=<first>-` or `bytes=<first>-<last>` of the part starting at most at written.
+// The part without the end has the last -1, the request without Range is the whole file
+func resumeRange(header string, written int) (first, last int, ok bool) {
+	if header == "" {
+		return 0, -1, true
+	}
+	spec, found := strings.CutPrefix(header, "bytes=")
+	if !found {
+		return 0, 0, false
+	}
+	start, end, found := strings.Cut(spec, "-")
+	first, err := strconv.Atoi(start)
+	if !found || err != nil || first < 0 || first > written {
+		return 0, 0, false
+	}
+	if end == "" {
+		return first, -1, true
+	}
+	last, err = strconv.Atoi(end)
+	if err != nil || last < first {
+		return 0, 0, false
+	}
+	return first, last, true
+}
+
+// serve writes the requested part of the file and moves the end of the written bytes
+func (d *downloadSessions) serve(s *downloadSession, w http.ResponseWriter, r *http.Request) {
+	h := w.Header()
+	size := s.text.Len()
+	d.mu.Lock()
+	written := s.written
+	d.mu.Unlock()
+	first, last, ok := resumeRange(r.Header.Get("Range"), written)
+	if !ok || (size > 0 && first >= size) {
+		h.Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+		http.Error(w, resumeRangeMessage, http.StatusRequestedRangeNotSatisfiable)
+		return
+	}
+	for name, values := range s.header {
+		h[name] = values
+	}
+	if last < 0 || last >= size {
+		last = size - 1
+	}
+	h.Set("Accept-Ranges", "bytes")
+	h.Set("Content-Length", strconv.Itoa(last+1-first))
+	if r.Header.Get("Range") == "" || size == 0 {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		h.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", first, last, size))
+		w.WriteHeader(http.StatusPartialContent)
+	}
+	if r.Method == http.MethodHead || size == 0 {
+		return
+	}
+	n, _ := s.text.WriteRange(w, first, last+1)
+	d.mu.Lock()
+	s.written = max(s.written, first+n)
+	d.mu.Unlock()
+}
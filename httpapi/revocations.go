@@ -0,0 +1,197 @@
+package httpapi
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	sst "github.com/evsan/secret-server-task"
+)
+
+// States of the bulk revocation
+const (
+	RevocationRunning = "running"
+	RevocationDone    = "done"
+	RevocationFailed  = "failed"
+)
+
+// revocationBatch is the amount of the secrets searched at once by the bulk revocation
+const revocationBatch = 100
+
+// RevocationJob is the status of the bulk revocation started by POST /admin/revocations
+type RevocationJob struct {
+	ID     string `json:"id" xml:"id"`
+	State  string `json:"state" xml:"state"`
+	Reason string `json:"reason" xml:"reason"`
+	// Matched is the amount of the live secrets found by the filter so far
+	Matched int `json:"matched" xml:"matched"`
+	Revoked int `json:"revoked" xml:"revoked"`
+	// Failed is the amount of the secrets which couldn't be revoked, the consumed ones in the meantime are not counted
+	Failed     int        `json:"failed" xml:"failed"`
+	StartedAt  time.Time  `json:"startedAt" xml:"startedAt"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty" xml:"finishedAt,omitempty"`
+	// Error is the reason of the failed state
+	Error string `json:"error,omitempty" xml:"error,omitempty"`
+}
+
+var errNoRevocationFilter = errors.New("at least one of apiKey, owner, tenant and label should be set")
+
+// revocationJobs keeps the bulk revocations of the process, they are lost on restart
+type revocationJobs struct {
+	mu   sync.Mutex
+	jobs map[string]*RevocationJob
+}
+
+func (j *revocationJobs) add(job *RevocationJob) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.jobs == nil {
+		j.jobs = map[string]*RevocationJob{}
+	}
+	j.jobs[job.ID] = job
+}
+
+// get returns the copy of the job, so it can be encoded while the job runs
+func (j *revocationJobs) get(id string) (RevocationJob, bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	job, ok := j.jobs[id]
+	if !ok {
+		return RevocationJob{}, false
+	}
+	return *job, true
+}
+
+func (j *revocationJobs) update(job *RevocationJob, fn func(job *RevocationJob)) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	fn(job)
+}
+
+// adminBulkRevokeHandler starts the revocation of all the live secrets matching the filter of the search:
+// apiKey= (the owner and the tenant of the key), owner=, tenant=, label=, createdAfter=, createdBefore=
+// or within= (the duration before now), and the required reason=. The job runs in the background,
+// its status is at the Location of the response
+func (a *App) adminBulkRevokeHandler(w http.ResponseWriter, r *http.Request) {
+	revoker, revokerOK := sst.Base(a.storage).(sst.Revoker)
+	searcher, searcherOK := sst.Base(a.storage).(sst.Searcher)
+	if !revokerOK || !searcherOK {
+		http.Error(w, "Storage doesn't support bulk revocation", http.StatusNotImplemented)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		a.bodyError(w, r, err, "Invalid input", http.StatusBadRequest)
+		return
+	}
+	q, err := parseSearchQuery(r.Form)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if value := r.FormValue("apiKey"); value != "" {
+		key, ok := a.apiKeys[value]
+		if !ok {
+			http.Error(w, "Unknown api key", http.StatusBadRequest)
+			return
+		}
+		q.Owner, q.Tenant = key.Owner, &key.Tenant
+	}
+	if value := r.FormValue("within"); value != "" {
+		within, err := time.ParseDuration(value)
+		if err != nil || within <= 0 {
+			http.Error(w, "within should be the positive duration, e.g. 24h", http.StatusBadRequest)
+			return
+		}
+		q.CreatedAfter = time.Now().Add(-within)
+	}
+	if q.Owner == "" && q.Tenant == nil && len(q.Labels) == 0 {
+		http.Error(w, errNoRevocationFilter.Error(), http.StatusBadRequest)
+		return
+	}
+	reason := r.FormValue("reason")
+	if reason == "" {
+		http.Error(w, sst.ErrEmptyReason.Error(), http.StatusBadRequest)
+		return
+	}
+	q.Labels = sst.StoredLabels(a.storage, q.Labels)
+	q.Status = sst.StatusLive
+	q.After = nil
+	q.Limit = revocationBatch
+
+	job := &RevocationJob{ID: sst.GenHashKey(), State: RevocationRunning, Reason: reason, StartedAt: time.Now()}
+	a.revocations.add(job)
+	log.Printf("admin: started bulk revocation %s, reason: %q", job.ID, reason)
+	go a.runRevocation(job, q, searcher, revoker)
+
+	status, _ := a.revocations.get(job.ID)
+	w.Header().Set("Location", a.pathPrefix+"/admin/revocations/"+job.ID)
+	w.Header().Set("Cache-Control", "no-store")
+	a.dataResponse(status, w, r)
+}
+
+// runRevocation pages through the matching secrets and revokes them. The revoked secrets are not live anymore,
+// the cursor still follows the order of the creation, so the page after the last one is requested
+func (a *App) runRevocation(job *RevocationJob, q sst.SearchQuery, searcher sst.Searcher, revoker sst.Revoker) {
+	var err error
+	for {
+		var secrets []sst.Secret
+		if secrets, err = searcher.Search(q); err != nil {
+			break
+		}
+		for _, s := range secrets {
+			revokeErr := revoker.Revoke(s.Hash, job.Reason)
+			if revokeErr == nil {
+				a.dropDownloads(s.Hash, "")
+			}
+			if revokeErr != nil && !errors.Is(revokeErr, sst.ErrSecretNotAvailable) {
+				log.Printf("admin: bulk revocation %s: secret %s: %s", job.ID, s.Hash, revokeErr)
+			}
+			a.revocations.update(job, func(job *RevocationJob) {
+				job.Matched++
+				switch {
+				case revokeErr == nil:
+					job.Revoked++
+				case !errors.Is(revokeErr, sst.ErrSecretNotAvailable):
+					job.Failed++
+				}
+			})
+		}
+		if len(secrets) < q.Limit {
+			break
+		}
+		last := secrets[len(secrets)-1]
+		q.After = &sst.SearchPosition{CreatedAt: last.CreatedAt, Hash: last.Hash}
+	}
+
+	now := time.Now()
+	a.revocations.update(job, func(job *RevocationJob) {
+		job.FinishedAt = &now
+		job.State = RevocationDone
+		if err != nil {
+			job.State, job.Error = RevocationFailed, err.Error()
+		}
+	})
+	status, _ := a.revocations.get(job.ID)
+	if err != nil {
+		log.Printf("admin: bulk revocation %s failed after %d secrets: %s", job.ID, status.Revoked, err)
+		return
+	}
+	log.Printf("admin: bulk revocation %s revoked %d secrets", job.ID, status.Revoked)
+	a.adminHook(context.Background(), AdminEvent{Action: AdminBulkRevoke, Target: job.ID,
+		Detail: strconv.Itoa(status.Revoked) + " secrets, reason: " + job.Reason})
+}
+
+// adminRevocationHandler returns the status of the bulk revocation
+func (a *App) adminRevocationHandler(w http.ResponseWriter, r *http.Request) {
+	job, ok := a.revocations.get(r.PathValue("id"))
+	if !ok {
+		http.Error(w, "Revocation not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	a.dataResponse(job, w, r)
+}
@@ -0,0 +1,341 @@
+package httpapi
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxRouteLimiters bounds the rate limiters of the clients of the route group,
+// the limiter of the least recently seen client is dropped beyond it
+const maxRouteLimiters = 10000
+
+// MiddlewareFactory builds the middleware of the route groups from its settings, the JSON object
+// of the middleware in the config. The App is the one serving the routes
+type MiddlewareFactory func(a *App, config json.RawMessage) (func(http.Handler) http.Handler, error)
+
+var (
+	middlewareMu sync.RWMutex
+	// middlewareRegistry holds the middlewares available to the route groups by the name
+	middlewareRegistry = map[string]MiddlewareFactory{
+		"apiKey":    apiKeyRouteMiddleware,
+		"rateLimit": rateLimitRouteMiddleware,
+		"bodyLimit": bodyLimitRouteMiddleware,
+		"timeout":   timeoutRouteMiddleware,
+	}
+)
+
+// RegisterMiddleware makes the middleware available to the route groups under the name, e.g. in init.
+// It panics if the name is already registered, as the built-in apiKey, rateLimit, bodyLimit and timeout are
+func RegisterMiddleware(name string, f MiddlewareFactory) {
+	middlewareMu.Lock()
+	defer middlewareMu.Unlock()
+	if _, ok := middlewareRegistry[name]; ok {
+		panic("httpapi: middleware " + name + " is already registered")
+	}
+	middlewareRegistry[name] = f
+}
+
+func middlewareFactory(name string) (MiddlewareFactory, bool) {
+	middlewareMu.RLock()
+	defer middlewareMu.RUnlock()
+	f, ok := middlewareRegistry[name]
+	return f, ok
+}
+
+// RouteMiddleware applies the middlewares to the group of the API routes
+type RouteMiddleware struct {
+	// Routes are the patterns of the routes without the path prefix, e.g. "GET /secret/{hash}".
+	// The method alone ("GET") or the path alone ("/t/{tenant}/secret") matches all routes of it, "*" matches all routes
+	Routes []string `json:"routes"`
+	// Middleware are applied in the order, the first one is the outermost
+	Middleware []MiddlewareConfig `json:"middleware"`
+}
+
+// MiddlewareConfig is the middleware of the registry with its settings, e.g. {"name": "rateLimit", "perMinute": 60}
+type MiddlewareConfig struct {
+	Name string
+	// Config is the whole JSON object of the middleware, the factory reads its settings from it
+	Config json.RawMessage
+}
+
+func (c *MiddlewareConfig) UnmarshalJSON(b []byte) error {
+	var named struct {
+		Name string `json:"name"`
+	}
+	if err := json.Unmarshal(b, &named); err != nil {
+		return err
+	}
+	c.Name, c.Config = named.Name, append(json.RawMessage(nil), b...)
+	return nil
+}
+
+// LoadRouteMiddleware reads the JSON list of the route groups and checks their middlewares are registered
+// and configured correctly
+func LoadRouteMiddleware(path string) ([]RouteMiddleware, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var groups []RouteMiddleware
+	if err = json.NewDecoder(f).Decode(&groups); err != nil {
+		return nil, err
+	}
+	// The settings are validated against the App without the options
+	a := &App{}
+	for i, g := range groups {
+		if len(g.Routes) == 0 {
+			return nil, fmt.Errorf("route group %d has no routes", i)
+		}
+		for _, route := range g.Routes {
+			if route == "" {
+				return nil, fmt.Errorf("route group %d has the empty route", i)
+			}
+		}
+		for _, m := range g.Middleware {
+			if _, err = m.build(a); err != nil {
+				return nil, fmt.Errorf("route group %d: %v", i, err)
+			}
+		}
+	}
+	return groups, nil
+}
+
+// WithRouteMiddleware applies the middlewares to the route groups of the public API. They are built
+// with the handler and run inside the middlewares of the whole API, e.g. after WithMaxBodySize.
+// The route matching several groups gets the middlewares of all of them in the order of the groups
+func WithRouteMiddleware(groups ...RouteMiddleware) Option {
+	return func(a *App) {
+		a.routeMiddleware = append(a.routeMiddleware, groups...)
+	}
+}
+
+// build creates the middleware by the factory of its name
+func (c MiddlewareConfig) build(a *App) (func(http.Handler) http.Handler, error) {
+	f, ok := middlewareFactory(c.Name)
+	if !ok {
+		return nil, fmt.Errorf("unknown middleware %q", c.Name)
+	}
+	config := c.Config
+	if len(config) == 0 {
+		config = json.RawMessage("{}")
+	}
+	mw, err := f(a, config)
+	if err != nil {
+		return nil, fmt.Errorf("middleware %s: %v", c.Name, err)
+	}
+	return mw, nil
+}
+
+// matchesRoute reports whether the route of the group matches the registered pattern, e.g. "GET /secret/{hash}"
+func matchesRoute(route, method, path string) bool {
+	if route == "*" {
+		return true
+	}
+	routeMethod, routePath, ok := strings.Cut(route, " ")
+	if !ok {
+		return route == method || route == path
+	}
+	return routeMethod == method && routePath == path
+}
+
+// routeMux registers the routes of the API wrapped in the middlewares of their route groups
+type routeMux struct {
+	*http.ServeMux
+	app *App
+	// groups are the built middlewares of the route groups, the routes of the group share them, e.g. the rate limit
+	groups [][]func(http.Handler) http.Handler
+	// matched counts the routes of the route groups, the groups matching no route are reported
+	matched []int
+}
+
+func (a *App) newRouteMux() *routeMux {
+	m := &routeMux{ServeMux: http.NewServeMux(), app: a, matched: make([]int, len(a.routeMiddleware))}
+	for i, g := range a.routeMiddleware {
+		m.groups = append(m.groups, nil)
+		for _, c := range g.Middleware {
+			mw, err := c.build(a)
+			if err != nil {
+				// The config is checked by LoadRouteMiddleware, as the invalid pattern it is the programming error
+				panic(fmt.Sprintf("httpapi: route group %d: %v", i, err))
+			}
+			m.groups[i] = append(m.groups[i], mw)
+		}
+	}
+	return m
+}
+
+func (m *routeMux) Handle(pattern string, handler http.Handler) {
+	method, path, _ := strings.Cut(pattern, " ")
+	path = strings.TrimPrefix(path, m.app.pathPrefix)
+	var middleware []func(http.Handler) http.Handler
+	for i, g := range m.app.routeMiddleware {
+		for _, route := range g.Routes {
+			if matchesRoute(route, method, path) {
+				m.matched[i]++
+				middleware = append(middleware, m.groups[i]...)
+				break
+			}
+		}
+	}
+	for i := len(middleware) - 1; i >= 0; i-- {
+		handler = middleware[i](handler)
+	}
+	m.ServeMux.Handle(pattern, handler)
+}
+
+func (m *routeMux) HandleFunc(pattern string, handler func(http.ResponseWriter, *http.Request)) {
+	m.Handle(pattern, http.HandlerFunc(handler))
+}
+
+// reportUnmatched logs the route groups which match no route, e.g. the misspelled pattern
+func (m *routeMux) reportUnmatched() {
+	for i, n := range m.matched {
+		if n == 0 {
+			log.Printf("route middleware: group %d %v matches no route", i, m.app.routeMiddleware[i].Routes)
+		}
+	}
+}
+
+// apiKeyRouteMiddleware authenticates the api key of the request, {"required": true} refuses the requests without it
+func apiKeyRouteMiddleware(a *App, config json.RawMessage) (func(http.Handler) http.Handler, error) {
+	var c struct {
+		Required bool `json:"required"`
+	}
+	if err := json.Unmarshal(config, &c); err != nil {
+		return nil, err
+	}
+	return a.apiKeys.Middleware(c.Required), nil
+}
+
+// rateLimitRouteMiddleware limits the requests of every client to the routes of the group, {"perMinute": 60, "burst": 10}.
+// "shared": true limits all clients by one bucket instead. The exemptions of WithRateLimitExemption apply
+// and the client address is the one of its trusted proxies
+func rateLimitRouteMiddleware(a *App, config json.RawMessage) (func(http.Handler) http.Handler, error) {
+	var c struct {
+		PerMinute int  `json:"perMinute"`
+		Burst     int  `json:"burst"`
+		Shared    bool `json:"shared"`
+	}
+	if err := json.Unmarshal(config, &c); err != nil {
+		return nil, err
+	}
+	if c.PerMinute <= 0 || c.Burst < 0 {
+		return nil, errors.New("perMinute should be positive and burst should not be negative")
+	}
+	limiters := newClientLimiters(c.PerMinute, c.Burst, maxRouteLimiters)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if a.rateLimitExemption(r) != "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			client := ""
+			if !c.Shared {
+				client = clientIP(r, a.exemptProxies)
+			}
+			now := time.Now()
+			limit, allowed := limiters.get(client).Take(now)
+			limit.setHeaders(w.Header())
+			if !allowed {
+				a.limitResponse(w, r, http.StatusTooManyRequests, rateLimitError(limit, now))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}, nil
+}
+
+// clientLimiters are the rate limiters of the clients of one route group, at most capacity of them.
+// They are ordered by the last request, so the flood of the new clients drops the idle ones first
+type clientLimiters struct {
+	mu        sync.Mutex
+	perMinute int
+	burst     int
+	capacity  int
+	// order has the *clientLimiter of the most recent client at the front
+	order    *list.List
+	limiters map[string]*list.Element
+}
+
+type clientLimiter struct {
+	client  string
+	limiter *rateLimiter
+}
+
+func newClientLimiters(perMinute, burst, capacity int) *clientLimiters {
+	return &clientLimiters{perMinute: perMinute, burst: burst, capacity: capacity, order: list.New(), limiters: map[string]*list.Element{}}
+}
+
+func (l *clientLimiters) get(client string) *rateLimiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if e, ok := l.limiters[client]; ok {
+		l.order.MoveToFront(e)
+		return e.Value.(*clientLimiter).limiter
+	}
+	if l.order.Len() >= l.capacity {
+		oldest := l.order.Back()
+		l.order.Remove(oldest)
+		delete(l.limiters, oldest.Value.(*clientLimiter).client)
+	}
+	limiter := newRateLimiter(l.perMinute, l.burst)
+	l.limiters[client] = l.order.PushFront(&clientLimiter{client: client, limiter: limiter})
+	return limiter
+}
+
+// bodyLimitRouteMiddleware limits the request bodies of the routes, {"maxBytes": 1024}.
+// It can only lower the limit of WithMaxBodySize
+func bodyLimitRouteMiddleware(a *App, config json.RawMessage) (func(http.Handler) http.Handler, error) {
+	var c struct {
+		MaxBytes int64 `json:"maxBytes"`
+	}
+	if err := json.Unmarshal(config, &c); err != nil {
+		return nil, err
+	}
+	if c.MaxBytes <= 0 {
+		return nil, errors.New("maxBytes should be positive")
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.ContentLength > c.MaxBytes {
+				a.tooLarge(w, r, c.MaxBytes)
+				return
+			}
+			r.Body = http.MaxBytesReader(w, r.Body, c.MaxBytes)
+			next.ServeHTTP(w, r)
+		})
+	}, nil
+}
+
+// timeoutRouteMiddleware sets the deadline of the requests to the routes as RequestTimeout does, {"timeout": "2s"}.
+// The shorter X-Request-Timeout of the client still applies
+func timeoutRouteMiddleware(a *App, config json.RawMessage) (func(http.Handler) http.Handler, error) {
+	var c struct {
+		Timeout string `json:"timeout"`
+	}
+	if err := json.Unmarshal(config, &c); err != nil {
+		return nil, err
+	}
+	timeout, err := time.ParseDuration(c.Timeout)
+	if err != nil || timeout <= 0 {
+		return nil, errors.New("timeout should be the positive duration, e.g. 2s")
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx, cancel := context.WithTimeout(r.Context(), timeout)
+			defer cancel()
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}, nil
+}
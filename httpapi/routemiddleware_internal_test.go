@@ -0,0 +1,34 @@
+package httpapi
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestClientLimiters_Bound(t *testing.T) {
+	limiters := newClientLimiters(1, 0, maxRouteLimiters)
+	now := time.Now()
+	if _, allowed := limiters.get("busy").Take(now); !allowed {
+		t.Fatal("first request should be allowed")
+	}
+	for i := 0; i < 2*maxRouteLimiters; i++ {
+		limiters.get(strconv.Itoa(i)).Take(now)
+		// The busy client is seen again and again, the idle ones are dropped
+		if i%100 == 0 {
+			limiters.get("busy")
+		}
+		if n := len(limiters.limiters); n > maxRouteLimiters || limiters.order.Len() != n {
+			t.Fatalf("expected: %d, result: %d, %d", maxRouteLimiters, n, limiters.order.Len())
+		}
+	}
+	if n := len(limiters.limiters); n != maxRouteLimiters {
+		t.Fatalf("expected: %d, result: %d", maxRouteLimiters, n)
+	}
+	if _, allowed := limiters.get("busy").Take(now); allowed {
+		t.Fatal("limit of the recently seen client should be kept")
+	}
+	if _, ok := limiters.limiters["0"]; ok {
+		t.Fatal("least recently seen client should be dropped")
+	}
+}
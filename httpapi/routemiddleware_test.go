@@ -0,0 +1,91 @@
+package httpapi_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	sst "github.com/evsan/secret-server-task"
+	"github.com/evsan/secret-server-task/httpapi"
+)
+
+func loadRouteMiddleware(t *testing.T, config string) ([]httpapi.RouteMiddleware, error) {
+	path := filepath.Join(t.TempDir(), "routes.json")
+	if err := os.WriteFile(path, []byte(config), 0o600); err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+	return httpapi.LoadRouteMiddleware(path)
+}
+
+func TestRouteMiddleware(t *testing.T) {
+	groups, err := loadRouteMiddleware(t, `[
+		{"routes": ["GET /secret/{hash}"], "middleware": [{"name": "rateLimit", "perMinute": 1, "burst": 2}]},
+		{"routes": ["POST"], "middleware": [{"name": "bodyLimit", "maxBytes": 64}]},
+		{"routes": ["/secret/{hash}/download"], "middleware": [{"name": "apiKey", "required": true}, {"name": "timeout", "timeout": "1s"}]}
+	]`)
+	if err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+	storage := sst.NewMemStorage()
+	keys := httpapi.APIKeys{"key": {Key: "key", Owner: "alice"}}
+	h := httpapi.New(storage, httpapi.WithMetrics(nil), httpapi.WithAPIKeys(keys, false), httpapi.WithRouteMiddleware(groups...))
+	secret, _ := storage.Store("test secret", 10, 0)
+
+	serve := func(method, target, body, apiKey string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Accept", "application/json")
+		if apiKey != "" {
+			req.Header.Set("X-API-Key", apiKey)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	// GET is rate limited, the creations are not
+	for i, expected := range []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+		if w := serve(http.MethodGet, "/secret/"+secret.Hash, "", ""); w.Code != expected {
+			t.Fatalf("%d: expected: %d, result: %d", i, expected, w.Code)
+		}
+	}
+	form := url.Values{"secret": {"test secret"}, "expireAfterViews": {"2"}, "expireAfter": {"0"}}
+	for i := 0; i < 3; i++ {
+		if w := serve(http.MethodPost, "/secret", form.Encode(), ""); w.Code != http.StatusOK {
+			t.Fatalf("expected: %d, result: %d", http.StatusOK, w.Code)
+		}
+	}
+	form.Set("secret", strings.Repeat("x", 64))
+	if w := serve(http.MethodPost, "/secret", form.Encode(), ""); w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected: %d, result: %d", http.StatusRequestEntityTooLarge, w.Code)
+	}
+
+	// The download requires the api key
+	if w := serve(http.MethodGet, "/secret/"+secret.Hash+"/download", "", ""); w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected: %d, result: %d", http.StatusUnauthorized, w.Code)
+	}
+	if w := serve(http.MethodGet, "/secret/"+secret.Hash+"/download", "", "key"); w.Code != http.StatusOK {
+		t.Fatalf("expected: %d, result: %d", http.StatusOK, w.Code)
+	}
+}
+
+func TestLoadRouteMiddleware(t *testing.T) {
+	testCases := map[string]string{
+		"unknown middleware": `[{"routes": ["GET"], "middleware": [{"name": "gzip"}]}]`,
+		"no routes":          `[{"routes": [], "middleware": [{"name": "apiKey"}]}]`,
+		"rate":               `[{"routes": ["GET"], "middleware": [{"name": "rateLimit", "perMinute": 0}]}]`,
+		"body limit":         `[{"routes": ["POST"], "middleware": [{"name": "bodyLimit"}]}]`,
+		"timeout":            `[{"routes": ["*"], "middleware": [{"name": "timeout", "timeout": "soon"}]}]`,
+	}
+	for name, config := range testCases {
+		t.Run(name, func(t *testing.T) {
+			if _, err := loadRouteMiddleware(t, config); err == nil {
+				t.Fatal("error is expected")
+			}
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package httpapi_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	sst "github.com/evsan/secret-server-task"
+	"github.com/evsan/secret-server-task/httpapi"
+)
+
+func benchmarkRequest(b *testing.B, h http.Handler, method, path string) {
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("Accept", "application/json")
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	}
+}
+
+func BenchmarkRouter_GetSecret(b *testing.B) {
+	storage := sst.NewMemStorage()
+	secret, err := storage.Store("test secret", 1<<30, 0)
+	if err != nil {
+		b.Fatal("error is not expected: ", err)
+	}
+	h := httpapi.New(storage, httpapi.WithMetrics(nil), httpapi.WithPathPrefix("/tools/secrets"))
+	benchmarkRequest(b, h, http.MethodGet, "/tools/secrets/secret/"+secret.Hash)
+}
+
+func BenchmarkRouter_TenantGetSecret(b *testing.B) {
+	storage := sst.NewMemStorage()
+	secret, err := sst.NewTenantStorage(storage, "acme").Store("test secret", 1<<30, 0)
+	if err != nil {
+		b.Fatal("error is not expected: ", err)
+	}
+	h := httpapi.New(storage, httpapi.WithMetrics(nil), httpapi.WithTenants("acme"))
+	benchmarkRequest(b, h, http.MethodGet, "/t/acme/secret/"+secret.Hash)
+}
+
+func BenchmarkRouter_NotFound(b *testing.B) {
+	h := httpapi.New(sst.NewMemStorage(), httpapi.WithMetrics(nil))
+	benchmarkRequest(b, h, http.MethodGet, "/unknown/route")
+}
+
+// discardResponse is the ResponseWriter without the allocations of httptest.ResponseRecorder
+type discardResponse struct {
+	header http.Header
+}
+
+func (w *discardResponse) Header() http.Header         { return w.header }
+func (w *discardResponse) Write(b []byte) (int, error) { return len(b), nil }
+func (w *discardResponse) WriteHeader(int)             {}
+
+func BenchmarkResponse_GetSecret(b *testing.B) {
+	storage := sst.NewMemStorage()
+	secret, err := storage.Store("test secret", 1<<30, 0)
+	if err != nil {
+		b.Fatal("error is not expected: ", err)
+	}
+	h := httpapi.New(storage, httpapi.WithMetrics(nil))
+	req := httptest.NewRequest(http.MethodGet, "/secret/"+secret.Hash, nil)
+	req.Header.Set("Accept", "text/html, application/json")
+	w := &discardResponse{header: http.Header{}}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for k := range w.header {
+			delete(w.header, k)
+		}
+		h.ServeHTTP(w, req)
+	}
+}
@@ -0,0 +1,127 @@
+package httpapi
+
+import (
+	"encoding/base64"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	sst "github.com/evsan/secret-server-task"
+)
+
+// SearchResponse is the response of GET /admin/secrets/search
+type SearchResponse struct {
+	Secrets []SecretInfo `json:"secrets" xml:"secrets>secret"`
+	// Next is the cursor of the next page, empty on the last one
+	Next string `json:"next,omitempty" xml:"next,omitempty"`
+}
+
+// errInvalidCursor is returned for the cursor which wasn't issued by the search
+var errInvalidCursor = errors.New("invalid cursor, it should be the next of the previous page")
+
+// encodeCursor returns the opaque cursor of the position
+func encodeCursor(p sst.SearchPosition) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(p.CreatedAt.UTC().Format(time.RFC3339Nano) + " " + p.Hash))
+}
+
+// decodeCursor parses the cursor of encodeCursor
+func decodeCursor(cursor string) (*sst.SearchPosition, error) {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+	created, hash, ok := strings.Cut(string(b), " ")
+	if !ok {
+		return nil, errInvalidCursor
+	}
+	t, err := time.Parse(time.RFC3339Nano, created)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+	return &sst.SearchPosition{CreatedAt: t, Hash: hash}, nil
+}
+
+// parseSearchQuery reads the filters of the search: ?tenant=, ?owner=, ?label=key:value (repeated, all must match),
+// ?status=live|expired|consumed, ?createdAfter= and ?createdBefore= (RFC 3339), ?cursor= and ?limit=
+func parseSearchQuery(query url.Values) (sst.SearchQuery, error) {
+	var q sst.SearchQuery
+	var err error
+	if _, ok := query["tenant"]; ok {
+		tenant := query.Get("tenant")
+		q.Tenant = &tenant
+	}
+	q.Owner = query.Get("owner")
+	if q.Labels, err = parseLabels(query["label"]); err != nil {
+		return sst.SearchQuery{}, err
+	}
+	if q.Status, err = sst.ParseSecretStatus(query.Get("status")); err != nil {
+		return sst.SearchQuery{}, err
+	}
+	for name, bound := range map[string]*time.Time{"createdAfter": &q.CreatedAfter, "createdBefore": &q.CreatedBefore} {
+		if value := query.Get(name); value != "" {
+			if *bound, err = time.Parse(time.RFC3339, value); err != nil {
+				return sst.SearchQuery{}, errors.New(name + " should be the RFC 3339 time")
+			}
+		}
+	}
+	if cursor := query.Get("cursor"); cursor != "" {
+		if q.After, err = decodeCursor(cursor); err != nil {
+			return sst.SearchQuery{}, err
+		}
+	}
+	q.Limit = defaultListLimit
+	if value := query.Get("limit"); value != "" {
+		q.Limit, err = strconv.Atoi(value)
+		if err != nil || q.Limit <= 0 || q.Limit > maxListLimit {
+			return sst.SearchQuery{}, errors.New("limit should be between 1 and " + strconv.Itoa(maxListLimit))
+		}
+	}
+	return q, nil
+}
+
+// adminSearchHandler finds the metadata of the secrets, the expired and the consumed ones kept until purged included.
+// The results are ordered by the creation time, the next page is requested with the cursor of the response
+func (a *App) adminSearchHandler(w http.ResponseWriter, r *http.Request) {
+	searcher, ok := sst.Base(a.storage).(sst.Searcher)
+	if !ok {
+		http.Error(w, "Storage doesn't support search", http.StatusNotImplemented)
+		return
+	}
+	q, err := parseSearchQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	q.Labels = sst.StoredLabels(a.storage, q.Labels)
+	limit := q.Limit
+	// The extra secret tells whether there is the next page
+	q.Limit++
+	secrets, err := searcher.Search(q)
+	if backendUnavailable(err, w) {
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Search failed", http.StatusInternalServerError)
+		return
+	}
+
+	resp := SearchResponse{Secrets: []SecretInfo{}}
+	if len(secrets) > limit {
+		last := secrets[limit-1]
+		resp.Next = encodeCursor(sst.SearchPosition{CreatedAt: last.CreatedAt, Hash: last.Hash})
+		secrets = secrets[:limit]
+	}
+	now := time.Now()
+	for _, s := range secrets {
+		info := newSecretInfo(s)
+		info.Status = s.StatusAt(now)
+		resp.Secrets = append(resp.Secrets, info)
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	a.dataResponse(resp, w, r)
+}
@@ -0,0 +1,125 @@
+package httpapi
+
+import (
+	"encoding/xml"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+
+	sst "github.com/evsan/secret-server-task"
+)
+
+// TimestampFormat is the encoding of the timestamps of the secret responses
+type TimestampFormat string
+
+const (
+	// TimestampRFC3339 encodes the timestamps as the RFC 3339 strings, e.g. "2006-01-02T15:04:05Z"
+	TimestampRFC3339 TimestampFormat = "rfc3339"
+	// TimestampUnix encodes the timestamps as the seconds since the Unix epoch
+	TimestampUnix TimestampFormat = "unix"
+)
+
+// ResponseShape configures the encoding of the secrets in the JSON and XML responses.
+// The zero value is the default wire format
+type ResponseShape struct {
+	// Timestamps is the encoding of createdAt, expiresAt and notBefore, RFC 3339 if empty
+	Timestamps TimestampFormat
+	// OmitZero omits expiresAt and notBefore of the secrets which never expire or are available immediately
+	OmitZero bool
+}
+
+// errUnknownField is returned for the fields= query parameter naming no field of the secret
+var errUnknownField = errors.New("Unknown field, the fields are " + strings.Join(secretFields, ", "))
+
+// shapedContentTypes are the media types of the built-in marshalers encoding the shaped secret,
+// the raw payload and the custom marshalers get sst.Secret
+var shapedContentTypes = map[string]bool{
+	"application/json": true,
+	"application/xml":  true,
+	"text/xml":         true,
+	joseContentType:    true,
+	halContentType:     true,
+	jsonAPIContentType: true,
+}
+
+// secretFields are the fields of the secret response selectable by the fields= query parameter
+var secretFields = []string{"hash", "secretText", "createdAt", "expiresAt", "remainingViews", "notBefore"}
+
+// WithResponseShape sets the encoding of the secrets. Regardless of it the clients select the fields
+// of the response with the fields= query parameter, e.g. ?fields=hash,expiresAt
+func WithResponseShape(shape ResponseShape) Option {
+	return func(a *App) {
+		a.shape = shape
+	}
+}
+
+// shapedSecret is the secret response with the selected fields and the configured timestamps.
+// The timestamps are time.Time or int64, nil omits the field
+type shapedSecret struct {
+	XMLName        xml.Name    `json:"-" xml:"Secret"`
+	Hash           *string     `json:"hash,omitempty" xml:"hash,omitempty"`
+	SecretText     *string     `json:"secretText,omitempty" xml:"secretText,omitempty"`
+	CreatedAt      interface{} `json:"createdAt,omitempty" xml:"createdAt,omitempty"`
+	ExpiresAt      interface{} `json:"expiresAt,omitempty" xml:"expiresAt,omitempty"`
+	RemainingViews *int        `json:"remainingViews,omitempty" xml:"remainingViews,omitempty"`
+	NotBefore      interface{} `json:"notBefore,omitempty" xml:"notBefore,omitempty"`
+}
+
+// shapeSecret returns the secret as is for the default shape and no selected fields,
+// so the default wire format is untouched
+func (a *App) shapeSecret(s sst.Secret, fields string) (interface{}, error) {
+	if a.shape == (ResponseShape{}) && fields == "" {
+		return s, nil
+	}
+	selected := map[string]bool{}
+	for _, f := range strings.Split(fields, ",") {
+		if f = strings.TrimSpace(f); f != "" {
+			selected[f] = true
+		}
+	}
+	for f := range selected {
+		if !slices.Contains(secretFields, f) {
+			return nil, errUnknownField
+		}
+	}
+	include := func(f string) bool {
+		return len(selected) == 0 || selected[f]
+	}
+
+	var shaped shapedSecret
+	if include("hash") {
+		shaped.Hash = &s.Hash
+	}
+	if include("secretText") {
+		shaped.SecretText = &s.SecretText
+	}
+	if include("createdAt") {
+		shaped.CreatedAt = a.timestamp(s.CreatedAt, false)
+	}
+	if include("expiresAt") {
+		shaped.ExpiresAt = a.timestamp(s.ExpiresAt, a.shape.OmitZero)
+	}
+	if include("remainingViews") {
+		shaped.RemainingViews = &s.RemainingViews
+	}
+	if include("notBefore") {
+		shaped.NotBefore = a.timestamp(s.NotBefore, a.shape.OmitZero)
+	}
+	return shaped, nil
+}
+
+// timestamp encodes the time in the configured format, it returns nil for the zero time if omitZero is set
+func (a *App) timestamp(t time.Time, omitZero bool) interface{} {
+	if omitZero && t.IsZero() {
+		return nil
+	}
+	if a.shape.Timestamps == TimestampUnix {
+		// The zero time is 0 rather than the seconds of the year 1
+		if t.IsZero() {
+			return int64(0)
+		}
+		return t.Unix()
+	}
+	return t
+}
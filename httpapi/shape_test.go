@@ -0,0 +1,61 @@
+package httpapi_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	sst "github.com/evsan/secret-server-task"
+	"github.com/evsan/secret-server-task/httpapi"
+)
+
+func TestWithResponseShape(t *testing.T) {
+	createdAt := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	storage := sst.NewMemStorage(sst.WithMemClock(sst.NewManualClock(createdAt)))
+
+	testCases := map[string]struct {
+		shape    httpapi.ResponseShape
+		accept   string
+		fields   string
+		status   int
+		expected string
+	}{
+		"default": {httpapi.ResponseShape{}, "application/json", "", http.StatusOK,
+			`{"hash":"%s","secretText":"text","createdAt":"2024-01-02T03:04:05Z","expiresAt":"0001-01-01T00:00:00Z","remainingViews":0,"notBefore":"0001-01-01T00:00:00Z"}`},
+		"unix": {httpapi.ResponseShape{Timestamps: httpapi.TimestampUnix}, "application/json", "", http.StatusOK,
+			`{"hash":"%s","secretText":"text","createdAt":1704164645,"expiresAt":0,"remainingViews":0,"notBefore":0}`},
+		"omit zero": {httpapi.ResponseShape{OmitZero: true}, "application/json", "", http.StatusOK,
+			`{"hash":"%s","secretText":"text","createdAt":"2024-01-02T03:04:05Z","remainingViews":0}`},
+		"fields": {httpapi.ResponseShape{}, "application/json", "secretText, remainingViews", http.StatusOK,
+			`{"secretText":"text","remainingViews":0}`},
+		"xml": {httpapi.ResponseShape{Timestamps: httpapi.TimestampUnix}, "application/xml", "createdAt", http.StatusOK,
+			`<Secret><createdAt>1704164645</createdAt></Secret>`},
+		"unknown field": {httpapi.ResponseShape{}, "application/json", "owner", http.StatusBadRequest, ""},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			secret, err := storage.Store("text", 1, 0)
+			if err != nil {
+				t.Fatal("error is not expected: ", err)
+			}
+			h := httpapi.New(storage, httpapi.WithMetrics(nil), httpapi.WithResponseShape(tc.shape))
+			req := httptest.NewRequest(http.MethodGet, "/secret/"+secret.Hash+"?fields="+url.QueryEscape(tc.fields), nil)
+			req.Header.Set("Accept", tc.accept)
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+			if w.Code != tc.status {
+				t.Fatalf("expected: %d, result: %d", tc.status, w.Code)
+			}
+			if tc.status != http.StatusOK {
+				return
+			}
+			expected := strings.Replace(tc.expected, "%s", secret.Hash, 1)
+			if body := strings.TrimSpace(w.Body.String()); body != expected {
+				t.Fatalf("expected: %s, result: %s", expected, body)
+			}
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package httpapi
+
+import (
+	"crypto/sha256"
+	"embed"
+	"encoding/base64"
+	"io"
+	"io/fs"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+//go:embed static
+var staticFS embed.FS
+
+// WebUI returns the embedded web UI: the page creating the secrets and revealing them by the link
+func WebUI() fs.FS {
+	ui, err := fs.Sub(staticFS, "static")
+	if err != nil {
+		panic(err)
+	}
+	return ui
+}
+
+// WithStatic serves the files of fsys at the root of the API, e.g. WebUI() or os.DirFS for the customized UI.
+// The routes of the API take precedence over the files
+func WithStatic(fsys fs.FS) Option {
+	return func(a *App) {
+		a.static = fsys
+	}
+}
+
+// staticCacheControl is the caching of the assets, they aren't fingerprinted,
+// so they are revalidated with the ETag after an hour
+const staticCacheControl = "public, max-age=3600"
+
+// StaticHandler serves the files of fsys with the ETag of their content. The HTML pages are revalidated
+// on every load and get the Content-Security-Policy allowing only the same origin, the other files are cached
+// for an hour. The directories are served by their index.html, they are never listed
+func StaticHandler(fsys fs.FS) http.Handler {
+	return &staticHandler{fsys: fsys}
+}
+
+type staticHandler struct {
+	fsys fs.FS
+	// etags caches the ETag by the file name, the modification time and the size
+	etags sync.Map
+}
+
+func (h *staticHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+	if name == "" {
+		name = "."
+	}
+	f, err := h.fsys.Open(name)
+	if err == nil {
+		if info, statErr := f.Stat(); statErr == nil && info.IsDir() {
+			f.Close()
+			name = path.Join(name, "index.html")
+			f, err = h.fsys.Open(name)
+		}
+	}
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	content, ok := f.(io.ReadSeeker)
+	if err != nil || !ok || info.IsDir() {
+		http.NotFound(w, r)
+		return
+	}
+
+	etag, err := h.etag(name, info)
+	if err != nil {
+		http.Error(w, "File is not readable", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("ETag", etag)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if strings.HasSuffix(name, ".html") {
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Content-Security-Policy", "default-src 'self'; frame-ancestors 'none'")
+		w.Header().Set("Referrer-Policy", "no-referrer")
+	} else {
+		w.Header().Set("Cache-Control", staticCacheControl)
+	}
+	http.ServeContent(w, r, name, info.ModTime(), content)
+}
+
+// etag returns the digest of the file content
+func (h *staticHandler) etag(name string, info fs.FileInfo) (string, error) {
+	key := name + "\x00" + info.ModTime().String() + "\x00" + strconv.FormatInt(info.Size(), 10)
+	if etag, ok := h.etags.Load(key); ok {
+		return etag.(string), nil
+	}
+	b, err := fs.ReadFile(h.fsys, name)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	etag := `"` + base64.RawURLEncoding.EncodeToString(sum[:16]) + `"`
+	h.etags.Store(key, etag)
+	return etag, nil
+}
@@ -0,0 +1,83 @@
+"use strict";
+
+// The UI is served at the root of the API, so the API is reached with the relative URLs.
+// The link of the secret keeps the hash in the fragment, which is never sent to the servers or in Referer
+const result = document.getElementById("result");
+
+// The CSRF token of the server, empty if it doesn't require one (GET csrf is 404)
+let csrfToken;
+
+async function csrf() {
+	if (csrfToken === undefined) {
+		const response = await fetch("csrf", {headers: {"Accept": "application/json"}});
+		csrfToken = response.ok ? (await response.json()).token : "";
+	}
+	return csrfToken;
+}
+
+async function request(url, options) {
+	const headers = {"Accept": "application/json"};
+	if (options && options.method && options.method !== "GET") {
+		headers["X-CSRF-Token"] = await csrf();
+	}
+	const response = await fetch(url, Object.assign({headers: headers}, options));
+	if (!response.ok) {
+		throw new Error((await response.text()).trim() || response.statusText);
+	}
+	return response.json();
+}
+
+document.getElementById("create").addEventListener("submit", async (event) => {
+	event.preventDefault();
+	try {
+		const secret = await request("secret", {method: "POST", body: new URLSearchParams(new FormData(event.target))});
+		event.target.reset();
+		const link = new URL(location.href);
+		link.hash = "/s/" + secret.hash;
+		result.textContent = link.href;
+	} catch (err) {
+		result.textContent = err.message;
+	}
+});
+
+// With the web login the creation needs the session, GET auth/session is 404 if the server has no login
+async function showSession() {
+	const response = await fetch("auth/session", {headers: {"Accept": "application/json"}});
+	if (response.status === 404) {
+		return;
+	}
+	document.getElementById("session").hidden = false;
+	if (!response.ok) {
+		document.getElementById("create").hidden = true;
+		document.getElementById("login").hidden = false;
+		return;
+	}
+	const session = await response.json();
+	document.getElementById("sessionUser").textContent = "Logged in as " + (session.email || session.name || session.sub);
+	const logout = document.getElementById("logout");
+	logout.hidden = false;
+	logout.addEventListener("click", async () => {
+		await fetch("auth/logout", {method: "POST", headers: {"X-CSRF-Token": await csrf()}});
+		location.reload();
+	});
+}
+
+const match = location.hash.match(/^#\/s\/([^/]+)$/);
+if (match) {
+	document.getElementById("create").hidden = true;
+	document.getElementById("reveal").hidden = false;
+	document.getElementById("revealButton").addEventListener("click", async (event) => {
+		event.target.disabled = true;
+		try {
+			const secret = await request("secret/" + encodeURIComponent(match[1]));
+			result.textContent = secret.secretText;
+		} catch (err) {
+			result.textContent = err.message;
+		}
+		history.replaceState(null, "", location.pathname);
+	});
+} else {
+	showSession().catch((err) => {
+		result.textContent = err.message;
+	});
+}
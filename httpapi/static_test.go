@@ -0,0 +1,73 @@
+package httpapi_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	sst "github.com/evsan/secret-server-task"
+	"github.com/evsan/secret-server-task/httpapi"
+)
+
+func TestWithStatic(t *testing.T) {
+	h := httpapi.New(sst.NewMemStorage(), httpapi.WithMetrics(nil), httpapi.WithStatic(httpapi.WebUI()))
+
+	testCases := map[string]struct {
+		path         string
+		status       int
+		contentType  string
+		cacheControl string
+	}{
+		"index":  {"/", http.StatusOK, "text/html; charset=utf-8", "no-cache"},
+		"script": {"/app.js", http.StatusOK, "text/javascript; charset=utf-8", "public, max-age=3600"},
+		"style":  {"/style.css", http.StatusOK, "text/css; charset=utf-8", "public, max-age=3600"},
+		"api":    {"/secret/0123456789abcdef0123456789abcdef", http.StatusNotFound, "text/plain; charset=utf-8", ""},
+		"none":   {"/missing.js", http.StatusNotFound, "text/plain; charset=utf-8", ""},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+			req.Header.Set("Accept", "application/json")
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+			if w.Code != tc.status {
+				t.Fatalf("expected: %d, result: %d", tc.status, w.Code)
+			}
+			if ct := w.Header().Get("Content-Type"); ct != tc.contentType {
+				t.Fatalf("expected: %s, result: %s", tc.contentType, ct)
+			}
+			if cc := w.Header().Get("Cache-Control"); cc != tc.cacheControl {
+				t.Fatalf("expected: %s, result: %s", tc.cacheControl, cc)
+			}
+			if tc.status != http.StatusOK {
+				return
+			}
+
+			etag := w.Header().Get("ETag")
+			req = httptest.NewRequest(http.MethodGet, tc.path, nil)
+			req.Header.Set("If-None-Match", etag)
+			w = httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+			if etag == "" || w.Code != http.StatusNotModified {
+				t.Fatalf("expected: %d, result: %d", http.StatusNotModified, w.Code)
+			}
+		})
+	}
+}
+
+func TestStaticHandler_PathPrefix(t *testing.T) {
+	files := fstest.MapFS{"docs/index.html": {Data: []byte("<p>docs</p>")}}
+	h := httpapi.New(sst.NewMemStorage(), httpapi.WithMetrics(nil), httpapi.WithPathPrefix("/secrets"), httpapi.WithStatic(files))
+
+	req := httptest.NewRequest(http.MethodGet, "/secrets/docs/", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "docs") {
+		t.Fatalf("expected: %d docs, result: %d %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	if csp := w.Header().Get("Content-Security-Policy"); csp == "" {
+		t.Fatal("Content-Security-Policy is expected")
+	}
+}
@@ -0,0 +1,245 @@
+package httpapi
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	sst "github.com/evsan/secret-server-task"
+)
+
+// Statuses of the secret in the support preview, the unavailability reasons are sst.UnavailableReason
+const (
+	SupportAvailable       = "available"
+	SupportNotYetAvailable = "not_yet_available"
+)
+
+var errInvalidPreviewToken = errors.New("preview token is invalid, expired or used")
+
+// PreviewTokens issues the single-use tokens of the support preview: the signed tenant, hash, expiry and nonce.
+// The used nonces are kept in the memory until the tokens expire, so the token is used once per process
+type PreviewTokens struct {
+	key []byte
+	ttl time.Duration
+
+	mu   sync.Mutex
+	used map[string]time.Time
+}
+
+// NewPreviewTokens creates the tokens valid for ttl signed with the key, empty generates it.
+// The key is shared by the instances serving the redemption
+func NewPreviewTokens(key []byte, ttl time.Duration) *PreviewTokens {
+	if len(key) == 0 {
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			panic(err)
+		}
+	}
+	return &PreviewTokens{key: key, ttl: ttl, used: map[string]time.Time{}}
+}
+
+// WithPreviewTokens serves POST /admin/secret/{hash}/preview-token issuing the support preview tokens and
+// GET /support/{token} redeeming them. The same tokens must be passed to the admin and the public API
+func WithPreviewTokens(t *PreviewTokens) Option {
+	return func(a *App) {
+		a.previewTokens = t
+	}
+}
+
+// previewClaims is the signed content of the token
+type previewClaims struct {
+	Tenant    string `json:"tenant,omitempty"`
+	Hash      string `json:"hash"`
+	ExpiresAt int64  `json:"exp"`
+	Nonce     string `json:"nonce"`
+}
+
+func (t *PreviewTokens) sign(payload string) string {
+	mac := hmac.New(sha256.New, t.key)
+	mac.Write([]byte(payload))
+	return b64(mac.Sum(nil))
+}
+
+// issue returns the token of the secret and its expiry
+func (t *PreviewTokens) issue(tenant, hash string, now time.Time) (string, time.Time) {
+	expiresAt := now.Add(t.ttl).Truncate(time.Second)
+	claims, _ := json.Marshal(previewClaims{Tenant: tenant, Hash: hash, ExpiresAt: expiresAt.Unix(), Nonce: randomToken()})
+	payload := b64(claims)
+	return payload + "." + t.sign(payload), expiresAt
+}
+
+// redeem verifies the token and marks it used
+func (t *PreviewTokens) redeem(token string, now time.Time) (previewClaims, error) {
+	payload, sig, ok := strings.Cut(token, ".")
+	if !ok || !hmac.Equal([]byte(sig), []byte(t.sign(payload))) {
+		return previewClaims{}, errInvalidPreviewToken
+	}
+	b, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return previewClaims{}, errInvalidPreviewToken
+	}
+	var claims previewClaims
+	if err = json.Unmarshal(b, &claims); err != nil || now.Unix() > claims.ExpiresAt {
+		return previewClaims{}, errInvalidPreviewToken
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for nonce, expiresAt := range t.used {
+		if now.After(expiresAt) {
+			delete(t.used, nonce)
+		}
+	}
+	if _, used := t.used[claims.Nonce]; used {
+		return previewClaims{}, errInvalidPreviewToken
+	}
+	t.used[claims.Nonce] = time.Unix(claims.ExpiresAt, 0)
+	return claims, nil
+}
+
+// PreviewTokenResponse is the body of POST /admin/secret/{hash}/preview-token
+type PreviewTokenResponse struct {
+	Hash      string    `json:"hash" xml:"hash"`
+	Tenant    string    `json:"tenant,omitempty" xml:"tenant,omitempty"`
+	Token     string    `json:"token" xml:"token"`
+	URL       string    `json:"url" xml:"url"`
+	ExpiresAt time.Time `json:"expiresAt" xml:"expiresAt"`
+}
+
+// SupportPreview is the body of GET /support/{token}: the metadata of the secret which explains why
+// the link doesn't work, the text is never included
+type SupportPreview struct {
+	Hash   string `json:"hash" xml:"hash"`
+	Tenant string `json:"tenant,omitempty" xml:"tenant,omitempty"`
+	// Status is available, not_yet_available, expired, consumed or not_found
+	Status         string     `json:"status" xml:"status"`
+	Owner          string     `json:"owner,omitempty" xml:"owner,omitempty"`
+	CreatedAt      *time.Time `json:"createdAt,omitempty" xml:"createdAt,omitempty"`
+	ExpiresAt      *time.Time `json:"expiresAt,omitempty" xml:"expiresAt,omitempty"`
+	NotBefore      *time.Time `json:"notBefore,omitempty" xml:"notBefore,omitempty"`
+	RemainingViews int        `json:"remainingViews" xml:"remainingViews"`
+	Views          int        `json:"views" xml:"views"`
+	// DeletedAt is the time the text of the consumed or expired secret was scrubbed, known from its tombstone
+	DeletedAt *time.Time `json:"deletedAt,omitempty" xml:"deletedAt,omitempty"`
+	// ClaimRequired and RecipientBound mean the plain GET of the link doesn't reveal the secret
+	ClaimRequired  bool   `json:"claimRequired" xml:"claimRequired"`
+	RecipientBound bool   `json:"recipientBound" xml:"recipientBound"`
+	Filename       string `json:"filename,omitempty" xml:"filename,omitempty"`
+}
+
+// timeRef returns the reference of the set time, nil for zero
+func timeRef(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+// adminPreviewTokenHandler issues the single-use token of the support preview of the secret.
+// The tenant= field selects the namespace, reason= is required and recorded, e.g. the ticket
+func (a *App) adminPreviewTokenHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		a.bodyError(w, r, err, "Invalid input", http.StatusBadRequest)
+		return
+	}
+	key, tenant, reason := r.PathValue("hash"), r.FormValue("tenant"), r.FormValue("reason")
+	if reason == "" {
+		http.Error(w, sst.ErrEmptyReason.Error(), http.StatusBadRequest)
+		return
+	}
+	if tenant != "" {
+		if err := sst.ValidateTenant(tenant); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	token, expiresAt := a.previewTokens.issue(tenant, key, time.Now())
+	target := key
+	if tenant != "" {
+		target = tenant + "/" + key
+	}
+	log.Printf("admin: issued preview token of secret %s, reason: %q", target, reason)
+	a.adminHook(r.Context(), AdminEvent{Action: AdminPreviewToken, Target: target, Detail: reason})
+	noStore(w.Header())
+	a.dataResponse(PreviewTokenResponse{
+		Hash:      key,
+		Tenant:    tenant,
+		Token:     token,
+		URL:       a.URL("/support/" + token),
+		ExpiresAt: expiresAt,
+	}, w, r)
+}
+
+// supportPreviewHandler redeems the preview token: the metadata of the secret is returned without
+// consuming a view or revealing the text
+func (a *App) supportPreviewHandler(w http.ResponseWriter, r *http.Request) {
+	claims, err := a.previewTokens.redeem(r.PathValue("token"), time.Now())
+	if err != nil {
+		http.Error(w, "Preview token is invalid, expired or used", http.StatusForbidden)
+		return
+	}
+	st := sst.NewTenantStorage(a.storage, claims.Tenant)
+	p, ok := sst.PeekerOf(st)
+	if !ok {
+		http.Error(w, "Storage doesn't support the preview", http.StatusNotImplemented)
+		return
+	}
+	preview := SupportPreview{Hash: claims.Hash, Tenant: claims.Tenant}
+	s, err := p.Peek(claims.Hash)
+	var unavailable *sst.UnavailableError
+	switch {
+	case err == nil:
+		preview.Status = SupportAvailable
+		preview.Owner = s.Owner
+		preview.CreatedAt = timeRef(s.CreatedAt)
+		preview.ExpiresAt = timeRef(s.ExpiresAt)
+		preview.NotBefore = timeRef(s.NotBefore)
+		preview.RemainingViews = s.RemainingViews
+		preview.Views = s.Views
+		preview.ClaimRequired = s.ClaimRequired
+		preview.RecipientBound = s.Recipient != ""
+		preview.Filename = s.Filename
+	case err == sst.ErrSecretNotYetAvailable:
+		preview.Status = SupportNotYetAvailable
+	case errors.As(err, &unavailable):
+		preview.Status = string(unavailable.Reason)
+		a.tombstonePreview(st, &preview)
+	case errors.Is(err, sst.ErrSecretNotAvailable):
+		preview.Status = string(sst.ReasonNotFound)
+		a.tombstonePreview(st, &preview)
+	case backendUnavailable(err, w):
+		return
+	default:
+		log.Println("support preview: ", err)
+		http.Error(w, "Preview is not available", http.StatusInternalServerError)
+		return
+	}
+	noStore(w.Header())
+	a.dataResponse(preview, w, r)
+}
+
+// tombstonePreview completes the preview of the unavailable secret by its tombstone if the storage keeps it
+func (a *App) tombstonePreview(st sst.Storage, preview *SupportPreview) {
+	reader, ok := sst.TombstoneReaderOf(st)
+	if !ok {
+		return
+	}
+	t, err := reader.Tombstone(preview.Hash)
+	if err != nil {
+		return
+	}
+	preview.Status = string(t.Reason)
+	preview.Owner = t.Owner
+	preview.CreatedAt = timeRef(t.CreatedAt)
+	preview.ExpiresAt = timeRef(t.ExpiresAt)
+	preview.Views = t.Views
+	preview.DeletedAt = timeRef(t.DeletedAt)
+}
@@ -0,0 +1,149 @@
+package httpapi_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	sst "github.com/evsan/secret-server-task"
+	"github.com/evsan/secret-server-task/httpapi"
+)
+
+func TestSupportPreview(t *testing.T) {
+	storage := sst.NewMemStorage(sst.WithMemRetention(time.Hour))
+	live, err := storage.Store("test secret", 2, 0, sst.WithOwner("alice"), sst.WithClaimRequired())
+	if err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+	consumed, err := storage.Store("test secret", 1, 0)
+	if err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+	if _, err = storage.Get(consumed.Hash); err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+	scheduled, err := storage.Store("test secret", 1, 0, sst.WithNotBefore(time.Now().Add(time.Hour)))
+	if err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+	tenant, err := sst.NewTenantStorage(storage, "acme").Store("test secret", 1, 0)
+	if err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+
+	tokens := httpapi.NewPreviewTokens(nil, time.Minute)
+	var events []httpapi.AdminEvent
+	hook := httpapi.WithAdminHook(func(_ context.Context, e httpapi.AdminEvent) { events = append(events, e) })
+	admin := httpapi.NewAdmin(storage, httpapi.WithMetrics(nil), httpapi.WithPreviewTokens(tokens), hook)
+	api := httpapi.New(storage, httpapi.WithMetrics(nil), httpapi.WithPreviewTokens(tokens), httpapi.WithTenants("acme"))
+
+	mint := func(hash string, form url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/admin/secret/"+hash+"/preview-token", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Accept", "application/json")
+		w := httptest.NewRecorder()
+		admin.ServeHTTP(w, req)
+		return w
+	}
+	redeem := func(token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/support/"+token, nil)
+		req.Header.Set("Accept", "application/json")
+		w := httptest.NewRecorder()
+		api.ServeHTTP(w, req)
+		return w
+	}
+
+	testCases := map[string]struct {
+		hash   string
+		tenant string
+		status string
+	}{
+		"live":      {live.Hash, "", httpapi.SupportAvailable},
+		"consumed":  {consumed.Hash, "", string(sst.ReasonConsumed)},
+		"scheduled": {scheduled.Hash, "", httpapi.SupportNotYetAvailable},
+		"unknown":   {sst.GenHashKey(), "", string(sst.ReasonNotFound)},
+		"tenant":    {tenant.Hash, "acme", httpapi.SupportAvailable},
+		"other":     {tenant.Hash, "", string(sst.ReasonNotFound)},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			w := mint(tc.hash, url.Values{"reason": {"ticket 42"}, "tenant": {tc.tenant}})
+			var token httpapi.PreviewTokenResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &token); err != nil {
+				t.Fatal("error is not expected: ", err)
+			}
+			if w.Code != http.StatusOK || !strings.HasSuffix(token.URL, "/support/"+token.Token) || token.ExpiresAt.IsZero() {
+				t.Fatalf("unexpected response: %d %s", w.Code, w.Body.String())
+			}
+
+			w = redeem(token.Token)
+			if w.Code != http.StatusOK || strings.Contains(w.Body.String(), "test secret") {
+				t.Fatalf("unexpected response: %d %s", w.Code, w.Body.String())
+			}
+			var preview httpapi.SupportPreview
+			if err := json.Unmarshal(w.Body.Bytes(), &preview); err != nil {
+				t.Fatal("error is not expected: ", err)
+			}
+			if preview.Status != tc.status || preview.Hash != tc.hash {
+				t.Fatalf("expected: %s, result: %+v", tc.status, preview)
+			}
+			if w = redeem(token.Token); w.Code != http.StatusForbidden {
+				t.Fatalf("expected: %d, result: %d", http.StatusForbidden, w.Code)
+			}
+		})
+	}
+
+	// The preview doesn't consume the views
+	peeker, _ := sst.PeekerOf(storage)
+	if secret, err := peeker.Peek(live.Hash); err != nil || secret.RemainingViews != 2 {
+		t.Fatalf("unexpected secret: %+v %v", secret, err)
+	}
+	if len(events) != len(testCases) || events[0].Action != httpapi.AdminPreviewToken || events[0].Detail != "ticket 42" {
+		t.Fatalf("unexpected events: %+v", events)
+	}
+
+	if w := mint(live.Hash, url.Values{}); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected: %d, result: %d", http.StatusBadRequest, w.Code)
+	}
+	for _, token := range []string{"token", "e30.forged", strings.Repeat("a", 40)} {
+		if w := redeem(token); w.Code != http.StatusForbidden {
+			t.Fatalf("%s: expected: %d, result: %d", token, http.StatusForbidden, w.Code)
+		}
+	}
+
+	// The expired tokens and the tokens of the other key are refused
+	w := mint(live.Hash, url.Values{"reason": {"ticket 42"}})
+	other := httpapi.New(storage, httpapi.WithMetrics(nil), httpapi.WithPreviewTokens(httpapi.NewPreviewTokens(nil, time.Minute)))
+	expired := httpapi.NewPreviewTokens(nil, -time.Minute)
+	expiredAdmin := httpapi.NewAdmin(storage, httpapi.WithMetrics(nil), httpapi.WithPreviewTokens(expired))
+	expiredAPI := httpapi.New(storage, httpapi.WithMetrics(nil), httpapi.WithPreviewTokens(expired))
+	req := httptest.NewRequest(http.MethodPost, "/admin/secret/"+live.Hash+"/preview-token", strings.NewReader("reason=ticket+42"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	expiredToken := httptest.NewRecorder()
+	expiredAdmin.ServeHTTP(expiredToken, req)
+	refused := map[string]struct {
+		handler http.Handler
+		body    []byte
+	}{
+		"other key": {other, w.Body.Bytes()},
+		"expired":   {expiredAPI, expiredToken.Body.Bytes()},
+	}
+	for name, tc := range refused {
+		var token httpapi.PreviewTokenResponse
+		if err := json.Unmarshal(tc.body, &token); err != nil {
+			t.Fatal("error is not expected: ", err)
+		}
+		req = httptest.NewRequest(http.MethodGet, "/support/"+token.Token, nil)
+		w = httptest.NewRecorder()
+		tc.handler.ServeHTTP(w, req)
+		if w.Code != http.StatusForbidden {
+			t.Fatalf("%s: expected: %d, result: %d", name, http.StatusForbidden, w.Code)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package httpapi
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/evsan/secret-server-task/openmetrics"
+	"github.com/evsan/secret-server-task/webhook"
+)
+
+// RequestIDHeader carries the id of the request, it is kept from the client or the proxy and generated otherwise
+const RequestIDHeader = "X-Request-Id"
+
+var requestIDRe = regexp.MustCompile(`^[A-Za-z0-9._:/+=-]{1,128}$`)
+
+type traceKey struct{}
+
+// Trace identifies the request which caused the event, so the share is followed across the webhooks
+// and the audit records
+type Trace struct {
+	RequestID string
+	// Traceparent is the W3C traceparent header of the traced request, empty if it isn't traced
+	Traceparent string
+}
+
+// TraceOf returns the trace of the request served by the API, the zero Trace outside of it
+func TraceOf(ctx context.Context) Trace {
+	t, _ := ctx.Value(traceKey{}).(Trace)
+	return t
+}
+
+// TraceID returns the trace id of the traceparent, empty if the request isn't traced
+func (t Trace) TraceID() string {
+	if t.Traceparent == "" {
+		return ""
+	}
+	return strings.Split(t.Traceparent, "-")[1]
+}
+
+// event attaches the trace to the webhook event
+func (t Trace) event(e webhook.Event) webhook.Event {
+	e.RequestID, e.Traceparent = t.RequestID, t.Traceparent
+	return e
+}
+
+// traceRequest records the trace of the request in its context and returns the request id in the response
+func traceRequest(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t := Trace{RequestID: r.Header.Get(RequestIDHeader)}
+		if !requestIDRe.MatchString(t.RequestID) {
+			t.RequestID = newRequestID()
+		}
+		if openmetrics.TraceID(r) != "" {
+			t.Traceparent = r.Header.Get("traceparent")
+		}
+		w.Header().Set(RequestIDHeader, t.RequestID)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), traceKey{}, t)))
+	})
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
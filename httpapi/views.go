@@ -0,0 +1,96 @@
+package httpapi
+
+import (
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	sst "github.com/evsan/secret-server-task"
+)
+
+// ViewHistoryResponse is the body of GET /secret/{hash}/views
+type ViewHistoryResponse struct {
+	Hash  string     `json:"hash" xml:"hash"`
+	Views []sst.View `json:"views" xml:"views>view"`
+}
+
+// WithViewRequester records the address and the User-Agent of the recipients in the view history.
+// Only the time of the views is recorded without it
+func WithViewRequester() Option {
+	return func(a *App) {
+		a.viewRequester = true
+	}
+}
+
+// WithoutViewHeaders hides X-Secret-Remaining-Views and X-Secret-Expires-At of the secrets from the headers
+// of all responses, also the raw and the file ones, e.g. where the proxies log the headers
+func WithoutViewHeaders() Option {
+	return func(a *App) {
+		a.noViewHeaders = true
+	}
+}
+
+// viewHeaders sets X-Secret-Remaining-Views and X-Secret-Expires-At of the written secret,
+// so the clients warn about the last view without parsing the body
+func (a *App) viewHeaders(h http.Header, s sst.Secret) {
+	if a.noViewHeaders {
+		return
+	}
+	h.Set("X-Secret-Remaining-Views", strconv.Itoa(s.RemainingViews))
+	if !s.ExpiresAt.IsZero() {
+		h.Set("X-Secret-Expires-At", s.ExpiresAt.Format(time.RFC3339))
+	}
+}
+
+// recordView appends the served view to the history if the storage keeps it
+func (a *App) recordView(st sst.Storage, s sst.Secret, r *http.Request) {
+	h, ok := sst.ViewHistoryOf(st)
+	if !ok {
+		return
+	}
+	view := sst.View{At: time.Now()}
+	if a.viewRequester {
+		view.RemoteAddr, _, _ = net.SplitHostPort(r.RemoteAddr)
+		view.UserAgent = r.UserAgent()
+	}
+	if err := h.RecordView(s, view); err != nil {
+		log.Println("view history: ", err)
+	}
+}
+
+// viewsHandler returns the view history of the secret to its owner identified by the api key.
+// The secrets of the other owners are reported as not found, so their existence isn't revealed
+func (a *App) viewsHandler(w http.ResponseWriter, r *http.Request) {
+	tenant, ok := a.requestTenant(w, r)
+	if !ok {
+		return
+	}
+	h, ok := sst.ViewHistoryOf(sst.NewTenantStorage(a.storage, tenant))
+	if !ok {
+		http.Error(w, "Storage doesn't keep the view history", http.StatusNotImplemented)
+		return
+	}
+	key := r.PathValue("hash")
+	if !a.validHash(key) {
+		http.Error(w, "Secret not found", http.StatusNotFound)
+		return
+	}
+	owner, views, err := h.Views(key)
+	if errors.Is(err, sst.ErrSecretNotAvailable) || (err == nil && owner != requestOwner(r)) {
+		http.Error(w, "Secret not found", http.StatusNotFound)
+		return
+	}
+	if backendUnavailable(err, w) {
+		return
+	}
+	if err != nil {
+		log.Println("view history: ", err)
+		http.Error(w, "View history is not available", http.StatusInternalServerError)
+		return
+	}
+	// The history is only appended, the last view is the last modification
+	a.metadataResponse(ViewHistoryResponse{Hash: key, Views: views}, views[len(views)-1].At, w, r)
+}
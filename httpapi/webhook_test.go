@@ -0,0 +1,109 @@
+package httpapi_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	sst "github.com/evsan/secret-server-task"
+	"github.com/evsan/secret-server-task/httpapi"
+	"github.com/evsan/secret-server-task/webhook"
+)
+
+func TestWebhook(t *testing.T) {
+	events := make(chan webhook.Event, 2)
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var e webhook.Event
+		if err := json.NewDecoder(r.Body).Decode(&e); err != nil {
+			t.Error("error is not expected: ", err)
+		}
+		events <- e
+	}))
+	defer target.Close()
+
+	storage := sst.NewMemStorage()
+	h := httpapi.New(storage, httpapi.WithMetrics(nil), httpapi.WithWebhookHosts("127.0.0.1"))
+	post := func(webhookURL string) *httptest.ResponseRecorder {
+		form := url.Values{"secret": {"test secret"}, "expireAfterViews": {"2"}, "expireAfter": {"0"}, "webhookUrl": {webhookURL}}
+		req := httptest.NewRequest(http.MethodPost, "/secret", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Accept", "application/json")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := post("https://example.com/hook"); w.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("expected: %d, result: %d", http.StatusMethodNotAllowed, w.Code)
+	}
+
+	w := post(target.URL + "/hook")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected: %d, result: %d", http.StatusOK, w.Code)
+	}
+	if strings.Contains(w.Body.String(), target.URL) {
+		t.Fatal("webhook URL should not be exposed")
+	}
+	var secret sst.Secret
+	if err := json.Unmarshal(w.Body.Bytes(), &secret); err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+
+	for _, expected := range []string{webhook.Viewed, webhook.Consumed} {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/secret/"+secret.Hash, nil))
+		select {
+		case e := <-events:
+			if e.Event != expected || e.Hash != secret.Hash {
+				t.Fatalf("expected: %s, result: %s", expected, e.Event)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("webhook is not called")
+		}
+	}
+}
+
+func TestWebhook_Trace(t *testing.T) {
+	const traceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	headers := make(chan http.Header, 1)
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers <- r.Header
+	}))
+	defer target.Close()
+
+	storage := sst.NewMemStorage()
+	h := httpapi.New(storage, httpapi.WithMetrics(nil), httpapi.WithWebhookHosts("127.0.0.1"))
+	secret, err := storage.Store("test secret", 2, 0, sst.WithWebhookURL(target.URL+"/hook"))
+	if err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/secret/"+secret.Hash, nil)
+	req.Header.Set(httpapi.RequestIDHeader, "req-1")
+	req.Header.Set("traceparent", traceparent)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if id := w.Header().Get(httpapi.RequestIDHeader); id != "req-1" {
+		t.Fatalf("expected: %s, result: %s", "req-1", id)
+	}
+	select {
+	case header := <-headers:
+		if header.Get("X-Request-Id") != "req-1" || header.Get("traceparent") != traceparent {
+			t.Fatalf("trace is not propagated: %v", header)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook is not called")
+	}
+
+	// The invalid request id is replaced by the generated one
+	req = httptest.NewRequest(http.MethodGet, "/secret/missing", nil)
+	req.Header.Set(httpapi.RequestIDHeader, "bad id\x01")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if id := w.Header().Get(httpapi.RequestIDHeader); len(id) != 32 {
+		t.Fatalf("request id is not generated: %q", id)
+	}
+}
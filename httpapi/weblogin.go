@@ -0,0 +1,238 @@
+package httpapi
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Cookies of the web UI login
+const (
+	sessionCookie = "sst_session"
+	loginCookie   = "sst_login"
+)
+
+// loginWindow is how long the user has to finish the login at the provider
+const loginWindow = 10 * time.Minute
+
+// Identity is the user logged in by the provider
+type Identity struct {
+	Subject string `json:"sub"`
+	Email   string `json:"email,omitempty"`
+	Name    string `json:"name,omitempty"`
+}
+
+// owner returns the owner of the secrets created in the session
+func (id Identity) owner() string {
+	if id.Email != "" {
+		return id.Email
+	}
+	return id.Subject
+}
+
+// WebLogin logs the users of the web UI in with the authorization code flow and PKCE, e.g. the OpenID Connect provider
+type WebLogin interface {
+	// AuthCodeURL returns the login page of the provider, which redirects to the redirect URL with the code and the state
+	AuthCodeURL(state, nonce, codeChallenge, redirectURL string) string
+	// Exchange redeems the code with the PKCE verifier and returns the user whose ID token has the nonce
+	Exchange(ctx context.Context, code, codeVerifier, redirectURL, nonce string) (Identity, error)
+}
+
+// Session is the response of GET /auth/session
+type Session struct {
+	Identity
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// loginState is kept in the cookie between the redirect to the provider and the callback
+type loginState struct {
+	State     string    `json:"state"`
+	Nonce     string    `json:"nonce"`
+	Verifier  string    `json:"verifier"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// webSessions issues and verifies the cookies of the login, they are the JSON signed with HMAC
+type webSessions struct {
+	login WebLogin
+	key   []byte
+	ttl   time.Duration
+}
+
+var errInvalidCookie = errors.New("invalid cookie")
+
+// WithWebLogin restricts the creation of the secrets without the api key to the users logged in with the
+// web login, the retrievals stay public. The session cookies are signed with the key, it must be the same on
+// all the instances; empty generates the key at start, so the sessions end when the process does.
+// The sessions last for ttl. The callback is /auth/callback under the base URL, which must be set
+func WithWebLogin(login WebLogin, key []byte, ttl time.Duration) Option {
+	return func(a *App) {
+		if len(key) == 0 {
+			key = make([]byte, 32)
+			if _, err := rand.Read(key); err != nil {
+				panic(err)
+			}
+		}
+		a.webLogin = &webSessions{login: login, key: key, ttl: ttl}
+	}
+}
+
+func (s *webSessions) seal(v interface{}) (string, error) {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return b64(payload) + "." + s.sign(payload), nil
+}
+
+func (s *webSessions) open(value string, v interface{}) error {
+	payload64, sig, ok := strings.Cut(value, ".")
+	if !ok {
+		return errInvalidCookie
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(payload64)
+	if err != nil || !hmac.Equal([]byte(sig), []byte(s.sign(payload))) {
+		return errInvalidCookie
+	}
+	return json.Unmarshal(payload, v)
+}
+
+func (s *webSessions) sign(payload []byte) string {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write(payload)
+	return b64(mac.Sum(nil))
+}
+
+// session returns the valid session of the request
+func (s *webSessions) session(r *http.Request, now time.Time) (Session, bool) {
+	c, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return Session{}, false
+	}
+	var session Session
+	if s.open(c.Value, &session) != nil || !now.Before(session.ExpiresAt) {
+		return Session{}, false
+	}
+	return session, true
+}
+
+// randomToken returns the random URL safe string for the state, the nonce and the PKCE verifier
+func randomToken() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return b64(b)
+}
+
+// cookie returns the cookie of the API paths, secure if the API is served over HTTPS
+func (a *App) cookie(name, value, path string, expires time.Time) *http.Cookie {
+	return &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     path,
+		Expires:  expires,
+		MaxAge:   int(time.Until(expires).Seconds()),
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(a.baseURL, "https://"),
+		// Lax sends the cookie with the redirect from the provider, but not with the cross-site POST
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+// creatorMiddleware identifies the creator by the api key or, with the web login, by the session.
+// With the web login the creation without either is refused
+func (a *App) creatorMiddleware(next http.Handler) http.Handler {
+	keys := a.apiKeys.Middleware(a.requireAPIKey)(next)
+	if a.webLogin == nil {
+		return keys
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if apiKeyFromRequest(r) != "" {
+			keys.ServeHTTP(w, r)
+			return
+		}
+		session, ok := a.webLogin.session(r, time.Now())
+		if !ok {
+			http.Error(w, "Login is required", http.StatusUnauthorized)
+			return
+		}
+		key := APIKey{Owner: session.owner()}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), apiKeyCtxKey, key)))
+	})
+}
+
+// loginHandler redirects to the provider with the new state, nonce and PKCE challenge
+func (a *App) loginHandler(w http.ResponseWriter, r *http.Request) {
+	state := loginState{State: randomToken(), Nonce: randomToken(), Verifier: randomToken(), ExpiresAt: time.Now().Add(loginWindow)}
+	value, err := a.webLogin.seal(state)
+	if err != nil {
+		http.Error(w, "Login failed", http.StatusInternalServerError)
+		return
+	}
+	challenge := sha256.Sum256([]byte(state.Verifier))
+	http.SetCookie(w, a.cookie(loginCookie, value, a.Path("/auth/"), state.ExpiresAt))
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, a.webLogin.login.AuthCodeURL(state.State, state.Nonce, b64(challenge[:]), a.URL("/auth/callback")), http.StatusFound)
+}
+
+// callbackHandler redeems the code of the provider and starts the session
+func (a *App) callbackHandler(w http.ResponseWriter, r *http.Request) {
+	var state loginState
+	c, err := r.Cookie(loginCookie)
+	if err == nil {
+		err = a.webLogin.open(c.Value, &state)
+	}
+	query := r.URL.Query()
+	if err != nil || !time.Now().Before(state.ExpiresAt) || !hmac.Equal([]byte(query.Get("state")), []byte(state.State)) {
+		http.Error(w, "Invalid login state, try to log in again", http.StatusBadRequest)
+		return
+	}
+	// The state is used once
+	http.SetCookie(w, a.cookie(loginCookie, "", a.Path("/auth/"), time.Unix(0, 0)))
+	if e := query.Get("error"); e != "" {
+		log.Printf("web login: provider refused the login: %s %s", e, query.Get("error_description"))
+		http.Error(w, "Login failed", http.StatusUnauthorized)
+		return
+	}
+	id, err := a.webLogin.login.Exchange(r.Context(), query.Get("code"), state.Verifier, a.URL("/auth/callback"), state.Nonce)
+	if err != nil {
+		log.Println("web login: ", err)
+		http.Error(w, "Login failed", http.StatusUnauthorized)
+		return
+	}
+	session := Session{Identity: id, ExpiresAt: time.Now().Add(a.webLogin.ttl)}
+	value, err := a.webLogin.seal(session)
+	if err != nil {
+		http.Error(w, "Login failed", http.StatusInternalServerError)
+		return
+	}
+	http.SetCookie(w, a.cookie(sessionCookie, value, a.Path("/"), session.ExpiresAt))
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, a.Path("/"), http.StatusFound)
+}
+
+// logoutHandler ends the session of the browser
+func (a *App) logoutHandler(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, a.cookie(sessionCookie, "", a.Path("/"), time.Unix(0, 0)))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// sessionHandler returns the logged in user, the UI shows the login link on 401
+func (a *App) sessionHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	session, ok := a.webLogin.session(r, time.Now())
+	if !ok {
+		http.Error(w, "Login is required", http.StatusUnauthorized)
+		return
+	}
+	a.dataResponse(session, w, r)
+}
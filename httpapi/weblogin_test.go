@@ -0,0 +1,153 @@
+package httpapi_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	sst "github.com/evsan/secret-server-task"
+	"github.com/evsan/secret-server-task/httpapi"
+)
+
+// fakeLogin accepts the code "good" redeemed with the verifier of the challenge
+type fakeLogin struct {
+	challenge, nonce string
+}
+
+func (l *fakeLogin) AuthCodeURL(state, nonce, codeChallenge, redirectURL string) string {
+	l.challenge, l.nonce = codeChallenge, nonce
+	return "https://idp.example.com/authorize?" + url.Values{"state": {state}, "redirect_uri": {redirectURL}}.Encode()
+}
+
+func (l *fakeLogin) Exchange(_ context.Context, code, codeVerifier, _, nonce string) (httpapi.Identity, error) {
+	sum := sha256.Sum256([]byte(codeVerifier))
+	if code != "good" || base64.RawURLEncoding.EncodeToString(sum[:]) != l.challenge || nonce != l.nonce {
+		return httpapi.Identity{}, errors.New("invalid grant")
+	}
+	return httpapi.Identity{Subject: "1234", Email: "alice@example.com"}, nil
+}
+
+func TestWithWebLogin(t *testing.T) {
+	storage := sst.NewMemStorage()
+	keys := httpapi.APIKeys{"ci-key": {Key: "ci-key", Owner: "ci"}}
+	handler := httpapi.New(storage, httpapi.WithMetrics(nil), httpapi.WithAPIKeys(keys, false),
+		httpapi.WithBaseURL("https://secrets.example.com"), httpapi.WithWebLogin(&fakeLogin{}, nil, time.Hour))
+	do := func(method, target string, header http.Header, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+		var body *strings.Reader
+		if method == http.MethodPost {
+			body = strings.NewReader(url.Values{"secret": {"test secret"}, "expireAfterViews": {"2"}, "expireAfter": {"0"}}.Encode())
+		} else {
+			body = strings.NewReader("")
+		}
+		req := httptest.NewRequest(method, target, body)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Accept", "application/json")
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+	cookie := func(w *httptest.ResponseRecorder, name string) *http.Cookie {
+		for _, c := range w.Result().Cookies() {
+			if c.Name == name {
+				return c
+			}
+		}
+		t.Fatalf("cookie %s is not set: %v", name, w.Header())
+		return nil
+	}
+
+	if w := do(http.MethodPost, "/secret", nil); w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected: %d, result: %d", http.StatusUnauthorized, w.Code)
+	}
+	if w := do(http.MethodPost, "/secret", http.Header{"X-Api-Key": {"ci-key"}}); w.Code != http.StatusOK {
+		t.Fatalf("expected: %d, result: %d", http.StatusOK, w.Code)
+	}
+	if w := do(http.MethodGet, "/auth/session", nil); w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected: %d, result: %d", http.StatusUnauthorized, w.Code)
+	}
+
+	w := do(http.MethodGet, "/auth/login", nil)
+	location, err := url.Parse(w.Header().Get("Location"))
+	if w.Code != http.StatusFound || err != nil || location.Host != "idp.example.com" {
+		t.Fatalf("unexpected redirect: %d %s", w.Code, w.Header().Get("Location"))
+	}
+	if redirect := location.Query().Get("redirect_uri"); redirect != "https://secrets.example.com/auth/callback" {
+		t.Fatalf("expected: %s, result: %s", "https://secrets.example.com/auth/callback", redirect)
+	}
+	login := cookie(w, "sst_login")
+	if !login.HttpOnly || !login.Secure || login.SameSite != http.SameSiteLaxMode {
+		t.Fatalf("unexpected cookie: %+v", login)
+	}
+	state := location.Query().Get("state")
+
+	for name, target := range map[string]string{
+		"no state":    "/auth/callback?code=good",
+		"other state": "/auth/callback?code=good&state=forged",
+	} {
+		if w := do(http.MethodGet, target, nil, login); w.Code != http.StatusBadRequest {
+			t.Fatalf("%s: expected: %d, result: %d", name, http.StatusBadRequest, w.Code)
+		}
+	}
+	if w := do(http.MethodGet, "/auth/callback?code=good&state="+state, nil); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected: %d, result: %d", http.StatusBadRequest, w.Code)
+	}
+	if w := do(http.MethodGet, "/auth/callback?code=bad&state="+state, nil, login); w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected: %d, result: %d", http.StatusUnauthorized, w.Code)
+	}
+
+	w = do(http.MethodGet, "/auth/callback?code=good&state="+state, nil, login)
+	if w.Code != http.StatusFound || w.Header().Get("Location") != "/" {
+		t.Fatalf("unexpected redirect: %d %s", w.Code, w.Header().Get("Location"))
+	}
+	session := cookie(w, "sst_session")
+
+	w = do(http.MethodGet, "/auth/session", nil, session)
+	var resp httpapi.Session
+	if err = json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+	if w.Code != http.StatusOK || resp.Email != "alice@example.com" || resp.Subject != "1234" {
+		t.Fatalf("unexpected session: %d %s", w.Code, w.Body.String())
+	}
+
+	w = do(http.MethodPost, "/secret", nil, session)
+	var secret sst.Secret
+	if err = json.Unmarshal(w.Body.Bytes(), &secret); err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+	stored, err := storage.Get(secret.Hash)
+	if err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+	if stored.Owner != "alice@example.com" {
+		t.Fatalf("expected: %s, result: %s", "alice@example.com", stored.Owner)
+	}
+	// The retrieval stays public
+	if w := do(http.MethodGet, "/secret/"+secret.Hash, nil); w.Code != http.StatusOK {
+		t.Fatalf("expected: %d, result: %d", http.StatusOK, w.Code)
+	}
+
+	forged := *session
+	forged.Value = strings.Replace(forged.Value, ".", "x.", 1)
+	if w := do(http.MethodPost, "/secret", nil, &forged); w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected: %d, result: %d", http.StatusUnauthorized, w.Code)
+	}
+	w = do(http.MethodPost, "/auth/logout", nil, session)
+	if w.Code != http.StatusNoContent || cookie(w, "sst_session").MaxAge >= 0 {
+		t.Fatalf("unexpected logout: %d %v", w.Code, w.Header())
+	}
+}
@@ -0,0 +1,113 @@
+package httpapi
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// writeRetryAfter is the Retry-After of the creation refused by the saturated queue, in seconds
+const writeRetryAfter = "1"
+
+// errWriteQueueFull is returned if all the workers are busy and the queue is full
+var errWriteQueueFull = errors.New("write queue is full")
+
+// writeQueue limits the concurrent storage writes of the creations. The requests wait in the queue
+// for the workers, the storage sees at most workers inserts at once however many requests arrive
+type writeQueue struct {
+	workers int
+	jobs    chan func()
+	once    sync.Once
+	// rejected counts the creations refused because the queue was full
+	rejected uint64
+}
+
+// WithWriteQueue stores the secrets created by POST /secret on the workers, at most depth creations wait
+// for them. The creations arriving to the full queue are refused with 503 and Retry-After.
+// Zero workers disable the queue, every request writes to the storage itself
+func WithWriteQueue(workers, depth int) Option {
+	return func(a *App) {
+		if workers <= 0 {
+			a.writeQueue = nil
+			return
+		}
+		if depth < 0 {
+			depth = 0
+		}
+		a.writeQueue = &writeQueue{workers: workers, jobs: make(chan func(), depth)}
+	}
+}
+
+// start runs the workers, they live as long as the process
+func (q *writeQueue) start() {
+	for i := 0; i < q.workers; i++ {
+		go func() {
+			for job := range q.jobs {
+				job()
+			}
+		}()
+	}
+}
+
+// Job states, the worker and the request which gave up waiting race for the pending job
+const (
+	jobPending int32 = iota
+	jobTaken
+	jobAbandoned
+)
+
+// do runs fn on a worker and waits for it. It returns errWriteQueueFull at once if the queue is full.
+// The request which is gone or past its deadline before a worker took the job abandons it,
+// the job taken by the worker is waited for, so the stored secret is always reported
+func (q *writeQueue) do(ctx context.Context, fn func()) error {
+	if q == nil {
+		fn()
+		return nil
+	}
+	q.once.Do(q.start)
+	var state int32
+	done := make(chan struct{})
+	job := func() {
+		defer close(done)
+		if atomic.CompareAndSwapInt32(&state, jobPending, jobTaken) {
+			fn()
+		}
+	}
+	select {
+	case q.jobs <- job:
+	default:
+		atomic.AddUint64(&q.rejected, 1)
+		return errWriteQueueFull
+	}
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		if atomic.CompareAndSwapInt32(&state, jobPending, jobAbandoned) {
+			return ctx.Err()
+		}
+		<-done
+		return nil
+	}
+}
+
+// collectors returns the metrics of the queue
+func (q *writeQueue) collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "secret_post_queue_length",
+			Help: "The number of the creations waiting for the write workers",
+		}, func() float64 {
+			return float64(len(q.jobs))
+		}),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "secret_post_queue_rejected_total",
+			Help: "The total number of the creations refused with 503 because the write queue was full",
+		}, func() float64 {
+			return float64(atomic.LoadUint64(&q.rejected))
+		}),
+	}
+}
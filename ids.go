@@ -0,0 +1,10 @@
+package secret_server_task
+
+import (
+	"crypto/rand"
+	"io"
+)
+
+// idSource is the entropy of the generated ids: the hashes of the secrets, the share links and the jobs.
+// It is crypto/rand unless the binary built with the testids tag seeds it and the UUIDs of GenHashKey by SeedIDs
+var idSource io.Reader = rand.Reader
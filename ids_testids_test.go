@@ -0,0 +1,34 @@
+//go:build testids
+
+package secret_server_task_test
+
+import (
+	"testing"
+
+	sst "github.com/evsan/secret-server-task"
+)
+
+func TestSeedIDs(t *testing.T) {
+	format := sst.HashFormat{Length: 22, Alphabet: "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789"}
+	generate := func(seed int64) []string {
+		sst.SeedIDs(seed)
+		storage := sst.NewMemStorage()
+		secret, err := storage.Store("test secret", 1, 0)
+		if err != nil {
+			t.Fatal("error is not expected: ", err)
+		}
+		return []string{sst.GenHashKey(), format.Generate(), secret.Hash}
+	}
+	first, second, other := generate(42), generate(42), generate(43)
+	for i := range first {
+		if first[i] != second[i] {
+			t.Fatalf("expected: %s, result: %s", first[i], second[i])
+		}
+		if first[i] == other[i] {
+			t.Fatalf("expected the other id than %s", first[i])
+		}
+	}
+	if !sst.DefaultHashFormat.Match(first[0]) || !format.Match(first[1]) {
+		t.Fatalf("unexpected ids: %v", first)
+	}
+}
@@ -0,0 +1,93 @@
+package secret_server_task
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strings"
+)
+
+// MinLabelIndexKey is the minimum size of the key of the blind index in bytes
+const MinLabelIndexKey = 32
+
+// blindPrefix marks the blinded label values
+const blindPrefix = "hmac-sha256:"
+
+// ErrLabelIndexKey is returned for the key of the blind index shorter than MinLabelIndexKey
+var ErrLabelIndexKey = errors.New("label index key should have at least 32 bytes")
+
+// LabelIndex replaces the label values with their keyed HMAC. The same value always gives the same index,
+// so the secrets are still searched by the labels, but the plaintext is never stored and can't be recovered
+// without brute-forcing the key
+type LabelIndex struct {
+	key []byte
+}
+
+// NewLabelIndex creates the blind index with the key. The replicas and the regions sharing the secrets need the same key
+func NewLabelIndex(key []byte) (*LabelIndex, error) {
+	if len(key) < MinLabelIndexKey {
+		return nil, ErrLabelIndexKey
+	}
+	return &LabelIndex{key: append([]byte(nil), key...)}, nil
+}
+
+// Blind returns the labels with the values replaced by the index. The label key is mixed in, so the equal values
+// of the different labels don't give the same index. The blinded values are kept as they are
+func (x *LabelIndex) Blind(labels Labels) Labels {
+	if len(labels) == 0 {
+		return labels
+	}
+	blinded := make(Labels, len(labels))
+	for k, v := range labels {
+		if strings.HasPrefix(v, blindPrefix) {
+			blinded[k] = v
+			continue
+		}
+		mac := hmac.New(sha256.New, x.key)
+		mac.Write([]byte(k))
+		mac.Write([]byte{0})
+		mac.Write([]byte(v))
+		blinded[k] = blindPrefix + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+	}
+	return blinded
+}
+
+// blindLabelStorage stores the labels of the new secrets blinded by the index
+type blindLabelStorage struct {
+	Storage
+	index *LabelIndex
+}
+
+// NewBlindLabelStorage blinds the labels of the stored secrets with the index.
+// The searches should blind their selectors with StoredLabels
+func NewBlindLabelStorage(st Storage, index *LabelIndex) Storage {
+	return &blindLabelStorage{Storage: st, index: index}
+}
+
+func (st *blindLabelStorage) Store(secret string, expireAfterViews, expireAfter int, opts ...SecretOption) (Secret, error) {
+	// The option is the last one, so it blinds the labels set by the others
+	opts = append(opts[:len(opts):len(opts)], func(s *Secret) {
+		s.Labels = st.index.Blind(s.Labels)
+	})
+	return st.Storage.Store(secret, expireAfterViews, expireAfter, opts...)
+}
+
+func (st *blindLabelStorage) Unwrap() Storage {
+	return st.Storage
+}
+
+// StoredLabels returns the labels as the storage keeps them: blinded if the storage or a storage it wraps
+// blinds them. The label selectors of the searches go through it
+func StoredLabels(st Storage, labels Labels) Labels {
+	for {
+		if b, ok := st.(*blindLabelStorage); ok {
+			return b.index.Blind(labels)
+		}
+		u, ok := st.(Unwrapper)
+		if !ok {
+			return labels
+		}
+		st = u.Unwrap()
+	}
+}
@@ -0,0 +1,107 @@
+package secret_server_task
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"regexp"
+	"sort"
+)
+
+// Label limits
+const (
+	MaxLabels          = 16
+	MaxLabelValueBytes = 256
+)
+
+// ErrInvalidLabels is returned for too many labels, invalid keys or too long values
+var ErrInvalidLabels = errors.New("invalid labels, up to 16 labels with [A-Za-z0-9_.-] keys of 64 characters and values of 256 bytes are allowed")
+
+var labelKeyRe = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+
+// Labels is the small key-value metadata attached by the creator, e.g. ticket ID or environment
+type Labels map[string]string
+
+// Validate checks the limits of the labels
+func (l Labels) Validate() error {
+	if len(l) > MaxLabels {
+		return ErrInvalidLabels
+	}
+	for k, v := range l {
+		if !labelKeyRe.MatchString(k) || len(v) > MaxLabelValueBytes {
+			return ErrInvalidLabels
+		}
+	}
+	return nil
+}
+
+// Match checks that the labels contain all the given ones
+func (l Labels) Match(selector Labels) bool {
+	for k, v := range selector {
+		if value, ok := l[k]; !ok || value != v {
+			return false
+		}
+	}
+	return true
+}
+
+// Value stores the labels as JSON
+func (l Labels) Value() (driver.Value, error) {
+	if l == nil {
+		return "{}", nil
+	}
+	b, err := json.Marshal(map[string]string(l))
+	return string(b), err
+}
+
+// Scan reads the labels stored as JSON
+func (l *Labels) Scan(src interface{}) error {
+	var b []byte
+	switch v := src.(type) {
+	case []byte:
+		b = v
+	case string:
+		b = []byte(v)
+	case nil:
+		*l = nil
+		return nil
+	default:
+		return errors.New("labels should be stored as JSON")
+	}
+	var m map[string]string
+	if err := json.Unmarshal(b, &m); err != nil {
+		return err
+	}
+	if len(m) == 0 {
+		m = nil
+	}
+	*l = m
+	return nil
+}
+
+// MarshalXML writes the labels as <label key="...">value</label> elements sorted by key
+func (l Labels) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	if err := e.EncodeToken(start); err != nil {
+		return err
+	}
+	keys := make([]string, 0, len(l))
+	for k := range l {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		label := xml.StartElement{Name: xml.Name{Local: "label"}, Attr: []xml.Attr{{Name: xml.Name{Local: "key"}, Value: k}}}
+		if err := e.EncodeElement(l[k], label); err != nil {
+			return err
+		}
+	}
+	return e.EncodeToken(start.End())
+}
+
+// WithLabels attaches the labels to the secret
+func WithLabels(labels Labels) SecretOption {
+	return func(s *Secret) {
+		s.Labels = labels
+	}
+}
@@ -0,0 +1,96 @@
+package secret_server_task_test
+
+import (
+	"encoding/xml"
+	"strings"
+	"testing"
+
+	sst "github.com/evsan/secret-server-task"
+)
+
+func TestLabels_Validate(t *testing.T) {
+	tooMany := sst.Labels{}
+	for i := 0; i <= sst.MaxLabels; i++ {
+		tooMany[strings.Repeat("k", i+1)] = "v"
+	}
+	testCases := map[string]struct {
+		labels   sst.Labels
+		expected error
+	}{
+		"empty":       {nil, nil},
+		"valid":       {sst.Labels{"ticket": "OPS-42", "env.name": "prod"}, nil},
+		"invalid key": {sst.Labels{"ticket id": "OPS-42"}, sst.ErrInvalidLabels},
+		"empty key":   {sst.Labels{"": "OPS-42"}, sst.ErrInvalidLabels},
+		"long value":  {sst.Labels{"purpose": strings.Repeat("x", sst.MaxLabelValueBytes+1)}, sst.ErrInvalidLabels},
+		"too many":    {tooMany, sst.ErrInvalidLabels},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			if err := tc.labels.Validate(); err != tc.expected {
+				t.Fatalf("expected: %v, result: %v", tc.expected, err)
+			}
+			_, err := sst.NewSecret(secretText, remainingViews, expiresDelta, sst.WithLabels(tc.labels))
+			if err != tc.expected {
+				t.Fatalf("expected: %v, result: %v", tc.expected, err)
+			}
+		})
+	}
+}
+
+func TestLabels_Storage(t *testing.T) {
+	labels := sst.Labels{"ticket": "OPS-42", "env": "prod"}
+
+	value, err := labels.Value()
+	if err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+	var scanned sst.Labels
+	if err = scanned.Scan([]byte(value.(string))); err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+	if len(scanned) != 2 || !scanned.Match(labels) {
+		t.Fatalf("expected: %v, result: %v", labels, scanned)
+	}
+
+	b, err := xml.Marshal(struct {
+		XMLName xml.Name   `xml:"secret"`
+		Labels  sst.Labels `xml:"labels"`
+	}{Labels: labels})
+	if err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+	expected := `<secret><labels><label key="env">prod</label><label key="ticket">OPS-42</label></labels></secret>`
+	if string(b) != expected {
+		t.Fatalf("expected: %s, result: %s", expected, b)
+	}
+}
+
+func TestLabelIndex(t *testing.T) {
+	if _, err := sst.NewLabelIndex([]byte("short")); err != sst.ErrLabelIndexKey {
+		t.Fatalf("expected: %s, result: %v", sst.ErrLabelIndexKey, err)
+	}
+	index, err := sst.NewLabelIndex([]byte(strings.Repeat("k", sst.MinLabelIndexKey)))
+	if err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+	storage := sst.NewBlindLabelStorage(sst.NewMemStorage(), index)
+	secret, err := storage.Store(secretText, remainingViews, 0, sst.WithLabels(sst.Labels{"ticket": "OPS-42", "env": "OPS-42"}))
+	if err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+	if strings.Contains(secret.Labels["ticket"], "OPS-42") || secret.Labels["ticket"] == secret.Labels["env"] {
+		t.Fatalf("labels should be blinded by the key: %v", secret.Labels)
+	}
+
+	// The selector is blinded the same way, the storage without the index keeps it as is
+	selector := sst.StoredLabels(sst.NewUsageStorage(storage), sst.Labels{"ticket": "OPS-42"})
+	if !secret.Labels.Match(selector) {
+		t.Fatalf("expected: %v, result: %v", secret.Labels, selector)
+	}
+	if plain := sst.StoredLabels(sst.NewMemStorage(), sst.Labels{"ticket": "OPS-42"}); plain["ticket"] != "OPS-42" {
+		t.Fatalf("expected: %s, result: %s", "OPS-42", plain["ticket"])
+	}
+	if blinded := index.Blind(secret.Labels); blinded["ticket"] != secret.Labels["ticket"] {
+		t.Fatal("blinded values should be kept")
+	}
+}
@@ -0,0 +1,94 @@
+// Package leader elects the replica which runs the singleton background jobs, e.g. the janitor.
+// The leader holds the PostgreSQL session level advisory lock: the lock is released by the database
+// when the leader's connection is lost, so another replica takes over on the next attempt.
+// The old leader notices the loss only on its next check, so the jobs must tolerate a short overlap.
+package leader
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultLockID is the advisory lock key used by the secret server replicas
+const DefaultLockID int64 = 0x5ec7e75e
+
+// Elector competes for the advisory lock until the context is canceled
+type Elector struct {
+	db       *sql.DB
+	lockID   int64
+	interval time.Duration
+	leading  int32
+}
+
+// New creates the elector. interval is how often the lock is tried by the followers
+// and how often the leader checks its connection
+func New(db *sql.DB, lockID int64, interval time.Duration) *Elector {
+	return &Elector{db: db, lockID: lockID, interval: interval}
+}
+
+// IsLeader reports whether the replica holds the lock now
+func (e *Elector) IsLeader() bool {
+	return atomic.LoadInt32(&e.leading) == 1
+}
+
+// Run takes part in the election until the context is canceled
+func (e *Elector) Run(ctx context.Context) {
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+	for {
+		if err := e.lead(ctx, ticker.C); err != nil && ctx.Err() == nil {
+			log.Println("leader: ", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// lead takes the lock on the dedicated connection and holds it while the connection is alive
+func (e *Elector) lead(ctx context.Context, tick <-chan time.Time) error {
+	conn, err := e.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	var locked bool
+	if err = conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", e.lockID).Scan(&locked); err != nil || !locked {
+		_ = conn.Close()
+		return err
+	}
+	// Closing the connection releases the lock. ErrBadConn makes database/sql close it
+	// instead of returning it to the pool, otherwise the lock would stay with the idle connection
+	defer func() {
+		_ = conn.Raw(func(interface{}) error { return driver.ErrBadConn })
+	}()
+	e.setLeading(true)
+	defer e.setLeading(false)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-tick:
+			if err = conn.PingContext(ctx); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (e *Elector) setLeading(leading bool) {
+	var v int32
+	if leading {
+		v = 1
+		log.Println("leader: this replica runs the background jobs")
+	} else {
+		log.Println("leader: this replica lost the leadership")
+	}
+	atomic.StoreInt32(&e.leading, v)
+}
@@ -0,0 +1,56 @@
+package leader_test
+
+import (
+	"context"
+	"flag"
+	"testing"
+	"time"
+
+	"github.com/evsan/secret-server-task/leader"
+	"github.com/jmoiron/sqlx"
+	_ "github.com/lib/pq"
+)
+
+var dbUrl = flag.String("dbUrl", "", "db url for integration tests")
+
+func TestIntegrationElector(t *testing.T) {
+	if testing.Short() || *dbUrl == "" {
+		t.Skip()
+	}
+	db := sqlx.MustConnect("postgres", *dbUrl)
+	defer db.Close()
+
+	const lockID = 42
+	interval := 50 * time.Millisecond
+	ctx1, cancel1 := context.WithCancel(context.Background())
+	defer cancel1()
+	first := leader.New(db.DB, lockID, interval)
+	go first.Run(ctx1)
+	waitFor(t, first.IsLeader)
+
+	ctx2, cancel2 := context.WithCancel(context.Background())
+	defer cancel2()
+	second := leader.New(db.DB, lockID, interval)
+	go second.Run(ctx2)
+	time.Sleep(5 * interval)
+	if second.IsLeader() {
+		t.Fatal("only one replica should lead")
+	}
+
+	// Failover after the leader is gone
+	cancel1()
+	waitFor(t, second.IsLeader)
+	if first.IsLeader() {
+		t.Fatal("stopped replica should not lead")
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,275 @@
+package secret_server_task
+
+import (
+	"database/sql"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ShareLink is the link to the secret with its own validity window, so the secret is re-shared without re-uploading it.
+// The id is the bearer token of the link, the hash of the secret isn't revealed to the link holders
+type ShareLink struct {
+	ID string `json:"id" xml:"id" db:"id"`
+	// Hash is the storage key of the secret
+	Hash      string    `json:"-" xml:"-" db:"secret_id"`
+	Owner     string    `json:"-" xml:"-" db:"owner"`
+	CreatedAt time.Time `json:"createdAt" xml:"createdAt" db:"created_at"`
+	ExpiresAt time.Time `json:"expiresAt" xml:"expiresAt" db:"expires_at"`
+	// SingleUse links are opened once, Used is set then
+	SingleUse bool `json:"singleUse" xml:"singleUse" db:"single_use"`
+	Used      bool `json:"used" xml:"used" db:"used"`
+}
+
+// ShareLinks is implemented by the storages which keep the share links of the secrets.
+// The expired links are removed by PurgeExpired, the links of the owner by EraseOwner
+type ShareLinks interface {
+	// CreateLink stores the link, the id should be generated with GenHashKey
+	CreateLink(link ShareLink) error
+	// Links returns the links of the secret in the order they were created
+	Links(key string) ([]ShareLink, error)
+	// UseLink returns the link if it is valid, the single-use link is marked used.
+	// Returns ErrNotFound for the unknown, expired and used links
+	UseLink(id string) (ShareLink, error)
+	// DeleteLink removes the link of the secret. Returns ErrNotFound if the secret has no such link
+	DeleteLink(key, id string) error
+}
+
+// ShareLinksOf returns the share links of the storage or of its base storage
+func ShareLinksOf(st Storage) (ShareLinks, bool) {
+	if l, ok := st.(ShareLinks); ok {
+		return l, true
+	}
+	l, ok := Base(st).(ShareLinks)
+	return l, ok
+}
+
+// usable reports whether the link can be opened at the time
+func (l ShareLink) usable(now time.Time) bool {
+	return now.Before(l.ExpiresAt) && !l.Used
+}
+
+// memLinks are the share links of the in-memory storage
+type memLinks struct {
+	mu    sync.Mutex
+	links map[string]*ShareLink
+}
+
+func (st *memStorage) CreateLink(link ShareLink) error {
+	st.links.mu.Lock()
+	defer st.links.mu.Unlock()
+	st.links.links[link.ID] = &link
+	return nil
+}
+
+func (st *memStorage) Links(key string) ([]ShareLink, error) {
+	st.links.mu.Lock()
+	defer st.links.mu.Unlock()
+	links := []ShareLink{}
+	for _, l := range st.links.links {
+		if l.Hash == key {
+			links = append(links, *l)
+		}
+	}
+	sort.Slice(links, func(i, j int) bool {
+		return links[i].CreatedAt.Before(links[j].CreatedAt)
+	})
+	return links, nil
+}
+
+func (st *memStorage) UseLink(id string) (ShareLink, error) {
+	st.links.mu.Lock()
+	defer st.links.mu.Unlock()
+	l, ok := st.links.links[id]
+	if !ok || !l.usable(st.clock.Now()) {
+		return ShareLink{}, ErrNotFound
+	}
+	l.Used = l.SingleUse
+	return *l, nil
+}
+
+func (st *memStorage) DeleteLink(key, id string) error {
+	st.links.mu.Lock()
+	defer st.links.mu.Unlock()
+	if l, ok := st.links.links[id]; !ok || l.Hash != key {
+		return ErrNotFound
+	}
+	delete(st.links.links, id)
+	return nil
+}
+
+// purgeLinks removes the expired links
+func (st *memStorage) purgeLinks(now time.Time) {
+	st.links.mu.Lock()
+	defer st.links.mu.Unlock()
+	for id, l := range st.links.links {
+		if !now.Before(l.ExpiresAt) {
+			delete(st.links.links, id)
+		}
+	}
+}
+
+// eraseLinks removes the links of the owner
+func (st *memStorage) eraseLinks(owner string) {
+	st.links.mu.Lock()
+	defer st.links.mu.Unlock()
+	for id, l := range st.links.links {
+		if l.Owner == owner {
+			delete(st.links.links, id)
+		}
+	}
+}
+
+const pgLinkColumns = "id, secret_id, owner, created_at, expires_at, single_use, used"
+
+func (st *pgStorage) CreateLink(link ShareLink) error {
+	q := "INSERT INTO secret_link(" + pgLinkColumns + ") values(:id, :secret_id, :owner, :created_at, :expires_at, :single_use, :used)"
+	err := st.retry(false, func() error {
+		_, err := st.db.NamedExec(q, link)
+		return err
+	})
+	if err != nil {
+		return &BackendError{Op: "create link", Err: err}
+	}
+	return nil
+}
+
+func (st *pgStorage) Links(key string) ([]ShareLink, error) {
+	links := []ShareLink{}
+	q := "SELECT " + pgLinkColumns + " FROM secret_link WHERE secret_id=$1 ORDER BY created_at, id"
+	err := st.retry(true, func() error {
+		return st.db.Select(&links, q, key)
+	})
+	if err != nil {
+		return nil, &BackendError{Op: "links", Err: err}
+	}
+	return links, nil
+}
+
+// UseLink marks the single-use link used by the same statement which checks it, so it is opened once
+// by the concurrent requests
+func (st *pgStorage) UseLink(id string) (ShareLink, error) {
+	var link ShareLink
+	q := "UPDATE secret_link SET used = single_use WHERE id=$1 AND expires_at > $2 AND NOT used RETURNING " + pgLinkColumns
+	err := st.retry(false, func() error {
+		return st.db.Get(&link, q, id, st.clock.Now())
+	})
+	if err == sql.ErrNoRows {
+		return ShareLink{}, ErrNotFound
+	}
+	if err != nil {
+		return ShareLink{}, &BackendError{Op: "use link", Err: err}
+	}
+	return link, nil
+}
+
+func (st *pgStorage) DeleteLink(key, id string) error {
+	var res sql.Result
+	err := st.retry(false, func() (err error) {
+		res, err = st.db.Exec("DELETE FROM secret_link WHERE id=$1 AND secret_id=$2", id, key)
+		return err
+	})
+	if err != nil {
+		return &BackendError{Op: "delete link", Err: err}
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// CreateLink stores the link under the storage key of the tenant. The id is prefixed like the keys,
+// so the links of the tenant can't be opened via the other tenants
+func (st *tenantStorage) CreateLink(link ShareLink) error {
+	l, ok := Base(st.Storage).(ShareLinks)
+	if !ok {
+		return ErrSecretNotAvailable
+	}
+	link.ID = tenantKeyPrefix(st.tenant) + link.ID
+	link.Hash = tenantKeyPrefix(st.tenant) + link.Hash
+	return l.CreateLink(link)
+}
+
+// Links returns the links of the secret of the tenant
+func (st *tenantStorage) Links(key string) ([]ShareLink, error) {
+	l, ok := Base(st.Storage).(ShareLinks)
+	if !ok || strings.Contains(key, "/") {
+		return nil, ErrSecretNotAvailable
+	}
+	links, err := l.Links(tenantKeyPrefix(st.tenant) + key)
+	for i := range links {
+		links[i] = st.strip(links[i])
+	}
+	return links, err
+}
+
+// UseLink opens the link of the tenant
+func (st *tenantStorage) UseLink(id string) (ShareLink, error) {
+	l, ok := Base(st.Storage).(ShareLinks)
+	if !ok || strings.Contains(id, "/") {
+		return ShareLink{}, ErrNotFound
+	}
+	link, err := l.UseLink(tenantKeyPrefix(st.tenant) + id)
+	if err != nil {
+		return ShareLink{}, err
+	}
+	return st.strip(link), nil
+}
+
+// DeleteLink removes the link of the secret of the tenant
+func (st *tenantStorage) DeleteLink(key, id string) error {
+	l, ok := Base(st.Storage).(ShareLinks)
+	if !ok || strings.Contains(key, "/") || strings.Contains(id, "/") {
+		return ErrNotFound
+	}
+	return l.DeleteLink(tenantKeyPrefix(st.tenant)+key, tenantKeyPrefix(st.tenant)+id)
+}
+
+// strip removes the prefix of the tenant from the id and the key of the link
+func (st *tenantStorage) strip(link ShareLink) ShareLink {
+	link.ID = strings.TrimPrefix(link.ID, tenantKeyPrefix(st.tenant))
+	link.Hash = strings.TrimPrefix(link.Hash, tenantKeyPrefix(st.tenant))
+	return link
+}
+
+// CreateLink stores the link in the shard the key of the secret is routed to
+func (st *shardedStorage) CreateLink(link ShareLink) error {
+	l, ok := Base(st.route(link.Hash).Storage).(ShareLinks)
+	if !ok {
+		return ErrSecretNotAvailable
+	}
+	return l.CreateLink(link)
+}
+
+// Links returns the links kept by the shard the key is routed to
+func (st *shardedStorage) Links(key string) ([]ShareLink, error) {
+	l, ok := Base(st.route(key).Storage).(ShareLinks)
+	if !ok {
+		return nil, ErrSecretNotAvailable
+	}
+	return l.Links(key)
+}
+
+// UseLink opens the link in the shard which has it. The id doesn't name the secret, so the shards are tried in turn
+func (st *shardedStorage) UseLink(id string) (ShareLink, error) {
+	for _, s := range st.shards {
+		l, ok := Base(s.Storage).(ShareLinks)
+		if !ok {
+			continue
+		}
+		if link, err := l.UseLink(id); err != ErrNotFound {
+			return link, err
+		}
+	}
+	return ShareLink{}, ErrNotFound
+}
+
+// DeleteLink removes the link from the shard the key is routed to
+func (st *shardedStorage) DeleteLink(key, id string) error {
+	l, ok := Base(st.route(key).Storage).(ShareLinks)
+	if !ok {
+		return ErrNotFound
+	}
+	return l.DeleteLink(key, id)
+}
@@ -0,0 +1,50 @@
+package secret_server_task_test
+
+import (
+	"testing"
+	"time"
+
+	sst "github.com/evsan/secret-server-task"
+)
+
+func TestMemStorage_ShareLinks(t *testing.T) {
+	clock := sst.NewManualClock(time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC))
+	mem := sst.NewMemStorage(sst.WithMemClock(clock))
+	storage := sst.NewTenantStorage(mem, "acme")
+	links, ok := sst.ShareLinksOf(storage)
+	if !ok {
+		t.Fatal("share links are expected")
+	}
+	secret, err := storage.Store(secretText, remainingViews, 0)
+	if err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+	link := sst.ShareLink{ID: sst.GenHashKey(), Hash: secret.Hash, CreatedAt: clock.Now(), ExpiresAt: clock.Now().Add(time.Minute)}
+	if err = links.CreateLink(link); err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+
+	// The link of the tenant can't be opened via the other tenant
+	other, _ := sst.ShareLinksOf(sst.NewTenantStorage(mem, "other"))
+	if _, err = other.UseLink(link.ID); err != sst.ErrNotFound {
+		t.Fatalf("expected: %s, result: %v", sst.ErrNotFound, err)
+	}
+	used, err := links.UseLink(link.ID)
+	if err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+	if used.Hash != secret.Hash || used.ID != link.ID {
+		t.Fatalf("unexpected link: %+v", used)
+	}
+
+	clock.Add(time.Minute)
+	if _, err = links.UseLink(link.ID); err != sst.ErrNotFound {
+		t.Fatalf("expected: %s, result: %v", sst.ErrNotFound, err)
+	}
+	if _, err = mem.(sst.Purger).PurgeExpired(); err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+	if list, err := links.Links(secret.Hash); err != nil || len(list) != 0 {
+		t.Fatalf("expected no links, result: %v, %v", list, err)
+	}
+}
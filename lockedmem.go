@@ -0,0 +1,126 @@
+package secret_server_task
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"runtime"
+	"sync"
+)
+
+// ErrLockedMemory is returned by Store if the secret can't be kept in the locked memory,
+// e.g. RLIMIT_MEMLOCK is reached
+var ErrLockedMemory = errors.New("secret can't be kept in the locked memory")
+
+// errLockedMemoryUnsupported is returned on the platforms without mlock
+var errLockedMemoryUnsupported = errors.New("locked memory is not supported on this platform")
+
+// WithLockedMemory keeps the texts of the in-memory storage outside of the Go heap: every text is in its own
+// mapping which is locked in RAM, so it is never swapped, excluded from the core dumps on Linux and surrounded
+// by the inaccessible guard pages. The mapping is zeroed as soon as the secret can't be served anymore.
+// Every secret takes at least three pages of RLIMIT_MEMLOCK
+func WithLockedMemory() MemOption {
+	return func(st *memStorage) {
+		st.locked = true
+	}
+}
+
+// lockedBuffer is the text kept in the locked memory
+type lockedBuffer struct {
+	mu sync.Mutex
+	// mem is the whole mapping including the guard pages, data is the text at the end of the writable pages,
+	// so reading past it faults on the guard page
+	mem, data []byte
+}
+
+func newLockedBuffer(text string) (*lockedBuffer, error) {
+	mem, data, err := allocLocked(len(text))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrLockedMemory, err)
+	}
+	copy(data, text)
+	b := &lockedBuffer{mem: mem, data: data}
+	// The buffer of the secret which was dropped without destroy is still unmapped
+	runtime.SetFinalizer(b, (*lockedBuffer).destroy)
+	return b, nil
+}
+
+// String copies the text to the heap, it is the copy served to the client
+func (b *lockedBuffer) String() string {
+	if b == nil {
+		return ""
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return string(b.data)
+}
+
+// destroy zeroes and unmaps the memory, the buffer is empty afterwards
+func (b *lockedBuffer) destroy() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.mem == nil {
+		return
+	}
+	clear(b.data)
+	freeLocked(b.mem)
+	b.mem, b.data = nil, nil
+}
+
+// LockedText is the text kept in the locked memory outside of the in-memory storage,
+// e.g. the file of the resumable download
+type LockedText struct {
+	b *lockedBuffer
+}
+
+// NewLockedText copies the text to the locked memory, ErrLockedMemory if it can't be locked
+func NewLockedText(text string) (*LockedText, error) {
+	b, err := newLockedBuffer(text)
+	if err != nil {
+		return nil, err
+	}
+	return &LockedText{b: b}, nil
+}
+
+// Len returns the length of the text in bytes, 0 after Destroy
+func (t *LockedText) Len() int {
+	t.b.mu.Lock()
+	defer t.b.mu.Unlock()
+	return len(t.b.data)
+}
+
+// errLockedTextDestroyed is returned by WriteRange if the text was destroyed meanwhile
+var errLockedTextDestroyed = errors.New("locked text is destroyed")
+
+// lockedWriteChunk is the part of the text written under the lock, so the slow writer doesn't block Destroy
+const lockedWriteChunk = 32 << 10
+
+// WriteRange writes the bytes [from, to) of the text straight from the locked memory, without the copy on the heap.
+// It stops with an error if the text is destroyed while it is written
+func (t *LockedText) WriteRange(w io.Writer, from, to int) (int, error) {
+	written := 0
+	for from < to {
+		end := min(from+lockedWriteChunk, to)
+		t.b.mu.Lock()
+		if end > len(t.b.data) {
+			t.b.mu.Unlock()
+			return written, errLockedTextDestroyed
+		}
+		n, err := w.Write(t.b.data[from:end])
+		t.b.mu.Unlock()
+		written += n
+		if err != nil {
+			return written, err
+		}
+		from = end
+	}
+	return written, nil
+}
+
+// Destroy zeroes and unmaps the text
+func (t *LockedText) Destroy() {
+	t.b.destroy()
+}
@@ -0,0 +1,9 @@
+package secret_server_task
+
+import "golang.org/x/sys/unix"
+
+func init() {
+	excludeFromCoreDump = func(b []byte) error {
+		return unix.Madvise(b, unix.MADV_DONTDUMP)
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd)
+
+package secret_server_task
+
+func allocLocked(int) (mem, data []byte, err error) {
+	return nil, nil, errLockedMemoryUnsupported
+}
+
+func freeLocked([]byte) {}
@@ -0,0 +1,49 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd
+
+package secret_server_task
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// excludeFromCoreDump marks the memory as not dumped, it is set on the platforms supporting it
+var excludeFromCoreDump = func([]byte) error { return nil }
+
+// allocLocked maps the guard page, the locked pages fitting n bytes and another guard page.
+// data is the last n bytes of the locked pages
+func allocLocked(n int) (mem, data []byte, err error) {
+	page := os.Getpagesize()
+	pages := (n + page - 1) / page
+	if pages == 0 {
+		pages = 1
+	}
+	size := (pages + 2) * page
+	mem, err = unix.Mmap(-1, 0, size, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_PRIVATE|unix.MAP_ANON)
+	if err != nil {
+		return nil, nil, err
+	}
+	inner := mem[page : size-page]
+	if err = unix.Mprotect(mem[:page], unix.PROT_NONE); err == nil {
+		err = unix.Mprotect(mem[size-page:], unix.PROT_NONE)
+	}
+	if err == nil {
+		err = unix.Mlock(inner)
+	}
+	if err == nil {
+		err = excludeFromCoreDump(inner)
+	}
+	if err != nil {
+		_ = unix.Munmap(mem)
+		return nil, nil, err
+	}
+	return mem, inner[len(inner)-n:], nil
+}
+
+// freeLocked unlocks and unmaps the memory, the caller zeroes it
+func freeLocked(mem []byte) {
+	page := os.Getpagesize()
+	_ = unix.Munlock(mem[page : len(mem)-page])
+	_ = unix.Munmap(mem)
+}
@@ -0,0 +1,59 @@
+package logoutput
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// JournaldSocket is the socket of the native journald protocol
+const JournaldSocket = "/run/systemd/journal/socket"
+
+// Journald sends the entries with the native journald protocol
+type Journald struct {
+	conn       net.Conn
+	identifier string
+}
+
+// DialJournald connects to the journald socket, JournaldSocket if it is empty.
+// The identifier is SYSLOG_IDENTIFIER of the entries
+func DialJournald(socket, identifier string) (*Journald, error) {
+	if socket == "" {
+		socket = JournaldSocket
+	}
+	conn, err := net.Dial("unixgram", socket)
+	if err != nil {
+		return nil, err
+	}
+	return &Journald{conn: conn, identifier: identifier}, nil
+}
+
+// WriteLog sends the entry with the MESSAGE, PRIORITY and SYSLOG_IDENTIFIER fields
+func (j *Journald) WriteLog(p Priority, message string) error {
+	var b bytes.Buffer
+	journalField(&b, "MESSAGE", message)
+	journalField(&b, "PRIORITY", strconv.Itoa(int(p)))
+	if j.identifier != "" {
+		journalField(&b, "SYSLOG_IDENTIFIER", j.identifier)
+	}
+	_, err := j.conn.Write(b.Bytes())
+	return err
+}
+
+func (j *Journald) Close() error {
+	return j.conn.Close()
+}
+
+// journalField writes KEY=value, or the binary form of the values with the newlines:
+// KEY, newline, the little endian 64 bit length and the value
+func journalField(b *bytes.Buffer, key, value string) {
+	if !strings.Contains(value, "\n") {
+		b.WriteString(key + "=" + value + "\n")
+		return
+	}
+	b.WriteString(key + "\n")
+	_ = binary.Write(b, binary.LittleEndian, uint64(len(value)))
+	b.WriteString(value + "\n")
+}
@@ -0,0 +1,77 @@
+// Package logoutput writes the log lines to syslog (RFC 5424) or journald with their priorities,
+// for the hosts where stdout is not collected.
+//
+// The standard logger has no levels, so the priority of the line is derived from its wording with Classify.
+package logoutput
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"sync"
+)
+
+// Priority is the syslog severity of the line
+type Priority int
+
+// Syslog severities
+const (
+	Emergency Priority = iota
+	Alert
+	Critical
+	Error
+	Warning
+	Notice
+	Info
+	Debug
+)
+
+// Output writes the log lines with their priorities
+type Output interface {
+	WriteLog(p Priority, message string) error
+	Close() error
+}
+
+// Classify returns the priority of the message of the standard logger
+func Classify(message string) Priority {
+	m := strings.ToLower(message)
+	switch {
+	case strings.Contains(m, "panic"):
+		return Critical
+	case containsAny(m, "error", "fail", "lost", "not available", "can't", "invalid", "unable"):
+		return Error
+	case containsAny(m, "warning", "refused", "retry"):
+		return Warning
+	}
+	return Info
+}
+
+func containsAny(s string, substrings ...string) bool {
+	for _, sub := range substrings {
+		if strings.Contains(s, sub) {
+			return true
+		}
+	}
+	return false
+}
+
+// Writer adapts the output for log.Logger. Every Write of the logger is one message,
+// its priority is found by Classify
+func Writer(out Output) io.Writer {
+	return &writer{out: out}
+}
+
+type writer struct {
+	mu  sync.Mutex
+	out Output
+}
+
+func (w *writer) Write(b []byte) (int, error) {
+	message := string(bytes.TrimRight(b, "\n"))
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.out.WriteLog(Classify(message), message); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
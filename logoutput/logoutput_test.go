@@ -0,0 +1,161 @@
+package logoutput_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"log"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/evsan/secret-server-task/logoutput"
+)
+
+func TestClassify(t *testing.T) {
+	testCases := map[string]logoutput.Priority{
+		"[api] PANIC: runtime error":                                    logoutput.Critical,
+		"admin: import failed: bad header":                              logoutput.Error,
+		"metrics are not available":                                     logoutput.Error,
+		"upgrade: refused, the secrets would be lost":                   logoutput.Error,
+		"staging only: warning, the faults are injected":                logoutput.Warning,
+		"admin: purged 3 expired secrets":                               logoutput.Info,
+		"[api] 200 | 1ms | localhost | GET /secret/abc":                 logoutput.Info,
+		"upgrade: the new process is serving, draining the connections": logoutput.Info,
+	}
+	for message, expected := range testCases {
+		if p := logoutput.Classify(message); p != expected {
+			t.Fatalf("%s: expected: %d, result: %d", message, expected, p)
+		}
+	}
+}
+
+func TestSyslog_UDP(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+	defer conn.Close()
+
+	s, err := logoutput.DialSyslog("udp://"+conn.LocalAddr().String(), "secret server", nil)
+	if err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+	defer s.Close()
+	if err = s.WriteLog(logoutput.Error, "storage failed"); err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+
+	buf := make([]byte, 1024)
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+	fields := strings.SplitN(string(buf[:n]), " ", 8)
+	if len(fields) != 8 {
+		t.Fatalf("unexpected message: %q", buf[:n])
+	}
+	// daemon facility (3) * 8 + err (3)
+	if fields[0] != "<27>1" || fields[3] != "secret_server" || fields[5] != "-" || fields[6] != "-" || fields[7] != "storage failed" {
+		t.Fatalf("unexpected message: %q", buf[:n])
+	}
+}
+
+func TestSyslog_TCP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+	defer ln.Close()
+	messages := make(chan string, 2)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		for {
+			length, err := r.ReadString(' ')
+			if err != nil {
+				return
+			}
+			n, _ := strconv.Atoi(strings.TrimSpace(length))
+			msg := make([]byte, n)
+			if _, err = io.ReadFull(r, msg); err != nil {
+				return
+			}
+			messages <- string(msg)
+		}
+	}()
+
+	s, err := logoutput.DialSyslog("tcp://"+ln.Addr().String(), "secret-server", nil)
+	if err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+	defer s.Close()
+	logger := log.New(logoutput.Writer(s), "", 0)
+	logger.Println("first line")
+	logger.Println("request failed")
+
+	// The frames are split by the length, the messages themselves may contain the spaces
+	for _, expected := range []string{"<30>1 ", "<27>1 "} {
+		if msg := <-messages; !strings.HasPrefix(msg, expected) {
+			t.Fatalf("expected: %s, result: %q", expected, msg)
+		}
+	}
+}
+
+func TestSyslog_InvalidAddress(t *testing.T) {
+	for _, address := range []string{"", "localhost:514", "http://localhost:514", "tcp://"} {
+		if _, err := logoutput.DialSyslog(address, "", nil); err != logoutput.ErrSyslogAddress {
+			t.Fatalf("%q: expected: %v, result: %v", address, logoutput.ErrSyslogAddress, err)
+		}
+	}
+}
+
+func TestJournald(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "journal.socket")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		t.Skip("unix datagram sockets are not available: ", err)
+	}
+	defer conn.Close()
+
+	j, err := logoutput.DialJournald(socket, "secret-server")
+	if err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+	defer j.Close()
+
+	read := func() []byte {
+		buf := make([]byte, 4096)
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatal("error is not expected: ", err)
+		}
+		return buf[:n]
+	}
+
+	if err = j.WriteLog(logoutput.Warning, "retrying"); err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+	if entry := string(read()); entry != "MESSAGE=retrying\nPRIORITY=4\nSYSLOG_IDENTIFIER=secret-server\n" {
+		t.Fatalf("unexpected entry: %q", entry)
+	}
+
+	message := "PANIC: boom\ngoroutine 1"
+	if err = j.WriteLog(logoutput.Critical, message); err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+	var expected bytes.Buffer
+	expected.WriteString("MESSAGE\n")
+	_ = binary.Write(&expected, binary.LittleEndian, uint64(len(message)))
+	expected.WriteString(message + "\nPRIORITY=2\nSYSLOG_IDENTIFIER=secret-server\n")
+	if entry := read(); !bytes.Equal(entry, expected.Bytes()) {
+		t.Fatalf("unexpected entry: %q", entry)
+	}
+}
@@ -0,0 +1,124 @@
+package logoutput
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// facilityDaemon is the syslog facility of the messages
+const facilityDaemon = 3
+
+// ErrSyslogAddress is returned for the syslog address other than udp://, tcp:// or tls://host:port
+var ErrSyslogAddress = errors.New("invalid syslog address, it should be udp://, tcp:// or tls://host:port")
+
+// Syslog sends the RFC 5424 messages. UDP sends one message per datagram,
+// TCP and TLS frame the messages with the octet counting of RFC 6587 and reconnect after the write error
+type Syslog struct {
+	network, addr string
+	tlsConfig     *tls.Config
+	tag, hostname string
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+// DialSyslog connects to the syslog server at udp://, tcp:// or tls://host:port.
+// The tag is the APP-NAME of the messages
+func DialSyslog(address, tag string, tlsConfig *tls.Config) (*Syslog, error) {
+	u, err := url.Parse(address)
+	if err != nil || u.Host == "" {
+		return nil, ErrSyslogAddress
+	}
+	switch u.Scheme {
+	case "udp", "tcp":
+	case "tls":
+		if tlsConfig == nil {
+			tlsConfig = &tls.Config{}
+		}
+		if tlsConfig.ServerName == "" {
+			tlsConfig = tlsConfig.Clone()
+			tlsConfig.ServerName = u.Hostname()
+		}
+	default:
+		return nil, ErrSyslogAddress
+	}
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "-"
+	}
+	s := &Syslog{network: u.Scheme, addr: u.Host, tlsConfig: tlsConfig, tag: tag, hostname: hostname}
+	if err = s.connect(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Syslog) connect() error {
+	var conn net.Conn
+	var err error
+	dialer := &net.Dialer{Timeout: 5 * time.Second}
+	if s.network == "tls" {
+		conn, err = tls.DialWithDialer(dialer, "tcp", s.addr, s.tlsConfig)
+	} else {
+		conn, err = dialer.Dial(s.network, s.addr)
+	}
+	if err != nil {
+		return err
+	}
+	s.conn = conn
+	return nil
+}
+
+// WriteLog sends the message, the stream is reconnected once if the write fails
+func (s *Syslog) WriteLog(p Priority, message string) error {
+	msg := s.format(p, message, time.Now())
+	if s.network != "udp" {
+		msg = fmt.Sprintf("%d %s", len(msg), msg)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn != nil {
+		if _, err := s.conn.Write([]byte(msg)); err == nil || s.network == "udp" {
+			return err
+		}
+		s.conn.Close()
+		s.conn = nil
+	}
+	if err := s.connect(); err != nil {
+		return err
+	}
+	_, err := s.conn.Write([]byte(msg))
+	return err
+}
+
+// format returns <PRI>1 TIMESTAMP HOSTNAME APP-NAME PROCID MSGID STRUCTURED-DATA MSG
+func (s *Syslog) format(p Priority, message string, at time.Time) string {
+	return fmt.Sprintf("<%d>1 %s %s %s %d - - %s",
+		facilityDaemon*8+int(p), at.Format(time.RFC3339Nano), s.hostname, nilValue(s.tag), os.Getpid(), message)
+}
+
+func (s *Syslog) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
+
+// nilValue replaces the empty header field and the spaces, they separate the fields
+func nilValue(field string) string {
+	if field == "" {
+		return "-"
+	}
+	return strings.ReplaceAll(field, " ", "_")
+}
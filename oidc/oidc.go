@@ -0,0 +1,323 @@
+// Package oidc logs the users of the web UI in with the OpenID Connect provider of the organization:
+// the authorization code flow with PKCE, the ID token is verified with the keys of the provider.
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/evsan/secret-server-task/httpapi"
+)
+
+// Errors of the ID token verification
+var (
+	ErrInvalidToken = errors.New("invalid ID token")
+	ErrUnknownKey   = errors.New("ID token is signed with the unknown key")
+)
+
+// Config of the client registered at the provider
+type Config struct {
+	// Issuer is the URL of the provider, its discovery document is at /.well-known/openid-configuration
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// Scopes are requested on top of openid, email and profile by default
+	Scopes []string
+	// Timeout bounds the requests to the provider
+	Timeout time.Duration
+}
+
+// Provider is the discovered OpenID Connect provider
+type Provider struct {
+	cfg           Config
+	client        *http.Client
+	authEndpoint  string
+	tokenEndpoint string
+	jwksURI       string
+
+	mu   sync.Mutex
+	keys map[string]crypto.PublicKey
+}
+
+// discovery is the part of the provider metadata the login needs
+type discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// New fetches the discovery document of the issuer
+func New(ctx context.Context, cfg Config) (*Provider, error) {
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = []string{"email", "profile"}
+	}
+	p := &Provider{cfg: cfg, client: &http.Client{Timeout: cfg.Timeout}}
+	var d discovery
+	if err := p.getJSON(ctx, strings.TrimSuffix(cfg.Issuer, "/")+"/.well-known/openid-configuration", &d); err != nil {
+		return nil, err
+	}
+	if d.Issuer != cfg.Issuer {
+		return nil, fmt.Errorf("discovered issuer %q doesn't match %q", d.Issuer, cfg.Issuer)
+	}
+	if d.AuthorizationEndpoint == "" || d.TokenEndpoint == "" || d.JWKSURI == "" {
+		return nil, errors.New("discovery document doesn't have the endpoints of the code flow")
+	}
+	p.authEndpoint, p.tokenEndpoint, p.jwksURI = d.AuthorizationEndpoint, d.TokenEndpoint, d.JWKSURI
+	return p, nil
+}
+
+func (p *Provider) getJSON(ctx context.Context, u string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s answered %d", u, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// AuthCodeURL returns the authorization endpoint with the request of the code flow and the S256 challenge
+func (p *Provider) AuthCodeURL(state, nonce, codeChallenge, redirectURL string) string {
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.cfg.ClientID},
+		"redirect_uri":          {redirectURL},
+		"scope":                 {strings.Join(append([]string{"openid"}, p.cfg.Scopes...), " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {codeChallenge},
+		"code_challenge_method": {"S256"},
+	}
+	sep := "?"
+	if strings.Contains(p.authEndpoint, "?") {
+		sep = "&"
+	}
+	return p.authEndpoint + sep + q.Encode()
+}
+
+// tokenResponse is the part of the token response the login needs
+type tokenResponse struct {
+	IDToken string `json:"id_token"`
+	Error   string `json:"error"`
+}
+
+// Exchange redeems the code at the token endpoint and verifies the ID token
+func (p *Provider) Exchange(ctx context.Context, code, codeVerifier, redirectURL, nonce string) (httpapi.Identity, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {redirectURL},
+		"client_id":     {p.cfg.ClientID},
+		"code_verifier": {codeVerifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.tokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return httpapi.Identity{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.cfg.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.cfg.ClientID), url.QueryEscape(p.cfg.ClientSecret))
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return httpapi.Identity{}, err
+	}
+	defer resp.Body.Close()
+	var body tokenResponse
+	if err = json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return httpapi.Identity{}, fmt.Errorf("token endpoint answered %d: %v", resp.StatusCode, err)
+	}
+	if resp.StatusCode != http.StatusOK || body.IDToken == "" {
+		return httpapi.Identity{}, fmt.Errorf("token endpoint answered %d: %s", resp.StatusCode, body.Error)
+	}
+	return p.Verify(ctx, body.IDToken, nonce, time.Now())
+}
+
+// claims are the verified claims of the ID token
+type claims struct {
+	Issuer   string   `json:"iss"`
+	Subject  string   `json:"sub"`
+	Audience audience `json:"aud"`
+	Expiry   int64    `json:"exp"`
+	Nonce    string   `json:"nonce"`
+	Email    string   `json:"email"`
+	// EmailVerified is a pointer, the providers which don't send it are trusted with the email
+	EmailVerified *bool  `json:"email_verified"`
+	Name          string `json:"name"`
+}
+
+// audience is the single audience or the array of them
+type audience []string
+
+func (a *audience) UnmarshalJSON(b []byte) error {
+	var single string
+	if json.Unmarshal(b, &single) == nil {
+		*a = audience{single}
+		return nil
+	}
+	return json.Unmarshal(b, (*[]string)(a))
+}
+
+func (a audience) contains(clientID string) bool {
+	for _, aud := range a {
+		if aud == clientID {
+			return true
+		}
+	}
+	return false
+}
+
+// Verify checks the signature of the ID token, its issuer, audience, expiry and nonce
+func (p *Provider) Verify(ctx context.Context, token, nonce string, now time.Time) (httpapi.Identity, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return httpapi.Identity{}, ErrInvalidToken
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return httpapi.Identity{}, ErrInvalidToken
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return httpapi.Identity{}, ErrInvalidToken
+	}
+	key, err := p.key(ctx, header.Kid)
+	if err != nil {
+		return httpapi.Identity{}, err
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if !verifySignature(header.Alg, key, digest[:], sig) {
+		return httpapi.Identity{}, ErrInvalidToken
+	}
+
+	var c claims
+	if err = decodeSegment(parts[1], &c); err != nil {
+		return httpapi.Identity{}, ErrInvalidToken
+	}
+	switch {
+	case c.Issuer != p.cfg.Issuer:
+		return httpapi.Identity{}, fmt.Errorf("%w: issuer %q", ErrInvalidToken, c.Issuer)
+	case !c.Audience.contains(p.cfg.ClientID):
+		return httpapi.Identity{}, fmt.Errorf("%w: audience %v", ErrInvalidToken, c.Audience)
+	case now.Unix() >= c.Expiry:
+		return httpapi.Identity{}, fmt.Errorf("%w: expired", ErrInvalidToken)
+	case c.Nonce != nonce:
+		return httpapi.Identity{}, fmt.Errorf("%w: nonce", ErrInvalidToken)
+	case c.Subject == "":
+		return httpapi.Identity{}, fmt.Errorf("%w: no subject", ErrInvalidToken)
+	}
+	id := httpapi.Identity{Subject: c.Subject, Email: c.Email, Name: c.Name}
+	if c.EmailVerified != nil && !*c.EmailVerified {
+		id.Email = ""
+	}
+	return id, nil
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// verifySignature checks the RS256 or ES256 signature of the digest, the other algorithms are refused
+func verifySignature(alg string, key crypto.PublicKey, digest, sig []byte) bool {
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		return alg == "RS256" && rsa.VerifyPKCS1v15(k, crypto.SHA256, digest, sig) == nil
+	case *ecdsa.PublicKey:
+		return alg == "ES256" && len(sig) == 64 &&
+			ecdsa.Verify(k, digest, new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:]))
+	}
+	return false
+}
+
+// key returns the signing key of the provider. The keys are fetched again for the unknown kid,
+// so the rotation at the provider doesn't need the restart
+func (p *Provider) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := p.getJSON(ctx, p.jwksURI, &set); err != nil {
+		return nil, err
+	}
+	p.keys = make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if key, ok := k.publicKey(); ok && (k.Use == "" || k.Use == "sig") {
+			p.keys[k.Kid] = key
+		}
+	}
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, ErrUnknownKey
+}
+
+// jwk is the RSA or the P-256 public key of the JWKS, RFC 7517
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+func (k jwk) publicKey() (crypto.PublicKey, bool) {
+	num := func(s string) (*big.Int, bool) {
+		b, err := base64.RawURLEncoding.DecodeString(s)
+		return new(big.Int).SetBytes(b), err == nil && len(b) > 0
+	}
+	switch k.Kty {
+	case "RSA":
+		n, okN := num(k.N)
+		e, okE := num(k.E)
+		if !okN || !okE || !e.IsInt64() {
+			return nil, false
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, true
+	case "EC":
+		x, okX := num(k.X)
+		y, okY := num(k.Y)
+		// ecdsa.Verify refuses the points off the curve
+		if k.Crv != "P-256" || !okX || !okY {
+			return nil, false
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, true
+	}
+	return nil, false
+}
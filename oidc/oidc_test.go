@@ -0,0 +1,188 @@
+package oidc_test
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/evsan/secret-server-task/httpapi"
+	"github.com/evsan/secret-server-task/oidc"
+)
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// provider is the fake OpenID Connect provider signing the ID tokens with the RSA and the P-256 keys
+type provider struct {
+	*httptest.Server
+	rsaKey *rsa.PrivateKey
+	ecKey  *ecdsa.PrivateKey
+}
+
+func newProvider(t *testing.T) *provider {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+	p := &provider{rsaKey: rsaKey, ecKey: ecKey}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 p.URL,
+			"authorization_endpoint": p.URL + "/authorize",
+			"token_endpoint":         p.URL + "/token",
+			"jwks_uri":               p.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("GET /jwks", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{
+			{"kty": "RSA", "kid": "rsa", "use": "sig", "n": b64(rsaKey.N.Bytes()), "e": b64(big.NewInt(int64(rsaKey.E)).Bytes())},
+			{"kty": "EC", "kid": "ec", "crv": "P-256", "x": b64(ecKey.X.FillBytes(make([]byte, 32))), "y": b64(ecKey.Y.FillBytes(make([]byte, 32)))},
+		}})
+	})
+	mux.HandleFunc("POST /token", func(w http.ResponseWriter, r *http.Request) {
+		id, secret, _ := r.BasicAuth()
+		if r.FormValue("code") != "code" || r.FormValue("code_verifier") != "verifier" || id != "ui" || secret != "s3cret" {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error": "invalid_grant"}`))
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]string{"id_token": p.token(t, "ec", "ES256", p.claims("nonce"))})
+	})
+	p.Server = httptest.NewServer(mux)
+	return p
+}
+
+func (p *provider) claims(nonce string) map[string]interface{} {
+	return map[string]interface{}{
+		"iss": p.URL, "sub": "1234", "aud": "ui", "exp": time.Now().Add(time.Minute).Unix(),
+		"nonce": nonce, "email": "alice@example.com", "name": "Alice",
+	}
+}
+
+// token returns the ID token signed with the key of the kid
+func (p *provider) token(t *testing.T, kid, alg string, claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	input := b64(header) + "." + b64(payload)
+	digest := sha256.Sum256([]byte(input))
+	var sig []byte
+	switch kid {
+	case "rsa":
+		var err error
+		if sig, err = rsa.SignPKCS1v15(rand.Reader, p.rsaKey, crypto.SHA256, digest[:]); err != nil {
+			t.Fatal("error is not expected: ", err)
+		}
+	default:
+		r, s, err := ecdsa.Sign(rand.Reader, p.ecKey, digest[:])
+		if err != nil {
+			t.Fatal("error is not expected: ", err)
+		}
+		sig = make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+	}
+	return input + "." + b64(sig)
+}
+
+func TestProvider_Verify(t *testing.T) {
+	p := newProvider(t)
+	defer p.Close()
+	provider, err := oidc.New(context.Background(), oidc.Config{Issuer: p.URL, ClientID: "ui", ClientSecret: "s3cret", Timeout: time.Second})
+	if err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+
+	with := func(key string, value interface{}) map[string]interface{} {
+		claims := p.claims("nonce")
+		claims[key] = value
+		return claims
+	}
+	valid := p.token(t, "ec", "ES256", p.claims("nonce"))
+	testCases := map[string]struct {
+		token string
+		valid bool
+	}{
+		"ES256":          {valid, true},
+		"RS256":          {p.token(t, "rsa", "RS256", p.claims("nonce")), true},
+		"audience array": {p.token(t, "ec", "ES256", with("aud", []string{"other", "ui"})), true},
+		"other audience": {p.token(t, "ec", "ES256", with("aud", "other")), false},
+		"other issuer":   {p.token(t, "ec", "ES256", with("iss", "https://evil.example.com")), false},
+		"expired":        {p.token(t, "ec", "ES256", with("exp", time.Now().Add(-time.Minute).Unix())), false},
+		"other nonce":    {p.token(t, "ec", "ES256", p.claims("replayed")), false},
+		"no subject":     {p.token(t, "ec", "ES256", with("sub", "")), false},
+		"wrong alg":      {p.token(t, "ec", "RS256", p.claims("nonce")), false},
+		"unknown key":    {p.token(t, "other", "ES256", p.claims("nonce")), false},
+		"tampered":       {strings.Replace(valid, ".", ".e", 1), false},
+		"not jws":        {"token", false},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			id, err := provider.Verify(context.Background(), tc.token, "nonce", time.Now())
+			if (err == nil) != tc.valid {
+				t.Fatalf("expected valid: %v, result: %v", tc.valid, err)
+			}
+			if tc.valid && id != (httpapi.Identity{Subject: "1234", Email: "alice@example.com", Name: "Alice"}) {
+				t.Fatalf("unexpected identity: %+v", id)
+			}
+		})
+	}
+
+	// The unverified email isn't the owner
+	id, err := provider.Verify(context.Background(), p.token(t, "ec", "ES256", with("email_verified", false)), "nonce", time.Now())
+	if err != nil || id.Email != "" {
+		t.Fatalf("unexpected identity: %+v %v", id, err)
+	}
+}
+
+func TestProvider_Exchange(t *testing.T) {
+	p := newProvider(t)
+	defer p.Close()
+	provider, err := oidc.New(context.Background(), oidc.Config{Issuer: p.URL, ClientID: "ui", ClientSecret: "s3cret", Timeout: time.Second})
+	if err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+
+	authURL, err := url.Parse(provider.AuthCodeURL("state", "nonce", "challenge", "https://secrets.example.com/auth/callback"))
+	if err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+	q := authURL.Query()
+	if authURL.Path != "/authorize" || q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") != "challenge" ||
+		q.Get("scope") != "openid email profile" || q.Get("client_id") != "ui" || q.Get("state") != "state" {
+		t.Fatalf("unexpected authorization URL: %s", authURL)
+	}
+
+	id, err := provider.Exchange(context.Background(), "code", "verifier", "https://secrets.example.com/auth/callback", "nonce")
+	if err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+	if id.Email != "alice@example.com" {
+		t.Fatalf("expected: %s, result: %s", "alice@example.com", id.Email)
+	}
+	if _, err = provider.Exchange(context.Background(), "other", "verifier", "https://secrets.example.com/auth/callback", "nonce"); err == nil {
+		t.Fatal("error is expected for the invalid grant")
+	}
+
+	if _, err = oidc.New(context.Background(), oidc.Config{Issuer: p.URL + "/other", ClientID: "ui"}); err == nil {
+		t.Fatal("error is expected for the other issuer")
+	}
+}
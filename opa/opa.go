@@ -0,0 +1,80 @@
+// Package opa evaluates the policies of the organization with the REST API of Open Policy Agent.
+// The Rego policies are loaded into the OPA server, the secret server posts the metadata of the requests
+// to the data API of the policy document, e.g. http://localhost:8181/v1/data/secrets/allow.
+package opa
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/evsan/secret-server-task/httpapi"
+)
+
+// ErrUndefined is returned if the policy document is undefined for the input, e.g. the policy isn't loaded.
+// The request is refused, the missing policy must not allow everything
+var ErrUndefined = errors.New("policy decision is undefined")
+
+// Client evaluates the policy document at the URL
+type Client struct {
+	url    string
+	client *http.Client
+}
+
+// New creates the client of the policy document at the URL. The timeout bounds the evaluation of every request
+func New(url string, timeout time.Duration) *Client {
+	return &Client{url: url, client: &http.Client{Timeout: timeout}}
+}
+
+// request is the body of the data API
+type request struct {
+	Input httpapi.PolicyInput `json:"input"`
+}
+
+// response is the body of the data API. The result is either the boolean of allow
+// or the object with allow and the optional reason
+type response struct {
+	Result *json.RawMessage `json:"result"`
+}
+
+// Evaluate posts the input to the data API and returns the decision of the policy
+func (c *Client) Evaluate(ctx context.Context, input httpapi.PolicyInput) (httpapi.PolicyDecision, error) {
+	b, err := json.Marshal(request{Input: input})
+	if err != nil {
+		return httpapi.PolicyDecision{}, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(b))
+	if err != nil {
+		return httpapi.PolicyDecision{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return httpapi.PolicyDecision{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return httpapi.PolicyDecision{}, fmt.Errorf("policy endpoint answered %d", resp.StatusCode)
+	}
+
+	var body response
+	if err = json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return httpapi.PolicyDecision{}, err
+	}
+	if body.Result == nil {
+		return httpapi.PolicyDecision{}, ErrUndefined
+	}
+	var allow bool
+	if json.Unmarshal(*body.Result, &allow) == nil {
+		return httpapi.PolicyDecision{Allow: allow}, nil
+	}
+	var decision httpapi.PolicyDecision
+	if err = json.Unmarshal(*body.Result, &decision); err != nil {
+		return httpapi.PolicyDecision{}, fmt.Errorf("policy result should be the boolean or {\"allow\": ..., \"reason\": ...}: %v", err)
+	}
+	return decision, nil
+}
@@ -0,0 +1,59 @@
+package opa_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/evsan/secret-server-task/httpapi"
+	"github.com/evsan/secret-server-task/opa"
+)
+
+func TestClient_Evaluate(t *testing.T) {
+	testCases := map[string]struct {
+		status   int
+		body     string
+		expected httpapi.PolicyDecision
+		err      bool
+	}{
+		"allowed":       {http.StatusOK, `{"result": true}`, httpapi.PolicyDecision{Allow: true}, false},
+		"denied":        {http.StatusOK, `{"result": false}`, httpapi.PolicyDecision{}, false},
+		"with reason":   {http.StatusOK, `{"result": {"allow": false, "reason": "no secrets over 30 days"}}`, httpapi.PolicyDecision{Reason: "no secrets over 30 days"}, false},
+		"undefined":     {http.StatusOK, `{}`, httpapi.PolicyDecision{}, true},
+		"invalid":       {http.StatusOK, `{"result": "yes"}`, httpapi.PolicyDecision{}, true},
+		"server error":  {http.StatusInternalServerError, `{"code": "internal_error"}`, httpapi.PolicyDecision{}, true},
+		"not json body": {http.StatusOK, `ok`, httpapi.PolicyDecision{}, true},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			var input httpapi.PolicyInput
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var body struct {
+					Input httpapi.PolicyInput `json:"input"`
+				}
+				if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+					t.Error("error is not expected: ", err)
+				}
+				input = body.Input
+				w.WriteHeader(tc.status)
+				_, _ = w.Write([]byte(tc.body))
+			}))
+			defer srv.Close()
+
+			decision, err := opa.New(srv.URL+"/v1/data/secrets/allow", time.Second).Evaluate(context.Background(),
+				httpapi.PolicyInput{Action: httpapi.PolicyRetrieve, Hash: "abc"})
+			if (err != nil) != tc.err {
+				t.Fatalf("expected error: %v, result: %v", tc.err, err)
+			}
+			if decision != tc.expected {
+				t.Fatalf("expected: %+v, result: %+v", tc.expected, decision)
+			}
+			if input.Action != httpapi.PolicyRetrieve || input.Hash != "abc" {
+				t.Fatalf("unexpected input: %+v", input)
+			}
+		})
+	}
+}
@@ -0,0 +1,235 @@
+// Package openmetrics serves the gathered metrics in the OpenMetrics 1.0 text format with the exemplars
+// linking the samples to the traces. The prometheus client in use predates OpenMetrics, so the format
+// is encoded here from the gathered families and the exemplars are kept alongside the collectors.
+package openmetrics
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// ContentType is the media type of the OpenMetrics text format
+const ContentType = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+
+// maxExemplarsPerSeries limits the exemplars of a histogram, they are spread over its buckets
+const maxExemplarsPerSeries = 16
+
+// traceIDLabel is the exemplar label of the trace
+const traceIDLabel = "trace_id"
+
+// Exemplar is the sample of the traced request
+type Exemplar struct {
+	TraceID string
+	Value   float64
+	At      time.Time
+}
+
+// Exemplars keeps the latest exemplars of the series. The zero value is not usable, see NewExemplars
+type Exemplars struct {
+	mu     sync.Mutex
+	series map[string][]Exemplar
+}
+
+// NewExemplars creates the empty exemplar store
+func NewExemplars() *Exemplars {
+	return &Exemplars{series: map[string][]Exemplar{}}
+}
+
+// Record stores the exemplar of the series: the sample name (e.g. "requests_total" or the histogram name)
+// and its labels. The counter shows the latest exemplar, the histogram the latest one of each bucket.
+// Calls on nil and without the trace are ignored, so the callers don't have to check them
+func (e *Exemplars) Record(name string, labels prometheus.Labels, value float64, traceID string) {
+	if e == nil || traceID == "" {
+		return
+	}
+	key := seriesKey(name, labels)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	list := append(e.series[key], Exemplar{TraceID: traceID, Value: value, At: time.Now()})
+	if len(list) > maxExemplarsPerSeries {
+		list = list[len(list)-maxExemplarsPerSeries:]
+	}
+	e.series[key] = list
+}
+
+// latest returns the last exemplar of the series with the value in (lower, upper]
+func (e *Exemplars) latest(key string, lower, upper float64) (Exemplar, bool) {
+	if e == nil {
+		return Exemplar{}, false
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	list := e.series[key]
+	for i := len(list) - 1; i >= 0; i-- {
+		if list[i].Value > lower && list[i].Value <= upper {
+			return list[i], true
+		}
+	}
+	return Exemplar{}, false
+}
+
+func seriesKey(name string, labels prometheus.Labels) string {
+	pairs := make([]string, 0, len(labels))
+	for k, v := range labels {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return name + "{" + strings.Join(pairs, ",") + "}"
+}
+
+// TraceID returns the trace id of the request from the W3C traceparent header: version-traceid-parentid-flags
+func TraceID(r *http.Request) string {
+	parts := strings.Split(r.Header.Get("traceparent"), "-")
+	if len(parts) < 4 || len(parts[1]) != 32 || strings.Trim(parts[1], "0") == "" {
+		return ""
+	}
+	for _, c := range parts[1] {
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f') {
+			return ""
+		}
+	}
+	return parts[1]
+}
+
+// Handler serves the metrics of the gatherer in the OpenMetrics format to the scrapers accepting it,
+// the others are served by the fallback, promhttp.HandlerFor(g) if it is nil
+func Handler(g prometheus.Gatherer, e *Exemplars, fallback http.Handler) http.Handler {
+	if fallback == nil {
+		fallback = promhttp.HandlerFor(g, promhttp.HandlerOpts{})
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.Header.Get("Accept"), "application/openmetrics-text") {
+			fallback.ServeHTTP(w, r)
+			return
+		}
+		families, err := g.Gather()
+		if err != nil && len(families) == 0 {
+			http.Error(w, "Metrics are not available: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", ContentType)
+		_ = Write(w, families, e)
+	})
+}
+
+// Write encodes the families in the OpenMetrics text format terminated with # EOF
+func Write(out io.Writer, families []*dto.MetricFamily, e *Exemplars) error {
+	w := bufio.NewWriter(out)
+	for _, mf := range families {
+		writeFamily(w, mf, e)
+	}
+	_, _ = w.WriteString("# EOF\n")
+	return w.Flush()
+}
+
+func writeFamily(w *bufio.Writer, mf *dto.MetricFamily, e *Exemplars) {
+	name := mf.GetName()
+	typ := "unknown"
+	switch mf.GetType() {
+	case dto.MetricType_COUNTER:
+		// The counter family is named without the suffix of its sample
+		typ, name = "counter", strings.TrimSuffix(name, "_total")
+	case dto.MetricType_GAUGE:
+		typ = "gauge"
+	case dto.MetricType_SUMMARY:
+		typ = "summary"
+	case dto.MetricType_HISTOGRAM:
+		typ = "histogram"
+	}
+	_, _ = w.WriteString("# TYPE " + name + " " + typ + "\n")
+	if mf.Help != nil {
+		_, _ = w.WriteString("# HELP " + name + " " + escape(mf.GetHelp()) + "\n")
+	}
+	for _, m := range mf.Metric {
+		labels := prometheus.Labels{}
+		for _, lp := range m.Label {
+			labels[lp.GetName()] = lp.GetValue()
+		}
+		switch mf.GetType() {
+		case dto.MetricType_COUNTER:
+			sample := name + "_total"
+			ex, ok := e.latest(seriesKey(sample, labels), math.Inf(-1), math.Inf(1))
+			writeSample(w, sample, m.Label, "", "", m.Counter.GetValue(), ex, ok)
+		case dto.MetricType_GAUGE:
+			writeSample(w, name, m.Label, "", "", m.Gauge.GetValue(), Exemplar{}, false)
+		case dto.MetricType_SUMMARY:
+			for _, q := range m.Summary.Quantile {
+				writeSample(w, name, m.Label, "quantile", formatFloat(q.GetQuantile()), q.GetValue(), Exemplar{}, false)
+			}
+			writeSample(w, name+"_sum", m.Label, "", "", m.Summary.GetSampleSum(), Exemplar{}, false)
+			writeSample(w, name+"_count", m.Label, "", "", float64(m.Summary.GetSampleCount()), Exemplar{}, false)
+		case dto.MetricType_HISTOGRAM:
+			key, lower := seriesKey(name, labels), math.Inf(-1)
+			for _, b := range m.Histogram.Bucket {
+				ex, ok := e.latest(key, lower, b.GetUpperBound())
+				writeSample(w, name+"_bucket", m.Label, "le", formatFloat(b.GetUpperBound()), float64(b.GetCumulativeCount()), ex, ok)
+				lower = b.GetUpperBound()
+			}
+			if len(m.Histogram.Bucket) == 0 || !math.IsInf(lower, 1) {
+				ex, ok := e.latest(key, lower, math.Inf(1))
+				writeSample(w, name+"_bucket", m.Label, "le", "+Inf", float64(m.Histogram.GetSampleCount()), ex, ok)
+			}
+			writeSample(w, name+"_sum", m.Label, "", "", m.Histogram.GetSampleSum(), Exemplar{}, false)
+			writeSample(w, name+"_count", m.Label, "", "", float64(m.Histogram.GetSampleCount()), Exemplar{}, false)
+		default:
+			writeSample(w, name, m.Label, "", "", m.Untyped.GetValue(), Exemplar{}, false)
+		}
+	}
+}
+
+// writeSample writes the sample line with the extra label (quantile or le) and the exemplar if any
+func writeSample(w *bufio.Writer, name string, labels []*dto.LabelPair, extraName, extraValue string, value float64, ex Exemplar, hasExemplar bool) {
+	_, _ = w.WriteString(name)
+	if len(labels) > 0 || extraName != "" {
+		_ = w.WriteByte('{')
+		for i, lp := range labels {
+			if i > 0 {
+				_ = w.WriteByte(',')
+			}
+			_, _ = w.WriteString(lp.GetName() + `="` + escape(lp.GetValue()) + `"`)
+		}
+		if extraName != "" {
+			if len(labels) > 0 {
+				_ = w.WriteByte(',')
+			}
+			_, _ = w.WriteString(extraName + `="` + extraValue + `"`)
+		}
+		_ = w.WriteByte('}')
+	}
+	_, _ = w.WriteString(" " + formatFloat(value))
+	if hasExemplar {
+		ts := float64(ex.At.UnixNano()) / 1e9
+		_, _ = w.WriteString(` # {` + traceIDLabel + `="` + ex.TraceID + `"} ` + formatFloat(ex.Value) + " " + strconv.FormatFloat(ts, 'f', 3, 64))
+	}
+	_ = w.WriteByte('\n')
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// escaper escapes the help texts and the label values
+var escaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+func escape(s string) string {
+	return escaper.Replace(s)
+}
@@ -0,0 +1,88 @@
+package openmetrics_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/evsan/secret-server-task/openmetrics"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+
+func TestHandler(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	requests := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "requests_total", Help: "Requests"}, []string{"path"})
+	latency := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "latency_seconds", Help: "Latency", Buckets: []float64{0.1, 1}})
+	queue := prometheus.NewGauge(prometheus.GaugeOpts{Name: "queue", Help: "Queue \"length\""})
+	reg.MustRegister(requests, latency, queue)
+
+	exemplars := openmetrics.NewExemplars()
+	requests.WithLabelValues("/a\nb").Inc()
+	exemplars.Record("requests_total", prometheus.Labels{"path": "/a\nb"}, 1, traceID)
+	latency.Observe(0.5)
+	exemplars.Record("latency_seconds", nil, 0.5, traceID)
+	latency.Observe(2)
+	exemplars.Record("latency_seconds", nil, 2, "")
+	queue.Set(3)
+
+	handler := openmetrics.Handler(reg, exemplars, nil)
+	get := func(accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		req.Header.Set("Accept", accept)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	w := get("application/openmetrics-text; version=1.0.0,text/plain;q=0.5")
+	if ct := w.Header().Get("Content-Type"); ct != openmetrics.ContentType {
+		t.Fatalf("expected: %s, result: %s", openmetrics.ContentType, ct)
+	}
+	body := w.Body.String()
+	expected := []string{
+		"# TYPE requests counter\n",
+		`requests_total{path="/a\nb"} 1 # {trace_id="` + traceID + `"} 1 `,
+		"# TYPE latency_seconds histogram\n",
+		`latency_seconds_bucket{le="0.1"} 0` + "\n",
+		`latency_seconds_bucket{le="1"} 1 # {trace_id="` + traceID + `"} 0.5 `,
+		// The observation without the trace has no exemplar
+		`latency_seconds_bucket{le="+Inf"} 2` + "\n",
+		"latency_seconds_count 2\n",
+		`# HELP queue Queue \"length\"` + "\n",
+		"queue 3\n",
+	}
+	for _, e := range expected {
+		if !strings.Contains(body, e) {
+			t.Fatalf("expected: %q, result:\n%s", e, body)
+		}
+	}
+	if !strings.HasSuffix(body, "# EOF\n") {
+		t.Fatalf("expected the EOF marker, result:\n%s", body)
+	}
+
+	// The Prometheus text format has no exemplars
+	w = get("text/plain")
+	if strings.Contains(w.Body.String(), "trace_id") || !strings.Contains(w.Body.String(), "requests_total") {
+		t.Fatalf("unexpected response:\n%s", w.Body.String())
+	}
+}
+
+func TestTraceID(t *testing.T) {
+	testCases := map[string]string{
+		"00-" + traceID + "-00f067aa0ba902b7-01":                  traceID,
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01": "",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01": "",
+		"garbage": "",
+		"":        "",
+	}
+	for header, expected := range testCases {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("traceparent", header)
+		if result := openmetrics.TraceID(req); result != expected {
+			t.Fatalf("%q: expected: %q, result: %q", header, expected, result)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package secret_server_task
+
+import (
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// OutboxEvent is the webhook notification recorded in the same transaction as the view of the secret
+type OutboxEvent struct {
+	ID    int64  `db:"id"`
+	Event string `db:"event"`
+	// Hash is the key of the secret without the tenant prefix, as it is known to the creator
+	Hash           string    `db:"hash"`
+	URL            string    `db:"url"`
+	RemainingViews int       `db:"remaining_views"`
+	CreatedAt      time.Time `db:"created_at"`
+	Attempts       int       `db:"attempts"`
+}
+
+// Outbox is implemented by the storages which record the webhook notifications transactionally
+type Outbox interface {
+	// ClaimEvents returns the due events and hides them from the other dispatchers for the lease
+	ClaimEvents(limit int, lease time.Duration) ([]OutboxEvent, error)
+	// CompleteEvent removes the delivered or the abandoned event
+	CompleteEvent(id int64) error
+	// RetryEvent schedules the next delivery attempt of the event
+	RetryEvent(id int64, at time.Time) error
+}
+
+// WithPgOutbox records the view notifications of the secrets with the webhook URL in the outbox table,
+// in the transaction which serves the view. They are delivered by the dispatcher, e.g. webhook.Dispatcher
+func WithPgOutbox() PgOption {
+	return func(st *pgStorage) {
+		st.outbox = true
+	}
+}
+
+// addOutboxEvent records the notification of the served view
+func (st *pgStorage) addOutboxEvent(e sqlx.Execer, s Secret) error {
+	if !st.outbox || s.WebhookURL == "" {
+		return nil
+	}
+	event := EventViewed
+	if s.RemainingViews == 0 {
+		event = EventConsumed
+	}
+	now := st.clock.Now()
+	q := "INSERT INTO outbox(event, hash, url, remaining_views, created_at, next_attempt_at) values($1, $2, $3, $4, $5, $5)"
+	_, err := e.Exec(q, event, s.Hash[len(tenantKeyPrefix(s.Tenant)):], s.WebhookURL, s.RemainingViews, now)
+	return err
+}
+
+func (st *pgStorage) ClaimEvents(limit int, lease time.Duration) ([]OutboxEvent, error) {
+	now := st.clock.Now()
+	// SKIP LOCKED lets the dispatchers of all replicas claim the different events at the same time
+	q := `UPDATE outbox SET next_attempt_at = $2 WHERE id IN (
+		SELECT id FROM outbox WHERE next_attempt_at <= $1 ORDER BY id LIMIT $3 FOR UPDATE SKIP LOCKED
+	) RETURNING id, event, hash, url, remaining_views, created_at, attempts`
+	events := []OutboxEvent{}
+	err := st.db.Select(&events, q, now, now.Add(lease), limit)
+	return events, err
+}
+
+func (st *pgStorage) CompleteEvent(id int64) error {
+	_, err := st.db.Exec("DELETE FROM outbox WHERE id=$1", id)
+	return err
+}
+
+func (st *pgStorage) RetryEvent(id int64, at time.Time) error {
+	_, err := st.db.Exec("UPDATE outbox SET attempts = attempts+1, next_attempt_at = $2 WHERE id=$1", id, at)
+	return err
+}
@@ -0,0 +1,114 @@
+package secret_server_task
+
+import (
+	"encoding/json"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// Storage events
+const (
+	EventStored   = "stored"
+	EventViewed   = "viewed"
+	EventConsumed = "consumed"
+	EventExpired  = "expired"
+	EventRevoked  = "revoked"
+	EventErased   = "erased"
+	// EventResync is received after the listener reconnected, the events in between are lost
+	// and the subscribers should reload their state
+	EventResync = "resync"
+)
+
+// StorageEvent is the lifecycle change of the secret published by the PostgreSQL storage.
+// It never contains the secret text
+type StorageEvent struct {
+	Event string `json:"event"`
+	// Hash is the storage key, it is prefixed with the tenant
+	Hash           string    `json:"hash,omitempty"`
+	Tenant         string    `json:"tenant,omitempty"`
+	RemainingViews int       `json:"remainingViews"`
+	At             time.Time `json:"at"`
+}
+
+// WithPgNotify publishes the storage events with NOTIFY on the channel, so the replicas
+// can follow the changes made by each other with PgListener
+func WithPgNotify(channel string) PgOption {
+	return func(st *pgStorage) {
+		st.notifyChannel = channel
+	}
+}
+
+func (st *pgStorage) newEvent(event string, s Secret) StorageEvent {
+	return StorageEvent{Event: event, Hash: s.Hash, Tenant: s.Tenant, RemainingViews: s.RemainingViews, At: st.clock.Now()}
+}
+
+// notify publishes the events. In the transaction the events are delivered only if it is committed
+func (st *pgStorage) notify(e sqlx.Execer, events ...StorageEvent) error {
+	if st.notifyChannel == "" || len(events) == 0 {
+		return nil
+	}
+	payloads := make([]string, len(events))
+	for i, event := range events {
+		b, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		payloads[i] = string(b)
+	}
+	_, err := e.Exec("SELECT pg_notify($1, payload) FROM unnest($2::text[]) AS payload", st.notifyChannel, pq.Array(payloads))
+	return err
+}
+
+// PgListener receives the storage events published by WithPgNotify of all replicas
+type PgListener struct {
+	listener *pq.Listener
+	events   chan StorageEvent
+}
+
+// NewPgListener listens to the channel using the dedicated connection, it is reestablished automatically
+func NewPgListener(dbURL, channel string) (*PgListener, error) {
+	l := &PgListener{events: make(chan StorageEvent, 64)}
+	l.listener = pq.NewListener(dbURL, time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Println("storage events: ", err)
+		}
+	})
+	if err := l.listener.Listen(channel); err != nil {
+		_ = l.listener.Close()
+		return nil, err
+	}
+	go l.run()
+	return l, nil
+}
+
+// Events returns the channel of the received events. It is closed by Close.
+// The channel must be drained, the notifications are not received while it is full
+func (l *PgListener) Events() <-chan StorageEvent {
+	return l.events
+}
+
+// Close stops listening
+func (l *PgListener) Close() error {
+	return l.listener.Close()
+}
+
+func (l *PgListener) run() {
+	defer close(l.events)
+	for n := range l.listener.Notify {
+		// nil is sent after the connection was reestablished
+		if n == nil {
+			l.events <- StorageEvent{Event: EventResync, At: time.Now()}
+			continue
+		}
+		var e StorageEvent
+		if err := json.NewDecoder(strings.NewReader(n.Extra)).Decode(&e); err != nil {
+			log.Println("storage events: invalid payload: ", err)
+			continue
+		}
+		l.events <- e
+	}
+}
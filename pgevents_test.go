@@ -0,0 +1,43 @@
+package secret_server_task_test
+
+import (
+	"testing"
+	"time"
+
+	sst "github.com/evsan/secret-server-task"
+)
+
+func TestIntegrationPgNotify(t *testing.T) {
+	if testing.Short() || db == nil {
+		t.Skip()
+	}
+	const channel = "secret_events_test"
+
+	listener, err := sst.NewPgListener(*dbUrl, channel)
+	if err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+	defer listener.Close()
+
+	storage := sst.NewPgStorage(db, sst.WithPgNotify(channel))
+	secret, err := storage.Store(secretText, 2, expiresDelta)
+	if err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err = storage.Get(secret.Hash); err != nil {
+			t.Fatal("error is not expected: ", err)
+		}
+	}
+
+	for _, expected := range []string{sst.EventStored, sst.EventViewed, sst.EventConsumed} {
+		select {
+		case e := <-listener.Events():
+			if e.Event != expected || e.Hash != secret.Hash {
+				t.Fatalf("expected: %s %s, result: %s %s", expected, secret.Hash, e.Event, e.Hash)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("expected: %s, result: timeout", expected)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package secret_server_task
+
+import (
+	"database/sql/driver"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Default retry settings of the PostgreSQL storage
+const (
+	defaultRetryAttempts = 3
+	defaultRetryBackoff  = 20 * time.Millisecond
+)
+
+// RetryStats is implemented by the storages which retry the transient errors
+type RetryStats interface {
+	// Retries returns the amount of the operations run again after the transient error
+	Retries() uint64
+}
+
+// transience of the storage errors
+type transience int
+
+const (
+	notTransient transience = iota
+	// transientAborted statements are rolled back by the server, every operation may be run again
+	transientAborted
+	// transientConnection errors leave the outcome of the statement unknown
+	transientConnection
+)
+
+// commitError is the failed commit. The transaction may be committed anyway, so it is never retried
+type commitError struct {
+	error
+}
+
+// classify returns the transience of the error
+func classify(err error) transience {
+	if _, ok := err.(commitError); ok {
+		return notTransient
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch {
+		case pqErr.Code == "40001", pqErr.Code == "40P01":
+			// serialization_failure, deadlock_detected
+			return transientAborted
+		case pqErr.Code.Class() == "08", pqErr.Code.Class() == "57" && pqErr.Code != "57014":
+			// connection_exception, operator_intervention such as admin_shutdown except query_canceled
+			return transientConnection
+		}
+		return notTransient
+	}
+	var netErr net.Error
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) || errors.As(err, &netErr) {
+		return transientConnection
+	}
+	return notTransient
+}
+
+// IsTransient reports whether the operation which failed with err may succeed if it is run again
+func IsTransient(err error) bool {
+	return classify(err) != notTransient
+}
+
+// WithPgRetry sets how many times the operation is attempted if it fails with the transient error
+// and the base of the jittered exponential backoff between the attempts. 1 attempt disables the retries
+func WithPgRetry(attempts int, backoff time.Duration) PgOption {
+	return func(st *pgStorage) {
+		st.retryAttempts = attempts
+		st.retryBackoff = backoff
+	}
+}
+
+// retry runs the operation again after the transient error. The statements interrupted by the connection
+// errors may be applied, so they are run again only if safe is set: the operation is read only
+// or runs in the transaction which is rolled back
+func (st *pgStorage) retry(safe bool, op func() error) error {
+	err := op()
+	for attempt := 1; attempt < st.retryAttempts && err != nil; attempt++ {
+		switch classify(err) {
+		case transientAborted:
+		case transientConnection:
+			if !safe {
+				return err
+			}
+		default:
+			return err
+		}
+		atomic.AddUint64(&st.retries, 1)
+		if max := int64(st.retryBackoff << uint(attempt-1)); max > 0 {
+			time.Sleep(time.Duration(rand.Int63n(max)))
+		}
+		err = op()
+	}
+	return err
+}
+
+// Retries returns the amount of the retried operations
+func (st *pgStorage) Retries() uint64 {
+	return atomic.LoadUint64(&st.retries)
+}
@@ -0,0 +1,39 @@
+package secret_server_task_test
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"syscall"
+	"testing"
+
+	sst "github.com/evsan/secret-server-task"
+	"github.com/lib/pq"
+)
+
+func TestIsTransient(t *testing.T) {
+	testCases := map[string]struct {
+		Err    error
+		Result bool
+	}{
+		"serialization failure": {Err: &pq.Error{Code: "40001"}, Result: true},
+		"deadlock":              {Err: &pq.Error{Code: "40P01"}, Result: true},
+		"connection failure":    {Err: &pq.Error{Code: "08006"}, Result: true},
+		"admin shutdown":        {Err: &pq.Error{Code: "57P01"}, Result: true},
+		"query canceled":        {Err: &pq.Error{Code: "57014"}},
+		"unique violation":      {Err: &pq.Error{Code: "23505"}},
+		"bad connection":        {Err: driver.ErrBadConn, Result: true},
+		"unexpected eof":        {Err: io.ErrUnexpectedEOF, Result: true},
+		"connection reset":      {Err: fmt.Errorf("read: %w", syscall.ECONNRESET), Result: true},
+		"not available":         {Err: sst.ErrSecretNotAvailable},
+		"other":                 {Err: errors.New("other")},
+	}
+	for name, tst := range testCases {
+		t.Run(name, func(t *testing.T) {
+			if result := sst.IsTransient(tst.Err); result != tst.Result {
+				t.Fatalf("expected: %v, result: %v", tst.Result, result)
+			}
+		})
+	}
+}
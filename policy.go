@@ -0,0 +1,91 @@
+package secret_server_task
+
+import "errors"
+
+// Policy errors
+var (
+	ErrPolicyExpireAfter      = errors.New("expireAfter exceeds the maximum allowed by the policy")
+	ErrPolicyExpireAfterViews = errors.New("expireAfterViews exceeds the maximum allowed by the policy")
+	ErrPolicySecretSize       = errors.New("secret exceeds the maximum size allowed by the policy")
+)
+
+// Policy limits the secrets which can be created. Zero values mean no limit.
+type Policy struct {
+	// MaxExpireAfter is the maximum TTL in minutes. If set, secrets without TTL are not allowed
+	MaxExpireAfter int `json:"maxExpireAfter"`
+	// MaxExpireAfterViews is the maximum amount of views
+	MaxExpireAfterViews int `json:"maxExpireAfterViews"`
+	// MaxSecretSize is the maximum length of the secret text in bytes
+	MaxSecretSize int `json:"maxSecretSize"`
+	// RatePerMinute is the amount of secrets which can be created per minute
+	RatePerMinute int `json:"ratePerMinute"`
+	// RateBurst is the amount of secrets which can be created at once, RatePerMinute is used if empty
+	RateBurst int `json:"rateBurst"`
+	// SecretsPerDay is the quota of the secrets each owner can create per UTC day
+	SecretsPerDay int `json:"secretsPerDay"`
+	// CapExpireAfterViews lowers expireAfterViews to MaxExpireAfterViews instead of refusing the secret.
+	// MaxExpireAfterViews = 1 with the cap enforces burn-after-reading regardless of the client
+	CapExpireAfterViews bool `json:"capExpireAfterViews"`
+}
+
+// Enforce caps expireAfterViews at max on top of the policy. It is used for the server wide limits
+// which can't be relaxed by the tenant overrides
+func (p Policy) Enforce(maxExpireAfterViews int) Policy {
+	if maxExpireAfterViews > 0 && (p.MaxExpireAfterViews == 0 || p.MaxExpireAfterViews >= maxExpireAfterViews) {
+		p.MaxExpireAfterViews = maxExpireAfterViews
+		p.CapExpireAfterViews = true
+	}
+	return p
+}
+
+// ApplyViews returns expireAfterViews allowed by the policy and true if it was lowered
+func (p Policy) ApplyViews(expireAfterViews int) (int, bool) {
+	if p.CapExpireAfterViews && p.MaxExpireAfterViews > 0 && expireAfterViews > p.MaxExpireAfterViews {
+		return p.MaxExpireAfterViews, true
+	}
+	return expireAfterViews, false
+}
+
+// Check validates the input values of the new secret against the policy
+func (p Policy) Check(secret string, expireAfterViews, expireAfter int) error {
+	if p.MaxSecretSize > 0 && len(secret) > p.MaxSecretSize {
+		return ErrPolicySecretSize
+	}
+	if p.MaxExpireAfterViews > 0 && !p.CapExpireAfterViews && expireAfterViews > p.MaxExpireAfterViews {
+		return ErrPolicyExpireAfterViews
+	}
+	if p.MaxExpireAfter > 0 && (expireAfter == 0 || expireAfter > p.MaxExpireAfter) {
+		return ErrPolicyExpireAfter
+	}
+	return nil
+}
+
+// PolicyOverride changes the fields of the base policy which are not nil
+type PolicyOverride struct {
+	MaxExpireAfter      *int  `json:"maxExpireAfter,omitempty"`
+	MaxExpireAfterViews *int  `json:"maxExpireAfterViews,omitempty"`
+	MaxSecretSize       *int  `json:"maxSecretSize,omitempty"`
+	RatePerMinute       *int  `json:"ratePerMinute,omitempty"`
+	RateBurst           *int  `json:"rateBurst,omitempty"`
+	SecretsPerDay       *int  `json:"secretsPerDay,omitempty"`
+	CapExpireAfterViews *bool `json:"capExpireAfterViews,omitempty"`
+}
+
+// Apply returns the base policy with the overridden fields
+func (o PolicyOverride) Apply(base Policy) Policy {
+	set := func(dst *int, src *int) {
+		if src != nil {
+			*dst = *src
+		}
+	}
+	set(&base.MaxExpireAfter, o.MaxExpireAfter)
+	set(&base.MaxExpireAfterViews, o.MaxExpireAfterViews)
+	set(&base.MaxSecretSize, o.MaxSecretSize)
+	set(&base.RatePerMinute, o.RatePerMinute)
+	set(&base.RateBurst, o.RateBurst)
+	set(&base.SecretsPerDay, o.SecretsPerDay)
+	if o.CapExpireAfterViews != nil {
+		base.CapExpireAfterViews = *o.CapExpireAfterViews
+	}
+	return base
+}
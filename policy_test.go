@@ -0,0 +1,120 @@
+package secret_server_task_test
+
+import (
+	"testing"
+
+	sst "github.com/evsan/secret-server-task"
+)
+
+func TestPolicy_Check(t *testing.T) {
+	policy := sst.Policy{
+		MaxExpireAfter:      expiresDelta,
+		MaxExpireAfterViews: remainingViews,
+		MaxSecretSize:       len(secretText),
+	}
+
+	testCases := map[string]struct {
+		SecretText        string
+		ExpiresAfter      int
+		ExpiresAfterViews int
+		ExpError          error
+	}{
+		"correct": {
+			SecretText:        secretText,
+			ExpiresAfter:      expiresDelta,
+			ExpiresAfterViews: remainingViews,
+		},
+		"forever TTL": {
+			ExpError:          sst.ErrPolicyExpireAfter,
+			SecretText:        secretText,
+			ExpiresAfterViews: remainingViews,
+		},
+		"too long TTL": {
+			ExpError:          sst.ErrPolicyExpireAfter,
+			SecretText:        secretText,
+			ExpiresAfter:      expiresDelta + 1,
+			ExpiresAfterViews: remainingViews,
+		},
+		"too many views": {
+			ExpError:          sst.ErrPolicyExpireAfterViews,
+			SecretText:        secretText,
+			ExpiresAfter:      expiresDelta,
+			ExpiresAfterViews: remainingViews + 1,
+		},
+		"too big secret": {
+			ExpError:          sst.ErrPolicySecretSize,
+			SecretText:        secretText + "!",
+			ExpiresAfter:      expiresDelta,
+			ExpiresAfterViews: remainingViews,
+		},
+	}
+
+	for name, tst := range testCases {
+		t.Run(name, func(t *testing.T) {
+			e := policy.Check(tst.SecretText, tst.ExpiresAfterViews, tst.ExpiresAfter)
+			if e != tst.ExpError {
+				t.Fatalf("expected: %s, result: %s", tst.ExpError, e)
+			}
+		})
+	}
+}
+
+func TestPolicyOverride_Apply(t *testing.T) {
+	views := 1
+	zero := 0
+	base := sst.Policy{MaxExpireAfter: expiresDelta, MaxExpireAfterViews: remainingViews}
+
+	p := sst.PolicyOverride{MaxExpireAfterViews: &views, MaxExpireAfter: &zero}.Apply(base)
+	if p.MaxExpireAfterViews != views || p.MaxExpireAfter != 0 {
+		t.Fatalf("unexpected policy: %+v", p)
+	}
+	if base.MaxExpireAfterViews != remainingViews {
+		t.Fatal("base policy should not be changed")
+	}
+}
+
+func TestPolicy_Enforce(t *testing.T) {
+	testCases := map[string]struct {
+		Policy   sst.Policy
+		Enforced int
+		Views    int
+		Expected int
+		Capped   bool
+	}{
+		"read once": {
+			Enforced: 1,
+			Views:    remainingViews,
+			Expected: 1,
+			Capped:   true,
+		},
+		"relaxed by the policy": {
+			Policy:   sst.Policy{MaxExpireAfterViews: remainingViews},
+			Enforced: 1,
+			Views:    remainingViews,
+			Expected: 1,
+			Capped:   true,
+		},
+		"below the cap": {
+			Enforced: remainingViews,
+			Views:    1,
+			Expected: 1,
+		},
+		"not enforced": {
+			Views:    remainingViews,
+			Expected: remainingViews,
+		},
+	}
+
+	for name, tst := range testCases {
+		t.Run(name, func(t *testing.T) {
+			policy := tst.Policy.Enforce(tst.Enforced)
+			if err := policy.Check(secretText, tst.Views, 0); err != nil {
+				t.Fatal("error is not expected: ", err)
+			}
+			views, capped := policy.ApplyViews(tst.Views)
+			if views != tst.Expected || capped != tst.Capped {
+				t.Fatalf("expected: %d, %v, result: %d, %v", tst.Expected, tst.Capped, views, capped)
+			}
+		})
+	}
+}
@@ -0,0 +1,35 @@
+package secret_server_task
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+)
+
+// minRecipientToken is the minimal length of the pre-shared recipient token
+const minRecipientToken = 16
+
+// ErrWeakRecipientToken is returned for the recipient tokens which can be guessed
+var ErrWeakRecipientToken = errors.New("recipient token should have at least 16 characters")
+
+// RecipientToken returns the binding of the pre-shared token. Only its digest is stored,
+// the token itself is sent to the recipient over the other channel than the URL
+func RecipientToken(token string) (string, error) {
+	if len(token) < minRecipientToken {
+		return "", ErrWeakRecipientToken
+	}
+	sum := sha256.Sum256([]byte(token))
+	return "token:" + base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+// RecipientCert returns the binding of the identity of the client certificate, e.g. its subject
+func RecipientCert(identity string) string {
+	return "cert:" + identity
+}
+
+// WithRecipient binds the secret to the recipient credential, Get returns ErrRecipientRequired for it
+func WithRecipient(binding string) SecretOption {
+	return func(s *Secret) {
+		s.Recipient = binding
+	}
+}
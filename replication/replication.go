@@ -0,0 +1,258 @@
+// Package replication ships the secrets to the peer instance in the other region, so the links
+// keep working during the regional outage.
+//
+// The secrets created locally are sent to the peer asynchronously and imported there as replicas
+// with the home region. The views are decremented only in the home region: the replica proxies
+// the views to its home and serves its local copy only if the home is unreachable.
+// The deletion of the consumed secrets is replicated too. The views served by the replica
+// during the outage are not sent back, so the secret may be viewed up to expireAfterViews times
+// in each region. Secrets with the two-step reveal are not replicated, and neither are the revocations
+// made with the admin API, the replicas are removed when they expire.
+package replication
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	sst "github.com/evsan/secret-server-task"
+)
+
+// Operations of the events
+const (
+	OpStore  = "store"
+	OpDelete = "delete"
+)
+
+// queueSize is the amount of the events waiting for the delivery, the newer events are dropped if it is full
+const queueSize = 1024
+
+// Event is the change shipped to the peer
+type Event struct {
+	Op string `json:"op"`
+	// Hash is the storage key including the tenant prefix
+	Hash string `json:"hash"`
+	// Secret is set for OpStore
+	Secret *sst.Secret `json:"secret,omitempty"`
+	Owner  string      `json:"owner,omitempty"`
+	Tenant string      `json:"tenant,omitempty"`
+	Labels sst.Labels  `json:"labels,omitempty"`
+	Region string      `json:"region"`
+}
+
+// Config describes the local region and its peer
+type Config struct {
+	// Region is the name of the local region
+	Region string
+	// PeerAdminURL is the admin API of the peer the events are posted to, e.g. https://eu.example.com:8443
+	PeerAdminURL string
+	// PeerToken is the bearer token of the peer admin API
+	PeerToken string
+	// PeerAPIURL is the public API of the peer the views of its replicas are proxied to, including the path prefix
+	PeerAPIURL string
+}
+
+// Storage replicates the created and consumed secrets of the wrapped storage to the peer
+type Storage struct {
+	sst.Storage
+	config Config
+	client *http.Client
+	events chan Event
+}
+
+// New wraps the storage, the events are delivered in the background until the process exits
+func New(st sst.Storage, config Config) *Storage {
+	s := &Storage{
+		Storage: st,
+		config:  config,
+		client:  &http.Client{Timeout: 5 * time.Second},
+		events:  make(chan Event, queueSize),
+	}
+	go s.run()
+	return s
+}
+
+// Unwrap returns the wrapped storage
+func (s *Storage) Unwrap() sst.Storage {
+	return s.Storage
+}
+
+func (s *Storage) Store(secret string, expireAfterViews, expireAfter int, opts ...sst.SecretOption) (sst.Secret, error) {
+	result, err := s.Storage.Store(secret, expireAfterViews, expireAfter, opts...)
+	if err != nil || result.ClaimRequired || result.Recipient != "" {
+		return result, err
+	}
+	// The tenant storage strips the prefix from the stored key, it is restored for the peer
+	stored := result
+	stored.Hash = storageKey(result.Tenant, result.Hash)
+	s.ship(Event{Op: OpStore, Hash: stored.Hash, Secret: &stored, Owner: result.Owner, Tenant: result.Tenant, Labels: result.Labels})
+	return result, nil
+}
+
+func (s *Storage) Get(key string) (sst.Secret, error) {
+	result, err := s.Storage.Get(key)
+	if err == sst.ErrReplica {
+		return s.getReplica(key)
+	}
+	if err == nil && result.RemainingViews == 0 && result.HomeRegion == "" {
+		s.ship(Event{Op: OpDelete, Hash: key})
+	}
+	return result, err
+}
+
+// getReplica serves the view in the home region, the local copy is served only if the home is unreachable
+func (s *Storage) getReplica(key string) (sst.Secret, error) {
+	result, err := s.proxy(key)
+	if err == nil || errors.Is(err, sst.ErrSecretNotAvailable) {
+		return result, err
+	}
+	log.Printf("replication: home region is unreachable, serving the local copy: %v", err)
+	return sst.RevealSecret(s.Storage, key)
+}
+
+// proxy gets the secret from the public API of the peer
+func (s *Storage) proxy(key string) (sst.Secret, error) {
+	path := "/secret/" + key
+	if tenant, hash, ok := strings.Cut(key, "/"); ok {
+		path = "/t/" + tenant + "/secret/" + hash
+	}
+	req, err := http.NewRequest(http.MethodGet, strings.TrimSuffix(s.config.PeerAPIURL, "/")+path, nil)
+	if err != nil {
+		return sst.Secret{}, err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return sst.Secret{}, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return sst.Secret{}, sst.ErrSecretNotAvailable
+	case resp.StatusCode != http.StatusOK:
+		return sst.Secret{}, errors.New("home region responded " + resp.Status)
+	}
+	var result sst.Secret
+	if err = json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return sst.Secret{}, err
+	}
+	return result, nil
+}
+
+func (s *Storage) ship(e Event) {
+	e.Region = s.config.Region
+	select {
+	case s.events <- e:
+	default:
+		log.Printf("replication: queue is full, %s of %s is not replicated", e.Op, e.Hash)
+	}
+}
+
+// run posts the events in batches, the failed batch is retried until it is delivered
+func (s *Storage) run() {
+	backoff := time.Second
+	for e := range s.events {
+		batch := []Event{e}
+		for len(batch) < 100 && len(s.events) > 0 {
+			batch = append(batch, <-s.events)
+		}
+		for {
+			err := s.post(batch)
+			if err == nil {
+				backoff = time.Second
+				break
+			}
+			log.Printf("replication: delivery of %d events failed, retry in %s: %v", len(batch), backoff, err)
+			time.Sleep(backoff)
+			if backoff < time.Minute {
+				backoff *= 2
+			}
+		}
+	}
+}
+
+func (s *Storage) post(batch []Event) error {
+	body, err := json.Marshal(batch)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(s.config.PeerAdminURL, "/")+"/admin/replication", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.config.PeerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+s.config.PeerToken)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return errors.New("peer responded " + resp.Status)
+	}
+	return nil
+}
+
+func storageKey(tenant, hash string) string {
+	if tenant == "" || strings.HasPrefix(hash, tenant+"/") {
+		return hash
+	}
+	return tenant + "/" + hash
+}
+
+// Handler applies the events posted by the peer. It must be served by the protected admin listener
+func Handler(st sst.Storage) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var batch []Event
+		if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+			http.Error(w, "Invalid events: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		importer, canImport := sst.Base(st).(sst.Importer)
+		revoker, canRevoke := sst.Base(st).(sst.Revoker)
+		if !canImport || !canRevoke {
+			http.Error(w, "Storage doesn't support replication", http.StatusNotImplemented)
+			return
+		}
+		for _, e := range batch {
+			if err := apply(importer, revoker, e); err != nil {
+				log.Printf("replication: %s of %s failed: %v", e.Op, e.Hash, err)
+				http.Error(w, "Replication failed", http.StatusInternalServerError)
+				return
+			}
+		}
+	})
+}
+
+func apply(importer sst.Importer, revoker sst.Revoker, e Event) error {
+	if e.Region == "" || e.Hash == "" {
+		return errors.New("event without the region or the hash")
+	}
+	switch e.Op {
+	case OpStore:
+		if e.Secret == nil {
+			return errors.New("store event without the secret")
+		}
+		secret := *e.Secret
+		secret.Hash, secret.Owner, secret.Tenant, secret.Labels = e.Hash, e.Owner, e.Tenant, e.Labels
+		secret.HomeRegion = e.Region
+		if err := importer.Import(secret); err != nil && err != sst.ErrSecretExists {
+			return err
+		}
+	case OpDelete:
+		err := revoker.Revoke(e.Hash, "consumed in the home region "+e.Region)
+		if err != nil && !errors.Is(err, sst.ErrSecretNotAvailable) {
+			return err
+		}
+	default:
+		return errors.New("unknown operation " + e.Op)
+	}
+	return nil
+}
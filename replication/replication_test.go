@@ -0,0 +1,72 @@
+package replication_test
+
+import (
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	sst "github.com/evsan/secret-server-task"
+	"github.com/evsan/secret-server-task/httpapi"
+	"github.com/evsan/secret-server-task/replication"
+)
+
+func TestReplication(t *testing.T) {
+	euBase, usBase := sst.NewMemStorage(), sst.NewMemStorage()
+	usAdmin := httptest.NewServer(replication.Handler(usBase))
+	defer usAdmin.Close()
+
+	eu := replication.New(euBase, replication.Config{Region: "eu", PeerAdminURL: usAdmin.URL})
+	euAPI := httptest.NewServer(httpapi.New(eu, httpapi.WithMetrics(nil), httpapi.WithTenants("acme")))
+	us := replication.New(usBase, replication.Config{Region: "us", PeerAPIURL: euAPI.URL})
+
+	secret, err := sst.NewTenantStorage(eu, "acme").Store("test secret", 3, 0, sst.WithOwner("alice"))
+	if err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+	key := "acme/" + secret.Hash
+	waitFor(t, func() bool {
+		_, err := usBase.Get(key)
+		return err == sst.ErrReplica
+	})
+
+	// The view of the replica is served by the home region
+	s, err := sst.NewTenantStorage(us, "acme").Get(secret.Hash)
+	if err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+	if s.SecretText != "test secret" || s.RemainingViews != 2 {
+		t.Fatalf("expected: %s %d, result: %s %d", "test secret", 2, s.SecretText, s.RemainingViews)
+	}
+
+	// The local copy is served during the outage of the home region
+	euAPI.Close()
+	s, err = us.Get(key)
+	if err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+	if s.SecretText != "test secret" {
+		t.Fatalf("expected: %s, result: %s", "test secret", s.SecretText)
+	}
+
+	// The consumption in the home region removes the replica
+	for i := 0; i < 2; i++ {
+		if _, err = eu.Get(key); err != nil {
+			t.Fatal("error is not expected: ", err)
+		}
+	}
+	waitFor(t, func() bool {
+		_, err := usBase.Get(key)
+		return errors.Is(err, sst.ErrSecretNotAvailable)
+	})
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
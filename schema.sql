@@ -3,5 +3,68 @@ CREATE TABLE secret (
     secret_text VARCHAR NOT NULL,
     created_at TIMESTAMP NOT NULL DEFAULT NOW(),
     expires_at TIMESTAMP NULL,
-    remaining_views INTEGER NOT NULL
-);
\ No newline at end of file
+    remaining_views INTEGER NOT NULL,
+    deleted_at TIMESTAMP NULL,
+    owner VARCHAR NOT NULL DEFAULT '',
+    tenant VARCHAR NOT NULL DEFAULT '',
+    webhook_url VARCHAR NOT NULL DEFAULT '',
+    not_before TIMESTAMP NULL,
+    views INTEGER NOT NULL DEFAULT 0,
+    labels JSONB NOT NULL DEFAULT '{}',
+    claim_required BOOLEAN NOT NULL DEFAULT FALSE,
+    home_region VARCHAR NOT NULL DEFAULT '',
+    recipient VARCHAR NOT NULL DEFAULT '',
+    filename VARCHAR NOT NULL DEFAULT '',
+    content_type VARCHAR NOT NULL DEFAULT '',
+    -- check_in_interval is in nanoseconds, positive for the dead man's switch secrets
+    check_in_interval BIGINT NOT NULL DEFAULT 0,
+    release_url VARCHAR NOT NULL DEFAULT '',
+    released BOOLEAN NOT NULL DEFAULT FALSE
+);
+
+CREATE INDEX secret_owner_idx ON secret (owner) WHERE owner <> '';
+
+CREATE TABLE outbox (
+    id BIGSERIAL PRIMARY KEY,
+    event VARCHAR NOT NULL,
+    hash VARCHAR NOT NULL,
+    url VARCHAR NOT NULL,
+    remaining_views INTEGER NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX outbox_next_attempt_at_idx ON outbox (next_attempt_at);
+
+CREATE TABLE secret_view (
+    seq BIGSERIAL PRIMARY KEY,
+    id VARCHAR NOT NULL,
+    owner VARCHAR NOT NULL DEFAULT '',
+    viewed_at TIMESTAMP NOT NULL,
+    remote_addr VARCHAR NOT NULL DEFAULT '',
+    user_agent VARCHAR NOT NULL DEFAULT ''
+);
+
+CREATE INDEX secret_view_id_idx ON secret_view (id);
+CREATE INDEX secret_view_owner_idx ON secret_view (owner) WHERE owner <> '';
+CREATE INDEX secret_view_viewed_at_idx ON secret_view (viewed_at);
+
+CREATE TABLE secret_revocation (
+    id VARCHAR NOT NULL,
+    reason VARCHAR NOT NULL,
+    revoked_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    owner VARCHAR NOT NULL DEFAULT ''
+);
+CREATE TABLE secret_link (
+    id VARCHAR PRIMARY KEY NOT NULL,
+    secret_id VARCHAR NOT NULL,
+    owner VARCHAR NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    single_use BOOLEAN NOT NULL DEFAULT FALSE,
+    used BOOLEAN NOT NULL DEFAULT FALSE
+);
+
+CREATE INDEX secret_link_secret_id_idx ON secret_link (secret_id);
+CREATE INDEX secret_link_owner_idx ON secret_link (owner) WHERE owner <> '';
@@ -0,0 +1,198 @@
+package secret_server_task
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SecretStatus is the state of the secret in the search results
+type SecretStatus string
+
+// Statuses of the secrets
+const (
+	// StatusLive secrets can be viewed, the scheduled ones included
+	StatusLive SecretStatus = "live"
+	// StatusExpired secrets passed their TTL, they are kept until purged or as the tombstones
+	StatusExpired SecretStatus = "expired"
+	// StatusConsumed secrets have no views left, they are kept as the tombstones only
+	StatusConsumed SecretStatus = "consumed"
+)
+
+// ErrInvalidStatus is returned for the status other than live, expired and consumed
+var ErrInvalidStatus = errors.New("invalid status, it should be live, expired or consumed")
+
+// ParseSecretStatus returns the status of the name, empty matches any status
+func ParseSecretStatus(name string) (SecretStatus, error) {
+	switch s := SecretStatus(name); s {
+	case "", StatusLive, StatusExpired, StatusConsumed:
+		return s, nil
+	}
+	return "", ErrInvalidStatus
+}
+
+// StatusAt returns the status of the secret at the time
+func (s *Secret) StatusAt(now time.Time) SecretStatus {
+	if !s.IsExpiredAt(now) {
+		return StatusLive
+	}
+	if unavailable, _ := s.unavailable(); unavailable.Unavailable == ReasonConsumed {
+		return StatusConsumed
+	}
+	return StatusExpired
+}
+
+// SearchQuery filters the metadata of the secrets. The zero values match everything
+type SearchQuery struct {
+	// Tenant matches the tenant if not nil, empty is the default tenant
+	Tenant *string
+	Owner  string
+	Labels Labels
+	Status SecretStatus
+	// CreatedAfter and CreatedBefore bound the creation time, CreatedBefore is exclusive
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+	// After is the position of the page: the secrets following it in the order of the results are returned
+	After *SearchPosition
+	// Limit is the maximum amount of the returned secrets
+	Limit int
+}
+
+// SearchPosition is the place of the secret in the search results, which are ordered by the creation time and the hash
+type SearchPosition struct {
+	CreatedAt time.Time
+	Hash      string
+}
+
+// before reports whether the secret precedes the position in the order of the results
+func (p SearchPosition) before(s Secret) bool {
+	return p.CreatedAt.Before(s.CreatedAt) || (p.CreatedAt.Equal(s.CreatedAt) && p.Hash < s.Hash)
+}
+
+// Searcher is implemented by the storages which find the secrets by their metadata, the text is never returned
+type Searcher interface {
+	// Search returns up to Limit matching secrets ordered by the creation time and the hash
+	Search(q SearchQuery) ([]Secret, error)
+}
+
+// matches checks the secret against the query at the time
+func (q SearchQuery) matches(s Secret, now time.Time) bool {
+	switch {
+	case q.Tenant != nil && s.Tenant != *q.Tenant,
+		q.Owner != "" && s.Owner != q.Owner,
+		!s.Labels.Match(q.Labels),
+		q.Status != "" && s.StatusAt(now) != q.Status,
+		!q.CreatedAfter.IsZero() && s.CreatedAt.Before(q.CreatedAfter),
+		!q.CreatedBefore.IsZero() && !s.CreatedAt.Before(q.CreatedBefore),
+		q.After != nil && !q.After.before(s):
+		return false
+	}
+	return true
+}
+
+// sortResults orders the secrets and cuts them to the limit
+func sortResults(secrets []Secret, limit int) []Secret {
+	sort.Slice(secrets, func(i, j int) bool {
+		return SearchPosition{CreatedAt: secrets[i].CreatedAt, Hash: secrets[i].Hash}.before(secrets[j])
+	})
+	if limit > 0 && len(secrets) > limit {
+		secrets = secrets[:limit]
+	}
+	return secrets
+}
+
+// Search scans the stored secrets, the tombstones included
+func (st *memStorage) Search(q SearchQuery) ([]Secret, error) {
+	now := st.clock.Now()
+	found := []Secret{}
+	st.values.Range(func(_, value interface{}) bool {
+		mSecret := value.(*memSecret)
+		mSecret.mu.Lock()
+		secret := mSecret.Secret
+		mSecret.mu.Unlock()
+
+		if q.matches(secret, now) {
+			secret.SecretText = ""
+			found = append(found, secret)
+		}
+		return true
+	})
+	return sortResults(found, q.Limit), nil
+}
+
+// Search queries the secret table, the tombstones included
+func (st *pgStorage) Search(q SearchQuery) ([]Secret, error) {
+	var where []string
+	var args []interface{}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+	if q.Tenant != nil {
+		where = append(where, "tenant = "+arg(*q.Tenant))
+	}
+	if q.Owner != "" {
+		where = append(where, "owner = "+arg(q.Owner))
+	}
+	if len(q.Labels) > 0 {
+		where = append(where, "labels @> "+arg(q.Labels)+"::jsonb")
+	}
+	switch now := st.clock.Now(); q.Status {
+	case StatusLive:
+		where = append(where, "remaining_views > 0 AND (expires_at IS NULL OR expires_at > "+arg(now)+")")
+	case StatusExpired:
+		where = append(where, "remaining_views > 0 AND expires_at <= "+arg(now))
+	case StatusConsumed:
+		where = append(where, "remaining_views <= 0")
+	}
+	if !q.CreatedAfter.IsZero() {
+		where = append(where, "created_at >= "+arg(q.CreatedAfter))
+	}
+	if !q.CreatedBefore.IsZero() {
+		where = append(where, "created_at < "+arg(q.CreatedBefore))
+	}
+	if q.After != nil {
+		where = append(where, "(created_at, id) > ("+arg(q.After.CreatedAt)+", "+arg(q.After.Hash)+")")
+	}
+	query := "SELECT " + pgSecretColumns + " FROM secret"
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY created_at, id"
+	if q.Limit > 0 {
+		query += " LIMIT " + arg(q.Limit)
+	}
+
+	var rows []pgSecret
+	err := st.retry(true, func() error {
+		return st.db.Select(&rows, query, args...)
+	})
+	if err != nil {
+		return nil, &BackendError{Op: "search", Err: err}
+	}
+	found := make([]Secret, len(rows))
+	for i, row := range rows {
+		found[i] = row.ToSecret()
+		found[i].SecretText = ""
+	}
+	return found, nil
+}
+
+// Search merges the results of the shards
+func (st *shardedStorage) Search(q SearchQuery) ([]Secret, error) {
+	found := []Secret{}
+	for _, s := range st.shards {
+		searcher, ok := Base(s.Storage).(Searcher)
+		if !ok {
+			return nil, errors.New("shard doesn't support search")
+		}
+		secrets, err := searcher.Search(q)
+		if err != nil {
+			return nil, err
+		}
+		found = append(found, secrets...)
+	}
+	return sortResults(found, q.Limit), nil
+}
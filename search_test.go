@@ -0,0 +1,68 @@
+package secret_server_task_test
+
+import (
+	"testing"
+	"time"
+
+	sst "github.com/evsan/secret-server-task"
+)
+
+func TestMemStorage_Search(t *testing.T) {
+	start := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+	clock := sst.NewManualClock(start)
+	storage := sst.NewMemStorage(sst.WithMemClock(clock), sst.WithMemRetention(time.Hour))
+
+	store := func(views, ttl int, opts ...sst.SecretOption) sst.Secret {
+		s, err := storage.Store(secretText, views, ttl, opts...)
+		if err != nil {
+			t.Fatal("error is not expected: ", err)
+		}
+		clock.Add(time.Minute)
+		return s
+	}
+	live := store(remainingViews, 0, sst.WithOwner("alice"), sst.WithLabels(sst.Labels{"env": "prod"}))
+	consumed := store(1, 0, sst.WithOwner("alice"))
+	expired := store(remainingViews, 1, sst.WithOwner("bob"), sst.WithTenant("acme"))
+	if _, err := storage.Get(consumed.Hash); err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+	clock.Add(time.Minute)
+
+	acme := "acme"
+	testCases := map[string]struct {
+		query    sst.SearchQuery
+		expected []sst.Secret
+	}{
+		"all":      {sst.SearchQuery{}, []sst.Secret{live, consumed, expired}},
+		"live":     {sst.SearchQuery{Status: sst.StatusLive}, []sst.Secret{live}},
+		"consumed": {sst.SearchQuery{Status: sst.StatusConsumed}, []sst.Secret{consumed}},
+		"expired":  {sst.SearchQuery{Status: sst.StatusExpired}, []sst.Secret{expired}},
+		"owner":    {sst.SearchQuery{Owner: "alice"}, []sst.Secret{live, consumed}},
+		"tenant":   {sst.SearchQuery{Tenant: &acme}, []sst.Secret{expired}},
+		"label":    {sst.SearchQuery{Labels: sst.Labels{"env": "prod"}}, []sst.Secret{live}},
+		"window":   {sst.SearchQuery{CreatedAfter: start.Add(time.Minute), CreatedBefore: start.Add(2 * time.Minute)}, []sst.Secret{consumed}},
+		"limit":    {sst.SearchQuery{Limit: 2}, []sst.Secret{live, consumed}},
+		"next page": {sst.SearchQuery{Limit: 2, After: &sst.SearchPosition{CreatedAt: consumed.CreatedAt, Hash: consumed.Hash}},
+			[]sst.Secret{expired}},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			found, err := storage.(sst.Searcher).Search(tc.query)
+			if err != nil {
+				t.Fatal("error is not expected: ", err)
+			}
+			if len(found) != len(tc.expected) {
+				t.Fatalf("expected: %d, result: %d", len(tc.expected), len(found))
+			}
+			for i, s := range found {
+				if s.Hash != tc.expected[i].Hash || s.SecretText != "" {
+					t.Fatalf("expected: %s, result: %s", tc.expected[i].Hash, s.Hash)
+				}
+			}
+		})
+	}
+
+	if _, err := sst.ParseSecretStatus("gone"); err != sst.ErrInvalidStatus {
+		t.Fatalf("expected: %s, result: %v", sst.ErrInvalidStatus, err)
+	}
+}
@@ -0,0 +1,143 @@
+// Package shadow compares the reads of the storage with the candidate storage before the migration.
+//
+// The views are served by the primary storage only. Every read is replayed against the candidate
+// with Peek in the background, so the candidate views are never consumed and the latency
+// of the primary is not affected. The mismatches are logged without the secret text and counted.
+// The remaining views are not compared: they diverge as soon as the candidate stops receiving the writes.
+package shadow
+
+import (
+	"errors"
+	"log"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	sst "github.com/evsan/secret-server-task"
+)
+
+// maxInflight is the amount of the comparisons running at once, the reads are not compared if it is reached
+const maxInflight = 64
+
+// ErrNoPeek means the candidate storage can't read the secrets without consuming the views
+var ErrNoPeek = errors.New("candidate storage doesn't support Peek")
+
+// Stats are the counters of the shadow reads
+type Stats struct {
+	// Compared is the amount of the reads replayed against the candidate
+	Compared uint64
+	// Mismatches is the amount of the reads the candidate answered differently
+	Mismatches uint64
+	// Skipped is the amount of the reads not compared because too many comparisons were running
+	Skipped uint64
+}
+
+// Storage serves the wrapped primary storage and compares its reads with the candidate
+type Storage struct {
+	sst.Storage
+	candidate sst.Peeker
+	inflight  chan struct{}
+
+	comparedCount, mismatches, skipped uint64
+}
+
+// New wraps the primary storage. The candidate must be able to Peek,
+// e.g. the PostgreSQL storage of the new database
+func New(primary, candidate sst.Storage) (*Storage, error) {
+	peeker, ok := sst.Base(candidate).(sst.Peeker)
+	if !ok {
+		return nil, ErrNoPeek
+	}
+	return &Storage{
+		Storage:   primary,
+		candidate: peeker,
+		inflight:  make(chan struct{}, maxInflight),
+	}, nil
+}
+
+// Unwrap returns the primary storage
+func (s *Storage) Unwrap() sst.Storage {
+	return s.Storage
+}
+
+func (s *Storage) Get(key string) (sst.Secret, error) {
+	result, err := s.Storage.Get(key)
+	s.shadow(key, result, err)
+	return result, err
+}
+
+// Reveal serves the claimed secrets and compares them the same way as Get
+func (s *Storage) Reveal(key string) (sst.Secret, error) {
+	result, err := sst.RevealSecret(s.Storage, key)
+	s.shadow(key, result, err)
+	return result, err
+}
+
+// Stats returns the counters of the shadow reads
+func (s *Storage) Stats() Stats {
+	return Stats{
+		Compared:   atomic.LoadUint64(&s.comparedCount),
+		Mismatches: atomic.LoadUint64(&s.mismatches),
+		Skipped:    atomic.LoadUint64(&s.skipped),
+	}
+}
+
+// shadow compares the read of the primary with the candidate in the background
+func (s *Storage) shadow(key string, primary sst.Secret, primaryErr error) {
+	select {
+	case s.inflight <- struct{}{}:
+	default:
+		atomic.AddUint64(&s.skipped, 1)
+		return
+	}
+	go func() {
+		defer func() { <-s.inflight }()
+		candidate, err := s.candidate.Peek(key)
+		atomic.AddUint64(&s.comparedCount, 1)
+		if diff := compare(primary, primaryErr, candidate, err); len(diff) > 0 {
+			atomic.AddUint64(&s.mismatches, 1)
+			log.Printf("shadow: %s differs in the candidate storage: %s", key, strings.Join(diff, ", "))
+		}
+	}()
+}
+
+// compare returns the names of the attributes which differ. The text is compared only if the primary served it
+func compare(primary sst.Secret, primaryErr error, candidate sst.Secret, candidateErr error) []string {
+	// The gated secrets are found, only their metadata is returned
+	if primaryErr == sst.ErrClaimRequired || primaryErr == sst.ErrReplica || primaryErr == sst.ErrRecipientRequired {
+		primaryErr = nil
+		candidate.SecretText = primary.SecretText
+	}
+	if primaryErr != nil || candidateErr != nil {
+		// The reasons may differ, e.g. the candidate has purged the secret the primary reports as consumed
+		if errors.Is(primaryErr, sst.ErrSecretNotAvailable) && errors.Is(candidateErr, sst.ErrSecretNotAvailable) {
+			return nil
+		}
+		if primaryErr != candidateErr {
+			return []string{"availability"}
+		}
+		return nil
+	}
+	var diff []string
+	if primary.SecretText != candidate.SecretText {
+		diff = append(diff, "secretText")
+	}
+	if !equalTime(primary.CreatedAt, candidate.CreatedAt) {
+		diff = append(diff, "createdAt")
+	}
+	if !equalTime(primary.ExpiresAt, candidate.ExpiresAt) {
+		diff = append(diff, "expiresAt")
+	}
+	if !equalTime(primary.NotBefore, candidate.NotBefore) {
+		diff = append(diff, "notBefore")
+	}
+	if primary.Owner != candidate.Owner || primary.Tenant != candidate.Tenant {
+		diff = append(diff, "owner")
+	}
+	return diff
+}
+
+// equalTime compares the times with the precision of PostgreSQL
+func equalTime(a, b time.Time) bool {
+	return a.Truncate(time.Microsecond).Equal(b.Truncate(time.Microsecond))
+}
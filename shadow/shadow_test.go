@@ -0,0 +1,72 @@
+package shadow_test
+
+import (
+	"testing"
+	"time"
+
+	sst "github.com/evsan/secret-server-task"
+	"github.com/evsan/secret-server-task/shadow"
+)
+
+func TestStorage_Get(t *testing.T) {
+	primary, candidate := sst.NewMemStorage(), sst.NewMemStorage()
+	st, err := shadow.New(primary, candidate)
+	if err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+
+	same, err := primary.Store("same", 2, 0)
+	if err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+	changed, err := primary.Store("changed", 2, 0)
+	if err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+	missing, err := primary.Store("missing", 2, 0)
+	if err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+	consumed, err := primary.Store("consumed", 1, 0)
+	if err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+	if _, err = primary.Get(consumed.Hash); err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+	copied := changed
+	copied.SecretText = "other"
+	for _, s := range []sst.Secret{same, copied} {
+		if err = candidate.(sst.Importer).Import(s); err != nil {
+			t.Fatal("error is not expected: ", err)
+		}
+	}
+
+	for _, key := range []string{same.Hash, changed.Hash, missing.Hash, consumed.Hash, "unknown"} {
+		st.Get(key)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for st.Stats().Compared < 5 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	stats := st.Stats()
+	if stats.Compared != 5 || stats.Mismatches != 2 {
+		t.Fatalf("expected: compared 5, mismatches 2, result: %+v", stats)
+	}
+
+	// The candidate views are not consumed
+	if s, err := candidate.(sst.Peeker).Peek(same.Hash); err != nil || s.RemainingViews != 2 {
+		t.Fatalf("candidate secret is changed: %v, %v", s, err)
+	}
+}
+
+func TestNew(t *testing.T) {
+	if _, err := shadow.New(sst.NewMemStorage(), noPeek{}); err != shadow.ErrNoPeek {
+		t.Fatalf("expected: %s, result: %s", shadow.ErrNoPeek, err)
+	}
+}
+
+type noPeek struct {
+	sst.Storage
+}
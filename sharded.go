@@ -0,0 +1,353 @@
+package secret_server_task
+
+import (
+	"errors"
+	"hash/fnv"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Sharding settings
+const (
+	// shardVirtualNodes is the amount of points of every shard on the ring
+	shardVirtualNodes = 128
+	// shardCooldown is how long the shard gets no new secrets after the failure
+	shardCooldown = 10 * time.Second
+)
+
+// ErrInvalidShards is returned by NewShardedStorage for no shards or duplicate names
+var ErrInvalidShards = errors.New("at least one shard with the unique name is required")
+
+// Shard is the storage with the name which places it on the hash ring.
+// The name must stay the same when the shards are added or reordered, e.g. the name of the database
+type Shard struct {
+	Name    string
+	Storage Storage
+}
+
+// ShardStatus is the health of the shard reported by Health
+type ShardStatus struct {
+	Name      string    `json:"name" xml:"name"`
+	Healthy   bool      `json:"healthy" xml:"healthy"`
+	Failures  int       `json:"failures" xml:"failures"`
+	LastError string    `json:"lastError,omitempty" xml:"lastError,omitempty"`
+	FailedAt  time.Time `json:"failedAt,omitempty" xml:"failedAt,omitempty"`
+}
+
+type shard struct {
+	Shard
+
+	mu        sync.Mutex
+	failures  int
+	lastError error
+	failedAt  time.Time
+}
+
+func (s *shard) healthy(now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.failures == 0 || now.Sub(s.failedAt) > shardCooldown
+}
+
+func (s *shard) report(err error, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err == nil {
+		s.failures = 0
+		return
+	}
+	s.failures++
+	s.lastError = err
+	s.failedAt = now
+}
+
+type ringPoint struct {
+	hash  uint64
+	shard *shard
+}
+
+// shardedStorage routes the secrets to the shards by the consistent hash of the generated part of the key.
+// New secrets skip the shards which failed recently: the hash is regenerated until it lands on the healthy one.
+// Lookups which miss on the owning shard try the other shards, so the secrets stored before the ring was changed
+// are still found. It costs a lookup per shard for the unknown hashes.
+type shardedStorage struct {
+	shards []*shard
+	ring   []ringPoint
+	clock  Clock
+}
+
+// NewShardedStorage creates the storage distributing the secrets across the shards
+func NewShardedStorage(shards ...Shard) (Storage, error) {
+	if len(shards) == 0 {
+		return nil, ErrInvalidShards
+	}
+	st := &shardedStorage{clock: SystemClock}
+	names := map[string]bool{}
+	for _, s := range shards {
+		if s.Name == "" || names[s.Name] {
+			return nil, ErrInvalidShards
+		}
+		names[s.Name] = true
+		sh := &shard{Shard: s}
+		st.shards = append(st.shards, sh)
+		for v := 0; v < shardVirtualNodes; v++ {
+			st.ring = append(st.ring, ringPoint{hash: ringHash(s.Name + "#" + strconv.Itoa(v)), shard: sh})
+		}
+	}
+	sort.Slice(st.ring, func(i, j int) bool { return st.ring[i].hash < st.ring[j].hash })
+	return st, nil
+}
+
+// ringHash is FNV-1a with the murmur3 finalizer, FNV alone places the similar names close to each other
+func ringHash(key string) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(key))
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
+
+// route returns the shard owning the key. The tenant prefix is ignored, the generated hash is uniformly distributed
+func (st *shardedStorage) route(key string) *shard {
+	h := ringHash(key[strings.LastIndex(key, "/")+1:])
+	i := sort.Search(len(st.ring), func(i int) bool { return st.ring[i].hash >= h })
+	if i == len(st.ring) {
+		i = 0
+	}
+	return st.ring[i].shard
+}
+
+// Health returns the status of the shards
+func (st *shardedStorage) Health() []ShardStatus {
+	now := st.clock.Now()
+	result := make([]ShardStatus, len(st.shards))
+	for i, s := range st.shards {
+		healthy := s.healthy(now)
+		s.mu.Lock()
+		result[i] = ShardStatus{Name: s.Name, Healthy: healthy, Failures: s.failures, FailedAt: s.failedAt}
+		if s.lastError != nil {
+			result[i].LastError = s.lastError.Error()
+		}
+		s.mu.Unlock()
+	}
+	return result
+}
+
+func (st *shardedStorage) Store(secret string, expireAfterViews, expireAfter int, opts ...SecretOption) (Secret, error) {
+	var err error
+	tried := map[*shard]bool{}
+	// The hashes are random, so every shard is reached in a few attempts. The unhealthy shards
+	// are tried in the second half of the attempts, if all the shards have failed recently
+	maxAttempts := 16 * len(st.shards)
+	var previous string
+	for attempts := 0; attempts < maxAttempts && len(tried) < len(st.shards); attempts++ {
+		// The candidate validates the input, so the errors of the shards are always failures.
+		// Its hash is generated by the options, e.g. in the configured format and with the tenant prefix
+		var candidate Secret
+		if candidate, err = NewSecretAt(st.clock, secret, expireAfterViews, expireAfter, opts...); err != nil {
+			return Secret{}, err
+		}
+		hash := candidate.Hash
+		s := st.route(hash)
+		// The hash chosen by WithHash routes to the same shard every attempt, it is tried once
+		fixed := hash == previous
+		previous = hash
+		if fixed && (tried[s] || err == ErrSecretExists) {
+			break
+		}
+		if tried[s] || (!s.healthy(st.clock.Now()) && attempts < maxAttempts/2 && !fixed) {
+			continue
+		}
+		tried[s] = true
+
+		var result Secret
+		result, err = s.Storage.Store(secret, expireAfterViews, expireAfter, append(opts[:len(opts):len(opts)], withHash(hash))...)
+		if err == ErrSecretExists {
+			// The hash is taken, the shard is healthy and is tried again with the next candidate
+			delete(tried, s)
+			continue
+		}
+		s.report(err, st.clock.Now())
+		if err == nil {
+			return result, nil
+		}
+		log.Printf("shard %s: store failed: %v", s.Name, err)
+	}
+	if err == nil {
+		err = errors.New("no healthy shard is available")
+	}
+	return Secret{}, err
+}
+
+// withHash replaces the generated hash including the prefix, it must be the last option
+func withHash(hash string) SecretOption {
+	return func(s *Secret) {
+		s.Hash = hash
+	}
+}
+
+// lookup calls fn on the owning shard, then on the other shards while it returns ErrSecretNotAvailable
+func (st *shardedStorage) lookup(key string, fn func(Storage) (Secret, error)) (Secret, error) {
+	owner := st.route(key)
+	s, err := fn(owner.Storage)
+	if !errors.Is(err, ErrSecretNotAvailable) {
+		return s, err
+	}
+	// The reason is reported by the shard which has the record
+	unavailable, unavailableErr := s, err
+	for _, other := range st.shards {
+		if other == owner {
+			continue
+		}
+		if s, err = fn(other.Storage); !errors.Is(err, ErrSecretNotAvailable) {
+			return s, err
+		}
+		if s.Unavailable != "" && s.Unavailable != ReasonNotFound {
+			unavailable, unavailableErr = s, err
+		}
+	}
+	return Secret{Unavailable: unavailable.Unavailable}, unavailableErr
+}
+
+func (st *shardedStorage) Get(key string) (Secret, error) {
+	return st.lookup(key, func(s Storage) (Secret, error) {
+		return s.Get(key)
+	})
+}
+
+// Reveal
+func (st *shardedStorage) Reveal(key string) (Secret, error) {
+	return st.lookup(key, func(s Storage) (Secret, error) {
+		return RevealSecret(s, key)
+	})
+}
+
+// Peek
+func (st *shardedStorage) Peek(key string) (Secret, error) {
+	return st.lookup(key, func(s Storage) (Secret, error) {
+		peeker, ok := Base(s).(Peeker)
+		if !ok {
+			return Secret{}, ErrSecretNotAvailable
+		}
+		return peeker.Peek(key)
+	})
+}
+
+// Revoke
+func (st *shardedStorage) Revoke(key, reason string) error {
+	if reason == "" {
+		return ErrEmptyReason
+	}
+	_, err := st.lookup(key, func(s Storage) (Secret, error) {
+		revoker, ok := Base(s).(Revoker)
+		if !ok {
+			return Secret{}, ErrSecretNotAvailable
+		}
+		return Secret{}, revoker.Revoke(key, reason)
+	})
+	return err
+}
+
+// Stats sums the statistics of the shards
+func (st *shardedStorage) Stats() (Stats, error) {
+	var total Stats
+	for _, s := range st.shards {
+		if stats, ok := Base(s.Storage).(StatsStorage); ok {
+			shardStats, err := stats.Stats()
+			if err != nil {
+				return total, err
+			}
+			total.Total += shardStats.Total
+			total.Available += shardStats.Available
+			total.Tombstones += shardStats.Tombstones
+		}
+	}
+	return total, nil
+}
+
+// PurgeExpired purges all the shards, except the ones delegating the expiry to the backend
+func (st *shardedStorage) PurgeExpired() (int, error) {
+	var removed int
+	for _, s := range st.shards {
+		if ExpiresNatively(s.Storage) {
+			continue
+		}
+		if purger, ok := Base(s.Storage).(Purger); ok {
+			n, err := purger.PurgeExpired()
+			removed += n
+			if err != nil {
+				return removed, err
+			}
+		}
+	}
+	return removed, nil
+}
+
+// Export exports the shards one by one
+func (st *shardedStorage) Export(fn func(Secret) error) error {
+	for _, s := range st.shards {
+		if exporter, ok := Base(s.Storage).(Exporter); ok {
+			if err := exporter.Export(fn); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Import stores the secret into the owning shard
+func (st *shardedStorage) Import(secret Secret) error {
+	importer, ok := Base(st.route(secret.Hash).Storage).(Importer)
+	if !ok {
+		return errors.New("shard doesn't support import")
+	}
+	return importer.Import(secret)
+}
+
+// EraseOwner erases the owner in all the shards
+func (st *shardedStorage) EraseOwner(owner string) (ErasureReport, error) {
+	if owner == "" {
+		return ErasureReport{}, ErrEmptyOwner
+	}
+	hashes := []string{}
+	var auditRecords int
+	for _, s := range st.shards {
+		if eraser, ok := Base(s.Storage).(Eraser); ok {
+			report, err := eraser.EraseOwner(owner)
+			if err != nil {
+				return ErasureReport{}, err
+			}
+			hashes = append(hashes, report.Hashes...)
+			auditRecords += report.ErasedAuditRecords
+		}
+	}
+	return newErasureReport(owner, hashes, auditRecords, st.clock.Now()), nil
+}
+
+// ShardHealth returns the status of the shards if the storage is sharded
+func ShardHealth(st Storage) ([]ShardStatus, bool) {
+	sharded, ok := Base(st).(*shardedStorage)
+	if !ok {
+		return nil, false
+	}
+	return sharded.Health(), true
+}
+
+// Retries sums the retried operations of the shards
+func (st *shardedStorage) Retries() uint64 {
+	var total uint64
+	for _, s := range st.shards {
+		if stats, ok := Base(s.Storage).(RetryStats); ok {
+			total += stats.Retries()
+		}
+	}
+	return total
+}
@@ -0,0 +1,165 @@
+package secret_server_task_test
+
+import (
+	"errors"
+	"testing"
+
+	sst "github.com/evsan/secret-server-task"
+	"github.com/evsan/secret-server-task/storagemock"
+)
+
+func TestShardedStorage(t *testing.T) {
+	shards := []sst.Storage{sst.NewMemStorage(), sst.NewMemStorage(), sst.NewMemStorage()}
+	storage, err := sst.NewShardedStorage(
+		sst.Shard{Name: "a", Storage: shards[0]},
+		sst.Shard{Name: "b", Storage: shards[1]},
+		sst.Shard{Name: "c", Storage: shards[2]},
+	)
+	if err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+
+	var hashes []string
+	for i := 0; i < 300; i++ {
+		s, err := sst.NewTenantStorage(storage, "acme").Store(secretText, 1, expiresDelta)
+		if err != nil {
+			t.Fatal("error is not expected: ", err)
+		}
+		hashes = append(hashes, s.Hash)
+	}
+	for i, shard := range shards {
+		stats, _ := shard.(sst.StatsStorage).Stats()
+		if stats.Total < 50 {
+			t.Fatalf("shard %d is underused: %d", i, stats.Total)
+		}
+	}
+	stats, _ := storage.(sst.StatsStorage).Stats()
+	if stats.Total != len(hashes) {
+		t.Fatalf("expected: %d, result: %d", len(hashes), stats.Total)
+	}
+	for _, hash := range hashes {
+		if _, err = sst.NewTenantStorage(storage, "acme").Get(hash); err != nil {
+			t.Fatal("error is not expected: ", err)
+		}
+	}
+
+	// The secrets are still found after the shard is added
+	resharded, _ := sst.NewShardedStorage(
+		sst.Shard{Name: "a", Storage: shards[0]},
+		sst.Shard{Name: "b", Storage: shards[1]},
+		sst.Shard{Name: "c", Storage: shards[2]},
+		sst.Shard{Name: "d", Storage: sst.NewMemStorage()},
+	)
+	s, _ := storage.Store(secretText, 1, expiresDelta)
+	if _, err = resharded.Get(s.Hash); err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+
+	if _, err = sst.NewShardedStorage(sst.Shard{Name: "a", Storage: shards[0]}, sst.Shard{Name: "a", Storage: shards[1]}); err != sst.ErrInvalidShards {
+		t.Fatalf("expected: %v, result: %v", sst.ErrInvalidShards, err)
+	}
+}
+
+func TestShardedStorage_Health(t *testing.T) {
+	failing := storagemock.New(nil)
+	failing.SetStoreError(errors.New("connection refused"))
+	healthy := sst.NewMemStorage()
+	storage, _ := sst.NewShardedStorage(sst.Shard{Name: "failing", Storage: failing}, sst.Shard{Name: "healthy", Storage: healthy})
+
+	for i := 0; i < 20; i++ {
+		if _, err := storage.Store(secretText, 1, expiresDelta); err != nil {
+			t.Fatal("error is not expected: ", err)
+		}
+	}
+	status, ok := sst.ShardHealth(storage)
+	if !ok || status[0].Healthy || !status[1].Healthy {
+		t.Fatalf("expected: failing shard is unhealthy, result: %+v", status)
+	}
+	stats, _ := healthy.(sst.StatsStorage).Stats()
+	if stats.Total != 20 {
+		t.Fatalf("expected: %d, result: %d", 20, stats.Total)
+	}
+}
+
+func TestShardedStorage_WithHash(t *testing.T) {
+	storage, _ := sst.NewShardedStorage(sst.Shard{Name: "a", Storage: sst.NewMemStorage()}, sst.Shard{Name: "b", Storage: sst.NewMemStorage()})
+	hash := sst.GenHashKey()
+	secret, err := storage.Store(secretText, 1, expiresDelta, sst.WithHash(hash))
+	if err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+	if secret.Hash != hash {
+		t.Fatalf("expected: %s, result: %s", hash, secret.Hash)
+	}
+	if _, err = storage.Store("other", 1, expiresDelta, sst.WithHash(hash)); err != sst.ErrSecretExists {
+		t.Fatalf("expected: %s, result: %v", sst.ErrSecretExists, err)
+	}
+	if s, err := storage.Get(hash); err != nil || s.SecretText != secretText {
+		t.Fatalf("expected: %s, result: %s %v", secretText, s.SecretText, err)
+	}
+}
+
+func TestShardedStorage_NativeExpiry(t *testing.T) {
+	native := storagemock.New(nil)
+	native.SetNativeExpiry(true)
+	swept := sst.NewMemStorage()
+	storage, _ := sst.NewShardedStorage(sst.Shard{Name: "native", Storage: native}, sst.Shard{Name: "swept", Storage: swept})
+	if sst.ExpiresNatively(storage) {
+		t.Fatal("storage with the swept shard should not expire natively")
+	}
+
+	for _, shard := range []sst.Storage{native, swept} {
+		secret, err := shard.Store(secretText, 1, expiresDelta)
+		if err != nil {
+			t.Fatal("error is not expected: ", err)
+		}
+		if _, err = shard.Get(secret.Hash); err != nil {
+			t.Fatal("error is not expected: ", err)
+		}
+	}
+	removed, err := storage.(sst.Purger).PurgeExpired()
+	if err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+	if removed != 1 {
+		t.Fatalf("expected: %d, result: %d", 1, removed)
+	}
+	stats, _ := native.Unwrap().(sst.StatsStorage).Stats()
+	if stats.Total != 1 {
+		t.Fatalf("native shard should not be swept, expected: %d, result: %d", 1, stats.Total)
+	}
+
+	other := storagemock.New(nil)
+	other.SetNativeExpiry(true)
+	storage, _ = sst.NewShardedStorage(sst.Shard{Name: "native", Storage: native}, sst.Shard{Name: "other", Storage: other})
+	if !sst.ExpiresNatively(storage) {
+		t.Fatal("storage of the native shards should expire natively")
+	}
+}
+
+func TestShardedStorage_Compact(t *testing.T) {
+	embedded, other := storagemock.New(nil), storagemock.New(nil)
+	embedded.SetCompaction(4096, nil)
+	other.SetCompaction(1024, nil)
+	storage, _ := sst.NewShardedStorage(sst.Shard{Name: "embedded", Storage: embedded}, sst.Shard{Name: "other", Storage: other}, sst.Shard{Name: "memory", Storage: sst.NewMemStorage()})
+	compactor, ok := sst.CompactorOf(storage)
+	if !ok {
+		t.Fatal("sharded storage should be compacted")
+	}
+	reclaimed, err := compactor.Compact()
+	if err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+	if reclaimed != 5120 {
+		t.Fatalf("expected: %d, result: %d", 5120, reclaimed)
+	}
+
+	failure := errors.New("database is locked")
+	other.SetCompaction(0, failure)
+	if _, err = compactor.Compact(); err != failure {
+		t.Fatalf("expected: %v, result: %v", failure, err)
+	}
+	if _, ok = sst.CompactorOf(sst.NewMemStorage()); ok {
+		t.Fatal("memory storage should not be compacted")
+	}
+}
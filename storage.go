@@ -1,9 +1,13 @@
 package secret_server_task
 
 import (
+	"crypto/sha256"
+	"database/sql"
 	"encoding/hex"
 	"errors"
 	"log"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
@@ -18,7 +22,35 @@ var (
 	ErrInvalidExpireAfter      = errors.New("invalid expireAfter, the value should be 0 or higher")
 	ErrInvalidExpireAfterViews = errors.New("invalid expireAfterViews, the value should be positive")
 	ErrEmptySecret             = errors.New("secret can't be empty")
-	ErrSecretNotAvailable      = errors.New("secret is not available")
+	// ErrSecretNotAvailable is matched by ErrNotFound, ErrExpired and ErrViewsExhausted, compare with errors.Is
+	ErrSecretNotAvailable    = errors.New("secret is not available")
+	ErrEmptyReason           = errors.New("reason can't be empty")
+	ErrSecretExists          = errors.New("secret with the same hash already exists")
+	ErrEmptyOwner            = errors.New("owner can't be empty")
+	ErrInvalidNotBefore      = errors.New("invalid notBefore, the secret should become available before it expires")
+	ErrSecretNotYetAvailable = errors.New("secret is not available yet")
+	// ErrClaimRequired is returned by Get with the metadata of the secret which is served only by Reveal
+	ErrClaimRequired = errors.New("secret should be claimed before it is revealed")
+	// ErrReplica is returned by Get with the metadata of the replicated secret, the views are served by its home region.
+	// Reveal serves the view of the local copy
+	ErrReplica = errors.New("secret is a replica of the other region")
+	// ErrRecipientRequired is returned by Get with the metadata of the secret bound to the recipient credential,
+	// the caller verifies the credential against Secret.Recipient and serves the view by Reveal
+	ErrRecipientRequired = errors.New("secret is bound to the recipient credential")
+)
+
+// UnavailableReason explains ErrSecretNotAvailable. It is never sent to the recipients,
+// the public API responds the same way for all of them
+type UnavailableReason string
+
+// Unavailability reasons
+const (
+	// ReasonNotFound means the secret never existed, or it was purged, revoked or erased
+	ReasonNotFound UnavailableReason = "not_found"
+	// ReasonExpired means the TTL of the secret passed
+	ReasonExpired UnavailableReason = "expired"
+	// ReasonConsumed means the views of the secret are exhausted
+	ReasonConsumed UnavailableReason = "consumed"
 )
 
 // Secret represents the secret entity
@@ -28,10 +60,99 @@ type Secret struct {
 	CreatedAt      time.Time `json:"createdAt" xml:"createdAt" db:"created_at"`
 	ExpiresAt      time.Time `json:"expiresAt" xml:"expiresAt"`
 	RemainingViews int       `json:"remainingViews" xml:"remainingViews" db:"remaining_views"`
+	// NotBefore is the time the secret becomes available at, zero means immediately
+	NotBefore time.Time `json:"notBefore" xml:"notBefore"`
+	// Owner is the identity of the creator. It is never exposed to the recipients
+	Owner string `json:"-" xml:"-" db:"owner"`
+	// Tenant is the namespace of the secret, empty for the default tenant
+	Tenant string `json:"-" xml:"-" db:"tenant"`
+	// Labels is the metadata of the creator. It is never exposed to the recipients
+	Labels Labels `json:"-" xml:"-" db:"labels"`
+	// Views is the amount of served views
+	Views int `json:"-" xml:"-" db:"views"`
+	// WebhookURL is notified when the secret is viewed. It is set by the creator and never exposed to the recipients
+	WebhookURL string `json:"-" xml:"-" db:"webhook_url"`
+	// ClaimRequired means the views are served only by Reveal, so link previews can't consume them
+	ClaimRequired bool `json:"-" xml:"-" db:"claim_required"`
+	// HomeRegion is the region the secret is replicated from, empty for the secrets created locally
+	HomeRegion string `json:"-" xml:"-" db:"home_region"`
+	// Recipient is the binding of the recipient credential, see RecipientToken and RecipientCert.
+	// Empty means the URL is enough to retrieve the secret
+	Recipient string `json:"-" xml:"-" db:"recipient"`
+	// Filename and ContentType describe the secret which is the file, e.g. the key file. They are served
+	// to the recipients, so GET /secret/{hash}/download saves it under its name
+	Filename    string `json:"filename,omitempty" xml:"filename,omitempty" db:"filename"`
+	ContentType string `json:"contentType,omitempty" xml:"contentType,omitempty" db:"content_type"`
+	// CheckInInterval makes the secret the dead man's switch, see WithDeadMansSwitch. Zero for the other secrets
+	CheckInInterval time.Duration `json:"-" xml:"-" db:"check_in_interval"`
+	// ReleaseURL is notified with the link of the switch secret after the missed check-in. It is never exposed to the recipients
+	ReleaseURL string `json:"-" xml:"-" db:"release_url"`
+	// Released means the release of the switch secret was sent
+	Released bool `json:"-" xml:"-" db:"released"`
+	// Unavailable is the reason of ErrSecretNotAvailable returned by Get, empty if the storage doesn't know it
+	Unavailable UnavailableReason `json:"-" xml:"-" db:"-"`
+}
+
+// SecretOption sets the optional attributes of the new secret
+type SecretOption func(*Secret)
+
+// WithOwner sets the identity of the creator
+func WithOwner(owner string) SecretOption {
+	return func(s *Secret) {
+		s.Owner = owner
+	}
+}
+
+// WithFile marks the secret as the file with the name and the media type, empty type is detected at download
+func WithFile(filename, contentType string) SecretOption {
+	return func(s *Secret) {
+		s.Filename, s.ContentType = filename, contentType
+	}
 }
 
+// WithNotBefore makes the secret available only from the given time
+func WithNotBefore(notBefore time.Time) SecretOption {
+	return func(s *Secret) {
+		s.NotBefore = notBefore
+	}
+}
+
+// WithWebhookURL sets the URL notified about the views of the secret
+func WithWebhookURL(url string) SecretOption {
+	return func(s *Secret) {
+		s.WebhookURL = url
+	}
+}
+
+// WithClaimRequired enables the two-step reveal of the secret
+func WithClaimRequired() SecretOption {
+	return func(s *Secret) {
+		s.ClaimRequired = true
+	}
+}
+
+// IsAvailable checks the expire conditions at the current wall time
 func (s *Secret) IsAvailable() bool {
-	return (s.ExpiresAt.IsZero() || s.ExpiresAt.After(time.Now())) && s.RemainingViews > 0
+	return s.IsAvailableAt(time.Now())
+}
+
+// IsAvailableAt checks the expire conditions and NotBefore at the given time
+func (s *Secret) IsAvailableAt(now time.Time) bool {
+	return !s.IsExpiredAt(now) && (s.NotBefore.IsZero() || !now.Before(s.NotBefore))
+}
+
+// IsExpiredAt checks if the secret can never be retrieved again. The scheduled secret is not expired
+// before NotBefore, but it is not available either
+func (s *Secret) IsExpiredAt(now time.Time) bool {
+	return (!s.ExpiresAt.IsZero() && !s.ExpiresAt.After(now)) || s.RemainingViews <= 0
+}
+
+// unavailable returns the secret which only explains why the expired secret is not available, and the error of the reason
+func (s *Secret) unavailable() (Secret, error) {
+	if s.RemainingViews <= 0 {
+		return Secret{Unavailable: ReasonConsumed}, ErrViewsExhausted
+	}
+	return Secret{Unavailable: ReasonExpired}, ErrExpired
 }
 
 // GenHashKey generates the hash key for the secret. Uses UUID for unique ids
@@ -41,10 +162,15 @@ func GenHashKey() string {
 }
 
 // NewSecret creates the secret with generated Hash and validates input values
-func NewSecret(secret string, expireAfterViews, expireAfter int) (Secret, error) {
+func NewSecret(secret string, expireAfterViews, expireAfter int, opts ...SecretOption) (Secret, error) {
+	return NewSecretAt(SystemClock, secret, expireAfterViews, expireAfter, opts...)
+}
+
+// NewSecretAt creates the secret the same way as NewSecret using the clock for the creation time
+func NewSecretAt(clock Clock, secret string, expireAfterViews, expireAfter int, opts ...SecretOption) (Secret, error) {
 	var result Secret
 	result.Hash = GenHashKey()
-	result.CreatedAt = time.Now()
+	result.CreatedAt = clock.Now()
 
 	if secret == "" {
 		return Secret{}, ErrEmptySecret
@@ -64,6 +190,20 @@ func NewSecret(secret string, expireAfterViews, expireAfter int) (Secret, error)
 		result.ExpiresAt = result.CreatedAt.Add(time.Duration(expireAfter) * time.Minute)
 	}
 
+	for _, opt := range opts {
+		opt(&result)
+	}
+
+	if result.CheckInInterval < 0 {
+		return Secret{}, ErrInvalidCheckInInterval
+	}
+	if !result.NotBefore.IsZero() && !result.ExpiresAt.IsZero() && !result.NotBefore.Before(result.ExpiresAt) {
+		return Secret{}, ErrInvalidNotBefore
+	}
+	if err := result.Labels.Validate(); err != nil {
+		return Secret{}, err
+	}
+
 	return result, nil
 }
 
@@ -71,50 +211,293 @@ func NewSecret(secret string, expireAfterViews, expireAfter int) (Secret, error)
 type Storage interface {
 	// Store creates the new record in the database with the given values.
 	// Returns the created ID (Secret.Hash) and error if any
-	Store(secret string, expireAfterViews, expireAfter int) (Secret, error)
+	Store(secret string, expireAfterViews, expireAfter int, opts ...SecretOption) (Secret, error)
 	// Get checks the existence of a secret with the given key
 	// and validates the expire conditions
 	Get(key string) (Secret, error)
 }
 
+// Stats is a snapshot of the storage state
+type Stats struct {
+	// Total is the amount of stored records including not yet removed expired ones
+	Total int `json:"total" xml:"total" db:"total"`
+	// Available is the amount of secrets which can be retrieved
+	Available int `json:"available" xml:"available" db:"available"`
+	// Tombstones is the amount of soft deleted records kept for the audit
+	Tombstones int `json:"tombstones" xml:"tombstones" db:"tombstones"`
+}
+
+// StatsStorage is implemented by the storages which are able to report the statistics
+type StatsStorage interface {
+	Stats() (Stats, error)
+}
+
+// Purger is implemented by the storages which are able to remove all expired and consumed secrets at once
+type Purger interface {
+	// PurgeExpired removes all not available secrets and returns the amount of removed records
+	PurgeExpired() (int, error)
+}
+
+// Revoker is implemented by the storages which allow to burn a secret regardless of its expire conditions
+type Revoker interface {
+	// Revoke removes the secret with the given key. Returns ErrSecretNotAvailable if there is no such secret
+	Revoke(key, reason string) error
+}
+
+// Exporter is implemented by the storages which are able to iterate over the available secrets.
+// Export must not consume views.
+type Exporter interface {
+	// Export calls fn for every available secret and stops on the first error
+	Export(fn func(Secret) error) error
+}
+
+// Revealer is implemented by the storages which support the two-step reveal
+type Revealer interface {
+	// Reveal serves the view of the secret regardless of ClaimRequired and HomeRegion
+	Reveal(key string) (Secret, error)
+}
+
+// Peeker is implemented by the storages which are able to read the secret without consuming a view
+type Peeker interface {
+	// Peek returns the available secret as Get would, but the views are not counted
+	// and the expired secret is not removed
+	Peek(key string) (Secret, error)
+}
+
+// PeekerOf returns the Peeker of the storage or of its base storage
+func PeekerOf(st Storage) (Peeker, bool) {
+	if p, ok := st.(Peeker); ok {
+		return p, true
+	}
+	p, ok := Base(st).(Peeker)
+	return p, ok
+}
+
+// RevokerOf returns the Revoker of the storage or of its base storage
+func RevokerOf(st Storage) (Revoker, bool) {
+	if r, ok := st.(Revoker); ok {
+		return r, true
+	}
+	r, ok := Base(st).(Revoker)
+	return r, ok
+}
+
+// RevealSecret serves the view of the claimed secret by the storage or by its base storage
+func RevealSecret(st Storage, key string) (Secret, error) {
+	if r, ok := st.(Revealer); ok {
+		return r.Reveal(key)
+	}
+	if r, ok := Base(st).(Revealer); ok {
+		return r.Reveal(key)
+	}
+	return Secret{}, ErrSecretNotAvailable
+}
+
+// gate returns the error of Get for the secrets which are served only by Reveal
+func (s Secret) gate(reveal bool) error {
+	switch {
+	case reveal:
+		return nil
+	case s.HomeRegion != "":
+		return ErrReplica
+	case s.Recipient != "":
+		return ErrRecipientRequired
+	case s.ClaimRequired:
+		return ErrClaimRequired
+	}
+	return nil
+}
+
+// gated reports whether Get returned the metadata of the secret which is served only by Reveal
+func gated(err error) bool {
+	return err == ErrClaimRequired || err == ErrReplica || err == ErrRecipientRequired
+}
+
+// peek returns the secret if it is available at now
+func (s Secret) peek(now time.Time) (Secret, error) {
+	if s.IsAvailableAt(now) {
+		return s, nil
+	}
+	if !s.IsExpiredAt(now) {
+		return Secret{}, ErrSecretNotYetAvailable
+	}
+	_, err := s.unavailable()
+	return Secret{}, err
+}
+
+// metadata returns the copy of the secret without the text
+func (s Secret) metadata() Secret {
+	s.SecretText = ""
+	return s
+}
+
+// Importer is implemented by the storages which are able to restore the exported secrets
+type Importer interface {
+	// Import stores the secret as is. Returns ErrSecretExists if the hash is already used
+	Import(secret Secret) error
+}
+
+// ErasureReport describes the data removed by EraseOwner.
+// Digest is the SHA-256 of the sorted erased hashes, so the report can be verified against the hash list.
+type ErasureReport struct {
+	Owner              string    `json:"owner" xml:"owner"`
+	ErasedSecrets      int       `json:"erasedSecrets" xml:"erasedSecrets"`
+	ErasedAuditRecords int       `json:"erasedAuditRecords" xml:"erasedAuditRecords"`
+	Hashes             []string  `json:"hashes" xml:"hashes>hash"`
+	Digest             string    `json:"digest" xml:"digest"`
+	ErasedAt           time.Time `json:"erasedAt" xml:"erasedAt"`
+}
+
+func newErasureReport(owner string, hashes []string, auditRecords int, erasedAt time.Time) ErasureReport {
+	sort.Strings(hashes)
+	digest := sha256.Sum256([]byte(strings.Join(hashes, "\n")))
+	return ErasureReport{
+		Owner:              owner,
+		ErasedSecrets:      len(hashes),
+		ErasedAuditRecords: auditRecords,
+		Hashes:             hashes,
+		Digest:             hex.EncodeToString(digest[:]),
+		ErasedAt:           erasedAt,
+	}
+}
+
+// Eraser is implemented by the storages which are able to remove all data of the creator
+type Eraser interface {
+	// EraseOwner removes all secrets, tombstones and audit records of the owner
+	EraseOwner(owner string) (ErasureReport, error)
+}
+
 /*
  * In memory Storage implementation
  */
 
 // memStorage implements Storage interface and uses in-memory map for storing the data
 type memStorage struct {
-	values sync.Map
+	values   sync.Map
+	clock    Clock
+	onExpire ExpiryHook
+	history  memHistory
+	links    memLinks
+	locked   bool
+	// retention is how long the tombstones are kept, zero removes the secrets immediately
+	retention time.Duration
+	// keys encrypt the texts, nil keeps them as they are
+	keys *TenantKeys
 }
 
+// memSecret is the stored secret. With the locked memory the text is kept in text and Secret.SecretText is empty
 type memSecret struct {
 	mu sync.Mutex
 	Secret
+	text *lockedBuffer
+	// deletedAt is the time the secret became the tombstone, zero for the stored secret
+	deletedAt time.Time
+}
+
+// newMemSecret moves the text to the locked memory if it is enabled
+func (st *memStorage) newMemSecret(secret Secret) (*memSecret, error) {
+	mSecret := &memSecret{Secret: secret}
+	if st.locked {
+		text, err := newLockedBuffer(secret.SecretText)
+		if err != nil {
+			return nil, err
+		}
+		mSecret.text, mSecret.SecretText = text, ""
+	}
+	return mSecret, nil
+}
+
+// withText returns the copy of the secret with the text
+func (m *memSecret) withText() Secret {
+	s := m.Secret
+	if m.text != nil {
+		s.SecretText = m.text.String()
+	}
+	return s
+}
+
+// destroy zeroes the text of the secret which is removed or can't be served anymore
+func (m *memSecret) destroy() {
+	m.text.destroy()
+	m.text = nil
+}
+
+// ExpiryHook is called with the secret removed because of the expiry, the secret text is already scrubbed.
+// It is called synchronously by Get and PurgeExpired, so it must not block
+type ExpiryHook func(Secret)
+
+// expired calls the hook if any
+func (h ExpiryHook) expired(s Secret) {
+	if h != nil {
+		s.SecretText = ""
+		h(s)
+	}
+}
+
+// MemOption configures the memory based storage
+type MemOption func(*memStorage)
+
+// WithMemClock sets the clock used for the creation time and the expiry checks
+func WithMemClock(clock Clock) MemOption {
+	return func(st *memStorage) {
+		st.clock = clock
+	}
+}
+
+// WithMemExpiryHook sets the hook called for the expired secrets
+func WithMemExpiryHook(hook ExpiryHook) MemOption {
+	return func(st *memStorage) {
+		st.onExpire = hook
+	}
 }
 
 // NewMemStorage creates the memory based storage
-func NewMemStorage() Storage {
-	return &memStorage{}
+func NewMemStorage(opts ...MemOption) Storage {
+	st := &memStorage{clock: SystemClock, history: memHistory{secrets: map[string]*memViews{}}, links: memLinks{links: map[string]*ShareLink{}}}
+	for _, opt := range opts {
+		opt(st)
+	}
+	return st
 }
 
-// Store
-func (st *memStorage) Store(secret string, expireAfterViews int, expireAfter int) (Secret, error) {
-	var err error
-	var mSecret memSecret
+// Store. The colliding hash is generated again
+func (st *memStorage) Store(secret string, expireAfterViews int, expireAfter int, opts ...SecretOption) (Secret, error) {
+	return storeUnique(func() (Secret, error) {
+		return NewSecretAt(st.clock, secret, expireAfterViews, expireAfter, opts...)
+	}, st.insert)
+}
 
-	mSecret.Secret, err = NewSecret(secret, expireAfterViews, expireAfter)
+// insert stores the secret unless its hash is taken
+func (st *memStorage) insert(s Secret) error {
+	s, err := st.keys.sealSecret(s)
 	if err != nil {
-		return Secret{}, err
+		return err
 	}
-	st.values.Store(mSecret.Hash, &mSecret)
-
-	return mSecret.Secret, nil
+	mSecret, err := st.newMemSecret(s)
+	if err != nil {
+		return err
+	}
+	if _, loaded := st.values.LoadOrStore(s.Hash, mSecret); loaded {
+		mSecret.destroy()
+		return ErrSecretExists
+	}
+	return nil
 }
 
 // Get
 func (st *memStorage) Get(key string) (Secret, error) {
+	return st.get(key, false)
+}
+
+// Reveal
+func (st *memStorage) Reveal(key string) (Secret, error) {
+	return st.get(key, true)
+}
+
+func (st *memStorage) get(key string, reveal bool) (Secret, error) {
 	secret, ok := st.values.Load(key)
 	if !ok {
-		return Secret{}, ErrSecretNotAvailable
+		return Secret{Unavailable: ReasonNotFound}, ErrNotFound
 	}
 	mSecret := secret.(*memSecret)
 
@@ -122,115 +505,728 @@ func (st *memStorage) Get(key string) (Secret, error) {
 	// If the record is already not available there is no need to lock the mutex.
 	// If it is available then we need to lock the mutex and check the availability again.
 	// Then reduce the amount of available views
-	if mSecret.IsAvailable() {
+	if mSecret.IsAvailableAt(st.clock.Now()) {
 		mSecret.mu.Lock()
 		defer mSecret.mu.Unlock()
 
-		if mSecret.IsAvailable() {
+		if mSecret.IsAvailableAt(st.clock.Now()) {
+			if err := mSecret.gate(reveal); err != nil {
+				return mSecret.metadata(), err
+			}
+			secret, err := st.keys.openSecret(mSecret.withText())
+			if err != nil {
+				return secret, err
+			}
 			mSecret.RemainingViews--
-			return mSecret.Secret, nil
+			mSecret.Views++
+			secret.RemainingViews, secret.Views = mSecret.RemainingViews, mSecret.Views
+			if mSecret.RemainingViews == 0 {
+				// The last view, the text is never served again
+				if st.retention > 0 {
+					st.bury(mSecret)
+				} else {
+					mSecret.destroy()
+				}
+			}
+			return secret, nil
+		}
+	}
+
+	if !mSecret.IsExpiredAt(st.clock.Now()) {
+		// Scheduled secret, it must be kept until NotBefore
+		return Secret{}, ErrSecretNotYetAvailable
+	}
+
+	if st.retention > 0 {
+		mSecret.mu.Lock()
+		defer mSecret.mu.Unlock()
+		if mSecret.deletedAt.IsZero() {
+			st.bury(mSecret)
+			st.onExpire.expired(mSecret.Secret)
 		}
+		return mSecret.unavailable()
 	}
 
 	// Secret is expired, remove it from the memory
-	st.values.Delete(key)
+	if _, loaded := st.values.LoadAndDelete(key); loaded {
+		mSecret.mu.Lock()
+		mSecret.destroy()
+		mSecret.mu.Unlock()
+		st.onExpire.expired(mSecret.Secret)
+	}
 
-	return Secret{}, ErrSecretNotAvailable
+	return mSecret.unavailable()
+}
+
+// Peek
+func (st *memStorage) Peek(key string) (Secret, error) {
+	secret, ok := st.values.Load(key)
+	if !ok {
+		return Secret{}, ErrNotFound
+	}
+	mSecret := secret.(*memSecret)
+	mSecret.mu.Lock()
+	defer mSecret.mu.Unlock()
+	s, err := mSecret.withText().peek(st.clock.Now())
+	if err != nil {
+		return s, err
+	}
+	return st.keys.openSecret(s)
+}
+
+// Stats
+func (st *memStorage) Stats() (Stats, error) {
+	var stats Stats
+	st.values.Range(func(key, value interface{}) bool {
+		mSecret := value.(*memSecret)
+		mSecret.mu.Lock()
+		defer mSecret.mu.Unlock()
+
+		stats.Total++
+		if mSecret.IsAvailableAt(st.clock.Now()) {
+			stats.Available++
+		}
+		if !mSecret.deletedAt.IsZero() {
+			stats.Tombstones++
+		}
+		return true
+	})
+	return stats, nil
+}
+
+// PurgeExpired. If the retention is enabled the expired secrets become tombstones,
+// and the tombstones older than the retention period are removed
+func (st *memStorage) PurgeExpired() (int, error) {
+	var removed int
+	st.values.Range(func(key, value interface{}) bool {
+		mSecret := value.(*memSecret)
+		mSecret.mu.Lock()
+		defer mSecret.mu.Unlock()
+
+		if !mSecret.deletedAt.IsZero() {
+			if !mSecret.deletedAt.After(st.clock.Now().Add(-st.retention)) {
+				st.values.Delete(key)
+				removed++
+			}
+		} else if mSecret.IsExpiredAt(st.clock.Now()) && st.retention > 0 {
+			st.bury(mSecret)
+			st.onExpire.expired(mSecret.Secret)
+			removed++
+		} else if mSecret.IsExpiredAt(st.clock.Now()) {
+			st.values.Delete(key)
+			mSecret.destroy()
+			st.onExpire.expired(mSecret.Secret)
+			removed++
+		}
+		return true
+	})
+	st.purgeViews(st.clock.Now())
+	st.purgeLinks(st.clock.Now())
+	return removed, nil
+}
+
+// Revoke. In-memory storage doesn't keep the reason, it exists only in the admin log
+func (st *memStorage) Revoke(key, reason string) error {
+	if reason == "" {
+		return ErrEmptyReason
+	}
+	value, ok := st.values.LoadAndDelete(key)
+	if !ok {
+		return ErrNotFound
+	}
+	mSecret := value.(*memSecret)
+	mSecret.mu.Lock()
+	mSecret.destroy()
+	mSecret.mu.Unlock()
+	return nil
+}
+
+// Export
+func (st *memStorage) Export(fn func(Secret) error) error {
+	var err error
+	st.values.Range(func(key, value interface{}) bool {
+		mSecret := value.(*memSecret)
+		mSecret.mu.Lock()
+		secret, expired := mSecret.withText(), mSecret.IsExpiredAt(st.clock.Now())
+		mSecret.mu.Unlock()
+
+		if expired {
+			return true
+		}
+		secret, openErr := st.keys.openSecret(secret)
+		if openErr != nil {
+			// The secrets of the destroyed tenant keys are crypto-shredded, they are not exported
+			return true
+		}
+		err = fn(secret)
+		return err == nil
+	})
+	return err
+}
+
+// Import
+func (st *memStorage) Import(secret Secret) error {
+	return st.insert(secret)
+}
+
+// EraseOwner. In-memory storage keeps no audit records
+func (st *memStorage) EraseOwner(owner string) (ErasureReport, error) {
+	if owner == "" {
+		return ErasureReport{}, ErrEmptyOwner
+	}
+	hashes := []string{}
+	st.values.Range(func(key, value interface{}) bool {
+		mSecret := value.(*memSecret)
+		if mSecret.Owner == owner {
+			st.values.Delete(key)
+			mSecret.mu.Lock()
+			mSecret.destroy()
+			mSecret.mu.Unlock()
+			hashes = append(hashes, key.(string))
+		}
+		return true
+	})
+	st.eraseLinks(owner)
+	return newErasureReport(owner, hashes, st.eraseViews(owner), st.clock.Now()), nil
 }
 
 /*
  * Storage implementation using PostgreSQL
  */
 
+// pgSecretColumns are the columns of the secret table read and written by the storage
+const (
+	pgSecretColumns = "id, secret_text, created_at, expires_at, remaining_views, owner, tenant, webhook_url, not_before, views, labels, claim_required, home_region, recipient, filename, content_type, check_in_interval, release_url, released"
+	pgSecretValues  = ":id, :secret_text, :created_at, :expires_at, :remaining_views, :owner, :tenant, :webhook_url, :not_before, :views, :labels, :claim_required, :home_region, :recipient, :filename, :content_type, :check_in_interval, :release_url, :released"
+)
+
 type pgSecret struct {
 	Secret
 	ExpiresAt pq.NullTime `db:"expires_at"`
+	NotBefore pq.NullTime `db:"not_before"`
+}
+
+func newPgSecret(s Secret) pgSecret {
+	return pgSecret{
+		Secret:    s,
+		ExpiresAt: pq.NullTime{Time: s.ExpiresAt, Valid: !s.ExpiresAt.IsZero()},
+		NotBefore: pq.NullTime{Time: s.NotBefore, Valid: !s.NotBefore.IsZero()},
+	}
 }
 
 func (p *pgSecret) ToSecret() Secret {
 	if p.ExpiresAt.Valid {
 		p.Secret.ExpiresAt = p.ExpiresAt.Time
 	}
+	if p.NotBefore.Valid {
+		p.Secret.NotBefore = p.NotBefore.Time
+	}
 	return p.Secret
 }
 
 // pgStorage implements Storage interface and uses PostgreSQL.
 type pgStorage struct {
-	db *sqlx.DB
+	db        *sqlx.DB
+	retention time.Duration
+	clock     Clock
+	onExpire  ExpiryHook
+	// notifyChannel is the channel of the storage events, empty if they are not published
+	notifyChannel string
+	// outbox enables the transactional recording of the webhook notifications
+	outbox bool
+	// retryAttempts is the maximum amount of attempts of the operation failed with the transient error
+	retryAttempts int
+	retryBackoff  time.Duration
+	// retries is the amount of the retried operations, accessed atomically
+	retries uint64
+	// keys encrypt the secret_text column, nil keeps the texts as they are
+	keys *TenantKeys
+}
+
+// PgOption configures the PostgreSQL based storage
+type PgOption func(*pgStorage)
+
+// WithRetention enables the soft deletion of the consumed and expired secrets.
+// Instead of removing the row the secret text is scrubbed and deleted_at is set,
+// the tombstone is physically removed by PurgeExpired after the retention period.
+// Zero retention means immediate hard deletion.
+func WithRetention(retention time.Duration) PgOption {
+	return func(st *pgStorage) {
+		st.retention = retention
+	}
+}
+
+// WithPgClock sets the clock used for the creation time and the expiry checks.
+// The database time is never used, so the clock controls the expiry completely
+func WithPgClock(clock Clock) PgOption {
+	return func(st *pgStorage) {
+		st.clock = clock
+	}
+}
+
+// WithPgExpiryHook sets the hook called for the expired secrets
+func WithPgExpiryHook(hook ExpiryHook) PgOption {
+	return func(st *pgStorage) {
+		st.onExpire = hook
+	}
 }
 
 // NewPgStorage creates the PostgreSQL based storage
-func NewPgStorage(db *sqlx.DB) Storage {
-	return &pgStorage{db: db}
+func NewPgStorage(db *sqlx.DB, opts ...PgOption) Storage {
+	st := &pgStorage{db: db, clock: SystemClock, retryAttempts: defaultRetryAttempts, retryBackoff: defaultRetryBackoff}
+	for _, opt := range opts {
+		opt(st)
+	}
+	return st
+}
+
+// pgSchema lists the columns of the tables used by the storage, schema.sql creates them
+var pgSchema = []struct{ table, columns string }{
+	{"secret", pgSecretColumns + ", deleted_at"},
+	{"secret_view", "seq, id, owner, viewed_at, remote_addr, user_agent"},
+	{"secret_revocation", "id, reason, revoked_at, owner"},
+	{"secret_link", pgLinkColumns},
+	{"outbox", "id, event, hash, url, remaining_views, created_at, attempts, next_attempt_at"},
 }
 
-func (st *pgStorage) Store(secret string, expireAfterViews int, expireAfter int) (Secret, error) {
+// VerifyPgSchema checks that the tables and the columns used by the storage exist,
+// so the database without the latest schema.sql changes is detected before serving.
+// The error lists every table which can't be read
+func VerifyPgSchema(db *sqlx.DB) error {
+	var failed []string
+	for _, t := range pgSchema {
+		rows, err := db.Query("SELECT " + t.columns + " FROM " + t.table + " LIMIT 0")
+		if err != nil {
+			failed = append(failed, t.table+": "+err.Error())
+			continue
+		}
+		_ = rows.Close()
+	}
+	if len(failed) > 0 {
+		return errors.New("schema is not up to date: " + strings.Join(failed, "; "))
+	}
+	return nil
+}
 
+// remove deletes the secret or turns it into the tombstone if the retention is enabled
+func (st *pgStorage) remove(e sqlx.Execer, key string) error {
 	var err error
-	var pSecret pgSecret
+	if st.retention > 0 {
+		q := "UPDATE secret SET secret_text = '', deleted_at = $2 WHERE id=$1 AND deleted_at IS NULL"
+		_, err = e.Exec(q, key, st.clock.Now())
+	} else {
+		_, err = e.Exec("DELETE FROM secret WHERE id=$1", key)
+	}
+	return err
+}
 
-	pSecret.Secret, err = NewSecret(secret, expireAfterViews, expireAfter)
+// Store. The colliding hash, including the tombstone's one, is generated again
+func (st *pgStorage) Store(secret string, expireAfterViews int, expireAfter int, opts ...SecretOption) (Secret, error) {
+	s, err := storeUnique(func() (Secret, error) {
+		return NewSecretAt(st.clock, secret, expireAfterViews, expireAfter, opts...)
+	}, func(s Secret) error {
+		s, err := st.keys.sealSecret(s)
+		if err != nil {
+			return err
+		}
+		return st.retry(false, func() error {
+			_, err := st.db.NamedExec("INSERT INTO secret("+pgSecretColumns+") values("+pgSecretValues+")", newPgSecret(s))
+			if uniqueViolation(err) {
+				return ErrSecretExists
+			}
+			return err
+		})
+	})
 	if err != nil {
 		return Secret{}, err
 	}
+	// The secret is already stored, the subscribers miss the event only
+	if err = st.notify(st.db, st.newEvent(EventStored, s)); err != nil {
+		log.Println(err)
+	}
+	return s, nil
+}
 
-	pSecret.ExpiresAt.Valid = !pSecret.Secret.ExpiresAt.IsZero()
+// uniqueViolation reports whether the insert failed because the primary key is taken
+func uniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
 
-	q := "INSERT INTO secret(id, secret_text, created_at, expires_at, remaining_views) values(:id, :secret_text, :created_at, :expires_at, :remaining_views)"
-	_, err = st.db.NamedExec(q, pSecret)
+func (st *pgStorage) Get(key string) (Secret, error) {
+	return st.get(key, false)
+}
 
-	if err != nil {
-		return Secret{}, err
+func (st *pgStorage) Reveal(key string) (Secret, error) {
+	return st.get(key, true)
+}
+
+func (st *pgStorage) get(key string, reveal bool) (Secret, error) {
+	var secret Secret
+	err := st.retry(true, func() (err error) {
+		secret, err = st.getTx(key, reveal)
+		return err
+	})
+	if err == nil || errors.Is(err, ErrSecretNotAvailable) || err == ErrSecretNotYetAvailable || gated(err) {
+		return secret, err
 	}
-	return pSecret.Secret, nil
+	log.Println(err)
+	return Secret{}, &BackendError{Op: "get", Err: err}
 }
 
-func (st *pgStorage) Get(key string) (secret Secret, err error) {
+// getTx counts the view in the transaction. It is rolled back unless the secret is viewed or its state is reported
+func (st *pgStorage) getTx(key string, reveal bool) (secret Secret, err error) {
 	var tx *sqlx.Tx
 	tx, err = st.db.Beginx()
 	if err != nil {
-		log.Println(err)
-		return Secret{}, ErrSecretNotAvailable
+		return Secret{}, err
 	}
 	defer func() {
-		if err != nil && err != ErrSecretNotAvailable {
-			log.Println(err)
-			err = ErrSecretNotAvailable
-			e := tx.Rollback()
-			if e != nil {
+		if err != nil && !errors.Is(err, ErrSecretNotAvailable) && err != ErrSecretNotYetAvailable && !gated(err) {
+			if e := tx.Rollback(); e != nil {
 				log.Println(e)
 			}
 			return
 		}
 		if e := tx.Commit(); e != nil {
-			log.Println(e)
-			err = ErrSecretNotAvailable
+			secret, err = Secret{}, commitError{e}
 		}
 	}()
 
-	var pSecret pgSecret
-	q := "SELECT id, secret_text, created_at, expires_at, remaining_views FROM secret WHERE id=$1 FOR UPDATE"
+	// The tombstones are read too, they tell why the secret is not available
+	var pSecret pgPurgedSecret
+	q := "SELECT " + pgSecretColumns + ", deleted_at IS NOT NULL AS tombstone FROM secret WHERE id=$1 FOR UPDATE"
 	err = tx.Get(&pSecret, q, key)
+	if err == sql.ErrNoRows {
+		return Secret{Unavailable: ReasonNotFound}, ErrNotFound
+	}
 	if err != nil {
 		return Secret{}, err
 	}
 
 	secret = pSecret.ToSecret()
+	if pSecret.Tombstone {
+		return secret.unavailable()
+	}
+	if secret, err = st.keys.openSecret(secret); err != nil {
+		return secret, err
+	}
 
-	if secret.IsAvailable() {
+	if secret.IsAvailableAt(st.clock.Now()) {
+		if err = secret.gate(reveal); err != nil {
+			return secret.metadata(), err
+		}
 		secret.RemainingViews--
-		q = "UPDATE secret set remaining_views = remaining_views-1 WHERE id=$1"
+		secret.Views++
+		q = "UPDATE secret set remaining_views = remaining_views-1, views = views+1 WHERE id=$1"
 		_, err = tx.Exec(q, key)
+		if err == nil && secret.RemainingViews == 0 && st.retention > 0 {
+			// The last view is consumed, there is no need to keep the text until the next request
+			err = st.remove(tx, key)
+		}
+		if err == nil {
+			event := EventViewed
+			if secret.RemainingViews == 0 {
+				event = EventConsumed
+			}
+			err = st.notify(tx, st.newEvent(event, secret))
+		}
+		if err == nil {
+			err = st.addOutboxEvent(tx, secret)
+		}
 		if err != nil {
 			return Secret{}, err
 		}
 		return secret, nil
+	} else if !secret.IsExpiredAt(st.clock.Now()) {
+		// Scheduled secret, it must be kept until NotBefore
+		return Secret{}, ErrSecretNotYetAvailable
 	} else {
-		q = "DELETE FROM secret WHERE id=$1"
-		_, err = tx.Exec(q, key)
+		err = st.remove(tx, key)
+		if err == nil {
+			err = st.notify(tx, st.newEvent(EventExpired, secret))
+		}
 		if err != nil {
 			return Secret{}, err
 		}
-		return Secret{}, ErrSecretNotAvailable
+		st.onExpire.expired(secret)
+		return secret.unavailable()
+	}
+}
+
+func (st *pgStorage) Peek(key string) (Secret, error) {
+	var pSecret pgSecret
+	q := "SELECT " + pgSecretColumns + " FROM secret WHERE id=$1 AND deleted_at IS NULL"
+	err := st.retry(true, func() error {
+		return st.db.Get(&pSecret, q, key)
+	})
+	if err == sql.ErrNoRows {
+		return Secret{}, ErrNotFound
+	}
+	if err != nil {
+		log.Println(err)
+		return Secret{}, &BackendError{Op: "peek", Err: err}
+	}
+	s, err := pSecret.ToSecret().peek(st.clock.Now())
+	if err != nil {
+		return s, err
+	}
+	return st.keys.openSecret(s)
+}
+
+func (st *pgStorage) Stats() (Stats, error) {
+	var stats Stats
+	// Current time is passed from the application, so it is compared the same way as in IsAvailable
+	q := `SELECT count(*) AS total,
+		count(*) FILTER (WHERE deleted_at IS NULL AND remaining_views > 0 AND (expires_at IS NULL OR expires_at > $1) AND (not_before IS NULL OR not_before <= $1)) AS available,
+		count(*) FILTER (WHERE deleted_at IS NOT NULL) AS tombstones
+		FROM secret`
+	err := st.retry(true, func() error {
+		return st.db.Get(&stats, q, st.clock.Now())
+	})
+	return stats, err
+}
+
+// PurgeExpired removes the expired secrets. If the retention is enabled they become tombstones,
+// and the tombstones older than the retention period are removed.
+func (st *pgStorage) PurgeExpired() (int, error) {
+	now := st.clock.Now()
+	// The history is not counted, it isn't the secret
+	err := st.retry(false, func() error {
+		_, err := st.db.Exec("DELETE FROM secret_view WHERE viewed_at < $1", now.Add(-ViewHistoryRetention))
+		return err
+	})
+	if err == nil {
+		err = st.retry(false, func() error {
+			_, err := st.db.Exec("DELETE FROM secret_link WHERE expires_at <= $1", now)
+			return err
+		})
+	}
+	if err != nil {
+		return 0, err
+	}
+	// The removed secrets are returned for the expiry hook, tombstones were reported when they were scrubbed
+	if st.retention == 0 {
+		q := "DELETE FROM secret WHERE remaining_views <= 0 OR expires_at <= $1 OR deleted_at IS NOT NULL RETURNING " +
+			pgSecretColumns + ", deleted_at IS NOT NULL AS tombstone"
+		var removed []pgPurgedSecret
+		err := st.retry(false, func() error {
+			return st.db.Select(&removed, q, now)
+		})
+		if err != nil {
+			return 0, err
+		}
+		var events []StorageEvent
+		for _, p := range removed {
+			if !p.Tombstone {
+				st.onExpire.expired(p.ToSecret())
+				events = append(events, st.newEvent(EventExpired, p.ToSecret()))
+			}
+		}
+		if err := st.notify(st.db, events...); err != nil {
+			log.Println(err)
+		}
+		return len(removed), nil
+	}
+
+	q := "UPDATE secret SET secret_text = '', deleted_at = $1 WHERE deleted_at IS NULL AND (remaining_views <= 0 OR expires_at <= $1) RETURNING " + pgSecretColumns
+	var scrubbed []pgSecret
+	err = st.retry(false, func() error {
+		return st.db.Select(&scrubbed, q, now)
+	})
+	if err != nil {
+		return 0, err
+	}
+	events := make([]StorageEvent, 0, len(scrubbed))
+	for _, p := range scrubbed {
+		st.onExpire.expired(p.ToSecret())
+		events = append(events, st.newEvent(EventExpired, p.ToSecret()))
+	}
+	if err := st.notify(st.db, events...); err != nil {
+		log.Println(err)
+	}
+
+	var res sql.Result
+	err = st.retry(false, func() (err error) {
+		res, err = st.db.Exec("DELETE FROM secret WHERE deleted_at <= $1", now.Add(-st.retention))
+		return err
+	})
+	if err != nil {
+		return len(scrubbed), err
+	}
+	removed, err := res.RowsAffected()
+	return len(scrubbed) + int(removed), err
+}
+
+// pgPurgedSecret is the row removed by PurgeExpired
+type pgPurgedSecret struct {
+	pgSecret
+	Tombstone bool `db:"tombstone"`
+}
+
+// Revoke removes the secret and records the revocation in the secret_revocation table
+func (st *pgStorage) Revoke(key, reason string) error {
+	if reason == "" {
+		return ErrEmptyReason
+	}
+	err := st.retry(true, func() error {
+		return st.revokeTx(key, reason)
+	})
+	if err != nil && !errors.Is(err, ErrSecretNotAvailable) {
+		return &BackendError{Op: "revoke", Err: err}
+	}
+	return err
+}
+
+func (st *pgStorage) revokeTx(key, reason string) error {
+	tx, err := st.db.Beginx()
+	if err != nil {
+		return err
+	}
+
+	var revoked struct {
+		Owner  string `db:"owner"`
+		Tenant string `db:"tenant"`
+	}
+	err = tx.Get(&revoked, "DELETE FROM secret WHERE id=$1 RETURNING owner, tenant", key)
+	if err == sql.ErrNoRows {
+		err = ErrNotFound
+	}
+	if err == nil {
+		q := "INSERT INTO secret_revocation(id, reason, revoked_at, owner) values($1, $2, $3, $4)"
+		_, err = tx.Exec(q, key, reason, st.clock.Now(), revoked.Owner)
+	}
+	if err == nil {
+		err = st.notify(tx, st.newEvent(EventRevoked, Secret{Hash: key, Tenant: revoked.Tenant}))
+	}
+	if err != nil {
+		if e := tx.Rollback(); e != nil {
+			log.Println(e)
+		}
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return commitError{err}
+	}
+	return nil
+}
+
+// Export reads the secrets using a cursor, so the whole table is never loaded into memory
+func (st *pgStorage) Export(fn func(Secret) error) error {
+	q := "SELECT " + pgSecretColumns + " FROM secret WHERE deleted_at IS NULL AND remaining_views > 0 AND (expires_at IS NULL OR expires_at > $1)"
+	// Only the query is retried, the secrets already passed to fn can't be taken back
+	var rows *sqlx.Rows
+	err := st.retry(true, func() (err error) {
+		rows, err = st.db.Queryx(q, st.clock.Now())
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var pSecret pgSecret
+		if err = rows.StructScan(&pSecret); err != nil {
+			return err
+		}
+		secret, openErr := st.keys.openSecret(pSecret.ToSecret())
+		if openErr != nil {
+			// The secrets of the destroyed tenant keys are crypto-shredded, they are not exported
+			continue
+		}
+		if err = fn(secret); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func (st *pgStorage) Import(secret Secret) error {
+	secret, err := st.keys.sealSecret(secret)
+	if err != nil {
+		return err
+	}
+	pSecret := newPgSecret(secret)
+
+	q := "INSERT INTO secret(" + pgSecretColumns + ") values(" + pgSecretValues + ") ON CONFLICT (id) DO NOTHING"
+	var res sql.Result
+	err = st.retry(false, func() (err error) {
+		res, err = st.db.NamedExec(q, pSecret)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrSecretExists
+	}
+	return nil
+}
+
+// EraseOwner removes the secrets including tombstones and the revocation records of the owner
+func (st *pgStorage) EraseOwner(owner string) (report ErasureReport, err error) {
+	if owner == "" {
+		return ErasureReport{}, ErrEmptyOwner
+	}
+	err = st.retry(true, func() (err error) {
+		report, err = st.eraseOwnerTx(owner)
+		return err
+	})
+	return report, err
+}
+
+func (st *pgStorage) eraseOwnerTx(owner string) (report ErasureReport, err error) {
+	tx, err := st.db.Beginx()
+	if err != nil {
+		return ErasureReport{}, err
+	}
+	defer func() {
+		if err != nil {
+			if e := tx.Rollback(); e != nil {
+				log.Println(e)
+			}
+			return
+		}
+		if e := tx.Commit(); e != nil {
+			report, err = ErasureReport{}, commitError{e}
+		}
+	}()
+
+	hashes := []string{}
+	if err = tx.Select(&hashes, "DELETE FROM secret WHERE owner=$1 RETURNING id", owner); err != nil {
+		return ErasureReport{}, err
+	}
+	res, err := tx.Exec("DELETE FROM secret_revocation WHERE owner=$1", owner)
+	if err != nil {
+		return ErasureReport{}, err
+	}
+	auditRecords, err := res.RowsAffected()
+	if err != nil {
+		return ErasureReport{}, err
+	}
+	if res, err = tx.Exec("DELETE FROM secret_view WHERE owner=$1", owner); err != nil {
+		return ErasureReport{}, err
+	}
+	views, err := res.RowsAffected()
+	if err != nil {
+		return ErasureReport{}, err
+	}
+	auditRecords += views
+	// The links are not the audit records, they are removed with the secrets
+	if _, err = tx.Exec("DELETE FROM secret_link WHERE owner=$1", owner); err != nil {
+		return ErasureReport{}, err
+	}
+	events := make([]StorageEvent, len(hashes))
+	for i, hash := range hashes {
+		events[i] = st.newEvent(EventErased, Secret{Hash: hash})
+	}
+	if err = st.notify(tx, events...); err != nil {
+		return ErasureReport{}, err
 	}
+	return newErasureReport(owner, hashes, int(auditRecords), st.clock.Now()), nil
 }
@@ -1,6 +1,7 @@
 package secret_server_task_test
 
 import (
+	"errors"
 	"flag"
 	"os"
 	"sync"
@@ -20,10 +21,12 @@ const (
 	goroutines     = 10000
 )
 
-var db *sqlx.DB
+var (
+	db    *sqlx.DB
+	dbUrl = flag.String("dbUrl", "", "db url for integration tests")
+)
 
 func TestMain(m *testing.M) {
-	dbUrl := flag.String("dbUrl", "", "db url for integration tests")
 	flag.Parse()
 
 	if !testing.Short() {
@@ -194,3 +197,309 @@ func integrationStorageTest(storage sst.Storage) func(t *testing.T) {
 		}
 	}
 }
+
+func TestIntegrationPurgeExpired(t *testing.T) {
+	if testing.Short() {
+		t.Skip()
+	}
+
+	t.Run("in-memory", purgeExpiredTest(sst.NewMemStorage()))
+
+	if db != nil {
+		t.Run("Postgres", purgeExpiredTest(sst.NewPgStorage(db)))
+	}
+}
+
+func purgeExpiredTest(storage sst.Storage) func(t *testing.T) {
+	return func(t *testing.T) {
+		consumed, err := storage.Store(secretText, 1, expiresDelta)
+		if err != nil {
+			t.Fatal("error is not expected: ", err)
+		}
+		if _, err = storage.Get(consumed.Hash); err != nil {
+			t.Fatal("error is not expected: ", err)
+		}
+		live, err := storage.Store(secretText, remainingViews, expiresDelta)
+		if err != nil {
+			t.Fatal("error is not expected: ", err)
+		}
+
+		removed, err := storage.(sst.Purger).PurgeExpired()
+		if err != nil {
+			t.Fatal("error is not expected: ", err)
+		}
+		if removed < 1 {
+			t.Fatalf("expected at least 1 removed record, result: %d", removed)
+		}
+		if _, err = storage.Get(live.Hash); err != nil {
+			t.Fatal("live secret should not be purged: ", err)
+		}
+	}
+}
+
+func TestIntegrationTombstones(t *testing.T) {
+	if testing.Short() {
+		t.Skip()
+	}
+
+	clock := sst.NewManualClock(time.Now())
+	t.Run("in-memory", tombstonesTest(clock, sst.NewMemStorage(sst.WithMemClock(clock), sst.WithMemRetention(time.Hour))))
+
+	if db != nil {
+		clock := sst.NewManualClock(time.Now())
+		t.Run("Postgres", tombstonesTest(clock, sst.NewPgStorage(db, sst.WithPgClock(clock), sst.WithRetention(time.Hour))))
+	}
+}
+
+func tombstonesTest(clock *sst.ManualClock, storage sst.Storage) func(t *testing.T) {
+	return func(t *testing.T) {
+		reader := storage.(sst.TombstoneReader)
+		consumed, err := storage.Store(secretText, 1, 0)
+		if err != nil {
+			t.Fatal("error is not expected: ", err)
+		}
+		expired, err := storage.Store(secretText, remainingViews, 1)
+		if err != nil {
+			t.Fatal("error is not expected: ", err)
+		}
+		if _, err = reader.Tombstone(consumed.Hash); !errors.Is(err, sst.ErrSecretNotAvailable) {
+			t.Fatalf("expected: %s, result: %s", sst.ErrSecretNotAvailable, err)
+		}
+
+		consumedAt := clock.Now()
+		if _, err = storage.Get(consumed.Hash); err != nil {
+			t.Fatal("error is not expected: ", err)
+		}
+		clock.Add(2 * time.Minute)
+		if _, err = storage.(sst.Purger).PurgeExpired(); err != nil {
+			t.Fatal("error is not expected: ", err)
+		}
+
+		testCases := map[string]struct {
+			hash      string
+			reason    sst.UnavailableReason
+			views     int
+			deletedAt time.Time
+		}{
+			"consumed": {consumed.Hash, sst.ReasonConsumed, 1, consumedAt},
+			"expired":  {expired.Hash, sst.ReasonExpired, 0, clock.Now()},
+		}
+		for name, tc := range testCases {
+			t.Run(name, func(t *testing.T) {
+				s, err := storage.Get(tc.hash)
+				if !errors.Is(err, sst.ErrSecretNotAvailable) || s.Unavailable != tc.reason {
+					t.Fatalf("expected: %s %s, result: %v %s", sst.ErrSecretNotAvailable, tc.reason, err, s.Unavailable)
+				}
+				tombstone, err := reader.Tombstone(tc.hash)
+				if err != nil {
+					t.Fatal("error is not expected: ", err)
+				}
+				if tombstone.Reason != tc.reason || tombstone.Views != tc.views || !tombstone.DeletedAt.Equal(tc.deletedAt) {
+					t.Fatalf("unexpected tombstone: %+v", tombstone)
+				}
+			})
+		}
+
+		stats, err := storage.(sst.StatsStorage).Stats()
+		if err != nil {
+			t.Fatal("error is not expected: ", err)
+		}
+		if stats.Tombstones < 2 {
+			t.Fatalf("expected at least 2 tombstones, result: %d", stats.Tombstones)
+		}
+
+		clock.Add(time.Hour)
+		if _, err = storage.(sst.Purger).PurgeExpired(); err != nil {
+			t.Fatal("error is not expected: ", err)
+		}
+		if _, err = reader.Tombstone(consumed.Hash); !errors.Is(err, sst.ErrSecretNotAvailable) {
+			t.Fatalf("expected: %s, result: %s", sst.ErrSecretNotAvailable, err)
+		}
+	}
+}
+
+func TestIntegrationHashCollision(t *testing.T) {
+	if testing.Short() {
+		t.Skip()
+	}
+
+	t.Run("in-memory", hashCollisionTest(sst.NewMemStorage()))
+
+	if db != nil {
+		t.Run("Postgres", hashCollisionTest(sst.NewPgStorage(db)))
+	}
+}
+
+func hashCollisionTest(storage sst.Storage) func(t *testing.T) {
+	return func(t *testing.T) {
+		taken, err := storage.Store(secretText, remainingViews, expiresDelta)
+		if err != nil {
+			t.Fatal("error is not expected: ", err)
+		}
+		fresh := sst.GenHashKey()
+		hashes := []string{taken.Hash, fresh}
+		generated := func(s *sst.Secret) {
+			s.Hash, hashes = hashes[0], hashes[1:]
+		}
+		secret, err := storage.Store("other", remainingViews, expiresDelta, generated)
+		if err != nil {
+			t.Fatal("error is not expected: ", err)
+		}
+		if secret.Hash != fresh {
+			t.Fatalf("expected: %s, result: %s", fresh, secret.Hash)
+		}
+		if s, err := storage.Get(taken.Hash); err != nil || s.SecretText != secretText {
+			t.Fatalf("colliding secret should stay: %v, %s", s, err)
+		}
+
+		fixed := func(s *sst.Secret) {
+			s.Hash = taken.Hash
+		}
+		if _, err = storage.Store("other", remainingViews, expiresDelta, fixed); err != sst.ErrSecretExists {
+			t.Fatalf("expected: %s, result: %s", sst.ErrSecretExists, err)
+		}
+	}
+}
+
+func TestMemStorage_Revoke(t *testing.T) {
+	storage := sst.NewMemStorage()
+	secret, err := storage.Store(secretText, remainingViews, expiresDelta)
+	if err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+
+	revoker := storage.(sst.Revoker)
+	if err = revoker.Revoke(secret.Hash, ""); err != sst.ErrEmptyReason {
+		t.Fatalf("expected: %s, result: %s", sst.ErrEmptyReason, err)
+	}
+	if err = revoker.Revoke(secret.Hash, "leaked"); err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+	if _, err = storage.Get(secret.Hash); !errors.Is(err, sst.ErrSecretNotAvailable) {
+		t.Fatalf("expected: %s, result: %s", sst.ErrSecretNotAvailable, err)
+	}
+	if err = revoker.Revoke(secret.Hash, "leaked"); !errors.Is(err, sst.ErrSecretNotAvailable) {
+		t.Fatalf("expected: %s, result: %s", sst.ErrSecretNotAvailable, err)
+	}
+}
+
+func TestMemStorage_Peek(t *testing.T) {
+	storage := sst.NewMemStorage()
+	secret, err := storage.Store(secretText, 1, expiresDelta)
+	if err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+
+	peeker := storage.(sst.Peeker)
+	for i := 0; i < 2; i++ {
+		v, err := peeker.Peek(secret.Hash)
+		if err != nil {
+			t.Fatal("error is not expected: ", err)
+		}
+		if v.RemainingViews != 1 {
+			t.Fatalf("expected: %d, result: %d", 1, v.RemainingViews)
+		}
+	}
+	if _, err = storage.Get(secret.Hash); err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+	if _, err = peeker.Peek(secret.Hash); !errors.Is(err, sst.ErrSecretNotAvailable) {
+		t.Fatalf("expected: %s, result: %s", sst.ErrSecretNotAvailable, err)
+	}
+}
+
+func TestMemStorage_LockedMemory(t *testing.T) {
+	storage := sst.NewMemStorage(sst.WithLockedMemory())
+	secret, err := storage.Store(secretText, 2, expiresDelta)
+	if errors.Is(err, sst.ErrLockedMemory) {
+		t.Skip("locked memory is not available: ", err)
+	}
+	if err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+
+	if v, err := storage.(sst.Peeker).Peek(secret.Hash); err != nil || v.SecretText != secretText {
+		t.Fatalf("expected: %s, result: %s, %v", secretText, v.SecretText, err)
+	}
+	for i := 0; i < 2; i++ {
+		v, err := storage.Get(secret.Hash)
+		if err != nil {
+			t.Fatal("error is not expected: ", err)
+		}
+		if v.SecretText != secretText {
+			t.Fatalf("expected: %s, result: %s", secretText, v.SecretText)
+		}
+	}
+	// The last view destroyed the text
+	if _, err = storage.Get(secret.Hash); !errors.Is(err, sst.ErrSecretNotAvailable) {
+		t.Fatalf("expected: %s, result: %s", sst.ErrSecretNotAvailable, err)
+	}
+
+	revoked, err := storage.Store(secretText, remainingViews, expiresDelta)
+	if err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+	if err = storage.(sst.Revoker).Revoke(revoked.Hash, "leaked"); err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+	if _, err = storage.Get(revoked.Hash); !errors.Is(err, sst.ErrSecretNotAvailable) {
+		t.Fatalf("expected: %s, result: %s", sst.ErrSecretNotAvailable, err)
+	}
+}
+
+func TestMemStorage_ExportImport(t *testing.T) {
+	source := sst.NewMemStorage()
+	secret, err := source.Store(secretText, remainingViews, expiresDelta)
+	if err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+
+	var exported []sst.Secret
+	err = source.(sst.Exporter).Export(func(s sst.Secret) error {
+		exported = append(exported, s)
+		return nil
+	})
+	if err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+	if len(exported) != 1 || exported[0].Hash != secret.Hash {
+		t.Fatalf("expected: [%s], result: %v", secret.Hash, exported)
+	}
+
+	target := sst.NewMemStorage()
+	if err = target.(sst.Importer).Import(exported[0]); err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+	if err = target.(sst.Importer).Import(exported[0]); err != sst.ErrSecretExists {
+		t.Fatalf("expected: %s, result: %s", sst.ErrSecretExists, err)
+	}
+	if v, err := target.Get(secret.Hash); err != nil || v.SecretText != secretText {
+		t.Fatalf("imported secret is not available: %v, %s", v, err)
+	}
+}
+
+func TestMemStorage_EraseOwner(t *testing.T) {
+	storage := sst.NewMemStorage()
+	owned, err := storage.Store(secretText, remainingViews, expiresDelta, sst.WithOwner("alice"))
+	if err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+	other, err := storage.Store(secretText, remainingViews, expiresDelta, sst.WithOwner("bob"))
+	if err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+
+	report, err := storage.(sst.Eraser).EraseOwner("alice")
+	if err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+	if report.ErasedSecrets != 1 || report.Hashes[0] != owned.Hash || report.Digest == "" {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+	if _, err = storage.Get(owned.Hash); !errors.Is(err, sst.ErrSecretNotAvailable) {
+		t.Fatalf("expected: %s, result: %s", sst.ErrSecretNotAvailable, err)
+	}
+	if _, err = storage.Get(other.Hash); err != nil {
+		t.Fatal("secret of another owner should not be erased: ", err)
+	}
+}
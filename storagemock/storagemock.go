@@ -0,0 +1,187 @@
+// Package storagemock provides the configurable fake of sst.Storage for the unit tests
+// of the services embedding the secret server.
+//
+//	st := storagemock.New(nil)
+//	st.SetGetError(errors.New("connection refused"))
+//	st.ScriptStore(storagemock.Response{Err: sst.ErrEmptySecret})
+//
+// Scripted responses are returned first, one per call, then the configured error if any,
+// then the call is passed to the backend storage.
+package storagemock
+
+import (
+	"sync"
+	"time"
+
+	sst "github.com/evsan/secret-server-task"
+)
+
+// Call is the recorded call of the fake
+type Call struct {
+	Method           string
+	Key              string
+	Secret           string
+	ExpireAfterViews int
+	ExpireAfter      int
+}
+
+// Response is the scripted result of one call
+type Response struct {
+	Secret sst.Secret
+	Err    error
+}
+
+// Storage implements sst.Storage recording the calls
+type Storage struct {
+	backend sst.Storage
+
+	mu          sync.Mutex
+	latency     time.Duration
+	storeErr    error
+	getErr      error
+	storeScript []Response
+	getScript   []Response
+	calls       []Call
+	native      bool
+	reclaimed   int64
+	compactErr  error
+}
+
+// New creates the fake passing the not scripted calls to the backend, the memory storage is used if nil
+func New(backend sst.Storage) *Storage {
+	if backend == nil {
+		backend = sst.NewMemStorage()
+	}
+	return &Storage{backend: backend}
+}
+
+// Unwrap returns the backend, so the optional capabilities of the backend are available
+func (s *Storage) Unwrap() sst.Storage {
+	return s.backend
+}
+
+// SetLatency delays every call by d
+func (s *Storage) SetLatency(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.latency = d
+}
+
+// SetStoreError makes every not scripted Store call fail with err, nil restores the backend
+func (s *Storage) SetStoreError(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.storeErr = err
+}
+
+// SetGetError makes every not scripted Get call fail with err, nil restores the backend
+func (s *Storage) SetGetError(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.getErr = err
+}
+
+// SetNativeExpiry makes the fake report that its backend enforces the expiry, see sst.NativeExpiry
+func (s *Storage) SetNativeExpiry(native bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.native = native
+}
+
+// ExpiresNatively
+func (s *Storage) ExpiresNatively() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.native
+}
+
+// SetCompaction makes Compact report the reclaimed bytes and fail with err, see sst.Compactor
+func (s *Storage) SetCompaction(reclaimed int64, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reclaimed, s.compactErr = reclaimed, err
+}
+
+// Compact records the call and returns the configured compaction result
+func (s *Storage) Compact() (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls = append(s.calls, Call{Method: "Compact"})
+	return s.reclaimed, s.compactErr
+}
+
+// ScriptStore queues the responses of the next Store calls
+func (s *Storage) ScriptStore(responses ...Response) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.storeScript = append(s.storeScript, responses...)
+}
+
+// ScriptGet queues the responses of the next Get calls
+func (s *Storage) ScriptGet(responses ...Response) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.getScript = append(s.getScript, responses...)
+}
+
+// Calls returns the calls made so far
+func (s *Storage) Calls() []Call {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Call(nil), s.calls...)
+}
+
+// Reset removes the recorded calls, the scripts and the errors
+func (s *Storage) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.latency, s.storeErr, s.getErr = 0, nil, nil
+	s.reclaimed, s.compactErr = 0, nil
+	s.storeScript, s.getScript, s.calls = nil, nil, nil
+}
+
+// Store
+func (s *Storage) Store(secret string, expireAfterViews, expireAfter int, opts ...sst.SecretOption) (sst.Secret, error) {
+	resp, ok := s.next(Call{Method: "Store", Secret: secret, ExpireAfterViews: expireAfterViews, ExpireAfter: expireAfter})
+	if ok {
+		return resp.Secret, resp.Err
+	}
+	return s.backend.Store(secret, expireAfterViews, expireAfter, opts...)
+}
+
+// Get
+func (s *Storage) Get(key string) (sst.Secret, error) {
+	resp, ok := s.next(Call{Method: "Get", Key: key})
+	if ok {
+		return resp.Secret, resp.Err
+	}
+	return s.backend.Get(key)
+}
+
+// next records the call, waits for the latency and returns the scripted or the error response if any
+func (s *Storage) next(call Call) (Response, bool) {
+	s.mu.Lock()
+	s.calls = append(s.calls, call)
+	latency := s.latency
+
+	script, err := &s.getScript, s.getErr
+	if call.Method == "Store" {
+		script, err = &s.storeScript, s.storeErr
+	}
+	var resp Response
+	ok := true
+	switch {
+	case len(*script) > 0:
+		resp, *script = (*script)[0], (*script)[1:]
+	case err != nil:
+		resp.Err = err
+	default:
+		ok = false
+	}
+	s.mu.Unlock()
+
+	if latency > 0 {
+		time.Sleep(latency)
+	}
+	return resp, ok
+}
@@ -0,0 +1,44 @@
+package storagemock_test
+
+import (
+	"errors"
+	"testing"
+
+	sst "github.com/evsan/secret-server-task"
+	"github.com/evsan/secret-server-task/storagemock"
+)
+
+const secretText = "secret"
+
+func TestStorage(t *testing.T) {
+	st := storagemock.New(nil)
+	errDown := errors.New("storage is down")
+
+	st.ScriptStore(storagemock.Response{Err: sst.ErrEmptySecret})
+	if _, err := st.Store(secretText, 1, 0); err != sst.ErrEmptySecret {
+		t.Fatalf("expected: %s, result: %v", sst.ErrEmptySecret, err)
+	}
+
+	secret, err := st.Store(secretText, 1, 0)
+	if err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+
+	st.SetGetError(errDown)
+	if _, err = st.Get(secret.Hash); err != errDown {
+		t.Fatalf("expected: %s, result: %v", errDown, err)
+	}
+
+	st.SetGetError(nil)
+	result, err := st.Get(secret.Hash)
+	if err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+	if result.SecretText != secretText {
+		t.Fatalf("expected: %s, result: %s", secretText, result.SecretText)
+	}
+
+	if len(st.Calls()) != 4 {
+		t.Fatalf("expected: %d, result: %d", 4, len(st.Calls()))
+	}
+}
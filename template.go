@@ -0,0 +1,54 @@
+package secret_server_task
+
+import (
+	"errors"
+	"regexp"
+)
+
+// Template errors
+var (
+	ErrInvalidTemplate = errors.New("invalid template, the name should match [a-z0-9-]{1,32}")
+	ErrUnknownTemplate = errors.New("unknown template")
+)
+
+var templateRe = regexp.MustCompile(`^[a-z0-9-]{1,32}$`)
+
+// Template is the named preset of the secret attributes selected at creation, e.g. "db-password".
+// The set fields replace the values given by the client
+type Template struct {
+	// ExpireAfter is the TTL in minutes, 0 means no TTL
+	ExpireAfter *int `json:"expireAfter,omitempty"`
+	// ExpireAfterViews is the amount of allowed views
+	ExpireAfterViews *int `json:"expireAfterViews,omitempty"`
+	// Labels are added to the labels of the client
+	Labels Labels `json:"labels,omitempty"`
+}
+
+// ValidateTemplate checks the name and the values of the template
+func ValidateTemplate(name string, t Template) error {
+	if !templateRe.MatchString(name) {
+		return ErrInvalidTemplate
+	}
+	if t.ExpireAfter != nil && *t.ExpireAfter < 0 {
+		return ErrInvalidExpireAfter
+	}
+	if t.ExpireAfterViews != nil && *t.ExpireAfterViews <= 0 {
+		return ErrInvalidExpireAfterViews
+	}
+	return t.Labels.Validate()
+}
+
+// ApplyLabels returns the labels of the client with the template labels on top
+func (t Template) ApplyLabels(labels Labels) Labels {
+	if len(t.Labels) == 0 {
+		return labels
+	}
+	result := make(Labels, len(labels)+len(t.Labels))
+	for k, v := range labels {
+		result[k] = v
+	}
+	for k, v := range t.Labels {
+		result[k] = v
+	}
+	return result
+}
@@ -0,0 +1,126 @@
+package secret_server_task
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+)
+
+// ErrInvalidTenant is returned for the tenant names which can't be used as a key prefix
+var ErrInvalidTenant = errors.New("invalid tenant, the name should match [a-z0-9-]{1,32}")
+
+var tenantRe = regexp.MustCompile(`^[a-z0-9-]{1,32}$`)
+
+// ValidateTenant checks the tenant name. Empty name is the default tenant
+func ValidateTenant(tenant string) error {
+	if tenant != "" && !tenantRe.MatchString(tenant) {
+		return ErrInvalidTenant
+	}
+	return nil
+}
+
+// tenantKeyPrefix returns the prefix of the storage keys of the tenant.
+// Generated hashes never contain "/", so keys of different tenants can't collide.
+func tenantKeyPrefix(tenant string) string {
+	if tenant == "" {
+		return ""
+	}
+	return tenant + "/"
+}
+
+// WithTenant places the secret into the tenant namespace: the tenant is recorded
+// and the storage key is prefixed with the tenant name
+func WithTenant(tenant string) SecretOption {
+	return func(s *Secret) {
+		s.Tenant = tenant
+		s.Hash = tenantKeyPrefix(tenant) + s.Hash
+	}
+}
+
+// tenantStorage isolates the secrets of the tenant using the key prefix.
+// The keys are prefixed on the way in and the prefix is stripped on the way out,
+// so the tenant never sees the keys of the other tenants.
+type tenantStorage struct {
+	Storage
+	tenant string
+}
+
+// NewTenantStorage returns the storage restricted to the namespace of the tenant.
+// The tenant must be validated with ValidateTenant, the default tenant gets the storage as is
+func NewTenantStorage(st Storage, tenant string) Storage {
+	if tenant == "" {
+		return st
+	}
+	return &tenantStorage{Storage: st, tenant: tenant}
+}
+
+func (st *tenantStorage) Store(secret string, expireAfterViews, expireAfter int, opts ...SecretOption) (Secret, error) {
+	opts = append(opts, WithTenant(st.tenant))
+	s, err := st.Storage.Store(secret, expireAfterViews, expireAfter, opts...)
+	if err != nil {
+		return Secret{}, err
+	}
+	s.Hash = strings.TrimPrefix(s.Hash, tenantKeyPrefix(st.tenant))
+	return s, nil
+}
+
+func (st *tenantStorage) Get(key string) (Secret, error) {
+	if strings.Contains(key, "/") {
+		return Secret{}, ErrNotFound
+	}
+	s, err := st.Storage.Get(tenantKeyPrefix(st.tenant) + key)
+	if errors.Is(err, ErrSecretNotAvailable) {
+		return Secret{Unavailable: s.Unavailable}, err
+	}
+	if err != nil && !gated(err) {
+		return Secret{}, err
+	}
+	s.Hash = key
+	return s, err
+}
+
+func (st *tenantStorage) Reveal(key string) (Secret, error) {
+	if strings.Contains(key, "/") {
+		return Secret{}, ErrNotFound
+	}
+	s, err := RevealSecret(st.Storage, tenantKeyPrefix(st.tenant)+key)
+	if err != nil {
+		return Secret{}, err
+	}
+	s.Hash = key
+	return s, nil
+}
+
+func (st *tenantStorage) Peek(key string) (Secret, error) {
+	p, ok := PeekerOf(st.Storage)
+	if !ok || strings.Contains(key, "/") {
+		return Secret{}, ErrSecretNotAvailable
+	}
+	s, err := p.Peek(tenantKeyPrefix(st.tenant) + key)
+	if err != nil {
+		return Secret{}, err
+	}
+	s.Hash = key
+	return s, nil
+}
+
+func (st *tenantStorage) Revoke(key, reason string) error {
+	r, ok := RevokerOf(st.Storage)
+	if !ok || strings.Contains(key, "/") {
+		return ErrSecretNotAvailable
+	}
+	return r.Revoke(tenantKeyPrefix(st.tenant)+key, reason)
+}
+
+func (st *tenantStorage) Tombstone(key string) (Tombstone, error) {
+	r, ok := TombstoneReaderOf(st.Storage)
+	if !ok || strings.Contains(key, "/") {
+		return Tombstone{}, ErrNotFound
+	}
+	t, err := r.Tombstone(tenantKeyPrefix(st.tenant) + key)
+	if err != nil {
+		return Tombstone{}, err
+	}
+	t.Hash = key
+	return t, nil
+}
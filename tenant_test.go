@@ -0,0 +1,55 @@
+package secret_server_task_test
+
+import (
+	"errors"
+	"testing"
+
+	sst "github.com/evsan/secret-server-task"
+)
+
+func TestTenantStorage_Isolation(t *testing.T) {
+	base := sst.NewMemStorage()
+	tenantA := sst.NewTenantStorage(base, "team-a")
+	tenantB := sst.NewTenantStorage(base, "team-b")
+
+	secret, err := tenantA.Store(secretText, remainingViews, expiresDelta)
+	if err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+
+	if _, err = tenantB.Get(secret.Hash); !errors.Is(err, sst.ErrSecretNotAvailable) {
+		t.Fatalf("expected: %s, result: %s", sst.ErrSecretNotAvailable, err)
+	}
+	if _, err = base.Get(secret.Hash); !errors.Is(err, sst.ErrSecretNotAvailable) {
+		t.Fatalf("expected: %s, result: %s", sst.ErrSecretNotAvailable, err)
+	}
+	if _, err = tenantB.Get("../team-a/" + secret.Hash); !errors.Is(err, sst.ErrSecretNotAvailable) {
+		t.Fatalf("expected: %s, result: %s", sst.ErrSecretNotAvailable, err)
+	}
+
+	v, err := tenantA.Get(secret.Hash)
+	if err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+	if v.Hash != secret.Hash || v.Tenant != "team-a" {
+		t.Fatalf("unexpected secret: %+v", v)
+	}
+}
+
+func TestValidateTenant(t *testing.T) {
+	testCases := map[string]error{
+		"":                                  nil,
+		"team-a":                            nil,
+		"Team":                              sst.ErrInvalidTenant,
+		"a/b":                               sst.ErrInvalidTenant,
+		"0123456789abcdef0123456789abcdefg": sst.ErrInvalidTenant,
+	}
+
+	for name, expected := range testCases {
+		t.Run(name, func(t *testing.T) {
+			if e := sst.ValidateTenant(name); e != expected {
+				t.Fatalf("expected: %s, result: %s", expected, e)
+			}
+		})
+	}
+}
@@ -0,0 +1,182 @@
+package secret_server_task
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"sync/atomic"
+)
+
+// sealedPrefix marks the texts sealed by TenantKeys, the key id follows it
+const sealedPrefix = "tk1:"
+
+// Errors of the tenant keys
+var (
+	// ErrTenantKey is returned by Store for the tenant without the key, e.g. the offboarded one
+	ErrTenantKey = errors.New("tenant has no encryption key")
+	errSealed    = errors.New("sealed text can't be opened")
+)
+
+var tenantKeyIDRe = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// TenantKey is the AES-256 key encrypting the texts of the tenant
+type TenantKey struct {
+	// ID is recorded in every sealed text, so the texts sealed by the rotated keys are still opened
+	ID  string `json:"id"`
+	Key []byte `json:"key"`
+}
+
+// TenantKeys encrypts the secret texts with the key of their tenant (AES-256-GCM bound to the tenant and the hash),
+// so the key of one tenant is rotated or destroyed without touching the others. Destroying all keys of the tenant
+// crypto-shreds its secrets: they are not found anymore and its new secrets are refused with ErrTenantKey
+type TenantKeys struct {
+	state atomic.Pointer[tenantKeyState]
+}
+
+// tenantKeyState is the set of the keys replaced at once
+type tenantKeyState struct {
+	// aeads are the ciphers of the tenants by the key id, the first key of the tenant seals
+	aeads map[string]map[string]cipher.AEAD
+	first map[string]string
+}
+
+// NewTenantKeys creates the keys by the tenant name, the default tenant is "". The first key of the tenant seals
+// the new texts and all of them open the stored ones, so the key is rotated by prepending the new one and
+// the old one is removed once the secrets it sealed expired
+func NewTenantKeys(keys map[string][]TenantKey) (*TenantKeys, error) {
+	state, err := newTenantKeyState(keys)
+	if err != nil {
+		return nil, err
+	}
+	k := &TenantKeys{}
+	k.state.Store(state)
+	return k, nil
+}
+
+// Replace swaps all keys for the keys of next at once, e.g. after the key file was rotated.
+// The storages keep using k, the texts being sealed or opened meanwhile use either set
+func (k *TenantKeys) Replace(next *TenantKeys) {
+	k.state.Store(next.state.Load())
+}
+
+func newTenantKeyState(keys map[string][]TenantKey) (*tenantKeyState, error) {
+	k := &tenantKeyState{aeads: map[string]map[string]cipher.AEAD{}, first: map[string]string{}}
+	for tenant, tenantKeys := range keys {
+		if err := ValidateTenant(tenant); err != nil {
+			return nil, err
+		}
+		k.aeads[tenant] = map[string]cipher.AEAD{}
+		for _, key := range tenantKeys {
+			if !tenantKeyIDRe.MatchString(key.ID) {
+				return nil, fmt.Errorf("invalid key id %q of tenant %q, it should match [A-Za-z0-9._-]{1,64}", key.ID, tenant)
+			}
+			if len(key.Key) != 32 {
+				return nil, fmt.Errorf("key %q of tenant %q should have 32 bytes", key.ID, tenant)
+			}
+			if _, ok := k.aeads[tenant][key.ID]; ok {
+				return nil, fmt.Errorf("key %q of tenant %q is repeated", key.ID, tenant)
+			}
+			block, err := aes.NewCipher(key.Key)
+			if err != nil {
+				return nil, err
+			}
+			if k.aeads[tenant][key.ID], err = cipher.NewGCM(block); err != nil {
+				return nil, err
+			}
+			if k.first[tenant] == "" {
+				k.first[tenant] = key.ID
+			}
+		}
+	}
+	return k, nil
+}
+
+// additionalData binds the sealed text to its row, so it can't be moved to the other hash or tenant
+func additionalData(tenant, key string) []byte {
+	return []byte(tenant + "\x00" + key)
+}
+
+// Seal encrypts the text of the secret stored at the key with the current key of the tenant
+func (k *TenantKeys) Seal(tenant, key, text string) (string, error) {
+	state := k.state.Load()
+	id := state.first[tenant]
+	if id == "" {
+		return "", ErrTenantKey
+	}
+	aead := state.aeads[tenant][id]
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(text)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(text), additionalData(tenant, key))
+	return sealedPrefix + id + ":" + base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+// Open decrypts the text sealed by Seal. The texts stored before the keys were configured are returned as they are
+func (k *TenantKeys) Open(tenant, key, text string) (string, error) {
+	if !strings.HasPrefix(text, sealedPrefix) {
+		return text, nil
+	}
+	id, payload, ok := strings.Cut(strings.TrimPrefix(text, sealedPrefix), ":")
+	aead := k.state.Load().aeads[tenant][id]
+	if !ok || aead == nil {
+		return "", errSealed
+	}
+	sealed, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return "", errSealed
+	}
+	plain, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], additionalData(tenant, key))
+	if err != nil {
+		return "", errSealed
+	}
+	return string(plain), nil
+}
+
+// sealSecret seals the text of the new secret, the nil keys keep it as is
+func (k *TenantKeys) sealSecret(s Secret) (Secret, error) {
+	if k == nil {
+		return s, nil
+	}
+	text, err := k.Seal(s.Tenant, s.Hash, s.SecretText)
+	if err != nil {
+		return Secret{}, err
+	}
+	s.SecretText = text
+	return s, nil
+}
+
+// openSecret opens the text of the stored secret. The secret whose key was destroyed is not found
+func (k *TenantKeys) openSecret(s Secret) (Secret, error) {
+	if k == nil || s.SecretText == "" {
+		return s, nil
+	}
+	text, err := k.Open(s.Tenant, s.Hash, s.SecretText)
+	if err != nil {
+		log.Printf("tenant keys: text of %s can't be opened, the key of tenant %q is destroyed or the text is corrupted", s.Hash, s.Tenant)
+		return Secret{Unavailable: ReasonNotFound}, ErrNotFound
+	}
+	s.SecretText = text
+	return s, nil
+}
+
+// WithMemTenantKeys encrypts the texts of the in-memory storage with the keys of their tenants
+func WithMemTenantKeys(keys *TenantKeys) MemOption {
+	return func(st *memStorage) {
+		st.keys = keys
+	}
+}
+
+// WithPgTenantKeys encrypts the secret_text column with the keys of the tenants. The rows written before
+// are read as they are, the backup exports and imports the texts opened
+func WithPgTenantKeys(keys *TenantKeys) PgOption {
+	return func(st *pgStorage) {
+		st.keys = keys
+	}
+}
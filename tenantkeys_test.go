@@ -0,0 +1,137 @@
+package secret_server_task_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	sst "github.com/evsan/secret-server-task"
+)
+
+func TestTenantKeys(t *testing.T) {
+	key := func(id string, b byte) sst.TenantKey {
+		return sst.TenantKey{ID: id, Key: bytes.Repeat([]byte{b}, 32)}
+	}
+	keys, err := sst.NewTenantKeys(map[string][]sst.TenantKey{
+		"":     {key("default-1", 1)},
+		"acme": {key("acme-2", 2), key("acme-1", 3)},
+	})
+	if err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+
+	sealed, err := keys.Seal("acme", "acme/hash", secretText)
+	if err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+	if strings.Contains(sealed, secretText) || !strings.Contains(sealed, "acme-2") {
+		t.Fatalf("text should be sealed by the first key: %s", sealed)
+	}
+	if text, err := keys.Open("acme", "acme/hash", sealed); err != nil || text != secretText {
+		t.Fatalf("expected: %s, result: %s %v", secretText, text, err)
+	}
+	// The sealed text is bound to its tenant and hash
+	for _, target := range [][2]string{{"", "acme/hash"}, {"acme", "acme/other"}} {
+		if _, err := keys.Open(target[0], target[1], sealed); err == nil {
+			t.Fatalf("text should not be opened for %v", target)
+		}
+	}
+	if text, err := keys.Open("acme", "acme/hash", secretText); err != nil || text != secretText {
+		t.Fatalf("plain text should be read as is: %s %v", text, err)
+	}
+
+	// The text sealed by the rotated key is still opened
+	old, _ := sst.NewTenantKeys(map[string][]sst.TenantKey{"acme": {key("acme-1", 3)}})
+	sealed, _ = old.Seal("acme", "acme/hash", secretText)
+	if text, err := keys.Open("acme", "acme/hash", sealed); err != nil || text != secretText {
+		t.Fatalf("expected: %s, result: %s %v", secretText, text, err)
+	}
+
+	invalid := map[string]map[string][]sst.TenantKey{
+		"short key": {"acme": {{ID: "acme-1", Key: []byte("short")}}},
+		"key id":    {"acme": {key("acme:1", 1)}},
+		"repeated":  {"acme": {key("acme-1", 1), key("acme-1", 2)}},
+		"tenant":    {"Acme": {key("acme-1", 1)}},
+	}
+	for name, keys := range invalid {
+		if _, err := sst.NewTenantKeys(keys); err == nil {
+			t.Fatalf("%s: error is expected", name)
+		}
+	}
+}
+
+func TestTenantKeys_Storage(t *testing.T) {
+	acmeKey := sst.TenantKey{ID: "acme-1", Key: bytes.Repeat([]byte{1}, 32)}
+	keys, _ := sst.NewTenantKeys(map[string][]sst.TenantKey{"acme": {acmeKey}})
+	storage := sst.NewMemStorage(sst.WithMemTenantKeys(keys))
+	acme := sst.NewTenantStorage(storage, "acme")
+	secret, err := acme.Store(secretText, 2, 0)
+	if err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+	if secret.SecretText != secretText {
+		t.Fatalf("expected: %s, result: %s", secretText, secret.SecretText)
+	}
+	if secret, err = acme.Get(secret.Hash); err != nil || secret.SecretText != secretText {
+		t.Fatalf("expected: %s, result: %s %v", secretText, secret.SecretText, err)
+	}
+	// The default tenant has no key, its secrets are refused
+	if _, err = storage.Store(secretText, 1, 0); err != sst.ErrTenantKey {
+		t.Fatalf("expected: %v, result: %v", sst.ErrTenantKey, err)
+	}
+}
+
+func TestTenantKeys_Shredding(t *testing.T) {
+	acmeKey := sst.TenantKey{ID: "acme-1", Key: bytes.Repeat([]byte{1}, 32)}
+	keys, _ := sst.NewTenantKeys(map[string][]sst.TenantKey{"acme": {acmeKey}})
+	sealed, err := keys.Seal("acme", "acme/hash", secretText)
+	if err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+	// The key of acme is destroyed, the other tenants keep theirs
+	shredded, _ := sst.NewTenantKeys(map[string][]sst.TenantKey{
+		"other": {{ID: "other-1", Key: bytes.Repeat([]byte{2}, 32)}},
+	})
+	if _, err = shredded.Open("acme", "acme/hash", sealed); err == nil {
+		t.Fatal("text of the destroyed key should not be opened")
+	}
+	if _, err = shredded.Seal("acme", "acme/hash", secretText); err != sst.ErrTenantKey {
+		t.Fatalf("expected: %v, result: %v", sst.ErrTenantKey, err)
+	}
+}
+
+func TestTenantKeys_Replace(t *testing.T) {
+	oldKey := sst.TenantKey{ID: "acme-1", Key: bytes.Repeat([]byte{1}, 32)}
+	newKey := sst.TenantKey{ID: "acme-2", Key: bytes.Repeat([]byte{2}, 32)}
+	keys, _ := sst.NewTenantKeys(map[string][]sst.TenantKey{"acme": {oldKey}})
+	storage := sst.NewMemStorage(sst.WithMemTenantKeys(keys))
+	acme := sst.NewTenantStorage(storage, "acme")
+	before, err := acme.Store(secretText, 1, 0)
+	if err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+
+	// The new key is prepended, the storage seals with it and still opens the old texts
+	rotated, _ := sst.NewTenantKeys(map[string][]sst.TenantKey{"acme": {newKey, oldKey}})
+	keys.Replace(rotated)
+	sealed, err := keys.Seal("acme", "acme/hash", secretText)
+	if err != nil || !strings.Contains(sealed, "acme-2") {
+		t.Fatalf("text should be sealed by the new key: %s %v", sealed, err)
+	}
+	if s, err := acme.Get(before.Hash); err != nil || s.SecretText != secretText {
+		t.Fatalf("expected: %s, result: %s %v", secretText, s.SecretText, err)
+	}
+
+	// The old key is removed
+	after, _ := acme.Store(secretText, 1, 0)
+	old, _ := sst.NewTenantKeys(map[string][]sst.TenantKey{"acme": {oldKey}})
+	sealed, _ = old.Seal("acme", "acme/hash", secretText)
+	removed, _ := sst.NewTenantKeys(map[string][]sst.TenantKey{"acme": {newKey}})
+	keys.Replace(removed)
+	if _, err = keys.Open("acme", "acme/hash", sealed); err == nil {
+		t.Fatal("text of the removed key should not be opened")
+	}
+	if s, err := acme.Get(after.Hash); err != nil || s.SecretText != secretText {
+		t.Fatalf("expected: %s, result: %s %v", secretText, s.SecretText, err)
+	}
+}
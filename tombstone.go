@@ -0,0 +1,110 @@
+package secret_server_task
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Tombstone is the record of the consumed or expired secret kept for the retention period after its text
+// is scrubbed, so the audit can tell the hash existed and when it stopped being available
+type Tombstone struct {
+	Hash      string    `json:"hash" xml:"hash"`
+	Owner     string    `json:"owner" xml:"owner"`
+	Tenant    string    `json:"tenant" xml:"tenant"`
+	CreatedAt time.Time `json:"createdAt" xml:"createdAt"`
+	ExpiresAt time.Time `json:"expiresAt" xml:"expiresAt"`
+	// Views is the amount of the served views
+	Views int `json:"views" xml:"views"`
+	// Reason is consumed or expired
+	Reason UnavailableReason `json:"reason" xml:"reason"`
+	// DeletedAt is the time the text was scrubbed: the last view of the consumed secret,
+	// the expired one is scrubbed by the first Get or PurgeExpired after its expiry
+	DeletedAt time.Time `json:"deletedAt" xml:"deletedAt"`
+}
+
+// TombstoneReader is implemented by the storages keeping the tombstones
+type TombstoneReader interface {
+	// Tombstone returns the tombstone of the key.
+	// Returns ErrNotFound if the secret is still stored or no tombstone is kept
+	Tombstone(key string) (Tombstone, error)
+}
+
+// TombstoneReaderOf returns the TombstoneReader of the storage or of its base storage
+func TombstoneReaderOf(st Storage) (TombstoneReader, bool) {
+	if r, ok := st.(TombstoneReader); ok {
+		return r, true
+	}
+	r, ok := Base(st).(TombstoneReader)
+	return r, ok
+}
+
+func newTombstone(s Secret, deletedAt time.Time) Tombstone {
+	unavailable, _ := s.unavailable()
+	return Tombstone{
+		Hash:      s.Hash,
+		Owner:     s.Owner,
+		Tenant:    s.Tenant,
+		CreatedAt: s.CreatedAt,
+		ExpiresAt: s.ExpiresAt,
+		Views:     s.Views,
+		Reason:    unavailable.Unavailable,
+		DeletedAt: deletedAt,
+	}
+}
+
+// WithMemRetention keeps the tombstones of the consumed and expired secrets, the text is zeroed
+// and the tombstone is removed by PurgeExpired after the retention period. Zero means immediate removal
+func WithMemRetention(retention time.Duration) MemOption {
+	return func(st *memStorage) {
+		st.retention = retention
+	}
+}
+
+// bury turns the secret into the tombstone, the caller holds the lock
+func (st *memStorage) bury(m *memSecret) {
+	m.destroy()
+	m.SecretText = ""
+	m.deletedAt = st.clock.Now()
+}
+
+// Tombstone
+func (st *memStorage) Tombstone(key string) (Tombstone, error) {
+	value, ok := st.values.Load(key)
+	if !ok {
+		return Tombstone{}, ErrNotFound
+	}
+	mSecret := value.(*memSecret)
+	mSecret.mu.Lock()
+	defer mSecret.mu.Unlock()
+	if mSecret.deletedAt.IsZero() {
+		return Tombstone{}, ErrNotFound
+	}
+	return newTombstone(mSecret.Secret, mSecret.deletedAt), nil
+}
+
+// Tombstone
+func (st *pgStorage) Tombstone(key string) (Tombstone, error) {
+	var row struct {
+		pgSecret
+		DeletedAt time.Time `db:"deleted_at"`
+	}
+	q := "SELECT " + pgSecretColumns + ", deleted_at FROM secret WHERE id=$1 AND deleted_at IS NOT NULL"
+	err := st.retry(true, func() error {
+		return st.db.Get(&row, q, key)
+	})
+	if err == sql.ErrNoRows {
+		return Tombstone{}, ErrNotFound
+	}
+	if err != nil {
+		return Tombstone{}, &BackendError{Op: "tombstone", Err: err}
+	}
+	return newTombstone(row.ToSecret(), row.DeletedAt), nil
+}
+
+// Tombstone returns the tombstone kept by the shard the key is routed to
+func (st *shardedStorage) Tombstone(key string) (Tombstone, error) {
+	if r, ok := Base(st.route(key).Storage).(TombstoneReader); ok {
+		return r.Tombstone(key)
+	}
+	return Tombstone{}, ErrSecretNotAvailable
+}
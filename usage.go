@@ -0,0 +1,136 @@
+package secret_server_task
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Unwrapper is implemented by the storage wrappers which add the behaviour to the underlying storage
+type Unwrapper interface {
+	Unwrap() Storage
+}
+
+// Base returns the innermost storage, so the capabilities of the backend (Purger, Exporter, ...)
+// stay reachable through the wrappers
+func Base(st Storage) Storage {
+	for {
+		u, ok := st.(Unwrapper)
+		if !ok {
+			return st
+		}
+		st = u.Unwrap()
+	}
+}
+
+// Usage is the consumption of the service by the creator
+type Usage struct {
+	Tenant         string `json:"tenant" xml:"tenant"`
+	Owner          string `json:"owner" xml:"owner"`
+	SecretsCreated int64  `json:"secretsCreated" xml:"secretsCreated"`
+	BytesStored    int64  `json:"bytesStored" xml:"bytesStored"`
+	ViewsServed    int64  `json:"viewsServed" xml:"viewsServed"`
+}
+
+type usageKey struct {
+	tenant string
+	owner  string
+}
+
+// UsageStorage counts the created secrets, stored bytes and served views per tenant and owner.
+// The counters live in memory and start from zero with every process.
+type UsageStorage struct {
+	Storage
+
+	since time.Time
+	mu    sync.Mutex
+	usage map[usageKey]*Usage
+}
+
+// NewUsageStorage wraps the storage with the usage accounting
+func NewUsageStorage(st Storage) *UsageStorage {
+	return &UsageStorage{Storage: st, since: time.Now(), usage: map[usageKey]*Usage{}}
+}
+
+// Since returns the time the counting was started at
+func (st *UsageStorage) Since() time.Time {
+	return st.since
+}
+
+func (st *UsageStorage) Unwrap() Storage {
+	return st.Storage
+}
+
+func (st *UsageStorage) Store(secret string, expireAfterViews, expireAfter int, opts ...SecretOption) (Secret, error) {
+	s, err := st.Storage.Store(secret, expireAfterViews, expireAfter, opts...)
+	if err == nil {
+		st.add(s, func(u *Usage) {
+			u.SecretsCreated++
+			u.BytesStored += int64(len(s.SecretText))
+		})
+	}
+	return s, err
+}
+
+func (st *UsageStorage) Get(key string) (Secret, error) {
+	s, err := st.Storage.Get(key)
+	if err == nil {
+		st.add(s, func(u *Usage) {
+			u.ViewsServed++
+		})
+	}
+	return s, err
+}
+
+// Reveal counts the views served by the two-step reveal
+func (st *UsageStorage) Reveal(key string) (Secret, error) {
+	s, err := RevealSecret(st.Storage, key)
+	if err == nil {
+		st.add(s, func(u *Usage) {
+			u.ViewsServed++
+		})
+	}
+	return s, err
+}
+
+func (st *UsageStorage) add(s Secret, fn func(u *Usage)) {
+	key := usageKey{tenant: s.Tenant, owner: s.Owner}
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	u, ok := st.usage[key]
+	if !ok {
+		u = &Usage{Tenant: s.Tenant, Owner: s.Owner}
+		st.usage[key] = u
+	}
+	fn(u)
+}
+
+// UsageOf returns the counters of the owner in the tenant
+func (st *UsageStorage) UsageOf(tenant, owner string) Usage {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if u, ok := st.usage[usageKey{tenant: tenant, owner: owner}]; ok {
+		return *u
+	}
+	return Usage{Tenant: tenant, Owner: owner}
+}
+
+// Usage returns the snapshot of the counters sorted by tenant and owner
+func (st *UsageStorage) Usage() []Usage {
+	st.mu.Lock()
+	result := make([]Usage, 0, len(st.usage))
+	for _, u := range st.usage {
+		result = append(result, *u)
+	}
+	st.mu.Unlock()
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Tenant != result[j].Tenant {
+			return result[i].Tenant < result[j].Tenant
+		}
+		return result[i].Owner < result[j].Owner
+	})
+	return result
+}
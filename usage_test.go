@@ -0,0 +1,43 @@
+package secret_server_task_test
+
+import (
+	"testing"
+
+	sst "github.com/evsan/secret-server-task"
+)
+
+func TestUsageStorage(t *testing.T) {
+	base := sst.NewMemStorage()
+	usage := sst.NewUsageStorage(base)
+
+	secret, err := sst.NewTenantStorage(usage, "team-a").Store(secretText, remainingViews, expiresDelta, sst.WithOwner("alice"))
+	if err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+	if _, err = usage.Store(secretText, remainingViews, expiresDelta); err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err = sst.NewTenantStorage(usage, "team-a").Get(secret.Hash); err != nil {
+			t.Fatal("error is not expected: ", err)
+		}
+	}
+
+	expected := []sst.Usage{
+		{SecretsCreated: 1, BytesStored: int64(len(secretText))},
+		{Tenant: "team-a", Owner: "alice", SecretsCreated: 1, BytesStored: int64(len(secretText)), ViewsServed: 2},
+	}
+	result := usage.Usage()
+	if len(result) != len(expected) {
+		t.Fatalf("expected: %+v, result: %+v", expected, result)
+	}
+	for i := range expected {
+		if result[i] != expected[i] {
+			t.Fatalf("expected: %+v, result: %+v", expected[i], result[i])
+		}
+	}
+
+	if _, ok := sst.Base(usage).(sst.Purger); !ok {
+		t.Fatal("capabilities of the base storage should be reachable")
+	}
+}
@@ -0,0 +1,180 @@
+package secret_server_task
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// ViewHistoryRetention is how long the recorded views are kept
+const ViewHistoryRetention = 30 * 24 * time.Hour
+
+// maxUserAgent is the maximum length of the recorded User-Agent
+const maxUserAgent = 256
+
+// View is the recorded view of the secret
+type View struct {
+	At time.Time `json:"at" xml:"at" db:"viewed_at"`
+	// RemoteAddr and UserAgent describe the requester, they are empty if the requester info is not recorded
+	RemoteAddr string `json:"remoteAddr,omitempty" xml:"remoteAddr,omitempty" db:"remote_addr"`
+	UserAgent  string `json:"userAgent,omitempty" xml:"userAgent,omitempty" db:"user_agent"`
+}
+
+// ViewHistory is implemented by the storages which keep the history of the views.
+// The history outlives the secret, so the consumption can be reconstructed after the last view.
+// It is removed with the data of the owner by EraseOwner and by PurgeExpired after ViewHistoryRetention
+type ViewHistory interface {
+	// RecordView appends the view to the history of the served secret
+	RecordView(secret Secret, view View) error
+	// Views returns the owner of the secret and its views in the order they were served.
+	// Returns ErrNotFound if no view of the secret was recorded
+	Views(key string) (owner string, views []View, err error)
+}
+
+// ViewHistoryOf returns the view history of the storage or of its base storage
+func ViewHistoryOf(st Storage) (ViewHistory, bool) {
+	if h, ok := st.(ViewHistory); ok {
+		return h, true
+	}
+	h, ok := Base(st).(ViewHistory)
+	return h, ok
+}
+
+// truncate limits the size of the requester info
+func (v View) truncate() View {
+	if len(v.UserAgent) > maxUserAgent {
+		v.UserAgent = v.UserAgent[:maxUserAgent]
+	}
+	return v
+}
+
+// memViews is the history of the secret kept by the in-memory storage
+type memViews struct {
+	owner string
+	views []View
+}
+
+// memHistory is the view history of the in-memory storage
+type memHistory struct {
+	mu      sync.Mutex
+	secrets map[string]*memViews
+}
+
+func (st *memStorage) RecordView(secret Secret, view View) error {
+	st.history.mu.Lock()
+	defer st.history.mu.Unlock()
+	h, ok := st.history.secrets[secret.Hash]
+	if !ok {
+		h = &memViews{owner: secret.Owner}
+		st.history.secrets[secret.Hash] = h
+	}
+	h.views = append(h.views, view.truncate())
+	return nil
+}
+
+func (st *memStorage) Views(key string) (string, []View, error) {
+	st.history.mu.Lock()
+	defer st.history.mu.Unlock()
+	h, ok := st.history.secrets[key]
+	if !ok {
+		return "", nil, ErrNotFound
+	}
+	return h.owner, append([]View(nil), h.views...), nil
+}
+
+// purgeViews removes the views older than the retention period
+func (st *memStorage) purgeViews(now time.Time) {
+	st.history.mu.Lock()
+	defer st.history.mu.Unlock()
+	for key, h := range st.history.secrets {
+		kept := h.views[:0]
+		for _, v := range h.views {
+			if !v.At.Before(now.Add(-ViewHistoryRetention)) {
+				kept = append(kept, v)
+			}
+		}
+		if h.views = kept; len(kept) == 0 {
+			delete(st.history.secrets, key)
+		}
+	}
+}
+
+// eraseViews removes the histories of the owner and returns the amount of the removed views
+func (st *memStorage) eraseViews(owner string) int {
+	st.history.mu.Lock()
+	defer st.history.mu.Unlock()
+	var erased int
+	for key, h := range st.history.secrets {
+		if h.owner == owner {
+			erased += len(h.views)
+			delete(st.history.secrets, key)
+		}
+	}
+	return erased
+}
+
+func (st *pgStorage) RecordView(secret Secret, view View) error {
+	view = view.truncate()
+	q := "INSERT INTO secret_view(id, owner, viewed_at, remote_addr, user_agent) values($1, $2, $3, $4, $5)"
+	return st.retry(false, func() error {
+		_, err := st.db.Exec(q, secret.Hash, secret.Owner, view.At, view.RemoteAddr, view.UserAgent)
+		return err
+	})
+}
+
+func (st *pgStorage) Views(key string) (string, []View, error) {
+	var rows []struct {
+		View
+		Owner string `db:"owner"`
+	}
+	q := "SELECT owner, viewed_at, remote_addr, user_agent FROM secret_view WHERE id=$1 ORDER BY viewed_at, seq"
+	err := st.retry(true, func() error {
+		return st.db.Select(&rows, q, key)
+	})
+	if err != nil {
+		return "", nil, &BackendError{Op: "views", Err: err}
+	}
+	if len(rows) == 0 {
+		return "", nil, ErrNotFound
+	}
+	views := make([]View, len(rows))
+	for i, row := range rows {
+		views[i] = row.View
+	}
+	return rows[0].Owner, views, nil
+}
+
+// RecordView records the view under the storage key of the tenant
+func (st *tenantStorage) RecordView(secret Secret, view View) error {
+	h, ok := Base(st.Storage).(ViewHistory)
+	if !ok {
+		return nil
+	}
+	secret.Hash = tenantKeyPrefix(st.tenant) + secret.Hash
+	return h.RecordView(secret, view)
+}
+
+// Views returns the history of the secret of the tenant
+func (st *tenantStorage) Views(key string) (string, []View, error) {
+	h, ok := Base(st.Storage).(ViewHistory)
+	if !ok || strings.Contains(key, "/") {
+		return "", nil, ErrSecretNotAvailable
+	}
+	return h.Views(tenantKeyPrefix(st.tenant) + key)
+}
+
+// RecordView records the view in the shard the key is routed to
+func (st *shardedStorage) RecordView(secret Secret, view View) error {
+	if h, ok := Base(st.route(secret.Hash).Storage).(ViewHistory); ok {
+		return h.RecordView(secret, view)
+	}
+	return nil
+}
+
+// Views returns the history kept by the shard the key is routed to
+func (st *shardedStorage) Views(key string) (string, []View, error) {
+	if h, ok := Base(st.route(key).Storage).(ViewHistory); ok {
+		return h.Views(key)
+	}
+	return "", nil, ErrSecretNotAvailable
+}
@@ -0,0 +1,96 @@
+package webhook
+
+import (
+	"context"
+	"log"
+	"time"
+
+	sst "github.com/evsan/secret-server-task"
+)
+
+// Dispatcher settings
+const (
+	dispatchBatch = 100
+	// dispatchLease hides the claimed events from the other replicas while they are delivered
+	dispatchLease = time.Minute
+	// maxAttempts is the amount of the deliveries after which the event is abandoned
+	maxAttempts = 10
+)
+
+// Dispatcher delivers the events recorded in the storage outbox, the failed deliveries are retried
+// with the exponential backoff. The dispatchers of all replicas may run at the same time
+type Dispatcher struct {
+	outbox   sst.Outbox
+	notifier *Notifier
+}
+
+// NewDispatcher creates the dispatcher of the outbox. The URLs are validated again by the notifier,
+// so the events of the hosts which are no longer allowed are dropped
+func NewDispatcher(outbox sst.Outbox, n *Notifier) *Dispatcher {
+	return &Dispatcher{outbox: outbox, notifier: n}
+}
+
+// Run delivers the due events every interval until the context is canceled
+func (d *Dispatcher) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		d.Drain()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Drain delivers the batches until there are no more due events and returns the amount of the claimed events
+func (d *Dispatcher) Drain() (int, error) {
+	claimed := 0
+	for {
+		n, err := d.Dispatch()
+		claimed += n
+		// The full batch means there are more due events
+		if err != nil || n < dispatchBatch {
+			return claimed, err
+		}
+	}
+}
+
+// Dispatch delivers one batch of the due events and returns the amount of the claimed events
+func (d *Dispatcher) Dispatch() (int, error) {
+	events, err := d.outbox.ClaimEvents(dispatchBatch, dispatchLease)
+	if err != nil {
+		log.Println("webhook: outbox: ", err)
+		return 0, err
+	}
+	for _, e := range events {
+		if err = d.deliver(e); err != nil {
+			log.Println("webhook: outbox: ", err)
+		}
+	}
+	return len(events), nil
+}
+
+func (d *Dispatcher) deliver(e sst.OutboxEvent) error {
+	err := d.notifier.Validate(e.URL)
+	if err == nil {
+		err = d.notifier.Send(e.URL, Event{Event: e.Event, Hash: e.Hash, RemainingViews: e.RemainingViews, At: e.CreatedAt})
+	}
+	if err == nil || err == ErrHost || err == ErrURL || e.Attempts+1 >= maxAttempts {
+		if err != nil {
+			log.Printf("webhook: outbox: %s of %s is abandoned after %d attempts: %v", e.Event, e.Hash, e.Attempts+1, err)
+		}
+		return d.outbox.CompleteEvent(e.ID)
+	}
+	return d.outbox.RetryEvent(e.ID, time.Now().Add(backoff(e.Attempts)))
+}
+
+// backoff is 10s doubled with every attempt, one hour at most
+func backoff(attempts int) time.Duration {
+	d := 10 * time.Second << uint(attempts)
+	if d > time.Hour || d <= 0 {
+		return time.Hour
+	}
+	return d
+}
@@ -0,0 +1,83 @@
+package webhook_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	sst "github.com/evsan/secret-server-task"
+	"github.com/evsan/secret-server-task/webhook"
+)
+
+// fakeOutbox records the outcome of the claimed events
+type fakeOutbox struct {
+	events    []sst.OutboxEvent
+	completed []int64
+	retried   []int64
+}
+
+func (o *fakeOutbox) ClaimEvents(limit int, lease time.Duration) ([]sst.OutboxEvent, error) {
+	if len(o.events) < limit {
+		limit = len(o.events)
+	}
+	events := o.events[:limit]
+	o.events = o.events[limit:]
+	return events, nil
+}
+
+func (o *fakeOutbox) CompleteEvent(id int64) error {
+	o.completed = append(o.completed, id)
+	return nil
+}
+
+func (o *fakeOutbox) RetryEvent(id int64, at time.Time) error {
+	o.retried = append(o.retried, id)
+	return nil
+}
+
+func TestDispatcher_Dispatch(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer srv.Close()
+
+	outbox := &fakeOutbox{events: []sst.OutboxEvent{
+		{ID: 1, Event: webhook.Viewed, Hash: "a", URL: srv.URL + "/ok"},
+		{ID: 2, Event: webhook.Viewed, Hash: "b", URL: srv.URL + "/fail"},
+		{ID: 3, Event: webhook.Consumed, Hash: "c", URL: srv.URL + "/fail", Attempts: 9},
+		{ID: 4, Event: webhook.Consumed, Hash: "d", URL: "https://other.example.com/"},
+	}}
+
+	n, err := webhook.NewDispatcher(outbox, webhook.New("127.0.0.1")).Dispatch()
+	if err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+	if n != 4 {
+		t.Fatalf("expected: %d, result: %d", 4, n)
+	}
+	// The delivered, the abandoned after the last attempt, and the not allowed events are completed
+	if len(outbox.completed) != 3 || outbox.completed[0] != 1 || outbox.completed[1] != 3 || outbox.completed[2] != 4 {
+		t.Fatalf("expected: %v, result: %v", []int64{1, 3, 4}, outbox.completed)
+	}
+	if len(outbox.retried) != 1 || outbox.retried[0] != 2 {
+		t.Fatalf("expected: %v, result: %v", []int64{2}, outbox.retried)
+	}
+}
+
+func TestDispatcher_Drain(t *testing.T) {
+	outbox := &fakeOutbox{}
+	for i := 0; i < 250; i++ {
+		outbox.events = append(outbox.events, sst.OutboxEvent{ID: int64(i), Event: webhook.Viewed, URL: "https://other.example.com/"})
+	}
+
+	n, err := webhook.NewDispatcher(outbox, webhook.New("127.0.0.1")).Drain()
+	if err != nil {
+		t.Fatal("error is not expected: ", err)
+	}
+	if n != 250 || len(outbox.completed) != 250 {
+		t.Fatalf("expected: %d, result: %d, completed: %d", 250, n, len(outbox.completed))
+	}
+}
@@ -0,0 +1,141 @@
+// Package webhook posts the events of the secrets to the webhook URLs.
+// The destinations chosen by the creators are checked against the allowlist of hosts.
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Errors
+var (
+	ErrURL  = errors.New("webhookUrl should be an absolute http or https URL")
+	ErrHost = errors.New("webhookUrl host is not allowed")
+)
+
+// Events
+const (
+	// Viewed is sent after the secret is viewed and there are views left
+	Viewed = "viewed"
+	// Consumed is sent after the last view of the secret
+	Consumed = "consumed"
+	// ExpiredUnviewed is sent to the creator if the secret expired without being viewed
+	ExpiredUnviewed = "expired_unviewed"
+	// Released is sent to the release URL of the dead man's switch secret after the missed check-in
+	Released = "released"
+)
+
+// Event is the JSON body posted to the webhook URL
+type Event struct {
+	Event          string `json:"event"`
+	Hash           string `json:"hash"`
+	RemainingViews int    `json:"remainingViews"`
+	// URL is the link of the released secret, sent only with the released event
+	URL string `json:"url,omitempty"`
+	// Owner and Tenant are sent only to the server configured webhooks, never to the per-secret ones
+	Owner  string `json:"owner,omitempty"`
+	Tenant string `json:"tenant,omitempty"`
+	// Labels are the metadata of the creator, sent only to the server configured webhooks as well
+	Labels map[string]string `json:"labels,omitempty"`
+	At     time.Time         `json:"at"`
+	// RequestID and Traceparent identify the request which caused the event, they are sent
+	// as the X-Request-Id and traceparent headers, the request id in the body as well
+	RequestID   string `json:"requestId,omitempty"`
+	Traceparent string `json:"-"`
+}
+
+// Notifier posts the events in the background
+type Notifier struct {
+	hosts  map[string]bool
+	client *http.Client
+}
+
+// New creates the notifier. The per-secret URLs may point only to the hosts,
+// "*.example.com" allows the subdomains of example.com
+func New(hosts ...string) *Notifier {
+	n := &Notifier{
+		hosts: map[string]bool{},
+		client: &http.Client{
+			Timeout: 5 * time.Second,
+			// A redirect could lead to the host which is not allowed
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+	for _, h := range hosts {
+		if h = strings.ToLower(strings.TrimSpace(h)); h != "" {
+			n.hosts[h] = true
+		}
+	}
+	return n
+}
+
+// Validate checks that the URL points to the allowed host. The nil notifier allows nothing
+func (n *Notifier) Validate(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.User != nil {
+		return ErrURL
+	}
+	if n == nil {
+		return ErrHost
+	}
+	host := strings.ToLower(u.Hostname())
+	if n.hosts[host] {
+		return nil
+	}
+	for i := strings.IndexByte(host, '.'); i >= 0; i = strings.IndexByte(host, '.') {
+		host = host[i+1:]
+		if n.hosts["*."+host] {
+			return nil
+		}
+	}
+	return ErrHost
+}
+
+// Notify posts the event in the background, the failures are only logged
+func (n *Notifier) Notify(webhookURL string, e Event) {
+	if n == nil || webhookURL == "" {
+		return
+	}
+	go func() {
+		if err := n.Send(webhookURL, e); err != nil {
+			log.Println("webhook:", err)
+		}
+	}()
+}
+
+// Send posts the event and returns the error if it is not accepted with 2xx
+func (n *Notifier) Send(webhookURL string, e Event) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, webhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if e.RequestID != "" {
+		req.Header.Set("X-Request-Id", e.RequestID)
+	}
+	if e.Traceparent != "" {
+		req.Header.Set("traceparent", e.Traceparent)
+	}
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s responded with %d", webhookURL, resp.StatusCode)
+	}
+	return nil
+}